@@ -0,0 +1,180 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/rest"
+)
+
+type apiRequestKey struct {
+	verb     string
+	resource string
+}
+
+// apiRequestCounter counts the requests to the API server sent by a run by verb and resource.
+// The requests sent by the task are also counted by the name of the task registered to the context of the request.
+type apiRequestCounter struct {
+	mu         sync.Mutex
+	total      int
+	counts     map[apiRequestKey]int
+	taskCounts map[string]int
+	taskNames  []string
+}
+
+func newAPIRequestCounter() *apiRequestCounter {
+	return &apiRequestCounter{
+		counts:     map[apiRequestKey]int{},
+		taskCounts: map[string]int{},
+	}
+}
+
+// wrapConfig returns the copy of cfg whose clients count the requests by c.
+func (c *apiRequestCounter) wrapConfig(cfg *rest.Config) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &apiRequestRoundTripper{rt: rt, counter: c}
+	})
+	return cfg
+}
+
+func (c *apiRequestCounter) count(req *http.Request) {
+	verb, resource := apiRequestVerbAndResource(req)
+	taskName, _ := req.Context().Value(apiRequestTaskKey{}).(string)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total++
+	c.counts[apiRequestKey{verb: verb, resource: resource}]++
+	if taskName == "" {
+		return
+	}
+	if _, exists := c.taskCounts[taskName]; !exists {
+		c.taskNames = append(c.taskNames, taskName)
+	}
+	c.taskCounts[taskName]++
+}
+
+// report returns the number of the requests. The requests are sorted by the number in descending order,
+// and the tasks are sorted in the order of the first request.
+func (c *apiRequestCounter) report() *ReportAPIRequests {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := &ReportAPIRequests{Total: c.total}
+	for key, count := range c.counts {
+		report.Requests = append(report.Requests, ReportAPIRequestCount{
+			Verb:     key.verb,
+			Resource: key.resource,
+			Count:    count,
+		})
+	}
+	sort.Slice(report.Requests, func(i, j int) bool {
+		ri, rj := report.Requests[i], report.Requests[j]
+		if ri.Count != rj.Count {
+			return ri.Count > rj.Count
+		}
+		if ri.Resource != rj.Resource {
+			return ri.Resource < rj.Resource
+		}
+		return ri.Verb < rj.Verb
+	})
+	for _, name := range c.taskNames {
+		report.Tasks = append(report.Tasks, ReportTaskAPIRequests{Name: name, Count: c.taskCounts[name]})
+	}
+	return report
+}
+
+// logSummary writes the number of the requests sent by the run.
+func (c *apiRequestCounter) logSummary(logger Logger) {
+	report := c.report()
+	requests := make([]string, 0, len(report.Requests))
+	for _, request := range report.Requests {
+		requests = append(requests, fmt.Sprintf("%s %s=%d", request.Verb, request.Resource, request.Count))
+	}
+	logger.Info("api requests: total=%d ( %s )", report.Total, strings.Join(requests, ", "))
+	if len(report.Tasks) == 0 {
+		return
+	}
+	tasks := make([]string, 0, len(report.Tasks))
+	for _, task := range report.Tasks {
+		tasks = append(tasks, fmt.Sprintf("%s=%d", task.Name, task.Count))
+	}
+	logger.Info("api requests by task: %s", strings.Join(tasks, ", "))
+}
+
+// apiRequestRoundTripper counts the requests before sending them by the wrapped RoundTripper.
+type apiRequestRoundTripper struct {
+	rt      http.RoundTripper
+	counter *apiRequestCounter
+}
+
+func (t *apiRequestRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.counter.count(req)
+	return t.rt.RoundTrip(req)
+}
+
+func (t *apiRequestRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return t.rt
+}
+
+// apiRequestVerbAndResource returns the verb and the resource of the request by the same rule as the authorization of kubernetes
+// ( e.g. list pods, create pods/exec ). The path is used as the resource of the request for non-resource URL ( e.g. /version ).
+func apiRequestVerbAndResource(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	var rest []string
+	switch {
+	case len(parts) > 2 && parts[0] == "api":
+		rest = parts[2:]
+	case len(parts) > 3 && parts[0] == "apis":
+		rest = parts[3:]
+	default:
+		return strings.ToLower(req.Method), req.URL.Path
+	}
+	if len(rest) > 2 && rest[0] == "namespaces" {
+		rest = rest[2:]
+	}
+	resource := rest[0]
+	hasName := len(rest) > 1
+	if len(rest) > 2 {
+		resource += "/" + rest[2]
+	}
+	switch req.Method {
+	case http.MethodGet:
+		switch {
+		case req.URL.Query().Get("watch") == "true":
+			return "watch", resource
+		case hasName:
+			return "get", resource
+		}
+		return "list", resource
+	case http.MethodPost:
+		return "create", resource
+	case http.MethodPut:
+		return "update", resource
+	case http.MethodPatch:
+		return "patch", resource
+	case http.MethodDelete:
+		if hasName {
+			return "delete", resource
+		}
+		return "deletecollection", resource
+	}
+	return strings.ToLower(req.Method), resource
+}
+
+type apiRequestTaskKey struct{}
+
+// withAPIRequestTask registers the name of the task to count the requests sent with the context.
+func withAPIRequestTask(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, apiRequestTaskKey{}, name)
+}
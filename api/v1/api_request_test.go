@@ -0,0 +1,109 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestAPIRequestCounter(t *testing.T) {
+	t.Run("verb and resource", func(t *testing.T) {
+		for _, test := range []struct {
+			method   string
+			url      string
+			verb     string
+			resource string
+		}{
+			{method: "GET", url: "/api/v1/namespaces/default/pods", verb: "list", resource: "pods"},
+			{method: "GET", url: "/api/v1/namespaces/default/pods?watch=true", verb: "watch", resource: "pods"},
+			{method: "GET", url: "/api/v1/namespaces/default/pods/test", verb: "get", resource: "pods"},
+			{method: "POST", url: "/api/v1/namespaces/default/pods/test/exec", verb: "create", resource: "pods/exec"},
+			{method: "POST", url: "/apis/batch/v1/namespaces/default/jobs", verb: "create", resource: "jobs"},
+			{method: "DELETE", url: "/apis/batch/v1/namespaces/default/jobs/test", verb: "delete", resource: "jobs"},
+			{method: "DELETE", url: "/apis/batch/v1/namespaces/default/jobs", verb: "deletecollection", resource: "jobs"},
+			{method: "GET", url: "/api/v1/namespaces/default", verb: "get", resource: "namespaces"},
+			{method: "GET", url: "/version", verb: "get", resource: "/version"},
+		} {
+			req := httptest.NewRequest(test.method, test.url, nil)
+			verb, resource := apiRequestVerbAndResource(req)
+			if verb != test.verb || resource != test.resource {
+				t.Errorf("%s %s: expected %s %s but got %s %s", test.method, test.url, test.verb, test.resource, verb, resource)
+			}
+		}
+	})
+	t.Run("count", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"kind":"PodList","apiVersion":"v1","items":[]}`)
+		}))
+		defer server.Close()
+		counter := newAPIRequestCounter()
+		clientset, err := kubernetes.NewForConfig(counter.wrapConfig(&rest.Config{Host: server.URL}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		if _, err := clientset.CoreV1().Pods("default").List(ctx, metav1.ListOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		taskCtx := withAPIRequestTask(ctx, "build")
+		for i := 0; i < 2; i++ {
+			if _, err := clientset.CoreV1().Pods("default").List(taskCtx, metav1.ListOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected := &ReportAPIRequests{
+			Total:    3,
+			Requests: []ReportAPIRequestCount{{Verb: "list", Resource: "pods", Count: 3}},
+			Tasks:    []ReportTaskAPIRequests{{Name: "build", Count: 2}},
+		}
+		if report := counter.report(); !reflect.DeepEqual(report, expected) {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+		var b bytes.Buffer
+		counter.logSummary(NewLogger(&b, LogLevelInfo))
+		if !strings.Contains(b.String(), "api requests: total=3 ( list pods=3 )") || !strings.Contains(b.String(), "api requests by task: build=2") {
+			t.Fatalf("unexpected summary: %q", b.String())
+		}
+	})
+	t.Run("task", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo))
+		for _, test := range []struct {
+			task     *Task
+			expected string
+		}{
+			{task: &Task{Name: "build"}, expected: "build"},
+			{task: &Task{}, expected: MainStepType},
+			{task: &Task{strategyKey: &StrategyKey{ConcurrentIdx: 1}}, expected: "mainStep shard-1"},
+		} {
+			job := &apiRequestTaskJob{}
+			test.task.job = job
+			if _, err := test.task.Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if job.name != test.expected {
+				t.Fatalf("the requests must be counted by %q but got %q", test.expected, job.name)
+			}
+		}
+	})
+}
+
+// apiRequestTaskJob records the name of the task counting the requests sent by the job.
+type apiRequestTaskJob struct {
+	fakeJob
+	name string
+}
+
+func (j *apiRequestTaskJob) RunWithExecutionHandler(ctx context.Context, _ func(context.Context, []JobExecutor) error, _ func(context.Context, JobExecutor) error) error {
+	j.name, _ = ctx.Value(apiRequestTaskKey{}).(string)
+	return nil
+}
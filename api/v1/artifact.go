@@ -5,42 +5,155 @@ package v1
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultArtifactExportConcurrency = 1
 )
 
+// errArtifactNotFound the artifact path doesn't exist in the container.
+var errArtifactNotFound = errors.New("artifact is not found")
+
+// isNotExistCopyError returns whether copying failed because the source path doesn't exist.
+// The error from the container is returned as the output of tar command, so it is matched by the message.
+func isNotExistCopyError(err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	return strings.Contains(err.Error(), "No such file or directory")
+}
+
 type ArtifactManager struct {
 	nameToLocalDirs  map[string]string
 	nameToLocalFiles map[string]string
-	exports          []ExportArtifact
+	nameToConflicts  map[string]ArtifactConflictPolicy
+	nameToMergedDirs map[string]string
+	nameToCoverages  map[string]struct{}
+	nameToDedups     map[string]*artifactDedup
+	// mergeMu guards nameToMergedDirs and the merged directories, which are reset by every merge.
+	mergeMu           sync.Mutex
+	exports           []ExportArtifact
+	exportConcurrency int
+	runMode           RunMode
+	workDir           string
 }
 
 func NewArtifactManager(exports []ExportArtifact) *ArtifactManager {
 	return &ArtifactManager{
 		nameToLocalDirs:  map[string]string{},
 		nameToLocalFiles: map[string]string{},
+		nameToConflicts:  map[string]ArtifactConflictPolicy{},
+		nameToMergedDirs: map[string]string{},
+		nameToCoverages:  map[string]struct{}{},
+		nameToDedups:     map[string]*artifactDedup{},
 		exports:          exports,
 	}
 }
 
+// SetExportConcurrency set the maximum number of artifacts exported at the same time.
+// If zero or a negative value is specified, artifacts are exported one by one.
+func (m *ArtifactManager) SetExportConcurrency(concurrency int) {
+	m.exportConcurrency = concurrency
+}
+
+func (m *ArtifactManager) SetRunMode(runMode RunMode) {
+	m.runMode = runMode
+}
+
+// SetWorkDir set the directory to create the local directories of artifacts.
+// If empty string is specified, the default directory for temporary files is used.
+func (m *ArtifactManager) SetWorkDir(dir string) {
+	m.workDir = dir
+}
+
+// AddArtifacts registers the local directory for each artifact.
+// The artifact already registered by the other container or task shares the same directory,
+// so the artifacts copied from all containers are resolved by its conflict policy.
 func (m *ArtifactManager) AddArtifacts(artifacts []ArtifactSpec) error {
 	for _, artifact := range artifacts {
-		dir, err := os.MkdirTemp("", "artifact")
+		if _, exists := m.nameToLocalDirs[artifact.Name]; exists {
+			continue
+		}
+		dir, err := os.MkdirTemp(m.workDir, "artifact")
 		if err != nil {
 			return fmt.Errorf("kubetest: failed to create temporary directory for artifact: %w", err)
 		}
 		m.nameToLocalDirs[artifact.Name] = dir
 		m.nameToLocalFiles[artifact.Name] = filepath.Base(artifact.Container.Path)
+		m.nameToConflicts[artifact.Name] = artifact.Conflict
+		if artifact.Deduplicate {
+			m.nameToDedups[artifact.Name] = newArtifactDedup(dir, m.nameToLocalFiles[artifact.Name])
+		}
+	}
+	return nil
+}
+
+// AddExistingArtifact uses the artifact already exists in dir instead of the artifact copied from the container.
+// dir must have the same layout as the exported artifact ( e.g. dir/<container name>/<artifact file> ).
+func (m *ArtifactManager) AddExistingArtifact(artifact ArtifactSpec, dir string) error {
+	file := filepath.Base(artifact.Container.Path)
+	paths, err := filepath.Glob(filepath.Join(dir, "*", file))
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to find existing artifact %s from %s: %w", artifact.Name, dir, err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("kubetest: existing artifact %s is not found in %s", artifact.Name, dir)
 	}
+	m.nameToLocalDirs[artifact.Name] = dir
+	m.nameToLocalFiles[artifact.Name] = file
+	m.nameToConflicts[artifact.Name] = artifact.Conflict
 	return nil
 }
 
+// AddCoverage registers the artifact having the coverage profiles copied from the main containers.
+// The profiles are stored per container, and resolved as a single merged profile.
+func (m *ArtifactManager) AddCoverage(coverage CoverageSpec) error {
+	if err := m.AddArtifacts([]ArtifactSpec{{
+		Name:      coverage.Name,
+		Container: ArtifactContainer{Path: coverage.Path},
+		Conflict:  ArtifactConflictPerContainer,
+	}}); err != nil {
+		return err
+	}
+	m.nameToCoverages[coverage.Name] = struct{}{}
+	return nil
+}
+
+// AddOutputArtifact registers the local directory for the artifact written by kubetest itself instead of the containers.
+// The files are placed in the returned directory directly, so they are exported as is.
+func (m *ArtifactManager) AddOutputArtifact(name string) (string, error) {
+	if dir, exists := m.nameToLocalDirs[name]; exists {
+		return dir, nil
+	}
+	dir, err := os.MkdirTemp(m.workDir, "artifact")
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to create temporary directory for artifact: %w", err)
+	}
+	m.nameToLocalDirs[name] = dir
+	m.nameToLocalFiles[name] = ""
+	return dir, nil
+}
+
 func (m *ArtifactManager) ExportPathByName(name string) (string, error) {
 	dir, exists := m.nameToLocalDirs[name]
 	if !exists {
 		return "", fmt.Errorf("kubetest: failed to find src path to export artifact by %s", name)
 	}
+	if _, exists := m.nameToCoverages[name]; exists {
+		return m.mergeCoverage(name)
+	}
+	if m.nameToConflicts[name] == ArtifactConflictMerge {
+		return m.mergeArtifact(name)
+	}
 	return dir, nil
 }
 
@@ -53,10 +166,30 @@ func (m *ArtifactManager) LocalPathByName(ctx context.Context, name string) (str
 	if !exists {
 		return "", fmt.Errorf("kubetest: failed to find local artitfact file by %s", name)
 	}
-	containerNames, err := filepath.Glob(filepath.Join(dir, "*"))
+	if _, exists := m.nameToCoverages[name]; exists {
+		return m.CoveragePathByName(name)
+	}
+	switch m.nameToConflicts[name] {
+	case ArtifactConflictMerge:
+		mergedDir, err := m.mergeArtifact(name)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(mergedDir, file), nil
+	case ArtifactConflictPerContainer:
+		return dir, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
 	if err != nil {
 		return "", fmt.Errorf("kubetest: couldn't find local path for artifact %s", name)
 	}
+	containerNames := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if filepath.Base(path) == artifactBlobDirName {
+			continue
+		}
+		containerNames = append(containerNames, path)
+	}
 	if len(containerNames) == 0 {
 		return "", fmt.Errorf("kubetest: couldn't find local path for artifact %s", name)
 	}
@@ -84,31 +217,198 @@ func (m *ArtifactManager) LocalPathByNameAndContainerName(name, containerName st
 	return filepath.Join(dir, containerName, file), nil
 }
 
-func (m *ArtifactManager) ExportArtifacts(ctx context.Context) error {
-	for _, export := range m.exports {
-		LoggerFromContext(ctx).Info("export artifact %s", export.Name)
-		src, err := m.ExportPathByName(export.Name)
+// mergeArtifact merges the artifacts copied from all containers into a single directory and returns it.
+// If the same file is copied from multiple containers, returns error instead of overwriting it.
+func (m *ArtifactManager) mergeArtifact(name string) (string, error) {
+	m.mergeMu.Lock()
+	defer m.mergeMu.Unlock()
+	dir := m.nameToLocalDirs[name]
+	file := m.nameToLocalFiles[name]
+	mergedDir, err := m.resetMergedDir(name)
+	if err != nil {
+		return "", err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*", file))
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to find artifact %s to merge: %w", name, err)
+	}
+	for _, path := range paths {
+		if err := mergeCopy(path, filepath.Join(mergedDir, file)); err != nil {
+			return "", fmt.Errorf("kubetest: failed to merge artifact %s: %w", name, err)
+		}
+	}
+	return mergedDir, nil
+}
+
+// CoveragePathByName merges the coverage profiles copied from all containers and returns the path to the merged profile.
+// If no profile is copied ( e.g. all tests failed before writing it ), returns errArtifactNotFound.
+func (m *ArtifactManager) CoveragePathByName(name string) (string, error) {
+	if _, exists := m.nameToCoverages[name]; !exists {
+		return "", fmt.Errorf("kubetest: failed to find coverage by %s", name)
+	}
+	mergedDir, err := m.mergeCoverage(name)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(mergedDir, m.nameToLocalFiles[name])
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("kubetest: %w: coverage profile of %s", errArtifactNotFound, name)
+	}
+	return path, nil
+}
+
+// mergeCoverage merges the coverage profiles copied from all containers into a single profile and returns the directory having it.
+// If no profile is copied, the directory is empty.
+func (m *ArtifactManager) mergeCoverage(name string) (string, error) {
+	m.mergeMu.Lock()
+	defer m.mergeMu.Unlock()
+	dir := m.nameToLocalDirs[name]
+	file := m.nameToLocalFiles[name]
+	mergedDir, err := m.resetMergedDir(name)
+	if err != nil {
+		return "", err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*", file))
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to find coverage profile %s to merge: %w", name, err)
+	}
+	if len(paths) == 0 {
+		return mergedDir, nil
+	}
+	if err := mergeCoverProfiles(paths, filepath.Join(mergedDir, file)); err != nil {
+		return "", fmt.Errorf("kubetest: failed to merge coverage profile %s: %w", name, err)
+	}
+	return mergedDir, nil
+}
+
+// resetMergedDir returns the empty directory to write the merged artifact.
+func (m *ArtifactManager) resetMergedDir(name string) (string, error) {
+	mergedDir, exists := m.nameToMergedDirs[name]
+	if exists {
+		// merge again because the artifacts may be added after the last merge.
+		if err := os.RemoveAll(mergedDir); err != nil {
+			return "", fmt.Errorf("kubetest: failed to remove merged artifact directory %s: %w", mergedDir, err)
+		}
+		if err := os.MkdirAll(mergedDir, 0755); err != nil {
+			return "", fmt.Errorf("kubetest: failed to create merged artifact directory %s: %w", mergedDir, err)
+		}
+	} else {
+		tmpDir, err := os.MkdirTemp(m.workDir, "merged-artifact")
 		if err != nil {
-			return fmt.Errorf("kubetest: failed to get src path to export artifact: %w", err)
+			return "", fmt.Errorf("kubetest: failed to create temporary directory for merged artifact: %w", err)
+		}
+		m.nameToMergedDirs[name] = tmpDir
+		mergedDir = tmpDir
+	}
+	return mergedDir, nil
+}
+
+// mergeCopy copies src to dst. If both src and dst are directory, the contents of src are merged into dst recursively.
+func mergeCopy(src, dst string) error {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return localCopy(src, dst)
+	}
+	if err != nil {
+		return err
+	}
+	if !srcInfo.IsDir() || !dstInfo.IsDir() {
+		return fmt.Errorf("%s is copied from multiple containers", filepath.Base(dst))
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := mergeCopy(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
 		}
-		dst := export.Path
-		if err := os.MkdirAll(dst, 0755); err != nil {
-			return fmt.Errorf("kubetest: failed to create %s directory for export artifact: %w", dst, err)
+	}
+	return nil
+}
+
+func (m *ArtifactManager) ExportArtifacts(ctx context.Context) error {
+	// the artifacts are merged once before exporting them concurrently,
+	// because merging again resets the merged directory while the other export copies from it.
+	srcs := map[string]string{}
+	for _, export := range m.exports {
+		if _, exists := srcs[export.Name]; exists {
+			continue
 		}
-		paths, err := filepath.Glob(filepath.Join(src, "*"))
+		src, err := m.ExportPathByName(export.Name)
 		if err != nil {
 			return fmt.Errorf("kubetest: failed to get src path to export artifact: %w", err)
 		}
-		for _, path := range paths {
-			src := path
-			dst := filepath.Join(dst, filepath.Base(path))
-			LoggerFromContext(ctx).Debug(
-				"export artifact: copy from %s to %s",
-				src, dst,
-			)
-			if err := localCopy(src, dst); err != nil {
-				return err
+		srcs[export.Name] = src
+	}
+	var eg errgroup.Group
+	eg.SetLimit(m.getExportConcurrency())
+	for _, exports := range m.exportGroups() {
+		exports := exports
+		eg.Go(func() error {
+			// exports that have the same destination path are processed sequentially
+			// to avoid racing on the same directory.
+			for _, export := range exports {
+				if err := m.exportArtifact(ctx, export, srcs[export.Name]); err != nil {
+					return err
+				}
 			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+func (m *ArtifactManager) getExportConcurrency() int {
+	if m.exportConcurrency <= 0 {
+		return defaultArtifactExportConcurrency
+	}
+	return m.exportConcurrency
+}
+
+func (m *ArtifactManager) exportGroups() [][]ExportArtifact {
+	groups := [][]ExportArtifact{}
+	pathToGroupIdx := map[string]int{}
+	for _, export := range m.exports {
+		path := filepath.Clean(export.Path)
+		idx, exists := pathToGroupIdx[path]
+		if !exists {
+			idx = len(groups)
+			pathToGroupIdx[path] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], export)
+	}
+	return groups
+}
+
+func (m *ArtifactManager) exportArtifact(ctx context.Context, export ExportArtifact, src string) error {
+	LoggerFromContext(ctx).Info("export artifact %s", export.Name)
+	dst := export.Path
+	if m.runMode == RunModeDryRun {
+		LoggerFromContext(ctx).Debug("export artifact: copy from %s to %s", src, dst)
+		return nil
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return fmt.Errorf("kubetest: failed to create %s directory for export artifact: %w", dst, err)
+	}
+	paths, err := filepath.Glob(filepath.Join(src, "*"))
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to get src path to export artifact: %w", err)
+	}
+	for _, path := range paths {
+		src := path
+		dst := filepath.Join(dst, filepath.Base(path))
+		LoggerFromContext(ctx).Debug(
+			"export artifact: copy from %s to %s",
+			src, dst,
+		)
+		if err := localCopy(src, dst); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -0,0 +1,208 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// artifactBlobDirName the directory in the artifact directory to store the deduplicated artifacts.
+// The artifact of each container is replaced with the relative symbolic link to the file in this directory,
+// so the links are kept valid after the artifact directory is exported.
+const artifactBlobDirName = ".blobs"
+
+// artifactDedup deduplicates the artifacts of the same name copied from multiple containers by the hash of the contents.
+// The first copy of the contents is kept as it is until the same contents are copied from another container,
+// because the artifact copied from only one container doesn't need to be shared.
+type artifactDedup struct {
+	mu   sync.Mutex
+	dir  string
+	file string
+	// hashToPath the path to the artifact copied first for each hash that isn't shared yet.
+	hashToPath map[string]string
+	// hashToBlob the path to the shared copy for each hash.
+	hashToBlob map[string]string
+	// pathToHash the hash of the artifact that isn't shared yet for each path.
+	pathToHash map[string]string
+}
+
+func newArtifactDedup(dir, file string) *artifactDedup {
+	return &artifactDedup{
+		dir:        dir,
+		file:       file,
+		hashToPath: map[string]string{},
+		hashToBlob: map[string]string{},
+		pathToHash: map[string]string{},
+	}
+}
+
+// prepare removes the link created by the last deduplication of path,
+// so copying the artifact again doesn't write it into the shared copy.
+func (d *artifactDedup) prepare(path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// deduplicate replaces the artifact at path with the link to the shared copy if the same contents were copied from another container.
+// Returns whether path was replaced.
+func (d *artifactDedup) deduplicate(path string) (bool, error) {
+	if _, err := os.Lstat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	hash, err := hashArtifact(path)
+	if err != nil {
+		return false, fmt.Errorf("kubetest: failed to compute hash of artifact %s: %w", path, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if oldHash, exists := d.pathToHash[path]; exists {
+		// the artifact was copied again ( e.g. by the other test in the same container ), so the old contents are no longer available.
+		delete(d.pathToHash, path)
+		delete(d.hashToPath, oldHash)
+	}
+	blob, exists := d.hashToBlob[hash]
+	if !exists {
+		firstPath, exists := d.hashToPath[hash]
+		if !exists {
+			d.hashToPath[hash] = path
+			d.pathToHash[path] = hash
+			return false, nil
+		}
+		blob = filepath.Join(d.dir, artifactBlobDirName, hash, d.file)
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return false, fmt.Errorf("kubetest: failed to create directory for deduplicated artifact: %w", err)
+		}
+		if err := os.Rename(firstPath, blob); err != nil {
+			return false, fmt.Errorf("kubetest: failed to move artifact %s to %s: %w", firstPath, blob, err)
+		}
+		if err := linkArtifact(blob, firstPath); err != nil {
+			return false, err
+		}
+		delete(d.hashToPath, hash)
+		delete(d.pathToHash, firstPath)
+		d.hashToBlob[hash] = blob
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return false, fmt.Errorf("kubetest: failed to remove duplicated artifact %s: %w", path, err)
+	}
+	if err := linkArtifact(blob, path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// linkArtifact creates the relative symbolic link to blob at path.
+func linkArtifact(blob, path string) error {
+	target, err := filepath.Rel(filepath.Dir(path), blob)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to get relative path to deduplicated artifact: %w", err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return fmt.Errorf("kubetest: failed to link artifact %s to %s: %w", path, blob, err)
+	}
+	return nil
+}
+
+// hashArtifact returns the sha256 hash of the contents of the file or the directory tree at path.
+// The hash of the directory covers the relative path, the type, the permission and the contents of each entry.
+func hashArtifact(path string) (string, error) {
+	h := sha256.New()
+	if err := filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", filepath.ToSlash(rel), info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", target)
+		case info.Mode().IsRegular():
+			fmt.Fprintf(h, "%d\x00", info.Size())
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PrepareArtifactCopy removes the link to the deduplicated artifact before the artifact of the container is copied again.
+func (m *ArtifactManager) PrepareArtifactCopy(name, containerName string) error {
+	dedup, exists := m.nameToDedups[name]
+	if !exists {
+		return nil
+	}
+	path, err := m.LocalPathByNameAndContainerName(name, containerName)
+	if err != nil {
+		return err
+	}
+	if err := dedup.prepare(path); err != nil {
+		return fmt.Errorf("kubetest: failed to remove deduplicated artifact %s: %w", path, err)
+	}
+	return nil
+}
+
+// DeduplicateArtifact replaces the artifact copied from the container with the link to the shared copy
+// if the identical artifact was already copied from another container.
+// If deduplicate isn't specified for the artifact, do nothing.
+func (m *ArtifactManager) DeduplicateArtifact(ctx context.Context, name, containerName string) error {
+	dedup, exists := m.nameToDedups[name]
+	if !exists {
+		return nil
+	}
+	path, err := m.LocalPathByNameAndContainerName(name, containerName)
+	if err != nil {
+		return err
+	}
+	deduplicated, err := dedup.deduplicate(path)
+	if err != nil {
+		return err
+	}
+	if deduplicated {
+		LoggerFromContext(ctx).Debug("artifact %s of container %s is deduplicated", name, containerName)
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestArtifactManager(t *testing.T) {
+	setupArtifacts := func(t *testing.T, num int, exportDir func(int) string) *ArtifactManager {
+		exports := make([]ExportArtifact, 0, num)
+		artifacts := make([]ArtifactSpec, 0, num)
+		for i := 0; i < num; i++ {
+			name := fmt.Sprintf("artifact%d", i)
+			artifacts = append(artifacts, ArtifactSpec{
+				Name: name,
+				Container: ArtifactContainer{
+					Name: "test",
+					Path: filepath.Join("/", "tmp", name),
+				},
+			})
+			exports = append(exports, ExportArtifact{
+				Name: name,
+				Path: exportDir(i),
+			})
+		}
+		mgr := NewArtifactManager(exports)
+		if err := mgr.AddArtifacts(artifacts); err != nil {
+			t.Fatal(err)
+		}
+		for _, artifact := range artifacts {
+			path, err := mgr.LocalPathByNameAndContainerName(artifact.Name, fmt.Sprintf("%s-container", artifact.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(artifact.Name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return mgr
+	}
+	ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+	t.Run("export concurrently", func(t *testing.T) {
+		dir := t.TempDir()
+		mgr := setupArtifacts(t, 8, func(i int) string {
+			// share the export directory between two artifacts.
+			return filepath.Join(dir, fmt.Sprint(i/2))
+		})
+		mgr.SetExportConcurrency(4)
+		if err := mgr.ExportArtifacts(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 8; i++ {
+			name := fmt.Sprintf("artifact%d", i)
+			path := filepath.Join(dir, fmt.Sprint(i/2), fmt.Sprintf("%s-container", name), name)
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != name {
+				t.Fatalf("failed to export artifact: expected %s but got %s", name, content)
+			}
+		}
+	})
+	t.Run("dry run", func(t *testing.T) {
+		dir := t.TempDir()
+		mgr := setupArtifacts(t, 2, func(i int) string {
+			return filepath.Join(dir, fmt.Sprint(i))
+		})
+		mgr.SetRunMode(RunModeDryRun)
+		mgr.SetExportConcurrency(2)
+		if err := mgr.ExportArtifacts(ctx); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("expected no exported files in dry run mode but got %d", len(entries))
+		}
+	})
+	t.Run("existing artifact", func(t *testing.T) {
+		artifact := ArtifactSpec{
+			Name:      "prepared",
+			Container: ArtifactContainer{Name: "build", Path: "/tmp/bin"},
+		}
+		mgr := NewArtifactManager(nil)
+		dir := t.TempDir()
+		if err := mgr.AddExistingArtifact(artifact, dir); err == nil {
+			t.Fatal("expected error for empty artifact directory")
+		}
+		path := filepath.Join(dir, "build-container", "bin")
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := mgr.AddExistingArtifact(artifact, dir); err != nil {
+			t.Fatal(err)
+		}
+		localPath, err := mgr.LocalPathByName(ctx, artifact.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if localPath != path {
+			t.Fatalf("failed to get local path of existing artifact: expected %s but got %s", path, localPath)
+		}
+	})
+	setupConflictedArtifact := func(t *testing.T, conflict ArtifactConflictPolicy, exportDir string) *ArtifactManager {
+		artifacts := []ArtifactSpec{
+			{Name: "result", Container: ArtifactContainer{Name: "a", Path: "/tmp/result"}, Conflict: conflict},
+			{Name: "result", Container: ArtifactContainer{Name: "b", Path: "/tmp/result"}, Conflict: conflict},
+		}
+		mgr := NewArtifactManager([]ExportArtifact{{Name: "result", Path: exportDir}})
+		if err := mgr.AddArtifacts(artifacts); err != nil {
+			t.Fatal(err)
+		}
+		for _, artifact := range artifacts {
+			path, err := mgr.LocalPathByNameAndContainerName(artifact.Name, artifact.Container.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(path, artifact.Container.Name), []byte(artifact.Container.Name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return mgr
+	}
+	t.Run("merge conflicted artifact", func(t *testing.T) {
+		dir := t.TempDir()
+		mgr := setupConflictedArtifact(t, ArtifactConflictMerge, dir)
+		localPath, err := mgr.LocalPathByName(ctx, "result")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(localPath) != "result" {
+			t.Fatalf("unexpected local path %s", localPath)
+		}
+		if err := mgr.ExportArtifacts(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a", "b"} {
+			content, err := os.ReadFile(filepath.Join(dir, "result", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != name {
+				t.Fatalf("failed to export merged artifact: expected %s but got %s", name, content)
+			}
+		}
+	})
+	t.Run("export merged artifact concurrently", func(t *testing.T) {
+		dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+		mgr := setupConflictedArtifact(t, ArtifactConflictMerge, dirs[0])
+		for _, dir := range dirs[1:] {
+			mgr.exports = append(mgr.exports, ExportArtifact{Name: "result", Path: dir})
+		}
+		mgr.SetExportConcurrency(len(dirs))
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := mgr.LocalPathByName(ctx, "result"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		if err := mgr.ExportArtifacts(ctx); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		for _, dir := range dirs {
+			for _, name := range []string{"a", "b"} {
+				if _, err := os.Stat(filepath.Join(dir, "result", name)); err != nil {
+					t.Fatalf("failed to export merged artifact: %v", err)
+				}
+			}
+		}
+	})
+	t.Run("merge conflicted file", func(t *testing.T) {
+		mgr := setupConflictedArtifact(t, ArtifactConflictMerge, t.TempDir())
+		path, err := mgr.LocalPathByNameAndContainerName("result", "b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "a"), []byte("b"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mgr.LocalPathByName(ctx, "result"); err == nil {
+			t.Fatal("expected error for the same file copied from multiple containers")
+		}
+	})
+	t.Run("per container artifact", func(t *testing.T) {
+		dir := t.TempDir()
+		mgr := setupConflictedArtifact(t, ArtifactConflictPerContainer, dir)
+		if err := mgr.ExportArtifacts(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a", "b"} {
+			content, err := os.ReadFile(filepath.Join(dir, name, "result", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != name {
+				t.Fatalf("failed to export artifact: expected %s but got %s", name, content)
+			}
+		}
+	})
+	t.Run("deduplicate artifact", func(t *testing.T) {
+		mgr := NewArtifactManager([]ExportArtifact{{Name: "result", Path: t.TempDir()}})
+		mgr.SetWorkDir(t.TempDir())
+		if err := mgr.AddArtifacts([]ArtifactSpec{
+			{Name: "result", Container: ArtifactContainer{Name: "test", Path: "/tmp/result"}, Deduplicate: true},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		copyArtifact := func(containerName, content string) {
+			t.Helper()
+			if err := mgr.PrepareArtifactCopy("result", containerName); err != nil {
+				t.Fatal(err)
+			}
+			path, err := mgr.LocalPathByNameAndContainerName("result", containerName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Join(path, "bin"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(path, "bin", "app"), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := mgr.DeduplicateArtifact(ctx, "result", containerName); err != nil {
+				t.Fatal(err)
+			}
+		}
+		copyArtifact("test0-0", "build")
+		copyArtifact("test0-1", "build")
+		copyArtifact("test0-2", "build")
+		copyArtifact("test1-0", "other")
+		// copy again with the different contents after deduplicated.
+		copyArtifact("test0-2", "changed")
+
+		dir := mgr.exports[0].Path
+		if err := mgr.ExportArtifacts(ctx); err != nil {
+			t.Fatal(err)
+		}
+		blobs, err := filepath.Glob(filepath.Join(dir, artifactBlobDirName, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blobs) != 1 {
+			t.Fatalf("identical artifacts must be stored once: %v", blobs)
+		}
+		for _, test := range []struct {
+			containerName string
+			content       string
+			linked        bool
+		}{
+			{containerName: "test0-0", content: "build", linked: true},
+			{containerName: "test0-1", content: "build", linked: true},
+			{containerName: "test0-2", content: "changed"},
+			{containerName: "test1-0", content: "other"},
+		} {
+			path := filepath.Join(dir, test.containerName, "result")
+			info, err := os.Lstat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if linked := info.Mode()&os.ModeSymlink != 0; linked != test.linked {
+				t.Fatalf("%s: expected linked %v but got %v", test.containerName, test.linked, linked)
+			}
+			content, err := os.ReadFile(filepath.Join(path, "bin", "app"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != test.content {
+				t.Fatalf("%s: expected %s but got %s", test.containerName, test.content, content)
+			}
+		}
+		if _, err := mgr.LocalPathByName(ctx, "result"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestValidateArtifactConflict(t *testing.T) {
+	newTemplate := func(conflicts ...ArtifactConflictPolicy) TestJobTemplateSpec {
+		var spec TestJobPodSpec
+		for idx, conflict := range conflicts {
+			name := fmt.Sprintf("test%d", idx)
+			spec.Containers = append(spec.Containers, TestJobContainer{
+				Container: corev1.Container{Name: name, Image: "alpine", Command: []string{"echo"}},
+			})
+			spec.Artifacts = append(spec.Artifacts, ArtifactSpec{
+				Name:      "result",
+				Container: ArtifactContainer{Name: name, Path: "/tmp/result"},
+				Conflict:  conflict,
+			})
+		}
+		return TestJobTemplateSpec{Main: "test0", Spec: spec}
+	}
+	for _, test := range []struct {
+		name      string
+		conflicts []ArtifactConflictPolicy
+		expectErr bool
+	}{
+		{name: "default", conflicts: []ArtifactConflictPolicy{"", ""}, expectErr: true},
+		{name: "error", conflicts: []ArtifactConflictPolicy{ArtifactConflictError, ArtifactConflictError}, expectErr: true},
+		{name: "merge", conflicts: []ArtifactConflictPolicy{ArtifactConflictMerge, ArtifactConflictMerge}},
+		{name: "perContainer", conflicts: []ArtifactConflictPolicy{ArtifactConflictPerContainer, ArtifactConflictPerContainer}},
+		{name: "mismatch", conflicts: []ArtifactConflictPolicy{ArtifactConflictMerge, ArtifactConflictPerContainer}, expectErr: true},
+		{name: "unknown", conflicts: []ArtifactConflictPolicy{"overwrite"}, expectErr: true},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := NewValidator().ValidateTestJobTemplateSpec(newTemplate(test.conflicts...), MainStepType)
+			if test.expectErr && err == nil {
+				t.Fatal("expected error")
+			}
+			if !test.expectErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestArtifactMode(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	srcRoot := t.TempDir()
+	dstRoot := t.TempDir()
+	localDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(srcRoot, "work"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	binPath := filepath.Join(srcRoot, "work", "v1.test")
+	if err := os.WriteFile(binPath, []byte("#!/bin/sh\necho ok\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// copy the executable artifact from the container running prestep to local.
+	srcExec := &localJobExecutor{rootDir: srcRoot, container: corev1.Container{Name: "test"}}
+	if err := srcExec.CopyFrom(ctx, "/work/v1.test", filepath.Join(localDir, "test")); err != nil {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(localDir, "test", "v1.test")
+	assertMode := func(t *testing.T, path string, expected os.FileMode) {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != expected {
+			t.Fatalf("unexpected mode of %s: expected %v but got %v", path, expected, info.Mode().Perm())
+		}
+	}
+	assertMode(t, localPath, 0o755)
+
+	// copy the artifact to the container and mount it.
+	dstExec := &localJobExecutor{rootDir: dstRoot, container: corev1.Container{Name: "test"}}
+	archiveMountPath := filepath.Join("/", "tmp", "artifact-archive", "bin")
+	if err := dstExec.CopyTo(ctx, localPath, archiveMountPath); err != nil {
+		t.Fatal(err)
+	}
+	artifactMgr := NewArtifactManager(nil)
+	artifactMgr.nameToLocalDirs["bin"] = localDir
+	artifactMgr.nameToLocalFiles["bin"] = "v1.test"
+	builder := &TaskBuilder{
+		mgr:     &ResourceManager{artifactMgr: artifactMgr, doneSetup: true},
+		runMode: RunModeLocal,
+	}
+	mount := func(t *testing.T, mode map[string]int32) {
+		t.Helper()
+		taskContainer := &TaskContainer{
+			artifactNameToMountPath:    map[string]string{"bin": archiveMountPath},
+			artifactNameToOrgMountPath: map[string]string{"bin": "/work/bin/v1.test"},
+			artifactNameToMode:         mode,
+		}
+		if err := builder.mountArtifact(ctx, taskContainer, dstExec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mountedPath := filepath.Join(dstRoot, "work", "bin", "v1.test")
+	t.Run("preserve mode", func(t *testing.T) {
+		mount(t, nil)
+		assertMode(t, mountedPath, 0o755)
+		out, err := exec.Command(mountedPath).CombinedOutput()
+		if err != nil {
+			t.Fatalf("mounted artifact must be runnable: %s: %v", out, err)
+		}
+	})
+	t.Run("specified mode", func(t *testing.T) {
+		mount(t, map[string]int32{"bin": 0o700})
+		assertMode(t, mountedPath, 0o700)
+	})
+	t.Run("invalid mode", func(t *testing.T) {
+		mode := int32(01777)
+		v := NewValidator()
+		v.artifactNameMap = map[string]ArtifactSpec{"bin": {Name: "bin"}}
+		if err := v.ValidateArtifactVolumeSource(&ArtifactVolumeSource{Name: "bin", Mode: &mode}); err == nil {
+			t.Fatal("expected error for invalid mode")
+		}
+	})
+}
@@ -0,0 +1,123 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"maps"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// taskContainerCache memoizes TaskContainer built from the same volume mounts and volumes.
+// PreSteps often share the same template except for the command,
+// so the volume rewiring and the path maps of their containers are reused.
+type taskContainerCache struct {
+	mu      sync.Mutex
+	entries map[string]*TaskContainer
+}
+
+func newTaskContainerCache() *taskContainerCache {
+	return &taskContainerCache{
+		entries: map[string]*TaskContainer{},
+	}
+}
+
+// volumesKey returns the hash of volumes. This is calculated once per build and used as a part of the key of each container.
+func (c *taskContainerCache) volumesKey(volumes []TestJobVolume) (string, error) {
+	b, err := json.Marshal(volumes)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to encode volumes: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// containerKey returns the key of the container. TaskContainer depends only on the volume mounts of the container and volumes.
+func (c *taskContainerCache) containerKey(container TestJobContainer, volumesKey string) string {
+	h := sha256.New()
+	writeHashField(h, volumesKey)
+	for _, vm := range container.VolumeMounts {
+		writeHashField(h, vm.Name)
+		writeHashField(h, vm.MountPath)
+		writeHashField(h, vm.SubPath)
+		writeHashField(h, vm.SubPathExpr)
+		writeHashField(h, strconv.FormatBool(vm.ReadOnly))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeHashField writes the length before the value to distinguish ("ab", "c") from ("a", "bc").
+func writeHashField(h hash.Hash, v string) {
+	h.Write([]byte(strconv.Itoa(len(v))))
+	h.Write([]byte(v))
+}
+
+// containerGroup builds TaskContainerGroup by reusing the cached TaskContainer.
+// The mount paths of containers are rewritten for the test volumes ( e.g. repo, artifact ).
+// Returns the number of containers reused from the cache.
+func (c *taskContainerCache) containerGroup(containers []TestJobContainer, volumes []TestJobVolume, volumesKey string) (*TaskContainerGroup, int) {
+	g := &TaskContainerGroup{
+		containerMap: map[string]*TaskContainer{},
+	}
+	var hits int
+	for _, container := range containers {
+		key := c.containerKey(container, volumesKey)
+		c.mu.Lock()
+		cached, exists := c.entries[key]
+		c.mu.Unlock()
+		if exists {
+			g.containerMap[container.Name] = cached.clone(container)
+			hits++
+			continue
+		}
+		taskContainer := newTaskContainer(container, volumes)
+		// the cached entry keeps only the rewritten volume mounts to not share them with the built spec.
+		entry := taskContainer.clone(TestJobContainer{
+			Container: corev1.Container{
+				VolumeMounts: append([]corev1.VolumeMount{}, taskContainer.container.VolumeMounts...),
+			},
+		})
+		c.mu.Lock()
+		c.entries[key] = entry
+		c.mu.Unlock()
+		g.containerMap[container.Name] = taskContainer
+	}
+	return g, hits
+}
+
+// clone creates TaskContainer for container from the cached one.
+// The mount paths of container are rewritten in the same way as the cached one.
+func (c *TaskContainer) clone(container TestJobContainer) *TaskContainer {
+	for idx := range container.VolumeMounts {
+		container.VolumeMounts[idx].MountPath = c.container.VolumeMounts[idx].MountPath
+	}
+	podSpecVolumeMap := make(map[string]corev1.Volume, len(c.podSpecVolumeMap))
+	for k, v := range c.podSpecVolumeMap {
+		podSpecVolumeMap[k] = *v.DeepCopy()
+	}
+	return &TaskContainer{
+		idx:                        c.idx,
+		container:                  container,
+		repoNameToArchiveMountPath: maps.Clone(c.repoNameToArchiveMountPath),
+		repoNameToOrgMountPath:     maps.Clone(c.repoNameToOrgMountPath),
+		tokenNameToMountPath:       maps.Clone(c.tokenNameToMountPath),
+		tokenNameToOrgMountPath:    maps.Clone(c.tokenNameToOrgMountPath),
+		artifactNameToMountPath:    maps.Clone(c.artifactNameToMountPath),
+		artifactNameToOrgMountPath: maps.Clone(c.artifactNameToOrgMountPath),
+		artifactNameToMode:         maps.Clone(c.artifactNameToMode),
+		logOrgMountPaths:           append([]string{}, c.logOrgMountPaths...),
+		reportOrgMountPaths:        append([]string{}, c.reportOrgMountPaths...),
+		gzipLogOrgMountPaths:       append([]string{}, c.gzipLogOrgMountPaths...),
+		gzipReportOrgMountPaths:    append([]string{}, c.gzipReportOrgMountPaths...),
+		podSpecVolumeMap:           podSpecVolumeMap,
+		preInitVolumeMountMap:      maps.Clone(c.preInitVolumeMountMap),
+	}
+}
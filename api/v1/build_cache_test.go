@@ -0,0 +1,122 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func buildCacheTestSpec(containerNum int, command string) TestJobPodSpec {
+	volumes := []TestJobVolume{
+		{Name: "repo", TestJobVolumeSource: TestJobVolumeSource{Repo: &RepositoryVolumeSource{Name: "repo"}}},
+		{Name: "token", TestJobVolumeSource: TestJobVolumeSource{Token: &TokenVolumeSource{Name: "token"}}},
+		{Name: "log", TestJobVolumeSource: TestJobVolumeSource{Log: &LogVolumeSource{}}},
+	}
+	containers := make([]TestJobContainer, 0, containerNum)
+	for i := 0; i < containerNum; i++ {
+		cacheName := fmt.Sprintf("cache%d", i)
+		volumes = append(volumes, TestJobVolume{
+			Name: cacheName,
+			TestJobVolumeSource: TestJobVolumeSource{
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			},
+		})
+		containers = append(containers, TestJobContainer{
+			Container: corev1.Container{
+				Name:    fmt.Sprintf("test%d", i),
+				Image:   "alpine",
+				Command: []string{"sh", "-c", command},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "repo", MountPath: "/work"},
+					{Name: "token", MountPath: "/token"},
+					{Name: "log", MountPath: "/log"},
+					{Name: cacheName, MountPath: "/cache"},
+				},
+			},
+		})
+	}
+	return TestJobPodSpec{
+		Containers: containers,
+		Volumes:    volumes,
+	}
+}
+
+func TestTaskBuilderBuildContextCache(t *testing.T) {
+	var logs bytes.Buffer
+	ctx := WithLogger(context.Background(), NewLogger(&logs, LogLevelDebug))
+	builder := NewTaskBuilder(nil, nil, "default", RunModeDryRun)
+
+	first, err := builder.newBuildContext(ctx, buildCacheTestSpec(3, "echo first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("unexpected cache hit: %s", logs.String())
+	}
+	second, err := builder.newBuildContext(ctx, buildCacheTestSpec(3, "echo second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() == 0 {
+		t.Fatal("expected cache hit log")
+	}
+	for _, buildCtx := range []*TaskBuildContext{first, second} {
+		mounts := buildCtx.spec.Containers[0].VolumeMounts
+		if mounts[0].MountPath != "/tmp/repo-archive/repo" || mounts[2].MountPath != logMountPath || mounts[3].MountPath != "/cache" {
+			t.Fatalf("failed to rewrite mount paths: %v", mounts)
+		}
+		if buildCtx.repoNameToArchiveMountPath("repo") != "/tmp/repo-archive/repo" {
+			t.Fatal("failed to get archive mount path")
+		}
+	}
+	container := second.taskContainer("test0", false)
+	if container.container.Command[2] != "echo second" {
+		t.Fatalf("cached container must be replaced by the built one: %v", container.container.Command)
+	}
+	if container.repoNameToOrgMountPath["repo"] != "/work" {
+		t.Fatalf("unexpected original mount path: %v", container.repoNameToOrgMountPath)
+	}
+	container.repoNameToOrgMountPath["repo"] = "/modified"
+	third, err := builder.newBuildContext(ctx, buildCacheTestSpec(3, "echo third"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.taskContainer("test0", false).repoNameToOrgMountPath["repo"] != "/work" {
+		t.Fatal("cached container must not be modified by the built one")
+	}
+}
+
+func BenchmarkTaskBuilderBuildContext(b *testing.B) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo))
+	newSpecs := func(num int) []TestJobPodSpec {
+		specs := make([]TestJobPodSpec, 0, num)
+		for i := 0; i < num; i++ {
+			specs = append(specs, buildCacheTestSpec(48, fmt.Sprintf("echo %d", i)))
+		}
+		return specs
+	}
+	b.Run("cached", func(b *testing.B) {
+		builder := NewTaskBuilder(nil, nil, "default", RunModeDryRun)
+		specs := newSpecs(b.N)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := builder.newBuildContext(ctx, specs[i]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		specs := newSpecs(b.N)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			builder := NewTaskBuilder(nil, nil, "default", RunModeDryRun)
+			if _, err := builder.newBuildContext(ctx, specs[i]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,78 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// diffChangedPaths returns the paths changed by HEAD of the repository since the merge base with base.
+// The paths are relative to the root of the repository.
+func diffChangedPaths(ctx context.Context, repoDir, base string) ([]string, error) {
+	LoggerFromContext(ctx).Info("compute changed paths: git diff --name-only %s...HEAD", base)
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", base+"...HEAD")
+	cmd.Dir = repoDir
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("kubetest: failed to compute changed paths from %s: %s: %w", base, string(exitErr.Stderr), err)
+		}
+		return nil, fmt.Errorf("kubetest: failed to compute changed paths from %s: %w", base, err)
+	}
+	paths := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, nil
+}
+
+// matchChangedPath returns whether changedPath is matched by pattern.
+// pattern matches the path itself or any of its parent directories.
+func matchChangedPath(pattern, changedPath string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	for p := changedPath; p != "." && p != "/"; p = path.Dir(p) {
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// changeFilter decides whether to skip the steps by the paths changed in the repositories.
+type changeFilter struct {
+	paths []string
+	// enabled whether the changed paths were computed. If not, no steps are skipped.
+	enabled bool
+}
+
+// skip returns true if none of runIfChanged changed.
+func (f *changeFilter) skip(ctx context.Context, stepName string, runIfChanged []string) bool {
+	if !f.unchanged(runIfChanged) {
+		return false
+	}
+	LoggerFromContext(ctx).Info("skip %s because none of runIfChanged changed: %v", stepName, runIfChanged)
+	return true
+}
+
+// unchanged returns true if none of runIfChanged changed. This is the same as skip without logging.
+func (f *changeFilter) unchanged(runIfChanged []string) bool {
+	if !f.enabled || len(runIfChanged) == 0 {
+		return false
+	}
+	for _, pattern := range runIfChanged {
+		for _, changedPath := range f.paths {
+			if matchChangedPath(pattern, changedPath) {
+				return false
+			}
+		}
+	}
+	return true
+}
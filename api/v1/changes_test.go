@@ -0,0 +1,257 @@
+package v1
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// createChangedRepo creates the git repository whose HEAD changes paths from the commit tagged as base.
+func createChangedRepo(t *testing.T, paths ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=kubetest", "-c", "user.email=user@kubetest.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("failed to run git %v: %s: %v", args, string(out), err)
+		}
+	}
+	writeFile := func(name string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString("change\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	writeFile("README.md")
+	git("add", "-A")
+	git("commit", "-q", "-m", "base")
+	git("tag", "base")
+	for _, path := range paths {
+		writeFile(path)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "change")
+	return dir
+}
+
+func TestChangedPaths(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo))
+	t.Run("diff", func(t *testing.T) {
+		dir := createChangedRepo(t, "services/api/main.go", "docs/index.md")
+		paths, err := diffChangedPaths(ctx, dir, "base")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(paths, []string{"docs/index.md", "services/api/main.go"}) {
+			t.Fatalf("unexpected changed paths: %v", paths)
+		}
+		if _, err := diffChangedPaths(ctx, dir, "unknown"); err == nil {
+			t.Fatal("expected error for unknown base")
+		}
+	})
+	t.Run("match", func(t *testing.T) {
+		for _, test := range []struct {
+			pattern string
+			path    string
+			matched bool
+		}{
+			{pattern: "services/api", path: "services/api/main.go", matched: true},
+			{pattern: "services/api/", path: "services/api/main.go", matched: true},
+			{pattern: "services/*", path: "services/api/main.go", matched: true},
+			{pattern: "docs/*.md", path: "docs/index.md", matched: true},
+			{pattern: "services/api/main.go", path: "services/api/main.go", matched: true},
+			{pattern: "services/web", path: "services/api/main.go", matched: false},
+			{pattern: "services/ap", path: "services/api/main.go", matched: false},
+			{pattern: "*.go", path: "services/api/main.go", matched: false},
+		} {
+			if matched := matchChangedPath(test.pattern, test.path); matched != test.matched {
+				t.Errorf("matchChangedPath(%q, %q): expected %v but got %v", test.pattern, test.path, test.matched, matched)
+			}
+		}
+	})
+	t.Run("skip", func(t *testing.T) {
+		changes := &changeFilter{paths: []string{"services/api/main.go"}, enabled: true}
+		if changes.skip(ctx, "step", []string{"services/web", "services/api"}) {
+			t.Fatal("the step must run if any path changed")
+		}
+		if !changes.skip(ctx, "step", []string{"services/web"}) {
+			t.Fatal("the step must be skipped if no path changed")
+		}
+		if changes.skip(ctx, "step", nil) {
+			t.Fatal("the step without runIfChanged must run")
+		}
+		disabled := &changeFilter{}
+		if disabled.skip(ctx, "step", []string{"services/web"}) {
+			t.Fatal("the step must run if the changed paths were not computed")
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		step := MainStep{
+			RunIfChanged: []string{"services/api"},
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"true"}}}},
+				},
+			},
+		}
+		repo := RepositorySpec{Name: "repo", Value: Repository{URL: "https://github.com/goccy/kubetest.git"}}
+		spec := TestJobSpec{Repos: []RepositorySpec{repo}, MainStep: step}
+		if err := NewValidator().ValidateTestJobSpec(spec); err == nil {
+			t.Fatal("expected error for runIfChanged without diffBase")
+		}
+		spec.Repos[0].Value.DiffBase = "origin/main"
+		if err := NewValidator().ValidateTestJobSpec(spec); err != nil {
+			t.Fatal(err)
+		}
+		spec.MainStep.RunIfChanged = []string{"services/["}
+		if err := NewValidator().ValidateTestJobSpec(spec); err == nil {
+			t.Fatal("expected error for invalid pattern")
+		}
+	})
+}
+
+func TestSkipStepsByChangedPaths(t *testing.T) {
+	runner := NewRunner(getConfig(), RunModeLocal)
+	runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+	runner.SetWorkDir(t.TempDir())
+	newTemplate := func(name string) TestJobTemplateSpec {
+		return TestJobTemplateSpec{
+			Spec: TestJobPodSpec{
+				Containers: []TestJobContainer{
+					{Container: corev1.Container{Name: name, Image: "alpine", Command: []string{"true"}}},
+				},
+			},
+		}
+	}
+	run := func(t *testing.T, mainRunIfChanged []string) *Report {
+		t.Helper()
+		report, err := runner.Run(context.Background(), TestJob{
+			ObjectMeta: testjobObjectMeta(),
+			Spec: TestJobSpec{
+				Repos: []RepositorySpec{
+					{
+						Name: "repo",
+						Value: Repository{
+							URL:        "https://github.com/goccy/kubetest.git",
+							ClonedPath: createChangedRepo(t, "services/api/main.go"),
+							DiffBase:   "base",
+						},
+					},
+				},
+				PreSteps: []PreStep{
+					{Name: "build-api", RunIfChanged: []string{"services/api"}, Template: newTemplate("build-api")},
+					{Name: "build-web", RunIfChanged: []string{"services/web"}, Template: newTemplate("build-web")},
+				},
+				MainStep: MainStep{RunIfChanged: mainRunIfChanged, Template: newTemplate("test")},
+				PostSteps: []PostStep{
+					{Name: "upload-web", RunIfChanged: []string{"services/web"}, Template: newTemplate("upload-web")},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	t.Run("changed", func(t *testing.T) {
+		report := run(t, []string{"services/api"})
+		if report.Status != ResultStatusSuccess || report.TotalNum != 1 {
+			t.Fatalf("unexpected report: status %s, total %d", report.Status, report.TotalNum)
+		}
+		expectedPreSteps := []ReportPreStep{
+			{Name: "build-api", Status: ResultStatusSuccess},
+			{Name: "build-web", Status: ResultStatusSkipped},
+		}
+		if !reflect.DeepEqual(report.PreSteps, expectedPreSteps) {
+			t.Fatalf("unexpected presteps: %+v", report.PreSteps)
+		}
+		if !reflect.DeepEqual(report.SkippedSteps, []string{"build-web", "upload-web"}) {
+			t.Fatalf("unexpected skipped steps: %v", report.SkippedSteps)
+		}
+		if len(report.Repositories) != 1 || report.Repositories[0].Name != "repo" || report.Repositories[0].SHA == "" {
+			t.Fatalf("unexpected repositories: %+v", report.Repositories)
+		}
+	})
+	t.Run("not changed", func(t *testing.T) {
+		report := run(t, []string{"services/web"})
+		if report.Status != ResultStatusSkipped || report.TotalNum != 0 {
+			t.Fatalf("skipped mainStep must be reported as skipped: status %s, total %d", report.Status, report.TotalNum)
+		}
+		if !reflect.DeepEqual(report.SkippedSteps, []string{"build-web", MainStepType, "upload-web"}) {
+			t.Fatalf("unexpected skipped steps: %v", report.SkippedSteps)
+		}
+		if CombinedStatus([]*Report{report}) != ResultStatusSuccess {
+			t.Fatal("skipped run must not fail the combined status")
+		}
+	})
+	t.Run("artifact of skipped prestep", func(t *testing.T) {
+		preStep := PreStep{Name: "build-web", RunIfChanged: []string{"services/web"}, Template: newTemplate("build-web")}
+		preStep.Template.Spec.Artifacts = []ArtifactSpec{
+			{Name: "web", Container: ArtifactContainer{Name: "build-web", Path: "/work/web.txt"}},
+		}
+		mainStep := MainStep{Template: newTemplate("test")}
+		mainStep.Template.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "web", MountPath: "/work/web"}}
+		mainStep.Template.Spec.Volumes = []TestJobVolume{
+			{Name: "web", TestJobVolumeSource: TestJobVolumeSource{Artifact: &ArtifactVolumeSource{Name: "web"}}},
+		}
+		newTestJob := func() TestJob {
+			return TestJob{
+				ObjectMeta: testjobObjectMeta(),
+				Spec: TestJobSpec{
+					Repos: []RepositorySpec{
+						{
+							Name: "repo",
+							Value: Repository{
+								URL:        "https://github.com/goccy/kubetest.git",
+								ClonedPath: createChangedRepo(t, "services/api/main.go"),
+								DiffBase:   "base",
+							},
+						},
+					},
+					PreSteps: []PreStep{preStep},
+					MainStep: mainStep,
+				},
+			}
+		}
+		runner := NewRunner(getConfig(), RunModeLocal)
+		runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+		runner.SetWorkDir(t.TempDir())
+		if _, err := runner.Run(context.Background(), newTestJob()); err == nil || !strings.Contains(err.Error(), "created by prestep build-web skipped by runIfChanged") {
+			t.Fatalf("expected error of the missing artifact but got %v", err)
+		}
+		existingDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(existingDir, "build-web"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(existingDir, "build-web", "web.txt"), []byte("web"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		runner.SetExistingArtifactPath("web", existingDir)
+		report, err := runner.Run(context.Background(), newTestJob())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Status != ResultStatusSuccess {
+			t.Fatalf("the existing artifact must be used instead of the skipped prestep: %s", report.Status)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InfraFailureError is returned when the run is stopped by the circuit breaker
+// because the infrastructure failures ( e.g. the pod stays pending, the stream of exec API is reset ) happened repeatedly.
+type InfraFailureError struct {
+	// FailureNum number of the infrastructure failures within the window.
+	FailureNum int
+	// Window window to count the infrastructure failures.
+	Window time.Duration
+	// Reasons distinct reasons of the failures sorted by the name.
+	Reasons []string
+}
+
+func (e *InfraFailureError) Error() string {
+	return fmt.Sprintf(
+		"kubetest: circuit breaker tripped by %d infrastructure failures within %s: %s",
+		e.FailureNum, e.Window, strings.Join(e.Reasons, ", "),
+	)
+}
+
+type infraFailure struct {
+	at     time.Time
+	reason string
+}
+
+// circuitBreaker stops the run when the infrastructure failures happen threshold times within window,
+// so the retries of the tasks, the copies and so on don't make the broken cluster take hours to fail the run.
+// The run is stopped by canceling the context of the run, so the running tasks are canceled and the new tasks are not started.
+type circuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cancel    context.CancelCauseFunc
+	failures  []infraFailure
+	err       *InfraFailureError
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func newCircuitBreaker(threshold int, window time.Duration, cancel context.CancelCauseFunc) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		window:    window,
+		cancel:    cancel,
+		now:       time.Now,
+	}
+}
+
+// record records the infrastructure failure by reason, and trips the breaker if the failures reach the threshold within the window.
+func (b *circuitBreaker) record(ctx context.Context, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return
+	}
+	now := b.now()
+	b.failures = append(b.failures, infraFailure{at: now, reason: reason})
+	recent := b.failures[:0]
+	for _, failure := range b.failures {
+		if now.Sub(failure.at) <= b.window {
+			recent = append(recent, failure)
+		}
+	}
+	b.failures = recent
+	LoggerFromContext(ctx).Warn(
+		"infrastructure failure: %s ( %d/%d within %s )",
+		reason, len(b.failures), b.threshold, b.window,
+	)
+	if len(b.failures) < b.threshold {
+		return
+	}
+	b.err = &InfraFailureError{
+		FailureNum: len(b.failures),
+		Window:     b.window,
+		Reasons:    distinctInfraFailureReasons(b.failures),
+	}
+	LoggerFromContext(ctx).Error("%s. stop the run", b.err)
+	b.cancel(b.err)
+}
+
+// tripped returns the error if the breaker tripped. Otherwise returns nil.
+func (b *circuitBreaker) tripped() *InfraFailureError {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+func distinctInfraFailureReasons(failures []infraFailure) []string {
+	counts := map[string]int{}
+	for _, failure := range failures {
+		counts[failure.reason]++
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%s ( x%d )", reason, count))
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+type circuitBreakerKey struct{}
+
+func withCircuitBreaker(ctx context.Context, breaker *circuitBreaker) context.Context {
+	return context.WithValue(ctx, circuitBreakerKey{}, breaker)
+}
+
+// recordInfraFailure records the infrastructure failure to the circuit breaker if it is registered to the context.
+func recordInfraFailure(ctx context.Context, reason string) {
+	if breaker, _ := ctx.Value(circuitBreakerKey{}).(*circuitBreaker); breaker != nil {
+		breaker.record(ctx, reason)
+	}
+}
@@ -0,0 +1,60 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo))
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	breaker := newCircuitBreaker(3, time.Minute, cancel)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	ctx = withCircuitBreaker(ctx, breaker)
+
+	recordInfraFailure(ctx, "pod stayed pending")
+	now = now.Add(2 * time.Minute)
+	// the failure out of the window is not counted.
+	recordInfraFailure(ctx, "pod stayed pending")
+	recordInfraFailure(ctx, "copy was interrupted")
+	if breaker.tripped() != nil || ctx.Err() != nil {
+		t.Fatal("the breaker must not trip before reaching the threshold within the window")
+	}
+	now = now.Add(30 * time.Second)
+	recordInfraFailure(ctx, "pod stayed pending")
+	infraErr := breaker.tripped()
+	if infraErr == nil {
+		t.Fatal("the breaker must trip")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("the context of the run must be canceled")
+	}
+	var causeErr *InfraFailureError
+	if !errors.As(context.Cause(ctx), &causeErr) {
+		t.Fatalf("unexpected cause: %v", context.Cause(ctx))
+	}
+	if infraErr.FailureNum != 3 {
+		t.Fatalf("unexpected failure num: %d", infraErr.FailureNum)
+	}
+	expected := []string{"copy was interrupted ( x1 )", "pod stayed pending ( x2 )"}
+	if strings.Join(infraErr.Reasons, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected reasons: %v", infraErr.Reasons)
+	}
+	// the failures after tripping are ignored.
+	recordInfraFailure(ctx, "failed to copy artifact")
+	if breaker.tripped().FailureNum != 3 {
+		t.Fatal("the error must not change after tripping")
+	}
+	// without breaker, recording does nothing.
+	recordInfraFailure(context.Background(), "pod stayed pending")
+	var nilBreaker *circuitBreaker
+	if nilBreaker.tripped() != nil {
+		t.Fatal("nil breaker must not trip")
+	}
+}
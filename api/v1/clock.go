@@ -0,0 +1,77 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// clockSkewTolerance skew ignored because the Date header of the API server has the resolution of a second.
+const clockSkewTolerance = 2 * time.Second
+
+// detectClockSkew returns how far the clock of the API server is ahead of the local clock.
+// The time of the API server is read from the Date header of the response to the version request,
+// and compared with the middle of the request to cancel the latency.
+func detectClockSkew(ctx context.Context, cfg *rest.Config) (time.Duration, error) {
+	var (
+		mu   sync.Mutex
+		date string
+	)
+	cfg = rest.CopyConfig(cfg)
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := rt.RoundTrip(req)
+			if err == nil {
+				mu.Lock()
+				date = resp.Header.Get("Date")
+				mu.Unlock()
+			}
+			return resp, err
+		})
+	})
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+	sentAt := time.Now()
+	if err := clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		return 0, fmt.Errorf("kubetest: failed to get the version of the API server: %w", err)
+	}
+	receivedAt := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	if date == "" {
+		return 0, fmt.Errorf("kubetest: the API server doesn't return Date header")
+	}
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return 0, fmt.Errorf("kubetest: invalid Date header %q of the API server: %w", date, err)
+	}
+	// Date header is truncated to the second, so the middle of the second is used.
+	serverTime = serverTime.Add(500 * time.Millisecond)
+	localTime := sentAt.Add(receivedAt.Sub(sentAt) / 2)
+	return serverTime.Sub(localTime.Round(0)), nil
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// clockSkewOffset returns the offset applied to the timestamps of the report for the detected skew.
+// The skew within clockSkewTolerance is ignored.
+func clockSkewOffset(skew time.Duration) time.Duration {
+	if skew > -clockSkewTolerance && skew < clockSkewTolerance {
+		return 0
+	}
+	return skew
+}
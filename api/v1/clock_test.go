@@ -0,0 +1,63 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestClockSkew(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(3*time.Minute).UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major":"1","minor":"30","gitVersion":"v1.30.1"}`)
+	}))
+	defer server.Close()
+
+	skew, err := detectClockSkew(context.Background(), &rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := skew - 3*time.Minute; diff < -time.Second || diff > time.Second {
+		t.Fatalf("unexpected skew: %s", skew)
+	}
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := detectClockSkew(canceledCtx, &rest.Config{Host: server.URL}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("the request must be canceled by the context but got %v", err)
+	}
+	if offset := clockSkewOffset(time.Second); offset != 0 {
+		t.Fatalf("skew within the tolerance must be ignored but got %s", offset)
+	}
+	if offset := clockSkewOffset(-3 * time.Minute); offset != -3*time.Minute {
+		t.Fatalf("unexpected offset: %s", offset)
+	}
+
+	startedAt := time.Now()
+	result := Result{
+		startedAt:      startedAt,
+		firstFailureAt: &metav1.Time{Time: startedAt.Add(time.Second)},
+		taskResult:     &TaskResultGroup{},
+		clockSkew:      3 * time.Minute,
+	}
+	report := result.toReport()
+	if !report.StartedAt.Time.Equal(startedAt.Add(3 * time.Minute)) {
+		t.Fatalf("startedAt must be adjusted: %s", report.StartedAt)
+	}
+	if !report.FirstFailureAt.Time.Equal(startedAt.Add(3*time.Minute + time.Second)) {
+		t.Fatalf("firstFailureAt must be adjusted: %s", report.FirstFailureAt)
+	}
+	if report.ClockSkewMillis != 180000 {
+		t.Fatalf("unexpected clock skew of the report: %d", report.ClockSkewMillis)
+	}
+	if !result.firstFailureAt.Time.Equal(startedAt.Add(time.Second)) {
+		t.Fatal("the result must not be changed by the report")
+	}
+}
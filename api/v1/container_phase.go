@@ -0,0 +1,101 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/goccy/kubejob"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContainerPhase phase of the pod in which the failure originated.
+type ContainerPhase string
+
+const (
+	// ContainerPhaseSetup the init containers and mounting the volumes of kubetest ( e.g. extracting the repository ) before the test.
+	ContainerPhaseSetup ContainerPhase = "setup"
+	// ContainerPhaseMain the command of the test.
+	ContainerPhaseMain ContainerPhase = "main"
+	// ContainerPhaseFinalizer the finalizer container run after the tests.
+	ContainerPhaseFinalizer ContainerPhase = "finalizer"
+)
+
+// ContainerPhaseError error originated in the phase other than the test ( e.g. extracting the repository ),
+// so the failure isn't confused with the failure of the test.
+type ContainerPhaseError struct {
+	Phase     ContainerPhase
+	Container string
+	// Action what kubetest was doing when the error occurred ( e.g. extracting repository 'repo' ). This may be empty.
+	Action string
+	Err    error
+}
+
+func (e *ContainerPhaseError) Error() string {
+	if e.Action == "" {
+		return fmt.Sprintf("%s failed in container '%s': %s", e.Phase, e.Container, e.Err)
+	}
+	return fmt.Sprintf("%s failed while %s in container '%s': %s", e.Phase, e.Action, e.Container, e.Err)
+}
+
+func (e *ContainerPhaseError) Unwrap() error {
+	return e.Err
+}
+
+// setupError returns the error of the setup phase of the container.
+// If err is already classified by the phase, err is returned as it is to keep the detailed action.
+func setupError(container, action string, err error) error {
+	var phaseErr *ContainerPhaseError
+	if errors.As(err, &phaseErr) {
+		return err
+	}
+	return &ContainerPhaseError{Phase: ContainerPhaseSetup, Container: container, Action: action, Err: err}
+}
+
+type finalizerSetupErrorKey struct{}
+
+// withFinalizerSetupError tells the finalizer handler that the finalizer runs in degraded mode
+// because the setup of the pod failed with err. If err is nil, ctx is returned as it is.
+func withFinalizerSetupError(ctx context.Context, err error) context.Context {
+	if err == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, finalizerSetupErrorKey{}, err)
+}
+
+// finalizerSetupErrorFromContext returns the error of the setup if the finalizer runs in degraded mode.
+func finalizerSetupErrorFromContext(ctx context.Context) error {
+	err, _ := ctx.Value(finalizerSetupErrorKey{}).(error)
+	return err
+}
+
+// failedInitContainerError returns the error of the init container failed by the status of the pod of the failed job.
+// If no init container failed, returns nil.
+func failedInitContainerError(failedJob *kubejob.FailedJob) error {
+	if failedJob.Pod == nil {
+		return nil
+	}
+	for _, status := range failedJob.Pod.Status.InitContainerStatuses {
+		terminated := status.State.Terminated
+		if terminated == nil || terminated.ExitCode == 0 {
+			continue
+		}
+		return &ContainerPhaseError{
+			Phase:     ContainerPhaseSetup,
+			Container: status.Name,
+			Action:    "running init container",
+			Err:       fmt.Errorf("exit code %d: %s", terminated.ExitCode, terminatedMessage(terminated)),
+		}
+	}
+	return nil
+}
+
+func terminatedMessage(terminated *corev1.ContainerStateTerminated) string {
+	if terminated.Message != "" {
+		return terminated.Message
+	}
+	return terminated.Reason
+}
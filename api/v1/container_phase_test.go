@@ -0,0 +1,270 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/goccy/kubejob"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// fakeJob runs the mount callback with the fake executors instead of creating the pod.
+type fakeJob struct {
+	spec  batchv1.JobSpec
+	mount func(context.Context, JobExecutor, bool) error
+	execs []JobExecutor
+}
+
+func (j *fakeJob) Spec() batchv1.JobSpec                                   { return j.spec }
+func (j *fakeJob) PreInit(TestJobContainer, PreInitCallback)               {}
+func (j *fakeJob) Mount(cb func(context.Context, JobExecutor, bool) error) { j.mount = cb }
+func (j *fakeJob) RunWithExecutionHandler(ctx context.Context, handler func(context.Context, []JobExecutor) error, _ func(context.Context, JobExecutor) error) error {
+	for _, exec := range j.execs {
+		if err := j.mount(ctx, exec, false); err != nil {
+			return setupError(exec.Container().Name, "mounting volumes", err)
+		}
+	}
+	return handler(ctx, j.execs)
+}
+
+// fakeJobExecutor executor whose PrepareCommand fails with prepareOut.
+type fakeJobExecutor struct {
+	container  corev1.Container
+	prepareOut string
+}
+
+func (e *fakeJobExecutor) Output(context.Context) ([]byte, error)           { return nil, nil }
+func (e *fakeJobExecutor) ExecAsync(context.Context)                        {}
+func (e *fakeJobExecutor) TerminationLog(context.Context, string) error     { return nil }
+func (e *fakeJobExecutor) Stop(context.Context) error                       { return nil }
+func (e *fakeJobExecutor) CopyFrom(context.Context, string, string) error   { return nil }
+func (e *fakeJobExecutor) CopyTo(context.Context, string, string) error     { return nil }
+func (e *fakeJobExecutor) Container() corev1.Container                      { return e.container }
+func (e *fakeJobExecutor) Pod() *corev1.Pod                                 { return nil }
+func (e *fakeJobExecutor) TerminatedReason(context.Context) (string, error) { return "", nil }
+func (e *fakeJobExecutor) PrepareCommand(context.Context, []string) ([]byte, error) {
+	return []byte(e.prepareOut), errors.New("exit status 2")
+}
+
+// failedPrepareExecutor executor whose PrepareCommand fails with out.
+type failedPrepareExecutor struct {
+	JobExecutor
+	out string
+}
+
+func (e *failedPrepareExecutor) PrepareCommand(context.Context, []string) ([]byte, error) {
+	return []byte(e.out), errors.New("exit status 2")
+}
+
+func TestContainerPhase(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	t.Run("setup", func(t *testing.T) {
+		step := MainStep{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{
+						{
+							Container: corev1.Container{
+								Name:         "test",
+								Image:        "alpine",
+								Command:      []string{"true"},
+								VolumeMounts: []corev1.VolumeMount{{Name: "repo", MountPath: "/work/repo"}},
+							},
+						},
+					},
+					Volumes: []TestJobVolume{
+						{Name: "repo", TestJobVolumeSource: TestJobVolumeSource{Repo: &RepositoryVolumeSource{Name: "repo"}}},
+					},
+				},
+			},
+		}
+		testjob := TestJob{
+			ObjectMeta: testjobObjectMeta(),
+			Spec: TestJobSpec{
+				Repos: []RepositorySpec{
+					{
+						Name: "repo",
+						Value: Repository{
+							URL:        "https://github.com/goccy/kubetest.git",
+							ClonedPath: createChangedRepo(t, "main.go"),
+						},
+					},
+				},
+				MainStep: step,
+			},
+		}
+		if err := testjob.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mgr := NewResourceManager(clientset, testjob)
+		mgr.SetWorkDir(t.TempDir())
+		if err := mgr.Setup(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer mgr.Cleanup()
+		task, err := NewTaskBuilder(getConfig(), mgr, "default", RunModeLocal).Build(ctx, &step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		localJob := task.job.(*localJob)
+		container := localJob.Spec().Template.Spec.Containers[0]
+		task.job = &fakeJob{
+			spec:  localJob.Spec(),
+			mount: localJob.mountCallback,
+			execs: []JobExecutor{&fakeJobExecutor{container: container, prepareOut: "tar: invalid archive"}},
+		}
+		result, err := task.Run(ctx)
+		if err != nil {
+			t.Fatalf("setup failure must be reported as the result: %v", err)
+		}
+		results := result.MainTaskResults()
+		if len(results) != 1 {
+			t.Fatalf("unexpected number of results: %d", len(results))
+		}
+		if results[0].Phase != ContainerPhaseSetup || results[0].Status != TaskResultFailure || !results[0].IsInternalError() {
+			t.Fatalf("unexpected result: phase %s, status %s", results[0].Phase, results[0].Status)
+		}
+		var phaseErr *ContainerPhaseError
+		if !errors.As(results[0].Err, &phaseErr) || phaseErr.Container != "test" {
+			t.Fatalf("unexpected error: %v", results[0].Err)
+		}
+		details := (&TaskResultGroup{results: []*TaskResult{result}}).ToReportDetails()
+		if details[0].Phase != ContainerPhaseSetup {
+			t.Fatalf("unexpected phase of the report: %s", details[0].Phase)
+		}
+		expected := "setup failed while extracting repository 'repo' in container 'test': kubetest: failed to mount repository. tar: invalid archive"
+		if !strings.HasPrefix(details[0].Message, expected) {
+			t.Fatalf("unexpected message: %q", details[0].Message)
+		}
+	})
+	t.Run("degraded finalizer", func(t *testing.T) {
+		step := MainStep{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{
+						{
+							Container: corev1.Container{
+								Name:         "test",
+								Image:        "alpine",
+								Command:      []string{"true"},
+								VolumeMounts: []corev1.VolumeMount{{Name: "repo", MountPath: "/work/repo"}},
+							},
+						},
+					},
+					FinalizerContainer: TestJobContainer{
+						Container: corev1.Container{
+							Name:    "finalizer",
+							Image:   "alpine",
+							Command: []string{"echo"},
+							Args:    []string{"release tenant"},
+						},
+					},
+					Volumes: []TestJobVolume{
+						{Name: "repo", TestJobVolumeSource: TestJobVolumeSource{Repo: &RepositoryVolumeSource{Name: "repo"}}},
+					},
+				},
+			},
+		}
+		testjob := TestJob{
+			ObjectMeta: testjobObjectMeta(),
+			Spec: TestJobSpec{
+				Repos: []RepositorySpec{
+					{
+						Name: "repo",
+						Value: Repository{
+							URL:        "https://github.com/goccy/kubetest.git",
+							ClonedPath: createChangedRepo(t, "main.go"),
+						},
+					},
+				},
+				MainStep: step,
+			},
+		}
+		if err := testjob.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mgr := NewResourceManager(clientset, testjob)
+		mgr.SetWorkDir(t.TempDir())
+		if err := mgr.Setup(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer mgr.Cleanup()
+		task, err := NewTaskBuilder(getConfig(), mgr, "default", RunModeLocal).Build(ctx, &step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// mountRepository fails by extracting the archive.
+		localJob := task.job.(*localJob)
+		mount := localJob.mountCallback
+		localJob.mountCallback = func(ctx context.Context, exec JobExecutor, isInitContainer bool) error {
+			return mount(ctx, &failedPrepareExecutor{JobExecutor: exec, out: "tar: invalid archive"}, isInitContainer)
+		}
+		result, err := task.Run(ctx)
+		if err != nil {
+			t.Fatalf("setup failure must be reported as the result: %v", err)
+		}
+		if results := result.MainTaskResults(); len(results) != 1 || results[0].Phase != ContainerPhaseSetup {
+			t.Fatalf("unexpected results: %v", results)
+		}
+		finalizer := result.Finalizer()
+		if finalizer == nil {
+			t.Fatal("finalizer must run even if mounting the repository failed")
+		}
+		if string(finalizer.Out) != "release tenant\n" || finalizer.Err != nil {
+			t.Fatalf("unexpected finalizer result: output %q, error %v", finalizer.Out, finalizer.Err)
+		}
+		reports := (&TaskResultGroup{results: []*TaskResult{result}}).ReportFinalizers()
+		if len(reports) != 1 {
+			t.Fatalf("unexpected number of finalizers: %d", len(reports))
+		}
+		report := reports[0]
+		if !report.Degraded || report.Status != ResultStatusSuccess || report.Output != "release tenant\n" ||
+			!strings.Contains(report.Reason, "extracting repository 'repo' in container 'test'") {
+			t.Fatalf("unexpected finalizer report: %+v", report)
+		}
+	})
+	t.Run("init container", func(t *testing.T) {
+		failedJob := &kubejob.FailedJob{
+			Pod: &corev1.Pod{
+				Status: corev1.PodStatus{
+					InitContainerStatuses: []corev1.ContainerStatus{
+						{Name: "preinit", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}},
+						{Name: "init", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}}},
+					},
+				},
+			},
+		}
+		err := failedInitContainerError(failedJob)
+		if err == nil || err.Error() != "setup failed while running init container in container 'init': exit code 1: Error" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := failedInitContainerError(&kubejob.FailedJob{Pod: &corev1.Pod{}}); err != nil {
+			t.Fatalf("unexpected error without failed init containers: %v", err)
+		}
+	})
+	t.Run("main", func(t *testing.T) {
+		subtask := &SubTask{
+			Name:         "test",
+			exec:         &localJobExecutor{rootDir: t.TempDir(), container: corev1.Container{Name: "test", Command: []string{"false"}}},
+			isMain:       true,
+			copyArtifact: func(context.Context, *SubTask) error { return nil },
+		}
+		result := subtask.Run(ctx)
+		if result.Phase != ContainerPhaseMain || result.phaseMessage() != "" {
+			t.Fatalf("unexpected result: phase %s, message %q", result.Phase, result.phaseMessage())
+		}
+	})
+}
@@ -0,0 +1,83 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CopyRetryPolicy describes how to retry copying files between local and the container
+// when the stream is interrupted by the transient error.
+type CopyRetryPolicy struct {
+	// MaxRetries maximum number of retries. If zero, copying isn't retried.
+	MaxRetries int
+	// Backoff interval before the first retry. The interval is doubled for each retry.
+	Backoff time.Duration
+}
+
+var defaultCopyRetryPolicy = CopyRetryPolicy{
+	MaxRetries: 3,
+	Backoff:    1 * time.Second,
+}
+
+// transientCopyErrorMessages messages of errors caused by resetting the stream of exec API.
+// These errors are often wrapped as string by the remote command, so they are matched by the message.
+var transientCopyErrorMessages = []string{
+	"connection reset by peer",
+	"broken pipe",
+	"stream error",
+	"unexpected EOF",
+	"http2: client connection lost",
+	"use of closed network connection",
+}
+
+// isTransientCopyError returns whether err is the transient error of the stream and copying may succeed by retrying.
+func isTransientCopyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	msg := err.Error()
+	for _, transient := range transientCopyErrorMessages {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryCopy calls copyFn and retries it according to policy while it returns the transient error.
+func retryCopy(ctx context.Context, policy CopyRetryPolicy, target string, copyFn func() error) error {
+	interval := policy.Backoff
+	for retryCount := 0; ; retryCount++ {
+		err := copyFn()
+		if err == nil {
+			return nil
+		}
+		if retryCount >= policy.MaxRetries || !isTransientCopyError(err) {
+			return err
+		}
+		recordInfraFailure(ctx, "copy was interrupted")
+		LoggerFromContext(ctx).Warn(
+			"failed to copy %s: %s. retry to copy %d/%d",
+			target, err, retryCount+1, policy.MaxRetries,
+		)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetryCopy(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+	policy := CopyRetryPolicy{MaxRetries: 2, Backoff: time.Millisecond}
+	t.Run("retry transient error", func(t *testing.T) {
+		var called int
+		if err := retryCopy(ctx, policy, "artifact", func() error {
+			called++
+			if called <= 2 {
+				return fmt.Errorf("failed to copy: %w", io.ErrUnexpectedEOF)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if called != 3 {
+			t.Fatalf("expected 3 calls but got %d", called)
+		}
+	})
+	t.Run("exceed max retries", func(t *testing.T) {
+		var called int
+		if err := retryCopy(ctx, policy, "artifact", func() error {
+			called++
+			return errors.New("read tcp: connection reset by peer")
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+		if called != 3 {
+			t.Fatalf("expected 3 calls but got %d", called)
+		}
+	})
+	t.Run("not retry permanent error", func(t *testing.T) {
+		var called int
+		if err := retryCopy(ctx, policy, "artifact", func() error {
+			called++
+			return errors.New("tar: /work/out: No such file or directory")
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+		if called != 1 {
+			t.Fatalf("expected 1 call but got %d", called)
+		}
+	})
+	t.Run("not retry canceled copy", func(t *testing.T) {
+		var called int
+		if err := retryCopy(ctx, policy, "artifact", func() error {
+			called++
+			return fmt.Errorf("stream error: %w", context.Canceled)
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+		if called != 1 {
+			t.Fatalf("expected 1 call but got %d", called)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const coverModePrefix = "mode: "
+
+// coverProfile the blocks of the Go coverage profiles merged in the order of appearance.
+type coverProfile struct {
+	mode   string
+	blocks []string
+	counts map[string]uint64
+}
+
+func newCoverProfile() *coverProfile {
+	return &coverProfile{counts: map[string]uint64{}}
+}
+
+// add merges the coverage profile read from r.
+// The block is identified by the position and the number of statements ( e.g. pkg/file.go:10.2,12.3 2 ),
+// so the same block reported by multiple profiles ( or by a profile of -coverpkg ) is written once.
+// In count and atomic modes, the counts of the same block are summed.
+// In set mode, the block is covered if any of the profiles covered it.
+func (p *coverProfile) add(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var readMode bool
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !readMode {
+			mode := strings.TrimPrefix(line, coverModePrefix)
+			if mode == line {
+				return fmt.Errorf("mode line is not found")
+			}
+			if p.mode != "" && p.mode != mode {
+				return fmt.Errorf("coverage modes %q and %q are mixed", p.mode, mode)
+			}
+			p.mode = mode
+			readMode = true
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		if idx < 0 {
+			return fmt.Errorf("invalid block %q", line)
+		}
+		block := line[:idx]
+		count, err := strconv.ParseUint(line[idx+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid count of block %q: %w", line, err)
+		}
+		current, exists := p.counts[block]
+		if !exists {
+			p.blocks = append(p.blocks, block)
+		}
+		if p.mode == "set" {
+			if count > 0 {
+				count = 1
+			}
+			if current > count {
+				count = current
+			}
+		} else {
+			count += current
+		}
+		p.counts[block] = count
+	}
+	return scanner.Err()
+}
+
+func (p *coverProfile) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "%s%s\n", coverModePrefix, p.mode); err != nil {
+		return err
+	}
+	for _, block := range p.blocks {
+		if _, err := fmt.Fprintf(bw, "%s %d\n", block, p.counts[block]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// mergeCoverProfiles merges the Go coverage profiles of paths into dst.
+func mergeCoverProfiles(paths []string, dst string) error {
+	profile := newCoverProfile()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = profile.add(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read coverage profile %s: %w", path, err)
+		}
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := profile.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
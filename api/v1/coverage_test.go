@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	writeProfiles := func(t *testing.T, mgr *ArtifactManager, profiles map[string]string) {
+		for containerName, profile := range profiles {
+			path, err := mgr.LocalPathByNameAndContainerName("coverage", containerName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(profile), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	setup := func(t *testing.T) *ArtifactManager {
+		mgr := NewArtifactManager(nil)
+		mgr.SetWorkDir(t.TempDir())
+		if err := mgr.AddCoverage(CoverageSpec{Name: "coverage", Path: "/work/cover.out"}); err != nil {
+			t.Fatal(err)
+		}
+		return mgr
+	}
+	for _, test := range []struct {
+		name     string
+		profiles map[string]string
+		expected string
+	}{
+		{
+			name: "count",
+			profiles: map[string]string{
+				"test0-0": "mode: count\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 0\n",
+				"test0-1": "mode: count\na.go:3.1,4.2 2 3\nb.go:1.1,2.2 1 1\n",
+				// -coverpkg reports the same block multiple times in a profile.
+				"test1-0": "mode: count\na.go:1.1,2.2 1 2\na.go:1.1,2.2 1 1\n",
+			},
+			expected: "mode: count\na.go:1.1,2.2 1 4\na.go:3.1,4.2 2 3\nb.go:1.1,2.2 1 1\n",
+		},
+		{
+			name: "atomic",
+			profiles: map[string]string{
+				"test0-0": "mode: atomic\na.go:1.1,2.2 1 5\na.go:3.1,4.2 2 0\n",
+				"test0-1": "mode: atomic\na.go:1.1,2.2 1 2\na.go:3.1,4.2 2 0\n",
+				"test1-0": "mode: atomic\na.go:1.1,2.2 1 0\nb.go:1.1,2.2 1 7\n",
+			},
+			expected: "mode: atomic\na.go:1.1,2.2 1 7\na.go:3.1,4.2 2 0\nb.go:1.1,2.2 1 7\n",
+		},
+		{
+			name: "set",
+			profiles: map[string]string{
+				"test0-0": "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 0\nc.go:1.1,2.2 1 0\n",
+				"test0-1": "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 0\n",
+				"test1-0": "mode: set\na.go:1.1,2.2 1 0\nb.go:1.1,2.2 1 1\nc.go:1.1,2.2 1 3\n",
+			},
+			expected: "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 2 0\nc.go:1.1,2.2 1 1\nb.go:1.1,2.2 1 1\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mgr := setup(t)
+			writeProfiles(t, mgr, test.profiles)
+			path, err := mgr.CoveragePathByName("coverage")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Base(path) != "cover.out" {
+				t.Fatalf("unexpected merged profile path %s", path)
+			}
+			merged, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(merged) != test.expected {
+				t.Fatalf("unexpected merged profile:\n%s", merged)
+			}
+			exportDir, err := mgr.ExportPathByName("coverage")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exportDir != filepath.Dir(path) {
+				t.Fatalf("the merged profile must be exported but got %s", exportDir)
+			}
+		})
+	}
+	t.Run("mixed modes", func(t *testing.T) {
+		mgr := setup(t)
+		writeProfiles(t, mgr, map[string]string{
+			"test0-0": "mode: set\na.go:1.1,2.2 1 1\n",
+			"test0-1": "mode: atomic\na.go:1.1,2.2 1 1\n",
+		})
+		if _, err := mgr.CoveragePathByName("coverage"); err == nil || !strings.Contains(err.Error(), "are mixed") {
+			t.Fatalf("expected mixed modes error but got %v", err)
+		}
+	})
+	t.Run("not copied", func(t *testing.T) {
+		mgr := setup(t)
+		if _, err := mgr.CoveragePathByName("coverage"); !errors.Is(err, errArtifactNotFound) {
+			t.Fatalf("expected not found error but got %v", err)
+		}
+	})
+}
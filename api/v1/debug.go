@@ -0,0 +1,167 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultDebugHoldDuration = 10 * time.Minute
+
+var (
+	// debugReleaseSignal signal to release all pods held for debugging.
+	debugReleaseSignal os.Signal = syscall.SIGUSR1
+
+	defaultDebugCommand = []string{"sh"}
+)
+
+// debugContainerAdder executor which can add the ephemeral container for debugging to its pod.
+type debugContainerAdder interface {
+	// AddDebugContainer adds the ephemeral container targeting the container of the executor and returns the name of the added container.
+	AddDebugContainer(ctx context.Context, image string, command []string) (string, error)
+}
+
+// debugHolder adds the ephemeral container to the pod of the failed test and holds the pod
+// until the hold duration elapses, kubetest receives debugReleaseSignal or the run ends.
+// Each pod is held once in the background, so the other tests of the pod keep running.
+type debugHolder struct {
+	image     string
+	command   []string
+	duration  time.Duration
+	sigCh     chan os.Signal
+	doneCh    chan struct{}
+	wg        sync.WaitGroup
+	holds     sync.WaitGroup
+	mu        sync.Mutex
+	releaseCh chan struct{}
+	// held channels closed when the holds of the pods end, keyed by namespace/name of the pod.
+	held map[string]chan struct{}
+}
+
+func newDebugHolder(spec DebugSpec) (*debugHolder, error) {
+	duration := defaultDebugHoldDuration
+	if spec.HoldDuration != "" {
+		d, err := time.ParseDuration(spec.HoldDuration)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to parse debug.holdDuration: %w", err)
+		}
+		duration = d
+	}
+	command := spec.Command
+	if len(command) == 0 {
+		command = defaultDebugCommand
+	}
+	return &debugHolder{
+		image:     spec.Image,
+		command:   command,
+		duration:  duration,
+		sigCh:     make(chan os.Signal, 1),
+		doneCh:    make(chan struct{}),
+		releaseCh: make(chan struct{}),
+		held:      map[string]chan struct{}{},
+	}, nil
+}
+
+// start starts watching debugReleaseSignal. The pods held at the time the signal is received are released.
+func (h *debugHolder) start(ctx context.Context) {
+	signal.Notify(h.sigCh, debugReleaseSignal)
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for {
+			select {
+			case sig := <-h.sigCh:
+				LoggerFromContext(ctx).Info("receive %s. release the pods held for debugging", sig)
+				h.releaseAll()
+			case <-h.doneCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops watching the signal and releases all pods held at the end of the run.
+func (h *debugHolder) stop() {
+	signal.Stop(h.sigCh)
+	close(h.doneCh)
+	h.wg.Wait()
+	h.releaseAll()
+	h.holds.Wait()
+}
+
+func (h *debugHolder) releaseAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	close(h.releaseCh)
+	h.releaseCh = make(chan struct{})
+}
+
+// hold starts holding the pod of the failed subtask in the background unless the pod is already held.
+// The ephemeral container is added to the pod and the pod is held until it is released.
+func (h *debugHolder) hold(ctx context.Context, logger Logger, subtask *SubTask) {
+	adder, ok := subtask.exec.(debugContainerAdder)
+	if !ok {
+		return
+	}
+	pod := subtask.exec.Pod()
+	if pod == nil {
+		return
+	}
+	key := pod.Namespace + "/" + pod.Name
+	h.mu.Lock()
+	if _, exists := h.held[key]; exists {
+		h.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	h.held[key] = done
+	released := h.releaseCh
+	h.holds.Add(1)
+	h.mu.Unlock()
+	go func() {
+		defer h.holds.Done()
+		defer close(done)
+		name, err := adder.AddDebugContainer(ctx, h.image, h.command)
+		if err != nil {
+			logger.Warn("failed to add debug container to %s pod: %s", pod.Name, err.Error())
+			return
+		}
+		logger.Error(
+			"%s failed. hold %s pod for debugging up to %s. send %s to kubetest ( pid %d ) to release it",
+			subtask.Name, pod.Name, h.duration, debugReleaseSignal, os.Getpid(),
+		)
+		logger.Info("debug: kubectl exec -it -n %s %s -c %s -- %s", pod.Namespace, pod.Name, name, strings.Join(h.command, " "))
+		timer := time.NewTimer(h.duration)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-released:
+		case <-ctx.Done():
+		}
+		logger.Info("release %s pod held for debugging", pod.Name)
+	}()
+}
+
+// wait waits until the holds of the pods of the executors end, so the pods aren't deleted while they are held.
+func (h *debugHolder) wait(executors []JobExecutor) {
+	for _, exec := range executors {
+		pod := exec.Pod()
+		if pod == nil {
+			continue
+		}
+		h.mu.Lock()
+		done := h.held[pod.Namespace+"/"+pod.Name]
+		h.mu.Unlock()
+		if done != nil {
+			<-done
+		}
+	}
+}
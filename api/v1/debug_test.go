@@ -0,0 +1,141 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goccy/kubejob"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDebugHolder(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-pod"}}
+	clientset := fake.NewSimpleClientset(pod)
+	subtask := &SubTask{
+		Name: "TestA",
+		exec: &kubernetesJobExecutor{
+			exec:      &kubejob.JobExecutor{Container: corev1.Container{Name: "test"}, Pod: pod},
+			podClient: clientset.CoreV1().Pods("default"),
+		},
+	}
+	newHolder := func(t *testing.T, duration string) *debugHolder {
+		t.Helper()
+		holder, err := newDebugHolder(DebugSpec{Enabled: true, Image: "busybox", HoldDuration: duration})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return holder
+	}
+	holder := newHolder(t, "1m")
+	var b bytes.Buffer
+	logger := NewLogger(&b, LogLevelInfo)
+	ctx := WithLogger(context.Background(), logger)
+	holder.start(ctx)
+
+	// hold returns immediately, so the other tests of the pod keep running.
+	holder.hold(ctx, logger, subtask)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		holder.wait([]JobExecutor{subtask.exec})
+	}()
+	waitHeld := func() {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			got, err := clientset.CoreV1().Pods("default").Get(ctx, pod.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Spec.EphemeralContainers) != 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("debug container wasn't added")
+	}
+	waitHeld()
+	// the pod is held only once even if the other tests of the pod fail.
+	holder.hold(ctx, logger, subtask)
+	select {
+	case <-done:
+		t.Fatal("the pod was released before the signal")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// the pod is released by the signal before the hold duration elapses.
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the pod wasn't released by the signal")
+	}
+	holder.stop()
+	got, err := clientset.CoreV1().Pods("default").Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Spec.EphemeralContainers) != 1 {
+		t.Fatalf("expected the debug container added once but got %+v", got.Spec.EphemeralContainers)
+	}
+	debug := got.Spec.EphemeralContainers[0]
+	if debug.Name != "kubetest-debug-0" || debug.Image != "busybox" || debug.TargetContainerName != "test" || strings.Join(debug.Command, " ") != "sh" {
+		t.Fatalf("unexpected debug container: %+v", debug)
+	}
+	if !strings.Contains(b.String(), "TestA failed. hold test-pod pod for debugging up to 1m0s") ||
+		!strings.Contains(b.String(), "kubectl exec -it -n default test-pod -c kubetest-debug-0 -- sh") {
+		t.Fatalf("unexpected log: %q", b.String())
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		holder := newHolder(t, "10ms")
+		holder.start(ctx)
+		defer holder.stop()
+		holder.hold(ctx, logger, subtask)
+		holder.wait([]JobExecutor{subtask.exec})
+		got, err := clientset.CoreV1().Pods("default").Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Spec.EphemeralContainers) != 2 || got.Spec.EphemeralContainers[1].Name != "kubetest-debug-1" {
+			t.Fatalf("unexpected debug containers: %+v", got.Spec.EphemeralContainers)
+		}
+	})
+	t.Run("end of run", func(t *testing.T) {
+		holder := newHolder(t, "1h")
+		holder.start(ctx)
+		holder.hold(ctx, logger, subtask)
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			holder.stop()
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(10 * time.Second):
+			t.Fatal("the held pod wasn't released at the end of the run")
+		}
+		holder.wait([]JobExecutor{subtask.exec})
+	})
+	t.Run("validate", func(t *testing.T) {
+		for _, spec := range []*DebugSpec{
+			{Enabled: true},
+			{Enabled: true, Image: "busybox", HoldDuration: "forever"},
+			{Enabled: true, Image: "busybox", HoldDuration: "-1m"},
+		} {
+			if err := NewValidator().ValidateDebug(spec); err == nil {
+				t.Errorf("expected error for %+v", spec)
+			}
+		}
+		if err := NewValidator().ValidateDebug(&DebugSpec{Image: "busybox", HoldDuration: "forever"}); err != nil {
+			t.Fatalf("disabled debug must not be validated: %v", err)
+		}
+	})
+}
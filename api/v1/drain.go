@@ -0,0 +1,97 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// drainSignals signals to stop the running TestJob and delete the created objects.
+	drainSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+
+	// drainTimeout timeout for deleting the created objects after receiving the signal.
+	// The context of the run is already canceled at that time, so use the independent timeout.
+	drainTimeout = 30 * time.Second
+)
+
+// signalDrainer cancels the context of the run when receiving SIGTERM or SIGINT,
+// and deletes the objects recorded by ObjectRecorder on best-effort basis.
+type signalDrainer struct {
+	clientset kubernetes.Interface
+	recorder  *ObjectRecorder
+	sigCh     chan os.Signal
+	doneCh    chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	received  os.Signal
+
+	// keepRetention time the objects kept by keepResources are skipped.
+	keepRetention time.Duration
+	// dynamicClient deletes the objects of the kinds without the typed client ( e.g. fixtures ).
+	dynamicClient *dynamicObjectClient
+}
+
+func newSignalDrainer(clientset kubernetes.Interface, recorder *ObjectRecorder) *signalDrainer {
+	return &signalDrainer{
+		clientset: clientset,
+		recorder:  recorder,
+		sigCh:     make(chan os.Signal, 1),
+		doneCh:    make(chan struct{}),
+	}
+}
+
+// start starts watching the signals and returns the context canceled by the signal.
+func (d *signalDrainer) start(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	signal.Notify(d.sigCh, drainSignals...)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer cancel()
+		select {
+		case sig := <-d.sigCh:
+			LoggerFromContext(ctx).Warn("receive %s. stop running and delete created objects", sig)
+			d.mu.Lock()
+			d.received = sig
+			d.mu.Unlock()
+		case <-d.doneCh:
+		}
+	}()
+	return ctx
+}
+
+// stop stops watching the signals.
+// If the signal was received, deletes all objects created by the run.
+func (d *signalDrainer) stop(ctx context.Context) {
+	signal.Stop(d.sigCh)
+	close(d.doneCh)
+	d.wg.Wait()
+
+	d.mu.Lock()
+	received := d.received
+	d.mu.Unlock()
+	if received == nil {
+		return
+	}
+	d.deleteObjects(ctx)
+}
+
+func (d *signalDrainer) deleteObjects(ctx context.Context) {
+	logger := LoggerFromContext(ctx)
+	ctx, cancel := context.WithTimeout(WithLogger(context.Background(), logger), drainTimeout)
+	defer cancel()
+
+	deletable, _ := d.recorder.deletableObjects(time.Now(), d.keepRetention)
+	if err := deleteObjects(ctx, d.clientset, d.dynamicClient, deletable); err != nil {
+		logger.Error("%s", err.Error())
+	}
+}
@@ -0,0 +1,51 @@
+package v1
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSignalDrainer(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+	newDrainer := func() (*signalDrainer, *fake.Clientset) {
+		clientset := fake.NewSimpleClientset(&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-job"},
+		})
+		recorder := NewObjectRecorder()
+		recorder.Record(ReportObject{Kind: "Job", Namespace: "default", Name: "test-job"})
+		recorder.Record(ReportObject{Kind: "Pod", Namespace: "default", Name: "test-pod"})
+		return newSignalDrainer(clientset, recorder), clientset
+	}
+	t.Run("drain by signal", func(t *testing.T) {
+		drainer, clientset := newDrainer()
+		runCtx := drainer.start(ctx)
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-runCtx.Done():
+		case <-time.After(10 * time.Second):
+			t.Fatal("context isn't canceled by signal")
+		}
+		drainer.stop(runCtx)
+		if _, err := clientset.BatchV1().Jobs("default").Get(ctx, "test-job", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected job is deleted but got %v", err)
+		}
+	})
+	t.Run("no signal", func(t *testing.T) {
+		drainer, clientset := newDrainer()
+		runCtx := drainer.start(ctx)
+		drainer.stop(runCtx)
+		if _, err := clientset.BatchV1().Jobs("default").Get(ctx, "test-job", metav1.GetOptions{}); err != nil {
+			t.Fatalf("expected job isn't deleted but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// minSecretMaskLength minimum length of the value of the secret registered as the mask.
+// The shorter value ( e.g. 1 or true ) matches the unrelated text and corrupts the log.
+const minSecretMaskLength = 4
+
+// secretKeyRefs returns the keys of secrets referenced by the environment variables of all test containers.
+// If all keys of the secret are referenced by envFrom, nil is set as the keys.
+func secretKeyRefs(testjob TestJob) map[string][]string {
+	refs := map[string][]string{}
+	allKeys := map[string]bool{}
+	for _, envFrom := range testjob.Spec.EnvFrom {
+		if envFrom.SecretRef != nil {
+			allKeys[envFrom.SecretRef.Name] = true
+		}
+	}
+	for _, tmpl := range testjob.templates() {
+		containers := append(append([]TestJobContainer{}, tmpl.Spec.InitContainers...), tmpl.Spec.Containers...)
+		for _, container := range containers {
+			for _, envFrom := range container.EnvFrom {
+				if envFrom.SecretRef != nil {
+					allKeys[envFrom.SecretRef.Name] = true
+				}
+			}
+			for _, env := range container.Env {
+				if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil {
+					continue
+				}
+				ref := env.ValueFrom.SecretKeyRef
+				refs[ref.Name] = append(refs[ref.Name], ref.Key)
+			}
+		}
+	}
+	for name := range allKeys {
+		refs[name] = nil
+	}
+	return refs
+}
+
+// credential credential resolved at the start of the run. It's checked that the value is masked in the log.
+type credential struct {
+	// name describes the credential in the message ( e.g. secret db ).
+	name  string
+	value string
+}
+
+// addSecretMasks registers the values of secrets referenced by the environment variables as masks of the log,
+// and returns the secrets as the credentials to check their masks.
+// Masking is best-effort, so the secret not found or not allowed to read is skipped with the warning,
+// and the values shorter than minSecretMaskLength aren't registered. The secret not allowed to read is returned
+// without the value because it's read by the pods, but the values too short to be masked aren't returned.
+func addSecretMasks(ctx context.Context, clientset kubernetes.Interface, namespace string, refs map[string][]string) ([]credential, error) {
+	logger := LoggerFromContext(ctx)
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var credentials []credential
+	for _, name := range names {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				logger.Warn("secret %s referenced by env is not found", name)
+				continue
+			}
+			if apierrors.IsForbidden(err) {
+				logger.Warn("secret %s referenced by env isn't masked in the log because it isn't allowed to read: %s", name, err)
+				credentials = append(credentials, credential{name: fmt.Sprintf("secret %s", name)})
+				continue
+			}
+			return nil, fmt.Errorf("kubetest: failed to read secret %s referenced by env: %w", name, err)
+		}
+		keys := refs[name]
+		if keys == nil {
+			for key := range secret.Data {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+		}
+		for _, key := range keys {
+			value := strings.TrimSpace(string(secret.Data[key]))
+			if value == "" {
+				continue
+			}
+			if len(value) < minSecretMaskLength {
+				logger.Debug("value of %s in secret %s isn't masked because it is shorter than %d characters", key, name, minSecretMaskLength)
+				continue
+			}
+			logger.AddMask(value)
+			credentials = append(credentials, credential{name: fmt.Sprintf("secret %s key %s", name, key), value: value})
+		}
+	}
+	return credentials, nil
+}
+
+// checkSecretMasks checks that each credential resolved at the start of the run is masked in the log,
+// so a regression of masking never prints the credentials silently.
+// If logger can't report its masks, the credentials can't be checked, so it's also reported.
+// If strict is true, returns error instead of the warning.
+func checkSecretMasks(ctx context.Context, credentials []credential, strict bool) error {
+	if len(credentials) == 0 {
+		return nil
+	}
+	logger := LoggerFromContext(ctx)
+	var unmasked []string
+	for _, credential := range credentials {
+		masked, ok := hasMask(logger, credential.value)
+		if !ok {
+			return reportUnmaskedCredentials(logger, strict, "credentials are configured but the logger can't report its masks")
+		}
+		if !masked {
+			unmasked = append(unmasked, credential.name)
+		}
+	}
+	if len(unmasked) == 0 {
+		return nil
+	}
+	return reportUnmaskedCredentials(logger, strict, fmt.Sprintf("no masks are registered for %s", strings.Join(unmasked, ", ")))
+}
+
+func reportUnmaskedCredentials(logger Logger, strict bool, msg string) error {
+	if strict {
+		return fmt.Errorf("kubetest: %s. the credentials may be printed in the log", msg)
+	}
+	logger.Warn("%s. the credentials may be printed in the log", msg)
+	return nil
+}
@@ -0,0 +1,165 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAddSecretMasks(t *testing.T) {
+	var testjob TestJob
+	testjob.Spec.EnvFrom = []corev1.EnvFromSource{
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "common"}}},
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}}},
+	}
+	testjob.Spec.MainStep.Template.Spec.Containers = []TestJobContainer{
+		{
+			Container: corev1.Container{
+				Name: "test",
+				Env: []corev1.EnvVar{
+					{
+						Name: "PASSWORD",
+						ValueFrom: &corev1.EnvVarSource{
+							SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+								Key:                  "password",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "common"},
+			Data:       map[string][]byte{"API_KEY": []byte("common-secret")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+			Data:       map[string][]byte{"password": []byte("db-secret"), "user": []byte("admin"), "port": []byte("1")},
+		},
+	)
+	clientset.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.GetAction).GetName() != "forbidden" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "forbidden", errors.New("no permission"))
+	})
+	testjob.Spec.EnvFrom = append(testjob.Spec.EnvFrom, corev1.EnvFromSource{
+		SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "forbidden"}},
+	})
+	testjob.Spec.MainStep.Template.Spec.Containers[0].Env = append(testjob.Spec.MainStep.Template.Spec.Containers[0].Env, corev1.EnvVar{
+		Name: "PORT",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+				Key:                  "port",
+			},
+		},
+	})
+	var b bytes.Buffer
+	logger := NewLogger(&b, LogLevelInfo)
+	ctx := WithLogger(context.Background(), logger)
+	credentials, err := addSecretMasks(ctx, clientset, "default", secretKeyRefs(testjob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, credential := range credentials {
+		names = append(names, credential.name)
+	}
+	// the value too short to be masked isn't a credential to check.
+	if strings.Join(names, ",") != "secret common key API_KEY,secret db key password,secret forbidden" {
+		t.Fatalf("unexpected credentials: %v", names)
+	}
+	logger.Info("common-secret db-secret admin 1")
+	out := b.String()
+	if strings.Contains(out, "common-secret") || strings.Contains(out, "db-secret") {
+		t.Fatalf("failed to mask secret values: %s", out)
+	}
+	if !strings.Contains(out, "admin") {
+		t.Fatalf("unreferenced key must not be masked: %s", out)
+	}
+	if !strings.Contains(out, "missing") {
+		t.Fatalf("expected warning for missing secret: %s", out)
+	}
+	if !strings.Contains(out, "secret forbidden referenced by env isn't masked") {
+		t.Fatalf("expected warning for the secret not allowed to read: %s", out)
+	}
+	if !strings.Contains(out, "admin 1") {
+		t.Fatalf("the short value must not be masked: %s", out)
+	}
+}
+
+func TestCheckSecretMasks(t *testing.T) {
+	credentials := []credential{
+		{name: "token github", value: "token-value"},
+		{name: "secret db key password", value: "db-password"},
+	}
+	t.Run("no masks", func(t *testing.T) {
+		var b bytes.Buffer
+		ctx := WithLogger(context.Background(), NewLogger(&b, LogLevelInfo))
+		if err := checkSecretMasks(ctx, credentials, false); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), "no masks are registered for token github, secret db key password") {
+			t.Fatalf("expected warning for empty masks: %s", b.String())
+		}
+		if err := checkSecretMasks(ctx, credentials, true); err == nil {
+			t.Fatal("expected error for empty masks in strict mode")
+		}
+	})
+	t.Run("a credential without mask", func(t *testing.T) {
+		logger := NewLogger(io.Discard, LogLevelInfo)
+		logger.AddMask("token-value")
+		ctx := WithLogger(context.Background(), logger)
+		err := checkSecretMasks(ctx, credentials, true)
+		if err == nil || !strings.Contains(err.Error(), "no masks are registered for secret db key password.") {
+			t.Fatalf("expected error for the credential without mask but got %v", err)
+		}
+	})
+	t.Run("secret not allowed to read", func(t *testing.T) {
+		logger := NewLogger(io.Discard, LogLevelInfo)
+		ctx := WithLogger(context.Background(), logger)
+		if err := checkSecretMasks(ctx, []credential{{name: "secret forbidden"}}, true); err == nil {
+			t.Fatal("expected error for the secret whose value isn't known")
+		}
+	})
+	t.Run("masks registered", func(t *testing.T) {
+		logger := NewLogger(io.Discard, LogLevelInfo)
+		logger.AddMask("token-value")
+		logger.AddMask("db-password")
+		ctx := WithLogger(context.Background(), logger)
+		if err := checkSecretMasks(ctx, credentials, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("logger without masks", func(t *testing.T) {
+		logger := NewLogger(io.Discard, LogLevelInfo)
+		logger.AddMask("token-value")
+		logger.AddMask("db-password")
+		ctx := WithLogger(context.Background(), logger.Group())
+		err := checkSecretMasks(ctx, credentials, true)
+		if err == nil || !strings.Contains(err.Error(), "the logger can't report its masks") {
+			t.Fatalf("strict mode must fail if the masks can't be checked but got %v", err)
+		}
+	})
+	t.Run("no credentials", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo).Group())
+		if err := checkSecretMasks(ctx, nil, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
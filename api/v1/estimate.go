@@ -0,0 +1,206 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ResourceEstimate represents the peak of resources requested by a run at the same time.
+// The steps run in order, so each value is the maximum of the values of the steps.
+// Note that each value may be reached by a different step.
+type ResourceEstimate struct {
+	// MaxPods maximum number of pods running at the same time.
+	MaxPods int `json:"maxPods"`
+	// MaxContainers maximum number of containers running at the same time.
+	MaxContainers int `json:"maxContainers"`
+	// Requests maximum of the sum of resource requests of the pods running at the same time.
+	Requests corev1.ResourceList `json:"requests"`
+	// Limits maximum of the sum of resource limits of the pods running at the same time.
+	Limits corev1.ResourceList `json:"limits"`
+}
+
+// EstimateResources estimates the peak of resources requested by testjob without running anything.
+// If the strategy keys are got dynamically, the number of keys is unknown until running, so use EstimateResourcesWithKeyNum instead.
+func EstimateResources(testjob TestJob) (*ResourceEstimate, error) {
+	strategy := testjob.Spec.MainStep.Strategy
+	if strategy == nil {
+		return EstimateResourcesWithKeyNum(testjob, 0)
+	}
+	if len(dynamicKeySources(strategy.Key.Source)) != 0 {
+		return nil, fmt.Errorf("kubetest: cannot estimate resources because strategy keys are got dynamically. specify the number of keys to estimate")
+	}
+	return EstimateResourcesWithKeyNum(testjob, len(strategy.Key.Source.Static))
+}
+
+// EstimateResourcesWithKeyNum estimates the peak of resources requested by testjob assuming that the strategy has keyNum keys.
+// If the strategy is not specified, keyNum is ignored.
+func EstimateResourcesWithKeyNum(testjob TestJob, keyNum int) (*ResourceEstimate, error) {
+	estimate := &ResourceEstimate{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+	for _, step := range testjob.Spec.PreSteps {
+		pod, err := newPodEstimate(step.Template, 0)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to estimate resources of prestep %s: %w", step.Name, err)
+		}
+		estimate.max(pod)
+	}
+	mainStep := testjob.Spec.MainStep
+	if strategy := mainStep.Strategy; strategy != nil {
+		// the pods to get dynamic keys run at the same time.
+		listing := &ResourceEstimate{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+		for _, source := range dynamicKeySources(strategy.Key.Source) {
+			pod, err := newPodEstimate(source.Template, 0)
+			if err != nil {
+				return nil, fmt.Errorf("kubetest: failed to estimate resources of the pod to get dynamic keys: %w", err)
+			}
+			listing.add(pod)
+		}
+		estimate.max(listing)
+	}
+	mainEstimate, err := estimateMainStep(mainStep, keyNum)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to estimate resources of main step: %w", err)
+	}
+	estimate.max(mainEstimate)
+	for _, step := range testjob.Spec.PostSteps {
+		pod, err := newPodEstimate(step.Template, 0)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to estimate resources of poststep %s: %w", step.Name, err)
+		}
+		estimate.max(pod)
+	}
+	return estimate, nil
+}
+
+// estimateMainStep estimates the pods of main step running at the same time.
+// If strategy.scheduler.maxCPU is specified, the pods are started in order until the sum of their CPU requests reaches it as TaskGroup does.
+func estimateMainStep(step MainStep, keyNum int) (*ResourceEstimate, error) {
+	if step.Strategy == nil {
+		return newPodEstimate(step.Template, 0)
+	}
+	estimate := &ResourceEstimate{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	maxCPU := step.Strategy.Scheduler.MaxCPU
+	for _, podKeyNum := range NewTaskScheduler(step).plannedKeyNums(keyNum) {
+		pod, err := newPodEstimate(step.Template, podKeyNum)
+		if err != nil {
+			return nil, err
+		}
+		if maxCPU != nil && estimate.MaxPods > 0 {
+			total := estimate.Requests.Cpu().DeepCopy()
+			total.Add(*pod.Requests.Cpu())
+			if total.Cmp(*maxCPU) > 0 {
+				break
+			}
+		}
+		estimate.add(pod)
+	}
+	return estimate, nil
+}
+
+// newPodEstimate estimates the resources of a pod created by tmpl.
+// If keyNum is greater than zero, the main container is replicated for each key as TaskBuilder does.
+// Init containers run before the others, so the larger of the sum of containers and each init container is used.
+func newPodEstimate(tmpl TestJobTemplateSpec, keyNum int) (*ResourceEstimate, error) {
+	containers := tmpl.Spec.Containers
+	if keyNum > 0 {
+		mainContainer, err := getMainContainerFromTmpl(tmpl)
+		if err != nil {
+			return nil, err
+		}
+		containers = []TestJobContainer{}
+		for _, container := range tmpl.Spec.Containers {
+			if container.Name != mainContainer.Name {
+				containers = append(containers, container)
+			}
+		}
+		for i := 0; i < keyNum; i++ {
+			containers = append(containers, mainContainer)
+		}
+	}
+	estimate := &ResourceEstimate{
+		MaxPods:       1,
+		MaxContainers: len(containers),
+		Requests:      corev1.ResourceList{},
+		Limits:        corev1.ResourceList{},
+	}
+	for _, container := range containers {
+		addResourceList(estimate.Requests, container.Resources.Requests)
+		addResourceList(estimate.Limits, container.Resources.Limits)
+	}
+	for _, container := range tmpl.Spec.InitContainers {
+		maxResourceList(estimate.Requests, container.Resources.Requests)
+		maxResourceList(estimate.Limits, container.Resources.Limits)
+	}
+	return estimate, nil
+}
+
+// add adds the resources of the pods running at the same time as e.
+func (e *ResourceEstimate) add(other *ResourceEstimate) {
+	e.MaxPods += other.MaxPods
+	e.MaxContainers += other.MaxContainers
+	addResourceList(e.Requests, other.Requests)
+	addResourceList(e.Limits, other.Limits)
+}
+
+// max takes the larger of each value for the pods running at a different time from e.
+func (e *ResourceEstimate) max(other *ResourceEstimate) {
+	if other.MaxPods > e.MaxPods {
+		e.MaxPods = other.MaxPods
+	}
+	if other.MaxContainers > e.MaxContainers {
+		e.MaxContainers = other.MaxContainers
+	}
+	maxResourceList(e.Requests, other.Requests)
+	maxResourceList(e.Limits, other.Limits)
+}
+
+// ExceededQuota returns the names of hard limits of ResourceQuota exceeded by the estimate in sorted order.
+// pods, requests.<resource>, limits.<resource> and <resource> ( same as requests.<resource> ) are compared, and the others are ignored.
+func (e *ResourceEstimate) ExceededQuota(hard corev1.ResourceList) []string {
+	exceeded := []string{}
+	for name, limit := range hard {
+		var used resource.Quantity
+		switch {
+		case name == corev1.ResourcePods:
+			used = *resource.NewQuantity(int64(e.MaxPods), resource.DecimalSI)
+		case strings.HasPrefix(string(name), "requests."):
+			used = e.Requests[corev1.ResourceName(strings.TrimPrefix(string(name), "requests."))]
+		case strings.HasPrefix(string(name), "limits."):
+			used = e.Limits[corev1.ResourceName(strings.TrimPrefix(string(name), "limits."))]
+		case name == corev1.ResourceCPU || name == corev1.ResourceMemory || name == corev1.ResourceEphemeralStorage:
+			used = e.Requests[name]
+		default:
+			continue
+		}
+		if used.Cmp(limit) > 0 {
+			exceeded = append(exceeded, string(name))
+		}
+	}
+	sort.Strings(exceeded)
+	return exceeded
+}
+
+func addResourceList(dst, src corev1.ResourceList) {
+	for name, quantity := range src {
+		total := dst[name]
+		total.Add(quantity)
+		dst[name] = total
+	}
+}
+
+func maxResourceList(dst, src corev1.ResourceList) {
+	for name, quantity := range src {
+		if current, exists := dst[name]; !exists || quantity.Cmp(current) > 0 {
+			dst[name] = quantity.DeepCopy()
+		}
+	}
+}
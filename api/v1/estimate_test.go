@@ -0,0 +1,188 @@
+package v1
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestEstimateResources(t *testing.T) {
+	newTestJob := func(scheduler Scheduler) TestJob {
+		return TestJob{
+			Spec: TestJobSpec{
+				PreSteps: []PreStep{
+					{
+						Name: "build",
+						Template: TestJobTemplateSpec{
+							Spec: TestJobPodSpec{
+								Containers: []TestJobContainer{
+									{
+										Container: corev1.Container{
+											Name: "build",
+											Resources: corev1.ResourceRequirements{
+												Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				MainStep: MainStep{
+					Strategy: &Strategy{
+						Key: StrategyKeySpec{
+							Env:    "TEST",
+							Source: StrategyKeySource{Static: []string{"a", "b", "c", "d", "e", "f", "g"}},
+						},
+						Scheduler: scheduler,
+					},
+					Template: TestJobTemplateSpec{
+						Main: "test",
+						Spec: TestJobPodSpec{
+							Containers: []TestJobContainer{
+								{
+									Container: corev1.Container{
+										Name: "test",
+										Resources: corev1.ResourceRequirements{
+											Requests: corev1.ResourceList{
+												corev1.ResourceCPU:    resource.MustParse("1"),
+												corev1.ResourceMemory: resource.MustParse("1Gi"),
+											},
+											Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+										},
+									},
+								},
+								{
+									Container: corev1.Container{
+										Name: "db",
+										Resources: corev1.ResourceRequirements{
+											Requests: corev1.ResourceList{
+												corev1.ResourceCPU:    resource.MustParse("500m"),
+												corev1.ResourceMemory: resource.MustParse("512Mi"),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	assertEstimate := func(t *testing.T, estimate *ResourceEstimate, pods, containers int, cpu, memory, cpuLimit string) {
+		t.Helper()
+		if estimate.MaxPods != pods {
+			t.Fatalf("unexpected max pods: expected %d but got %d", pods, estimate.MaxPods)
+		}
+		if estimate.MaxContainers != containers {
+			t.Fatalf("unexpected max containers: expected %d but got %d", containers, estimate.MaxContainers)
+		}
+		if q := resource.MustParse(cpu); estimate.Requests.Cpu().Cmp(q) != 0 {
+			t.Fatalf("unexpected cpu requests: expected %s but got %s", cpu, estimate.Requests.Cpu())
+		}
+		if q := resource.MustParse(memory); estimate.Requests.Memory().Cmp(q) != 0 {
+			t.Fatalf("unexpected memory requests: expected %s but got %s", memory, estimate.Requests.Memory())
+		}
+		if q := resource.MustParse(cpuLimit); estimate.Limits.Cpu().Cmp(q) != 0 {
+			t.Fatalf("unexpected cpu limits: expected %s but got %s", cpuLimit, estimate.Limits.Cpu())
+		}
+	}
+	t.Run("maxContainersPerPod", func(t *testing.T) {
+		estimate, err := EstimateResources(newTestJob(Scheduler{MaxContainersPerPod: 3, MaxConcurrentNumPerPod: 3}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// pods have 3, 3 and 1 keys with a sidecar.
+		assertEstimate(t, estimate, 3, 10, "8500m", "8704Mi", "14")
+	})
+	t.Run("maxPodNum", func(t *testing.T) {
+		estimate, err := EstimateResources(newTestJob(Scheduler{MaxPodNum: 2, MaxConcurrentNumPerPod: 3}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// pods have 3 and 4 keys with a sidecar.
+		assertEstimate(t, estimate, 2, 9, "8", "8Gi", "14")
+	})
+	t.Run("maxCPU", func(t *testing.T) {
+		maxCPU := resource.MustParse("7")
+		estimate, err := EstimateResources(newTestJob(Scheduler{MaxContainersPerPod: 3, MaxConcurrentNumPerPod: 3, MaxCPU: &maxCPU}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEstimate(t, estimate, 2, 8, "7", "7Gi", "12")
+	})
+	t.Run("without strategy", func(t *testing.T) {
+		testjob := newTestJob(Scheduler{})
+		testjob.Spec.MainStep.Strategy = nil
+		estimate, err := EstimateResources(testjob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// prestep requests more CPU than main step.
+		assertEstimate(t, estimate, 1, 2, "2", "1536Mi", "2")
+	})
+	t.Run("dynamic keys", func(t *testing.T) {
+		testjob := newTestJob(Scheduler{MaxContainersPerPod: 3, MaxConcurrentNumPerPod: 3})
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Dynamic: &StrategyDynamicKeySource{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{{Container: corev1.Container{Name: "list"}}},
+					},
+				},
+			},
+		}
+		if _, err := EstimateResources(testjob); err == nil {
+			t.Fatal("expected error")
+		}
+		estimate, err := EstimateResourcesWithKeyNum(testjob, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEstimate(t, estimate, 2, 6, "5", "5Gi", "8")
+	})
+	t.Run("exceeded quota", func(t *testing.T) {
+		estimate, err := EstimateResources(newTestJob(Scheduler{MaxContainersPerPod: 3, MaxConcurrentNumPerPod: 3}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		exceeded := estimate.ExceededQuota(corev1.ResourceList{
+			corev1.ResourcePods:           resource.MustParse("2"),
+			corev1.ResourceRequestsCPU:    resource.MustParse("10"),
+			corev1.ResourceLimitsCPU:      resource.MustParse("10"),
+			corev1.ResourceMemory:         resource.MustParse("8Gi"),
+			corev1.ResourceServices:       resource.MustParse("0"),
+			corev1.ResourceRequestsMemory: resource.MustParse("16Gi"),
+		})
+		expected := []string{"limits.cpu", "memory", "pods"}
+		if !reflect.DeepEqual(exceeded, expected) {
+			t.Fatalf("unexpected exceeded quota: expected %v but got %v", expected, exceeded)
+		}
+	})
+	t.Run("planned key nums", func(t *testing.T) {
+		for _, scheduler := range []Scheduler{{MaxPodNum: 4}, {MaxContainersPerPod: 4}} {
+			for _, keyNum := range []int{1, 3, 4, 5, 8, 17} {
+				name := fmt.Sprintf("maxPodNum_%d_maxContainersPerPod_%d_keyNum_%d", scheduler.MaxPodNum, scheduler.MaxContainersPerPod, keyNum)
+				t.Run(name, func(t *testing.T) {
+					taskScheduler := NewTaskScheduler(MainStep{Strategy: &Strategy{Scheduler: scheduler}})
+					keyNums := taskScheduler.plannedKeyNums(keyNum)
+					if len(keyNums) != taskScheduler.plannedTaskNum(keyNum) {
+						t.Fatalf("unexpected task num: expected %d but got %d", taskScheduler.plannedTaskNum(keyNum), len(keyNums))
+					}
+					sum := 0
+					for _, num := range keyNums {
+						sum += num
+					}
+					if sum != keyNum {
+						t.Fatalf("unexpected key num: expected %d but got %d", keyNum, sum)
+					}
+				})
+			}
+		}
+	})
+}
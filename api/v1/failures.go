@@ -0,0 +1,87 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// writeFailuresLog writes the output of failed tests grouped by the test name.
+// All outputs are masked by mask function.
+func writeFailuresLog(w io.Writer, results []*SubTaskResult, mask func(string) string) error {
+	names := []string{}
+	nameToResults := map[string][]*SubTaskResult{}
+	for _, result := range results {
+		if _, exists := nameToResults[result.Name]; !exists {
+			names = append(names, result.Name)
+		}
+		nameToResults[result.Name] = append(nameToResults[result.Name], result)
+	}
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "=== FAIL: %s\n", mask(name)); err != nil {
+			return err
+		}
+		for _, result := range nameToResults[name] {
+			podName := "unknown"
+			if result.Pod != nil {
+				podName = result.Pod.Name
+			}
+			header := fmt.Sprintf("--- pod: %s, container: %s, exit code: %d", podName, result.Container.Name, result.ExitCode())
+			if result.FailureKind != FailureKindNone {
+				header += fmt.Sprintf(", kind: %s", result.FailureKind)
+			}
+			if result.Phase != "" && result.Phase != ContainerPhaseMain {
+				header += fmt.Sprintf(", phase: %s", result.Phase)
+			}
+			if _, err := fmt.Fprintln(w, header); err != nil {
+				return err
+			}
+			if result.Container.Image != "" {
+				if _, err := fmt.Fprintf(w, "--- repro: %s\n", mask(result.ReproCommand())); err != nil {
+					return err
+				}
+			}
+			out := mask(string(result.Out))
+			if out != "" && !strings.HasSuffix(out, "\n") {
+				out += "\n"
+			}
+			if _, err := io.WriteString(w, out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFailuresLog writes the failures of testjob to the failures log. The first run of the runner creates the file
+// and the following runs append to it, so the failures of all testjobs run by RunTestJobs are kept.
+// The failures of each testjob follow the line with its name.
+func (r *Runner) writeFailuresLog(testjob TestJob, taskResult *TaskResultGroup) error {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if r.failuresLogCreated {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(r.failuresLogPath, flag, 0o644)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to create failures log %s: %w", r.failuresLogPath, err)
+	}
+	defer f.Close()
+	r.failuresLogCreated = true
+	results := taskResult.FailedMainResults()
+	if len(results) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(f, "=== TESTJOB: %s\n", testjob.Name); err != nil {
+		return fmt.Errorf("kubetest: failed to write failures log %s: %w", r.failuresLogPath, err)
+	}
+	if err := writeFailuresLog(f, results, func(msg string) string {
+		return maskText(r.logger, msg)
+	}); err != nil {
+		return fmt.Errorf("kubetest: failed to write failures log %s: %w", r.failuresLogPath, err)
+	}
+	return nil
+}
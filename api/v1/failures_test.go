@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWriteFailuresLog(t *testing.T) {
+	logger := NewLogger(&bytes.Buffer{}, LogLevelInfo)
+	logger.AddMask("secret")
+	newResult := func(name, podName, out string) *SubTaskResult {
+		return &SubTaskResult{
+			Status:    TaskResultFailure,
+			Name:      name,
+			Out:       []byte(out),
+			Err:       errors.New("failed"),
+			Container: corev1.Container{Name: "test"},
+			Pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName}},
+		}
+	}
+	var b bytes.Buffer
+	if err := writeFailuresLog(&b, []*SubTaskResult{
+		newResult("TestA", "pod-a", "token is secret"),
+		newResult("TestB", "pod-b", "failed B\n"),
+		newResult("TestA", "pod-c", "failed A again"),
+	}, func(msg string) string {
+		return maskText(logger, msg)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `=== FAIL: TestA
+--- pod: pod-a, container: test, exit code: -1
+token is ******
+--- pod: pod-c, container: test, exit code: -1
+failed A again
+=== FAIL: TestB
+--- pod: pod-b, container: test, exit code: -1
+failed B
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected failures log: expected %q but got %q", expected, b.String())
+	}
+
+	result := newResult("TestC", "pod-d", "failed C")
+	result.Container.Image = "alpine"
+	b.Reset()
+	if err := writeFailuresLog(&b, []*SubTaskResult{result}, func(msg string) string { return msg }); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b.Bytes(), []byte("--- repro: docker run --rm alpine\n")) {
+		t.Fatalf("expected repro command in failures log but got %q", b.String())
+	}
+
+	t.Run("testjobs", func(t *testing.T) {
+		runner := NewRunner(getConfig(), RunModeLocal)
+		runner.SetLogger(logger)
+		runner.SetFailuresLogPath(filepath.Join(t.TempDir(), "failures.log"))
+		if err := os.WriteFile(runner.failuresLogPath, []byte("previous invocation\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"first", "second", "third"} {
+			var group SubTaskResultGroup
+			if name != "second" {
+				group.add(&SubTaskResult{Status: TaskResultFailure, Name: "Test", Out: []byte("failed in " + name), IsMain: true})
+			}
+			var g TaskResultGroup
+			g.add(&TaskResult{groups: []*SubTaskResultGroup{&group}})
+			if err := runner.writeFailuresLog(TestJob{ObjectMeta: metav1.ObjectMeta{Name: name}}, &g); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := os.ReadFile(runner.failuresLogPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `=== TESTJOB: first
+=== FAIL: Test
+--- pod: unknown, container: , exit code: 0
+failed in first
+=== TESTJOB: third
+=== FAIL: Test
+--- pod: unknown, container: , exit code: 0
+failed in third
+`
+		if string(b) != expected {
+			t.Fatalf("the failures of all testjobs must be kept: expected %q but got %q", expected, string(b))
+		}
+	})
+}
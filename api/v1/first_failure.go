@@ -0,0 +1,57 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// firstFailureRecorder records the time the first test failure was observed while the tests run concurrently.
+type firstFailureRecorder struct {
+	startedAt time.Time
+	at        time.Time
+	mu        sync.Mutex
+}
+
+func newFirstFailureRecorder(startedAt time.Time) *firstFailureRecorder {
+	return &firstFailureRecorder{startedAt: startedAt}
+}
+
+// record records at if it is earlier than the failure already recorded.
+// It returns true if at is recorded as the first failure.
+func (r *firstFailureRecorder) record(at time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.at.IsZero() && !at.Before(r.at) {
+		return false
+	}
+	r.at = at
+	return true
+}
+
+// firstFailureAt returns the time of the first failure. If no test failed, returns false.
+func (r *firstFailureRecorder) firstFailureAt() (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.at, !r.at.IsZero()
+}
+
+type firstFailureRecorderKey struct{}
+
+func withFirstFailureRecorder(ctx context.Context, recorder *firstFailureRecorder) context.Context {
+	return context.WithValue(ctx, firstFailureRecorderKey{}, recorder)
+}
+
+// recordTestFailure records the time the test failed if the recorder is registered to the context.
+func recordTestFailure(ctx context.Context, at time.Time) {
+	recorder, _ := ctx.Value(firstFailureRecorderKey{}).(*firstFailureRecorder)
+	if recorder == nil {
+		return
+	}
+	if recorder.record(at) {
+		LoggerFromContext(ctx).Info("first failure observed %s after the start", at.Sub(recorder.startedAt).Round(time.Second))
+	}
+}
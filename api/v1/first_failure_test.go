@@ -0,0 +1,65 @@
+package v1
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestFirstFailureRecorder(t *testing.T) {
+	startedAt := time.Now()
+	t.Run("concurrent", func(t *testing.T) {
+		recorder := newFirstFailureRecorder(startedAt)
+		if _, failed := recorder.firstFailureAt(); failed {
+			t.Fatal("no failure must be recorded")
+		}
+		var wg sync.WaitGroup
+		for i := 10; i > 0; i-- {
+			at := startedAt.Add(time.Duration(i) * time.Second)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recorder.record(at)
+			}()
+		}
+		wg.Wait()
+		at, failed := recorder.firstFailureAt()
+		if !failed || !at.Equal(startedAt.Add(time.Second)) {
+			t.Fatalf("unexpected first failure: %s", at)
+		}
+	})
+	t.Run("subtask", func(t *testing.T) {
+		recorder := newFirstFailureRecorder(startedAt)
+		ctx := withFirstFailureRecorder(WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug)), recorder)
+		newSubTask := func(command string, isMain bool) *SubTask {
+			return &SubTask{
+				Name: "test",
+				exec: &localJobExecutor{
+					rootDir:   t.TempDir(),
+					container: corev1.Container{Name: "test", Command: []string{"sh", "-c", command}},
+				},
+				isMain:       isMain,
+				copyArtifact: func(context.Context, *SubTask) error { return nil },
+			}
+		}
+		newSubTask("true", true).Run(ctx)
+		newSubTask("false", false).Run(ctx)
+		if _, failed := recorder.firstFailureAt(); failed {
+			t.Fatal("the success and the failure of the non-main container must not be recorded")
+		}
+		before := time.Now()
+		newSubTask("false", true).Run(ctx)
+		at, failed := recorder.firstFailureAt()
+		if !failed || at.Before(before) {
+			t.Fatalf("unexpected first failure: %s", at)
+		}
+		newSubTask("false", true).Run(ctx)
+		if again, _ := recorder.firstFailureAt(); !again.Equal(at) {
+			t.Fatalf("the first failure must not be overwritten: %s", again)
+		}
+	})
+}
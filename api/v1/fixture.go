@@ -0,0 +1,275 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// fixtureReadyTimeout time to wait until the fixture becomes ready.
+	fixtureReadyTimeout = 5 * time.Minute
+	// fixturePollInterval interval to get the fixture while waiting until it becomes ready.
+	fixturePollInterval = 2 * time.Second
+)
+
+// FixtureError is returned when the fixture couldn't be applied or didn't become ready.
+// The run fails before the tasks start, so this is the infrastructure error instead of the failure of the tests.
+type FixtureError struct {
+	// Fixture the offending fixture ( e.g. Deployment default/db ).
+	Fixture string
+	Err     error
+}
+
+func (e *FixtureError) Error() string {
+	return fmt.Sprintf("kubetest: failed to apply fixture %s: %s", e.Fixture, e.Err)
+}
+
+func (e *FixtureError) Unwrap() error {
+	return e.Err
+}
+
+// decodeFixture decodes the manifest of the fixture. The fixture must have apiVersion, kind and name to be deleted after the run.
+func decodeFixture(raw runtime.RawExtension) (*unstructured.Unstructured, error) {
+	if len(raw.Raw) == 0 {
+		return nil, fmt.Errorf("fixture must not be empty")
+	}
+	var obj unstructured.Unstructured
+	if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+		return nil, err
+	}
+	if obj.GetAPIVersion() == "" {
+		return nil, fmt.Errorf("apiVersion of fixture must be specified")
+	}
+	if obj.GetName() == "" {
+		return nil, fmt.Errorf("name of %s fixture must be specified", obj.GetKind())
+	}
+	return &obj, nil
+}
+
+// fixtureName returns the name identifying the fixture in the log and the error ( e.g. Deployment default/db ).
+func fixtureName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return fmt.Sprintf("%s %s", obj.GetKind(), obj.GetName())
+	}
+	return fmt.Sprintf("%s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+}
+
+type fixture struct {
+	obj      *unstructured.Unstructured
+	resource dynamic.ResourceInterface
+}
+
+// buildFixtures decodes the fixtures of testjob and labels them with the run id.
+func buildFixtures(testjob TestJob, runID string) ([]*unstructured.Unstructured, error) {
+	objs := make([]*unstructured.Unstructured, 0, len(testjob.Spec.Fixtures))
+	for idx, raw := range testjob.Spec.Fixtures {
+		obj, err := decodeFixture(raw)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: invalid fixtures[%d]: %w", idx, err)
+		}
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[kubetestLabel] = fmt.Sprint(true)
+		labels[runIDLabel] = runID
+		obj.SetLabels(labels)
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// resolveFixture resolves the resource of obj by mapper. The namespaced fixture without namespace is created in the namespace of testjob,
+// and the owner reference is set to the fixtures in that namespace.
+func resolveFixture(client dynamic.Interface, mapper meta.RESTMapper, obj *unstructured.Unstructured, namespace string, ownerReference *metav1.OwnerReference) (*fixture, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return &fixture{obj: obj, resource: client.Resource(mapping.Resource)}, nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(namespace)
+	}
+	if ownerReference := ownerReferenceIn(obj.GetNamespace(), namespace, ownerReference); ownerReference != nil {
+		obj.SetOwnerReferences(append(obj.GetOwnerReferences(), *ownerReference))
+	}
+	return &fixture{obj: obj, resource: client.Resource(mapping.Resource).Namespace(obj.GetNamespace())}, nil
+}
+
+// fixtureReady returns whether the fixture is ready. The Deployment is ready if all replicas are available,
+// and the Job is ready if it is complete. The other objects are ready as soon as they are created.
+func fixtureReady(obj *unstructured.Unstructured) (bool, error) {
+	switch obj.GroupVersionKind().GroupKind() {
+	case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+		observedGeneration, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+		if observedGeneration < obj.GetGeneration() {
+			return false, nil
+		}
+		replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+		if !found {
+			replicas = 1
+		}
+		available, _, _ := unstructured.NestedInt64(obj.Object, "status", "availableReplicas")
+		return available >= replicas, nil
+	case schema.GroupKind{Group: "batch", Kind: "Job"}:
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		for _, condition := range conditions {
+			c, ok := condition.(map[string]interface{})
+			if !ok || c["status"] != "True" {
+				continue
+			}
+			switch c["type"] {
+			case "Complete":
+				return true, nil
+			case "Failed":
+				return false, fmt.Errorf("Job failed: %v", c["message"])
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// waitFixture waits until the fixture becomes ready.
+func waitFixture(ctx context.Context, f *fixture) error {
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, fixturePollInterval, fixtureReadyTimeout, true, func(ctx context.Context) (bool, error) {
+		obj, err := f.resource.Get(ctx, f.obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			// the error may be temporary, so it is retried until the timeout.
+			lastErr = err
+			return false, nil
+		}
+		return fixtureReady(obj)
+	})
+	if err != nil && wait.Interrupted(err) {
+		if lastErr != nil {
+			return fmt.Errorf("not ready within %s: %w", fixtureReadyTimeout, lastErr)
+		}
+		return fmt.Errorf("not ready within %s", fixtureReadyTimeout)
+	}
+	return err
+}
+
+// deleteFixtures deletes the fixtures in reverse order of creation. The fixtures already deleted are ignored.
+func deleteFixtures(ctx context.Context, fixtures []*fixture) error {
+	var errs []error
+	propagation := metav1.DeletePropagationBackground
+	for idx := len(fixtures) - 1; idx >= 0; idx-- {
+		f := fixtures[idx]
+		LoggerFromContext(ctx).Info("delete fixture %s", fixtureName(f.obj))
+		if err := f.resource.Delete(ctx, f.obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("kubetest: failed to delete fixture %s: %w", fixtureName(f.obj), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setupFixtures applies the fixtures of testjob and waits until they become ready, and returns the function to delete them
+// which receives whether the run failed to decide whether the fixtures are kept by keepResources.
+// If one of the fixtures couldn't be applied, FixtureError is returned and the applied fixtures are deleted unless keepResources keeps them on failure.
+// The fixtures are written to manifests if specified. In dry-run mode, the fixtures are rendered to the log instead of being applied.
+func (r *Runner) setupFixtures(ctx context.Context, client dynamic.Interface, mapper meta.RESTMapper, testjob TestJob, runID string, manifests *manifestWriter) (func(bool), error) {
+	objs, err := buildFixtures(testjob, runID)
+	if err != nil {
+		return nil, err
+	}
+	for idx, obj := range objs {
+		if manifests == nil {
+			continue
+		}
+		path, err := manifests.writeObject(obj.Object, fmt.Sprintf("%s-fixture-%d-%s.yaml", runID, idx, strings.ToLower(obj.GetKind())))
+		if err != nil {
+			return nil, err
+		}
+		r.logger.Debug("wrote fixture manifest to %s", path)
+	}
+	switch r.runMode {
+	case RunModeDryRun:
+		for _, obj := range objs {
+			b, err := yaml.Marshal(obj.Object)
+			if err != nil {
+				return nil, fmt.Errorf("kubetest: failed to encode fixture manifest: %w", err)
+			}
+			r.logger.Info("fixture:\n%s", string(b))
+		}
+		return func(bool) {}, nil
+	case RunModeLocal:
+		r.logger.Warn("fixtures aren't applied in local mode")
+		return func(bool) {}, nil
+	}
+	var (
+		fixtures []*fixture
+		objects  []ReportObject
+	)
+	release := func(failed bool) {
+		if keepResources(testjob.Spec.KeepResources, failed) {
+			// marked as kept, so OwnerCleanup and the drainer delete them only after the retention elapses.
+			now := time.Now()
+			if recorder := ObjectRecorderFromContext(ctx); recorder != nil {
+				recorder.keep(objects, now)
+			}
+			r.createdObjects.keep(objects, now)
+			for _, f := range fixtures {
+				r.logger.Info("keep fixture %s ( keepResources: %s )", fixtureName(f.obj), testjob.Spec.KeepResources)
+			}
+			return
+		}
+		// the fixtures are deleted even if the run was interrupted.
+		if err := deleteFixtures(context.WithoutCancel(ctx), fixtures); err != nil {
+			r.logger.Warn("%s", err.Error())
+		}
+	}
+	for _, obj := range objs {
+		f, err := resolveFixture(client, mapper, obj, testjob.Namespace, r.ownerReference)
+		if err != nil {
+			release(true)
+			return nil, &FixtureError{Fixture: fixtureName(obj), Err: err}
+		}
+		r.logger.Info("create fixture %s", fixtureName(obj))
+		created, err := f.resource.Create(ctx, obj, metav1.CreateOptions{})
+		if err != nil {
+			release(true)
+			return nil, &FixtureError{Fixture: fixtureName(obj), Err: err}
+		}
+		fixtures = append(fixtures, f)
+		object := ReportObject{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			UID:        created.GetUID(),
+		}
+		objects = append(objects, object)
+		// recorded so that OwnerCleanup and the drainer delete the fixture even if the run crashed before deleting it.
+		recordObject(ctx, object)
+	}
+	// the fixtures are created before waiting, so they become ready concurrently.
+	for _, f := range fixtures {
+		if err := waitFixture(ctx, f); err != nil {
+			release(true)
+			return nil, &FixtureError{Fixture: fixtureName(f.obj), Err: err}
+		}
+		r.logger.Debug("fixture %s is ready", fixtureName(f.obj))
+	}
+	return release, nil
+}
@@ -0,0 +1,221 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFixtures(t *testing.T) {
+	var (
+		configMapGVR  = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+		deploymentGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+		jobGVR        = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+	)
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, meta.RESTScopeNamespace)
+
+	configMap := `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"seed"},"data":{"user":"kubetest"}}`
+	deployment := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"db","namespace":"database"},"spec":{"replicas":2},"status":{"availableReplicas":2}}`
+	failedJob := `{"apiVersion":"batch/v1","kind":"Job","metadata":{"name":"migrate"},"status":{"conditions":[{"type":"Failed","status":"True","message":"BackoffLimitExceeded"}]}}`
+	newTestJob := func(policy KeepResourcesPolicy, fixtures ...string) TestJob {
+		testjob := TestJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "fixtures", Namespace: "default"},
+			Spec:       TestJobSpec{KeepResources: policy},
+		}
+		for _, fixture := range fixtures {
+			testjob.Spec.Fixtures = append(testjob.Spec.Fixtures, runtime.RawExtension{Raw: []byte(fixture)})
+		}
+		return testjob
+	}
+	newRunner := func(runMode RunMode) (*Runner, *bytes.Buffer) {
+		var buf bytes.Buffer
+		runner := NewRunner(getConfig(), runMode)
+		runner.SetLogger(NewLogger(&buf, LogLevelInfo))
+		return runner, &buf
+	}
+	t.Run("kubernetes", func(t *testing.T) {
+		runner, _ := newRunner(RunModeKubernetes)
+		ctx := WithLogger(context.Background(), runner.logger)
+		client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+		release, err := runner.setupFixtures(ctx, client, mapper, newTestJob("", configMap, deployment), "abcd", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seed, err := client.Resource(configMapGVR).Namespace("default").Get(ctx, "seed", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seed.GetLabels()[runIDLabel] != "abcd" {
+			t.Fatalf("the fixture must have the run id label: %v", seed.GetLabels())
+		}
+		if _, err := client.Resource(deploymentGVR).Namespace("database").Get(ctx, "db", metav1.GetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		release(false)
+		if _, err := client.Resource(configMapGVR).Namespace("default").Get(ctx, "seed", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("the fixture must be deleted by release but got %v", err)
+		}
+		if _, err := client.Resource(deploymentGVR).Namespace("database").Get(ctx, "db", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("the fixture must be deleted by release but got %v", err)
+		}
+	})
+	t.Run("keep on failure", func(t *testing.T) {
+		runner, buf := newRunner(RunModeKubernetes)
+		recorder := NewObjectRecorder()
+		ctx := WithObjectRecorder(WithLogger(context.Background(), runner.logger), recorder)
+		client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+		release, err := runner.setupFixtures(ctx, client, mapper, newTestJob(KeepResourcesOnFailure, configMap, deployment), "abcd", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects := recorder.Objects()
+		if len(objects) != 2 || objects[0].Kind != "ConfigMap" || objects[0].Namespace != "default" || objects[1].APIVersion != "apps/v1" {
+			t.Fatalf("the fixtures must be recorded: %+v", objects)
+		}
+		release(true)
+		if _, err := client.Resource(configMapGVR).Namespace("default").Get(ctx, "seed", metav1.GetOptions{}); err != nil {
+			t.Fatalf("the fixture must be kept: %v", err)
+		}
+		if deletable, _ := recorder.deletableObjects(time.Now(), time.Hour); len(deletable) != 0 {
+			t.Fatalf("the kept fixtures must be skipped within the retention: %+v", deletable)
+		}
+		dynamicClient := &dynamicObjectClient{client: client, mapper: mapper}
+		deletable, _ := recorder.deletableObjects(time.Now().Add(2*time.Hour), time.Hour)
+		if err := deleteObjects(ctx, fake.NewSimpleClientset(), dynamicClient, deletable); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Resource(deploymentGVR).Namespace("database").Get(ctx, "db", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("the kept fixture must be deleted after the retention but got %v", err)
+		}
+		if !strings.Contains(buf.String(), "keep fixture ConfigMap default/seed") {
+			t.Fatalf("the kept fixture must be printed: %s", buf.String())
+		}
+	})
+	t.Run("failed job", func(t *testing.T) {
+		runner, _ := newRunner(RunModeKubernetes)
+		ctx := WithLogger(context.Background(), runner.logger)
+		client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+		_, err := runner.setupFixtures(ctx, client, mapper, newTestJob("", configMap, failedJob), "abcd", nil)
+		var fixtureErr *FixtureError
+		if !errors.As(err, &fixtureErr) {
+			t.Fatalf("expected FixtureError but got %v", err)
+		}
+		if fixtureErr.Fixture != "Job default/migrate" || !strings.Contains(err.Error(), "BackoffLimitExceeded") {
+			t.Fatalf("the error must identify the failed fixture: %v", err)
+		}
+		if _, err := client.Resource(configMapGVR).Namespace("default").Get(ctx, "seed", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("the applied fixtures must be deleted but got %v", err)
+		}
+		if _, err := client.Resource(jobGVR).Namespace("default").Get(ctx, "migrate", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("the failed fixture must be deleted but got %v", err)
+		}
+	})
+	t.Run("unknown kind", func(t *testing.T) {
+		runner, _ := newRunner(RunModeKubernetes)
+		ctx := WithLogger(context.Background(), runner.logger)
+		client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+		_, err := runner.setupFixtures(ctx, client, mapper, newTestJob("", `{"apiVersion":"example.com/v1","kind":"Database","metadata":{"name":"db"}}`), "abcd", nil)
+		var fixtureErr *FixtureError
+		if !errors.As(err, &fixtureErr) || fixtureErr.Fixture != "Database db" {
+			t.Fatalf("expected FixtureError of Database but got %v", err)
+		}
+	})
+	t.Run("dry run", func(t *testing.T) {
+		runner, buf := newRunner(RunModeDryRun)
+		ctx := WithLogger(context.Background(), runner.logger)
+		client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+		if _, err := runner.setupFixtures(ctx, client, mapper, newTestJob("", configMap), "abcd", nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Resource(configMapGVR).Namespace("default").Get(ctx, "seed", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("the fixture must not be created in dry-run mode: %v", err)
+		}
+		if !strings.Contains(buf.String(), "kind: ConfigMap") || !strings.Contains(buf.String(), "kubetest.io/run: abcd") {
+			t.Fatalf("the fixture must be rendered: %s", buf.String())
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		if err := NewValidator().ValidateFixtures(newTestJob("", `{"apiVersion":"v1","kind":"ConfigMap"}`).Spec.Fixtures); err == nil || !strings.Contains(err.Error(), "name of ConfigMap fixture") {
+			t.Fatalf("expected the error of the fixture without name but got %v", err)
+		}
+	})
+}
+
+func TestFixtureReady(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		obj      map[string]interface{}
+		expected bool
+	}{
+		{
+			name: "deployment available",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "Deployment",
+				"metadata": map[string]interface{}{"generation": int64(2)},
+				"status":   map[string]interface{}{"observedGeneration": int64(2), "availableReplicas": int64(1)},
+			},
+			expected: true,
+		},
+		{
+			name: "deployment not observed",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "Deployment",
+				"metadata": map[string]interface{}{"generation": int64(2)},
+				"status":   map[string]interface{}{"observedGeneration": int64(1), "availableReplicas": int64(1)},
+			},
+		},
+		{
+			name: "deployment unavailable",
+			obj: map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "Deployment",
+				"spec":   map[string]interface{}{"replicas": int64(3)},
+				"status": map[string]interface{}{"availableReplicas": int64(2)},
+			},
+		},
+		{
+			name: "job complete",
+			obj: map[string]interface{}{
+				"apiVersion": "batch/v1", "kind": "Job",
+				"status": map[string]interface{}{
+					"conditions": []interface{}{map[string]interface{}{"type": "Complete", "status": "True"}},
+				},
+			},
+			expected: true,
+		},
+		{
+			name:     "job running",
+			obj:      map[string]interface{}{"apiVersion": "batch/v1", "kind": "Job"},
+			expected: false,
+		},
+		{
+			name:     "service",
+			obj:      map[string]interface{}{"apiVersion": "v1", "kind": "Service"},
+			expected: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ready, err := fixtureReady(&unstructured.Unstructured{Object: test.obj})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready != test.expected {
+				t.Fatalf("expected ready %t but got %t", test.expected, ready)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	hermeticPolicyNamePrefix = "kubetest-hermetic-"
+	// namespaceNameLabel label set to all namespaces by kubernetes ( v1.21+ ) to select the namespace by name.
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+)
+
+// networkPolicyPlugins prefixes of the DaemonSets in kube-system of the network plugins enforcing NetworkPolicy.
+// The other plugins ( e.g. flannel ) accept NetworkPolicy but don't enforce it.
+var networkPolicyPlugins = []string{"calico", "cilium", "antrea", "weave-net", "kube-router", "canal"}
+
+// hermeticNetworkPolicy builds the NetworkPolicy selecting the pods of the run by the run id label.
+// The egress of the pods is denied except kube-dns and the allowlist of spec. The ingress isn't changed.
+func hermeticNetworkPolicy(namespace, runID string, spec HermeticSpec, ownerReference *metav1.OwnerReference) *networkingv1.NetworkPolicy {
+	udp := corev1.ProtocolUDP
+	tcp := corev1.ProtocolTCP
+	dnsPort := intstr.FromInt(53)
+	egress := []networkingv1.NetworkPolicyEgressRule{
+		{
+			To: []networkingv1.NetworkPolicyPeer{
+				{
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{namespaceNameLabel: metav1.NamespaceSystem},
+					},
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"k8s-app": "kube-dns"},
+					},
+				},
+			},
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dnsPort},
+				{Protocol: &tcp, Port: &dnsPort},
+			},
+		},
+	}
+	var allowed []networkingv1.NetworkPolicyPeer
+	for _, cidr := range spec.AllowedCIDRs {
+		allowed = append(allowed, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+	if len(spec.AllowedNamespaces) != 0 {
+		allowed = append(allowed, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      namespaceNameLabel,
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   append([]string{}, spec.AllowedNamespaces...),
+					},
+				},
+			},
+		})
+	}
+	if len(allowed) != 0 {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{To: allowed})
+	}
+	policy := &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: networkingv1.SchemeGroupVersion.String(), Kind: "NetworkPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hermeticPolicyNamePrefix + runID,
+			Namespace: namespace,
+			Labels: map[string]string{
+				kubetestLabel: fmt.Sprint(true),
+				runIDLabel:    runID,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{runIDLabel: runID},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      egress,
+		},
+	}
+	if ownerReference != nil {
+		policy.OwnerReferences = append(policy.OwnerReferences, *ownerReference)
+	}
+	return policy
+}
+
+// createHermeticPolicies creates the policies. If one of them couldn't be created, the created policies are deleted.
+func createHermeticPolicies(ctx context.Context, clientset kubernetes.Interface, policies []*networkingv1.NetworkPolicy) error {
+	for idx, policy := range policies {
+		LoggerFromContext(ctx).Info("create NetworkPolicy %s/%s for hermetic mode", policy.Namespace, policy.Name)
+		created, err := clientset.NetworkingV1().NetworkPolicies(policy.Namespace).Create(ctx, policy, metav1.CreateOptions{})
+		if err != nil {
+			if deleteErr := deleteHermeticPolicies(ctx, clientset, policies[:idx]); deleteErr != nil {
+				LoggerFromContext(ctx).Warn("%s", deleteErr.Error())
+			}
+			return fmt.Errorf("kubetest: failed to create NetworkPolicy %s/%s for hermetic mode: %w", policy.Namespace, policy.Name, err)
+		}
+		// recorded so that OwnerCleanup and the drainer delete the policy even if the run crashed before deleting it.
+		recordObject(ctx, ReportObject{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       "NetworkPolicy",
+			Namespace:  created.Namespace,
+			Name:       created.Name,
+			UID:        created.UID,
+		})
+	}
+	return nil
+}
+
+// deleteHermeticPolicies deletes the policies. The policies already deleted are ignored.
+func deleteHermeticPolicies(ctx context.Context, clientset kubernetes.Interface, policies []*networkingv1.NetworkPolicy) error {
+	var errs []error
+	for _, policy := range policies {
+		LoggerFromContext(ctx).Info("delete NetworkPolicy %s/%s", policy.Namespace, policy.Name)
+		if err := clientset.NetworkingV1().NetworkPolicies(policy.Namespace).Delete(ctx, policy.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("kubetest: failed to delete NetworkPolicy %s/%s: %w", policy.Namespace, policy.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// warnNetworkPolicyEnforcement warns if no network plugin enforcing NetworkPolicy is found in kube-system.
+// NetworkPolicy is accepted by the cluster without such a plugin, so the tests silently run without the restriction.
+func warnNetworkPolicyEnforcement(ctx context.Context, clientset kubernetes.Interface) {
+	logger := LoggerFromContext(ctx)
+	daemonSets, err := clientset.AppsV1().DaemonSets(metav1.NamespaceSystem).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logger.Warn("WARNING: hermetic mode couldn't check whether NetworkPolicy is enforced by the cluster: %s", err)
+		return
+	}
+	for _, daemonSet := range daemonSets.Items {
+		for _, plugin := range networkPolicyPlugins {
+			if strings.HasPrefix(daemonSet.Name, plugin) {
+				logger.Debug("NetworkPolicy is enforced by %s", daemonSet.Name)
+				return
+			}
+		}
+	}
+	logger.Warn(
+		"WARNING: hermetic mode found no network plugin enforcing NetworkPolicy ( %s ) in %s. The tests may access the network without restriction",
+		strings.Join(networkPolicyPlugins, ", "), metav1.NamespaceSystem,
+	)
+}
+
+// setupHermetic creates the NetworkPolicy of hermetic mode in all namespaces having the pods of the run, and returns the function to delete them.
+// The policies are written to manifests if specified. In dry-run mode, the policies are rendered to the log instead of being created.
+func (r *Runner) setupHermetic(ctx context.Context, clientset kubernetes.Interface, testjob TestJob, runID string, manifests *manifestWriter) (func(), error) {
+	var policies []*networkingv1.NetworkPolicy
+	for _, namespace := range runNamespaces(testjob) {
+		ownerReference := ownerReferenceIn(namespace, testjob.Namespace, r.ownerReference)
+		policy := hermeticNetworkPolicy(namespace, runID, *testjob.Spec.Hermetic, ownerReference)
+		if manifests != nil {
+			path, err := manifests.writeObject(policy, fmt.Sprintf("%s-networkpolicy-%s.yaml", runID, namespace))
+			if err != nil {
+				return nil, err
+			}
+			r.logger.Debug("wrote NetworkPolicy manifest to %s", path)
+		}
+		policies = append(policies, policy)
+	}
+	switch r.runMode {
+	case RunModeDryRun:
+		for _, policy := range policies {
+			b, err := yaml.Marshal(policy)
+			if err != nil {
+				return nil, fmt.Errorf("kubetest: failed to encode NetworkPolicy manifest: %w", err)
+			}
+			r.logger.Info("NetworkPolicy of hermetic mode:\n%s", string(b))
+		}
+		return func() {}, nil
+	case RunModeLocal:
+		r.logger.Warn("hermetic mode doesn't restrict the network in local mode")
+		return func() {}, nil
+	}
+	warnNetworkPolicyEnforcement(ctx, clientset)
+	if err := createHermeticPolicies(ctx, clientset, policies); err != nil {
+		return nil, err
+	}
+	return func() {
+		// the policies are deleted even if the run was interrupted.
+		if err := deleteHermeticPolicies(context.WithoutCancel(ctx), clientset, policies); err != nil {
+			r.logger.Warn("%s", err.Error())
+		}
+	}, nil
+}
@@ -0,0 +1,157 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHermetic(t *testing.T) {
+	testjob := TestJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "hermetic", Namespace: "default"},
+		Spec: TestJobSpec{
+			Hermetic: &HermeticSpec{
+				Enabled:           true,
+				AllowedCIDRs:      []string{"10.0.0.0/8"},
+				AllowedNamespaces: []string{"database"},
+			},
+			MainStep: MainStep{
+				Strategy: &Strategy{Scheduler: Scheduler{Namespaces: []string{"default", "shard"}}},
+			},
+		},
+	}
+	newRunner := func(runMode RunMode) (*Runner, *bytes.Buffer) {
+		var buf bytes.Buffer
+		runner := NewRunner(getConfig(), runMode)
+		runner.SetLogger(NewLogger(&buf, LogLevelInfo))
+		return runner, &buf
+	}
+	t.Run("kubernetes", func(t *testing.T) {
+		runner, buf := newRunner(RunModeKubernetes)
+		ctx := WithLogger(context.Background(), runner.logger)
+		clientset := fake.NewSimpleClientset()
+		cleanup, err := runner.setupHermetic(ctx, clientset, testjob, "abcd", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, namespace := range []string{"default", "shard"} {
+			policy, err := clientset.NetworkingV1().NetworkPolicies(namespace).Get(ctx, "kubetest-hermetic-abcd", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.Spec.PodSelector.MatchLabels[runIDLabel] != "abcd" {
+				t.Fatalf("the policy must select the pods of the run: %+v", policy.Spec.PodSelector)
+			}
+			egress := policy.Spec.Egress
+			if len(egress) != 2 || len(egress[0].Ports) != 2 {
+				t.Fatalf("unexpected egress: %+v", egress)
+			}
+			if egress[1].To[0].IPBlock.CIDR != "10.0.0.0/8" || egress[1].To[1].NamespaceSelector.MatchExpressions[0].Values[0] != "database" {
+				t.Fatalf("unexpected allowlist: %+v", egress[1].To)
+			}
+		}
+		if !strings.Contains(buf.String(), "WARNING: hermetic mode found no network plugin enforcing NetworkPolicy") {
+			t.Fatalf("expected warning of the cluster without enforcement: %s", buf.String())
+		}
+		cleanup()
+		policies, err := clientset.NetworkingV1().NetworkPolicies("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(policies.Items) != 0 {
+			t.Fatalf("the policies must be deleted by cleanup: %+v", policies.Items)
+		}
+	})
+	t.Run("owner reference", func(t *testing.T) {
+		runner, _ := newRunner(RunModeKubernetes)
+		runner.SetOwnerReference(metav1.OwnerReference{APIVersion: "kubetest.io/v1", Kind: "TestJob", Name: "hermetic", UID: "uid"})
+		ctx := WithLogger(context.Background(), runner.logger)
+		clientset := fake.NewSimpleClientset()
+		cleanup, err := runner.setupHermetic(ctx, clientset, testjob, "abcd", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		for namespace, expected := range map[string]int{"default": 1, "shard": 0} {
+			policy, err := clientset.NetworkingV1().NetworkPolicies(namespace).Get(ctx, "kubetest-hermetic-abcd", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(policy.OwnerReferences) != expected {
+				t.Fatalf("expected %d owner references in %s but got %+v", expected, namespace, policy.OwnerReferences)
+			}
+		}
+	})
+	t.Run("recorded", func(t *testing.T) {
+		runner, _ := newRunner(RunModeKubernetes)
+		recorder := NewObjectRecorder()
+		ctx := WithObjectRecorder(WithLogger(context.Background(), runner.logger), recorder)
+		clientset := fake.NewSimpleClientset()
+		// the cleanup isn't called as if the run crashed.
+		if _, err := runner.setupHermetic(ctx, clientset, testjob, "abcd", nil); err != nil {
+			t.Fatal(err)
+		}
+		objects := recorder.Objects()
+		if len(objects) != 2 || objects[0].Kind != "NetworkPolicy" || objects[1].Namespace != "shard" {
+			t.Fatalf("the policies must be recorded: %+v", objects)
+		}
+		if err := deleteObjects(ctx, clientset, nil, objects); err != nil {
+			t.Fatal(err)
+		}
+		policies, err := clientset.NetworkingV1().NetworkPolicies("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(policies.Items) != 0 {
+			t.Fatalf("the recorded policies must be deleted: %+v", policies.Items)
+		}
+	})
+	t.Run("enforced", func(t *testing.T) {
+		runner, buf := newRunner(RunModeKubernetes)
+		ctx := WithLogger(context.Background(), runner.logger)
+		clientset := fake.NewSimpleClientset(&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "calico-node", Namespace: metav1.NamespaceSystem},
+		})
+		cleanup, err := runner.setupHermetic(ctx, clientset, testjob, "abcd", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		if strings.Contains(buf.String(), "WARNING") {
+			t.Fatalf("unexpected warning: %s", buf.String())
+		}
+	})
+	t.Run("dry run", func(t *testing.T) {
+		runner, buf := newRunner(RunModeDryRun)
+		ctx := WithLogger(context.Background(), runner.logger)
+		clientset := fake.NewSimpleClientset()
+		dir := t.TempDir()
+		if _, err := runner.setupHermetic(ctx, clientset, testjob, "abcd", newManifestWriter(dir)); err != nil {
+			t.Fatal(err)
+		}
+		policies, err := clientset.NetworkingV1().NetworkPolicies("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(policies.Items) != 0 {
+			t.Fatalf("the policies must not be created in dry-run mode: %+v", policies.Items)
+		}
+		if !strings.Contains(buf.String(), "kind: NetworkPolicy") {
+			t.Fatalf("the policy must be rendered: %s", buf.String())
+		}
+		manifest, err := os.ReadFile(filepath.Join(dir, "abcd-networkpolicy-shard.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(manifest), "name: kubetest-hermetic-abcd") {
+			t.Fatalf("unexpected manifest: %s", manifest)
+		}
+	})
+}
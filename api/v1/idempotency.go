@@ -0,0 +1,247 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// idempotencyKeyLabel label of the Jobs having the idempotency key of the run set by Runner.SetIdempotencyKey.
+const idempotencyKeyLabel = "kubetest.io/idempotency-key"
+
+var (
+	// idempotencyPollInterval interval to read the status of the existing run while attaching to it.
+	idempotencyPollInterval = 5 * time.Second
+	// idempotencyStaleTimeout time the existing run can have no active Jobs without publishing its result.
+	// The run has no active Jobs while it runs on the local host between the steps ( e.g. exporting the artifacts ),
+	// so the run is regarded as crashed only after this time.
+	idempotencyStaleTimeout = 10 * time.Minute
+	// runStatusTimeout timeout to publish the final status of the run.
+	runStatusTimeout = 30 * time.Second
+)
+
+const (
+	// runStatusKey key of the data of the ConfigMap having the status of the run.
+	runStatusKey = "status"
+	// runStatusRunning status of the run published while it's running.
+	runStatusRunning = "running"
+)
+
+// IdempotencyPolicy what to do when the run having the same idempotency key is already running.
+type IdempotencyPolicy string
+
+const (
+	// IdempotencyPolicyRefuse fails the run with DuplicateRunError.
+	IdempotencyPolicyRefuse IdempotencyPolicy = "refuse"
+	// IdempotencyPolicyAttach waits until the existing run finishes instead of running the tests,
+	// and returns the report having the status of the existing run and its run id as AttachedRunID.
+	IdempotencyPolicyAttach IdempotencyPolicy = "attach"
+)
+
+// DuplicateRunError is returned when the run having the same idempotency key is already running and the policy is refuse.
+type DuplicateRunError struct {
+	// Key idempotency key of the run.
+	Key string
+	// RunID identifier of the existing run.
+	RunID string
+}
+
+func (e *DuplicateRunError) Error() string {
+	return fmt.Sprintf("kubetest: run %s having the same idempotency key %q is already running", e.RunID, e.Key)
+}
+
+// jobFinished returns whether the Job is complete or failed.
+func jobFinished(job batchv1.Job) bool {
+	return jobCondition(job) != ""
+}
+
+// jobCondition returns JobComplete or JobFailed if the Job finished. Otherwise, returns the empty string.
+func jobCondition(job batchv1.Job) batchv1.JobConditionType {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		if condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed {
+			return condition.Type
+		}
+	}
+	return ""
+}
+
+// findRunningRun returns the run id of the run having the Jobs labeled with key which haven't finished yet in namespaces.
+// If multiple runs are found, returns the run which created the latest Job. If no run is found, returns the empty string.
+func findRunningRun(ctx context.Context, clientset kubernetes.Interface, namespaces []string, key string) (string, error) {
+	var running []batchv1.Job
+	for _, namespace := range namespaces {
+		jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", idempotencyKeyLabel, key),
+		})
+		if err != nil {
+			return "", fmt.Errorf("kubetest: failed to list Jobs having idempotency key %q in %s: %w", key, namespace, err)
+		}
+		for _, job := range jobs.Items {
+			if !jobFinished(job) && job.Labels[runIDLabel] != "" {
+				running = append(running, job)
+			}
+		}
+	}
+	if len(running) == 0 {
+		return "", nil
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].CreationTimestamp.After(running[j].CreationTimestamp.Time)
+	})
+	return running[0].Labels[runIDLabel], nil
+}
+
+// runStatusName returns the name of the ConfigMap having the status of the run.
+func runStatusName(runID string) string {
+	return fmt.Sprintf("kubetest-run-%s", runID)
+}
+
+// publishRunStatus creates the ConfigMap having the running status of the run labeled with key,
+// so the run attached to it can read its result published by updateRunStatus.
+// The ConfigMaps of the finished runs having the same key are deleted, so only the last one remains for each key.
+func publishRunStatus(ctx context.Context, clientset kubernetes.Interface, namespace, key, runID string, ownerReference *metav1.OwnerReference) error {
+	client := clientset.CoreV1().ConfigMaps(namespace)
+	selector := fmt.Sprintf("%s=%s", idempotencyKeyLabel, key)
+	finished, err := client.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to list the status of the runs having idempotency key %q: %w", key, err)
+	}
+	for _, configMap := range finished.Items {
+		if configMap.Data[runStatusKey] == runStatusRunning {
+			continue
+		}
+		if err := client.Delete(ctx, configMap.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("kubetest: failed to delete the status of the finished run %s: %w", configMap.Name, err)
+		}
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      runStatusName(runID),
+			Namespace: namespace,
+			Labels: map[string]string{
+				idempotencyKeyLabel: key,
+				runIDLabel:          runID,
+			},
+		},
+		Data: map[string]string{runStatusKey: runStatusRunning},
+	}
+	if ownerReference != nil {
+		configMap.OwnerReferences = []metav1.OwnerReference{*ownerReference}
+	}
+	if _, err := client.Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("kubetest: failed to publish the status of run %s: %w", runID, err)
+	}
+	return nil
+}
+
+// updateRunStatus publishes the final status of the run to the ConfigMap created by publishRunStatus.
+func updateRunStatus(ctx context.Context, clientset kubernetes.Interface, namespace, runID string, status ResultStatus) error {
+	client := clientset.CoreV1().ConfigMaps(namespace)
+	configMap, err := client.Get(ctx, runStatusName(runID), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to get the status of run %s: %w", runID, err)
+	}
+	configMap.Data = map[string]string{runStatusKey: string(status)}
+	if _, err := client.Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("kubetest: failed to publish the status of run %s: %w", runID, err)
+	}
+	return nil
+}
+
+// readRunStatus returns the status of the run published by publishRunStatus and updateRunStatus.
+// If the run didn't publish its status, the result of the run can't be determined and the error is returned.
+func readRunStatus(ctx context.Context, clientset kubernetes.Interface, namespace, runID string) (string, error) {
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, runStatusName(runID), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("kubetest: couldn't determine the result of run %s because it didn't publish its status", runID)
+		}
+		return "", fmt.Errorf("kubetest: failed to get the status of run %s: %w", runID, err)
+	}
+	status := configMap.Data[runStatusKey]
+	switch ResultStatus(status) {
+	case ResultStatusSuccess, ResultStatusFailure, ResultStatusError, ResultStatusSkipped:
+		return status, nil
+	}
+	if status != runStatusRunning {
+		return "", fmt.Errorf("kubetest: unknown status %q of run %s", status, runID)
+	}
+	return status, nil
+}
+
+// hasActiveJobs returns whether the run of runID has the Jobs which haven't finished yet in namespaces.
+func hasActiveJobs(ctx context.Context, clientset kubernetes.Interface, namespaces []string, runID string) (bool, error) {
+	for _, namespace := range namespaces {
+		jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", runIDLabel, runID),
+		})
+		if err != nil {
+			return false, fmt.Errorf("kubetest: failed to list Jobs of run %s in %s: %w", runID, namespace, err)
+		}
+		for _, job := range jobs.Items {
+			if !jobFinished(job) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// attachRun waits until the run of runID publishes its final status to namespace, and returns the report attached to it.
+// The status is the status of the report of the run, so the quarantined tests, the verdict of the finalizer and postSteps are reflected.
+// If the run didn't publish its status, or it has had no active Jobs in namespaces for idempotencyStaleTimeout without publishing its result,
+// the result of the run can't be determined and the error is returned.
+// The results of the tests are reported by the existing run, so the report has no details.
+func (r *Runner) attachRun(ctx context.Context, clientset kubernetes.Interface, namespace string, namespaces []string, runID string, startedAt time.Time) (*Report, error) {
+	r.logger.Info("attach to run %s having the same idempotency key. wait until it finishes", runID)
+	lastActive := time.Now()
+	ticker := time.NewTicker(idempotencyPollInterval)
+	defer ticker.Stop()
+	for {
+		status, err := readRunStatus(ctx, clientset, namespace, runID)
+		if err != nil {
+			return nil, err
+		}
+		if status != runStatusRunning {
+			r.logger.Info("run %s finished with %s. see the report of it for the results", runID, status)
+			return &Report{
+				Status:         ResultStatus(status),
+				StartedAt:      metav1.NewTime(startedAt),
+				ElapsedTimeSec: int64(time.Since(startedAt).Seconds()),
+				Details:        []*ReportDetail{},
+				AttachedRunID:  runID,
+			}, nil
+		}
+		active, err := hasActiveJobs(ctx, clientset, namespaces, runID)
+		if err != nil {
+			return nil, err
+		}
+		if active {
+			lastActive = time.Now()
+		}
+		if time.Since(lastActive) >= idempotencyStaleTimeout {
+			return nil, fmt.Errorf(
+				"kubetest: couldn't determine the result of run %s because it has had no active Jobs for %s without publishing its result. it might have crashed",
+				runID, idempotencyStaleTimeout,
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
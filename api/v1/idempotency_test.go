@@ -0,0 +1,174 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIdempotency(t *testing.T) {
+	newJob := func(name, runID string, createdAt time.Time, finished bool) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				CreationTimestamp: metav1.NewTime(createdAt),
+				Labels: map[string]string{
+					runIDLabel:          runID,
+					idempotencyKeyLabel: "build-1",
+				},
+			},
+		}
+		if finished {
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		}
+		return job
+	}
+	now := time.Now()
+	t.Run("find running run", func(t *testing.T) {
+		shard := newJob("shard", "dddd", now.Add(time.Minute), false)
+		shard.Namespace = "shard-1"
+		clientset := fake.NewSimpleClientset(
+			newJob("finished", "aaaa", now.Add(-time.Hour), true),
+			newJob("old", "bbbb", now.Add(-time.Minute), false),
+			newJob("new", "cccc", now, false),
+		)
+		runID, err := findRunningRun(context.Background(), clientset, []string{"default"}, "build-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runID != "cccc" {
+			t.Fatalf("expected the run of the latest Job but got %q", runID)
+		}
+		runID, err = findRunningRun(context.Background(), clientset, []string{"default"}, "build-2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runID != "" {
+			t.Fatalf("the run having the other key must be ignored but got %q", runID)
+		}
+		clientset = fake.NewSimpleClientset(newJob("finished", "aaaa", now, true), shard)
+		runID, err = findRunningRun(context.Background(), clientset, []string{"default", "shard-1"}, "build-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runID != "dddd" {
+			t.Fatalf("the run having the shards in the other namespace must be found but got %q", runID)
+		}
+	})
+	t.Run("finished runs are ignored", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(newJob("finished", "aaaa", now, true))
+		runID, err := findRunningRun(context.Background(), clientset, []string{"default"}, "build-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runID != "" {
+			t.Fatalf("the finished run must be ignored but got %q", runID)
+		}
+	})
+	t.Run("publish status", func(t *testing.T) {
+		ctx := context.Background()
+		clientset := fake.NewSimpleClientset()
+		if err := publishRunStatus(ctx, clientset, "default", "build-1", "aaaa", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := updateRunStatus(ctx, clientset, "default", "aaaa", ResultStatusFailure); err != nil {
+			t.Fatal(err)
+		}
+		if err := publishRunStatus(ctx, clientset, "default", "build-1", "bbbb", nil); err != nil {
+			t.Fatal(err)
+		}
+		if status, err := readRunStatus(ctx, clientset, "default", "bbbb"); err != nil || status != runStatusRunning {
+			t.Fatalf("unexpected status of the running run: %q, %v", status, err)
+		}
+		if _, err := readRunStatus(ctx, clientset, "default", "aaaa"); err == nil || !strings.Contains(err.Error(), "didn't publish its status") {
+			t.Fatalf("the status of the finished run having the same key must be deleted but got %v", err)
+		}
+	})
+	t.Run("attach", func(t *testing.T) {
+		pollInterval := idempotencyPollInterval
+		idempotencyPollInterval = 10 * time.Millisecond
+		defer func() { idempotencyPollInterval = pollInterval }()
+		var buf bytes.Buffer
+		runner := NewRunner(getConfig(), RunModeKubernetes)
+		runner.SetLogger(NewLogger(&buf, LogLevelInfo))
+		ctx := context.Background()
+		// the Jobs of the run completed, but the run failed by the tests.
+		clientset := fake.NewSimpleClientset(newJob("prestep", "aaaa", now, true))
+		if err := publishRunStatus(ctx, clientset, "default", "build-1", "aaaa", nil); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			if err := updateRunStatus(ctx, clientset, "default", "aaaa", ResultStatusFailure); err != nil {
+				t.Error(err)
+			}
+		}()
+		report, err := runner.attachRun(ctx, clientset, "default", []string{"default"}, "aaaa", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Status != ResultStatusFailure || report.AttachedRunID != "aaaa" {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+		if !strings.Contains(buf.String(), "attach to run aaaa") {
+			t.Fatalf("the attached run must be logged: %s", buf.String())
+		}
+	})
+	t.Run("attach to run without status", func(t *testing.T) {
+		runner := NewRunner(getConfig(), RunModeKubernetes)
+		runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+		clientset := fake.NewSimpleClientset(newJob("running", "aaaa", now, false))
+		if _, err := runner.attachRun(context.Background(), clientset, "default", []string{"default"}, "aaaa", now); err == nil || !strings.Contains(err.Error(), "didn't publish its status") {
+			t.Fatalf("expected the error of the undetermined result but got %v", err)
+		}
+	})
+	t.Run("attach to crashed run", func(t *testing.T) {
+		pollInterval, staleTimeout := idempotencyPollInterval, idempotencyStaleTimeout
+		idempotencyPollInterval, idempotencyStaleTimeout = 10*time.Millisecond, 50*time.Millisecond
+		defer func() {
+			idempotencyPollInterval, idempotencyStaleTimeout = pollInterval, staleTimeout
+		}()
+		runner := NewRunner(getConfig(), RunModeKubernetes)
+		runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+		ctx := context.Background()
+		shard := newJob("shard", "aaaa", now, false)
+		shard.Namespace = "shard-1"
+		clientset := fake.NewSimpleClientset(shard)
+		if err := publishRunStatus(ctx, clientset, "default", "build-1", "aaaa", nil); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			finished := newJob("shard", "aaaa", now, true)
+			finished.Namespace = "shard-1"
+			if _, err := clientset.BatchV1().Jobs("shard-1").UpdateStatus(ctx, finished, metav1.UpdateOptions{}); err != nil {
+				t.Error(err)
+			}
+		}()
+		startedAt := time.Now()
+		_, err := runner.attachRun(ctx, clientset, "default", []string{"default", "shard-1"}, "aaaa", now)
+		if err == nil || !strings.Contains(err.Error(), "might have crashed") {
+			t.Fatalf("expected the error of the crashed run but got %v", err)
+		}
+		if time.Since(startedAt) < 100*time.Millisecond {
+			t.Fatal("the run having the active shard in the other namespace must be waited")
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		if err := NewValidator().ValidateIdempotencyKey("build/1", IdempotencyPolicyRefuse); err == nil {
+			t.Fatal("expected the error of the key which isn't a label value")
+		}
+		if err := NewValidator().ValidateIdempotencyKey("build-1", "wait"); err == nil || !strings.Contains(err.Error(), "unknown idempotency policy") {
+			t.Fatalf("expected the error of the unknown policy but got %v", err)
+		}
+	})
+}
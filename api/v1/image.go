@@ -0,0 +1,355 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	dockerHubRegistry    = "docker.io"
+	dockerHubAPIEndpoint = "registry-1.docker.io"
+)
+
+var (
+	// imageVerifyTimeout timeout for checking the manifest of each image.
+	imageVerifyTimeout = 30 * time.Second
+
+	manifestMediaTypes = []string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}
+
+	authChallengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// prefixedImage prepends prefix ( e.g. the host of pull-through mirror ) to image.
+// If image already has prefix, returns image as it is.
+func prefixedImage(prefix, image string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" || image == "" || strings.HasPrefix(image, prefix+"/") {
+		return image
+	}
+	return prefix + "/" + image
+}
+
+// imageReference the location of image in the registry.
+type imageReference struct {
+	registry   string
+	repository string
+	// reference tag or digest of image.
+	reference string
+}
+
+func parseImageReference(image string) imageReference {
+	name := image
+	reference := "latest"
+	if idx := strings.Index(name, "@"); idx >= 0 {
+		name, reference = name[:idx], name[idx+1:]
+	} else if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		name, reference = name[:idx], name[idx+1:]
+	}
+	registry := dockerHubRegistry
+	if idx := strings.Index(name, "/"); idx >= 0 {
+		if host := name[:idx]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			registry, name = normalizeRegistry(host), name[idx+1:]
+		}
+	}
+	if registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return imageReference{registry: registry, repository: name, reference: reference}
+}
+
+// normalizeRegistry returns the host of registry from the key of docker config ( e.g. https://index.docker.io/v1/ ).
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	if idx := strings.Index(registry, "/"); idx >= 0 {
+		registry = registry[:idx]
+	}
+	switch registry {
+	case "index.docker.io", dockerHubAPIEndpoint:
+		return dockerHubRegistry
+	}
+	return registry
+}
+
+func (r imageReference) manifestURL() string {
+	endpoint := r.registry
+	if endpoint == dockerHubRegistry {
+		endpoint = dockerHubAPIEndpoint
+	}
+	return fmt.Sprintf("https://%s/v2/%s/manifests/%s", endpoint, r.repository, r.reference)
+}
+
+type registryCredential struct {
+	username string
+	password string
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+// registryCredentials reads the credentials of registries from the image pull secrets.
+func registryCredentials(ctx context.Context, clientset kubernetes.Interface, namespace string, secretNames []string) (map[string]registryCredential, error) {
+	creds := map[string]registryCredential{}
+	for _, name := range secretNames {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to read image pull secret %s: %w", name, err)
+		}
+		var auths map[string]dockerConfigEntry
+		if data, exists := secret.Data[corev1.DockerConfigJsonKey]; exists {
+			var cfg dockerConfigJSON
+			if err := json.Unmarshal(data, &cfg); err != nil {
+				return nil, fmt.Errorf("kubetest: failed to decode image pull secret %s: %w", name, err)
+			}
+			auths = cfg.Auths
+		} else if data, exists := secret.Data[corev1.DockerConfigKey]; exists {
+			if err := json.Unmarshal(data, &auths); err != nil {
+				return nil, fmt.Errorf("kubetest: failed to decode image pull secret %s: %w", name, err)
+			}
+		}
+		for registry, entry := range auths {
+			cred := registryCredential{username: entry.Username, password: entry.Password}
+			if entry.Auth != "" {
+				decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+				if err != nil {
+					return nil, fmt.Errorf("kubetest: failed to decode auth of %s in image pull secret %s: %w", registry, name, err)
+				}
+				if username, password, found := strings.Cut(string(decoded), ":"); found {
+					cred = registryCredential{username: username, password: password}
+				}
+			}
+			creds[normalizeRegistry(registry)] = cred
+		}
+	}
+	return creds, nil
+}
+
+// imageVerifier checks that the manifest of image exists by the registry API.
+type imageVerifier struct {
+	client      *http.Client
+	credentials map[string]registryCredential
+}
+
+func newImageVerifier(credentials map[string]registryCredential) *imageVerifier {
+	return &imageVerifier{
+		client:      &http.Client{Timeout: imageVerifyTimeout},
+		credentials: credentials,
+	}
+}
+
+// exists returns whether the manifest of image exists.
+// If the registry requires the authentication, the credential of image pull secrets is used.
+func (v *imageVerifier) exists(ctx context.Context, image string) (bool, error) {
+	ref := parseImageReference(image)
+	cred, hasCred := v.credentials[ref.registry]
+	resp, err := v.headManifest(ctx, ref, "")
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		scheme, params := parseAuthChallenge(resp.Header.Get("WWW-Authenticate"))
+		var authorization string
+		switch strings.ToLower(scheme) {
+		case "bearer":
+			token, err := v.bearerToken(ctx, ref, params, cred, hasCred)
+			if err != nil {
+				return false, err
+			}
+			authorization = "Bearer " + token
+		case "basic":
+			if !hasCred {
+				return false, fmt.Errorf("kubetest: registry %s requires credential. specify imagePullSecrets", ref.registry)
+			}
+			authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(cred.username+":"+cred.password))
+		default:
+			return false, fmt.Errorf("kubetest: unsupported authentication scheme of registry %s: %q", ref.registry, scheme)
+		}
+		resp, err = v.headManifest(ctx, ref, authorization)
+		if err != nil {
+			return false, err
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("kubetest: unexpected status of manifest for %s: %s", image, resp.Status)
+}
+
+func (v *imageVerifier) headManifest(ctx context.Context, ref imageReference, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ref.manifestURL(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to create request for manifest: %w", err)
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ","))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to get manifest from %s: %w", ref.registry, err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func (v *imageVerifier) bearerToken(ctx context.Context, ref imageReference, params map[string]string, cred registryCredential, hasCred bool) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("kubetest: failed to find realm of registry %s", ref.registry)
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: invalid realm of registry %s: %w", ref.registry, err)
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", ref.repository))
+	tokenURL.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to create request for token: %w", err)
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.username, cred.password)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to get token from %s: %w", tokenURL.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("kubetest: failed to get token of registry %s: %s", ref.registry, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("kubetest: failed to decode token of registry %s: %w", ref.registry, err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// parseAuthChallenge parses WWW-Authenticate header ( e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io" ).
+func parseAuthChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for _, match := range authChallengeParamPattern.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	return scheme, params
+}
+
+// testJobImages returns the distinct images used by all steps and the image pull secrets of them.
+// The images are rewritten by imagePrefix in the same way as the built tasks.
+func testJobImages(testjob TestJob) ([]string, []string) {
+	imageMap := map[string]struct{}{}
+	secretMap := map[string]struct{}{}
+	for _, tmpl := range testjob.templates() {
+		containers := append(append([]TestJobContainer{}, tmpl.Spec.InitContainers...), tmpl.Spec.Containers...)
+		if tmpl.Spec.FinalizerContainer.Name != "" {
+			containers = append(containers, tmpl.Spec.FinalizerContainer)
+		}
+		for _, container := range containers {
+			if container.Image == "" {
+				continue
+			}
+			imageMap[prefixedImage(testjob.Spec.ImagePrefix, container.Image)] = struct{}{}
+		}
+		for _, secret := range tmpl.Spec.ImagePullSecrets {
+			secretMap[secret.Name] = struct{}{}
+		}
+	}
+	if strategy := testjob.Spec.MainStep.Strategy; strategy != nil {
+		for _, image := range strategy.Key.Images {
+			imageMap[prefixedImage(testjob.Spec.ImagePrefix, image)] = struct{}{}
+		}
+	}
+	images := make([]string, 0, len(imageMap))
+	for image := range imageMap {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	secrets := make([]string, 0, len(secretMap))
+	for secret := range secretMap {
+		secrets = append(secrets, secret)
+	}
+	sort.Strings(secrets)
+	return images, secrets
+}
+
+// verifyImages checks that all images exist before creating any Job.
+// Returns the error listing all missing images and the images failed to check.
+func verifyImages(ctx context.Context, verifier *imageVerifier, images []string) error {
+	logger := LoggerFromContext(ctx)
+	var (
+		missing []string
+		failed  []string
+	)
+	for _, image := range images {
+		logger.Debug("verify image %s", image)
+		exists, err := verifier.exists(ctx, image)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s ( %s )", image, err))
+			continue
+		}
+		if !exists {
+			missing = append(missing, image)
+		}
+	}
+	if len(missing) == 0 && len(failed) == 0 {
+		return nil
+	}
+	msgs := []string{}
+	if len(missing) != 0 {
+		msgs = append(msgs, fmt.Sprintf("images not found: %s", strings.Join(missing, ", ")))
+	}
+	if len(failed) != 0 {
+		msgs = append(msgs, fmt.Sprintf("failed to verify images: %s", strings.Join(failed, ", ")))
+	}
+	return fmt.Errorf("kubetest: %s. disable verifyImages if the registry doesn't allow checking manifests", strings.Join(msgs, ". "))
+}
+
+func verifyTestJobImages(ctx context.Context, clientset kubernetes.Interface, testjob TestJob) error {
+	images, secrets := testJobImages(testjob)
+	creds, err := registryCredentials(ctx, clientset, testjob.Namespace, secrets)
+	if err != nil {
+		return err
+	}
+	LoggerFromContext(ctx).Info("verify %d images", len(images))
+	return verifyImages(ctx, newImageVerifier(creds), images)
+}
@@ -0,0 +1,156 @@
+package v1
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseImageReference(t *testing.T) {
+	for _, test := range []struct {
+		image    string
+		expected imageReference
+	}{
+		{"alpine", imageReference{"docker.io", "library/alpine", "latest"}},
+		{"golang:1.22", imageReference{"docker.io", "library/golang", "1.22"}},
+		{"goccy/kubetest:v1", imageReference{"docker.io", "goccy/kubetest", "v1"}},
+		{"ghcr.io/goccy/kubetest", imageReference{"ghcr.io", "goccy/kubetest", "latest"}},
+		{"localhost:5000/app:dev", imageReference{"localhost:5000", "app", "dev"}},
+		{"gcr.io/app@sha256:abc", imageReference{"gcr.io", "app", "sha256:abc"}},
+	} {
+		if ref := parseImageReference(test.image); ref != test.expected {
+			t.Fatalf("%s: expected %+v but got %+v", test.image, test.expected, ref)
+		}
+	}
+}
+
+func TestPrefixedImage(t *testing.T) {
+	for _, test := range []struct {
+		prefix   string
+		image    string
+		expected string
+	}{
+		{"", "alpine", "alpine"},
+		{"mirror.example.com", "alpine", "mirror.example.com/alpine"},
+		{"mirror.example.com/", "ghcr.io/goccy/kubetest", "mirror.example.com/ghcr.io/goccy/kubetest"},
+		{"mirror.example.com", "mirror.example.com/alpine", "mirror.example.com/alpine"},
+	} {
+		if image := prefixedImage(test.prefix, test.image); image != test.expected {
+			t.Fatalf("expected %s but got %s", test.expected, image)
+		}
+	}
+}
+
+func TestVerifyImages(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("scope") != "repository:private/app:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "registry-token"})
+		case strings.HasPrefix(r.URL.Path, "/v2/private/"):
+			if r.Header.Get("Authorization") != "Bearer registry-token" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path == "/v2/private/app/manifests/v1" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/v2/public/app/manifests/v1":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pull-secret"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(fmt.Sprintf(
+				`{"auths":{"https://%s":{"auth":"%s"}}}`,
+				host, base64.StdEncoding.EncodeToString([]byte("user:pass")),
+			)),
+		},
+	})
+	creds, err := registryCredentials(ctx, clientset, "default", []string{"pull-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := newImageVerifier(creds)
+	verifier.client = srv.Client()
+
+	if err := verifyImages(ctx, verifier, []string{
+		host + "/public/app:v1",
+		host + "/private/app:v1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	err = verifyImages(ctx, verifier, []string{
+		host + "/public/app:v1",
+		host + "/public/app:typo",
+		host + "/private/app:typo",
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), host+"/public/app:typo, "+host+"/private/app:typo") {
+		t.Fatalf("expected error listing all missing images but got %v", err)
+	}
+
+	verifier.credentials = nil
+	if err := verifyImages(ctx, verifier, []string{host + "/private/app:v1"}); err == nil {
+		t.Fatal("expected error without credential")
+	}
+}
+
+func TestTestJobImages(t *testing.T) {
+	var testjob TestJob
+	testjob.Spec.ImagePrefix = "mirror.example.com"
+	testjob.Spec.PreSteps = []PreStep{
+		{
+			Name: "build",
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					PodSpec:    corev1.PodSpec{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull-secret"}}},
+					Containers: []TestJobContainer{{Container: corev1.Container{Name: "build", Image: "golang"}}},
+				},
+			},
+		},
+	}
+	testjob.Spec.MainStep.Template.Spec = TestJobPodSpec{
+		Containers:         []TestJobContainer{{Container: corev1.Container{Name: "test", Image: "golang"}}},
+		FinalizerContainer: TestJobContainer{Container: corev1.Container{Name: "finalizer", Image: "alpine"}},
+	}
+	images, secrets := testJobImages(testjob)
+	if strings.Join(images, ",") != "mirror.example.com/alpine,mirror.example.com/golang" {
+		t.Fatalf("unexpected images: %v", images)
+	}
+	if strings.Join(secrets, ",") != "pull-secret" {
+		t.Fatalf("unexpected secrets: %v", secrets)
+	}
+}
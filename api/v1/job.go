@@ -4,18 +4,35 @@
 package v1
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/goccy/kubejob"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+	"k8s.io/client-go/util/retry"
 )
 
 type PreInitCallback func(context.Context, JobExecutor) error
@@ -37,34 +54,124 @@ type JobExecutor interface {
 	Container() corev1.Container
 	Pod() *corev1.Pod
 	PrepareCommand(context.Context, []string) ([]byte, error)
+	// TerminatedReason returns the reason why the container was terminated ( e.g. OOMKilled ).
+	// If the container isn't terminated or the reason is unknown, returns empty string.
+	TerminatedReason(context.Context) (string, error)
 }
 
+// defaultPendingTimeout time the pod of the job can be pending by default.
+const defaultPendingTimeout = 10 * time.Minute
+
+// defaultInitLogLimit maximum bytes of the output of the failed init container kept by default.
+const defaultInitLogLimit = 64 * 1024
+
+// defaultPreInitReadyTimeout time to wait until the preinit container accepts the exec API by default.
+const defaultPreInitReadyTimeout = 1 * time.Minute
+
+// preInitReadyInterval interval to check whether the preinit container is ready.
+var preInitReadyInterval = 1 * time.Second
+
+// execRetryInterval interval before retrying the command which couldn't be executed in the container.
+var execRetryInterval = 1 * time.Second
+
+// podQuotaInterval interval to check whether the pod of the job is rejected by the quota of the namespace.
+var podQuotaInterval = 5 * time.Second
+
 type JobBuilder struct {
-	cfg       *rest.Config
-	namespace string
-	runMode   RunMode
-	finalizer *corev1.Container
+	cfg            *rest.Config
+	clientset      kubernetes.Interface
+	namespace      string
+	runMode        RunMode
+	finalizer      *corev1.Container
+	workDir        string
+	copyRetry      CopyRetryPolicy
+	pendingTimeout time.Duration
+	initLogLimit   int
+	preInitReady   time.Duration
+	onSubmit       func(context.Context, *batchv1.Job)
 }
 
 func NewJobBuilder(cfg *rest.Config, namespace string, runMode RunMode) *JobBuilder {
 	return &JobBuilder{
-		cfg:       cfg,
-		namespace: namespace,
-		runMode:   runMode,
+		cfg:            cfg,
+		namespace:      namespace,
+		runMode:        runMode,
+		copyRetry:      defaultCopyRetryPolicy,
+		pendingTimeout: defaultPendingTimeout,
+		initLogLimit:   defaultInitLogLimit,
+		preInitReady:   defaultPreInitReadyTimeout,
 	}
 }
 
+// SetClientset set the clientset to access the pods of the Job and run the commands in its containers.
+// If it isn't set, the clientset is created from the config passed to NewJobBuilder.
+func (b *JobBuilder) SetClientset(clientset kubernetes.Interface) {
+	b.clientset = clientset
+}
+
 func (b *JobBuilder) SetFinalizer(finalizer *corev1.Container) {
 	b.finalizer = finalizer
 }
 
+// SetWorkDir set the directory to create the root directory of the job in local run mode.
+// If empty string is specified, the default directory for temporary files is used.
+func (b *JobBuilder) SetWorkDir(dir string) {
+	b.workDir = dir
+}
+
+// SetCopyRetryPolicy set the policy to retry copying files between local and the container of kubernetes.
+func (b *JobBuilder) SetCopyRetryPolicy(policy CopyRetryPolicy) {
+	b.copyRetry = policy
+}
+
+// SetInitLogLimit set the maximum bytes of the output of the failed init container written to the log.
+// Only the tail of the output is kept. If 0 is specified, the output isn't written.
+func (b *JobBuilder) SetInitLogLimit(limit int) {
+	b.initLogLimit = limit
+}
+
+// SetPreInitReadyTimeout set the time to wait until the preinit container is running and accepts the exec API before copying files to it.
+// If the container isn't ready within this time, the preinit fails. If 0 is specified, the files are copied without waiting.
+func (b *JobBuilder) SetPreInitReadyTimeout(timeout time.Duration) {
+	b.preInitReady = timeout
+}
+
+// SetPendingTimeout set the time the pod of the job can be pending.
+// If the pod doesn't start running within this time, running the job fails with kubejob.PendingPhaseTimeoutError.
+func (b *JobBuilder) SetPendingTimeout(timeout time.Duration) {
+	b.pendingTimeout = timeout
+}
+
+// SetSubmitHandler set the function called once with the Job submitted to kubernetes.
+// The Job has the labels, the init containers, the finalizer and the command wrapper added by kubejob.
+// It's called only in kubernetes run mode because the Job isn't submitted in the other modes.
+func (b *JobBuilder) SetSubmitHandler(handler func(context.Context, *batchv1.Job)) {
+	b.onSubmit = handler
+}
+
 func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job, containerNameToInstalledPathMap map[string]string, sharedAgentSpec *TestAgentSpec) (Job, error) {
 	switch b.runMode {
 	case RunModeKubernetes:
-		job, err := kubejob.NewJobBuilder(b.cfg, b.namespace).BuildWithJob(jobSpec)
+		aborter := newWatchAborter()
+		job, err := kubejob.NewJobBuilder(aborter.wrapConfig(b.cfg), b.namespace).BuildWithJob(jobSpec)
 		if err != nil {
 			return nil, err
 		}
+		// label the job by the same label as its pod to find the job created with the generated name.
+		labels := map[string]string{}
+		for k, v := range job.Labels {
+			labels[k] = v
+		}
+		labels[kubejob.SelectorLabel] = job.Spec.Template.Labels[kubejob.SelectorLabel]
+		job.Labels = labels
+		clientset := b.clientset
+		if clientset == nil {
+			newClientset, err := kubernetes.NewForConfig(b.cfg)
+			if err != nil {
+				return nil, err
+			}
+			clientset = newClientset
+		}
 		var agentConfig *kubejob.AgentConfig
 		if sharedAgentSpec != nil {
 			cfg, err := kubejob.NewAgentConfig(containerNameToInstalledPathMap)
@@ -87,9 +194,20 @@ func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job, containerNameToInstalled
 			job.UseAgent(cfg)
 			agentConfig = cfg
 		}
-		return newKubernetesJob(job, b.finalizer, agentConfig), nil
+		k8sJob := newKubernetesJob(job, clientset.CoreV1().Pods(b.namespace), b.namespace, b.finalizer, agentConfig)
+		k8sJob.copyRetry = b.copyRetry
+		k8sJob.pendingTimeout = b.pendingTimeout
+		k8sJob.initLogLimit = b.initLogLimit
+		k8sJob.preInitReady = b.preInitReady
+		k8sJob.cfg = b.cfg
+		k8sJob.restClient = clientset.CoreV1().RESTClient()
+		k8sJob.jobClient = clientset.BatchV1().Jobs(b.namespace)
+		k8sJob.eventClient = clientset.CoreV1().Events(b.namespace)
+		k8sJob.aborter = aborter
+		k8sJob.onSubmit = b.onSubmit
+		return k8sJob, nil
 	case RunModeLocal:
-		rootDir, err := os.MkdirTemp("", "root")
+		rootDir, err := os.MkdirTemp(b.workDir, "root")
 		if err != nil {
 			return nil, fmt.Errorf("kubetest: failed to create working directory for running on local file system")
 		}
@@ -101,20 +219,49 @@ func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job, containerNameToInstalled
 }
 
 type kubernetesJob struct {
-	job           *kubejob.Job
-	finalizer     *corev1.Container
-	agentConfig   *kubejob.AgentConfig
-	mountCallback func(context.Context, JobExecutor, bool) error
+	job            *kubejob.Job
+	podClient      typedcorev1.PodInterface
+	namespace      string
+	finalizer      *corev1.Container
+	agentConfig    *kubejob.AgentConfig
+	copyRetry      CopyRetryPolicy
+	pendingTimeout time.Duration
+	initLogLimit   int
+	preInitReady   time.Duration
+	cfg            *rest.Config
+	restClient     rest.Interface
+	jobClient      typedbatchv1.JobInterface
+	eventClient    typedcorev1.EventInterface
+	aborter        *watchAborter
+	mountCallback  func(context.Context, JobExecutor, bool) error
+	onSubmit       func(context.Context, *batchv1.Job)
+	submitOnce     sync.Once
 }
 
 var defaultMountCallback = func(context.Context, JobExecutor, bool) error { return nil }
 
-func newKubernetesJob(job *kubejob.Job, finalizer *corev1.Container, agentConfig *kubejob.AgentConfig) *kubernetesJob {
+func newKubernetesJob(job *kubejob.Job, podClient typedcorev1.PodInterface, namespace string, finalizer *corev1.Container, agentConfig *kubejob.AgentConfig) *kubernetesJob {
 	return &kubernetesJob{
-		job:           job,
-		finalizer:     finalizer,
-		agentConfig:   agentConfig,
-		mountCallback: defaultMountCallback,
+		job:            job,
+		podClient:      podClient,
+		namespace:      namespace,
+		finalizer:      finalizer,
+		agentConfig:    agentConfig,
+		copyRetry:      defaultCopyRetryPolicy,
+		pendingTimeout: defaultPendingTimeout,
+		initLogLimit:   defaultInitLogLimit,
+		preInitReady:   defaultPreInitReadyTimeout,
+		mountCallback:  defaultMountCallback,
+	}
+}
+
+func (j *kubernetesJob) newExecutor(exec *kubejob.JobExecutor) *kubernetesJobExecutor {
+	return &kubernetesJobExecutor{
+		exec:       exec,
+		podClient:  j.podClient,
+		copyRetry:  j.copyRetry,
+		cfg:        j.cfg,
+		restClient: j.restClient,
 	}
 }
 
@@ -124,53 +271,410 @@ func (j *kubernetesJob) Spec() batchv1.JobSpec {
 
 func (j *kubernetesJob) PreInit(c TestJobContainer, cb PreInitCallback) {
 	j.job.PreInit(c.Container, func(ctx context.Context, exec *kubejob.JobExecutor) error {
-		return cb(ctx, &kubernetesJobExecutor{exec: exec})
+		e := j.newExecutor(exec)
+		if err := waitContainerReady(ctx, j.podClient, e, j.preInitReady); err != nil {
+			return err
+		}
+		return cb(ctx, e)
 	})
 }
 
+// waitContainerReady waits until the init container of exec is running and accepts the exec API.
+// The container may not be ready on the slow node although kubejob observed it running, so the copies to it fail transiently.
+func waitContainerReady(ctx context.Context, podClient typedcorev1.PodInterface, exec JobExecutor, timeout time.Duration) error {
+	if timeout <= 0 || podClient == nil || exec.Pod() == nil {
+		return nil
+	}
+	name := exec.Container().Name
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var lastErr error
+	for {
+		lastErr = containerReady(ctx, podClient, exec)
+		if lastErr == nil {
+			return nil
+		}
+		LoggerFromContext(ctx).Debug("wait until %s container is ready: %s", name, lastErr)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("kubetest: %s container isn't ready within %s: %w", name, timeout, lastErr)
+		case <-time.After(preInitReadyInterval):
+		}
+	}
+}
+
+// containerReady returns nil if the init container of exec is running and the command can be executed in it.
+func containerReady(ctx context.Context, podClient typedcorev1.PodInterface, exec JobExecutor) error {
+	name := exec.Container().Name
+	pod, err := podClient.Get(ctx, exec.Pod().Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	running := false
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name == name && status.State.Running != nil {
+			running = true
+		}
+	}
+	if !running {
+		return fmt.Errorf("%s container isn't running", name)
+	}
+	if out, err := exec.PrepareCommand(ctx, []string{"true"}); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	return nil
+}
+
 func (j *kubernetesJob) Mount(cb func(context.Context, JobExecutor, bool) error) {
 	j.mountCallback = cb
 }
 
 func (j *kubernetesJob) RunWithExecutionHandler(ctx context.Context, handler func(context.Context, []JobExecutor) error, finalizerHandler func(context.Context, JobExecutor) error) error {
+	logger := LoggerFromContext(ctx)
 	j.job.DisableInitContainerLog()
-	j.job.SetPendingPhaseTimeout(10 * time.Minute)
+	j.job.SetPendingPhaseTimeout(j.pendingTimeout)
 	j.job.SetInitContainerExecutionHandler(func(ctx context.Context, exec *kubejob.JobExecutor) error {
-		e := &kubernetesJobExecutor{exec: exec}
+		e := j.newExecutor(exec)
 		if err := j.mountCallback(ctx, e, true); err != nil {
-			return err
+			return setupError(exec.Container.Name, "mounting volumes", err)
 		}
-		_, err := exec.ExecOnly(ctx)
-		return err
+		// keep only the tail of the output so that verbose init containers of many shards don't exhaust memory.
+		tail := newTailBuffer(j.initLogLimit)
+		if err := e.outputTo(ctx, tail); err != nil {
+			logger.Error("init container %s failed: %s: output: %s", exec.Container.Name, err, tail.String())
+			return setupError(exec.Container.Name, "running init container", err)
+		}
+		return nil
 	})
+	// setupErr error of mounting the volumes of the main containers.
+	// kubejob runs the finalizer even if it failed, so the finalizer runs in degraded mode.
+	var setupErr error
 	var finalizer *kubejob.JobFinalizer
 	if j.finalizer != nil {
 		finalizer = &kubejob.JobFinalizer{
 			Container: *j.finalizer,
 			Handler: func(ctx context.Context, exec *kubejob.JobExecutor) error {
-				return finalizerHandler(ctx, &kubernetesJobExecutor{exec: exec})
+				// kubejob runs the finalizer with the new context, so the logger of the run is set again.
+				ctx = withFinalizerSetupError(WithLogger(ctx, logger), setupErr)
+				if err := finalizerHandler(ctx, j.newExecutor(exec)); err != nil {
+					return &ContainerPhaseError{Phase: ContainerPhaseFinalizer, Container: exec.Container.Name, Err: err}
+				}
+				return nil
 			},
 		}
 	}
-	return j.job.RunWithExecutionHandler(ctx, func(ctx context.Context, execs []*kubejob.JobExecutor) error {
+	// the job is created in kubejob.Job.RunWithExecutionHandler, so record the job name after it returns
+	// even if it failed after creating the job.
+	defer j.recordJob(ctx, "")
+	// kubejob starts the pending timeout after the pod is created, so it waits forever for the job whose pod is rejected by the quota.
+	// The waiting is aborted to return the error retried after the quota of the namespace is freed.
+	quotaCtx, stopQuota := context.WithCancel(ctx)
+	defer stopQuota()
+	quotaErrCh := make(chan error, 1)
+	if j.aborter != nil {
+		defer j.aborter.abort()
+		selector := fmt.Sprintf("%s=%s", kubejob.SelectorLabel, j.job.Spec.Template.Labels[kubejob.SelectorLabel])
+		go func() {
+			err := j.waitPodQuotaExceeded(quotaCtx, selector)
+			if err != nil {
+				j.aborter.abort()
+			}
+			quotaErrCh <- err
+		}()
+	} else {
+		quotaErrCh <- nil
+	}
+	err := j.job.RunWithExecutionHandler(ctx, func(ctx context.Context, execs []*kubejob.JobExecutor) error {
+		var jobUID types.UID
+		if len(execs) != 0 {
+			jobUID = jobUIDFromPod(execs[0].Pod)
+		}
+		j.recordJob(ctx, jobUID)
+		j.submitted(ctx)
 		converted := make([]JobExecutor, 0, len(execs))
 		for _, exec := range execs {
-			e := &kubernetesJobExecutor{exec: exec}
+			j.recordPod(ctx, exec.Pod)
+			e := j.newExecutor(exec)
 			if err := j.mountCallback(ctx, e, false); err != nil {
-				return err
+				setupErr = setupError(exec.Container.Name, "mounting volumes", err)
+				return setupErr
 			}
 			converted = append(converted, e)
 		}
 		return handler(ctx, converted)
 	}, finalizer)
+	// kubejob returns JobCreationError only if it couldn't create the job, so the job was submitted for the other errors
+	// even if its pod didn't run.
+	var creationErr *kubejob.JobCreationError
+	if !errors.As(err, &creationErr) {
+		j.submitted(ctx)
+	}
+	stopQuota()
+	if quotaErr := <-quotaErrCh; quotaErr != nil {
+		return quotaErr
+	}
+	return err
+}
+
+// PodQuotaExceededError is returned when the job controller couldn't create the pod of the job
+// because ResourceQuota of the namespace is exhausted.
+type PodQuotaExceededError struct {
+	// Job name of the job.
+	Job string
+	// Message message of the FailedCreate event of the job.
+	Message string
+}
+
+func (e *PodQuotaExceededError) Error() string {
+	return fmt.Sprintf("kubetest: failed to create the pod of job %s: %s", e.Job, e.Message)
+}
+
+// waitPodQuotaExceeded waits until the FailedCreate event caused by the quota of the namespace is recorded to the job selected by selector,
+// deletes the job and returns PodQuotaExceededError. If the pod of the job is created or ctx is done, returns nil.
+func (j *kubernetesJob) waitPodQuotaExceeded(ctx context.Context, selector string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(podQuotaInterval):
+		}
+		pods, err := j.podClient.List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			LoggerFromContext(ctx).Debug("failed to list pods to check the quota: %s", err)
+			continue
+		}
+		if len(pods.Items) != 0 {
+			return nil
+		}
+		jobs, err := j.jobClient.List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			LoggerFromContext(ctx).Debug("failed to list jobs to check the quota: %s", err)
+			continue
+		}
+		for _, job := range jobs.Items {
+			events, err := j.eventClient.List(ctx, metav1.ListOptions{
+				FieldSelector: fields.Set{"involvedObject.uid": string(job.UID), "reason": "FailedCreate"}.String(),
+			})
+			if err != nil {
+				LoggerFromContext(ctx).Debug("failed to list events of job %s to check the quota: %s", job.Name, err)
+				continue
+			}
+			for _, event := range events.Items {
+				if event.InvolvedObject.UID != job.UID || event.Reason != "FailedCreate" {
+					continue
+				}
+				if !strings.Contains(event.Message, "exceeded quota") {
+					continue
+				}
+				// the job controller keeps creating the pod of the rejected job, so it's deleted before the job is created again.
+				if err := j.deleteRejectedJob(ctx, job); err != nil {
+					LoggerFromContext(ctx).Warn("failed to delete job %s rejected by the quota: %s", job.Name, err)
+					continue
+				}
+				return &PodQuotaExceededError{Job: job.Name, Message: event.Message}
+			}
+		}
+	}
+}
+
+// deleteRejectedJob deletes the job whose pod was rejected by the quota.
+// The UID precondition avoids deleting the other job created with the same name.
+func (j *kubernetesJob) deleteRejectedJob(ctx context.Context, job batchv1.Job) error {
+	propagation := metav1.DeletePropagationBackground
+	err := j.jobClient.Delete(ctx, job.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+		Preconditions:     metav1.NewUIDPreconditions(string(job.UID)),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// watchAborter aborts the watch requests sent by the clients created from the config wrapped by it.
+// kubejob watches the pods of the job with the context never canceled, so this is the only way to stop waiting for the job.
+type watchAborter struct {
+	ctx   context.Context
+	abort context.CancelFunc
+}
+
+func newWatchAborter() *watchAborter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &watchAborter{ctx: ctx, abort: cancel}
+}
+
+// wrapConfig returns the copy of cfg whose watch requests are aborted by a.
+func (a *watchAborter) wrapConfig(cfg *rest.Config) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Query().Get("watch") != "true" {
+				return rt.RoundTrip(req)
+			}
+			// the response body is read after returning, so the context is canceled only when it's aborted.
+			ctx, cancel := context.WithCancel(req.Context())
+			stop := context.AfterFunc(a.ctx, cancel)
+			resp, err := rt.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				stop()
+				cancel()
+			}
+			return resp, err
+		})
+	})
+	return cfg
+}
+
+// submitted passes the Job created by kubejob to the submit handler once.
+// kubejob sets the generated name to the Job after creating it, so the Job has the name of the created object.
+func (j *kubernetesJob) submitted(ctx context.Context) {
+	if j.onSubmit == nil {
+		return
+	}
+	j.submitOnce.Do(func() {
+		j.onSubmit(ctx, j.job.Job)
+	})
+}
+
+func (j *kubernetesJob) recordJob(ctx context.Context, uid types.UID) {
+	recordObject(ctx, ReportObject{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Namespace:  j.namespace,
+		Name:       j.job.Name,
+		UID:        uid,
+	})
+}
+
+func (j *kubernetesJob) recordPod(ctx context.Context, pod *corev1.Pod) {
+	if pod == nil {
+		return
+	}
+	recordObject(ctx, ReportObject{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	})
+}
+
+// jobUIDFromPod returns the UID of the Job owning the pod.
+// kubejob doesn't keep the created Job, so the UID is taken from the owner reference of the pod.
+func jobUIDFromPod(pod *corev1.Pod) types.UID {
+	if pod == nil {
+		return ""
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "Job" {
+			return ref.UID
+		}
+	}
+	return ""
 }
 
 type kubernetesJobExecutor struct {
-	exec *kubejob.JobExecutor
+	exec       *kubejob.JobExecutor
+	podClient  typedcorev1.PodInterface
+	copyRetry  CopyRetryPolicy
+	cfg        *rest.Config
+	restClient rest.Interface
 }
 
+// PrepareCommand runs cmd in the container.
+// kubejob refuses to run the command after the main command started ( e.g. to collect diagnostics of the failed test ),
+// so the command is executed by exec API directly in that case.
 func (e *kubernetesJobExecutor) PrepareCommand(ctx context.Context, cmd []string) ([]byte, error) {
-	return e.exec.ExecPrepareCommand(ctx, []string{"sh", "-c", strings.Join(cmd, " ")})
+	shellCmd := []string{"sh", "-c", strings.Join(cmd, " ")}
+	if e.exec.IsRunning() && e.restClient != nil {
+		return e.execDirect(ctx, shellCmd)
+	}
+	return e.exec.ExecPrepareCommand(ctx, shellCmd)
+}
+
+func (e *kubernetesJobExecutor) execDirect(ctx context.Context, cmd []string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	if err := e.stream(ctx, cmd, &stdout, &stderr); err != nil {
+		return append(stdout.Bytes(), stderr.Bytes()...), err
+	}
+	return append(stdout.Bytes(), stderr.Bytes()...), nil
+}
+
+// outputTo runs the command of the container and writes its output to w while it runs.
+// ExecOnly of kubejob returns the whole output kept in memory, so the command is executed by exec API directly
+// with the same shell command as kubejob. kubejob-agent returns the whole output, so it's written after the command finished.
+func (e *kubernetesJobExecutor) outputTo(ctx context.Context, w io.Writer) error {
+	if e.exec.EnabledAgent() || e.restClient == nil {
+		out, err := e.exec.ExecOnly(ctx)
+		_, _ = w.Write(out)
+		return err
+	}
+	cmd := append(append([]string{}, e.exec.Container.Command...), e.exec.Container.Args...)
+	shellCmd := []string{"sh", "-c", kubejobShellCommand(cmd)}
+	var err error
+	for retry := 0; retry <= kubejob.ExecRetryCount; retry++ {
+		if retry > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(execRetryInterval):
+			}
+		}
+		err = e.stream(ctx, shellCmd, w, w)
+		var exitErr utilexec.ExitError
+		if err == nil || errors.As(err, &exitErr) {
+			return err
+		}
+		LoggerFromContext(ctx).Debug("failed to run command in %s container. retry: %d/%d: %s", e.exec.Container.Name, retry, kubejob.ExecRetryCount, err)
+	}
+	return err
+}
+
+// kubejobShellCommand returns the shell command to run cmd in the same way as kubejob.
+// The arguments having the white space are passed by the variables to keep them as is.
+func kubejobShellCommand(cmd []string) string {
+	normalized := make([]string, 0, len(cmd))
+	var vars []string
+	for idx, c := range cmd {
+		c = strings.Trim(c, " ")
+		if strings.Contains(c, " ") {
+			vars = append(vars, fmt.Sprintf("VAR%d=$(cat <<-'EOS'\n%s\nEOS\n)", idx, c))
+			normalized = append(normalized, fmt.Sprintf(`"$VAR%d"`, idx))
+		} else {
+			normalized = append(normalized, c)
+		}
+	}
+	if len(vars) == 0 {
+		return strings.Join(normalized, " ")
+	}
+	return fmt.Sprintf("%s; %s", strings.Join(vars, ";"), strings.Join(normalized, " "))
+}
+
+// stream runs cmd in the container by exec API and writes the output to stdout and stderr while it runs.
+func (e *kubernetesJobExecutor) stream(ctx context.Context, cmd []string, stdout, stderr io.Writer) error {
+	pod := e.exec.Pod
+	req := e.restClient.Post().
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: e.exec.Container.Name,
+			Command:   cmd,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(e.cfg, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to create executor for %s pod: %w", pod.Name, err)
+	}
+	if err := exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: stdout,
+		Stderr: stderr,
+	}); err != nil {
+		return fmt.Errorf("kubetest: failed to run command in %s pod: %w", pod.Name, err)
+	}
+	return nil
 }
 
 func (e *kubernetesJobExecutor) Output(ctx context.Context) ([]byte, error) {
@@ -200,14 +704,38 @@ func (e *kubernetesJobExecutor) CopyFrom(ctx context.Context, src string, dst st
 	containerName := e.exec.Container.Name
 	addr := e.exec.Pod.Status.PodIP
 	LoggerFromContext(ctx).Debug("copy from %s on container(%s) in %s pod to %s on local by %s", src, containerName, addr, dst, e.execProtocol())
-	return e.exec.CopyFromPod(ctx, src, dst)
+	// the size of src isn't got before the copy because it needs the extra command executed in the container for every copy.
+	progress := startCopyProgress(ctx, fmt.Sprintf("%s from %s", src, e.exec.Pod.Name), unknownCopySize, func(context.Context) (int64, error) {
+		return localSize(dst)
+	})
+	defer progress.stop(ctx)
+	return retryCopy(ctx, e.copyRetry, fmt.Sprintf("%s from %s", src, e.exec.Pod.Name), func() error {
+		return e.exec.CopyFromPod(ctx, src, dst)
+	})
 }
 
 func (e *kubernetesJobExecutor) CopyTo(ctx context.Context, src string, dst string) error {
 	containerName := e.exec.Container.Name
 	addr := e.exec.Pod.Status.PodIP
 	LoggerFromContext(ctx).Debug("copy from %s on local to %s on container(%s) in %s pod by %s", src, dst, containerName, addr, e.execProtocol())
-	return e.exec.CopyToPod(ctx, src, dst)
+	total, _ := localSize(src)
+	progress := startCopyProgress(ctx, fmt.Sprintf("%s to %s", src, e.exec.Pod.Name), total, func(ctx context.Context) (int64, error) {
+		return e.remoteSize(ctx, dst)
+	})
+	defer progress.stop(ctx)
+	return retryCopy(ctx, e.copyRetry, fmt.Sprintf("%s to %s", src, e.exec.Pod.Name), func() error {
+		return e.exec.CopyToPod(ctx, src, dst)
+	})
+}
+
+// remoteSize returns the approximate size of path on the container.
+func (e *kubernetesJobExecutor) remoteSize(ctx context.Context, path string) (int64, error) {
+	// PrepareCommand runs the command by the shell, so the path having the white space is quoted.
+	out, err := e.PrepareCommand(ctx, []string{"du", "-sk", shellQuote(path)})
+	if err != nil {
+		return 0, fmt.Errorf("kubetest: failed to get size of %s: %w", path, err)
+	}
+	return parseDiskUsage(out)
 }
 
 func (e *kubernetesJobExecutor) Container() corev1.Container {
@@ -218,6 +746,66 @@ func (e *kubernetesJobExecutor) Pod() *corev1.Pod {
 	return e.exec.Pod
 }
 
+// TerminatedReason gets the latest status of the pod because the status kept by the executor is the one at the time the pod started.
+func (e *kubernetesJobExecutor) TerminatedReason(ctx context.Context) (string, error) {
+	if e.podClient == nil || e.exec.Pod == nil {
+		return "", nil
+	}
+	pod, err := e.podClient.Get(ctx, e.exec.Pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to get pod %s: %w", e.exec.Pod.Name, err)
+	}
+	return terminatedReason(pod, e.exec.Container.Name), nil
+}
+
+// AddDebugContainer adds the ephemeral container sharing the process namespace of the container to the pod.
+// The ephemeral containers of the pod are updated by the subresource, so the conflict with the other failed test of the same pod is retried.
+func (e *kubernetesJobExecutor) AddDebugContainer(ctx context.Context, image string, command []string) (string, error) {
+	if e.podClient == nil || e.exec.Pod == nil {
+		return "", fmt.Errorf("kubetest: pod of container %s is unknown", e.exec.Container.Name)
+	}
+	var name string
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := e.podClient.Get(ctx, e.exec.Pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		name = fmt.Sprintf("kubetest-debug-%d", len(pod.Spec.EphemeralContainers))
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+				Name:    name,
+				Image:   image,
+				Command: command,
+				Stdin:   true,
+				TTY:     true,
+			},
+			TargetContainerName: e.exec.Container.Name,
+		})
+		_, err = e.podClient.UpdateEphemeralContainers(ctx, pod.Name, pod, metav1.UpdateOptions{})
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("kubetest: failed to add debug container to pod %s: %w", e.exec.Pod.Name, err)
+	}
+	return name, nil
+}
+
+// terminatedReason returns the reason of the last termination of the container in the pod.
+func terminatedReason(pod *corev1.Pod, containerName string) string {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.Name != containerName {
+			continue
+		}
+		if status.State.Terminated != nil {
+			return status.State.Terminated.Reason
+		}
+		if status.LastTerminationState.Terminated != nil {
+			return status.LastTerminationState.Terminated.Reason
+		}
+	}
+	return ""
+}
+
 type localJob struct {
 	rootDir          string
 	preInitContainer corev1.Container
@@ -250,51 +838,164 @@ func (j *localJob) Mount(cb func(context.Context, JobExecutor, bool) error) {
 }
 
 func (j *localJob) RunWithExecutionHandler(ctx context.Context, handler func(context.Context, []JobExecutor) error, finalizer func(context.Context, JobExecutor) error) error {
+	noFile, err := j.ulimitNoFile()
+	if err != nil {
+		return err
+	}
 	preInitNameToPath := map[string]string{}
 	if j.preInitCallback != nil {
-		j.preInitCallback(ctx, &localJobExecutor{
-			rootDir:   j.rootDir,
-			container: j.preInitContainer,
-		})
+		preInitContainer, err := j.mountScratch(j.preInitContainer)
+		if err != nil {
+			return err
+		}
+		j.preInitCallback(ctx, j.newExecutor(preInitContainer, noFile))
 		for _, vm := range j.preInitContainer.VolumeMounts {
 			preInitNameToPath[vm.Name] = filepath.Join(j.rootDir, vm.MountPath)
 		}
 	}
+	execs, err := j.prepareExecutors(ctx, noFile)
+	if err != nil {
+		// the pod of the local job is always runnable, so the finalizer runs with the containers prepared so far
+		// to release the resources acquired outside kubetest.
+		if finalizerErr := j.runFinalizer(withFinalizerSetupError(ctx, err), finalizer, noFile); finalizerErr != nil {
+			LoggerFromContext(ctx).Warn("failed to run finalizer in degraded mode: %s", finalizerErr)
+		}
+		return err
+	}
+	if err := handler(ctx, execs); err != nil {
+		return err
+	}
+	return j.runFinalizer(ctx, finalizer, noFile)
+}
+
+// prepareExecutors creates the directories of the containers and mounts the volumes to them.
+func (j *localJob) prepareExecutors(ctx context.Context, noFile int64) ([]JobExecutor, error) {
 	execs := make([]JobExecutor, 0, len(j.job.Spec.Template.Spec.Containers))
 	for _, container := range j.job.Spec.Template.Spec.Containers {
 		if err := os.MkdirAll(filepath.Join(j.rootDir, container.WorkingDir), 0755); err != nil {
-			return err
+			return nil, err
 		}
-		e := &localJobExecutor{
-			rootDir:   j.rootDir,
-			container: container,
+		container, err := j.mountScratch(container)
+		if err != nil {
+			return nil, err
 		}
+		container = j.localCompressedFilePaths(container)
+		e := j.newExecutor(container, noFile)
 		if err := j.mountCallback(ctx, e, false); err != nil {
-			return err
+			return nil, setupError(container.Name, "mounting volumes", err)
 		}
 		execs = append(execs, e)
 	}
-	if err := handler(ctx, execs); err != nil {
+	return execs, nil
+}
+
+func (j *localJob) runFinalizer(ctx context.Context, finalizer func(context.Context, JobExecutor) error, noFile int64) error {
+	if j.finalizer == nil {
+		return nil
+	}
+	container, err := j.mountScratch(*j.finalizer)
+	if err != nil {
 		return err
 	}
-	if j.finalizer != nil {
-		if err := finalizer(ctx, &localJobExecutor{
-			rootDir:   j.rootDir,
-			container: *j.finalizer,
-		}); err != nil {
-			return err
-		}
+	container, err = j.localVerdictPath(container)
+	if err != nil {
+		return err
+	}
+	container = j.localCompressedFilePaths(container)
+	if err := finalizer(ctx, j.newExecutor(container, noFile)); err != nil {
+		return &ContainerPhaseError{Phase: ContainerPhaseFinalizer, Container: container.Name, Err: err}
 	}
 	return nil
 }
 
+// ulimitNoFile returns the maximum number of the open file descriptors of the commands specified by ulimits of the template.
+// If it isn't specified, returns 0.
+func (j *localJob) ulimitNoFile() (int64, error) {
+	value, exists := j.job.Spec.Template.Annotations[ulimitNoFileAnnotation]
+	if !exists {
+		return 0, nil
+	}
+	noFile, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || noFile <= 0 {
+		return 0, fmt.Errorf("kubetest: invalid %s annotation %q", ulimitNoFileAnnotation, value)
+	}
+	return noFile, nil
+}
+
+func (j *localJob) newExecutor(container corev1.Container, noFile int64) *localJobExecutor {
+	return &localJobExecutor{
+		rootDir:   j.rootDir,
+		container: container,
+		noFile:    noFile,
+	}
+}
+
+// mountScratch maps the scratch volume to the directory of each container under rootDir.
+// TMPDIR pointing to the mount path is rewritten to the directory, because the local process can't see the mount path.
+func (j *localJob) mountScratch(container corev1.Container) (corev1.Container, error) {
+	for _, vm := range container.VolumeMounts {
+		if vm.Name != scratchVolumeName {
+			continue
+		}
+		dir := filepath.Join(j.rootDir, scratchVolumeName, container.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return container, fmt.Errorf("kubetest: failed to create scratch directory: %w", err)
+		}
+		env := make([]corev1.EnvVar, 0, len(container.Env))
+		for _, e := range container.Env {
+			if e.Name == scratchEnvName && e.Value == vm.MountPath {
+				e.Value = dir
+			}
+			env = append(env, e)
+		}
+		container.Env = env
+	}
+	return container, nil
+}
+
+// localCompressedFilePaths rewrites the paths to the compressed log and report to the paths under rootDir,
+// because they are copied under rootDir as well as the other mount paths.
+func (j *localJob) localCompressedFilePaths(container corev1.Container) corev1.Container {
+	env := make([]corev1.EnvVar, 0, len(container.Env))
+	for _, e := range container.Env {
+		if e.Name == logPathEnvName || e.Name == reportPathEnvName {
+			e.Value = filepath.Join(j.rootDir, e.Value)
+		}
+		env = append(env, e)
+	}
+	container.Env = env
+	return container
+}
+
+// localVerdictPath rewrites the path to write the verdict of the finalizer to the path under rootDir.
+func (j *localJob) localVerdictPath(container corev1.Container) (corev1.Container, error) {
+	env := make([]corev1.EnvVar, 0, len(container.Env))
+	for _, e := range container.Env {
+		if e.Name == finalizerVerdictEnv {
+			e.Value = filepath.Join(j.rootDir, e.Value)
+			if err := os.MkdirAll(filepath.Dir(e.Value), 0755); err != nil {
+				return container, fmt.Errorf("kubetest: failed to create directory for the verdict: %w", err)
+			}
+		}
+		env = append(env, e)
+	}
+	container.Env = env
+	return container, nil
+}
+
 type localJobExecutor struct {
 	rootDir   string
 	container corev1.Container
 	finalizer *corev1.Container
+	// noFile maximum number of the open file descriptors of the commands. If 0, the limit of kubetest is inherited.
+	noFile int64
 }
 
 func (e *localJobExecutor) cmd(cmdarr []string) (*exec.Cmd, error) {
+	if e.noFile > 0 {
+		// exec.Cmd can't set the resource limits of the child process, so the shell sets them before running the command.
+		cmdarr = append([]string{"sh", "-c", fmt.Sprintf(`ulimit -n %d && exec "$@"`, e.noFile), "sh"}, cmdarr...)
+	}
 	var cmd *exec.Cmd
 	if len(cmdarr) == 1 {
 		cmd = exec.Command(cmdarr[0])
@@ -362,7 +1063,10 @@ func (e *localJobExecutor) Stop(_ context.Context) error {
 }
 
 func (e *localJobExecutor) CopyFrom(ctx context.Context, src string, dst string) error {
-	src = filepath.Join(e.rootDir, src)
+	src, err := pathInRootDir(e.rootDir, src)
+	if err != nil {
+		return err
+	}
 	if filepath.Base(src) != filepath.Base(dst) {
 		dst = filepath.Join(dst, filepath.Base(src))
 	}
@@ -370,11 +1074,14 @@ func (e *localJobExecutor) CopyFrom(ctx context.Context, src string, dst string)
 		return err
 	}
 	LoggerFromContext(ctx).Debug("copy from %s on local to %s on local", src, dst)
-	return localCopy(src, dst)
+	return localCopyWithProgress(ctx, src, dst)
 }
 
 func (e *localJobExecutor) CopyTo(ctx context.Context, src string, dst string) error {
-	dst = filepath.Join(e.rootDir, dst)
+	dst, err := pathInRootDir(e.rootDir, dst)
+	if err != nil {
+		return err
+	}
 	if filepath.Base(src) != filepath.Base(dst) {
 		dst = filepath.Join(dst, filepath.Base(src))
 	}
@@ -382,7 +1089,41 @@ func (e *localJobExecutor) CopyTo(ctx context.Context, src string, dst string) e
 		return err
 	}
 	LoggerFromContext(ctx).Debug("copy from %s on local to %s on local", src, dst)
-	return localCopy(src, dst)
+	return localCopyWithProgress(ctx, src, dst)
+}
+
+// pathInRootDir returns the path on the local file system for the path in the container.
+// If the path escapes rootDir by ".." or symbolic links, returns error.
+func pathInRootDir(rootDir, p string) (string, error) {
+	joined := filepath.Join(rootDir, p)
+	resolvedRoot, err := filepath.EvalSymlinks(rootDir)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to resolve root directory %s: %w", rootDir, err)
+	}
+	// resolve symbolic links of the longest existing ancestor because the path may not be created yet.
+	existing := joined
+	var rest []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = parent
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to resolve path %s: %w", existing, err)
+	}
+	resolved = filepath.Join(append([]string{resolved}, rest...)...)
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("kubetest: path %s is outside of root directory %s", p, rootDir)
+	}
+	return joined, nil
 }
 
 func (e *localJobExecutor) Container() corev1.Container {
@@ -393,6 +1134,10 @@ func (e *localJobExecutor) Pod() *corev1.Pod {
 	return &corev1.Pod{}
 }
 
+func (e *localJobExecutor) TerminatedReason(_ context.Context) (string, error) {
+	return "", nil
+}
+
 type dryRunJob struct {
 	job       *batchv1.Job
 	finalizer *corev1.Container
@@ -419,7 +1164,7 @@ func (j *dryRunJob) RunWithExecutionHandler(ctx context.Context, handler func(co
 		if err := finalizer(ctx, &dryRunJobExecutor{
 			container: *j.finalizer,
 		}); err != nil {
-			return err
+			return &ContainerPhaseError{Phase: ContainerPhaseFinalizer, Container: j.finalizer.Name, Err: err}
 		}
 	}
 	return nil
@@ -457,3 +1202,7 @@ func (e *dryRunJobExecutor) Container() corev1.Container {
 func (e *dryRunJobExecutor) Pod() *corev1.Pod {
 	return &corev1.Pod{}
 }
+
+func (e *dryRunJobExecutor) TerminatedReason(_ context.Context) (string, error) {
+	return "", nil
+}
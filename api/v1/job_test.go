@@ -0,0 +1,459 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/kubejob"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPathInRootDir(t *testing.T) {
+	rootDir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootDir, "work"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(rootDir, "work", "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path  string
+		valid bool
+	}{
+		{path: "/work/result.txt", valid: true},
+		{path: "/work/new/dir/result.txt", valid: true},
+		{path: "/work/../../result.txt", valid: false},
+		{path: "/work/link/result.txt", valid: false},
+		{path: "/work/link", valid: false},
+	} {
+		path, err := pathInRootDir(rootDir, test.path)
+		if test.valid {
+			if err != nil {
+				t.Fatalf("expected %q is valid but got %v", test.path, err)
+			}
+			if path != filepath.Join(rootDir, test.path) {
+				t.Fatalf("unexpected path: %s", path)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("expected %q is invalid", test.path)
+		}
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	buf := newTailBuffer(8)
+	for _, p := range []string{"init ", "step 1\n", "step 2\n"} {
+		if _, err := buf.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := "... ( 11 bytes truncated )\n\nstep 2\n"; buf.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, buf.String())
+	}
+	buf = newTailBuffer(8)
+	if _, err := buf.Write([]byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "... ( 8 bytes truncated )\n89abcdef"; buf.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, buf.String())
+	}
+	buf = newTailBuffer(64)
+	if _, err := buf.Write([]byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "short" {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+	// stdout and stderr of the command are written concurrently.
+	buf = newTailBuffer(8)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = buf.Write([]byte("0123"))
+			}
+		}()
+	}
+	wg.Wait()
+	if expected := "... ( 792 bytes truncated )\n01230123"; buf.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, buf.String())
+	}
+}
+
+func TestKubejobShellCommand(t *testing.T) {
+	for _, test := range []struct {
+		cmd      []string
+		expected string
+	}{
+		{cmd: []string{"make", "setup"}, expected: "make setup"},
+		{
+			cmd:      []string{"sh", "-c", "echo a; echo b"},
+			expected: "VAR2=$(cat <<-'EOS'\necho a; echo b\nEOS\n); sh -c \"$VAR2\"",
+		},
+	} {
+		if got := kubejobShellCommand(test.cmd); got != test.expected {
+			t.Fatalf("expected %q but got %q", test.expected, got)
+		}
+	}
+}
+
+func TestLocalUlimits(t *testing.T) {
+	noFile := int64(256)
+	step := MainStep{
+		Template: TestJobTemplateSpec{
+			Spec: TestJobPodSpec{
+				Containers: []TestJobContainer{
+					{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"sh", "-c", "ulimit -n"}}},
+				},
+				Ulimits: &UlimitSpec{NoFile: &noFile},
+			},
+		},
+	}
+	testjob := TestJob{ObjectMeta: testjobObjectMeta(), Spec: TestJobSpec{MainStep: step}}
+	if err := testjob.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewResourceManager(clientset, testjob)
+	mgr.SetWorkDir(t.TempDir())
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo))
+	if err := mgr.Setup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Cleanup()
+	task, err := NewTaskBuilder(getConfig(), mgr, "default", RunModeLocal).Build(ctx, &step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := task.job.Spec().Template.Annotations[ulimitNoFileAnnotation]; value != "256" {
+		t.Fatalf("unexpected annotation of the pod: %q", value)
+	}
+	result, err := task.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := result.MainTaskResults()
+	if len(results) != 1 || results[0].Status != TaskResultSuccess {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if out := strings.TrimSpace(string(results[0].Out)); out != "256" {
+		t.Fatalf("unexpected limit of the command: %q", out)
+	}
+}
+
+// readyProbeExecutor executor whose PrepareCommand fails until it is called more than failures times.
+type readyProbeExecutor struct {
+	JobExecutor
+	pod      *corev1.Pod
+	failures int
+	calls    int
+}
+
+func (e *readyProbeExecutor) Pod() *corev1.Pod { return e.pod }
+func (e *readyProbeExecutor) Container() corev1.Container {
+	return corev1.Container{Name: "preinit"}
+}
+func (e *readyProbeExecutor) PrepareCommand(context.Context, []string) ([]byte, error) {
+	e.calls++
+	if e.calls <= e.failures {
+		return nil, errors.New("container not found")
+	}
+	return nil, nil
+}
+
+func TestWaitContainerReady(t *testing.T) {
+	interval := preInitReadyInterval
+	preInitReadyInterval = 10 * time.Millisecond
+	defer func() { preInitReadyInterval = interval }()
+	newPod := func(running bool) *corev1.Pod {
+		state := corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}
+		if running {
+			state = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Status: corev1.PodStatus{
+				InitContainerStatuses: []corev1.ContainerStatus{{Name: "preinit", State: state}},
+			},
+		}
+	}
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	t.Run("ready", func(t *testing.T) {
+		pod := newPod(true)
+		podClient := fake.NewSimpleClientset(pod).CoreV1().Pods("default")
+		exec := &readyProbeExecutor{pod: pod, failures: 2}
+		if err := waitContainerReady(ctx, podClient, exec, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if exec.calls != 3 {
+			t.Fatalf("expected to probe until the container accepts the command but probed %d times", exec.calls)
+		}
+	})
+	t.Run("not running", func(t *testing.T) {
+		pod := newPod(false)
+		podClient := fake.NewSimpleClientset(pod).CoreV1().Pods("default")
+		exec := &readyProbeExecutor{pod: pod}
+		err := waitContainerReady(ctx, podClient, exec, 50*time.Millisecond)
+		if err == nil || !strings.Contains(err.Error(), "preinit container isn't ready within 50ms: preinit container isn't running") {
+			t.Fatalf("expected timeout error but got %v", err)
+		}
+		if exec.calls != 0 {
+			t.Fatal("the command must not be executed in the container not running")
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		pod := newPod(false)
+		podClient := fake.NewSimpleClientset(pod).CoreV1().Pods("default")
+		if err := waitContainerReady(ctx, podClient, &readyProbeExecutor{pod: pod}, 0); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestWaitPodQuotaExceeded(t *testing.T) {
+	interval := podQuotaInterval
+	podQuotaInterval = 10 * time.Millisecond
+	defer func() { podQuotaInterval = interval }()
+	const selector = "kubejob.io/id=test"
+	labels := map[string]string{"kubejob.io/id": "test"}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-abcde", Namespace: "default", UID: "job-uid", Labels: labels},
+	}
+	quotaEvent := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "test-abcde.1", Namespace: "default"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Job", Name: "test-abcde", UID: "job-uid"},
+		Reason:         "FailedCreate",
+		Message:        `Error creating: pods "test-abcde-xyz" is forbidden: exceeded quota: compute-resources, requested: requests.cpu=2, used: requests.cpu=8, limited: requests.cpu=8`,
+	}
+	newJob := func(clientset kubernetes.Interface) *kubernetesJob {
+		k8sJob := newKubernetesJob(nil, clientset.CoreV1().Pods("default"), "default", nil, nil)
+		k8sJob.jobClient = clientset.BatchV1().Jobs("default")
+		k8sJob.eventClient = clientset.CoreV1().Events("default")
+		return k8sJob
+	}
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	t.Run("pod rejected by quota", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(job, quotaEvent)
+		err := newJob(clientset).waitPodQuotaExceeded(ctx, selector)
+		var quotaErr *PodQuotaExceededError
+		if !errors.As(err, &quotaErr) {
+			t.Fatalf("expected quota error but got %v", err)
+		}
+		if quotaErr.Job != "test-abcde" {
+			t.Fatalf("unexpected job name: %s", quotaErr.Job)
+		}
+		if !isQuotaExceededError(err) {
+			t.Fatal("the pod rejected by the quota must be retried")
+		}
+		// the job is created again by the retry.
+		retried := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test-fghij", Namespace: "default", UID: "retried-uid", Labels: labels}}
+		if _, err := clientset.BatchV1().Jobs("default").Create(ctx, retried, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		jobs, err := clientset.BatchV1().Jobs("default").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jobs.Items) != 1 || jobs.Items[0].Name != "test-fghij" {
+			t.Fatalf("the rejected job must be deleted before the retry: %v", jobs.Items)
+		}
+		var deleteAction k8stesting.DeleteActionImpl
+		for _, action := range clientset.Actions() {
+			if action, ok := action.(k8stesting.DeleteActionImpl); ok {
+				deleteAction = action
+			}
+		}
+		opts := deleteAction.GetDeleteOptions()
+		if opts.PropagationPolicy == nil || *opts.PropagationPolicy != metav1.DeletePropagationBackground {
+			t.Fatalf("the pods of the rejected job must be deleted in background: %v", opts.PropagationPolicy)
+		}
+		if opts.Preconditions == nil || opts.Preconditions.UID == nil || *opts.Preconditions.UID != job.UID {
+			t.Fatalf("the rejected job must be deleted by its uid: %v", opts.Preconditions)
+		}
+	})
+	t.Run("pod created", func(t *testing.T) {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-abcde-xyz", Namespace: "default", Labels: labels}}
+		if err := newJob(fake.NewSimpleClientset(job, quotaEvent, pod)).waitPodQuotaExceeded(ctx, selector); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("other failure", func(t *testing.T) {
+		event := quotaEvent.DeepCopy()
+		event.Message = `Error creating: pods "test-abcde-xyz" is forbidden: violates PodSecurity`
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		if err := newJob(fake.NewSimpleClientset(job, event)).waitPodQuotaExceeded(ctx, selector); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestWatchAborter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("watch") != "true" {
+			_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","items":[]}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	aborter := newWatchAborter()
+	clientset, err := kubernetes.NewForConfig(aborter.wrapConfig(&rest.Config{Host: server.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	watcher, err := clientset.CoreV1().Pods("default").Watch(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+	aborter.abort()
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if ok && event.Type != watch.Error {
+				t.Fatalf("unexpected event: %v", event.Type)
+			}
+			closed = !ok
+		case <-timeout:
+			t.Fatal("the watch wasn't aborted")
+		}
+	}
+	if _, err := clientset.CoreV1().Pods("default").List(ctx, metav1.ListOptions{}); err != nil {
+		t.Fatalf("the requests other than watch must not be aborted: %v", err)
+	}
+}
+
+func TestJobBuilderClientset(t *testing.T) {
+	existing := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "default"}}
+	clientset := fake.NewSimpleClientset(existing)
+	builder := NewJobBuilder(&rest.Config{Host: "http://127.0.0.1:0"}, "default", RunModeKubernetes)
+	builder.SetClientset(clientset)
+	job, err := builder.BuildWithJob(&batchv1.Job{ObjectMeta: metav1.ObjectMeta{GenerateName: "test-", Namespace: "default"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8sJob, ok := job.(*kubernetesJob)
+	if !ok {
+		t.Fatalf("unexpected job type: %T", job)
+	}
+	if _, err := k8sJob.jobClient.Get(context.Background(), "existing", metav1.GetOptions{}); err != nil {
+		t.Fatalf("the clientset set by SetClientset must be used: %v", err)
+	}
+}
+
+func TestJobSubmitHandler(t *testing.T) {
+	newJob := func(t *testing.T, server *httptest.Server, submitted *[]*batchv1.Job) *kubernetesJob {
+		builder := NewJobBuilder(&rest.Config{Host: server.URL}, "default", RunModeKubernetes)
+		builder.SetClientset(fake.NewSimpleClientset())
+		builder.SetFinalizer(&corev1.Container{Name: "finalizer", Image: "alpine", Command: []string{"true"}})
+		builder.SetSubmitHandler(func(_ context.Context, job *batchv1.Job) {
+			*submitted = append(*submitted, job.DeepCopy())
+		})
+		job, err := builder.BuildWithJob(&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "test-", Namespace: "default"},
+			Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "alpine", Command: []string{"true"}}}},
+				},
+			},
+		}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job.(*kubernetesJob)
+	}
+	run := func(job *kubernetesJob) error {
+		ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+		return job.RunWithExecutionHandler(ctx, func(context.Context, []JobExecutor) error {
+			return nil
+		}, func(context.Context, JobExecutor) error {
+			return nil
+		})
+	}
+	t.Run("created", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/jobs") {
+				var job batchv1.Job
+				if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				job.Name = job.GenerateName + "abcde"
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				_ = json.NewEncoder(w).Encode(&job)
+				return
+			}
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+		var submitted []*batchv1.Job
+		if err := run(newJob(t, server, &submitted)); err == nil {
+			t.Fatal("expected error of watching the job")
+		}
+		if len(submitted) != 1 {
+			t.Fatalf("expected the submitted job once but got %d", len(submitted))
+		}
+		job := submitted[0]
+		if job.Name != "test-abcde" {
+			t.Fatalf("expected the name of the created job but got %q", job.Name)
+		}
+		if job.Labels[kubejob.SelectorLabel] == "" {
+			t.Fatalf("expected the label added by kubejob: %v", job.Labels)
+		}
+		var names []string
+		for _, c := range job.Spec.Template.Spec.Containers {
+			names = append(names, c.Name)
+		}
+		if strings.Join(names, ",") != "test,finalizer" {
+			t.Fatalf("expected the finalizer added to the job but got %v", names)
+		}
+	})
+	t.Run("not created", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+		var submitted []*batchv1.Job
+		err := run(newJob(t, server, &submitted))
+		var creationErr *kubejob.JobCreationError
+		if !errors.As(err, &creationErr) {
+			t.Fatalf("expected JobCreationError but got %v", err)
+		}
+		if len(submitted) != 0 {
+			t.Fatalf("the job which isn't created must not be passed to the handler: %v", submitted)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// keepTTLAnnotation ttlSecondsAfterFinished of the step removed from the Job by keepResources.
+	// It is restored from this annotation if the objects aren't kept after the run.
+	keepTTLAnnotation = "kubetest.io/ttlSecondsAfterFinished"
+
+	// defaultKeepRetention time the kept objects are skipped by OwnerCleanup by default.
+	defaultKeepRetention = 24 * time.Hour
+)
+
+// keepResources returns whether the objects created by the run are kept by policy.
+func keepResources(policy KeepResourcesPolicy, failed bool) bool {
+	switch policy {
+	case KeepResourcesAlways:
+		return true
+	case KeepResourcesOnFailure:
+		return failed
+	}
+	return false
+}
+
+// isKeptKind returns whether the object of kind is kept by keepResources. Only the Jobs and their pods are kept.
+func isKeptKind(kind string) bool {
+	return kind == "Job" || kind == "Pod"
+}
+
+// keptObjects returns the objects kept by keepResources.
+func keptObjects(objects []ReportObject) []ReportObject {
+	kept := make([]ReportObject, 0, len(objects))
+	for _, obj := range objects {
+		if isKeptKind(obj.Kind) {
+			kept = append(kept, obj)
+		}
+	}
+	return kept
+}
+
+// keepJobTTL returns ttlSecondsAfterFinished of the Job built for the policy.
+// The Job isn't deleted by the TTL controller while the run may still decide to keep it,
+// so the original TTL is recorded to keepTTLAnnotation of meta to be restored by restoreJobTTL.
+func keepJobTTL(policy KeepResourcesPolicy, meta *metav1.ObjectMeta, ttl *int32) *int32 {
+	switch policy {
+	case KeepResourcesAlways:
+		return nil
+	case KeepResourcesOnFailure:
+		if ttl == nil {
+			return nil
+		}
+		if meta.Annotations == nil {
+			meta.Annotations = map[string]string{}
+		}
+		meta.Annotations[keepTTLAnnotation] = strconv.FormatInt(int64(*ttl), 10)
+		return nil
+	}
+	return ttl
+}
+
+// restoreJobTTL restores ttlSecondsAfterFinished removed by keepResources, so the Jobs not kept are deleted as usual.
+// The Jobs already deleted are ignored.
+func restoreJobTTL(ctx context.Context, clientset kubernetes.Interface, objects []ReportObject) error {
+	var errs []error
+	for _, obj := range objects {
+		if obj.Kind != "Job" {
+			continue
+		}
+		jobClient := clientset.BatchV1().Jobs(obj.Namespace)
+		if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			job, err := jobClient.Get(ctx, obj.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			value, exists := job.Annotations[keepTTLAnnotation]
+			if !exists {
+				return nil
+			}
+			ttl, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid %s annotation %q: %w", keepTTLAnnotation, value, err)
+			}
+			ttlSeconds := int32(ttl)
+			job.Spec.TTLSecondsAfterFinished = &ttlSeconds
+			delete(job.Annotations, keepTTLAnnotation)
+			_, err = jobClient.Update(ctx, job, metav1.UpdateOptions{})
+			return err
+		}); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("kubetest: failed to restore ttlSecondsAfterFinished of Job %s/%s: %w", obj.Namespace, obj.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
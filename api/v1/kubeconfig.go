@@ -0,0 +1,53 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ConfigFromKubeconfig loads the config of the context from the kubeconfig file and returns it with the default namespace of the context.
+// If path is empty, the kubeconfig is loaded by the default rules ( KUBECONFIG environment variable or $HOME/.kube/config ).
+// If context is empty, the current context of the kubeconfig is used.
+// If the context doesn't specify the namespace, returns "default".
+func ConfigFromKubeconfig(path, context string) (*rest.Config, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path != "" {
+		rules.ExplicitPath = path
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	)
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("kubetest: failed to load config of context %q from kubeconfig: %w", context, err)
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, "", fmt.Errorf("kubetest: failed to get namespace of context %q from kubeconfig: %w", context, err)
+	}
+	return cfg, namespace, nil
+}
+
+// DefaultConfig returns the config in cluster if kubetest is running in the pod of kubernetes.
+// Otherwise, the config of the current context is loaded from the kubeconfig by the default rules ( KUBECONFIG environment variable or $HOME/.kube/config ).
+func DefaultConfig() (*rest.Config, error) {
+	cfg, err := rest.InClusterConfig()
+	if err == nil {
+		return cfg, nil
+	}
+	if !errors.Is(err, rest.ErrNotInCluster) {
+		return nil, fmt.Errorf("kubetest: failed to load config in cluster: %w", err)
+	}
+	cfg, _, err = ConfigFromKubeconfig("", "")
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFromKubeconfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(`
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: user
+- name: prod
+  context:
+    cluster: prod
+    user: user
+    namespace: ci
+users:
+- name: user
+  user:
+    token: token
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("current context", func(t *testing.T) {
+		cfg, namespace, err := ConfigFromKubeconfig(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Host != "https://dev.example.com" || namespace != "default" {
+			t.Fatalf("unexpected config: host %s namespace %s", cfg.Host, namespace)
+		}
+	})
+	t.Run("named context", func(t *testing.T) {
+		cfg, namespace, err := ConfigFromKubeconfig(path, "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Host != "https://prod.example.com" || namespace != "ci" {
+			t.Fatalf("unexpected config: host %s namespace %s", cfg.Host, namespace)
+		}
+		if cfg.BearerToken != "token" {
+			t.Fatalf("unexpected token: %s", cfg.BearerToken)
+		}
+	})
+	t.Run("unknown context", func(t *testing.T) {
+		if _, _, err := ConfigFromKubeconfig(path, "unknown"); err == nil {
+			t.Fatal("expected error for unknown context")
+		}
+	})
+}
+
+func TestDefaultConfig(t *testing.T) {
+	if inCluster {
+		t.Skip("the config in cluster is always used in cluster")
+	}
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(`
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", path)
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Host != "https://dev.example.com" {
+		t.Fatalf("unexpected host: %s", cfg.Host)
+	}
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "notfound"))
+	if _, err := DefaultConfig(); err == nil {
+		t.Fatal("expected error if kubeconfig doesn't exist")
+	}
+}
@@ -0,0 +1,205 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	sigsjson "sigs.k8s.io/json"
+)
+
+// LoadTestJobs loads TestJobs from multi-document YAML or JSON.
+// A JSON array of TestJob is also supported.
+// Unknown or duplicated fields are reported as error with the field path.
+func LoadTestJobs(r io.Reader) ([]TestJob, error) {
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(r))
+	testjobs := []TestJob{}
+	for docIdx := 0; ; docIdx++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to read testjob document: %w", err)
+		}
+		jsonDoc, err := k8syaml.ToJSON(doc)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to convert testjob document %d to JSON: %w", docIdx, err)
+		}
+		jsonDoc = bytes.TrimSpace(jsonDoc)
+		if len(jsonDoc) == 0 || bytes.Equal(jsonDoc, []byte("null")) {
+			// empty document.
+			continue
+		}
+		if jsonDoc[0] == '[' {
+			var docs []json.RawMessage
+			if err := json.Unmarshal(jsonDoc, &docs); err != nil {
+				return nil, fmt.Errorf("kubetest: failed to decode testjob document %d: %w", docIdx, err)
+			}
+			for _, doc := range docs {
+				testjob, err := decodeTestJob(doc, docIdx)
+				if err != nil {
+					return nil, err
+				}
+				testjobs = append(testjobs, testjob)
+			}
+			continue
+		}
+		testjob, err := decodeTestJob(jsonDoc, docIdx)
+		if err != nil {
+			return nil, err
+		}
+		testjobs = append(testjobs, testjob)
+	}
+	if len(testjobs) == 0 {
+		return nil, fmt.Errorf("kubetest: testjob is not found")
+	}
+	return testjobs, nil
+}
+
+func decodeTestJob(data []byte, docIdx int) (TestJob, error) {
+	testjob, err := decodeTestJobStrict(data)
+	if err != nil {
+		return TestJob{}, fmt.Errorf("kubetest: invalid testjob document %d: %w", docIdx, err)
+	}
+	return testjob, nil
+}
+
+// decodeTestJobStrict decodes JSON data to TestJob.
+// Unknown fields are reported with the closest known field name.
+func decodeTestJobStrict(data []byte) (TestJob, error) {
+	var testjob TestJob
+	strictErrs, err := sigsjson.UnmarshalStrict(data, &testjob)
+	if err != nil {
+		return TestJob{}, err
+	}
+	if len(strictErrs) != 0 {
+		return TestJob{}, withFieldSuggestions(strictErrs)
+	}
+	return testjob, nil
+}
+
+// unknownFieldError error for unknown field with suggestion of the closest known field.
+type unknownFieldError struct {
+	err        error
+	suggestion string
+}
+
+func (e *unknownFieldError) Error() string {
+	return fmt.Sprintf("%s ( did you mean %q ? )", e.err.Error(), e.suggestion)
+}
+
+func (e *unknownFieldError) Unwrap() error {
+	return e.err
+}
+
+func withFieldSuggestions(strictErrs []error) error {
+	errs := make([]error, 0, len(strictErrs))
+	for _, err := range strictErrs {
+		fieldErr, ok := err.(interface{ FieldPath() string })
+		if !ok || !strings.HasPrefix(err.Error(), "unknown field") {
+			errs = append(errs, err)
+			continue
+		}
+		suggestion := suggestField(reflect.TypeOf(TestJob{}), fieldErr.FieldPath())
+		if suggestion == "" {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, &unknownFieldError{err: err, suggestion: suggestion})
+	}
+	return errors.Join(errs...)
+}
+
+var fieldIndexPattern = regexp.MustCompile(`\[[0-9]+\]`)
+
+// suggestField returns the closest known field name for the unknown field specified by path ( e.g. spec.mainStep.template.spec.artifcats ).
+// If there is no similar field, returns empty string.
+func suggestField(typ reflect.Type, path string) string {
+	fields := strings.Split(fieldIndexPattern.ReplaceAllString(path, ""), ".")
+	for _, field := range fields[:len(fields)-1] {
+		fieldType, exists := jsonFieldTypes(typ)[field]
+		if !exists {
+			return ""
+		}
+		typ = fieldType
+	}
+	unknown := fields[len(fields)-1]
+	var (
+		suggestion  string
+		minDistance = len(unknown)/2 + 1
+	)
+	for name := range jsonFieldTypes(typ) {
+		distance := editDistance(strings.ToLower(unknown), strings.ToLower(name))
+		if distance < minDistance || (distance == minDistance && suggestion != "" && name < suggestion) {
+			minDistance = distance
+			suggestion = name
+		}
+	}
+	return suggestion
+}
+
+// jsonFieldTypes returns the map from the JSON field name to its type.
+// The fields of inline struct are also included.
+func jsonFieldTypes(typ reflect.Type) map[string]reflect.Type {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+		typ = typ.Elem()
+	}
+	fields := map[string]reflect.Type{}
+	if typ.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			for name, typ := range jsonFieldTypes(field.Type) {
+				fields[name] = typ
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// editDistance returns levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
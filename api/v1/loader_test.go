@@ -0,0 +1,151 @@
+package v1
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadTestJobs(t *testing.T) {
+	t.Run("multiple documents", func(t *testing.T) {
+		testjobs, err := LoadTestJobs(strings.NewReader(`
+apiVersion: kubetest.io/v1
+kind: TestJob
+metadata:
+  name: first
+spec:
+  mainStep:
+    template:
+      spec:
+        containers:
+          - name: test
+            image: alpine
+            command: ["echo"]
+---
+# comment only document
+---
+apiVersion: kubetest.io/v1
+kind: TestJob
+metadata:
+  name: second
+spec:
+  mainStep:
+    template:
+      spec:
+        containers:
+          - name: test
+            image: alpine
+            command: ["echo"]
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(testjobs) != 2 {
+			t.Fatalf("failed to load testjobs: expected 2 but got %d", len(testjobs))
+		}
+		if testjobs[0].Name != "first" || testjobs[1].Name != "second" {
+			t.Fatalf("unexpected testjob names: %s, %s", testjobs[0].Name, testjobs[1].Name)
+		}
+	})
+	t.Run("json array", func(t *testing.T) {
+		testjobs, err := LoadTestJobs(strings.NewReader(`[
+  {"metadata": {"name": "first"}, "spec": {"mainStep": {"template": {"spec": {"containers": [{"name": "test", "image": "alpine"}]}}}}},
+  {"metadata": {"name": "second"}, "spec": {"mainStep": {"template": {"spec": {"containers": [{"name": "test", "image": "alpine"}]}}}}}
+]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(testjobs) != 2 {
+			t.Fatalf("failed to load testjobs: expected 2 but got %d", len(testjobs))
+		}
+	})
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := LoadTestJobs(strings.NewReader(`
+metadata:
+  name: typo
+spec:
+  mainStep:
+    template:
+      spec:
+        containers:
+          - name: test
+            image: alpine
+        artifcats:
+          - name: artifact
+`))
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "spec.mainStep.template.spec.artifcats") {
+			t.Fatalf("failed to get field path of unknown field: %v", err)
+		}
+		if !strings.Contains(err.Error(), `did you mean "artifacts"`) {
+			t.Fatalf("failed to get suggestion of unknown field: %v", err)
+		}
+	})
+	t.Run("suggestion", func(t *testing.T) {
+		for _, test := range []struct {
+			path     string
+			expected string
+		}{
+			{path: "spec.mainStep.strategy.scheduler.maxConcurentNumPerPod", expected: "maxConcurrentNumPerPod"},
+			{path: "spec.mainStep.template.spec.containers[0].imag", expected: "image"},
+			{path: "spec.mainStep.template.spec.restartPolcy", expected: "restartPolicy"},
+			{path: "spec.repo", expected: "repos"},
+			{path: "spec.unrelated", expected: ""},
+			{path: "spec.unknown.field", expected: ""},
+		} {
+			if got := suggestField(reflect.TypeOf(TestJob{}), test.path); got != test.expected {
+				t.Fatalf("failed to suggest field for %s: expected %q but got %q", test.path, test.expected, got)
+			}
+		}
+	})
+	t.Run("validate data", func(t *testing.T) {
+		err := NewValidator().ValidateTestJobData([]byte(`
+spec:
+  mainStep:
+    strategy:
+      key:
+        env: TEST
+        source:
+          static: [A]
+      scheduler:
+        maxPodNum: 1
+        maxConcurentNumPerPod: 1
+    template:
+      spec:
+        containers:
+          - name: test
+            image: alpine
+`))
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), `did you mean "maxConcurrentNumPerPod"`) {
+			t.Fatalf("failed to get suggestion of unknown field: %v", err)
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		if _, err := LoadTestJobs(strings.NewReader("---\n")); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("examples", func(t *testing.T) {
+		paths, err := filepath.Glob(filepath.Join("..", "..", "_examples", "*.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadTestJobs(f); err != nil {
+				t.Fatalf("failed to load %s: %v", path, err)
+			}
+			f.Close()
+		}
+	})
+}
@@ -6,10 +6,12 @@ package v1
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Logger interface {
@@ -24,12 +26,110 @@ type Logger interface {
 }
 
 type mainLogger struct {
-	masks  []string
-	level  LogLevel
-	out    io.Writer
-	buf    *bytes.Buffer
-	maskMu sync.RWMutex
-	logMu  sync.Mutex
+	masks   []string
+	level   LogLevel
+	sinks   []LogSink
+	batches []*logBatchWriter
+	buf     *bytes.Buffer
+	runID   string
+	maskMu  sync.RWMutex
+	logMu   sync.Mutex
+}
+
+// LogFormat output format of LogSink.
+type LogFormat string
+
+const (
+	LogFormatText  LogFormat = "text"
+	LogFormatJSONL LogFormat = "jsonl"
+)
+
+// LogSink destination of log with its own format and level.
+// All sinks attached to the same logger receive the same masked events.
+type LogSink struct {
+	Out    io.Writer
+	Level  LogLevel
+	Format LogFormat
+	// FlushInterval batches the output and writes it to Out at this interval if positive.
+	// This reduces the writes when many tasks log at the same time, so CI log collectors don't drop the lines.
+	// The lines written at once ( e.g. by LogGroup ) are kept together in order.
+	FlushInterval time.Duration
+}
+
+type logEntry struct {
+	level LogLevel
+	msg   string
+	runID string
+	// maxLines maximum number of the last lines of msg written to the sinks. If zero, all lines are written.
+	maxLines int
+}
+
+// LogLineLimit maximum number of the last lines of the output of each test written to the log by the result of the test.
+// Only the log is trimmed. The whole output is kept for the report and the artifacts. If zero, all lines are written.
+type LogLineLimit struct {
+	Success int
+	Failure int
+}
+
+// maxLines returns the limit for the result of the test.
+func (l LogLineLimit) maxLines(failed bool) int {
+	if failed {
+		return l.Failure
+	}
+	return l.Success
+}
+
+// tailLines returns the last maxLines lines of msg with the number of hidden lines at the beginning.
+func tailLines(msg string, maxLines int) string {
+	if maxLines <= 0 {
+		return msg
+	}
+	body := strings.TrimSuffix(msg, "\n")
+	lines := strings.Split(body, "\n")
+	if len(lines) <= maxLines {
+		return msg
+	}
+	hidden := len(lines) - maxLines
+	return fmt.Sprintf("... ( %d lines hidden )\n", hidden) + strings.Join(lines[hidden:], "\n") + msg[len(body):]
+}
+
+func (e logEntry) text() string {
+	var prefix string
+	if e.runID != "" {
+		prefix = "[" + e.runID + "] "
+	}
+	switch e.level {
+	case LogLevelDebug:
+		return prefix + "[DEBUG] " + e.msg
+	case LogLevelInfo:
+		return prefix + "[INFO] " + e.msg
+	case LogLevelWarn:
+		return prefix + "[WARN] " + e.msg
+	case LogLevelError:
+		return prefix + "[ERROR] " + e.msg
+	}
+	return prefix + e.msg
+}
+
+type jsonLogEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level,omitempty"`
+	RunID   string `json:"runID,omitempty"`
+	Message string `json:"msg"`
+}
+
+func (e logEntry) jsonLine(now time.Time) []byte {
+	var level string
+	if e.level != LogLevelNone {
+		level = e.level.String()
+	}
+	b, _ := json.Marshal(&jsonLogEntry{
+		Time:    now.Format(time.RFC3339Nano),
+		Level:   level,
+		RunID:   e.runID,
+		Message: e.msg,
+	})
+	return b
 }
 
 type loggerKey struct{}
@@ -43,11 +143,84 @@ func LoggerFromContext(ctx context.Context) Logger {
 }
 
 func NewLogger(out io.Writer, level LogLevel) Logger {
+	return NewLoggerWithSinks(LogSink{Out: out, Level: level, Format: LogFormatText})
+}
+
+// NewLoggerWithSinks creates logger writes to multiple sinks.
+// The captured log ( kubetest.log ) is written in text format with the most verbose level of sinks.
+func NewLoggerWithSinks(sinks ...LogSink) Logger {
+	level := LogLevelNone
+	// Out of the batched sink is replaced, so don't modify the sinks of the caller.
+	sinks = append([]LogSink{}, sinks...)
+	var batches []*logBatchWriter
+	for idx, sink := range sinks {
+		if sink.Level > level {
+			level = sink.Level
+		}
+		if sink.FlushInterval > 0 {
+			batch := newLogBatchWriter(sink.Out, sink.FlushInterval)
+			sinks[idx].Out = batch
+			batches = append(batches, batch)
+		}
+	}
 	return &mainLogger{
-		level: level,
-		out:   out,
-		buf:   bytes.NewBuffer([]byte{}),
+		level:   level,
+		sinks:   sinks,
+		batches: batches,
+		buf:     bytes.NewBuffer([]byte{}),
+	}
+}
+
+// maxLogBatchSize size of the batched output written immediately without waiting for the interval.
+const maxLogBatchSize = 1024 * 1024
+
+// logBatchWriter buffers the output and writes it to out at once when the interval elapses after the first buffered write.
+type logBatchWriter struct {
+	out      io.Writer
+	interval time.Duration
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	timer    *time.Timer
+}
+
+func newLogBatchWriter(out io.Writer, interval time.Duration) *logBatchWriter {
+	return &logBatchWriter{
+		out:      out,
+		interval: interval,
+	}
+}
+
+func (w *logBatchWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	if w.buf.Len() >= maxLogBatchSize {
+		return len(p), w.flush()
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.Flush)
+	}
+	return len(p), nil
+}
+
+// Flush writes the buffered output.
+func (w *logBatchWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.flush()
+}
+
+func (w *logBatchWriter) flush() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.buf.Len() == 0 {
+		return nil
 	}
+	defer w.buf.Reset()
+	_, err := w.out.Write(w.buf.Bytes())
+	return err
 }
 
 func (l *mainLogger) AddMask(mask string) {
@@ -63,8 +236,8 @@ func (l *mainLogger) Group() Logger {
 }
 
 type groupLogger struct {
-	level LogLevel
-	msgs  []string
+	level   LogLevel
+	entries []logEntry
 }
 
 func (g *groupLogger) AddMask(mask string) {}
@@ -79,50 +252,60 @@ func (g *groupLogger) LogGroup(group Logger) {
 	if !ok {
 		return
 	}
-	g.msgs = append(g.msgs, subgroup.msgs...)
+	g.entries = append(g.entries, subgroup.entries...)
 }
 
 func (g *groupLogger) Log(msg string) {
-	g.log(msg)
+	g.log(LogLevelNone, msg)
 }
 
 func (g *groupLogger) Debug(format string, args ...interface{}) {
 	if g.level < LogLevelDebug {
 		return
 	}
-	g.log("[DEBUG] " + fmt.Sprintf(format, args...))
+	g.log(LogLevelDebug, fmt.Sprintf(format, args...))
 }
 
 func (g *groupLogger) Info(format string, args ...interface{}) {
 	if g.level < LogLevelInfo {
 		return
 	}
-	g.log("[INFO] " + fmt.Sprintf(format, args...))
+	g.log(LogLevelInfo, fmt.Sprintf(format, args...))
 }
 
 func (g *groupLogger) Warn(format string, args ...interface{}) {
 	if g.level < LogLevelWarn {
 		return
 	}
-	g.log("[WARN] " + fmt.Sprintf(format, args...))
+	g.log(LogLevelWarn, fmt.Sprintf(format, args...))
 }
 
 func (g *groupLogger) Error(format string, args ...interface{}) {
 	if g.level < LogLevelError {
 		return
 	}
-	g.log("[ERROR] " + fmt.Sprintf(format, args...))
+	g.log(LogLevelError, fmt.Sprintf(format, args...))
 }
 
-func (g *groupLogger) log(msg string) {
+func (g *groupLogger) log(level LogLevel, msg string) {
 	if msg == "" {
 		return
 	}
-	g.msgs = append(g.msgs, msg)
+	g.entries = append(g.entries, logEntry{level: level, msg: msg})
 }
 
-func (g *groupLogger) buf() string {
-	return strings.Join(g.msgs, "\n")
+// logOutput writes the output of the test to logger keeping the last maxLines lines.
+// The lines are trimmed after masking, so the mask spanning multiple lines is applied to the whole output.
+func logOutput(logger Logger, out string, maxLines int) {
+	g, ok := logger.(*groupLogger)
+	if !ok {
+		logger.Log(tailLines(out, maxLines))
+		return
+	}
+	if out == "" {
+		return
+	}
+	g.entries = append(g.entries, logEntry{level: LogLevelNone, msg: out, maxLines: maxLines})
 }
 
 func (l *mainLogger) LogGroup(group Logger) {
@@ -130,59 +313,190 @@ func (l *mainLogger) LogGroup(group Logger) {
 	if !ok {
 		return
 	}
-	l.log(g.buf())
+	l.write(g.entries)
 }
 
 func (l *mainLogger) Log(msg string) {
-	l.log(msg)
+	l.log(LogLevelNone, msg)
 }
 
 func (l *mainLogger) Debug(format string, args ...interface{}) {
 	if l.level < LogLevelDebug {
 		return
 	}
-	l.log("[DEBUG] " + fmt.Sprintf(format, args...))
+	l.log(LogLevelDebug, fmt.Sprintf(format, args...))
 }
 
 func (l *mainLogger) Info(format string, args ...interface{}) {
 	if l.level < LogLevelInfo {
 		return
 	}
-	l.log("[INFO] " + fmt.Sprintf(format, args...))
+	l.log(LogLevelInfo, fmt.Sprintf(format, args...))
 }
 
 func (l *mainLogger) Warn(format string, args ...interface{}) {
 	if l.level < LogLevelWarn {
 		return
 	}
-	l.log("[WARN] " + fmt.Sprintf(format, args...))
+	l.log(LogLevelWarn, fmt.Sprintf(format, args...))
 }
 
 func (l *mainLogger) Error(format string, args ...interface{}) {
 	if l.level < LogLevelError {
 		return
 	}
-	l.log("[ERROR] " + fmt.Sprintf(format, args...))
+	l.log(LogLevelError, fmt.Sprintf(format, args...))
 }
 
-func (l *mainLogger) log(msg string) {
+func (l *mainLogger) log(level LogLevel, msg string) {
 	if msg == "" {
 		return
 	}
+	l.write([]logEntry{{level: level, msg: msg}})
+}
+
+// write writes entries to all sinks.
+// In text format, entries are written at once to keep the logs in the same group together.
+func (l *mainLogger) write(entries []logEntry) {
+	if len(entries) == 0 {
+		return
+	}
 	l.logMu.Lock()
 	defer l.logMu.Unlock()
-	maskedMsg := l.mask(msg)
-	fmt.Fprintln(l.out, maskedMsg)
-	fmt.Fprintln(l.buf, maskedMsg)
+	now := time.Now()
+	maskedEntries := make([]logEntry, 0, len(entries))
+	var trimmed bool
+	for _, entry := range entries {
+		msg, matchedMaskNum, replacedNum := l.maskWithCount(entry.msg)
+		maskedEntries = append(maskedEntries, logEntry{level: entry.level, msg: msg, runID: l.runID, maxLines: entry.maxLines})
+		if entry.maxLines > 0 {
+			trimmed = true
+		}
+		if replacedNum != 0 && l.level >= LogLevelDebug {
+			// report only the numbers to help finding the output over-redacted by the mask coincidentally matched.
+			maskedEntries = append(maskedEntries, logEntry{
+				level: LogLevelDebug,
+				msg:   fmt.Sprintf("%d masks matched and %d occurrences were replaced in the above message", matchedMaskNum, replacedNum),
+				runID: l.runID,
+			})
+		}
+	}
+	// the captured log keeps the whole output.
+	fmt.Fprintln(l.buf, l.text(maskedEntries, l.level))
+	if trimmed {
+		maskedEntries = trimEntries(maskedEntries)
+	}
+	for _, sink := range l.sinks {
+		switch sink.Format {
+		case LogFormatJSONL:
+			for _, entry := range maskedEntries {
+				if entry.level > sink.Level {
+					continue
+				}
+				fmt.Fprintln(sink.Out, string(entry.jsonLine(now)))
+			}
+		default:
+			if text := l.text(maskedEntries, sink.Level); text != "" {
+				fmt.Fprintln(sink.Out, text)
+			}
+		}
+	}
+}
+
+// trimEntries returns the copy of entries having the messages trimmed by maxLines.
+func trimEntries(entries []logEntry) []logEntry {
+	trimmed := make([]logEntry, 0, len(entries))
+	for _, entry := range entries {
+		entry.msg = tailLines(entry.msg, entry.maxLines)
+		trimmed = append(trimmed, entry)
+	}
+	return trimmed
+}
+
+func (l *mainLogger) text(entries []logEntry, level LogLevel) string {
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.level > level {
+			continue
+		}
+		lines = append(lines, entry.text())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// hasMask returns whether value is registered to logger as the mask.
+// If logger isn't mainLogger, the masks can't be reported, so returns false as the second value.
+func hasMask(logger Logger, value string) (bool, bool) {
+	l, ok := logger.(*mainLogger)
+	if !ok {
+		return false, false
+	}
+	if value == "" {
+		return false, true
+	}
+	l.maskMu.RLock()
+	defer l.maskMu.RUnlock()
+	for _, m := range l.masks {
+		if m == value {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// maskText masks msg by the masks registered to logger.
+func maskText(logger Logger, msg string) string {
+	if l, ok := logger.(*mainLogger); ok {
+		return l.mask(msg)
+	}
+	return msg
+}
+
+// flushLog writes the output batched by FlushInterval of the sinks of logger.
+func flushLog(logger Logger) {
+	if l, ok := logger.(*mainLogger); ok {
+		for _, batch := range l.batches {
+			batch.Flush()
+		}
+	}
+}
+
+// setLogRunID sets the run id written with every log line of logger.
+// If runID is empty, the log lines are written without run id.
+func setLogRunID(logger Logger, runID string) {
+	if l, ok := logger.(*mainLogger); ok {
+		l.logMu.Lock()
+		l.runID = runID
+		l.logMu.Unlock()
+	}
 }
 
 func (l *mainLogger) mask(msg string) string {
+	maskedMsg, _, _ := l.maskWithCount(msg)
+	return maskedMsg
+}
+
+// maskWithCount masks msg and returns the masked message with the number of masks matched and the number of replaced occurrences.
+func (l *mainLogger) maskWithCount(msg string) (string, int, int) {
 	l.maskMu.RLock()
 	defer l.maskMu.RUnlock()
-	maskedMsg := msg
+	var (
+		maskedMsg      = msg
+		matchedMaskNum int
+		replacedNum    int
+	)
 	for _, m := range l.masks {
+		if m == "" {
+			continue
+		}
+		count := strings.Count(maskedMsg, m)
+		if count == 0 {
+			continue
+		}
+		matchedMaskNum++
+		replacedNum += count
 		genMaskText := strings.Repeat("*", len(m))
 		maskedMsg = strings.Replace(maskedMsg, m, genMaskText, -1)
 	}
-	return maskedMsg
+	return maskedMsg, matchedMaskNum, replacedNum
 }
@@ -0,0 +1,211 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoggerSinks(t *testing.T) {
+	var (
+		text  bytes.Buffer
+		jsonl bytes.Buffer
+	)
+	logger := NewLoggerWithSinks(
+		LogSink{Out: &text, Level: LogLevelInfo, Format: LogFormatText},
+		LogSink{Out: &jsonl, Level: LogLevelDebug, Format: LogFormatJSONL},
+	)
+	logger.AddMask("secret")
+	logger.Info("token is secret")
+	logger.Debug("debug message")
+	group := logger.Group()
+	group.Log("group output")
+	group.Debug("group debug")
+	group.Warn("group warn")
+	logger.LogGroup(group)
+
+	expectedText := "[INFO] token is ******\ngroup output\n[WARN] group warn\n"
+	if text.String() != expectedText {
+		t.Fatalf("unexpected text log: %q", text.String())
+	}
+	type entry struct {
+		Time    string `json:"time"`
+		Level   string `json:"level"`
+		Message string `json:"msg"`
+	}
+	var entries []entry
+	for _, line := range strings.Split(strings.TrimSpace(jsonl.String()), "\n") {
+		var e entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("failed to decode %q: %v", line, err)
+		}
+		if e.Time == "" {
+			t.Fatalf("time is empty: %q", line)
+		}
+		entries = append(entries, e)
+	}
+	expected := []entry{
+		{Level: "info", Message: "token is ******"},
+		{Level: "debug", Message: "1 masks matched and 1 occurrences were replaced in the above message"},
+		{Level: "debug", Message: "debug message"},
+		{Level: "", Message: "group output"},
+		{Level: "debug", Message: "group debug"},
+		{Level: "warn", Message: "group warn"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("unexpected number of JSON Lines: %d", len(entries))
+	}
+	for idx, e := range entries {
+		if e.Level != expected[idx].Level || e.Message != expected[idx].Message {
+			t.Fatalf("unexpected entry: expected %+v but got %+v", expected[idx], e)
+		}
+	}
+	captured := logger.(*mainLogger).buf.String()
+	if !strings.Contains(captured, "[DEBUG] group debug") {
+		t.Fatalf("captured log must have the most verbose level: %q", captured)
+	}
+}
+
+func TestLoggerFlushInterval(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		out bytes.Buffer
+	)
+	writes := 0
+	writer := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		writes++
+		return out.Write(p)
+	})
+	written := func() (string, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return out.String(), writes
+	}
+	sinks := []LogSink{{Out: writer, Level: LogLevelInfo, Format: LogFormatText, FlushInterval: time.Hour}}
+	logger := NewLoggerWithSinks(sinks...)
+	if _, batched := sinks[0].Out.(*logBatchWriter); batched {
+		t.Fatal("sinks of the caller must not be modified")
+	}
+	logger.Info("first")
+	group := logger.Group()
+	group.Info("group1")
+	group.Info("group2")
+	logger.LogGroup(group)
+	if text, _ := written(); text != "" {
+		t.Fatalf("log must be batched until the interval elapses but got %q", text)
+	}
+	flushLog(logger)
+	text, num := written()
+	if expected := "[INFO] first\n[INFO] group1\n[INFO] group2\n"; text != expected {
+		t.Fatalf("unexpected log: %q", text)
+	}
+	if num != 1 {
+		t.Fatalf("expected the batched log is written at once but written %d times", num)
+	}
+
+	logger = NewLoggerWithSinks(LogSink{Out: writer, Level: LogLevelInfo, Format: LogFormatText, FlushInterval: 10 * time.Millisecond})
+	logger.Info("second")
+	for i := 0; i < 100; i++ {
+		if text, _ := written(); strings.HasSuffix(text, "[INFO] second\n") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("batched log wasn't written after the interval")
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestLoggerRunID(t *testing.T) {
+	var (
+		text  bytes.Buffer
+		jsonl bytes.Buffer
+	)
+	logger := NewLoggerWithSinks(
+		LogSink{Out: &text, Level: LogLevelInfo, Format: LogFormatText},
+		LogSink{Out: &jsonl, Level: LogLevelInfo, Format: LogFormatJSONL},
+	)
+	setLogRunID(logger, "abc123")
+	logger.Info("start")
+	group := logger.Group()
+	group.Log("group output")
+	logger.LogGroup(group)
+	setLogRunID(logger, "")
+	logger.Info("finish")
+
+	expectedText := "[abc123] [INFO] start\n[abc123] group output\n[INFO] finish\n"
+	if text.String() != expectedText {
+		t.Fatalf("unexpected text log: %q", text.String())
+	}
+	lines := strings.Split(strings.TrimSpace(jsonl.String()), "\n")
+	expectedRunIDs := []string{"abc123", "abc123", ""}
+	if len(lines) != len(expectedRunIDs) {
+		t.Fatalf("unexpected number of JSON Lines: %d", len(lines))
+	}
+	for idx, line := range lines {
+		var e struct {
+			RunID string `json:"runID"`
+		}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("failed to decode %q: %v", line, err)
+		}
+		if e.RunID != expectedRunIDs[idx] {
+			t.Fatalf("unexpected run id: expected %q but got %q", expectedRunIDs[idx], e.RunID)
+		}
+	}
+}
+
+func TestLoggerMaskCount(t *testing.T) {
+	var (
+		info  bytes.Buffer
+		debug bytes.Buffer
+	)
+	logger := NewLoggerWithSinks(
+		LogSink{Out: &info, Level: LogLevelInfo, Format: LogFormatText},
+		LogSink{Out: &debug, Level: LogLevelDebug, Format: LogFormatText},
+	)
+	logger.AddMask("secret")
+	logger.AddMask("token")
+	logger.AddMask("unused")
+	logger.Info("secret token secret")
+	logger.Info("nothing to mask")
+
+	if info.String() != "[INFO] ****** ***** ******\n[INFO] nothing to mask\n" {
+		t.Fatalf("mask count must not be written to the sink not enabled debug level: %q", info.String())
+	}
+	expected := "[INFO] ****** ***** ******\n[DEBUG] 2 masks matched and 3 occurrences were replaced in the above message\n[INFO] nothing to mask\n"
+	if debug.String() != expected {
+		t.Fatalf("unexpected debug log: %q", debug.String())
+	}
+}
+
+func TestLoggerLineLimit(t *testing.T) {
+	var text bytes.Buffer
+	logger := NewLoggerWithSinks(LogSink{Out: &text, Level: LogLevelInfo, Format: LogFormatText})
+	logger.AddMask("a\nb")
+	setLogRunID(logger, "abc123")
+	group := logger.Group()
+	logOutput(group, "x\na\nb\nc\nd\n", 2)
+	logOutput(group, "short\n", 2)
+	logger.LogGroup(group)
+
+	// the mask spanning the hidden line and the written line must be applied before trimming.
+	expected := "[abc123] ... ( 2 lines hidden )\nc\nd\n\n[abc123] short\n\n"
+	if text.String() != expected {
+		t.Fatalf("unexpected trimmed log: %q", text.String())
+	}
+	captured := logger.(*mainLogger).buf.String()
+	if captured != "[abc123] x\n***\nc\nd\n\n[abc123] short\n\n" {
+		t.Fatalf("captured log must have the whole output: %q", captured)
+	}
+	if limit := (LogLineLimit{Success: 200, Failure: 2000}); limit.maxLines(false) != 200 || limit.maxLines(true) != 2000 {
+		t.Fatalf("unexpected limit: %+v", limit)
+	}
+}
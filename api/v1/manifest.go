@@ -0,0 +1,66 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// manifestWriter writes the manifests of the Jobs built by TaskBuilder to the directory for audit.
+// The file name has the sequence number in order of building, so the Jobs built again by retry don't overwrite the previous ones.
+type manifestWriter struct {
+	dir string
+	seq uint32
+}
+
+func newManifestWriter(dir string) *manifestWriter {
+	return &manifestWriter{dir: dir}
+}
+
+// write writes job as YAML to the file named by the sequence number, the phase and the shard index
+// ( e.g. 0003-mainStep-shard-1.yaml ). If runID is specified, it is prepended to the file name.
+func (w *manifestWriter) write(job *batchv1.Job, step Step, strategyKey *StrategyKey, runID string) (string, error) {
+	manifest := job.DeepCopy()
+	manifest.TypeMeta = metav1.TypeMeta{APIVersion: batchv1.SchemeGroupVersion.String(), Kind: "Job"}
+	return w.writeObject(manifest, manifestFileName(atomic.AddUint32(&w.seq, 1)-1, step, strategyKey, runID))
+}
+
+// writeObject writes obj as YAML to the file in the directory. obj must have TypeMeta to be applied as is.
+func (w *manifestWriter) writeObject(obj interface{}, fileName string) (string, error) {
+	if err := os.MkdirAll(w.dir, 0o755); err != nil {
+		return "", fmt.Errorf("kubetest: failed to create manifest directory %s: %w", w.dir, err)
+	}
+	b, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to encode manifest: %w", err)
+	}
+	path := filepath.Join(w.dir, fileName)
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return "", fmt.Errorf("kubetest: failed to write manifest to %s: %w", path, err)
+	}
+	return path, nil
+}
+
+func manifestFileName(seq uint32, step Step, strategyKey *StrategyKey, runID string) string {
+	elems := []string{}
+	if runID != "" {
+		elems = append(elems, runID)
+	}
+	elems = append(elems, fmt.Sprintf("%04d", seq), string(step.GetType()))
+	if name := step.GetName(); name != "" {
+		elems = append(elems, name)
+	}
+	if strategyKey != nil {
+		elems = append(elems, fmt.Sprintf("shard-%d", strategyKey.ConcurrentIdx))
+	}
+	return strings.Join(elems, "-") + ".yaml"
+}
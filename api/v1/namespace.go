@@ -0,0 +1,108 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// runNamespaces returns the namespaces having the Jobs of the run.
+// The shards of mainStep are also created in the namespaces of strategy.scheduler.namespaces.
+func runNamespaces(testjob TestJob) []string {
+	namespaces := []string{testjob.Namespace}
+	if strategy := testjob.Spec.MainStep.Strategy; strategy != nil {
+		for _, namespace := range strategy.Scheduler.Namespaces {
+			if namespace != testjob.Namespace {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+	return namespaces
+}
+
+// ownerReferenceIn returns ownerReference if the object created in namespace can have it, otherwise returns nil.
+// The owner is in ownerNamespace ( the namespace of the TestJob ), and the owner in the other namespace is invalid:
+// the garbage collector regards it as missing and deletes the object during the run.
+// So the objects in the other namespaces have no owner, and they are deleted by kubetest instead.
+func ownerReferenceIn(namespace, ownerNamespace string, ownerReference *metav1.OwnerReference) *metav1.OwnerReference {
+	if namespace != ownerNamespace {
+		return nil
+	}
+	return ownerReference
+}
+
+// checkShardNamespaces checks that the secrets referenced by the pods of mainStep exist in all namespaces of strategy.scheduler.namespaces,
+// so the shards don't get stuck in the namespace missing them after the run starts. kubetest doesn't replicate the secrets.
+// The tokens don't need to exist in the namespaces because they are read by kubetest in the namespace of the TestJob.
+func checkShardNamespaces(ctx context.Context, clientset kubernetes.Interface, testjob TestJob) error {
+	strategy := testjob.Spec.MainStep.Strategy
+	if strategy == nil || len(strategy.Scheduler.Namespaces) == 0 {
+		return nil
+	}
+	secrets := shardSecretNames(testjob)
+	var missing []string
+	for _, namespace := range strategy.Scheduler.Namespaces {
+		for _, name := range secrets {
+			if _, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+				if apierrors.IsNotFound(err) {
+					missing = append(missing, namespace+"/"+name)
+					continue
+				}
+				return fmt.Errorf("kubetest: failed to read secret %s in namespace %s: %w", name, namespace, err)
+			}
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("kubetest: secrets referenced by the shards are not found in strategy.scheduler.namespaces: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// shardSecretNames returns the sorted names of the secrets referenced by the pods of mainStep.
+func shardSecretNames(testjob TestJob) []string {
+	names := map[string]struct{}{}
+	for _, envFrom := range testjob.Spec.EnvFrom {
+		if envFrom.SecretRef != nil {
+			names[envFrom.SecretRef.Name] = struct{}{}
+		}
+	}
+	spec := testjob.Spec.MainStep.Template.Spec
+	containers := append(append([]TestJobContainer{}, spec.InitContainers...), spec.Containers...)
+	if spec.FinalizerContainer.Name != "" {
+		containers = append(containers, spec.FinalizerContainer)
+	}
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				names[envFrom.SecretRef.Name] = struct{}{}
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				names[env.ValueFrom.SecretKeyRef.Name] = struct{}{}
+			}
+		}
+	}
+	for _, secret := range spec.ImagePullSecrets {
+		names[secret.Name] = struct{}{}
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			names[volume.Secret.SecretName] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
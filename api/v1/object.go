@@ -0,0 +1,222 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	// objectNumPerTask number of kubernetes objects created by a task ( Job and Pod ).
+	objectNumPerTask = 2
+)
+
+// ObjectHandler called when kubetest creates a kubernetes object.
+// If the UID of the object becomes known after it was reported, it is called again with the UID.
+type ObjectHandler func(ReportObject)
+
+type objectKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// ObjectRecorder records kubernetes objects created by kubetest.
+type ObjectRecorder struct {
+	objects []ReportObject
+	seen    map[objectKey]int
+	// kept time each object was kept for postmortem by keepResources.
+	kept    map[objectKey]time.Time
+	handler ObjectHandler
+	mu      sync.Mutex
+}
+
+func NewObjectRecorder() *ObjectRecorder {
+	return &ObjectRecorder{
+		seen: map[objectKey]int{},
+		kept: map[objectKey]time.Time{},
+	}
+}
+
+// SetHandler set the handler called whenever a new object is recorded.
+func (r *ObjectRecorder) SetHandler(handler ObjectHandler) {
+	r.handler = handler
+}
+
+func (r *ObjectRecorder) Record(obj ReportObject) {
+	if obj.Name == "" {
+		return
+	}
+	r.mu.Lock()
+	key := objectKey{kind: obj.Kind, namespace: obj.Namespace, name: obj.Name}
+	if idx, exists := r.seen[key]; exists {
+		if obj.UID == "" || r.objects[idx].UID != "" {
+			r.mu.Unlock()
+			return
+		}
+		r.objects[idx].UID = obj.UID
+		obj = r.objects[idx]
+	} else {
+		r.seen[key] = len(r.objects)
+		r.objects = append(r.objects, obj)
+	}
+	handler := r.handler
+	r.mu.Unlock()
+
+	if handler != nil {
+		handler(obj)
+	}
+}
+
+func (r *ObjectRecorder) Objects() []ReportObject {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ReportObject{}, r.objects...)
+}
+
+// keep marks the objects as kept for postmortem at the time.
+func (r *ObjectRecorder) keep(objects []ReportObject, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, obj := range objects {
+		key := objectKey{kind: obj.Kind, namespace: obj.Namespace, name: obj.Name}
+		if idx, exists := r.seen[key]; exists {
+			r.objects[idx].Kept = true
+			r.kept[key] = at
+		}
+	}
+}
+
+// isKept returns whether obj is kept for postmortem.
+func (r *ObjectRecorder) isKept(obj ReportObject) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, exists := r.kept[objectKey{kind: obj.Kind, namespace: obj.Namespace, name: obj.Name}]
+	return exists
+}
+
+// deletableObjects returns the objects to be deleted and the recorder having the remaining objects.
+// The objects kept within retention remain, so the postmortem isn't disturbed by the cleanup.
+func (r *ObjectRecorder) deletableObjects(now time.Time, retention time.Duration) ([]ReportObject, *ObjectRecorder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remaining := NewObjectRecorder()
+	remaining.handler = r.handler
+	var deletable []ReportObject
+	for _, obj := range r.objects {
+		key := objectKey{kind: obj.Kind, namespace: obj.Namespace, name: obj.Name}
+		if keptAt, exists := r.kept[key]; exists && now.Sub(keptAt) < retention {
+			remaining.seen[key] = len(remaining.objects)
+			remaining.objects = append(remaining.objects, obj)
+			remaining.kept[key] = keptAt
+			continue
+		}
+		deletable = append(deletable, obj)
+	}
+	return deletable, remaining
+}
+
+type objectRecorderKey struct{}
+
+func WithObjectRecorder(ctx context.Context, recorder *ObjectRecorder) context.Context {
+	return context.WithValue(ctx, objectRecorderKey{}, recorder)
+}
+
+// ObjectRecorderFromContext returns ObjectRecorder if registered to the context. otherwise returns nil.
+func ObjectRecorderFromContext(ctx context.Context) *ObjectRecorder {
+	recorder, _ := ctx.Value(objectRecorderKey{}).(*ObjectRecorder)
+	return recorder
+}
+
+func recordObject(ctx context.Context, obj ReportObject) {
+	if recorder := ObjectRecorderFromContext(ctx); recorder != nil {
+		recorder.Record(obj)
+	}
+}
+
+// dynamicObjectClient deletes the objects of the kinds without the typed client ( e.g. Deployment of fixtures ).
+type dynamicObjectClient struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+}
+
+func newDynamicObjectClient(cfg *rest.Config, clientset kubernetes.Interface) (*dynamicObjectClient, error) {
+	client, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamicObjectClient{
+		client: client,
+		mapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery())),
+	}, nil
+}
+
+func (c *dynamicObjectClient) delete(ctx context.Context, obj ReportObject, opts metav1.DeleteOptions) error {
+	gv, err := schema.ParseGroupVersion(obj.APIVersion)
+	if err != nil {
+		return err
+	}
+	mapping, err := c.mapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: obj.Kind}, gv.Version)
+	if err != nil {
+		return err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return c.client.Resource(mapping.Resource).Namespace(obj.Namespace).Delete(ctx, obj.Name, opts)
+	}
+	return c.client.Resource(mapping.Resource).Delete(ctx, obj.Name, opts)
+}
+
+// deleteObjects deletes objects created by kubetest.
+// Jobs are deleted with their Pods, and the objects already deleted are ignored.
+// If the UID of the object is known, the object is deleted only if it has the same UID.
+// The objects of the other kinds having apiVersion are deleted by dynamicClient if it isn't nil.
+func deleteObjects(ctx context.Context, clientset kubernetes.Interface, dynamicClient *dynamicObjectClient, objects []ReportObject) error {
+	propagation := metav1.DeletePropagationBackground
+	var errs []error
+	for _, obj := range objects {
+		opts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+		if obj.UID != "" {
+			uid := obj.UID
+			opts.Preconditions = &metav1.Preconditions{UID: &uid}
+		}
+		LoggerFromContext(ctx).Info("delete %s %s/%s", obj.Kind, obj.Namespace, obj.Name)
+		var err error
+		switch obj.Kind {
+		case "Job":
+			err = clientset.BatchV1().Jobs(obj.Namespace).Delete(ctx, obj.Name, opts)
+		case "Pod":
+			err = clientset.CoreV1().Pods(obj.Namespace).Delete(ctx, obj.Name, opts)
+		case "Secret":
+			err = clientset.CoreV1().Secrets(obj.Namespace).Delete(ctx, obj.Name, opts)
+		case "ConfigMap":
+			err = clientset.CoreV1().ConfigMaps(obj.Namespace).Delete(ctx, obj.Name, opts)
+		case "NetworkPolicy":
+			err = clientset.NetworkingV1().NetworkPolicies(obj.Namespace).Delete(ctx, obj.Name, opts)
+		default:
+			if dynamicClient == nil || obj.APIVersion == "" {
+				err = fmt.Errorf("unsupported kind")
+				break
+			}
+			err = dynamicClient.delete(ctx, obj, opts)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("kubetest: failed to delete %s %s/%s: %w", obj.Kind, obj.Namespace, obj.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
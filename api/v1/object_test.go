@@ -0,0 +1,127 @@
+package v1
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestObjectRecorder(t *testing.T) {
+	t.Run("record", func(t *testing.T) {
+		recorder := NewObjectRecorder()
+		var handled []ReportObject
+		recorder.SetHandler(func(obj ReportObject) {
+			handled = append(handled, obj)
+		})
+		recorder.Record(ReportObject{Kind: "Job", Namespace: "default", Name: "test"})
+		recorder.Record(ReportObject{Kind: "Job", Namespace: "default", Name: "test"})
+		recorder.Record(ReportObject{Kind: "Job", Namespace: "default", Name: "test", UID: "job-uid"})
+		recorder.Record(ReportObject{Kind: "Pod", Namespace: "default", Name: "test-pod", UID: "pod-uid"})
+		recorder.Record(ReportObject{Kind: "Pod", Namespace: "default"})
+
+		objects := recorder.Objects()
+		if len(objects) != 2 {
+			t.Fatalf("failed to record objects: %v", objects)
+		}
+		if objects[0].UID != "job-uid" {
+			t.Fatalf("failed to update uid: %v", objects[0])
+		}
+		if len(handled) != 3 {
+			t.Fatalf("unexpected handled objects: %v", handled)
+		}
+		if handled[1].UID != "job-uid" {
+			t.Fatalf("expected handler is called again with uid but got %v", handled[1])
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		clientset := fake.NewSimpleClientset(
+			&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-pod"}},
+		)
+		if err := deleteObjects(ctx, clientset, nil, []ReportObject{
+			{Kind: "Job", Namespace: "default", Name: "test"},
+			{Kind: "Pod", Namespace: "default", Name: "test-pod"},
+			{Kind: "Pod", Namespace: "default", Name: "already-deleted"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := clientset.BatchV1().Jobs("default").Get(ctx, "test", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected job is deleted but got %v", err)
+		}
+		if _, err := clientset.CoreV1().Pods("default").Get(ctx, "test-pod", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected pod is deleted but got %v", err)
+		}
+	})
+	t.Run("keep", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		recorder := NewObjectRecorder()
+		job := ReportObject{Kind: "Job", Namespace: "default", Name: "kept"}
+		recorder.Record(job)
+		recorder.Record(ReportObject{Kind: "Job", Namespace: "default", Name: "other"})
+		now := time.Now()
+		recorder.keep([]ReportObject{job}, now)
+		if objects := recorder.Objects(); !objects[0].Kept || objects[1].Kept {
+			t.Fatalf("unexpected kept objects: %v", objects)
+		}
+		deletable, remaining := recorder.deletableObjects(now.Add(time.Hour), 2*time.Hour)
+		if len(deletable) != 1 || deletable[0].Name != "other" {
+			t.Fatalf("unexpected deletable objects: %v", deletable)
+		}
+		if objects := remaining.Objects(); len(objects) != 1 || objects[0].Name != "kept" {
+			t.Fatalf("unexpected remaining objects: %v", objects)
+		}
+		deletable, remaining = remaining.deletableObjects(now.Add(3*time.Hour), 2*time.Hour)
+		if len(deletable) != 1 || deletable[0].Name != "kept" || len(remaining.Objects()) != 0 {
+			t.Fatalf("kept object must be deleted after the retention but got %v", deletable)
+		}
+
+		ttl := int32(60)
+		var meta metav1.ObjectMeta
+		if got := keepJobTTL(KeepResourcesNever, &meta, &ttl); got != &ttl || meta.Annotations != nil {
+			t.Fatalf("ttl must not be changed by never but got %v", got)
+		}
+		if got := keepJobTTL(KeepResourcesAlways, &meta, &ttl); got != nil || meta.Annotations != nil {
+			t.Fatalf("ttl must be removed by always but got %v", got)
+		}
+		if got := keepJobTTL(KeepResourcesOnFailure, &meta, &ttl); got != nil || meta.Annotations[keepTTLAnnotation] != "60" {
+			t.Fatalf("ttl must be recorded by onFailure but got %v, %v", got, meta.Annotations)
+		}
+		clientset := fake.NewSimpleClientset(
+			&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Annotations: meta.Annotations}},
+		)
+		if err := restoreJobTTL(ctx, clientset, []ReportObject{
+			{Kind: "Job", Namespace: "default", Name: "test"},
+			{Kind: "Job", Namespace: "default", Name: "already-deleted"},
+			{Kind: "Pod", Namespace: "default", Name: "test-pod"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := clientset.BatchV1().Jobs("default").Get(ctx, "test", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Spec.TTLSecondsAfterFinished == nil || *restored.Spec.TTLSecondsAfterFinished != 60 {
+			t.Fatalf("failed to restore ttl: %v", restored.Spec.TTLSecondsAfterFinished)
+		}
+		if _, exists := restored.Annotations[keepTTLAnnotation]; exists {
+			t.Fatalf("annotation must be removed after restoring ttl: %v", restored.Annotations)
+		}
+
+		kept := keptObjects([]ReportObject{
+			{Kind: "Job", Namespace: "default", Name: "test"},
+			{Kind: "Pod", Namespace: "default", Name: "test-pod"},
+			{Kind: "NetworkPolicy", Namespace: "default", Name: "kubetest-hermetic-abcd"},
+		})
+		if len(kept) != 2 || kept[0].Kind != "Job" || kept[1].Kind != "Pod" {
+			t.Fatalf("only the Jobs and the pods must be kept but got %v", kept)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	partialReportPrefix = "task-"
+	partialReportSuffix = ".json"
+)
+
+// partialReportWriter writes the report of each task to the directory as soon as the task finishes,
+// so the results of the finished tasks remain even if the process dies before the end of the run.
+// The reports are written to the directory named by the run id, and each file is renamed atomically after it is written.
+type partialReportWriter struct {
+	dir       string
+	runID     string
+	startedAt time.Time
+	seq       int
+	mu        sync.Mutex
+	// indent indent of the merged report. The reports of the tasks are always written in a single line.
+	indent string
+	// clockSkew offset applied to startedAt of the reports for the skew of the clock of the API server.
+	clockSkew time.Duration
+}
+
+func newPartialReportWriter(dir, runID string, startedAt time.Time) (*partialReportWriter, error) {
+	dir = filepath.Join(dir, runID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("kubetest: failed to create partial report directory %s: %w", dir, err)
+	}
+	return &partialReportWriter{dir: dir, runID: runID, startedAt: startedAt}, nil
+}
+
+// write writes the report of the finished task as task-<seq>.json.
+func (w *partialReportWriter) write(result *TaskResult) error {
+	details := result.toReportDetails()
+	report := newReportFromDetails(details)
+	report.RunID = w.runID
+	report.StartedAt.Time = w.startedAt.Add(w.clockSkew)
+	report.ElapsedTimeSec = int64(time.Since(w.startedAt).Seconds())
+	b, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to encode partial report: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	path := filepath.Join(w.dir, fmt.Sprintf("%s%04d%s", partialReportPrefix, w.seq, partialReportSuffix))
+	if err := writeFileAtomically(path, b); err != nil {
+		return err
+	}
+	w.seq++
+	return nil
+}
+
+// merge writes the report of the finished run as report.json and removes the partial reports merged into it.
+func (w *partialReportWriter) merge(report *Report) error {
+	b, err := marshalReport(report, w.indent)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to encode report: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := writeFileAtomically(filepath.Join(w.dir, reportJSONFile), b); err != nil {
+		return err
+	}
+	paths, err := partialReportPaths(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("kubetest: failed to remove partial report %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomically writes b to the temporary file in the same directory and renames it to path,
+// so the reader never sees the partially written file.
+func writeFileAtomically(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to create temporary file for %s: %w", path, err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("kubetest: failed to write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("kubetest: failed to write %s: %w", path, err)
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("kubetest: failed to change mode of %s: %w", path, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("kubetest: failed to rename to %s: %w", path, err)
+	}
+	return nil
+}
+
+func partialReportPaths(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to read partial report directory %s: %w", dir, err)
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, partialReportPrefix) || !strings.HasSuffix(name, partialReportSuffix) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// RecoverReport assembles the report from the directory written by the run with Runner.SetPartialReportDir.
+// dir is the directory of the run ( <partial report dir>/<run id> ).
+// If the run finished, returns its report. Otherwise returns the report of the tasks finished before the run stopped,
+// and the report is marked as interrupted. If a test was retried, the result of the last run is used.
+func RecoverReport(dir string) (*Report, error) {
+	if f, err := os.Open(filepath.Join(dir, reportJSONFile)); err == nil {
+		defer f.Close()
+		return ReadReport(f)
+	}
+	paths, err := partialReportPaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("kubetest: partial report is not found in %s", dir)
+	}
+	var (
+		recovered Report
+		details   []*ReportDetail
+		indexes   = map[string]int{}
+	)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to open partial report %s: %w", path, err)
+		}
+		report, err := ReadReport(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to read partial report %s: %w", path, err)
+		}
+		recovered.RunID = report.RunID
+		recovered.StartedAt = report.StartedAt
+		if report.ElapsedTimeSec > recovered.ElapsedTimeSec {
+			recovered.ElapsedTimeSec = report.ElapsedTimeSec
+		}
+		for _, detail := range report.Details {
+			if idx, exists := indexes[detail.Name]; exists {
+				details[idx] = detail
+				continue
+			}
+			indexes[detail.Name] = len(details)
+			details = append(details, detail)
+		}
+	}
+	report := newReportFromDetails(details)
+	report.RunID = recovered.RunID
+	report.StartedAt = recovered.StartedAt
+	report.ElapsedTimeSec = recovered.ElapsedTimeSec
+	report.Interrupted = true
+	return report, nil
+}
+
+// newReportFromDetails returns the report summarizing details.
+func newReportFromDetails(details []*ReportDetail) *Report {
+	report := &Report{
+		Status:   ResultStatusSuccess,
+		TotalNum: len(details),
+		Details:  details,
+	}
+	for _, detail := range details {
+		switch detail.Status {
+		case ResultStatusSuccess:
+			report.SuccessNum++
+		case ResultStatusFailure:
+			report.FailureNum++
+		default:
+			report.UnknownNum++
+		}
+	}
+	if report.FailureNum != 0 {
+		report.Status = ResultStatusFailure
+	}
+	if report.UnknownNum != 0 {
+		report.Status = ResultStatusError
+	}
+	return report
+}
+
+type partialReportWriterKey struct{}
+
+func withPartialReportWriter(ctx context.Context, writer *partialReportWriter) context.Context {
+	return context.WithValue(ctx, partialReportWriterKey{}, writer)
+}
+
+// writePartialReport writes the report of the finished task if the partial report writer is registered to the context.
+// The failure is only logged because the partial report must not stop the run.
+func writePartialReport(ctx context.Context, result *TaskResult) {
+	writer, _ := ctx.Value(partialReportWriterKey{}).(*partialReportWriter)
+	if writer == nil {
+		return
+	}
+	if err := writer.write(result); err != nil {
+		LoggerFromContext(ctx).Warn("failed to write partial report: %s", err)
+	}
+}
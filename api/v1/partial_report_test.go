@@ -0,0 +1,139 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartialReport(t *testing.T) {
+	newTaskResult := func(results map[string]TaskResultStatus) *TaskResult {
+		names := make([]string, 0, len(results))
+		for name := range results {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var group SubTaskResultGroup
+		for _, name := range names {
+			group.add(&SubTaskResult{Name: name, Status: results[name], IsMain: true})
+		}
+		return &TaskResult{groups: []*SubTaskResultGroup{&group}}
+	}
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo))
+	t.Run("recover", func(t *testing.T) {
+		writer, err := newPartialReportWriter(t.TempDir(), "run", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := withPartialReportWriter(ctx, writer)
+		writePartialReport(ctx, newTaskResult(map[string]TaskResultStatus{"a": TaskResultSuccess, "b": TaskResultFailure}))
+		writePartialReport(ctx, newTaskResult(map[string]TaskResultStatus{"c": TaskResultSuccess}))
+		// retried test replaces the previous result.
+		writePartialReport(ctx, newTaskResult(map[string]TaskResultStatus{"b": TaskResultSuccess}))
+		report, err := RecoverReport(writer.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Interrupted || report.RunID != "run" {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+		if report.Status != ResultStatusSuccess || report.TotalNum != 3 || report.SuccessNum != 3 {
+			t.Fatalf("unexpected report: status %s total %d success %d", report.Status, report.TotalNum, report.SuccessNum)
+		}
+		names := []string{}
+		for _, detail := range report.Details {
+			names = append(names, detail.Name)
+		}
+		if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "c" {
+			t.Fatalf("unexpected details: %v", names)
+		}
+	})
+	t.Run("merge", func(t *testing.T) {
+		writer, err := newPartialReportWriter(t.TempDir(), "run", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.write(newTaskResult(map[string]TaskResultStatus{"a": TaskResultFailure})); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.merge(&Report{RunID: "run", Status: ResultStatusFailure, TotalNum: 1, FailureNum: 1}); err != nil {
+			t.Fatal(err)
+		}
+		paths, err := partialReportPaths(writer.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 0 {
+			t.Fatalf("partial reports must be removed after merged: %v", paths)
+		}
+		report, err := RecoverReport(writer.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Interrupted || report.Status != ResultStatusFailure || report.FailureNum != 1 {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+	})
+	t.Run("indent", func(t *testing.T) {
+		writer, err := newPartialReportWriter(t.TempDir(), "run", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.indent = "  "
+		if err := writer.write(newTaskResult(map[string]TaskResultStatus{"a": TaskResultSuccess})); err != nil {
+			t.Fatal(err)
+		}
+		paths, err := partialReportPaths(writer.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(paths[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "\n") {
+			t.Fatalf("report of the task must be written in a single line: %q", b)
+		}
+		if err := writer.merge(&Report{RunID: "run", Status: ResultStatusSuccess}); err != nil {
+			t.Fatal(err)
+		}
+		b, err = os.ReadFile(filepath.Join(writer.dir, reportJSONFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(b), "{\n  \"runID\": \"run\",\n") {
+			t.Fatalf("merged report must be indented: %q", b)
+		}
+		if report, err := RecoverReport(writer.dir); err != nil || report.RunID != "run" {
+			t.Fatalf("failed to read indented report: %v", err)
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		if _, err := RecoverReport(t.TempDir()); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("atomic write", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "task-0000.json")
+		if err := writeFileAtomically(path, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "task-0000.json" {
+			t.Fatalf("temporary file must not remain: %v", entries)
+		}
+		if err := writeFileAtomically(filepath.Join(dir, "missing", "task.json"), nil); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected not exist error but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,286 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// RunPlan represents steps, containers and mounts planned to be run by TestJob.
+// RunPlan can be serialized as JSON, so it can be compared with the plan of the last run by DiffPlan.
+type RunPlan struct {
+	Steps []*StepPlan `json:"steps"`
+}
+
+type StepPlan struct {
+	Name       string           `json:"name,omitempty"`
+	Type       StepType         `json:"type"`
+	Containers []*ContainerPlan `json:"containers"`
+}
+
+type ContainerPlan struct {
+	Name    string       `json:"name"`
+	Image   string       `json:"image"`
+	Command []string     `json:"command,omitempty"`
+	Args    []string     `json:"args,omitempty"`
+	Mounts  []*MountPlan `json:"mounts,omitempty"`
+}
+
+type MountPlan struct {
+	Name      string `json:"name"`
+	Source    string `json:"source"`
+	MountPath string `json:"mountPath"`
+}
+
+type PlanChangeType string
+
+const (
+	PlanChangeAdded   PlanChangeType = "added"
+	PlanChangeRemoved PlanChangeType = "removed"
+	PlanChangeChanged PlanChangeType = "changed"
+)
+
+// PlanChange represents a change between two RunPlans.
+// Path is the location of the changed step, container or mount ( e.g. mainStep/containers/test/mounts/repo ).
+type PlanChange struct {
+	Type  PlanChangeType `json:"type"`
+	Path  string         `json:"path"`
+	Field string         `json:"field,omitempty"`
+	Old   string         `json:"old,omitempty"`
+	New   string         `json:"new,omitempty"`
+}
+
+type PlanDiff struct {
+	Changes []*PlanChange `json:"changes"`
+}
+
+// NewRunPlan creates the plan of testjob without running anything.
+func NewRunPlan(testjob TestJob) *RunPlan {
+	plan := &RunPlan{}
+	for idx := range testjob.Spec.PreSteps {
+		plan.Steps = append(plan.Steps, newStepPlan(&testjob.Spec.PreSteps[idx]))
+	}
+	plan.Steps = append(plan.Steps, newStepPlan(&testjob.Spec.MainStep))
+	for idx := range testjob.Spec.PostSteps {
+		plan.Steps = append(plan.Steps, newStepPlan(&testjob.Spec.PostSteps[idx]))
+	}
+	return plan
+}
+
+// ReadRunPlan reads the plan serialized by RunPlan.Write.
+func ReadRunPlan(r io.Reader) (*RunPlan, error) {
+	var plan RunPlan
+	if err := json.NewDecoder(r).Decode(&plan); err != nil {
+		return nil, fmt.Errorf("kubetest: failed to decode run plan: %w", err)
+	}
+	return &plan, nil
+}
+
+// Write writes the plan as JSON.
+func (p *RunPlan) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to encode run plan: %w", err)
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("kubetest: failed to write run plan: %w", err)
+	}
+	return nil
+}
+
+func newStepPlan(step Step) *StepPlan {
+	spec := step.GetTemplate().Spec
+	volumeSourceMap := map[string]string{}
+	for _, volume := range spec.Volumes {
+		volumeSourceMap[volume.Name] = volumeSourceName(volume)
+	}
+	containers := make([]*ContainerPlan, 0, len(spec.Containers))
+	for _, container := range spec.Containers {
+		mounts := make([]*MountPlan, 0, len(container.VolumeMounts))
+		for _, mount := range container.VolumeMounts {
+			mounts = append(mounts, &MountPlan{
+				Name:      mount.Name,
+				Source:    volumeSourceMap[mount.Name],
+				MountPath: mount.MountPath,
+			})
+		}
+		containers = append(containers, &ContainerPlan{
+			Name:    container.Name,
+			Image:   container.Image,
+			Command: container.Command,
+			Args:    container.Args,
+			Mounts:  mounts,
+		})
+	}
+	return &StepPlan{
+		Name:       step.GetName(),
+		Type:       step.GetType(),
+		Containers: containers,
+	}
+}
+
+func volumeSourceName(volume TestJobVolume) string {
+	switch {
+	case volume.Repo != nil:
+		return "repo:" + volume.Repo.Name
+	case volume.Artifact != nil:
+		return "artifact:" + volume.Artifact.Name
+	case volume.Token != nil:
+		return "token:" + volume.Token.Name
+	case volume.Log != nil:
+		return "log"
+	case volume.Report != nil:
+		return "report"
+	}
+	return "volume"
+}
+
+func (s *StepPlan) path() string {
+	if s.Name == "" {
+		return string(s.Type)
+	}
+	return fmt.Sprintf("%s/%s", s.Type, s.Name)
+}
+
+// DiffPlan reports added, removed and changed steps, containers and mounts from oldPlan to newPlan.
+func DiffPlan(oldPlan, newPlan *RunPlan) *PlanDiff {
+	diff := &PlanDiff{Changes: []*PlanChange{}}
+	oldSteps := map[string]*StepPlan{}
+	for _, step := range oldPlan.Steps {
+		oldSteps[step.path()] = step
+	}
+	newSteps := map[string]*StepPlan{}
+	for _, step := range newPlan.Steps {
+		newSteps[step.path()] = step
+	}
+	for _, step := range oldPlan.Steps {
+		if _, exists := newSteps[step.path()]; !exists {
+			diff.add(PlanChangeRemoved, step.path())
+		}
+	}
+	for _, step := range newPlan.Steps {
+		oldStep, exists := oldSteps[step.path()]
+		if !exists {
+			diff.add(PlanChangeAdded, step.path())
+			continue
+		}
+		diff.diffContainers(step.path(), oldStep.Containers, step.Containers)
+	}
+	return diff
+}
+
+func (d *PlanDiff) diffContainers(stepPath string, oldContainers, newContainers []*ContainerPlan) {
+	oldContainerMap := map[string]*ContainerPlan{}
+	for _, container := range oldContainers {
+		oldContainerMap[container.Name] = container
+	}
+	newContainerMap := map[string]*ContainerPlan{}
+	for _, container := range newContainers {
+		newContainerMap[container.Name] = container
+	}
+	for _, container := range oldContainers {
+		if _, exists := newContainerMap[container.Name]; !exists {
+			d.add(PlanChangeRemoved, fmt.Sprintf("%s/containers/%s", stepPath, container.Name))
+		}
+	}
+	for _, container := range newContainers {
+		path := fmt.Sprintf("%s/containers/%s", stepPath, container.Name)
+		oldContainer, exists := oldContainerMap[container.Name]
+		if !exists {
+			d.add(PlanChangeAdded, path)
+			continue
+		}
+		if oldContainer.Image != container.Image {
+			d.addChanged(path, "image", oldContainer.Image, container.Image)
+		}
+		if !reflect.DeepEqual(oldContainer.Command, container.Command) {
+			d.addChanged(path, "command", strings.Join(oldContainer.Command, " "), strings.Join(container.Command, " "))
+		}
+		if !reflect.DeepEqual(oldContainer.Args, container.Args) {
+			d.addChanged(path, "args", strings.Join(oldContainer.Args, " "), strings.Join(container.Args, " "))
+		}
+		d.diffMounts(path, oldContainer.Mounts, container.Mounts)
+	}
+}
+
+func (d *PlanDiff) diffMounts(containerPath string, oldMounts, newMounts []*MountPlan) {
+	oldMountMap := map[string]*MountPlan{}
+	for _, mount := range oldMounts {
+		oldMountMap[mount.Name] = mount
+	}
+	newMountMap := map[string]*MountPlan{}
+	for _, mount := range newMounts {
+		newMountMap[mount.Name] = mount
+	}
+	for _, mount := range oldMounts {
+		if _, exists := newMountMap[mount.Name]; !exists {
+			d.add(PlanChangeRemoved, fmt.Sprintf("%s/mounts/%s", containerPath, mount.Name))
+		}
+	}
+	for _, mount := range newMounts {
+		path := fmt.Sprintf("%s/mounts/%s", containerPath, mount.Name)
+		oldMount, exists := oldMountMap[mount.Name]
+		if !exists {
+			d.add(PlanChangeAdded, path)
+			continue
+		}
+		if oldMount.Source != mount.Source {
+			d.addChanged(path, "source", oldMount.Source, mount.Source)
+		}
+		if oldMount.MountPath != mount.MountPath {
+			d.addChanged(path, "mountPath", oldMount.MountPath, mount.MountPath)
+		}
+	}
+}
+
+func (d *PlanDiff) add(typ PlanChangeType, path string) {
+	d.Changes = append(d.Changes, &PlanChange{Type: typ, Path: path})
+}
+
+func (d *PlanDiff) addChanged(path, field, oldValue, newValue string) {
+	d.Changes = append(d.Changes, &PlanChange{
+		Type:  PlanChangeChanged,
+		Path:  path,
+		Field: field,
+		Old:   oldValue,
+		New:   newValue,
+	})
+}
+
+// HasChanges returns true if any step, container or mount is changed.
+func (d *PlanDiff) HasChanges() bool {
+	return len(d.Changes) != 0
+}
+
+// Write writes the diff as JSON so that it can be read by other tools.
+func (d *PlanDiff) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to encode plan diff: %w", err)
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("kubetest: failed to write plan diff: %w", err)
+	}
+	return nil
+}
+
+// String returns human-readable representation of diff.
+func (d *PlanDiff) String() string {
+	lines := make([]string, 0, len(d.Changes))
+	for _, change := range d.Changes {
+		switch change.Type {
+		case PlanChangeAdded:
+			lines = append(lines, fmt.Sprintf("+ %s", change.Path))
+		case PlanChangeRemoved:
+			lines = append(lines, fmt.Sprintf("- %s", change.Path))
+		case PlanChangeChanged:
+			lines = append(lines, fmt.Sprintf("~ %s %s: %q => %q", change.Path, change.Field, change.Old, change.New))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,113 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRunPlan(t *testing.T) {
+	newTestJob := func() TestJob {
+		return TestJob{
+			Spec: TestJobSpec{
+				PreSteps: []PreStep{
+					{
+						Name: "build",
+						Template: TestJobTemplateSpec{
+							Spec: TestJobPodSpec{
+								Containers: []TestJobContainer{
+									{Container: corev1.Container{Name: "build", Image: "golang:1.18", Command: []string{"go"}, Args: []string{"build"}}},
+								},
+							},
+						},
+					},
+				},
+				MainStep: MainStep{
+					Template: TestJobTemplateSpec{
+						Spec: TestJobPodSpec{
+							Containers: []TestJobContainer{
+								{
+									Container: corev1.Container{
+										Name:         "test",
+										Image:        "golang:1.18",
+										Command:      []string{"go"},
+										Args:         []string{"test", "./..."},
+										VolumeMounts: []corev1.VolumeMount{{Name: "repo", MountPath: "/go/src"}},
+									},
+								},
+							},
+							Volumes: []TestJobVolume{
+								{
+									Name: "repo",
+									TestJobVolumeSource: TestJobVolumeSource{
+										Repo: &RepositoryVolumeSource{Name: "kubetest-repo"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	t.Run("serialize", func(t *testing.T) {
+		plan := NewRunPlan(newTestJob())
+		var b bytes.Buffer
+		if err := plan.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ReadRunPlan(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := DiffPlan(plan, decoded); diff.HasChanges() {
+			t.Fatalf("unexpected diff: %s", diff)
+		}
+	})
+	t.Run("diff", func(t *testing.T) {
+		oldJob := newTestJob()
+		newJob := newTestJob()
+		newJob.Spec.PreSteps = nil
+		newJob.Spec.MainStep.Template.Spec.Containers[0].Image = "golang:1.19"
+		newJob.Spec.MainStep.Template.Spec.Containers[0].VolumeMounts[0].MountPath = "/src"
+		newJob.Spec.MainStep.Template.Spec.Containers = append(
+			newJob.Spec.MainStep.Template.Spec.Containers,
+			TestJobContainer{Container: corev1.Container{Name: "sidecar", Image: "redis"}},
+		)
+		newJob.Spec.PostSteps = []PostStep{
+			{
+				Name: "notify",
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{{Container: corev1.Container{Name: "notify", Image: "alpine"}}},
+					},
+				},
+			},
+		}
+		diff := DiffPlan(NewRunPlan(oldJob), NewRunPlan(newJob))
+		expected := `- preStep/build
+~ mainStep/containers/test image: "golang:1.18" => "golang:1.19"
+~ mainStep/containers/test/mounts/repo mountPath: "/go/src" => "/src"
++ mainStep/containers/sidecar
++ postStep/notify`
+		if diff.String() != expected {
+			t.Fatalf("failed to get diff: expected %s but got %s", expected, diff)
+		}
+		b, err := json.Marshal(diff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded PlanDiff
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded.Changes) != 5 {
+			t.Fatalf("failed to decode diff: %s", string(b))
+		}
+		if decoded.Changes[1].Type != PlanChangeChanged || decoded.Changes[1].Field != "image" {
+			t.Fatalf("unexpected change: %+v", decoded.Changes[1])
+		}
+	})
+}
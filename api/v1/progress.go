@@ -0,0 +1,170 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// copyProgressThreshold the progress of copy is reported only if the size of the copied file is larger than this value.
+	copyProgressThreshold int64 = 100 * 1024 * 1024 // 100MiB
+	copyProgressInterval        = 10 * time.Second
+)
+
+// unknownCopySize total passed to startCopyProgress when the size of the source isn't known before the copy
+// ( e.g. getting it needs the command executed in the container ). The progress is reported once the copied size exceeds copyProgressThreshold,
+// and the total is measured by sizeFn when the copy finishes.
+const unknownCopySize int64 = -1
+
+// copyProgress reports the progress of copy periodically by calling sizeFn which returns the size of already copied files.
+type copyProgress struct {
+	desc      string
+	total     int64
+	sizeFn    func(context.Context) (int64, error)
+	startedAt time.Time
+	cancel    func()
+	wg        sync.WaitGroup
+}
+
+// startCopyProgress starts reporting the progress of copy.
+// If total is smaller than copyProgressThreshold, nothing is reported to avoid log spam.
+func startCopyProgress(ctx context.Context, desc string, total int64, sizeFn func(context.Context) (int64, error)) *copyProgress {
+	p := &copyProgress{
+		desc:      desc,
+		total:     total,
+		sizeFn:    sizeFn,
+		startedAt: time.Now(),
+		cancel:    func() {},
+	}
+	if total != unknownCopySize && total < copyProgressThreshold {
+		return p
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p.cancel = cancel
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(copyProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				size, err := p.sizeFn(ctx)
+				if err != nil {
+					continue
+				}
+				if p.total == unknownCopySize {
+					if size >= copyProgressThreshold {
+						LoggerFromContext(ctx).Debug("copied %s of %s", formatBytes(size), p.desc)
+					}
+					continue
+				}
+				LoggerFromContext(ctx).Debug(
+					"copied %s / ~%s of %s",
+					formatBytes(size), formatBytes(p.total), p.desc,
+				)
+			}
+		}
+	}()
+	return p
+}
+
+// stop stops reporting the progress and reports the transfer rate.
+func (p *copyProgress) stop(ctx context.Context) {
+	p.cancel()
+	p.wg.Wait()
+	elapsed := time.Since(p.startedAt)
+	total := p.total
+	if total == unknownCopySize {
+		size, err := p.sizeFn(ctx)
+		if err != nil {
+			return
+		}
+		total = size
+	}
+	if total < copyProgressThreshold {
+		return
+	}
+	LoggerFromContext(ctx).Debug(
+		"copied ~%s of %s in %f sec ( %s/s )",
+		formatBytes(total), p.desc, elapsed.Seconds(), formatBytes(transferRate(total, elapsed)),
+	)
+}
+
+func localCopyWithProgress(ctx context.Context, src, dst string) error {
+	total, _ := localSize(src)
+	progress := startCopyProgress(ctx, src, total, func(context.Context) (int64, error) {
+		return localSize(dst)
+	})
+	defer progress.stop(ctx)
+	return localCopy(src, dst)
+}
+
+func transferRate(size int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return size
+	}
+	return int64(float64(size) / elapsed.Seconds())
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// localSize returns the total size of files in path.
+func localSize(path string) (int64, error) {
+	var size int64
+	if err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	}); err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// parseDiskUsage parses output of `du -sk` command and returns the size in bytes.
+func parseDiskUsage(out []byte) (int64, error) {
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("kubetest: unexpected output of du command: %q", string(out))
+	}
+	kb, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("kubetest: unexpected output of du command: %q: %w", string(out), err)
+	}
+	return kb * 1024, nil
+}
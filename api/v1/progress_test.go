@@ -0,0 +1,107 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCopyProgress(t *testing.T) {
+	t.Run("format bytes", func(t *testing.T) {
+		for _, test := range []struct {
+			size     int64
+			expected string
+		}{
+			{size: 512, expected: "512B"},
+			{size: 1536, expected: "1.5KiB"},
+			{size: 1288490188, expected: "1.2GiB"},
+			{size: 3 * 1024 * 1024 * 1024, expected: "3.0GiB"},
+		} {
+			if got := formatBytes(test.size); got != test.expected {
+				t.Fatalf("failed to format %d: expected %s but got %s", test.size, test.expected, got)
+			}
+		}
+	})
+	t.Run("parse du output", func(t *testing.T) {
+		size, err := parseDiskUsage([]byte("2048\t/tmp/artifact\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != 2048*1024 {
+			t.Fatalf("failed to parse du output: %d", size)
+		}
+		if _, err := parseDiskUsage([]byte("du: /tmp/artifact: No such file or directory")); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("report progress", func(t *testing.T) {
+		defaultThreshold, defaultInterval := copyProgressThreshold, copyProgressInterval
+		defer func() {
+			copyProgressThreshold, copyProgressInterval = defaultThreshold, defaultInterval
+		}()
+		copyProgressThreshold, copyProgressInterval = 1, 10*time.Millisecond
+
+		src := filepath.Join(t.TempDir(), "artifact")
+		if err := os.WriteFile(src, bytes.Repeat([]byte("a"), 2048), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		ctx := WithLogger(context.Background(), NewLogger(&buf, LogLevelDebug))
+		total, err := localSize(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		progress := startCopyProgress(ctx, "artifact", total, func(context.Context) (int64, error) {
+			return 1024, nil
+		})
+		time.Sleep(50 * time.Millisecond)
+		progress.stop(ctx)
+		log := buf.String()
+		if !strings.Contains(log, "copied 1.0KiB / ~2.0KiB of artifact") {
+			t.Fatalf("failed to report progress: %s", log)
+		}
+		if !strings.Contains(log, "copied ~2.0KiB of artifact in") {
+			t.Fatalf("failed to report transfer rate: %s", log)
+		}
+	})
+	t.Run("report progress of unknown size", func(t *testing.T) {
+		defaultThreshold, defaultInterval := copyProgressThreshold, copyProgressInterval
+		defer func() {
+			copyProgressThreshold, copyProgressInterval = defaultThreshold, defaultInterval
+		}()
+		copyProgressThreshold, copyProgressInterval = 1024, 10*time.Millisecond
+
+		var (
+			buf  bytes.Buffer
+			mu   sync.Mutex
+			size int64 = 512
+		)
+		ctx := WithLogger(context.Background(), NewLogger(&buf, LogLevelDebug))
+		progress := startCopyProgress(ctx, "artifact", unknownCopySize, func(context.Context) (int64, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return size, nil
+		})
+		time.Sleep(50 * time.Millisecond)
+		if log := buf.String(); strings.Contains(log, "copied") {
+			t.Fatalf("the progress under the threshold must not be reported: %s", log)
+		}
+		mu.Lock()
+		size = 2048
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		progress.stop(ctx)
+		log := buf.String()
+		if !strings.Contains(log, "copied 2.0KiB of artifact") {
+			t.Fatalf("failed to report progress: %s", log)
+		}
+		if !strings.Contains(log, "copied ~2.0KiB of artifact in") {
+			t.Fatalf("failed to report transfer rate: %s", log)
+		}
+	})
+}
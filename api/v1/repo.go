@@ -5,37 +5,65 @@ package v1
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/klauspost/compress/zstd"
 )
 
 type RepositoryManager struct {
-	repos        []RepositorySpec
-	tokenMgr     *TokenManager
-	clonedPaths  map[string]string
-	archivePaths map[string]string
+	repos       []RepositorySpec
+	tokenMgr    *TokenManager
+	clonedPaths map[string]string
+	// preparedPaths copies of the repositories cloned to clonedPath, where the prepare commands run.
+	// Each copy is created in its own temporary directory.
+	preparedPaths map[string]string
+	archivePaths  map[string]string
+	compressions  map[string]ArchiveCompression
+	// gzipArchivePaths archives created on demand for the containers not supporting the specified compression.
+	gzipArchivePaths map[string]string
+	gzipArchiveMu    sync.Mutex
+	workDir          string
+	// changedPaths paths changed from diffBase of each repository.
+	changedPaths map[string][]string
+	// revisions commit checked out for each repository.
+	revisions map[string]ReportRepository
 }
 
 func NewRepositoryManager(repos []RepositorySpec, tokenMgr *TokenManager) *RepositoryManager {
 	return &RepositoryManager{
-		repos:        repos,
-		tokenMgr:     tokenMgr,
-		clonedPaths:  map[string]string{},
-		archivePaths: map[string]string{},
+		repos:            repos,
+		tokenMgr:         tokenMgr,
+		clonedPaths:      map[string]string{},
+		preparedPaths:    map[string]string{},
+		archivePaths:     map[string]string{},
+		compressions:     map[string]ArchiveCompression{},
+		gzipArchivePaths: map[string]string{},
+		changedPaths:     map[string][]string{},
+		revisions:        map[string]ReportRepository{},
 	}
 }
 
+// SetWorkDir set the directory to clone and archive the repositories.
+// If empty string is specified, the default directory for temporary files is used.
+func (m *RepositoryManager) SetWorkDir(dir string) {
+	m.workDir = dir
+}
+
 func (m *RepositoryManager) Cleanup() error {
 	errs := []string{}
 	for name, clonedPath := range m.clonedPaths {
@@ -43,11 +71,21 @@ func (m *RepositoryManager) Cleanup() error {
 			errs = append(errs, fmt.Sprintf("failed to remove %s repository directory: %s", name, err.Error()))
 		}
 	}
+	for name, preparedPath := range m.preparedPaths {
+		if err := os.RemoveAll(filepath.Dir(preparedPath)); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to remove %s prepared repository directory: %s", name, err.Error()))
+		}
+	}
 	for name, archivePath := range m.archivePaths {
 		if err := os.RemoveAll(archivePath); err != nil {
 			errs = append(errs, fmt.Sprintf("failed to remove %s repository archive directory: %s", name, err.Error()))
 		}
 	}
+	for name, archivePath := range m.gzipArchivePaths {
+		if err := os.RemoveAll(archivePath); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to remove %s repository archive directory: %s", name, err.Error()))
+		}
+	}
 	if len(errs) > 0 {
 		return fmt.Errorf("kubetest: failed to cleanup %s", strings.Join(errs, ":"))
 	}
@@ -56,19 +94,24 @@ func (m *RepositoryManager) Cleanup() error {
 
 func (m *RepositoryManager) CloneAll(ctx context.Context) error {
 	for _, repo := range m.repos {
-		var repoDir string
+		var (
+			repoDir string
+			// branch checked out by clone. The branch of the reused directory is resolved from HEAD.
+			branch string
+		)
 		if repo.Value.ClonedPath != "" {
 			dir := repo.Value.ClonedPath
 			if !existsDir(dir) {
 				if err := m.clone(ctx, dir, repo.Value); err != nil {
 					return err
 				}
+				branch = repo.Value.Branch
 			} else {
 				LoggerFromContext(ctx).Info("reuse an already cloned directory: %s", dir)
 			}
 			repoDir = dir
 		} else {
-			dir, err := os.MkdirTemp("", "repo")
+			dir, err := os.MkdirTemp(m.workDir, "repo")
 			if err != nil {
 				return fmt.Errorf("kubetest: failed to create temporary directory for repository: %w", err)
 			}
@@ -76,21 +119,74 @@ func (m *RepositoryManager) CloneAll(ctx context.Context) error {
 				return err
 			}
 			repoDir = dir
+			branch = repo.Value.Branch
 		}
-		repoArchiveDir, err := os.MkdirTemp("", "repo-archive")
+		revision, err := checkedOutRevision(repo.Name, repoDir, branch)
 		if err != nil {
-			return fmt.Errorf("kubetest: failed to create temporary directory for repository archive: %w", err)
+			return err
+		}
+		if revision != nil {
+			LoggerFromContext(ctx).Info("checked out %s repository at %s", repo.Name, revision.SHA)
+			m.revisions[repo.Name] = *revision
+		} else {
+			LoggerFromContext(ctx).Info("%s repository has no checked out commit", repo.Name)
 		}
-		repoArchivePath := filepath.Join(repoArchiveDir, "repo.tar.gz")
-		if err := m.archiveRepo(repoDir, repoArchivePath); err != nil {
+		if base := repo.Value.DiffBase; base != "" {
+			paths, err := diffChangedPaths(ctx, repoDir, base)
+			if err != nil {
+				return err
+			}
+			LoggerFromContext(ctx).Debug("changed paths of %s repository: %v", repo.Name, paths)
+			m.changedPaths[repo.Name] = paths
+		}
+		if repo.Value.ClonedPath != "" && len(repo.Value.PrepareCommands) != 0 {
+			// clonedPath is the directory of the caller, which may be reused by the next run,
+			// so the prepare commands run on the copy of it.
+			preparedPath, err := m.copyRepo(repo.Name, repoDir)
+			if err != nil {
+				return err
+			}
+			m.preparedPaths[repo.Name] = preparedPath
+			repoDir = preparedPath
+		}
+		if err := m.runPrepareCommands(ctx, repoDir, repo); err != nil {
+			return err
+		}
+		if err := m.checkArchiveSpace(repo.Name, repoDir); err != nil {
+			return err
+		}
+		compression := repo.Value.ArchiveCompression
+		if compression == "" {
+			compression = ArchiveCompressionGzip
+		}
+		repoArchivePath, err := m.createArchive(ctx, repo.Name, repoDir, compression)
+		if err != nil {
 			return err
 		}
 		m.archivePaths[repo.Name] = repoArchivePath
+		m.compressions[repo.Name] = compression
 		m.clonedPaths[repo.Name] = repoDir
 	}
 	return nil
 }
 
+// checkArchiveSpace checks whether the working directory has enough space to archive the repository.
+// The size of the archive doesn't exceed the size of the repository in most cases, so the repository size is required.
+func (m *RepositoryManager) checkArchiveSpace(name, repoDir string) error {
+	size, err := localSize(repoDir)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to get size of %s repository: %w", name, err)
+	}
+	dir := m.workDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := checkFreeSpace(dir, uint64(size)); err != nil {
+		return fmt.Errorf("kubetest: failed to archive %s repository: %w", name, err)
+	}
+	return nil
+}
+
 func (m *RepositoryManager) clone(ctx context.Context, clonedPath string, repo Repository) error {
 	LoggerFromContext(ctx).Info("clone repository: %s", repo.URL)
 
@@ -212,20 +308,113 @@ func (m *RepositoryManager) clone(ctx context.Context, clonedPath string, repo R
 	return nil
 }
 
-func (m *RepositoryManager) archiveRepo(repoDir, archivePath string) error {
+// copyRepo copies the repository to the new temporary directory in the working directory and returns the path to the copy.
+func (m *RepositoryManager) copyRepo(name, repoDir string) (string, error) {
+	if err := m.checkArchiveSpace(name, repoDir); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(m.workDir, "repo-prepared")
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to create temporary directory for repository: %w", err)
+	}
+	preparedPath := filepath.Join(dir, "repo")
+	if err := localCopy(repoDir, preparedPath); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("kubetest: failed to copy %s repository to prepare it: %w", name, err)
+	}
+	return preparedPath, nil
+}
+
+func (m *RepositoryManager) runPrepareCommands(ctx context.Context, repoDir string, repo RepositorySpec) error {
+	for _, prepareCmd := range repo.Value.PrepareCommands {
+		command := strings.Join(prepareCmd.Command, " ")
+		LoggerFromContext(ctx).Info("prepare repository %s: %s", repo.Name, command)
+		cmd := exec.CommandContext(ctx, prepareCmd.Command[0], prepareCmd.Command[1:]...)
+		cmd.Dir = repoDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("kubetest: failed to prepare repository %s by %q: %s: %w", repo.Name, command, string(out), err)
+		}
+		if len(out) != 0 {
+			LoggerFromContext(ctx).Debug(string(out))
+		}
+	}
+	return nil
+}
+
+// archiveFileName returns the file name of the repository archive compressed by compression.
+func archiveFileName(compression ArchiveCompression) string {
+	switch compression {
+	case ArchiveCompressionZstd:
+		return "repo.tar.zst"
+	case ArchiveCompressionNone:
+		return "repo.tar"
+	}
+	return "repo.tar.gz"
+}
+
+// createArchive archives the repository to the new temporary directory and returns the path to the archive.
+// The time to archive and the size of the archive are logged at debug level to compare the compressions.
+func (m *RepositoryManager) createArchive(ctx context.Context, name, repoDir string, compression ArchiveCompression) (string, error) {
+	repoArchiveDir, err := os.MkdirTemp(m.workDir, "repo-archive")
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to create temporary directory for repository archive: %w", err)
+	}
+	repoArchivePath := filepath.Join(repoArchiveDir, archiveFileName(compression))
+	start := time.Now()
+	if err := m.archiveRepo(repoDir, repoArchivePath, compression); err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(repoArchivePath); err == nil {
+		LoggerFromContext(ctx).Debug(
+			"archived %s repository by %s in %s ( %d bytes )",
+			name, compression, time.Since(start), info.Size(),
+		)
+	}
+	return repoArchivePath, nil
+}
+
+// zstdProbeArchive returns the empty tar archive compressed by zstd to probe whether tar of the container can extract it.
+func zstdProbeArchive() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tar.NewWriter(&buf).Close(); err != nil {
+		return nil, err
+	}
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer zw.Close()
+	return zw.EncodeAll(buf.Bytes(), nil), nil
+}
+
+func (m *RepositoryManager) archiveRepo(repoDir, archivePath string, compression ArchiveCompression) error {
 	dst, err := os.Create(archivePath)
 	if err != nil {
 		return fmt.Errorf("kubetest: failed to create archive file for repository: %w", err)
 	}
 	defer dst.Close()
 
-	gzw, err := gzip.NewWriterLevel(dst, gzip.BestCompression)
-	if err != nil {
-		return fmt.Errorf("kubetest: failed to create gzip writer: %w", err)
+	var w io.Writer = dst
+	switch compression {
+	case ArchiveCompressionZstd:
+		zw, err := zstd.NewWriter(dst)
+		if err != nil {
+			return fmt.Errorf("kubetest: failed to create zstd writer: %w", err)
+		}
+		defer zw.Close()
+		w = zw
+	case ArchiveCompressionNone:
+	default:
+		gzw, err := gzip.NewWriterLevel(dst, gzip.BestCompression)
+		if err != nil {
+			return fmt.Errorf("kubetest: failed to create gzip writer: %w", err)
+		}
+		defer gzw.Close()
+		w = gzw
 	}
-	defer gzw.Close()
 
-	tw := tar.NewWriter(gzw)
+	tw := tar.NewWriter(w)
 	defer tw.Close()
 
 	return filepath.Walk(repoDir, func(path string, info os.FileInfo, err error) error {
@@ -273,6 +462,63 @@ func (m *RepositoryManager) archiveRepo(repoDir, archivePath string) error {
 	})
 }
 
+// ChangedPaths returns the paths changed from diffBase of all repositories.
+// The second value is false if no repository has diffBase.
+func (m *RepositoryManager) ChangedPaths() ([]string, bool) {
+	if len(m.changedPaths) == 0 {
+		return nil, false
+	}
+	paths := []string{}
+	for _, repo := range m.repos {
+		paths = append(paths, m.changedPaths[repo.Name]...)
+	}
+	return paths, true
+}
+
+// checkedOutRevision returns the commit and the branch checked out in repoDir.
+// The commit is resolved before running prepareCommands, so it is the revision of the repository the tests ran against.
+// branch is the branch checked out by clone. It is checked out from the remote tracking branch and HEAD is detached,
+// so it is recorded instead of the branch of HEAD.
+// If the reused directory isn't a git repository or has no commits, returns nil.
+func checkedOutRevision(name, repoDir, branch string) (*ReportRepository, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		if errors.Is(err, git.ErrRepositoryNotExists) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("kubetest: failed to open %s repository to resolve the checked out commit: %w", name, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("kubetest: failed to resolve the checked out commit of %s repository: %w", name, err)
+	}
+	revision := &ReportRepository{Name: name, SHA: head.Hash().String()}
+	switch {
+	case head.Name().IsBranch():
+		// the branch created to check out rev is named by the commit, so it isn't recorded.
+		if ref := head.Name().Short(); !plumbing.IsHash(ref) {
+			revision.Ref = ref
+		}
+	case branch != "":
+		revision.Ref = branch
+	}
+	return revision, nil
+}
+
+// Revisions returns the commit checked out for each repository in the order of the specified repositories.
+func (m *RepositoryManager) Revisions() []ReportRepository {
+	revisions := make([]ReportRepository, 0, len(m.revisions))
+	for _, repo := range m.repos {
+		if revision, exists := m.revisions[repo.Name]; exists {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions
+}
+
 func (m *RepositoryManager) ArchivePathByRepoName(name string) (string, error) {
 	path, exists := m.archivePaths[name]
 	if !exists {
@@ -280,3 +526,37 @@ func (m *RepositoryManager) ArchivePathByRepoName(name string) (string, error) {
 	}
 	return path, nil
 }
+
+// ArchiveCompressionByRepoName returns the compression of the archive returned by ArchivePathByRepoName.
+func (m *RepositoryManager) ArchiveCompressionByRepoName(name string) ArchiveCompression {
+	if compression, exists := m.compressions[name]; exists {
+		return compression
+	}
+	return ArchiveCompressionGzip
+}
+
+// GzipArchivePathByRepoName returns the path to the archive compressed by gzip.
+// This is used for the containers whose tar doesn't support the specified compression, so the archive is created on the first call.
+func (m *RepositoryManager) GzipArchivePathByRepoName(ctx context.Context, name string) (string, error) {
+	if m.ArchiveCompressionByRepoName(name) == ArchiveCompressionGzip {
+		return m.ArchivePathByRepoName(name)
+	}
+	m.gzipArchiveMu.Lock()
+	defer m.gzipArchiveMu.Unlock()
+	if path, exists := m.gzipArchivePaths[name]; exists {
+		return path, nil
+	}
+	repoDir, exists := m.clonedPaths[name]
+	if !exists {
+		return "", fmt.Errorf("kubetest: repository name %s is undefined", name)
+	}
+	if preparedPath, exists := m.preparedPaths[name]; exists {
+		repoDir = preparedPath
+	}
+	path, err := m.createArchive(ctx, name, repoDir, ArchiveCompressionGzip)
+	if err != nil {
+		return "", err
+	}
+	m.gzipArchivePaths[name] = path
+	return path, nil
+}
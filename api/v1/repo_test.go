@@ -1,13 +1,18 @@
 package v1
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/go-git/go-billy/v5"
@@ -16,9 +21,87 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/klauspost/compress/zstd"
 	"github.com/sosedoff/gitkit"
+	corev1 "k8s.io/api/core/v1"
 )
 
+// zstdProbeExecutor executor of the container whose tar supports zstd if supported is true.
+// If err is set, the probe fails to run the command.
+type zstdProbeExecutor struct {
+	JobExecutor
+	image     string
+	supported bool
+	err       error
+	calls     *int
+}
+
+func (e *zstdProbeExecutor) Container() corev1.Container {
+	return corev1.Container{Name: "test", Image: e.image}
+}
+
+func (e *zstdProbeExecutor) PrepareCommand(context.Context, []string) ([]byte, error) {
+	*e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	if !e.supported {
+		return []byte("tar: unrecognized option '--zstd'\n" + zstdUnsupportedMarker + "\n"), nil
+	}
+	return []byte(zstdSupportedMarker + "\n"), nil
+}
+
+func TestSupportsZstd(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	t.Run("cache", func(t *testing.T) {
+		builder := NewTaskBuilder(nil, nil, "default", RunModeKubernetes)
+		var calls int
+		for i := 0; i < 3; i++ {
+			if !builder.supportsZstd(ctx, &zstdProbeExecutor{image: "golang:1.22", supported: true, calls: &calls}) {
+				t.Fatal("expected zstd to be supported")
+			}
+			if builder.supportsZstd(ctx, &zstdProbeExecutor{image: "busybox", calls: &calls}) {
+				t.Fatal("expected zstd not to be supported")
+			}
+		}
+		if calls != 2 {
+			t.Fatalf("expected to probe once per image but probed %d times", calls)
+		}
+	})
+	t.Run("probe error", func(t *testing.T) {
+		builder := NewTaskBuilder(nil, nil, "default", RunModeKubernetes)
+		var calls int
+		if builder.supportsZstd(ctx, &zstdProbeExecutor{image: "golang:1.22", supported: true, err: fmt.Errorf("connection refused"), calls: &calls}) {
+			t.Fatal("expected gzip to be used if the probe failed")
+		}
+		if !builder.supportsZstd(ctx, &zstdProbeExecutor{image: "golang:1.22", supported: true, calls: &calls}) {
+			t.Fatal("the failed probe must not be cached")
+		}
+		if calls != 2 {
+			t.Fatalf("expected to probe again after the failure but probed %d times", calls)
+		}
+	})
+	t.Run("archive", func(t *testing.T) {
+		cmd, err := zstdProbeCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the archive printed by the shell must be the archive compressed by zstd.
+		out, err := exec.Command("sh", "-c", strings.Join(cmd[:2], " ")).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zstd.NewReader(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		if _, err := tar.NewReader(zr).Next(); err != io.EOF {
+			t.Fatalf("expected the empty tar archive but got %v", err)
+		}
+	})
+}
+
 func TestRepositoryManager(t *testing.T) {
 	t.Run("checkout branch", func(t *testing.T) {
 		mgr := NewRepositoryManager([]RepositorySpec{
@@ -116,6 +199,224 @@ func TestRepositoryManager(t *testing.T) {
 		}
 		t.Logf("archive path: %s", path)
 	})
+	t.Run("prepare commands", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		spec := RepositorySpec{
+			Name: "test",
+			Value: Repository{
+				ClonedPath: dir,
+				PrepareCommands: []RepositoryCommand{
+					{Command: []string{"sh", "-c", "echo -n prepared > prepared.txt"}},
+				},
+			},
+		}
+		if err := NewValidator().ValidateRepositorySpec(spec); err != nil {
+			t.Fatal(err)
+		}
+		mgr := NewRepositoryManager([]RepositorySpec{spec}, new(TokenManager))
+		defer func() {
+			if err := mgr.Cleanup(); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		if err := mgr.CloneAll(WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "prepared.txt")); !os.IsNotExist(err) {
+			t.Fatalf("the prepare commands must not change clonedPath: %v", err)
+		}
+		archivePath, err := mgr.ArchivePathByRepoName("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gzr)
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				t.Fatalf("the prepared file must be archived: %v", err)
+			}
+			if filepath.Base(header.Name) != "prepared.txt" {
+				continue
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "prepared" {
+				t.Fatalf("unexpected content: %q", content)
+			}
+			break
+		}
+	})
+	t.Run("checked out commit", func(t *testing.T) {
+		branchDir := createChangedRepo(t, "main.go")
+		detachedDir := createChangedRepo(t, "main.go")
+		repo, err := git.PlainOpen(detachedDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, err := repo.Head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		worktree, err := repo.Worktree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := worktree.Checkout(&git.CheckoutOptions{Hash: head.Hash()}); err != nil {
+			t.Fatal(err)
+		}
+		specs := []RepositorySpec{
+			{Name: "branch", Value: Repository{ClonedPath: branchDir}},
+			{Name: "detached", Value: Repository{ClonedPath: detachedDir}},
+			{Name: "plain", Value: Repository{ClonedPath: t.TempDir()}},
+		}
+		mgr := NewRepositoryManager(specs, new(TokenManager))
+		defer mgr.Cleanup()
+		if err := mgr.CloneAll(WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))); err != nil {
+			t.Fatal(err)
+		}
+		revisions := mgr.Revisions()
+		if len(revisions) != 2 {
+			t.Fatalf("unexpected revisions: %+v", revisions)
+		}
+		if revisions[0].Name != "branch" || revisions[0].Ref == "" || len(revisions[0].SHA) != 40 {
+			t.Fatalf("unexpected revision of the branch: %+v", revisions[0])
+		}
+		expected := ReportRepository{Name: "detached", SHA: head.Hash().String()}
+		if revisions[1] != expected {
+			t.Fatalf("unexpected revision of the detached HEAD: %+v", revisions[1])
+		}
+	})
+	t.Run("checked out branch", func(t *testing.T) {
+		srcDir := createChangedRepo(t, "main.go")
+		if out, err := exec.Command("git", "-C", srcDir, "branch", "feature", "base").CombinedOutput(); err != nil {
+			t.Fatalf("failed to create branch: %s: %v", out, err)
+		}
+		src, err := git.PlainOpen(srcDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base, err := src.Reference(plumbing.NewBranchReferenceName("feature"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		specs := []RepositorySpec{
+			{Name: "branch", Value: Repository{URL: srcDir, Branch: "feature"}},
+			{Name: "rev", Value: Repository{URL: srcDir, Rev: base.Hash().String()}},
+		}
+		mgr := NewRepositoryManager(specs, new(TokenManager))
+		mgr.SetWorkDir(t.TempDir())
+		defer mgr.Cleanup()
+		if err := mgr.CloneAll(WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))); err != nil {
+			t.Fatal(err)
+		}
+		revisions := mgr.Revisions()
+		if len(revisions) != 2 {
+			t.Fatalf("unexpected revisions: %+v", revisions)
+		}
+		expected := ReportRepository{Name: "branch", Ref: "feature", SHA: base.Hash().String()}
+		if revisions[0] != expected {
+			t.Fatalf("unexpected revision of the branch: %+v", revisions[0])
+		}
+		expected = ReportRepository{Name: "rev", SHA: base.Hash().String()}
+		if revisions[1] != expected {
+			t.Fatalf("unexpected revision of the rev: %+v", revisions[1])
+		}
+	})
+	t.Run("invalid prepare command", func(t *testing.T) {
+		spec := RepositorySpec{
+			Name: "test",
+			Value: Repository{
+				URL:             "https://github.com/goccy/kubetest.git",
+				PrepareCommands: []RepositoryCommand{{}},
+			},
+		}
+		if err := NewValidator().ValidateRepositorySpec(spec); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("archive compression", func(t *testing.T) {
+		for _, compression := range []ArchiveCompression{"", ArchiveCompressionGzip, ArchiveCompressionZstd, ArchiveCompressionNone} {
+			compression := compression
+			t.Run(string(compression), func(t *testing.T) {
+				dir := t.TempDir()
+				if err := os.WriteFile(filepath.Join(dir, "test.txt"), []byte("test"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				spec := RepositorySpec{
+					Name:  "test",
+					Value: Repository{ClonedPath: dir, ArchiveCompression: compression},
+				}
+				if err := NewValidator().ValidateRepositorySpec(spec); err != nil {
+					t.Fatal(err)
+				}
+				mgr := NewRepositoryManager([]RepositorySpec{spec}, new(TokenManager))
+				defer func() {
+					if err := mgr.Cleanup(); err != nil {
+						t.Fatal(err)
+					}
+				}()
+				ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+				if err := mgr.CloneAll(ctx); err != nil {
+					t.Fatal(err)
+				}
+				path, err := mgr.ArchivePathByRepoName("test")
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := compression
+				if expected == "" {
+					expected = ArchiveCompressionGzip
+				}
+				if mgr.ArchiveCompressionByRepoName("test") != expected {
+					t.Fatalf("unexpected compression: %s", mgr.ArchiveCompressionByRepoName("test"))
+				}
+				if filepath.Base(path) != archiveFileName(expected) {
+					t.Fatalf("unexpected archive name: %s", path)
+				}
+				assertArchivedFile(t, path, expected, "test.txt", "test")
+
+				gzipPath, err := mgr.GzipArchivePathByRepoName(ctx, "test")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if filepath.Base(gzipPath) != "repo.tar.gz" {
+					t.Fatalf("unexpected gzip archive name: %s", gzipPath)
+				}
+				assertArchivedFile(t, gzipPath, ArchiveCompressionGzip, "test.txt", "test")
+			})
+		}
+	})
+	t.Run("invalid archive compression", func(t *testing.T) {
+		spec := RepositorySpec{
+			Name: "test",
+			Value: Repository{
+				URL:                "https://github.com/goccy/kubetest.git",
+				ArchiveCompression: "bzip2",
+			},
+		}
+		if err := NewValidator().ValidateRepositorySpec(spec); err == nil {
+			t.Fatal("expected error")
+		}
+	})
 	t.Run("add a file that specified to be ignored on the base branch", func(t *testing.T) {
 		addr, reposDir := runGitServer(t)
 
@@ -295,3 +596,51 @@ func assertFile(t *testing.T, fs billy.Filesystem, path string, expect string) {
 		t.Errorf("%s: expect %q but got %q", path, expect, got)
 	}
 }
+
+func assertArchivedFile(t *testing.T, archivePath string, compression ArchiveCompression, name, expect string) {
+	t.Helper()
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch compression {
+	case ArchiveCompressionGzip:
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gzr.Close()
+		r = gzr
+	case ArchiveCompressionZstd:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name != name {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expect {
+			t.Fatalf("unexpected content of %s: %q", name, content)
+		}
+		return
+	}
+	t.Fatalf("failed to find %s in %s", name, archivePath)
+}
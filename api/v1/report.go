@@ -0,0 +1,15 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+// GroupDetailsByMetadata groups the details by the value of metadata specified by key ( e.g. owner, component ).
+// The details not having the metadata are grouped by empty string.
+func (r *Report) GroupDetailsByMetadata(key string) map[string][]*ReportDetail {
+	groups := map[string][]*ReportDetail{}
+	for _, detail := range r.Details {
+		value := detail.Metadata[key]
+		groups[value] = append(groups[value], detail)
+	}
+	return groups
+}
@@ -0,0 +1,190 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DefaultSlowdownThreshold ratio of the increase of elapsed time reported as slower by DiffReports.
+const DefaultSlowdownThreshold = 0.2
+
+// ReportDiff represents the changes of the tests from the report of the baseline run ( e.g. the last green run ).
+// Each list is sorted by the name of the test.
+type ReportDiff struct {
+	// NewFailures tests failed in the new report but not in the old report. This includes the added tests that failed.
+	NewFailures []string `json:"newFailures"`
+	// Fixed tests failed in the old report but succeeded in the new report.
+	Fixed []string `json:"fixed"`
+	// Added tests only in the new report.
+	Added []string `json:"added"`
+	// Removed tests only in the old report.
+	Removed []string `json:"removed"`
+	// Slower tests whose elapsed time increased more than the threshold.
+	Slower []*ReportElapsedDelta `json:"slower"`
+}
+
+// ReportElapsedDelta elapsed time of the test in the old and new report.
+type ReportElapsedDelta struct {
+	Name              string  `json:"name"`
+	OldElapsedTimeSec int64   `json:"oldElapsedTimeSec"`
+	NewElapsedTimeSec int64   `json:"newElapsedTimeSec"`
+	Ratio             float64 `json:"ratio"`
+}
+
+// ReadReport reads the report written as JSON.
+func ReadReport(r io.Reader) (*Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, fmt.Errorf("kubetest: failed to decode report: %w", err)
+	}
+	return &report, nil
+}
+
+// DiffReports reports the changes of the tests from oldReport to newReport.
+// The tests slowed down more than DefaultSlowdownThreshold are reported as slower.
+func DiffReports(oldReport, newReport *Report) *ReportDiff {
+	return DiffReportsWithThreshold(oldReport, newReport, DefaultSlowdownThreshold)
+}
+
+// DiffReportsWithThreshold reports the changes of the tests from oldReport to newReport.
+// The tests whose elapsed time increased more than threshold ( e.g. 0.2 means 20% ) are reported as slower.
+// The tests which took less than a second in oldReport are not compared because the elapsed time is recorded in seconds.
+func DiffReportsWithThreshold(oldReport, newReport *Report, threshold float64) *ReportDiff {
+	diff := &ReportDiff{
+		NewFailures: []string{},
+		Fixed:       []string{},
+		Added:       []string{},
+		Removed:     []string{},
+		Slower:      []*ReportElapsedDelta{},
+	}
+	oldDetails := reportDetailMap(oldReport)
+	newDetails := reportDetailMap(newReport)
+	for _, name := range sortedDetailNames(oldDetails) {
+		if _, exists := newDetails[name]; !exists {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	for _, name := range sortedDetailNames(newDetails) {
+		newDetail := newDetails[name]
+		oldDetail, exists := oldDetails[name]
+		if !exists {
+			diff.Added = append(diff.Added, name)
+			if newDetail.Status != ResultStatusSuccess {
+				diff.NewFailures = append(diff.NewFailures, name)
+			}
+			continue
+		}
+		switch {
+		case oldDetail.Status == ResultStatusSuccess && newDetail.Status != ResultStatusSuccess:
+			diff.NewFailures = append(diff.NewFailures, name)
+		case oldDetail.Status != ResultStatusSuccess && newDetail.Status == ResultStatusSuccess:
+			diff.Fixed = append(diff.Fixed, name)
+		}
+		if oldDetail.ElapsedTimeSec <= 0 {
+			continue
+		}
+		ratio := float64(newDetail.ElapsedTimeSec-oldDetail.ElapsedTimeSec) / float64(oldDetail.ElapsedTimeSec)
+		if ratio > threshold {
+			diff.Slower = append(diff.Slower, &ReportElapsedDelta{
+				Name:              name,
+				OldElapsedTimeSec: oldDetail.ElapsedTimeSec,
+				NewElapsedTimeSec: newDetail.ElapsedTimeSec,
+				Ratio:             ratio,
+			})
+		}
+	}
+	return diff
+}
+
+// CompareBaseline compares the tests of report with baseline. path is the location of baseline recorded to the comparison.
+// The tests failed in both reports are regarded as known failures, so they don't make the run fail.
+func CompareBaseline(baseline, report *Report, path string) *ReportBaseline {
+	diff := DiffReports(baseline, report)
+	compared := &ReportBaseline{
+		Path:          path,
+		NewFailures:   diff.NewFailures,
+		KnownFailures: []string{},
+		Improvements:  diff.Fixed,
+	}
+	oldDetails := reportDetailMap(baseline)
+	newDetails := reportDetailMap(report)
+	for _, name := range sortedDetailNames(newDetails) {
+		if newDetails[name].Status == ResultStatusSuccess {
+			continue
+		}
+		if oldDetail, exists := oldDetails[name]; exists && oldDetail.Status != ResultStatusSuccess {
+			compared.KnownFailures = append(compared.KnownFailures, name)
+		}
+	}
+	return compared
+}
+
+// reportDetailMap returns the details of report by the name of the test.
+// If the same name appears multiple times, the last one is used.
+func reportDetailMap(report *Report) map[string]*ReportDetail {
+	details := map[string]*ReportDetail{}
+	if report == nil {
+		return details
+	}
+	for _, detail := range report.Details {
+		details[detail.Name] = detail
+	}
+	return details
+}
+
+func sortedDetailNames(details map[string]*ReportDetail) []string {
+	names := make([]string, 0, len(details))
+	for name := range details {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasChanges returns true if any test is changed from the old report.
+func (d *ReportDiff) HasChanges() bool {
+	return len(d.NewFailures) != 0 || len(d.Fixed) != 0 || len(d.Added) != 0 || len(d.Removed) != 0 || len(d.Slower) != 0
+}
+
+// HasRegressions returns true if any test newly failed or got slower.
+func (d *ReportDiff) HasRegressions() bool {
+	return len(d.NewFailures) != 0 || len(d.Slower) != 0
+}
+
+// Markdown returns the diff rendered as Markdown.
+func (d *ReportDiff) Markdown() string {
+	var b strings.Builder
+	b.WriteString("## Diff from the baseline report\n")
+	if !d.HasChanges() {
+		b.WriteString("\nNo changes.\n")
+		return b.String()
+	}
+	writeNames := func(title string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n### %s ( %d )\n\n", title, len(names))
+		for _, name := range names {
+			fmt.Fprintf(&b, "- `%s`\n", name)
+		}
+	}
+	writeNames("New failures", d.NewFailures)
+	writeNames("Fixed", d.Fixed)
+	writeNames("Added", d.Added)
+	writeNames("Removed", d.Removed)
+	if len(d.Slower) != 0 {
+		fmt.Fprintf(&b, "\n### Slower ( %d )\n\n", len(d.Slower))
+		b.WriteString("| name | old ( sec ) | new ( sec ) | delta |\n")
+		b.WriteString("| ---- | ---- | ---- | ---- |\n")
+		for _, delta := range d.Slower {
+			fmt.Fprintf(&b, "| `%s` | %d | %d | +%.1f%% |\n", delta.Name, delta.OldElapsedTimeSec, delta.NewElapsedTimeSec, delta.Ratio*100)
+		}
+	}
+	return b.String()
+}
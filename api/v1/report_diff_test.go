@@ -0,0 +1,81 @@
+package v1
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readReportFixture(t *testing.T, name string) *Report {
+	t.Helper()
+	f, err := os.Open(filepath.Join("..", "..", "testdata", "report", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	report, err := ReadReport(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestDiffReports(t *testing.T) {
+	oldReport := readReportFixture(t, "old.json")
+	newReport := readReportFixture(t, "new.json")
+	t.Run("golden", func(t *testing.T) {
+		diff := DiffReports(oldReport, newReport)
+		if !diff.HasRegressions() {
+			t.Fatal("expected regressions")
+		}
+		golden, err := os.ReadFile(filepath.Join("..", "..", "testdata", "report", "diff.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff.Markdown() != string(golden) {
+			t.Fatalf("unexpected markdown:\n%s", diff.Markdown())
+		}
+	})
+	t.Run("threshold", func(t *testing.T) {
+		diff := DiffReportsWithThreshold(oldReport, newReport, 0.5)
+		if len(diff.Slower) != 0 {
+			t.Fatalf("the increase equal to threshold must not be reported: %+v", diff.Slower[0])
+		}
+	})
+	t.Run("same report", func(t *testing.T) {
+		diff := DiffReports(newReport, newReport)
+		if diff.HasChanges() {
+			t.Fatalf("unexpected changes: %+v", diff)
+		}
+		if diff.Markdown() != "## Diff from the baseline report\n\nNo changes.\n" {
+			t.Fatalf("unexpected markdown: %q", diff.Markdown())
+		}
+	})
+}
+
+func TestCompareBaseline(t *testing.T) {
+	oldReport := readReportFixture(t, "old.json")
+	newReport := readReportFixture(t, "new.json")
+	t.Run("regressed", func(t *testing.T) {
+		compared := CompareBaseline(oldReport, newReport, "old.json")
+		if !reflect.DeepEqual(compared.NewFailures, []string{"TestB", "TestG"}) {
+			t.Fatalf("unexpected new failures: %v", compared.NewFailures)
+		}
+		if !reflect.DeepEqual(compared.Improvements, []string{"TestC"}) {
+			t.Fatalf("unexpected improvements: %v", compared.Improvements)
+		}
+		if len(compared.KnownFailures) != 0 {
+			t.Fatalf("unexpected known failures: %v", compared.KnownFailures)
+		}
+	})
+	t.Run("known failures", func(t *testing.T) {
+		compared := CompareBaseline(newReport, newReport, "new.json")
+		if len(compared.NewFailures) != 0 || len(compared.Improvements) != 0 {
+			t.Fatalf("unexpected changes: %+v", compared)
+		}
+		if !reflect.DeepEqual(compared.KnownFailures, []string{"TestB", "TestG"}) {
+			t.Fatalf("unexpected known failures: %v", compared.KnownFailures)
+		}
+	})
+}
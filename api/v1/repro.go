@@ -0,0 +1,86 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// envSnapshot returns the environment variables of container resolved at the time the test ran.
+// The values are masked by mask. The values referenced from the other resources are resolved by kubernetes,
+// so they are recorded as the reference ( e.g. <secret:name/key> ).
+func envSnapshot(container corev1.Container, mask func(string) string) []corev1.EnvVar {
+	env := make([]corev1.EnvVar, 0, len(container.Env))
+	for _, e := range container.Env {
+		value := mask(e.Value)
+		if e.ValueFrom != nil {
+			value = envSourceRef(e.ValueFrom)
+		}
+		env = append(env, corev1.EnvVar{Name: e.Name, Value: value})
+	}
+	return env
+}
+
+func envSourceRef(source *corev1.EnvVarSource) string {
+	switch {
+	case source.SecretKeyRef != nil:
+		return fmt.Sprintf("<secret:%s/%s>", source.SecretKeyRef.Name, source.SecretKeyRef.Key)
+	case source.ConfigMapKeyRef != nil:
+		return fmt.Sprintf("<configmap:%s/%s>", source.ConfigMapKeyRef.Name, source.ConfigMapKeyRef.Key)
+	case source.FieldRef != nil:
+		return fmt.Sprintf("<field:%s>", source.FieldRef.FieldPath)
+	case source.ResourceFieldRef != nil:
+		return fmt.Sprintf("<resource:%s>", source.ResourceFieldRef.Resource)
+	}
+	return ""
+}
+
+// ReproCommand returns the docker command to reproduce the test on local with the same image, command, environment variables and mounts.
+// The sources of volumes ( e.g. repository, artifact ) and envFrom are rendered as the placeholders to be replaced by the local ones.
+func (r *SubTaskResult) ReproCommand() string {
+	args := []string{"docker", "run", "--rm"}
+	if r.WorkingDir != "" {
+		args = append(args, "-w", r.WorkingDir)
+	}
+	for _, env := range r.Env {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", env.Name, env.Value))
+	}
+	for _, envFrom := range r.Container.EnvFrom {
+		switch {
+		case envFrom.ConfigMapRef != nil:
+			args = append(args, "--env-file", fmt.Sprintf("<configmap:%s>", envFrom.ConfigMapRef.Name))
+		case envFrom.SecretRef != nil:
+			args = append(args, "--env-file", fmt.Sprintf("<secret:%s>", envFrom.SecretRef.Name))
+		}
+	}
+	for _, mount := range r.Container.VolumeMounts {
+		args = append(args, "-v", fmt.Sprintf("<%s>:%s", mount.Name, mount.MountPath))
+	}
+	if len(r.Container.Command) != 0 {
+		args = append(args, "--entrypoint", r.Container.Command[0])
+	}
+	args = append(args, r.Container.Image)
+	if len(r.Container.Command) > 1 {
+		args = append(args, r.Container.Command[1:]...)
+	}
+	args = append(args, r.Container.Args...)
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	if shellSafePattern.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
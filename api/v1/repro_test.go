@@ -0,0 +1,48 @@
+package v1
+
+import (
+	"bytes"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSubTaskResultReproCommand(t *testing.T) {
+	logger := NewLogger(&bytes.Buffer{}, LogLevelInfo)
+	logger.AddMask("secret")
+	container := corev1.Container{
+		Name:       "test",
+		Image:      "golang:1.22",
+		Command:    []string{"sh", "-c"},
+		Args:       []string{"go test -run $TEST ./..."},
+		WorkingDir: "/work",
+		Env: []corev1.EnvVar{
+			{Name: "TEST", Value: "TestA"},
+			{Name: "TOKEN", Value: "secret"},
+			{
+				Name: "PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+						Key:                  "password",
+					},
+				},
+			},
+		},
+		EnvFrom: []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "common"}}},
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: "repo", MountPath: "/work"}},
+	}
+	result := &SubTaskResult{
+		Container: container,
+		Env: envSnapshot(container, func(msg string) string {
+			return maskText(logger, msg)
+		}),
+		WorkingDir: container.WorkingDir,
+	}
+	expected := `docker run --rm -w /work -e TEST=TestA -e 'TOKEN=******' -e 'PASSWORD=<secret:db/password>' --env-file '<configmap:common>' -v '<repo>:/work' --entrypoint sh golang:1.22 -c 'go test -run $TEST ./...'`
+	if cmd := result.ReproCommand(); cmd != expected {
+		t.Fatalf("unexpected repro command:\nexpected: %s\nactual:   %s", expected, cmd)
+	}
+}
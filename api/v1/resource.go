@@ -22,6 +22,9 @@ type ResourceManager struct {
 	doneSetup   bool
 	logPath     string
 	reportPath  string
+	workDir     string
+	// reportIndent indent of the report written by WriteReport. If empty, the report is written in a single line.
+	reportIndent string
 }
 
 func NewResourceManager(clientset *kubernetes.Clientset, testjob TestJob) *ResourceManager {
@@ -36,6 +39,20 @@ func NewResourceManager(clientset *kubernetes.Clientset, testjob TestJob) *Resou
 	}
 }
 
+// SetWorkDir set the directory to create the local files of the run ( e.g. repositories, artifacts, logs ).
+// If empty string is specified, the default directory for temporary files is used.
+func (m *ResourceManager) SetWorkDir(dir string) {
+	m.workDir = dir
+	m.repoMgr.SetWorkDir(dir)
+	m.tokenMgr.SetWorkDir(dir)
+	m.artifactMgr.SetWorkDir(dir)
+}
+
+// WorkDir returns the directory to create the local files of the run.
+func (m *ResourceManager) WorkDir() string {
+	return m.workDir
+}
+
 func (m *ResourceManager) Cleanup() error {
 	return m.repoMgr.Cleanup()
 }
@@ -70,7 +87,7 @@ func (m *ResourceManager) LogPath() (string, error) {
 	if m.logPath != "" {
 		return m.logPath, nil
 	}
-	dir, err := os.MkdirTemp("", "log")
+	dir, err := os.MkdirTemp(m.workDir, "log")
 	if err != nil {
 		return "", fmt.Errorf("kubetest: failed to create temporary directory for log: %w", err)
 	}
@@ -78,16 +95,31 @@ func (m *ResourceManager) LogPath() (string, error) {
 	return m.logPath, nil
 }
 
+// CompressedLogPath returns the path to the log compressed by gzip. The log must be written by WriteLog in advance.
+func (m *ResourceManager) CompressedLogPath() (string, error) {
+	logPath, err := m.LogPath()
+	if err != nil {
+		return "", err
+	}
+	return gzipFile(logPath)
+}
+
 const (
 	reportJSONFile = "report.json"
 )
 
+// SetReportIndent set the indent ( e.g. two spaces ) to pretty-print the report written by WriteReport for human readers.
+// If empty string is specified, the report is written in a single line.
+func (m *ResourceManager) SetReportIndent(indent string) {
+	m.reportIndent = indent
+}
+
 func (m *ResourceManager) WriteReport(result *Result) error {
 	reportPath, err := m.ReportPath(ReportFormatTypeJSON)
 	if err != nil {
 		return err
 	}
-	b, err := json.Marshal(result)
+	b, err := marshalReport(result, m.reportIndent)
 	if err != nil {
 		return fmt.Errorf("kubetest: failed to encode result to json: %w", err)
 	}
@@ -97,9 +129,17 @@ func (m *ResourceManager) WriteReport(result *Result) error {
 	return nil
 }
 
+// marshalReport encodes v to JSON. If indent isn't empty, v is pretty-printed with indent.
+func marshalReport(v interface{}, indent string) ([]byte, error) {
+	if indent == "" {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", indent)
+}
+
 func (m *ResourceManager) ReportPath(format ReportFormatType) (string, error) {
 	if m.reportPath == "" {
-		dir, err := os.MkdirTemp("", "report")
+		dir, err := os.MkdirTemp(m.workDir, "report")
 		if err != nil {
 			return "", fmt.Errorf("kubetest: failed to create temporary directory for report: %w", err)
 		}
@@ -113,6 +153,15 @@ func (m *ResourceManager) ReportPath(format ReportFormatType) (string, error) {
 	}
 }
 
+// CompressedReportPath returns the path to the report compressed by gzip. The report must be written by WriteReport in advance.
+func (m *ResourceManager) CompressedReportPath(format ReportFormatType) (string, error) {
+	reportPath, err := m.ReportPath(format)
+	if err != nil {
+		return "", err
+	}
+	return gzipFile(reportPath)
+}
+
 func (m *ResourceManager) RepositoryPathByName(name string) (string, error) {
 	if !m.doneSetup {
 		return "", fmt.Errorf("kubetest: resource manager isn't setup")
@@ -120,6 +169,35 @@ func (m *ResourceManager) RepositoryPathByName(name string) (string, error) {
 	return m.repoMgr.ArchivePathByRepoName(name)
 }
 
+// Revisions returns the commit checked out for each repository.
+func (m *ResourceManager) Revisions() []ReportRepository {
+	return m.repoMgr.Revisions()
+}
+
+// ChangedPaths returns the paths changed from diffBase of the repositories.
+// The second value is false if no repository has diffBase.
+func (m *ResourceManager) ChangedPaths() ([]string, bool) {
+	return m.repoMgr.ChangedPaths()
+}
+
+// RepositoryArchiveCompression returns the compression of the archive returned by RepositoryPathByName.
+func (m *ResourceManager) RepositoryArchiveCompression(name string) ArchiveCompression {
+	return m.repoMgr.ArchiveCompressionByRepoName(name)
+}
+
+// RepositoryGzipArchivePathByName returns the path to the repository archive compressed by gzip.
+func (m *ResourceManager) RepositoryGzipArchivePathByName(ctx context.Context, name string) (string, error) {
+	if !m.doneSetup {
+		return "", fmt.Errorf("kubetest: resource manager isn't setup")
+	}
+	return m.repoMgr.GzipArchivePathByRepoName(ctx, name)
+}
+
+// credentials returns the tokens resolved by the resource manager ( e.g. to clone the repositories ).
+func (m *ResourceManager) credentials() []credential {
+	return m.tokenMgr.credentials()
+}
+
 func (m *ResourceManager) TokenPathByName(ctx context.Context, name string) (string, error) {
 	if !m.doneSetup {
 		return "", fmt.Errorf("kubetest: resource manager isn't setup")
@@ -145,6 +223,29 @@ func (m *ResourceManager) ArtifactPathByNameAndContainerName(name, containerName
 	return m.artifactMgr.LocalPathByNameAndContainerName(name, containerName)
 }
 
+// PrepareArtifactCopy removes the link to the deduplicated artifact of the container before it is copied again.
+func (m *ResourceManager) PrepareArtifactCopy(name, containerName string) error {
+	return m.artifactMgr.PrepareArtifactCopy(name, containerName)
+}
+
+// DeduplicateArtifact replaces the artifact copied from the container with the link to the identical artifact of the other container.
+func (m *ResourceManager) DeduplicateArtifact(ctx context.Context, name, containerName string) error {
+	return m.artifactMgr.DeduplicateArtifact(ctx, name, containerName)
+}
+
+// CoveragePathByName merges the coverage profiles copied from all shards and returns the path to the merged profile.
+func (m *ResourceManager) CoveragePathByName(name string) (string, error) {
+	if !m.doneSetup {
+		return "", fmt.Errorf("kubetest: resource manager isn't setup")
+	}
+	return m.artifactMgr.CoveragePathByName(name)
+}
+
+// AddOutputArtifact registers the artifact written by kubetest itself and returns the directory to write the files.
+func (m *ResourceManager) AddOutputArtifact(name string) (string, error) {
+	return m.artifactMgr.AddOutputArtifact(name)
+}
+
 func (m *ResourceManager) ExportArtifacts(ctx context.Context) error {
 	return m.artifactMgr.ExportArtifacts(ctx)
 }
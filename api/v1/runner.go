@@ -5,13 +5,18 @@ package v1
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 type RunMode int
@@ -35,16 +40,59 @@ func (m RunMode) String() string {
 }
 
 type Runner struct {
-	cfg       *rest.Config
-	clientset *kubernetes.Clientset
-	runMode   RunMode
-	logger    Logger
+	cfg                       *rest.Config
+	clientset                 *kubernetes.Clientset
+	runMode                   RunMode
+	logger                    Logger
+	artifactExportConcurrency int
+	maxObjects                int
+	skipPreSteps              bool
+	existingArtifactPaths     map[string]string
+	retryPredicate            RetryPredicate
+	drainOnSignal             bool
+	ownerReference            *metav1.OwnerReference
+	objectHandler             ObjectHandler
+	createdObjects            *ObjectRecorder
+	failuresLogPath           string
+	failuresLogCreated        bool
+	workDir                   string
+	manifestDir               string
+	partialReportDir          string
+	circuitBreakerThreshold   int
+	circuitBreakerWindow      time.Duration
+	copyRetry                 CopyRetryPolicy
+	initLogLimit              int
+	skipImageVerification     bool
+	listCommand               []string
+	strictMasking             bool
+	shardSummary              bool
+	clientTimeout             time.Duration
+	clientQPS                 float32
+	clientBurst               int
+	testListProcessor         TestListProcessor
+	keepRetention             time.Duration
+	resultIndent              string
+	logLineLimit              LogLineLimit
+	adjustClockSkew           bool
+	nameGenerator             NameGenerator
+	idempotencyKey            string
+	idempotencyPolicy         IdempotencyPolicy
+	baselinePath              string
+	preInitReadyTimeout       time.Duration
 }
 
+// RetryPredicate decides whether to retry the failed test by the test name, the output and the exit code of the test.
+type RetryPredicate func(testName string, output string, exitCode int) bool
+
 func NewRunner(cfg *rest.Config, runMode RunMode) *Runner {
 	return &Runner{
-		cfg:     cfg,
-		runMode: runMode,
+		cfg:                 cfg,
+		runMode:             runMode,
+		createdObjects:      NewObjectRecorder(),
+		copyRetry:           defaultCopyRetryPolicy,
+		initLogLimit:        defaultInitLogLimit,
+		preInitReadyTimeout: defaultPreInitReadyTimeout,
+		keepRetention:       defaultKeepRetention,
 	}
 }
 
@@ -52,10 +100,372 @@ func (r *Runner) SetLogger(logger Logger) {
 	r.logger = logger
 }
 
-func (r *Runner) Run(ctx context.Context, testjob TestJob) (*Report, error) {
+// SetArtifactExportConcurrency set the maximum number of artifacts exported concurrently after running all steps.
+func (r *Runner) SetArtifactExportConcurrency(concurrency int) {
+	r.artifactExportConcurrency = concurrency
+}
+
+// SetMaxObjects set the maximum number of kubernetes objects created by a run.
+// This overrides the value of spec.maxObjects.
+func (r *Runner) SetMaxObjects(maxObjects int) {
+	r.maxObjects = maxObjects
+}
+
+// SetSkipPreSteps skip running preSteps to reuse the artifacts created by the previous run.
+// The artifacts created by preSteps and used by the other steps must be specified by SetExistingArtifactPath.
+func (r *Runner) SetSkipPreSteps(skip bool) {
+	r.skipPreSteps = skip
+}
+
+// SetExistingArtifactPath set the path to the artifact exported by the previous run.
+// This is used instead of the artifact created by preSteps when preSteps are skipped.
+func (r *Runner) SetExistingArtifactPath(name, path string) {
+	if r.existingArtifactPaths == nil {
+		r.existingArtifactPaths = map[string]string{}
+	}
+	r.existingArtifactPaths[name] = path
+}
+
+// SetRetryPredicate set the predicate to retry failed tests.
+// After running all tests, only the failed tests matching the predicate are run again once,
+// and their results are replaced by the results of retry.
+// If strategy.retest is enabled without predicate, all failed tests are run again.
+func (r *Runner) SetRetryPredicate(predicate RetryPredicate) {
+	r.retryPredicate = predicate
+}
+
+// SetTestListProcessor set the processor to transform the list of tests got from strategy.key.source before scheduling them.
+// This filters, reorders, dedupes or renames the tests in one place. If the processor returns error, the run is aborted.
+func (r *Runner) SetTestListProcessor(processor TestListProcessor) {
+	r.testListProcessor = processor
+}
+
+// SetDrainOnSignal enables graceful drain on SIGTERM or SIGINT.
+// When receiving the signal, the context of the run is canceled to stop all executors,
+// and the Jobs created by the run are deleted on best-effort basis before returning.
+// This is disabled by default to leave the signal handling to the application embedding Runner.
+func (r *Runner) SetDrainOnSignal(enabled bool) {
+	r.drainOnSignal = enabled
+}
+
+// SetOwnerReference set the owner reference attached to every Job created by the run.
+// When the owner ( e.g. the custom resource of the controller running kubetest ) is deleted,
+// the Jobs are deleted by garbage collection.
+// The owner must be in the same namespace as TestJob.
+func (r *Runner) SetOwnerReference(ref metav1.OwnerReference) {
+	r.ownerReference = &ref
+}
+
+// SetFailuresLogPath set the path to write the output of all failed tests at the end of the run.
+// The outputs are masked and grouped by the test name, so the failures of the distributed run can be triaged from a single file.
+// The failures of the testjobs run by RunTestJobs are written to the file in order, each after the line with the name of the testjob.
+func (r *Runner) SetFailuresLogPath(path string) {
+	r.failuresLogPath = path
+}
+
+// SetWorkDir set the directory to create the local files of the run ( e.g. cloned repositories, artifacts ).
+// If not specified, KUBETEST_WORKDIR environment variable or the default directory for temporary files is used.
+func (r *Runner) SetWorkDir(dir string) {
+	r.workDir = dir
+}
+
+// SetListCommand set the command to get dynamic keys used instead of the command of the main container in the spec.
+// This overrides the command only for the run, so the spec isn't changed.
+func (r *Runner) SetListCommand(command []string) {
+	r.listCommand = command
+}
+
+// SetManifestDir set the directory to write the manifests of all Jobs submitted by the run.
+// The file names have the run ID, the phase and the shard index. In kubernetes run mode, the Jobs are written as they are created
+// with the containers added by kubejob. In the other run modes, the Jobs the run would submit are written.
+func (r *Runner) SetManifestDir(dir string) {
+	r.manifestDir = dir
+}
+
+// SetPartialReportDir set the directory to write the report of each task as soon as the task finishes.
+// The reports are written to the directory named by the run ID, so the results of the finished tasks remain
+// even if the process dies before the end of the run. They can be assembled by RecoverReport.
+// When the run finishes, they are merged into report.json in the same directory.
+func (r *Runner) SetPartialReportDir(dir string) {
+	r.partialReportDir = dir
+}
+
+// SetCircuitBreaker stops the run when the infrastructure failures happen threshold times within window.
+// The infrastructure failures are the retried failures of the tasks ( e.g. the pod stays pending ),
+// the retried copies interrupted by the transient error and the tests failed by internal errors.
+// When the breaker trips, the running tasks are canceled, the new tasks are not started,
+// and the run fails with InfraFailureError listing the distinct reasons. If threshold is zero, the breaker is disabled.
+func (r *Runner) SetCircuitBreaker(threshold int, window time.Duration) {
+	r.circuitBreakerThreshold = threshold
+	r.circuitBreakerWindow = window
+}
+
+// SetCopyRetry set the maximum number of retries and the initial backoff to copy files between local and the container
+// when the stream is interrupted by the transient error ( e.g. connection reset ). The backoff is doubled for each retry.
+// By default, copying is retried up to 3 times.
+func (r *Runner) SetCopyRetry(maxRetries int, backoff time.Duration) {
+	r.copyRetry = CopyRetryPolicy{MaxRetries: maxRetries, Backoff: backoff}
+}
+
+// SetPreInitReadyTimeout set the time to wait until the preinit container is running and accepts the exec API
+// before the repositories, the tokens and the artifacts are copied to it ( default: 1m ).
+// This avoids the transient failures of the copies on the slow nodes. If 0 is specified, the files are copied without waiting.
+func (r *Runner) SetPreInitReadyTimeout(timeout time.Duration) {
+	r.preInitReadyTimeout = timeout
+}
+
+// SetInitLogLimit set the maximum bytes of the output of the failed init container written to the log.
+// Only the tail of the output is kept, so verbose init containers don't exhaust memory. By default, 64KiB is kept.
+// If 0 is specified, the output isn't written.
+func (r *Runner) SetInitLogLimit(limit int) {
+	r.initLogLimit = limit
+}
+
+// SetMaxLogLinesPerKey set the maximum number of the last lines of the output of each test written to the log
+// for the passed test and the failed test ( e.g. 200 and 2000 ). The number of hidden lines is written before the lines.
+// Only the log is trimmed, so the report, the artifacts and the captured log ( kubetest.log ) have the whole output.
+// If 0 is specified, all lines are written.
+func (r *Runner) SetMaxLogLinesPerKey(success, failure int) {
+	r.logLineLimit = LogLineLimit{Success: success, Failure: failure}
+}
+
+// SetClientTimeout set the timeout of each request of the kubernetes client ( e.g. 60s for large runs ).
+// This bounds the requests to the hung API server. The timeout isn't applied to the client running the Jobs,
+// because it also cuts watching the pods, reading the logs, copying files and running commands in the containers.
+// By default, the timeout of the config passed to NewRunner is used.
+func (r *Runner) SetClientTimeout(timeout time.Duration) {
+	r.clientTimeout = timeout
+}
+
+// SetClientRateLimit set the client-side rate limit of the kubernetes client by queries per second and burst.
+// The default of client-go ( 5 qps and 10 burst ) throttles the runs creating many Jobs and Pods,
+// so the large runs should raise them ( e.g. 50 qps and 100 burst ) within the limit allowed by the API server.
+// If 0 is specified, the value of the config passed to NewRunner is used.
+func (r *Runner) SetClientRateLimit(qps float32, burst int) {
+	r.clientQPS = qps
+	r.clientBurst = burst
+}
+
+// SetResultIndent set the indent ( e.g. two spaces ) to pretty-print the reports written to the files ( report.json ) for human readers.
+// The reports of the tasks written while running are kept in a single line. If empty string is specified, all reports are written in a single line.
+func (r *Runner) SetResultIndent(indent string) {
+	r.resultIndent = indent
+}
+
+// SetClockSkewAdjustment offsets the timestamps of the report ( e.g. startedAt ) by the skew of the clock of the API server from the local clock,
+// so they can be compared with the timestamps of the cluster ( e.g. events of the pods ). The skew is detected at the start of the run in kubernetes mode
+// and always logged. The skew within 2 seconds is ignored. The elapsed times are measured by the local monotonic clock regardless of this.
+func (r *Runner) SetClockSkewAdjustment(enabled bool) {
+	r.adjustClockSkew = enabled
+}
+
+// SetKeepRetention set the time the objects kept by keepResources are skipped by OwnerCleanup and the signal drainer.
+// The kept objects are deleted by OwnerCleanup after the retention elapses ( default: 24h ).
+func (r *Runner) SetKeepRetention(retention time.Duration) {
+	r.keepRetention = retention
+}
+
+// SetNameGenerator set the function to name the Jobs of the run ( e.g. to embed the build id of CI for traceability ).
+// generator is called with generateName of the template. If not specified, kubernetes appends the random suffix to generateName.
+// The generated name must be a DNS-1123 subdomain within 63 characters. Otherwise the task fails to build.
+func (r *Runner) SetNameGenerator(generator NameGenerator) {
+	r.nameGenerator = generator
+}
+
+// SetIdempotencyKey set the key to avoid the duplicate runs of the same TestJob ( e.g. launched twice by the retry of the webhook ).
+// The key is attached to the Jobs as label, and the Jobs having the same key are searched in the namespaces of the TestJob and the shards before any Job is created.
+// If the run having the key is still running, the run is refused or attached to it by policy ( default: refuse ).
+// The run publishes its status to the ConfigMap labeled with the key in the namespace of the TestJob, and the attached run reports the status of it.
+// The key must be a valid label value. Note that the runs started at the same time may not see each other.
+func (r *Runner) SetIdempotencyKey(key string, policy IdempotencyPolicy) {
+	r.idempotencyKey = key
+	r.idempotencyPolicy = policy
+}
+
+// SetCompareBaseline set the path to the report of the baseline run ( e.g. the last run of the default branch ) to compare the tests with.
+// The run fails only if some tests newly failed compared to the baseline, so the known failures of the baseline are tolerated.
+// The comparison including the tests newly passed is reported as baseline of the report.
+func (r *Runner) SetCompareBaseline(reportPath string) {
+	r.baselinePath = reportPath
+}
+
+// readBaseline reads the baseline report set by SetCompareBaseline. If it isn't set, returns nil.
+func (r *Runner) readBaseline() (*Report, error) {
+	if r.baselinePath == "" {
+		return nil, nil
+	}
+	f, err := os.Open(r.baselinePath)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to open baseline report %s: %w", r.baselinePath, err)
+	}
+	defer f.Close()
+	return ReadReport(f)
+}
+
+// clockSkewOffset detects the skew of the clock of the API server and returns the offset applied to the timestamps of the report.
+// If the adjustment is disabled or the skew can't be detected, returns zero.
+func (r *Runner) clockSkewOffset(ctx context.Context, cfg *rest.Config) time.Duration {
+	skew, err := detectClockSkew(ctx, cfg)
+	if err != nil {
+		r.logger.Warn("failed to detect clock skew: %s", err.Error())
+		return 0
+	}
+	offset := clockSkewOffset(skew)
+	switch {
+	case offset == 0:
+		r.logger.Debug("clock skew of the API server: %s", skew)
+	case r.adjustClockSkew:
+		r.logger.Warn("the clock of the API server is %s ahead of kubetest. the timestamps of the report are adjusted", skew)
+	default:
+		r.logger.Warn("the clock of the API server is %s ahead of kubetest. the timestamps of the report are not adjusted", skew)
+		return 0
+	}
+	return offset
+}
+
+// debugHolder returns the holder of the pods of the failed tests if debug is enabled.
+// Holding the pod needs the user watching the run, so debug is ignored in CI ( CI environment variable is set ) and the run modes without pods.
+func (r *Runner) debugHolder(testjob TestJob) (*debugHolder, error) {
+	debug := testjob.Spec.Debug
+	if debug == nil || !debug.Enabled {
+		return nil, nil
+	}
+	if os.Getenv("CI") != "" {
+		r.logger.Warn("debug is ignored because CI environment variable is set")
+		return nil, nil
+	}
+	if r.runMode != RunModeKubernetes {
+		r.logger.Warn("debug is ignored because it works only in kubernetes run mode")
+		return nil, nil
+	}
+	return newDebugHolder(*debug)
+}
+
+// restConfig returns the config of the kubernetes client applied the rate limit of the runner.
+// The rate limiter is set to the config, so all clients created from it share the rate limit
+// instead of having their own one ( e.g. the clients created by kubejob for each Job ).
+func (r *Runner) restConfig() *rest.Config {
+	if r.clientQPS == 0 && r.clientBurst == 0 {
+		return r.cfg
+	}
+	cfg := rest.CopyConfig(r.cfg)
+	if r.clientQPS != 0 {
+		cfg.QPS = r.clientQPS
+	}
+	if r.clientBurst != 0 {
+		cfg.Burst = r.clientBurst
+	}
+	qps, burst := cfg.QPS, cfg.Burst
+	if qps == 0 {
+		qps = rest.DefaultQPS
+	}
+	if burst == 0 {
+		burst = rest.DefaultBurst
+	}
+	cfg.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	return cfg
+}
+
+// requestConfig returns the config of the client sending the unary requests, which has the timeout set by SetClientTimeout.
+// The timeout of http.Client also cuts the watches and the streams, so cfg is used as is to run the Jobs.
+func (r *Runner) requestConfig(cfg *rest.Config) *rest.Config {
+	if r.clientTimeout == 0 {
+		return cfg
+	}
+	cfg = rest.CopyConfig(cfg)
+	cfg.Timeout = r.clientTimeout
+	return cfg
+}
+
+// SetSkipImageVerification skips verifying images even if verifyImages is enabled by TestJob.
+// This is used for the registry that doesn't allow checking manifests ( e.g. air-gapped registry ).
+func (r *Runner) SetSkipImageVerification(skip bool) {
+	r.skipImageVerification = skip
+}
+
+// SetStrictMasking fails the run instead of the warning if a credential resolved at the start of the run isn't masked in the log
+// or the logger can't report its masks.
+func (r *Runner) SetStrictMasking(strict bool) {
+	r.strictMasking = strict
+}
+
+// SetShardSummary writes the summary line of each shard of mainStep ( e.g. the number of passed tests and the pod name ) when it finishes.
+func (r *Runner) SetShardSummary(enabled bool) {
+	r.shardSummary = enabled
+}
+
+// SetObjectHandler set the handler called whenever kubetest creates a kubernetes object ( e.g. Job and Pod ).
+// This allows the application embedding Runner to track the created objects incrementally,
+// so it can clean them up even if the process crashes before the run finishes.
+func (r *Runner) SetObjectHandler(handler ObjectHandler) {
+	r.objectHandler = handler
+}
+
+// CreatedObjects returns all kubernetes objects created by the runs of this Runner and not cleaned up by OwnerCleanup yet.
+func (r *Runner) CreatedObjects() []ReportObject {
+	return r.createdObjects.Objects()
+}
+
+// OwnerCleanup deletes all kubernetes objects created by the runs of this Runner.
+// The objects already deleted are ignored.
+// The objects kept by keepResources are skipped until the retention set by SetKeepRetention elapses.
+func (r *Runner) OwnerCleanup(ctx context.Context) error {
+	clientset, err := kubernetes.NewForConfig(r.requestConfig(r.restConfig()))
+	if err != nil {
+		return err
+	}
+	if r.logger != nil {
+		ctx = WithLogger(ctx, r.logger)
+	}
+	dynamicClient, err := newDynamicObjectClient(r.requestConfig(r.restConfig()), clientset)
+	if err != nil {
+		return err
+	}
+	deletable, remaining := r.createdObjects.deletableObjects(time.Now(), r.keepRetention)
+	if err := deleteObjects(ctx, clientset, dynamicClient, deletable); err != nil {
+		return err
+	}
+	r.createdObjects = remaining
+	return nil
+}
+
+// Run runs testjob and returns the report.
+// If ctx is canceled while running, returns the error wrapping context.Canceled or context.DeadlineExceeded,
+// so the caller can distinguish the abort from the other errors by errors.Is.
+// In that case, the partial report of the finished tests is also returned if the main step has already started.
+func (r *Runner) Run(ctx context.Context, testjob TestJob) (runReport *Report, e error) {
+	if r.maxObjects > 0 {
+		// validated by the overridden limit, so the planned objects are checked before anything runs.
+		testjob.Spec.MaxObjects = r.maxObjects
+	}
 	if err := testjob.Validate(); err != nil {
 		return nil, err
 	}
+	var breaker *circuitBreaker
+	defer func() {
+		if infraErr := breaker.tripped(); infraErr != nil && e != nil {
+			// the run was canceled by the circuit breaker.
+			e = infraErr
+			return
+		}
+		// ctx is replaced by the context canceled by the signal if drainOnSignal is enabled.
+		e = interruptedError(ctx, e)
+	}()
+	if r.ownerReference != nil {
+		if err := NewValidator().ValidateOwnerReference(*r.ownerReference); err != nil {
+			return nil, err
+		}
+	}
+	if r.idempotencyKey != "" {
+		if err := NewValidator().ValidateIdempotencyKey(r.idempotencyKey, r.idempotencyPolicy); err != nil {
+			return nil, err
+		}
+	}
+	// the baseline is read before the run, so the broken baseline doesn't waste the run.
+	baseline, err := r.readBaseline()
+	if err != nil {
+		return nil, err
+	}
 	if r.logger == nil {
 		level := LogLevelInfo
 		if testjob.Spec.Log.Level != LogLevelNone {
@@ -63,58 +473,368 @@ func (r *Runner) Run(ctx context.Context, testjob TestJob) (*Report, error) {
 		}
 		r.logger = NewLogger(os.Stdout, level)
 	}
-	r.logger.Info("start kubetest")
+	// write the batched log before returning, so the log of the run is complete when the report is printed.
+	defer flushLog(r.logger)
+	runID := rand.String(8)
+	setLogRunID(r.logger, runID)
+	defer setLogRunID(r.logger, "")
+	r.logger.Info("start kubetest ( run id: %s )", runID)
 	r.logger.Debug("run validation")
 	startedAt := time.Now()
 	ctx = WithLogger(ctx, r.logger)
-	clientset, err := kubernetes.NewForConfig(r.cfg)
+	objectRecorder := NewObjectRecorder()
+	createdObjects := r.createdObjects
+	objectRecorder.SetHandler(func(obj ReportObject) {
+		createdObjects.Record(obj)
+		if r.objectHandler != nil {
+			r.objectHandler(obj)
+		}
+	})
+	ctx = WithObjectRecorder(ctx, objectRecorder)
+	firstFailure := newFirstFailureRecorder(startedAt)
+	ctx = withFirstFailureRecorder(ctx, firstFailure)
+	if r.circuitBreakerThreshold > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		breaker = newCircuitBreaker(r.circuitBreakerThreshold, r.circuitBreakerWindow, cancel)
+		ctx = withCircuitBreaker(ctx, breaker)
+	}
+	scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+	scheduler.SetListCommand(r.listCommand)
+	scheduler.SetTestListProcessor(r.testListProcessor)
+	apiRequests := newAPIRequestCounter()
+	defer apiRequests.logSummary(r.logger)
+	restCfg := apiRequests.wrapConfig(r.restConfig())
+	clientset, err := kubernetes.NewForConfig(r.requestConfig(restCfg))
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := newDynamicObjectClient(r.requestConfig(restCfg), clientset)
+	if err != nil {
+		return nil, err
+	}
+	if r.idempotencyKey != "" && r.runMode == RunModeKubernetes {
+		existing, err := findRunningRun(ctx, clientset, runNamespaces(testjob), r.idempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		if existing != "" {
+			if r.idempotencyPolicy != IdempotencyPolicyAttach {
+				return nil, &DuplicateRunError{Key: r.idempotencyKey, RunID: existing}
+			}
+			report, err := r.attachRun(ctx, clientset, testjob.Namespace, runNamespaces(testjob), existing, startedAt)
+			if err != nil {
+				return nil, err
+			}
+			report.RunID = runID
+			return report, nil
+		}
+		// the status is published, so the run attached to this run by the idempotency key can get the result of it.
+		if err := publishRunStatus(ctx, clientset, testjob.Namespace, r.idempotencyKey, runID, r.ownerReference); err != nil {
+			r.logger.Warn("%s. the run attached to this run can't get the result of it", err.Error())
+		} else {
+			defer func() {
+				status := ResultStatus(ResultStatusError)
+				if e == nil && runReport != nil {
+					status = runReport.Status
+				}
+				// the status is published even if the run was interrupted.
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), runStatusTimeout)
+				defer cancel()
+				if err := updateRunStatus(ctx, clientset, testjob.Namespace, runID, status); err != nil {
+					r.logger.Warn("%s", err.Error())
+				}
+			}()
+		}
+	}
+	var clockSkew time.Duration
+	if r.runMode == RunModeKubernetes {
+		clockSkew = r.clockSkewOffset(ctx, r.requestConfig(restCfg))
+	}
+	if testjob.Spec.VerifyImages && r.runMode == RunModeKubernetes {
+		if r.skipImageVerification {
+			r.logger.Info("skip verifying images")
+		} else if err := verifyTestJobImages(ctx, clientset, testjob); err != nil {
+			return nil, err
+		}
+	}
+	if r.drainOnSignal {
+		drainer := newSignalDrainer(clientset, objectRecorder)
+		drainer.keepRetention = r.keepRetention
+		drainer.dynamicClient = dynamicClient
+		ctx = drainer.start(ctx)
+		defer drainer.stop(ctx)
+	}
+	if r.ownerReference != nil && r.runMode == RunModeKubernetes {
+		// registered before keepObjects, so the Jobs kept by keepResources are skipped.
+		defer r.deleteShardJobs(ctx, clientset, testjob.Namespace, objectRecorder)
+	}
+	if policy := testjob.Spec.KeepResources; policy != "" && policy != KeepResourcesNever {
+		// registered after the drainer, so the kept objects are skipped by the drainer.
+		defer func() {
+			r.keepObjects(ctx, clientset, policy, runFailed(runReport, e), objectRecorder, runReport)
+		}()
+	}
+	workDir, err := resolveWorkDir(r.workDir)
 	if err != nil {
 		return nil, err
 	}
+	r.logger.Info("working directory: %s", workDir)
+	requiredSpace, err := expectedWorkDirSpace(testjob)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFreeSpace(workDir, requiredSpace); err != nil {
+		return nil, err
+	}
 	resourceMgr := NewResourceManager(clientset, testjob)
+	resourceMgr.SetWorkDir(workDir)
+	resourceMgr.SetReportIndent(r.resultIndent)
+	resourceMgr.artifactMgr.SetRunMode(r.runMode)
+	resourceMgr.artifactMgr.SetExportConcurrency(r.artifactExportConcurrency)
 	r.logger.Debug("setup resource manager")
 	if err := resourceMgr.Setup(ctx); err != nil {
 		return nil, err
 	}
 	defer resourceMgr.Cleanup()
-	builder := NewTaskBuilder(r.cfg, resourceMgr, testjob.Namespace, r.runMode)
-	var result Result
-	for _, step := range testjob.Spec.PreSteps {
+	var taskOutput *taskOutputWriter
+	if name := testjob.Spec.OutputArtifact; name != "" {
+		dir, err := resourceMgr.AddOutputArtifact(name)
+		if err != nil {
+			return nil, err
+		}
+		taskOutput = newTaskOutputWriter(name, dir)
+		ctx = withTaskOutputWriter(ctx, taskOutput)
+	}
+	builder := NewTaskBuilder(restCfg, resourceMgr, testjob.Namespace, r.runMode)
+	if r.runMode == RunModeKubernetes {
+		// the clientset running the Jobs is created from the config without timeout because it watches the pods and streams the output.
+		jobClientset, err := kubernetes.NewForConfig(restCfg)
+		if err != nil {
+			return nil, err
+		}
+		builder.SetClientset(jobClientset)
+	}
+	builder.SetOwnerReference(r.ownerReference)
+	builder.SetRunID(runID)
+	builder.SetEnvFrom(testjob.Spec.EnvFrom)
+	builder.SetCopyRetryPolicy(r.copyRetry)
+	builder.SetInitLogLimit(r.initLogLimit)
+	builder.SetPreInitReadyTimeout(r.preInitReadyTimeout)
+	builder.SetShardSummary(r.shardSummary)
+	builder.SetLogLineLimit(r.logLineLimit)
+	builder.SetImagePrefix(testjob.Spec.ImagePrefix)
+	builder.SetScratch(testjob.Spec.Scratch)
+	builder.SetManifestDir(r.manifestDir)
+	builder.SetKeepResources(testjob.Spec.KeepResources)
+	builder.SetNameGenerator(r.nameGenerator)
+	builder.SetIdempotencyKey(r.idempotencyKey)
+	if hermetic := testjob.Spec.Hermetic; hermetic != nil && hermetic.Enabled {
+		// created before the teardown is registered, so the finalizer of the teardown also runs in hermetic mode.
+		cleanup, err := r.setupHermetic(ctx, clientset, testjob, runID, builder.manifestWriter)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+	}
+	if len(testjob.Spec.Fixtures) != 0 {
+		release, err := r.setupFixtures(ctx, dynamicClient.client, dynamicClient.mapper, testjob, runID, builder.manifestWriter)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			release(runFailed(runReport, e))
+		}()
+	}
+	// If the run fails before any task of mainStep runs the finalizer ( e.g. prestep, listing or scheduling the tests ),
+	// the finalizer is run by teardown. The tasks failed to run may have run the finalizer, so it is tracked by the tasks.
+	var (
+		mainStepSkipped  bool
+		mainFinalizerRan atomic.Bool
+	)
+	builder.setOnMainStepFinalizer(func() {
+		mainFinalizerRan.Store(true)
+	})
+	defer func() {
+		if e == nil || mainStepSkipped || mainFinalizerRan.Load() {
+			return
+		}
+		if finalizers := r.runTeardown(ctx, testjob, builder, e); runReport != nil {
+			runReport.Finalizers = append(runReport.Finalizers, finalizers...)
+		}
+	}()
+	debug, err := r.debugHolder(testjob)
+	if err != nil {
+		return nil, err
+	}
+	if debug != nil {
+		debug.start(ctx)
+		defer debug.stop()
+		builder.setDebugHolder(debug)
+	}
+	if r.runMode != RunModeDryRun {
+		secretCredentials, err := addSecretMasks(ctx, clientset, testjob.Namespace, secretKeyRefs(testjob))
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSecretMasks(ctx, append(resourceMgr.credentials(), secretCredentials...), r.strictMasking); err != nil {
+			return nil, err
+		}
+	}
+	if r.runMode == RunModeKubernetes {
+		if err := checkShardNamespaces(ctx, clientset, testjob); err != nil {
+			return nil, err
+		}
+	}
+	result := Result{runID: runID, apiRequests: apiRequests, repositories: resourceMgr.Revisions(), clockSkew: clockSkew}
+	var partialReport *partialReportWriter
+	if r.partialReportDir != "" {
+		partialReport, err = newPartialReportWriter(r.partialReportDir, runID, startedAt)
+		if err != nil {
+			return nil, err
+		}
+		partialReport.indent = r.resultIndent
+		partialReport.clockSkew = clockSkew
+		r.logger.Info("write partial reports to %s", partialReport.dir)
+		ctx = withPartialReportWriter(ctx, partialReport)
+	}
+	if r.skipPreSteps {
+		r.logger.Info("skip presteps")
+		if err := r.addExistingArtifacts(testjob, resourceMgr.artifactMgr); err != nil {
+			return nil, err
+		}
+	}
+	changedPaths, detected := resourceMgr.ChangedPaths()
+	changes := &changeFilter{paths: changedPaths, enabled: detected}
+	if err := r.addSkippedPreStepArtifacts(testjob, changes, resourceMgr.artifactMgr); err != nil {
+		return nil, err
+	}
+	for _, step := range r.preSteps(testjob) {
 		step := step
+		if changes.skip(ctx, step.Name, step.RunIfChanged) {
+			result.preSteps = append(result.preSteps, ReportPreStep{Name: step.Name, Status: ResultStatusSkipped})
+			result.skippedSteps = append(result.skippedSteps, step.Name)
+			continue
+		}
 		r.logger.Info("run prestep: %s", step.Name)
 		task, err := builder.Build(ctx, &step)
 		if err != nil {
 			return nil, err
 		}
 		preStepResult, err := task.Run(ctx)
+		if err == nil {
+			for _, mainResult := range preStepResult.MainTaskResults() {
+				if err = mainResult.Error(); err != nil {
+					break
+				}
+			}
+		}
+		result.preSteps = append(result.preSteps, newReportPreStep(step.Name, preStepResult, err, func(msg string) string {
+			return maskText(r.logger, msg)
+		}))
 		if err != nil {
-			return nil, fmt.Errorf("kubetest: failed to run prestep %s: %w", step.Name, err)
+			// keep the output of the failed prestep in the report because the streamed log may be truncated.
+			result.setPreStepFailure(startedAt)
+			return result.toReport(), fmt.Errorf("kubetest: failed to run prestep %s: %w", step.Name, err)
 		}
-		for _, result := range preStepResult.MainTaskResults() {
-			if err := result.Error(); err != nil {
-				return nil, fmt.Errorf("kubetest: failed to run prestep %s: %w", step.Name, err)
+		result.preStepResults = append(result.preStepResults, preStepResult)
+	}
+	var (
+		taskResult *TaskResultGroup
+		taskNum    int
+	)
+	mainStepSkipped = changes.skip(ctx, MainStepType, testjob.Spec.MainStep.RunIfChanged)
+	if mainStepSkipped {
+		taskResult = &TaskResultGroup{}
+		result.skippedSteps = append(result.skippedSteps, MainStepType)
+	} else {
+		taskResult, taskNum, err = r.runSmokeTests(ctx, testjob, scheduler, builder)
+		if err == nil && ctx.Err() == nil {
+			if taskResult.Status() == ResultStatusSuccess {
+				taskResult, taskNum, err = r.runMainTests(ctx, testjob, scheduler, builder, taskResult, taskNum)
+			} else {
+				result.smokeFailed = true
 			}
 		}
-		result.preStepResults = append(result.preStepResults, preStepResult)
 	}
-	scheduler := NewTaskScheduler(testjob.Spec.MainStep)
-	taskGroup, err := scheduler.Schedule(ctx, builder)
-	if err != nil {
-		return nil, err
+	if seed, shuffled := scheduler.ShuffleSeed(); shuffled {
+		result.shuffleSeed = &seed
+	}
+	if ctx.Err() != nil && taskResult != nil {
+		// TaskGroup.Run returns the results of the finished tasks even if it was canceled.
+		result.interrupted = true
+		result.setByTaskResult(startedAt, taskResult)
+		result.tasks = taskResult.ReportTasks(func(msg string) string {
+			return maskText(r.logger, msg)
+		})
+		result.setFirstFailure(firstFailure)
+		result.objects = objectRecorder.Objects()
+		result.artifacts = taskOutput.Artifacts()
+		if infraErr := breaker.tripped(); infraErr != nil {
+			result.status = ResultStatusError
+			result.circuitBreaker = &ReportCircuitBreaker{
+				FailureNum: infraErr.FailureNum,
+				WindowSec:  infraErr.Window.Seconds(),
+				Reasons:    infraErr.Reasons,
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("kubetest: run is interrupted: %w", ctx.Err())
+		}
+		return result.toReport(), err
 	}
-	taskResult, err := taskGroup.Run(ctx)
 	if err != nil {
-		return nil, err
+		var groupErr *TaskGroupError
+		if taskResult == nil || !errors.As(err, &groupErr) {
+			return nil, err
+		}
+		// report the results of the tasks which ran with the tasks which failed to run.
+		result.setByTaskResult(startedAt, taskResult)
+		result.status = ResultStatusError
+		result.tasks = taskResult.ReportTasks(func(msg string) string {
+			return maskText(r.logger, msg)
+		})
+		result.setFirstFailure(firstFailure)
+		result.objects = objectRecorder.Objects()
+		result.artifacts = taskOutput.Artifacts()
+		return result.toReport(), err
 	}
 	result.setByTaskResult(startedAt, taskResult)
+	if mainStepSkipped {
+		// distinguish from the run whose tests all passed.
+		result.status = ResultStatusSkipped
+	}
+	result.setFirstFailure(firstFailure)
+	if strategy := testjob.Spec.MainStep.Strategy; strategy != nil {
+		result.applyInternalErrorThreshold(strategy.InternalErrorThreshold, r.logger)
+	}
+	if baseline != nil && !mainStepSkipped {
+		result.applyBaseline(baseline, r.baselinePath, r.logger)
+	}
+	if coverage := testjob.Spec.MainStep.Coverage; coverage != nil && !mainStepSkipped && r.runMode != RunModeDryRun {
+		if err := r.mergeCoverage(resourceMgr, coverage.Name); err != nil {
+			return nil, err
+		}
+	}
+	if r.failuresLogPath != "" {
+		if err := r.writeFailuresLog(testjob, taskResult); err != nil {
+			return nil, err
+		}
+	}
 	if err := resourceMgr.WriteLog(r.logger); err != nil {
 		return nil, err
 	}
+	result.artifacts = taskOutput.Artifacts()
 	if err := resourceMgr.WriteReport(&result); err != nil {
 		return nil, err
 	}
 	for _, step := range testjob.Spec.PostSteps {
 		step := step
+		if changes.skip(ctx, step.Name, step.RunIfChanged) {
+			result.skippedSteps = append(result.skippedSteps, step.Name)
+			continue
+		}
 		r.logger.Info("run poststep: %s", step.Name)
 		task, err := builder.Build(ctx, &step)
 		if err != nil {
@@ -134,7 +854,432 @@ func (r *Runner) Run(ctx context.Context, testjob TestJob) (*Report, error) {
 	if err := resourceMgr.ExportArtifacts(ctx); err != nil {
 		return nil, err
 	}
-	return result.toReport(), nil
+	result.objects = objectRecorder.Objects()
+	result.artifacts = taskOutput.Artifacts()
+	report := result.toReport()
+	if partialReport != nil {
+		if err := partialReport.merge(report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// runFailed returns whether the run failed to decide whether the objects of the run are kept by keepResources.
+func runFailed(report *Report, err error) bool {
+	return err != nil || report == nil ||
+		(report.Status != ResultStatusSuccess && report.Status != ResultStatusSkipped)
+}
+
+// keepObjects keeps the objects created by the run for postmortem if policy requires it, and prints their names.
+// Otherwise, restores ttlSecondsAfterFinished of the Jobs removed while running, so they are deleted as usual.
+func (r *Runner) keepObjects(ctx context.Context, clientset kubernetes.Interface, policy KeepResourcesPolicy, failed bool, recorder *ObjectRecorder, report *Report) {
+	objects := recorder.Objects()
+	if !keepResources(policy, failed) {
+		if err := restoreJobTTL(ctx, clientset, objects); err != nil {
+			r.logger.Warn("%s", err.Error())
+		}
+		return
+	}
+	// only the Jobs and their pods are kept. The other objects ( e.g. NetworkPolicy of hermetic mode ) are deleted as usual.
+	objects = keptObjects(objects)
+	now := time.Now()
+	recorder.keep(objects, now)
+	r.createdObjects.keep(objects, now)
+	if report != nil {
+		for idx := range report.Objects {
+			// the fixtures are kept by the release of the fixtures before this.
+			report.Objects[idx].Kept = isKeptKind(report.Objects[idx].Kind) || recorder.isKept(report.Objects[idx])
+		}
+	}
+	if len(objects) == 0 {
+		return
+	}
+	r.logger.Info("keep %d objects for postmortem ( keepResources: %s )", len(objects), policy)
+	for _, obj := range objects {
+		r.logger.Info("keep %s %s/%s", obj.Kind, obj.Namespace, obj.Name)
+	}
+}
+
+// shardJobCleanupTimeout timeout for deleting the Jobs of the shards in the other namespaces after the run.
+var shardJobCleanupTimeout = 30 * time.Second
+
+// deleteShardJobs deletes the Jobs of the shards run in the namespaces other than namespace.
+// The owner in the other namespace is invalid, so they have no owner and deleting the owner doesn't delete them.
+// The Jobs kept by keepResources are skipped until the retention elapses, and OwnerCleanup deletes them after that.
+func (r *Runner) deleteShardJobs(ctx context.Context, clientset kubernetes.Interface, namespace string, recorder *ObjectRecorder) {
+	deletable, _ := recorder.deletableObjects(time.Now(), r.keepRetention)
+	var jobs []ReportObject
+	for _, obj := range deletable {
+		if obj.Kind == "Job" && obj.Namespace != namespace {
+			jobs = append(jobs, obj)
+		}
+	}
+	if len(jobs) == 0 {
+		return
+	}
+	// the Jobs are deleted even if the run was interrupted.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shardJobCleanupTimeout)
+	defer cancel()
+	if err := deleteObjects(ctx, clientset, nil, jobs); err != nil {
+		r.logger.Warn("%s", err.Error())
+	}
+}
+
+// runSmokeTests runs the smoke tests specified by strategy.smoke and returns their results with the number of tasks including the retries.
+// The failed smoke tests are retried here, so that the other tests run only if all smoke tests finally succeed.
+// If smoke tests are not specified, returns the empty results.
+func (r *Runner) runSmokeTests(ctx context.Context, testjob TestJob, scheduler *TaskScheduler, builder *TaskBuilder) (*TaskResultGroup, int, error) {
+	smokeGroup, err := scheduler.ScheduleSmoke(ctx, builder)
+	if err != nil {
+		return nil, 0, err
+	}
+	if smokeGroup == nil {
+		return &TaskResultGroup{}, 0, nil
+	}
+	if err := r.validateObjectNum(testjob, smokeGroup.TaskNum()); err != nil {
+		return nil, 0, err
+	}
+	r.logger.Info("run smoke tests")
+	smokeResult, err := smokeGroup.Run(ctx)
+	if err != nil {
+		return smokeResult, smokeGroup.TaskNum(), err
+	}
+	retryTaskNum, err := r.retryFailedTests(ctx, testjob, scheduler, builder, smokeGroup.TaskNum(), smokeResult)
+	if err != nil {
+		return nil, 0, err
+	}
+	if smokeResult.Status() != ResultStatusSuccess {
+		r.logger.Warn("%d smoke tests failed. skip running the other tests", smokeResult.FailureNum())
+	}
+	return smokeResult, smokeGroup.TaskNum() + retryTaskNum, nil
+}
+
+// runMainTests runs the tests scheduled by the main step and returns the results merged with smokeResult.
+func (r *Runner) runMainTests(ctx context.Context, testjob TestJob, scheduler *TaskScheduler, builder *TaskBuilder, smokeResult *TaskResultGroup, smokeTaskNum int) (*TaskResultGroup, int, error) {
+	// returns smokeResult with the error to tell that the smoke tests already ran.
+	taskGroup, err := scheduler.Schedule(ctx, builder)
+	if err != nil {
+		return smokeResult, smokeTaskNum, err
+	}
+	taskNum := smokeTaskNum + taskGroup.TaskNum()
+	if err := r.validateObjectNum(testjob, taskNum); err != nil {
+		return smokeResult, smokeTaskNum, err
+	}
+	taskResult, err := taskGroup.Run(ctx)
+	if err == nil && ctx.Err() == nil {
+		// the failed smoke tests were already retried by runSmokeTests, so only the tests of this run are retried.
+		retryTaskNum, err := r.retryFailedTests(ctx, testjob, scheduler, builder, taskNum, taskResult)
+		if err != nil {
+			return nil, taskNum, err
+		}
+		taskNum += retryTaskNum
+	}
+	if taskResult != nil {
+		taskResult.merge(smokeResult)
+	}
+	return taskResult, taskNum, err
+}
+
+// interruptedError wraps err by the error of ctx if ctx was canceled.
+func interruptedError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	ctxErr := ctx.Err()
+	if ctxErr == nil || errors.Is(err, ctxErr) {
+		return err
+	}
+	return fmt.Errorf("kubetest: run is interrupted: %w: %w", ctxErr, err)
+}
+
+// RunTestJobs runs testjobs sequentially and returns the report of each testjob.
+// Even if a testjob couldn't run, the remaining testjobs run, and the errors of all testjobs are joined.
+// The report of the testjob which couldn't run is included if it exists ( e.g. the partial report of the interrupted testjob ).
+// If the run is interrupted, the remaining testjobs don't run.
+func (r *Runner) RunTestJobs(ctx context.Context, testjobs []TestJob) ([]*Report, error) {
+	reports := make([]*Report, 0, len(testjobs))
+	var errs []error
+	for idx, testjob := range testjobs {
+		if ctx.Err() != nil {
+			break
+		}
+		report, err := r.Run(ctx, testjob)
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("kubetest: failed to run testjob %d ( %s ): %w", idx, testjob.Name, err))
+		}
+	}
+	return reports, errors.Join(errs...)
+}
+
+// CombinedStatus returns the status combined the status of all reports.
+// error takes precedence over failure, and failure takes precedence over success. skipped is combined as success.
+func CombinedStatus(reports []*Report) ResultStatus {
+	status := ResultStatus(ResultStatusSuccess)
+	for _, report := range reports {
+		switch report.Status {
+		case ResultStatusError:
+			return ResultStatusError
+		case ResultStatusFailure:
+			status = ResultStatusFailure
+		}
+	}
+	return status
+}
+
+// retryFailedTests runs the failed tests of taskResult again and replaces their results.
+// taskNum is the number of tasks of mainStep already run, so the retries are checked against maxObjects with them.
+// Returns the number of tasks run for the retries.
+func (r *Runner) retryFailedTests(ctx context.Context, testjob TestJob, scheduler *TaskScheduler, builder *TaskBuilder, taskNum int, taskResult *TaskResultGroup) (int, error) {
+	enabledRetest := testjob.Spec.MainStep.Strategy != nil && testjob.Spec.MainStep.Strategy.Retest
+	if !enabledRetest && r.retryPredicate == nil {
+		return 0, nil
+	}
+	names := []string{}
+	for _, result := range taskResult.FailedMainResults() {
+		if result.Phase == ContainerPhaseFinalizer {
+			// the failure of the finalizer isn't a test, so it can't be retried.
+			continue
+		}
+		if r.retryPredicate == nil || r.retryPredicate(result.Name, string(result.Out), result.ExitCode()) {
+			names = append(names, result.Name)
+		}
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	r.logger.Info("retry %d failed tests", len(names))
+	retryGroup, err := scheduler.ScheduleRetry(ctx, builder, names)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.validateObjectNum(testjob, taskNum+retryGroup.TaskNum()); err != nil {
+		return 0, err
+	}
+	retryResult, err := retryGroup.Run(ctx)
+	if err != nil {
+		return retryGroup.TaskNum(), err
+	}
+	taskResult.replaceResults(retryResult)
+	return retryGroup.TaskNum(), nil
+}
+
+func (r *Runner) preSteps(testjob TestJob) []PreStep {
+	if r.skipPreSteps {
+		return nil
+	}
+	return testjob.Spec.PreSteps
+}
+
+// teardownTimeout timeout for running the finalizer of mainStep by the teardown.
+var teardownTimeout = 5 * time.Minute
+
+// runTeardown runs the finalizer container of mainStep without the tests when the run failed before the tests ran,
+// so the finalizer cleans up the resources created by preSteps or the preparation of the run.
+// The failure of the teardown is only logged to return the original error, and the results of the finalizer are returned.
+// The teardown runs even if the run was interrupted, but it gives up after teardownTimeout
+// so that the run isn't blocked forever ( e.g. the quota is exhausted or the API server is unreachable ).
+func (r *Runner) runTeardown(ctx context.Context, testjob TestJob, builder *TaskBuilder, cause error) []ReportFinalizer {
+	if testjob.Spec.MainStep.Template.Spec.FinalizerContainer.Name == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), teardownTimeout)
+	defer cancel()
+	r.logger.Warn("run finalizer of mainStep because the run failed before running the tests: %s", cause)
+	task, err := builder.BuildTeardown(ctx, &testjob.Spec.MainStep, cause)
+	if err != nil {
+		r.logger.Error("failed to build teardown: %s", err)
+		return nil
+	}
+	type teardownResult struct {
+		result *TaskResult
+		err    error
+	}
+	// kubejob doesn't stop the job by the context, so the teardown stops waiting for it instead.
+	done := make(chan teardownResult, 1)
+	go func() {
+		result, err := task.Run(ctx)
+		done <- teardownResult{result: result, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		r.logger.Error("failed to run teardown: gave up after %s: %s", teardownTimeout, ctx.Err())
+		return nil
+	case res := <-done:
+		if res.err != nil {
+			r.logger.Error("failed to run teardown: %s", res.err)
+			return nil
+		}
+		group := &TaskResultGroup{results: []*TaskResult{res.result}}
+		return group.ReportFinalizers()
+	}
+}
+
+// mergeCoverage merges the coverage profiles copied from all tests before they are used by postSteps or exported.
+// The run isn't failed if no profile is copied because the tests may fail before writing it.
+func (r *Runner) mergeCoverage(resourceMgr *ResourceManager, name string) error {
+	path, err := resourceMgr.CoveragePathByName(name)
+	if err != nil {
+		if errors.Is(err, errArtifactNotFound) {
+			r.logger.Warn("coverage profile %s is not copied from any test", name)
+			return nil
+		}
+		return err
+	}
+	r.logger.Info("merged coverage profile %s: %s", name, path)
+	return nil
+}
+
+// addExistingArtifacts validates that all artifacts created by preSteps and used by the other steps exist,
+// and registers them to artifact manager.
+func (r *Runner) addExistingArtifacts(testjob TestJob, artifactMgr *ArtifactManager) error {
+	preStepArtifacts := map[string]ArtifactSpec{}
+	for _, step := range testjob.Spec.PreSteps {
+		for _, artifact := range step.Template.Spec.Artifacts {
+			preStepArtifacts[artifact.Name] = artifact
+		}
+	}
+	for name := range r.existingArtifactPaths {
+		if _, exists := preStepArtifacts[name]; !exists {
+			return fmt.Errorf("kubetest: existing artifact %s is not defined by preSteps", name)
+		}
+	}
+	for _, name := range r.referencedArtifactNames(testjob) {
+		artifact, exists := preStepArtifacts[name]
+		if !exists {
+			continue
+		}
+		path, exists := r.existingArtifactPaths[name]
+		if !exists {
+			return fmt.Errorf("kubetest: artifact %s is created by preSteps. the path to the existing artifact must be specified to skip preSteps", name)
+		}
+		if err := artifactMgr.AddExistingArtifact(artifact, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSkippedPreStepArtifacts adds the existing artifacts instead of the artifacts of the presteps skipped by runIfChanged.
+// If the artifact of the skipped prestep is used by the steps which run, the path to the existing artifact must be specified,
+// because the step can't mount the artifact which isn't created.
+func (r *Runner) addSkippedPreStepArtifacts(testjob TestJob, changes *changeFilter, artifactMgr *ArtifactManager) error {
+	skippedArtifacts := map[string]ArtifactSpec{}
+	skippedStepNames := map[string]string{}
+	templates := []TestJobTemplateSpec{}
+	for _, step := range r.preSteps(testjob) {
+		if !changes.unchanged(step.RunIfChanged) {
+			templates = append(templates, step.Template)
+			continue
+		}
+		for _, artifact := range step.Template.Spec.Artifacts {
+			skippedArtifacts[artifact.Name] = artifact
+			skippedStepNames[artifact.Name] = step.Name
+		}
+	}
+	if len(skippedArtifacts) == 0 {
+		return nil
+	}
+	if !changes.unchanged(testjob.Spec.MainStep.RunIfChanged) {
+		templates = append(templates, mainStepTemplates(testjob)...)
+	}
+	for _, step := range testjob.Spec.PostSteps {
+		if !changes.unchanged(step.RunIfChanged) {
+			templates = append(templates, step.Template)
+		}
+	}
+	for _, name := range artifactNames(templates) {
+		artifact, exists := skippedArtifacts[name]
+		if !exists {
+			continue
+		}
+		path, exists := r.existingArtifactPaths[name]
+		if !exists {
+			return fmt.Errorf(
+				"kubetest: artifact %s is created by prestep %s skipped by runIfChanged. the path to the existing artifact must be specified to use it",
+				name, skippedStepNames[name],
+			)
+		}
+		if err := artifactMgr.AddExistingArtifact(artifact, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referencedArtifactNames returns the artifact names used as volume by mainStep and postSteps.
+func (r *Runner) referencedArtifactNames(testjob TestJob) []string {
+	templates := mainStepTemplates(testjob)
+	for _, step := range testjob.Spec.PostSteps {
+		templates = append(templates, step.Template)
+	}
+	return artifactNames(templates)
+}
+
+// mainStepTemplates returns the templates of mainStep including the templates to get the dynamic keys.
+func mainStepTemplates(testjob TestJob) []TestJobTemplateSpec {
+	templates := []TestJobTemplateSpec{testjob.Spec.MainStep.Template}
+	if strategy := testjob.Spec.MainStep.Strategy; strategy != nil {
+		for _, source := range dynamicKeySources(strategy.Key.Source) {
+			templates = append(templates, source.Template)
+		}
+	}
+	return templates
+}
+
+// artifactNames returns the artifact names used as volume by templates.
+func artifactNames(templates []TestJobTemplateSpec) []string {
+	names := []string{}
+	nameMap := map[string]struct{}{}
+	for _, template := range templates {
+		for _, volume := range template.Spec.Volumes {
+			if volume.Artifact == nil {
+				continue
+			}
+			if _, exists := nameMap[volume.Artifact.Name]; exists {
+				continue
+			}
+			nameMap[volume.Artifact.Name] = struct{}{}
+			names = append(names, volume.Artifact.Name)
+		}
+	}
+	return names
+}
+
+func (r *Runner) getMaxObjects(testjob TestJob) int {
+	if r.maxObjects > 0 {
+		return r.maxObjects
+	}
+	return testjob.Spec.MaxObjects
+}
+
+// plannedObjectNum returns the number of kubernetes objects created by a run running preStepNum presteps and mainTaskNum tasks of mainStep.
+func plannedObjectNum(spec TestJobSpec, preStepNum, mainTaskNum int) int {
+	taskNum := preStepNum + mainTaskNum + len(spec.PostSteps)
+	if strategy := spec.MainStep.Strategy; strategy != nil {
+		// tasks to get dynamic keys.
+		taskNum += len(dynamicKeySources(strategy.Key.Source))
+	}
+	return taskNum * objectNumPerTask
+}
+
+func (r *Runner) validateObjectNum(testjob TestJob, mainTaskNum int) error {
+	maxObjects := r.getMaxObjects(testjob)
+	if maxObjects <= 0 {
+		return nil
+	}
+	objectNum := plannedObjectNum(testjob.Spec, len(r.preSteps(testjob)), mainTaskNum)
+	if objectNum > maxObjects {
+		return fmt.Errorf(
+			"kubetest: the run requires %d kubernetes objects ( Job and Pod ) but maxObjects is %d",
+			objectNum, maxObjects,
+		)
+	}
+	r.logger.Debug("the run requires %d kubernetes objects ( maxObjects: %d )", objectNum, maxObjects)
+	return nil
 }
 
 type Result struct {
@@ -145,10 +1290,51 @@ type Result struct {
 	successNum      int
 	failureNum      int
 	unknownNum      int
+	internalErrNum  int
+	runID           string
+	interrupted     bool
 	preStepResults  []*TaskResult
 	postStepResults []*TaskResult
 	taskResult      *TaskResultGroup
 	job             TestJob
+	objects         []ReportObject
+	shuffleSeed     *int64
+	circuitBreaker  *ReportCircuitBreaker
+	firstFailureAt  *metav1.Time
+	artifacts       []ReportArtifact
+	preSteps        []ReportPreStep
+	skippedSteps    []string
+	apiRequests     *apiRequestCounter
+	repositories    []ReportRepository
+	// clockSkew offset applied to the timestamps of the report for the skew of the clock of the API server.
+	clockSkew time.Duration
+	baseline  *ReportBaseline
+	tasks     []ReportTask
+	// smokeFailed whether the smoke tests failed, so the other tests of mainStep didn't run.
+	smokeFailed bool
+}
+
+// setPreStepFailure set the result of the run stopped by the failed prestep. No tests of mainStep have run.
+func (r *Result) setPreStepFailure(startedAt time.Time) {
+	r.startedAt = startedAt
+	r.elapsedTime = time.Since(startedAt)
+	r.status = ResultStatusError
+	r.taskResult = &TaskResultGroup{}
+}
+
+// newReportPreStep creates the result of the prestep. The output is recorded only if the prestep failed.
+func newReportPreStep(name string, result *TaskResult, err error, mask func(string) string) ReportPreStep {
+	if err == nil {
+		return ReportPreStep{Name: name, Status: ResultStatusSuccess}
+	}
+	var output string
+	if result != nil {
+		output = string(formatTaskOutput(result, mask))
+	}
+	if output == "" {
+		output = mask(err.Error())
+	}
+	return ReportPreStep{Name: name, Status: ResultStatusError, Output: output}
 }
 
 func (r *Result) setByTaskResult(startedAt time.Time, taskResult *TaskResultGroup) {
@@ -161,20 +1347,100 @@ func (r *Result) setByTaskResult(startedAt time.Time, taskResult *TaskResultGrou
 		r.status = ResultStatusError
 		r.unknownNum = r.totalNum - (r.successNum + r.failureNum)
 	}
+	r.internalErrNum = taskResult.InternalErrorShardNum()
 	r.taskResult = taskResult
 	r.elapsedTime = time.Since(startedAt)
 }
 
+func (r *Result) setFirstFailure(recorder *firstFailureRecorder) {
+	if at, failed := recorder.firstFailureAt(); failed {
+		r.firstFailureAt = &metav1.Time{Time: at}
+	}
+}
+
+// applyInternalErrorThreshold marks the failed run as error if more than threshold percent of the shards hit internal errors.
+// This distinguishes the problems of the infrastructure from the genuine failures of the tests.
+func (r *Result) applyInternalErrorThreshold(threshold *int, logger Logger) {
+	if threshold == nil || r.status != ResultStatusFailure || r.taskResult == nil {
+		return
+	}
+	shardNum := r.taskResult.shardNum()
+	if shardNum == 0 || r.internalErrNum*100 <= *threshold*shardNum {
+		return
+	}
+	logger.Warn(
+		"%d of %d shards failed by internal errors ( threshold: %d%% ). mark the run as error",
+		r.internalErrNum, shardNum, *threshold,
+	)
+	r.status = ResultStatusError
+}
+
+// applyBaseline compares the tests with baseline, and marks the failed run as success if all failed tests also failed in baseline.
+// The run having the errors ( e.g. the unknown results ) isn't changed because they can't be compared.
+// The run whose smoke tests failed isn't changed either, because the other tests didn't run.
+func (r *Result) applyBaseline(baseline *Report, path string, logger Logger) {
+	if r.taskResult == nil {
+		return
+	}
+	r.baseline = CompareBaseline(baseline, &Report{Details: r.taskResult.ToReportDetails()}, path)
+	if len(r.baseline.Improvements) != 0 {
+		logger.Info(
+			"%d tests which failed in the baseline passed: %s",
+			len(r.baseline.Improvements), strings.Join(r.baseline.Improvements, ", "),
+		)
+	}
+	if r.status != ResultStatusFailure || len(r.baseline.NewFailures) != 0 {
+		return
+	}
+	if r.smokeFailed {
+		logger.Warn(
+			"all %d failed smoke tests also failed in the baseline, but the run fails because the other tests didn't run: %s",
+			len(r.baseline.KnownFailures), strings.Join(r.baseline.KnownFailures, ", "),
+		)
+		return
+	}
+	logger.Info(
+		"all %d failed tests also failed in the baseline. mark the run as success: %s",
+		len(r.baseline.KnownFailures), strings.Join(r.baseline.KnownFailures, ", "),
+	)
+	r.status = ResultStatusSuccess
+}
+
 func (r *Result) toReport() *Report {
 	return &Report{
-		Status:         r.status,
-		TotalNum:       r.totalNum,
-		SuccessNum:     r.successNum,
-		FailureNum:     r.failureNum,
-		UnknownNum:     r.unknownNum,
-		StartedAt:      metav1.Time{r.startedAt},
-		ElapsedTimeSec: int64(r.elapsedTime.Seconds()),
-		Details:        r.taskResult.ToReportDetails(),
-		ExtParam:       r.job.Spec.Log.ExtParam,
+		RunID:            r.runID,
+		Status:           r.status,
+		Interrupted:      r.interrupted,
+		TotalNum:         r.totalNum,
+		SuccessNum:       r.successNum,
+		FailureNum:       r.failureNum,
+		UnknownNum:       r.unknownNum,
+		InternalErrorNum: r.internalErrNum,
+		StartedAt:        metav1.Time{Time: r.startedAt.Add(r.clockSkew)},
+		ElapsedTimeSec:   int64(r.elapsedTime.Seconds()),
+		Details:          r.taskResult.ToReportDetails(),
+		ExtParam:         r.job.Spec.Log.ExtParam,
+		Objects:          r.objects,
+		ShardBalance:     r.taskResult.ShardBalance(),
+		ShuffleSeed:      r.shuffleSeed,
+		FirstFailureAt:   r.reportFirstFailureAt(),
+		CircuitBreaker:   r.circuitBreaker,
+		Artifacts:        r.artifacts,
+		PreSteps:         r.preSteps,
+		SkippedSteps:     r.skippedSteps,
+		APIRequests:      r.apiRequests.report(),
+		Repositories:     r.repositories,
+		AuxiliaryDetails: r.taskResult.ToAuxiliaryReportDetails(),
+		Finalizers:       r.taskResult.ReportFinalizers(),
+		ClockSkewMillis:  r.clockSkew.Milliseconds(),
+		Baseline:         r.baseline,
+		Tasks:            r.tasks,
+	}
+}
+
+func (r *Result) reportFirstFailureAt() *metav1.Time {
+	if r.firstFailureAt == nil || r.clockSkew == 0 {
+		return r.firstFailureAt
 	}
+	return &metav1.Time{Time: r.firstFailureAt.Add(r.clockSkew)}
 }
@@ -3,11 +3,15 @@ package v1
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -211,7 +215,7 @@ func TestRunner(t *testing.T) {
 		runner := NewRunner(getConfig(), RunModeKubernetes)
 		runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
 		ttl := int32(1)
-		if _, err := runner.Run(ctx, TestJob{
+		report, err := runner.Run(ctx, TestJob{
 			ObjectMeta: testjobObjectMeta(),
 			Spec: TestJobSpec{
 				Repos: testRepos(),
@@ -247,8 +251,12 @@ func TestRunner(t *testing.T) {
 					},
 				},
 			},
-		}); err != nil {
-			t.Fatal(err)
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded error but got %v", err)
+		}
+		if report == nil || !report.Interrupted {
+			t.Fatalf("expected partial report of interrupted run but got %+v", report)
 		}
 		// Make sure ttl is working properly.
 		time.Sleep(5 * time.Second)
@@ -1342,5 +1350,707 @@ func TestRunner(t *testing.T) {
 			})
 		}
 	})
+	t.Run("max objects", func(t *testing.T) {
+		testjob := TestJob{
+			ObjectMeta: testjobObjectMeta(),
+			Spec: TestJobSpec{
+				MainStep: MainStep{
+					Strategy: &Strategy{
+						Key: StrategyKeySpec{
+							Env: "TEST",
+							Source: StrategyKeySource{
+								Static: []string{"A", "B", "C", "D", "E"},
+							},
+						},
+						Scheduler: Scheduler{
+							MaxContainersPerPod:    2,
+							MaxConcurrentNumPerPod: 2,
+						},
+					},
+					Template: TestJobTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							GenerateName: "test-",
+						},
+						Spec: TestJobPodSpec{
+							Containers: []TestJobContainer{
+								{
+									Container: corev1.Container{
+										Name:    "test",
+										Image:   "alpine",
+										Command: []string{"echo"},
+										Args:    []string{"$TEST"},
+									},
+								},
+							},
+						},
+					},
+				},
+				// 3 tasks require 6 objects.
+				MaxObjects: 5,
+			},
+		}
+		t.Run("exceeded", func(t *testing.T) {
+			runner := NewRunner(getConfig(), RunModeDryRun)
+			runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+			if _, err := runner.Run(context.Background(), testjob); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+		t.Run("override by runner", func(t *testing.T) {
+			runner := NewRunner(getConfig(), RunModeDryRun)
+			runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+			runner.SetMaxObjects(6)
+			if _, err := runner.Run(context.Background(), testjob); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+	t.Run("retry predicate", func(t *testing.T) {
+		markerDir := t.TempDir()
+		runner := NewRunner(getConfig(), RunModeLocal)
+		runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+		var (
+			exitCodeMu sync.Mutex
+			exitCodes  = map[string]int{}
+		)
+		runner.SetRetryPredicate(func(testName string, output string, exitCode int) bool {
+			exitCodeMu.Lock()
+			exitCodes[testName] = exitCode
+			exitCodeMu.Unlock()
+			return strings.Contains(output, "connection refused")
+		})
+		script := fmt.Sprintf(`
+if [ "$TEST" = "B" ] && [ ! -f %[1]s/retried ]; then
+  touch %[1]s/retried
+  echo "connection refused"
+  exit 1
+fi
+if [ "$TEST" = "C" ]; then
+  echo "assertion failed"
+  exit 2
+fi
+echo $TEST`, markerDir)
+		report, err := runner.Run(context.Background(), TestJob{
+			ObjectMeta: testjobObjectMeta(),
+			Spec: TestJobSpec{
+				MainStep: MainStep{
+					Strategy: &Strategy{
+						Key: StrategyKeySpec{
+							Env: "TEST",
+							Source: StrategyKeySource{
+								Static: []string{"A", "B", "C"},
+							},
+						},
+						Scheduler: Scheduler{
+							MaxContainersPerPod:    3,
+							MaxConcurrentNumPerPod: 3,
+						},
+					},
+					Template: TestJobTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							GenerateName: "test-",
+						},
+						Spec: TestJobPodSpec{
+							Containers: []TestJobContainer{
+								{
+									Container: corev1.Container{
+										Name:    "test",
+										Image:   "alpine",
+										Command: []string{"sh", "-c"},
+										Args:    []string{script},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.TotalNum != 3 || report.SuccessNum != 2 || report.FailureNum != 1 {
+			t.Fatalf("unexpected report: total %d success %d failure %d", report.TotalNum, report.SuccessNum, report.FailureNum)
+		}
+		for _, detail := range report.Details {
+			expected := ResultStatus(ResultStatusSuccess)
+			if detail.Name == "C" {
+				expected = ResultStatusFailure
+			}
+			if detail.Status != expected {
+				t.Fatalf("unexpected status of %s: expected %s but got %s", detail.Name, expected, detail.Status)
+			}
+		}
+		if exitCodes["B"] != 1 || exitCodes["C"] != 2 {
+			t.Fatalf("unexpected exit codes: %v", exitCodes)
+		}
+	})
+}
+
+type testExitError int
+
+func (e testExitError) Error() string   { return fmt.Sprintf("exit status %d", int(e)) }
+func (e testExitError) ExitStatus() int { return int(e) }
+
+func TestResultInternalErrorThreshold(t *testing.T) {
+	newTaskResult := func(errs ...error) *TaskResult {
+		var group SubTaskResultGroup
+		for _, err := range errs {
+			status := TaskResultSuccess
+			if err != nil {
+				status = TaskResultFailure
+			}
+			group.add(&SubTaskResult{Status: status, Err: err, IsMain: true})
+		}
+		return &TaskResult{groups: []*SubTaskResultGroup{&group}}
+	}
+	newResult := func() *Result {
+		var g TaskResultGroup
+		g.add(newTaskResult(nil, testExitError(1)))
+		g.add(newTaskResult(errors.New("connection lost")))
+		g.add(newTaskResult(nil))
+		g.add(newTaskResult(nil, nil))
+		g.totalSubTaskNum = 6
+		var result Result
+		result.setByTaskResult(time.Now(), &g)
+		return &result
+	}
+	threshold := func(v int) *int { return &v }
+	logger := NewLogger(io.Discard, LogLevelInfo)
+	t.Run("not specified", func(t *testing.T) {
+		result := newResult()
+		result.applyInternalErrorThreshold(nil, logger)
+		if result.status != ResultStatusFailure {
+			t.Fatalf("unexpected status: %s", result.status)
+		}
+		if result.internalErrNum != 1 {
+			t.Fatalf("unexpected internal error num: %d", result.internalErrNum)
+		}
+	})
+	t.Run("exceeded", func(t *testing.T) {
+		result := newResult()
+		result.applyInternalErrorThreshold(threshold(20), logger)
+		if result.status != ResultStatusError {
+			t.Fatalf("unexpected status: %s", result.status)
+		}
+	})
+	t.Run("not exceeded", func(t *testing.T) {
+		result := newResult()
+		result.applyInternalErrorThreshold(threshold(25), logger)
+		if result.status != ResultStatusFailure {
+			t.Fatalf("unexpected status: %s", result.status)
+		}
+	})
+	t.Run("task failed to run", func(t *testing.T) {
+		result := newResult()
+		result.taskResult.failures = []*TaskFailure{
+			{Task: "shard-4", Err: errors.New("pod couldn't start")},
+			{Task: "shard-5", Err: context.Canceled},
+		}
+		result.setByTaskResult(time.Now(), result.taskResult)
+		if result.internalErrNum != 2 {
+			t.Fatalf("unexpected internal error num: %d", result.internalErrNum)
+		}
+		result.applyInternalErrorThreshold(threshold(25), logger)
+		if result.status != ResultStatusError {
+			t.Fatalf("unexpected status: %s", result.status)
+		}
+	})
+	t.Run("artifact error", func(t *testing.T) {
+		r := &SubTaskResult{Status: TaskResultFailure, ArtifactErr: errors.New("failed to copy")}
+		if !r.IsInternalError() {
+			t.Fatal("failure of copying artifact must be internal error")
+		}
+		r = &SubTaskResult{Status: TaskResultFailure, Err: errors.New("canceled"), FailureKind: FailureKindInterrupted}
+		if r.IsInternalError() {
+			t.Fatal("interrupted test must not be internal error")
+		}
+	})
+}
+
+func TestResultBaseline(t *testing.T) {
+	newResult := func() *Result {
+		var group SubTaskResultGroup
+		group.add(&SubTaskResult{Name: "TestA", Status: TaskResultSuccess, IsMain: true})
+		group.add(&SubTaskResult{Name: "TestB", Status: TaskResultFailure, Err: testExitError(1), IsMain: true})
+		var g TaskResultGroup
+		g.add(&TaskResult{groups: []*SubTaskResultGroup{&group}})
+		g.totalSubTaskNum = 2
+		var result Result
+		result.setByTaskResult(time.Now(), &g)
+		return &result
+	}
+	logger := NewLogger(io.Discard, LogLevelInfo)
+	t.Run("known failure", func(t *testing.T) {
+		result := newResult()
+		baseline := &Report{Details: []*ReportDetail{
+			{Name: "TestA", Status: ResultStatusFailure},
+			{Name: "TestB", Status: ResultStatusFailure},
+		}}
+		result.applyBaseline(baseline, "baseline.json", logger)
+		if result.status != ResultStatusSuccess {
+			t.Fatalf("unexpected status: %s", result.status)
+		}
+		report := result.toReport()
+		if report.Baseline == nil || report.Baseline.Path != "baseline.json" {
+			t.Fatalf("the comparison must be reported: %+v", report.Baseline)
+		}
+		if !reflect.DeepEqual(report.Baseline.Improvements, []string{"TestA"}) || !reflect.DeepEqual(report.Baseline.KnownFailures, []string{"TestB"}) {
+			t.Fatalf("unexpected comparison: %+v", report.Baseline)
+		}
+	})
+	t.Run("new failure", func(t *testing.T) {
+		result := newResult()
+		baseline := &Report{Details: []*ReportDetail{
+			{Name: "TestA", Status: ResultStatusSuccess},
+			{Name: "TestB", Status: ResultStatusSuccess},
+		}}
+		result.applyBaseline(baseline, "baseline.json", logger)
+		if result.status != ResultStatusFailure {
+			t.Fatalf("unexpected status: %s", result.status)
+		}
+		if !reflect.DeepEqual(result.baseline.NewFailures, []string{"TestB"}) {
+			t.Fatalf("unexpected new failures: %v", result.baseline.NewFailures)
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		result := newResult()
+		result.status = ResultStatusError
+		result.applyBaseline(&Report{Details: []*ReportDetail{{Name: "TestB", Status: ResultStatusFailure}}}, "baseline.json", logger)
+		if result.status != ResultStatusError {
+			t.Fatalf("the error must not be tolerated by the baseline: %s", result.status)
+		}
+	})
+}
+
+func TestBaselineSmokeFailure(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	testjob := TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec: TestJobSpec{
+			MainStep: MainStep{
+				Strategy: &Strategy{
+					Key: StrategyKeySpec{
+						Env:    "TEST",
+						Source: StrategyKeySource{Static: []string{"TestSmoke", "TestA"}},
+					},
+					Scheduler: Scheduler{MaxContainersPerPod: 16, MaxConcurrentNumPerPod: 1},
+					Smoke:     &StrategySmokeSpec{Keys: []string{"TestSmoke"}},
+				},
+				Template: TestJobTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"},
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{
+								Container: corev1.Container{
+									Name:    "test",
+									Image:   "alpine",
+									Command: []string{"sh", "-c"},
+									Args:    []string{fmt.Sprintf(`echo $TEST >> %s; test "$TEST" != TestSmoke`, runs)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	baselinePath := filepath.Join(t.TempDir(), "baseline.json")
+	baseline, err := json.Marshal(&Report{Details: []*ReportDetail{
+		{Name: "TestSmoke", Status: ResultStatusFailure},
+		{Name: "TestA", Status: ResultStatusSuccess},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(baselinePath, baseline, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runner := NewRunner(getConfig(), RunModeLocal)
+	runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+	runner.SetWorkDir(t.TempDir())
+	runner.SetCompareBaseline(baselinePath)
+	report, err := runner.Run(context.Background(), testjob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != ResultStatusFailure {
+		t.Fatalf("the run whose smoke test failed must fail even if it also failed in the baseline: %s", report.Status)
+	}
+	if report.Baseline == nil || !reflect.DeepEqual(report.Baseline.KnownFailures, []string{"TestSmoke"}) {
+		t.Fatalf("unexpected comparison: %+v", report.Baseline)
+	}
+	b, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(b)); strings.Join(got, ",") != "TestSmoke" {
+		t.Fatalf("the other tests must not run after the smoke test failed: %v", got)
+	}
+}
+
+func TestPreStepFailureReport(t *testing.T) {
+	logger := NewLogger(io.Discard, LogLevelInfo)
+	logger.AddMask("secret-value")
+	runner := NewRunner(getConfig(), RunModeLocal)
+	runner.SetLogger(logger)
+	runner.SetWorkDir(t.TempDir())
+	newPreStep := func(name, script string) PreStep {
+		return PreStep{
+			Name: name,
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{
+						{
+							Container: corev1.Container{
+								Name:    name,
+								Image:   "alpine",
+								Command: []string{"sh", "-c"},
+								Args:    []string{script},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	report, err := runner.Run(context.Background(), TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec: TestJobSpec{
+			PreSteps: []PreStep{
+				newPreStep("build", "echo build"),
+				newPreStep("prepare", "echo 'failed to prepare with secret-value'; exit 1"),
+			},
+			MainStep: MainStep{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"true"}}},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error by the failed prestep")
+	}
+	if report == nil {
+		t.Fatal("the report must be returned with the result of the failed prestep")
+	}
+	if report.Status != ResultStatusError {
+		t.Fatalf("unexpected status: %s", report.Status)
+	}
+	if len(report.PreSteps) != 2 {
+		t.Fatalf("unexpected presteps: %+v", report.PreSteps)
+	}
+	if report.PreSteps[0].Status != ResultStatusSuccess || report.PreSteps[0].Output != "" {
+		t.Fatalf("unexpected result of the succeeded prestep: %+v", report.PreSteps[0])
+	}
+	failed := report.PreSteps[1]
+	if failed.Name != "prepare" || failed.Status != ResultStatusError {
+		t.Fatalf("unexpected result of the failed prestep: %+v", failed)
+	}
+	if !strings.Contains(failed.Output, "failed to prepare with ************") {
+		t.Fatalf("the output of the failed prestep must be recorded with masks: %q", failed.Output)
+	}
+}
 
+func TestTeardown(t *testing.T) {
+	runner := NewRunner(getConfig(), RunModeLocal)
+	runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+	runner.SetWorkDir(t.TempDir())
+	teardownPath := filepath.Join(t.TempDir(), "teardown")
+	report, err := runner.Run(context.Background(), TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec: TestJobSpec{
+			PreSteps: []PreStep{
+				{
+					Name: "prepare",
+					Template: TestJobTemplateSpec{
+						Spec: TestJobPodSpec{
+							Containers: []TestJobContainer{
+								{Container: corev1.Container{Name: "prepare", Image: "alpine", Command: []string{"false"}}},
+							},
+						},
+					},
+				},
+			},
+			MainStep: MainStep{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"true"}}},
+						},
+						FinalizerContainer: TestJobContainer{
+							Container: corev1.Container{
+								Name:    "finalizer",
+								Image:   "alpine",
+								Command: []string{"sh", "-c"},
+								Args:    []string{"echo teardown > " + teardownPath},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed to run prestep prepare") {
+		t.Fatalf("expected error by the failed prestep but got %v", err)
+	}
+	if _, err := os.Stat(teardownPath); err != nil {
+		t.Fatalf("the finalizer of mainStep must run even if the prestep failed: %v", err)
+	}
+	if report == nil || len(report.Finalizers) != 1 {
+		t.Fatalf("the result of the finalizer must be reported: %+v", report)
+	}
+	if finalizer := report.Finalizers[0]; finalizer.Container != "finalizer" || !finalizer.Degraded {
+		t.Fatalf("unexpected finalizer: %+v", finalizer)
+	}
+}
+
+func TestTeardownTimeout(t *testing.T) {
+	defaultTeardownTimeout := teardownTimeout
+	teardownTimeout = 200 * time.Millisecond
+	defer func() { teardownTimeout = defaultTeardownTimeout }()
+
+	runner := NewRunner(getConfig(), RunModeLocal)
+	runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+	runner.SetWorkDir(t.TempDir())
+	start := time.Now()
+	report, err := runner.Run(context.Background(), TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec: TestJobSpec{
+			PreSteps: []PreStep{
+				{
+					Name: "prepare",
+					Template: TestJobTemplateSpec{
+						Spec: TestJobPodSpec{
+							Containers: []TestJobContainer{
+								{Container: corev1.Container{Name: "prepare", Image: "alpine", Command: []string{"false"}}},
+							},
+						},
+					},
+				},
+			},
+			MainStep: MainStep{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"true"}}},
+						},
+						FinalizerContainer: TestJobContainer{
+							Container: corev1.Container{Name: "finalizer", Image: "alpine", Command: []string{"sleep"}, Args: []string{"5"}},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed to run prestep prepare") {
+		t.Fatalf("expected error by the failed prestep but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("the teardown must give up after the timeout but took %s", elapsed)
+	}
+	if report != nil && len(report.Finalizers) != 0 {
+		t.Fatalf("the finalizer given up must not be reported: %+v", report.Finalizers)
+	}
+}
+
+func TestMainStepFinalizerTracked(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo))
+	finalizer := TestJobContainer{Container: corev1.Container{Name: "finalizer", Image: "alpine", Command: []string{"true"}}}
+	preStep := PreStep{
+		Name: "prepare",
+		Template: TestJobTemplateSpec{
+			Spec: TestJobPodSpec{
+				Containers:         []TestJobContainer{{Container: corev1.Container{Name: "prepare", Image: "alpine", Command: []string{"true"}}}},
+				FinalizerContainer: finalizer,
+			},
+		},
+	}
+	mainStep := MainStep{
+		Template: TestJobTemplateSpec{
+			Spec: TestJobPodSpec{
+				Containers:         []TestJobContainer{{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"true"}}}},
+				FinalizerContainer: finalizer,
+			},
+		},
+	}
+	testjob := TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec:       TestJobSpec{PreSteps: []PreStep{preStep}, MainStep: mainStep},
+	}
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewResourceManager(clientset, testjob)
+	mgr.SetWorkDir(t.TempDir())
+	if err := mgr.Setup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Cleanup()
+	builder := NewTaskBuilder(getConfig(), mgr, "default", RunModeLocal)
+	var called int
+	builder.setOnMainStepFinalizer(func() { called++ })
+	for _, step := range []Step{&preStep, &mainStep} {
+		task, err := builder.Build(ctx, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := task.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the finalizer of mainStep is tracked.
+	if called != 1 {
+		t.Fatalf("unexpected number of calls: %d", called)
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	cfg := getConfig()
+	runner := NewRunner(cfg, RunModeLocal)
+	if runner.restConfig() != cfg {
+		t.Fatal("the config passed to NewRunner must be used as it is by default")
+	}
+	runner.SetClientTimeout(time.Minute)
+	runner.SetClientRateLimit(50, 100)
+	restCfg := runner.restConfig()
+	if restCfg.Timeout != 0 || restCfg.QPS != 50 || restCfg.Burst != 100 {
+		t.Fatalf("unexpected client config: timeout %s, qps %f, burst %d", restCfg.Timeout, restCfg.QPS, restCfg.Burst)
+	}
+	requestCfg := runner.requestConfig(restCfg)
+	if requestCfg.Timeout != time.Minute || requestCfg.QPS != 50 || requestCfg.Burst != 100 {
+		t.Fatalf("unexpected request config: timeout %s, qps %f, burst %d", requestCfg.Timeout, requestCfg.QPS, requestCfg.Burst)
+	}
+	if restCfg.Timeout != 0 {
+		t.Fatal("the timeout must not be applied to the config running the Jobs because it cuts the streams")
+	}
+	if restCfg.RateLimiter == nil || requestCfg.RateLimiter != restCfg.RateLimiter {
+		t.Fatal("all clients of the run must share the rate limiter")
+	}
+	if cfg.Timeout != 0 || cfg.QPS != 0 || cfg.Burst != 0 {
+		t.Fatal("the config passed to NewRunner must not be modified")
+	}
+}
+
+func TestRetryMainTests(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	testjob := TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec: TestJobSpec{
+			MainStep: MainStep{
+				Strategy: &Strategy{
+					Key: StrategyKeySpec{
+						Env:    "TEST",
+						Source: StrategyKeySource{Static: []string{"TestSmoke", "TestA"}},
+					},
+					Scheduler: Scheduler{MaxContainersPerPod: 16, MaxConcurrentNumPerPod: 1},
+					Smoke:     &StrategySmokeSpec{Keys: []string{"TestSmoke"}},
+					Retest:    true,
+				},
+				Template: TestJobTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"},
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{
+								Container: corev1.Container{
+									Name:    "test",
+									Image:   "alpine",
+									Command: []string{"sh", "-c"},
+									Args:    []string{fmt.Sprintf(`echo $TEST >> %s; test "$TEST" != TestA`, runs)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	runner := NewRunner(getConfig(), RunModeLocal)
+	runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+	ctx := WithLogger(context.Background(), runner.logger)
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+	scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+	smokeResult, smokeTaskNum, err := runner.runSmokeTests(ctx, testjob, scheduler, builder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _, err := runner.runMainTests(ctx, testjob, scheduler, builder, smokeResult, smokeTaskNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TotalNum() != 2 || result.FailureNum() != 1 {
+		t.Fatalf("unexpected result: total %d, failure %d", result.TotalNum(), result.FailureNum())
+	}
+	b, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the failed test is retried once, and the smoke test isn't run again.
+	if got := strings.Fields(string(b)); strings.Join(got, ",") != "TestSmoke,TestA,TestA" {
+		t.Fatalf("unexpected runs: %v", got)
+	}
+}
+
+func TestRunTestJobs(t *testing.T) {
+	runner := NewRunner(getConfig(), RunModeLocal)
+	runner.SetLogger(NewLogger(io.Discard, LogLevelInfo))
+	runner.SetWorkDir(t.TempDir())
+	newTestJob := func(name, script string) TestJob {
+		meta := testjobObjectMeta()
+		meta.Name = name
+		return TestJob{
+			ObjectMeta: meta,
+			Spec: TestJobSpec{
+				PreSteps: []PreStep{
+					{
+						Name: "prepare",
+						Template: TestJobTemplateSpec{
+							Spec: TestJobPodSpec{
+								Containers: []TestJobContainer{
+									{Container: corev1.Container{Name: "prepare", Image: "alpine", Command: []string{"sh", "-c"}, Args: []string{script}}},
+								},
+							},
+						},
+					},
+				},
+				MainStep: MainStep{
+					Template: TestJobTemplateSpec{
+						Spec: TestJobPodSpec{
+							Containers: []TestJobContainer{
+								{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"true"}}},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	reports, err := runner.RunTestJobs(context.Background(), []TestJob{
+		newTestJob("first", "exit 1"),
+		newTestJob("second", "true"),
+		newTestJob("third", "exit 2"),
+	})
+	if err == nil {
+		t.Fatal("expected error by the failed testjobs")
+	}
+	if !strings.Contains(err.Error(), "testjob 0 ( first )") || !strings.Contains(err.Error(), "testjob 2 ( third )") {
+		t.Fatalf("the errors of all failed testjobs must be returned: %v", err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("the testjobs after the failed one must run: %d reports", len(reports))
+	}
+	for idx, expected := range []ResultStatus{ResultStatusError, ResultStatusSuccess, ResultStatusError} {
+		if reports[idx].Status != expected {
+			t.Fatalf("unexpected status of testjob %d: %s", idx, reports[idx].Status)
+		}
+	}
 }
@@ -5,29 +5,101 @@ package v1
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math/rand"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// dynamicKeysRetryInterval interval before the first retry of the job to get dynamic keys.
+	dynamicKeysRetryInterval = 1 * time.Second
+	// dynamicKeysMaxRetryInterval upper bound of the doubled interval between the retries of the job to get dynamic keys.
+	dynamicKeysMaxRetryInterval = 30 * time.Second
+	// dynamicKeysMaxRetryWait upper bound of the total time waiting between the retries of the job to get dynamic keys.
+	dynamicKeysMaxRetryWait = 5 * time.Minute
 )
 
 type TaskScheduler struct {
 	step    MainStep
 	builder *TaskBuilder
+	// keyMetadata metadata of each key got dynamically.
+	keyMetadata map[string]map[string]string
+	// originalKeys original key of each key renamed by strategy.key.duplicates.
+	originalKeys map[string]string
+	// keys the keys got by ScheduleSmoke. Schedule reuses them instead of getting the keys again.
+	keys []string
+	// keysResolved whether keys were got. keys may be nil if the processor returned nil, so this is used to reuse them.
+	keysResolved bool
+	// smokeKeys the keys already scheduled by ScheduleSmoke.
+	smokeKeys map[string]struct{}
+	// keyMetadataMu guards keyMetadata written by the dynamic sources running concurrently.
+	keyMetadataMu sync.Mutex
+	// buildMu serializes building the tasks of the dynamic sources because TaskBuilder is not goroutine safe.
+	buildMu sync.Mutex
+	// shuffleSeed seed used to shuffle the keys. This is nil if the keys are not shuffled.
+	shuffleSeed *int64
+	// listCommand command used instead of the command of the template to get dynamic keys.
+	listCommand []string
+	// testListProcessor processes the keys got from the key source before scheduling them.
+	testListProcessor TestListProcessor
 }
 
+// TestListProcessor transforms the names of the tests got from the key source ( e.g. filter, reorder, dedupe ).
+// If it returns error, the run is aborted.
+type TestListProcessor func([]string) ([]string, error)
+
 func NewTaskScheduler(step MainStep) *TaskScheduler {
 	return &TaskScheduler{
 		step: step,
 	}
 }
 
+// SetListCommand set the command used instead of the command and the args of the main container to get dynamic keys.
+// The step passed to NewTaskScheduler isn't changed. This is available only if the step has a single dynamic key source.
+func (s *TaskScheduler) SetListCommand(command []string) {
+	s.listCommand = command
+}
+
+// SetTestListProcessor set the processor applied to the keys got from the key source before scheduling them.
+// The processor runs after the built-in filter of the key source and before handling the duplicated keys and shuffling them.
+func (s *TaskScheduler) SetTestListProcessor(processor TestListProcessor) {
+	s.testListProcessor = processor
+}
+
 type StrategyKey struct {
 	ConcurrentIdx    uint32
 	Keys             []string
 	Env              string
 	SubTaskScheduler *SubTaskScheduler
 	OnFinishSubTask  func(*SubTask)
+	// Metadata metadata of each key. This is nil if the keys don't have metadata.
+	Metadata map[string]map[string]string
+	// Images image of the main container for each key.
+	Images map[string]string
+	// OriginalKeys original key of each key renamed by strategy.key.duplicates ( e.g. TestA#1 => TestA ).
+	// The renamed key identifies the container in the report, and the original key is set to Env and used to look up Images.
+	OriginalKeys map[string]string
+}
+
+// originalKey returns the key before being renamed by strategy.key.duplicates.
+func (k *StrategyKey) originalKey(key string) string {
+	if original, exists := k.OriginalKeys[key]; exists {
+		return original
+	}
+	return key
+}
+
+// strategyKeyContainerName returns the name of the container running the idx-th key of the task of concurrentIdx.
+func strategyKeyContainerName(mainContainerName string, concurrentIdx uint32, idx int) string {
+	return mainContainerName + fmt.Sprintf("%d-%d", concurrentIdx, idx)
 }
 
 func (s *TaskScheduler) Schedule(ctx context.Context, builder *TaskBuilder) (*TaskGroup, error) {
@@ -38,145 +110,489 @@ func (s *TaskScheduler) Schedule(ctx context.Context, builder *TaskBuilder) (*Ta
 		}
 		return NewTaskGroup([]*Task{task}), nil
 	}
-	strategy := s.step.Strategy
-	keys, err := s.getScheduleKeys(ctx, builder, strategy.Key.Source)
+	keys, err := s.strategyKeys(ctx, builder)
 	if err != nil {
 		return nil, err
 	}
-	subTaskScheduler := NewSubTaskScheduler(strategy.Scheduler.MaxConcurrentNumPerPod)
-	switch {
-	case strategy.Scheduler.MaxPodNum != 0:
-		return s.maxPodNumBasedSchedule(ctx, builder, keys, subTaskScheduler)
-	case strategy.Scheduler.MaxContainersPerPod != 0:
-		return s.maxContainersBasedSchedule(ctx, builder, keys, subTaskScheduler)
+	if len(s.smokeKeys) != 0 {
+		restKeys := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if _, exists := s.smokeKeys[key]; !exists {
+				restKeys = append(restKeys, key)
+			}
+		}
+		if len(restKeys) == 0 {
+			return NewTaskGroup(nil), nil
+		}
+		keys = restKeys
 	}
-	return nil, fmt.Errorf("kubetest: unsupecified scheduler parameter. maxPodNum or maxContainersPerPod must be specified")
+	return s.scheduleKeys(ctx, builder, keys)
 }
 
-func (s *TaskScheduler) maxContainersBasedSchedule(ctx context.Context, builder *TaskBuilder, keys []string, subTaskScheduler *SubTaskScheduler) (*TaskGroup, error) {
-	strategy := s.step.Strategy
-	maxContainers := uint32(strategy.Scheduler.MaxContainersPerPod)
+// ScheduleSmoke schedules tasks to run only the smoke tests specified by strategy.smoke.
+// The smoke tests are excluded from the tasks scheduled by Schedule after this.
+// If smoke tests are not specified or no key matches them, returns nil.
+func (s *TaskScheduler) ScheduleSmoke(ctx context.Context, builder *TaskBuilder) (*TaskGroup, error) {
+	if s.step.Strategy == nil || s.step.Strategy.Smoke == nil {
+		return nil, nil
+	}
+	keys, err := s.strategyKeys(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+	smokeKeys, err := selectSmokeKeys(s.step.Strategy.Smoke, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(smokeKeys) == 0 {
+		LoggerFromContext(ctx).Warn("no key matches the smoke tests")
+		return nil, nil
+	}
+	s.smokeKeys = make(map[string]struct{}, len(smokeKeys))
+	for _, key := range smokeKeys {
+		s.smokeKeys[key] = struct{}{}
+	}
+	return s.scheduleKeys(ctx, builder, smokeKeys)
+}
 
-	var (
-		finishedKeyNum uint32
-		finishedKeyMu  sync.Mutex
-		keyNum         uint32 = uint32(len(keys))
-	)
-	if keyNum <= maxContainers {
-		task, err := builder.BuildWithKey(ctx, &s.step, &StrategyKey{
-			ConcurrentIdx:    0,
-			Keys:             keys,
-			SubTaskScheduler: subTaskScheduler,
-			Env:              strategy.Key.Env,
-			OnFinishSubTask: func(_ *SubTask) {
-				finishedKeyMu.Lock()
-				defer finishedKeyMu.Unlock()
-				finishedKeyNum++
-				LoggerFromContext(ctx).Info(
-					"%d/%d (%f%%) finished.",
-					finishedKeyNum, keyNum, (float32(finishedKeyNum)/float32(keyNum))*100,
-				)
-			},
-		})
+func (s *TaskScheduler) strategyKeys(ctx context.Context, builder *TaskBuilder) ([]string, error) {
+	if s.keysResolved {
+		return s.keys, nil
+	}
+	if len(s.listCommand) != 0 {
+		if sourceNum := len(dynamicKeySources(s.step.Strategy.Key.Source)); sourceNum != 1 {
+			return nil, fmt.Errorf("kubetest: list command can be overridden only for a single dynamic key source but found %d sources", sourceNum)
+		}
+	}
+	keys, err := s.getScheduleKeys(ctx, builder, s.step.Strategy.Key.Source)
+	if err != nil {
+		return nil, err
+	}
+	if s.testListProcessor != nil {
+		processed, err := s.testListProcessor(append([]string{}, keys...))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("kubetest: failed to process the list of tests: %w", err)
 		}
-		return NewTaskGroup([]*Task{task}), nil
+		LoggerFromContext(ctx).Info("processed the list of %d tests into %d tests", len(keys), len(processed))
+		keys = processed
 	}
-	concurrent := keyNum / maxContainers
-	tasks := []*Task{}
-	sum := uint32(0)
-	for i := uint32(0); i <= concurrent; i++ {
-		var taskKeys []string
-		if i == concurrent {
-			taskKeys = keys[sum:]
-		} else {
-			taskKeys = keys[sum : sum+maxContainers]
+	keys, err = s.handleDuplicateKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	if scheduler := s.step.Strategy.Scheduler; scheduler.ShuffleKeys {
+		seed := time.Now().UnixNano()
+		if scheduler.ShuffleSeed != nil {
+			seed = *scheduler.ShuffleSeed
 		}
-		taskNum := uint32(len(taskKeys))
-		if taskNum == 0 {
-			// if 'keyNum % maxContaienrs' is zero, taskKeys goes to zero in the last loop.
+		keys = shuffleKeys(keys, seed)
+		s.shuffleSeed = &seed
+		LoggerFromContext(ctx).Info("shuffled %d keys with seed %d", len(keys), seed)
+	}
+	s.keys = keys
+	s.keysResolved = true
+	return keys, nil
+}
+
+// handleDuplicateKeys handles the duplicated keys by strategy.key.duplicates.
+// The keys renamed by the suffix inherit the metadata of the original key.
+func (s *TaskScheduler) handleDuplicateKeys(ctx context.Context, keys []string) ([]string, error) {
+	duplicates := duplicateKeys(keys)
+	if len(duplicates) == 0 {
+		return keys, nil
+	}
+	switch s.step.Strategy.Key.Duplicates {
+	case DuplicateKeyPolicyError:
+		return nil, fmt.Errorf("kubetest: found duplicated keys: %s", strings.Join(duplicates, ", "))
+	case DuplicateKeyPolicySuffix:
+		renamed := renameDuplicateKeys(keys)
+		s.originalKeys = map[string]string{}
+		s.keyMetadataMu.Lock()
+		for idx, key := range renamed {
+			if key == keys[idx] {
+				continue
+			}
+			s.originalKeys[key] = keys[idx]
+			if metadata, exists := s.keyMetadata[keys[idx]]; exists {
+				s.keyMetadata[key] = metadata
+			}
+		}
+		s.keyMetadataMu.Unlock()
+		LoggerFromContext(ctx).Info("renamed duplicated keys by the index suffix: %s", strings.Join(duplicates, ", "))
+		return renamed, nil
+	}
+	LoggerFromContext(ctx).Warn(
+		"found duplicated keys: %s. their results collide in the report. use strategy.key.duplicates to handle them",
+		strings.Join(duplicates, ", "),
+	)
+	return keys, nil
+}
+
+// duplicateKeys returns the keys appearing more than once in order of the first appearance.
+func duplicateKeys(keys []string) []string {
+	counts := make(map[string]int, len(keys))
+	duplicates := []string{}
+	for _, key := range keys {
+		counts[key]++
+		if counts[key] == 2 {
+			duplicates = append(duplicates, key)
+		}
+	}
+	return duplicates
+}
+
+// renameDuplicateKeys renames the second and later occurrences of the key to <key>#<index> ( e.g. TestA, TestA#1, TestA#2 ).
+// The index is skipped if the renamed key is already in keys.
+func renameDuplicateKeys(keys []string) []string {
+	used := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		used[key] = struct{}{}
+	}
+	seen := make(map[string]int, len(keys))
+	renamed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		count, exists := seen[key]
+		if !exists {
+			seen[key] = 1
+			renamed = append(renamed, key)
 			continue
 		}
-		task, err := builder.BuildWithKey(ctx, &s.step, &StrategyKey{
-			ConcurrentIdx:    i,
-			Keys:             taskKeys,
-			SubTaskScheduler: subTaskScheduler,
-			Env:              strategy.Key.Env,
-			OnFinishSubTask: func(_ *SubTask) {
-				finishedKeyMu.Lock()
-				defer finishedKeyMu.Unlock()
-				finishedKeyNum++
-				LoggerFromContext(ctx).Info(
-					"%d/%d (%f%%) finished.",
-					finishedKeyNum, keyNum, (float32(finishedKeyNum)/float32(keyNum))*100,
-				)
-			},
-		})
+		name := fmt.Sprintf("%s#%d", key, count)
+		for {
+			if _, exists := used[name]; !exists {
+				break
+			}
+			count++
+			name = fmt.Sprintf("%s#%d", key, count)
+		}
+		seen[key] = count + 1
+		used[name] = struct{}{}
+		renamed = append(renamed, name)
+	}
+	return renamed
+}
+
+// ShuffleSeed returns the seed used to shuffle the keys.
+// If the keys are not shuffled, returns false.
+func (s *TaskScheduler) ShuffleSeed() (int64, bool) {
+	if s.shuffleSeed == nil {
+		return 0, false
+	}
+	return *s.shuffleSeed, true
+}
+
+// shuffleKeys returns the keys in random order determined by seed.
+func shuffleKeys(keys []string, seed int64) []string {
+	shuffled := append([]string{}, keys...)
+	rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// selectSmokeKeys returns the keys specified by spec.keys or matched by spec.pattern in the order of keys.
+func selectSmokeKeys(spec *StrategySmokeSpec, keys []string) ([]string, error) {
+	var pattern *regexp.Regexp
+	if spec.Pattern != "" {
+		p, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to compile smoke pattern %s: %w", spec.Pattern, err)
+		}
+		pattern = p
+	}
+	specifiedKeys := make(map[string]struct{}, len(spec.Keys))
+	for _, key := range spec.Keys {
+		specifiedKeys[key] = struct{}{}
+	}
+	smokeKeys := []string{}
+	for _, key := range keys {
+		_, specified := specifiedKeys[key]
+		if specified || (pattern != nil && pattern.MatchString(key)) {
+			smokeKeys = append(smokeKeys, key)
+		}
+	}
+	return smokeKeys, nil
+}
+
+// ScheduleRetry schedules tasks to run again only the tests specified by names.
+// If strategy is not specified, the main step is scheduled again.
+func (s *TaskScheduler) ScheduleRetry(ctx context.Context, builder *TaskBuilder, names []string) (*TaskGroup, error) {
+	if s.step.Strategy == nil {
+		task, err := builder.Build(ctx, &s.step)
 		if err != nil {
 			return nil, err
 		}
-		tasks = append(tasks, task)
-		sum += taskNum
+		return NewTaskGroup([]*Task{task}), nil
 	}
-	if keyNum != sum {
-		return nil, fmt.Errorf("kubetest: failed to schedule: required key num %d but scheduled key num %d", keyNum, sum)
+	return s.scheduleKeys(ctx, builder, names)
+}
+
+// scheduleKeys schedules tasks to run keys.
+// If strategy.key.after has constraints between keys, the keys are scheduled in stages and each stage runs after the previous one finishes.
+func (s *TaskScheduler) scheduleKeys(ctx context.Context, builder *TaskBuilder, keys []string) (*TaskGroup, error) {
+	stages, err := keyStages(keys, s.step.Strategy.Key.After)
+	if err != nil {
+		return nil, err
 	}
-	return NewTaskGroup(tasks), nil
+	if len(stages) <= 1 {
+		return s.scheduleStage(ctx, builder, keys)
+	}
+	LoggerFromContext(ctx).Info("scheduled %d keys in %d stages by strategy.key.after", len(keys), len(stages))
+	var (
+		first *TaskGroup
+		last  *TaskGroup
+	)
+	for _, stage := range stages {
+		taskGroup, err := s.scheduleStage(ctx, builder, stage)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = taskGroup
+		} else {
+			last.SetNext(taskGroup)
+		}
+		last = taskGroup
+	}
+	return first, nil
 }
 
-func (s *TaskScheduler) maxPodNumBasedSchedule(ctx context.Context, builder *TaskBuilder, keys []string, subTaskScheduler *SubTaskScheduler) (*TaskGroup, error) {
+func (s *TaskScheduler) scheduleStage(ctx context.Context, builder *TaskBuilder, keys []string) (*TaskGroup, error) {
 	strategy := s.step.Strategy
-	maxPods := uint32(strategy.Scheduler.MaxPodNum)
+	subTaskScheduler := NewSubTaskScheduler(strategy.Scheduler.MaxConcurrentNumPerPod)
+	subTaskScheduler.SetAllocation(strategy.Scheduler.Allocation)
+	subTaskScheduler.SetResourceBudget(strategy.Scheduler.MaxResourcesPerPod, strategy.Key.Resources)
+	if strategy.Scheduler.MaxPodNum == 0 && strategy.Scheduler.MaxContainersPerPod == 0 {
+		return nil, fmt.Errorf("kubetest: unsupecified scheduler parameter. maxPodNum or maxContainersPerPod must be specified")
+	}
+	keyNums := s.plannedKeyNums(len(keys))
+	if strategy.Scheduler.MaxPodNum == 0 && !strategy.Scheduler.Rebalance {
+		warnUnevenShards(ctx, keyNums, strategy.Scheduler.MaxContainersPerPod)
+	}
+	taskGroup, err := s.scheduleByKeyNums(ctx, builder, keys, keyNums, subTaskScheduler)
+	if err != nil {
+		return nil, err
+	}
+	if strategy.Scheduler.MaxCPU != nil {
+		taskGroup.SetCPUBudget(*strategy.Scheduler.MaxCPU)
+	}
+	return taskGroup, nil
+}
 
-	var (
-		finishedKeyNum uint32
-		finishedKeyMu  sync.Mutex
-		keyNum         uint32 = uint32(len(keys))
-		tasks          []*Task
+// keyStages splits keys into stages so that each key is in a later stage than the keys it must run after.
+// The key is placed in the earliest possible stage, and the order of keys is kept in each stage.
+// The constraints for the keys not included in keys are ignored.
+func keyStages(keys []string, after map[string][]string) ([][]string, error) {
+	if len(after) == 0 {
+		return [][]string{keys}, nil
+	}
+	if cycle := keyOrderCycle(after); len(cycle) != 0 {
+		return nil, fmt.Errorf("kubetest: strategy.key.after has a cycle: %s", strings.Join(cycle, " -> "))
+	}
+	included := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		included[key] = struct{}{}
+	}
+	levels := map[string]int{}
+	var level func(key string) int
+	level = func(key string) int {
+		if l, exists := levels[key]; exists {
+			return l
+		}
+		l := 0
+		for _, dep := range after[key] {
+			if _, exists := included[dep]; !exists {
+				continue
+			}
+			if depLevel := level(dep) + 1; depLevel > l {
+				l = depLevel
+			}
+		}
+		levels[key] = l
+		return l
+	}
+	var stages [][]string
+	for _, key := range keys {
+		l := level(key)
+		for len(stages) <= l {
+			stages = append(stages, []string{})
+		}
+		stages[l] = append(stages[l], key)
+	}
+	return stages, nil
+}
+
+// keyOrderCycle returns the keys forming a cycle in the constraints of strategy.key.after ( e.g. [A B A] ).
+// If the constraints have no cycle, returns nil.
+func keyOrderCycle(after map[string][]string) []string {
+	const (
+		visiting = iota + 1
+		visited
 	)
-	if keyNum < maxPods {
-		// If there are more Pods in use than the number of keys, launch as many Pods as there are keys.
-		for i := uint32(0); i < keyNum; i++ {
-			task, err := builder.BuildWithKey(ctx, &s.step, &StrategyKey{
-				ConcurrentIdx:    i,
-				Keys:             []string{keys[i]},
-				SubTaskScheduler: subTaskScheduler,
-				Env:              strategy.Key.Env,
-				OnFinishSubTask: func(_ *SubTask) {
-					finishedKeyMu.Lock()
-					defer finishedKeyMu.Unlock()
-					finishedKeyNum++
-					LoggerFromContext(ctx).Info(
-						"%d/%d (%f%%) finished.",
-						finishedKeyNum, keyNum, (float32(finishedKeyNum)/float32(keyNum))*100,
-					)
-				},
-			})
-			if err != nil {
-				return nil, err
+	states := map[string]int{}
+	var path []string
+	var visit func(key string) []string
+	visit = func(key string) []string {
+		switch states[key] {
+		case visiting:
+			for idx, k := range path {
+				if k == key {
+					return append(append([]string{}, path[idx:]...), key)
+				}
+			}
+		case visited:
+			return nil
+		}
+		states[key] = visiting
+		path = append(path, key)
+		for _, dep := range after[key] {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
 			}
-			tasks = append(tasks, task)
 		}
-		return NewTaskGroup(tasks), nil
+		path = path[:len(path)-1]
+		states[key] = visited
+		return nil
+	}
+	keys := make([]string, 0, len(after))
+	for key := range after {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if cycle := visit(key); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// minTaskNum returns the minimum number of tasks to be scheduled.
+// If the keys are obtained dynamically, the actual number of tasks is determined after getting the keys.
+func (s *TaskScheduler) minTaskNum() int {
+	strategy := s.step.Strategy
+	if strategy == nil {
+		return 1
+	}
+	keys := strategy.Key.Source.Static
+	if len(keys) == 0 {
+		return 1
+	}
+	if strategy.Smoke != nil {
+		// the smoke tests are scheduled separately from the other tests.
+		smokeKeys, err := selectSmokeKeys(strategy.Smoke, keys)
+		if err == nil && len(smokeKeys) != 0 {
+			return s.plannedTaskNum(len(smokeKeys)) + s.plannedTaskNum(len(keys)-len(smokeKeys))
+		}
+	}
+	return s.plannedTaskNum(len(keys))
+}
+
+// plannedTaskNum returns the number of tasks to be scheduled for the specified number of keys.
+func (s *TaskScheduler) plannedTaskNum(keyNum int) int {
+	if s.step.Strategy == nil {
+		return 1
 	}
+	return len(s.plannedKeyNums(keyNum))
+}
 
+// plannedKeyNums returns the number of keys assigned to each task for the specified number of keys.
+// The tasks are scheduled by this, so the estimation using this is the same as the actual tasks.
+func (s *TaskScheduler) plannedKeyNums(keyNum int) []int {
+	strategy := s.step.Strategy
+	if strategy == nil || keyNum <= 0 {
+		return nil
+	}
+	switch {
+	case strategy.Scheduler.MaxPodNum != 0:
+		return maxPodNumKeyNums(keyNum, strategy.Scheduler.MaxPodNum)
+	case strategy.Scheduler.MaxContainersPerPod != 0:
+		return maxContainersKeyNums(keyNum, strategy.Scheduler.MaxContainersPerPod, strategy.Scheduler.Rebalance)
+	}
+	return nil
+}
+
+// maxPodNumKeyNums returns the number of keys assigned to each of maxPods pods at most.
+// If there are less keys than maxPods, each pod has one key. Otherwise, the last pod has the remainder of the keys.
+func maxPodNumKeyNums(keyNum, maxPods int) []int {
+	keyNums := []int{}
+	if keyNum <= 0 || maxPods <= 0 {
+		return keyNums
+	}
+	if keyNum < maxPods {
+		for i := 0; i < keyNum; i++ {
+			keyNums = append(keyNums, 1)
+		}
+		return keyNums
+	}
 	perPodKeyNum := keyNum / maxPods
-	sum := uint32(0)
-	for i := uint32(0); i < maxPods; i++ {
-		var taskKeys []string
-		if i == (maxPods - 1) {
-			taskKeys = keys[sum:]
-		} else {
-			taskKeys = keys[sum : sum+perPodKeyNum]
+	for i := 0; i < maxPods-1; i++ {
+		keyNums = append(keyNums, perPodKeyNum)
+	}
+	return append(keyNums, keyNum-perPodKeyNum*(maxPods-1))
+}
+
+// maxContainersKeyNums returns the number of keys assigned to each pod having maxContainers keys at most.
+// If rebalance is true, the keys are distributed evenly over the same number of pods, so the numbers differ by one at most.
+// Otherwise, the last pod has the remainder of the keys.
+func maxContainersKeyNums(keyNum, maxContainers int, rebalance bool) []int {
+	keyNums := []int{}
+	if keyNum <= 0 || maxContainers <= 0 {
+		return keyNums
+	}
+	podNum := (keyNum + maxContainers - 1) / maxContainers
+	for i := 0; i < podNum; i++ {
+		switch {
+		case rebalance:
+			num := keyNum / podNum
+			if i < keyNum%podNum {
+				num++
+			}
+			keyNums = append(keyNums, num)
+		case i == podNum-1:
+			keyNums = append(keyNums, keyNum-maxContainers*(podNum-1))
+		default:
+			keyNums = append(keyNums, maxContainers)
 		}
+	}
+	return keyNums
+}
+
+// warnUnevenShards warns if the last pod has less than half of maxContainers keys, because the pod is mostly wasted.
+func warnUnevenShards(ctx context.Context, keyNums []int, maxContainers int) {
+	if len(keyNums) < 2 {
+		return
+	}
+	last := keyNums[len(keyNums)-1]
+	if last*2 >= maxContainers {
+		return
+	}
+	LoggerFromContext(ctx).Warn(
+		"the last of %d shards has only %d/%d containers. set strategy.scheduler.rebalance to distribute the keys evenly",
+		len(keyNums), last, maxContainers,
+	)
+}
+
+// scheduleByKeyNums builds a task for each number of keyNums by assigning the keys in order.
+func (s *TaskScheduler) scheduleByKeyNums(ctx context.Context, builder *TaskBuilder, keys []string, keyNums []int, subTaskScheduler *SubTaskScheduler) (*TaskGroup, error) {
+	strategy := s.step.Strategy
+
+	var (
+		finishedKeyNum uint32
+		finishedKeyMu  sync.Mutex
+		keyNum         uint32 = uint32(len(keys))
+	)
+	tasks := []*Task{}
+	sum := uint32(0)
+	for i, num := range keyNums {
+		taskKeys := keys[sum : sum+uint32(num)]
 		taskNum := uint32(len(taskKeys))
-		if taskNum == 0 {
-			break
-		}
 		task, err := builder.BuildWithKey(ctx, &s.step, &StrategyKey{
-			ConcurrentIdx:    i,
+			ConcurrentIdx:    uint32(i),
 			Keys:             taskKeys,
+			Metadata:         s.keyMetadata,
+			Images:           strategy.Key.Images,
+			OriginalKeys:     s.originalKeys,
 			SubTaskScheduler: subTaskScheduler,
 			Env:              strategy.Key.Env,
 			OnFinishSubTask: func(_ *SubTask) {
@@ -203,6 +619,8 @@ func (s *TaskScheduler) maxPodNumBasedSchedule(ctx context.Context, builder *Tas
 
 func (s *TaskScheduler) getScheduleKeys(ctx context.Context, builder *TaskBuilder, source StrategyKeySource) ([]string, error) {
 	switch {
+	case source.Union || len(source.Dynamics) > 0:
+		return s.unionKeys(ctx, builder, source)
 	case len(source.Static) > 0:
 		LoggerFromContext(ctx).Info(
 			"found %d static keys to start distributed task",
@@ -216,18 +634,205 @@ func (s *TaskScheduler) getScheduleKeys(ctx context.Context, builder *TaskBuilde
 	}
 }
 
+// unionKeys returns the static keys followed by the keys of each dynamic source not included in the preceding keys.
+// If strategy.key.duplicates is error or suffix, the keys are concatenated as they are, so the policy handles the duplicates.
+// The keys of the dynamic sources are got concurrently, and the others are canceled if one of them fails.
+func (s *TaskScheduler) unionKeys(ctx context.Context, builder *TaskBuilder, source StrategyKeySource) ([]string, error) {
+	dynamicSources := dynamicKeySources(source)
+	keysPerSource := make([][]string, len(dynamicSources))
+	// the other sources are canceled if one of them fails, because the keys can't be combined anyway.
+	eg, egCtx := errgroup.WithContext(ctx)
+	for idx, dynamicSource := range dynamicSources {
+		idx, dynamicSource := idx, dynamicSource
+		eg.Go(func() error {
+			keys, err := s.dynamicKeys(egCtx, builder, dynamicSource)
+			if err != nil {
+				return err
+			}
+			keysPerSource[idx] = keys
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	allKeys := append([]string{}, source.Static...)
+	dynamicKeyNum := 0
+	for _, dynamicKeys := range keysPerSource {
+		allKeys = append(allKeys, dynamicKeys...)
+		dynamicKeyNum += len(dynamicKeys)
+	}
+	keys := allKeys
+	if policy := s.step.Strategy.Key.Duplicates; policy != DuplicateKeyPolicyError && policy != DuplicateKeyPolicySuffix {
+		keys = make([]string, 0, len(allKeys))
+		keyMap := map[string]struct{}{}
+		for _, key := range allKeys {
+			if _, exists := keyMap[key]; exists {
+				continue
+			}
+			keyMap[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	LoggerFromContext(ctx).Info(
+		"found %d keys from %d static keys and %d dynamic keys of %d sources to start distributed task",
+		len(keys), len(source.Static), dynamicKeyNum, len(dynamicSources),
+	)
+	return keys, nil
+}
+
+// dynamicKeySources returns dynamic and dynamics of source.
+func dynamicKeySources(source StrategyKeySource) []*StrategyDynamicKeySource {
+	sources := make([]*StrategyDynamicKeySource, 0, len(source.Dynamics)+1)
+	if source.Dynamic != nil {
+		sources = append(sources, source.Dynamic)
+	}
+	for idx := range source.Dynamics {
+		sources = append(sources, &source.Dynamics[idx])
+	}
+	return sources
+}
+
 func (s *TaskScheduler) dynamicKeys(ctx context.Context, builder *TaskBuilder, source *StrategyDynamicKeySource) ([]string, error) {
 	LoggerFromContext(ctx).Info("start to get dynamic task keys for running distributed task")
-	keyTask, err := builder.Build(ctx, &MainStep{
+	tmpl, err := s.listingTemplate(source)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := s.sourceFilter(source.Filter)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		out    []byte
+		waited time.Duration
+	)
+	interval := dynamicKeysRetryInterval
+	for retryCount := 0; ; retryCount++ {
+		out, err = s.runDynamicKeysTask(ctx, builder, source, tmpl)
+		if err == nil {
+			break
+		}
+		// pendingTimeout is the total time the listing pod can be pending, so it isn't retried.
+		if retryCount >= source.Retries || isPendingTimeoutError(err) {
+			return nil, err
+		}
+		if waited+interval > dynamicKeysMaxRetryWait {
+			return nil, fmt.Errorf("%w. gave up retrying to get dynamic keys after waiting %s", err, waited)
+		}
+		LoggerFromContext(ctx).Warn(
+			"%s. retry to get dynamic keys %d/%d",
+			err, retryCount+1, source.Retries,
+		)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(interval):
+		}
+		waited += interval
+		interval = min(interval*2, dynamicKeysMaxRetryInterval)
+	}
+	keys := []string{}
+	for _, key := range strings.Split(string(out), s.sourceDelim(source.Delim)) {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		var metadata map[string]string
+		if source.Format == KeyFormatJSON {
+			key, metadata, err = parseKeyWithMetadata(key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if filter != nil && !filter.MatchString(key) {
+			continue
+		}
+		if len(metadata) != 0 {
+			s.keyMetadataMu.Lock()
+			if s.keyMetadata == nil {
+				s.keyMetadata = map[string]map[string]string{}
+			}
+			s.keyMetadata[key] = metadata
+			s.keyMetadataMu.Unlock()
+		}
+		keys = append(keys, key)
+	}
+	LoggerFromContext(ctx).Info("found %d dynamic keys to start distributed task", len(keys))
+	return keys, nil
+}
+
+// overrideListCommand replaces the command and the args of the main container of tmpl with the list command.
+func (s *TaskScheduler) overrideListCommand(tmpl *TestJobTemplateSpec) error {
+	if len(s.listCommand) == 0 {
+		return nil
+	}
+	listingContainer, err := getMainContainerFromTmpl(*tmpl)
+	if err != nil {
+		return err
+	}
+	for idx := range tmpl.Spec.Containers {
+		if tmpl.Spec.Containers[idx].Name == listingContainer.Name {
+			tmpl.Spec.Containers[idx].Command = append([]string{}, s.listCommand...)
+			tmpl.Spec.Containers[idx].Args = nil
+		}
+	}
+	return nil
+}
+
+// parseKeyWithMetadata parses the key of JSON format ( e.g. {"name":"TestA","metadata":{"owner":"team-a"}} ).
+// "meta" is accepted as the short form of "metadata". If both are specified, "metadata" takes precedence for the same field.
+// The metadata is only attached to the results and doesn't affect scheduling.
+func parseKeyWithMetadata(key string) (string, map[string]string, error) {
+	var v struct {
+		Name     string            `json:"name"`
+		Metadata map[string]string `json:"metadata"`
+		Meta     map[string]string `json:"meta"`
+	}
+	if err := json.Unmarshal([]byte(key), &v); err != nil {
+		return "", nil, fmt.Errorf("kubetest: failed to decode dynamic key %q: %w", key, err)
+	}
+	if v.Name == "" {
+		return "", nil, fmt.Errorf("kubetest: name of dynamic key is empty: %q", key)
+	}
+	if len(v.Meta) == 0 {
+		return v.Name, v.Metadata, nil
+	}
+	metadata := make(map[string]string, len(v.Meta)+len(v.Metadata))
+	for k, value := range v.Meta {
+		metadata[k] = value
+	}
+	for k, value := range v.Metadata {
+		metadata[k] = value
+	}
+	return v.Name, metadata, nil
+}
+
+// runDynamicKeysTask runs the job to get dynamic keys and returns the output of the main container.
+// The job is built for each run because the internal state of the job changes after running.
+func (s *TaskScheduler) runDynamicKeysTask(ctx context.Context, builder *TaskBuilder, source *StrategyDynamicKeySource, tmpl TestJobTemplateSpec) ([]byte, error) {
+	pendingTimeout := defaultPendingTimeout
+	if source.PendingTimeout != "" {
+		timeout, err := time.ParseDuration(source.PendingTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to parse pendingTimeout of dynamic key source: %w", err)
+		}
+		pendingTimeout = timeout
+	}
+	s.buildMu.Lock()
+	keyTask, err := builder.BuildWithPendingTimeout(ctx, &MainStep{
 		TTLSecondsAfterFinished: source.TTLSecondsAfterFinished,
-		Template:                source.Template,
-	})
+		Template:                tmpl,
+	}, pendingTimeout)
+	s.buildMu.Unlock()
 	if err != nil {
 		return nil, err
 	}
 	result, err := keyTask.Run(ctx)
 	if err != nil {
-		return nil, err
+		if isPendingTimeoutError(err) {
+			return nil, fmt.Errorf("kubetest: cluster has no capacity for the listing pod: %w", err)
+		}
+		return nil, fmt.Errorf("kubetest: failed to run dynamic key task: %w", err)
 	}
 	mainResults := result.MainTaskResults()
 	if len(mainResults) == 0 {
@@ -237,25 +842,80 @@ func (s *TaskScheduler) dynamicKeys(ctx context.Context, builder *TaskBuilder, s
 		return nil, fmt.Errorf("kubetest: found multiple main task results")
 	}
 	if mainResults[0].Err != nil {
-		return nil, fmt.Errorf("kubetest: failed to get dynamic key task: %w", mainResults[0].Err)
+		return nil, fmt.Errorf("kubetest: failed to get dynamic key task: %w: output: %s", mainResults[0].Err, string(mainResults[0].Out))
 	}
-	out := mainResults[0].Out
-	filter, err := s.sourceFilter(source.Filter)
+	return mainResults[0].Out, nil
+}
+
+// listingTemplate returns the template to get dynamic keys.
+// The volumes specified by inheritVolumes are copied from the template of mainStep with their mounts of the main container.
+// The priorityClassName of mainStep is used if neither the source nor its template specifies it.
+// If the list command is overridden, it is used as the command of the main container of the template.
+func (s *TaskScheduler) listingTemplate(source *StrategyDynamicKeySource) (TestJobTemplateSpec, error) {
+	tmpl := *source.Template.DeepCopy()
+	if err := s.overrideListCommand(&tmpl); err != nil {
+		return tmpl, err
+	}
+	switch {
+	case source.PriorityClassName != "":
+		tmpl.Spec.PriorityClassName = source.PriorityClassName
+		tmpl.Spec.Priority = nil
+	case tmpl.Spec.PriorityClassName == "" && tmpl.Spec.Priority == nil:
+		tmpl.Spec.PriorityClassName = s.step.Template.Spec.PriorityClassName
+	}
+	if len(source.InheritVolumes) == 0 {
+		return tmpl, nil
+	}
+	mainContainer, err := getMainContainerFromTmpl(s.step.Template)
 	if err != nil {
-		return nil, err
+		return tmpl, err
 	}
-	keys := []string{}
-	for _, key := range strings.Split(string(out), s.sourceDelim(source.Delim)) {
-		if strings.TrimSpace(key) == "" {
-			continue
+	listingContainer, err := getMainContainerFromTmpl(tmpl)
+	if err != nil {
+		return tmpl, err
+	}
+	var listingContainerIdx int
+	for idx, container := range tmpl.Spec.Containers {
+		if container.Name == listingContainer.Name {
+			listingContainerIdx = idx
+			break
 		}
-		if filter != nil && !filter.MatchString(key) {
-			continue
+	}
+	volumeMap := map[string]TestJobVolume{}
+	for _, volume := range s.step.Template.Spec.Volumes {
+		volumeMap[volume.Name] = volume
+	}
+	definedVolumeMap := map[string]struct{}{}
+	for _, volume := range tmpl.Spec.Volumes {
+		definedVolumeMap[volume.Name] = struct{}{}
+	}
+	// mounts are identified by the volume name and the mount path.
+	definedMountMap := map[string]struct{}{}
+	for _, mount := range listingContainer.VolumeMounts {
+		definedMountMap[mount.Name+":"+mount.MountPath] = struct{}{}
+	}
+	for _, name := range source.InheritVolumes {
+		volume, exists := volumeMap[name]
+		if !exists {
+			return tmpl, fmt.Errorf("kubetest: failed to find volume %s to inherit from mainStep", name)
+		}
+		if _, exists := definedVolumeMap[name]; !exists {
+			tmpl.Spec.Volumes = append(tmpl.Spec.Volumes, *volume.DeepCopy())
+		}
+		for _, mount := range mainContainer.VolumeMounts {
+			if mount.Name != name {
+				continue
+			}
+			if _, exists := definedMountMap[mount.Name+":"+mount.MountPath]; exists {
+				continue
+			}
+			tmpl.Spec.Containers[listingContainerIdx].VolumeMounts = append(
+				tmpl.Spec.Containers[listingContainerIdx].VolumeMounts,
+				mount,
+			)
 		}
-		keys = append(keys, key)
 	}
-	LoggerFromContext(ctx).Info("found %d dynamic keys to start distributed task", len(keys))
-	return keys, nil
+	return tmpl, nil
 }
 
 func (s *TaskScheduler) sourceFilter(filter string) (*regexp.Regexp, error) {
@@ -283,9 +943,49 @@ func NewSubTaskScheduler(maxConcurrentNumPerPod int) *SubTaskScheduler {
 
 type SubTaskScheduler struct {
 	maxConcurrentNumPerPod int
+	allocation             *SubTaskAllocation
+	resourceBudget         corev1.ResourceList
+	resourceHints          map[string]corev1.ResourceList
+}
+
+// SetAllocation set the pool of values assigned to the subtasks running concurrently.
+func (s *SubTaskScheduler) SetAllocation(allocation *SubTaskAllocation) {
+	s.allocation = allocation
+}
+
+// SetResourceBudget set the total resources used by the subtasks running concurrently and the resources used by each subtask.
+// hints is looked up by the name of the subtask ( strategy key ).
+func (s *SubTaskScheduler) SetResourceBudget(budget corev1.ResourceList, hints map[string]corev1.ResourceList) {
+	s.resourceBudget = budget
+	s.resourceHints = hints
+}
+
+// AllocatedEnv returns the env value allocated to the subtask at idx in the taskNum subtasks.
+// The values are assigned to the slots of concurrentNum subtasks in turn, and Schedule runs at most concurrentNum contiguous subtasks at the same time,
+// so the same value is never assigned to subtasks running at the same time even if the last group is smaller than the others.
+// If allocation is not specified, returns false.
+func (s *SubTaskScheduler) AllocatedEnv(taskNum, idx int) (corev1.EnvVar, bool) {
+	if s.allocation == nil {
+		return corev1.EnvVar{}, false
+	}
+	concurrentNum := s.getConcurrentNum(taskNum)
+	if concurrentNum <= 0 {
+		return corev1.EnvVar{}, false
+	}
+	slot := idx % concurrentNum
+	var value string
+	if len(s.allocation.Values) != 0 {
+		value = s.allocation.Values[slot]
+	} else {
+		value = fmt.Sprint(s.allocation.StartPort + slot)
+	}
+	return corev1.EnvVar{Name: s.allocation.Env, Value: value}, true
 }
 
 func (s *SubTaskScheduler) Schedule(tasks []*SubTask) []*SubTaskGroup {
+	if len(s.resourceBudget) != 0 && len(s.resourceHints) != 0 {
+		return s.scheduleByResources(tasks)
+	}
 	concurrentNum := s.getConcurrentNum(len(tasks))
 	taskNum := len(tasks)
 	groups := []*SubTaskGroup{}
@@ -305,13 +1005,60 @@ func (s *SubTaskScheduler) Schedule(tasks []*SubTask) []*SubTaskGroup {
 	return groups
 }
 
+// scheduleByResources packs the subtasks in order into the groups
+// so that the sum of their resources doesn't exceed the budget and the number of them doesn't exceed concurrentNum.
+// Each group is a contiguous range of subtasks, so the values of AllocatedEnv are still unique in the group.
+func (s *SubTaskScheduler) scheduleByResources(tasks []*SubTask) []*SubTaskGroup {
+	concurrentNum := s.getConcurrentNum(len(tasks))
+	groups := []*SubTaskGroup{}
+	start := 0
+	used := corev1.ResourceList{}
+	for idx, task := range tasks {
+		hint := s.resourceHints[task.Name]
+		if idx > start && (idx-start >= concurrentNum || !s.fitsResourceBudget(used, hint)) {
+			groups = append(groups, NewSubTaskGroup(tasks[start:idx]))
+			start = idx
+			used = corev1.ResourceList{}
+		}
+		for name, quantity := range hint {
+			total := used[name]
+			total.Add(quantity)
+			used[name] = total
+		}
+	}
+	if start < len(tasks) {
+		groups = append(groups, NewSubTaskGroup(tasks[start:]))
+	}
+	return groups
+}
+
+// fitsResourceBudget returns true if the resources of hint can be added to used without exceeding the budget.
+// The resources not specified in the budget are not limited.
+func (s *SubTaskScheduler) fitsResourceBudget(used, hint corev1.ResourceList) bool {
+	for name, budget := range s.resourceBudget {
+		quantity, exists := hint[name]
+		if !exists {
+			continue
+		}
+		total := used[name]
+		total.Add(quantity)
+		if total.Cmp(budget) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// getConcurrentNum returns the number of subtasks running at the same time.
+// If the values of the allocation are fewer than it ( e.g. maxConcurrentNumPerPod isn't specified ),
+// the number is limited to the number of the values so that each running subtask has the distinct value.
 func (s *SubTaskScheduler) getConcurrentNum(taskNum int) int {
-	maxConcurrentNum := s.maxConcurrentNumPerPod
-	if maxConcurrentNum <= 0 {
-		return taskNum
+	concurrentNum := taskNum
+	if s.maxConcurrentNumPerPod > 0 && s.maxConcurrentNumPerPod < concurrentNum {
+		concurrentNum = s.maxConcurrentNumPerPod
 	}
-	if maxConcurrentNum > taskNum {
-		return taskNum
+	if s.allocation != nil && len(s.allocation.Values) != 0 && len(s.allocation.Values) < concurrentNum {
+		concurrentNum = len(s.allocation.Values)
 	}
-	return maxConcurrentNum
+	return concurrentNum
 }
@@ -1,16 +1,26 @@
 package v1
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
 )
 
 func staticSources(num int) []string {
@@ -127,6 +137,40 @@ func TestScheduler(t *testing.T) {
 			})
 		}
 	})
+	t.Run("PlannedTaskNum", func(t *testing.T) {
+		for _, scheduler := range []Scheduler{
+			{MaxContainersPerPod: 16, MaxConcurrentNumPerPod: 1},
+			{MaxPodNum: 4, MaxConcurrentNumPerPod: 1},
+		} {
+			for _, staticKeyNum := range []int{1, 3, 4, 16, 31, 32, 33} {
+				name := fmt.Sprintf(
+					"maxPodNum_%d_maxContainersPerPod_%d_keyNum_%d",
+					scheduler.MaxPodNum, scheduler.MaxContainersPerPod, staticKeyNum,
+				)
+				t.Run(name, func(t *testing.T) {
+					testjob := *baseTestJob.DeepCopy()
+					testjob.Spec.MainStep.Strategy.Scheduler = scheduler
+					testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+						Static: staticSources(staticKeyNum),
+					}
+					clientset, err := kubernetes.NewForConfig(getConfig())
+					if err != nil {
+						t.Fatal(err)
+					}
+					resourceMgr := NewResourceManager(clientset, testjob)
+					builder := NewTaskBuilder(getConfig(), resourceMgr, "default", RunModeDryRun)
+					taskScheduler := NewTaskScheduler(testjob.Spec.MainStep)
+					taskGroup, err := taskScheduler.Schedule(ctx, builder)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if taskScheduler.plannedTaskNum(staticKeyNum) != taskGroup.TaskNum() {
+						t.Fatalf("failed to plan task num: expected %d but got %d", taskGroup.TaskNum(), taskScheduler.plannedTaskNum(staticKeyNum))
+					}
+				})
+			}
+		}
+	})
 	t.Run("ScheduleSubTask", func(t *testing.T) {
 		for _, test := range []struct {
 			maxConcurrentNumPerPod int
@@ -163,4 +207,1338 @@ func TestScheduler(t *testing.T) {
 			})
 		}
 	})
+	t.Run("ScheduleSubTaskByResources", func(t *testing.T) {
+		hints := map[string]corev1.ResourceList{
+			"heavy":  {corev1.ResourceCPU: resource.MustParse("3")},
+			"medium": {corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("2Gi")},
+			"light":  {corev1.ResourceCPU: resource.MustParse("500m")},
+			"huge":   {corev1.ResourceCPU: resource.MustParse("8")},
+		}
+		budget := corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("4"),
+			corev1.ResourceMemory: resource.MustParse("3Gi"),
+		}
+		for _, test := range []struct {
+			name           string
+			keys           []string
+			budget         corev1.ResourceList
+			expectedGroups [][]string
+		}{
+			{
+				name:           "pack within budget",
+				keys:           []string{"heavy", "light", "medium", "medium", "light", "light"},
+				budget:         budget,
+				expectedGroups: [][]string{{"heavy", "light"}, {"medium"}, {"medium", "light", "light"}},
+			},
+			{
+				name:           "run alone if over budget",
+				keys:           []string{"light", "huge", "light"},
+				budget:         budget,
+				expectedGroups: [][]string{{"light"}, {"huge"}, {"light"}},
+			},
+			{
+				name:           "keys without hint are limited by count",
+				keys:           []string{"a", "b", "c", "d"},
+				budget:         budget,
+				expectedGroups: [][]string{{"a", "b", "c"}, {"d"}},
+			},
+			{
+				name:           "count based without budget",
+				keys:           []string{"heavy", "heavy", "heavy", "heavy"},
+				expectedGroups: [][]string{{"heavy", "heavy", "heavy"}, {"heavy"}},
+			},
+		} {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				scheduler := NewSubTaskScheduler(3)
+				scheduler.SetResourceBudget(test.budget, hints)
+				subtasks := make([]*SubTask, 0, len(test.keys))
+				for _, key := range test.keys {
+					subtasks = append(subtasks, &SubTask{Name: key})
+				}
+				groups := [][]string{}
+				for _, group := range scheduler.Schedule(subtasks) {
+					names := []string{}
+					for _, task := range group.tasks {
+						names = append(names, task.Name)
+					}
+					groups = append(groups, names)
+				}
+				if !reflect.DeepEqual(groups, test.expectedGroups) {
+					t.Fatalf("failed to schedule subtask by resources: expected %v but got %v", test.expectedGroups, groups)
+				}
+			})
+		}
+	})
+	t.Run("AllocateSubTaskEnv", func(t *testing.T) {
+		for _, allocation := range []*SubTaskAllocation{
+			{Env: "TEST_PORT", StartPort: 8000},
+			{Env: "TEST_DB", Values: []string{"db0", "db1", "db2"}},
+		} {
+			for _, test := range []struct {
+				maxConcurrentNum int
+				taskNum          int
+				resources        bool
+			}{
+				{maxConcurrentNum: 3, taskNum: 1},
+				{maxConcurrentNum: 3, taskNum: 3},
+				{maxConcurrentNum: 3, taskNum: 7},
+				// the groups packed by the resources start in the middle of the slots.
+				{maxConcurrentNum: 3, taskNum: 7, resources: true},
+				// the tests run concurrently up to the number of the values.
+				{maxConcurrentNum: 0, taskNum: 7},
+				{maxConcurrentNum: 0, taskNum: 8, resources: true},
+			} {
+				test := test
+				name := fmt.Sprintf("%s_max_%d_taskNum_%d_resources_%t", allocation.Env, test.maxConcurrentNum, test.taskNum, test.resources)
+				t.Run(name, func(t *testing.T) {
+					taskNum := test.taskNum
+					scheduler := NewSubTaskScheduler(test.maxConcurrentNum)
+					scheduler.SetAllocation(allocation)
+					if test.resources {
+						scheduler.SetResourceBudget(
+							corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+							map[string]corev1.ResourceList{
+								"0": {corev1.ResourceCPU: resource.MustParse("3")},
+								"4": {corev1.ResourceCPU: resource.MustParse("3")},
+							},
+						)
+					}
+					subtasks := make([]*SubTask, taskNum)
+					values := map[*SubTask]string{}
+					for i := 0; i < taskNum; i++ {
+						subtasks[i] = &SubTask{Name: fmt.Sprint(i)}
+						env, exists := scheduler.AllocatedEnv(taskNum, i)
+						if !exists {
+							t.Fatal("failed to allocate env")
+						}
+						if env.Name != allocation.Env {
+							t.Fatalf("failed to get env name: expected %s but got %s", allocation.Env, env.Name)
+						}
+						values[subtasks[i]] = env.Value
+					}
+					groups := scheduler.Schedule(subtasks)
+					if allocation.Values != nil && test.maxConcurrentNum == 0 && taskNum > len(allocation.Values) && len(groups) < 2 {
+						t.Fatalf("the tests must not run concurrently more than the number of the values: %d groups", len(groups))
+					}
+					for _, group := range groups {
+						used := map[string]struct{}{}
+						for _, task := range group.tasks {
+							value := values[task]
+							if _, exists := used[value]; exists {
+								t.Fatalf("found duplicated value %s in concurrent subtasks", value)
+							}
+							used[value] = struct{}{}
+						}
+					}
+				})
+			}
+		}
+		if _, exists := NewSubTaskScheduler(3).AllocatedEnv(3, 0); exists {
+			t.Fatal("unexpected allocated env")
+		}
+	})
+	t.Run("DynamicKeysRetries", func(t *testing.T) {
+		defaultInterval := dynamicKeysRetryInterval
+		dynamicKeysRetryInterval = time.Millisecond
+		defer func() { dynamicKeysRetryInterval = defaultInterval }()
+
+		// the listing command fails at the first time and succeeds after that.
+		marker := filepath.Join(t.TempDir(), "marker")
+		source := &StrategyDynamicKeySource{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{{
+						Container: corev1.Container{
+							Name:    "list",
+							Image:   "alpine",
+							Command: []string{"sh", "-c"},
+							Args:    []string{fmt.Sprintf("if [ -f %[1]s ]; then echo TestA; else touch %[1]s; echo temporary error; exit 1; fi", marker)},
+						},
+					}},
+				},
+			},
+		}
+		testjob := *baseTestJob.DeepCopy()
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+		if _, err := scheduler.dynamicKeys(ctx, builder, source); err == nil || !strings.Contains(err.Error(), "temporary error") {
+			t.Fatalf("expected error with the output of listing command but got %v", err)
+		}
+		if err := os.Remove(marker); err != nil {
+			t.Fatal(err)
+		}
+		source.Retries = 1
+		keys, err := scheduler.dynamicKeys(ctx, builder, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 || keys[0] != "TestA" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+
+		// the interval is capped, and the retries stop when the total wait would exceed the limit.
+		defaultMaxInterval, defaultMaxWait := dynamicKeysMaxRetryInterval, dynamicKeysMaxRetryWait
+		dynamicKeysMaxRetryInterval, dynamicKeysMaxRetryWait = 2*time.Millisecond, 5*time.Millisecond
+		defer func() { dynamicKeysMaxRetryInterval, dynamicKeysMaxRetryWait = defaultMaxInterval, defaultMaxWait }()
+		runs := filepath.Join(t.TempDir(), "runs")
+		source.Template.Spec.Containers[0].Args = []string{fmt.Sprintf("echo run >> %s; exit 1", runs)}
+		source.Retries = 100
+		if _, err := scheduler.dynamicKeys(ctx, builder, source); err == nil || !strings.Contains(err.Error(), "gave up retrying") {
+			t.Fatalf("expected error of giving up the retries but got %v", err)
+		}
+		out, err := os.ReadFile(runs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// waits 1ms, 2ms and 2ms, and the next wait exceeds 5ms.
+		if n := strings.Count(string(out), "run"); n != 4 {
+			t.Fatalf("expected 4 runs but got %d", n)
+		}
+	})
+	t.Run("DynamicKeysMetadata", func(t *testing.T) {
+		source := &StrategyDynamicKeySource{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{{
+						Container: corev1.Container{
+							Name:    "list",
+							Image:   "alpine",
+							Command: []string{"sh", "-c"},
+							Args:    []string{`echo '{"name":"TestA","metadata":{"owner":"team-a"}}'; echo '{"name":"TestB"}'; echo '{"name":"TestC","meta":{"owner":"team-c","team":"payments"},"metadata":{"owner":"team-b"}}'`},
+						},
+					}},
+				},
+			},
+			Format: KeyFormatJSON,
+		}
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Dynamic: source}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+		keys, err := scheduler.dynamicKeys(ctx, builder, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(keys, ",") != "TestA,TestB,TestC" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		builder = NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+		taskGroup, err := scheduler.scheduleKeys(ctx, builder, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range taskGroup.tasks {
+			if task.strategyKey.Metadata["TestA"]["owner"] != "team-a" || task.strategyKey.Metadata["TestB"] != nil {
+				t.Fatalf("unexpected metadata: %v", task.strategyKey.Metadata)
+			}
+			if metadata := task.strategyKey.Metadata["TestC"]; metadata["owner"] != "team-b" || metadata["team"] != "payments" {
+				t.Fatalf("unexpected metadata of the key with meta: %v", metadata)
+			}
+		}
+		report := &Report{Details: []*ReportDetail{
+			{Name: "TestA", Metadata: scheduler.keyMetadata["TestA"]},
+			{Name: "TestB"},
+		}}
+		groups := report.GroupDetailsByMetadata("owner")
+		if len(groups["team-a"]) != 1 || len(groups[""]) != 1 {
+			t.Fatalf("unexpected groups: %v", groups)
+		}
+	})
+	t.Run("KeyImages", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Static: []string{"1.21", "1.22", "1.23"}}
+		testjob.Spec.MainStep.Strategy.Key.Images = map[string]string{"1.21": "golang:1.21", "1.22": "golang:1.22"}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images := []string{}
+		for _, task := range taskGroup.tasks {
+			for _, container := range task.job.(*dryRunJob).job.Spec.Template.Spec.Containers {
+				images = append(images, container.Image)
+			}
+		}
+		if strings.Join(images, ",") != "golang:1.21,golang:1.22,alpine" {
+			t.Fatalf("unexpected images: %v", images)
+		}
+	})
+	t.Run("Smoke", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Static: []string{"TestA", "TestSmokeB", "TestC", "TestD"}}
+		testjob.Spec.MainStep.Strategy.Smoke = &StrategySmokeSpec{Keys: []string{"TestD"}, Pattern: "Smoke"}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+		scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+		groupKeys := func(taskGroup *TaskGroup) string {
+			keys := []string{}
+			for _, task := range taskGroup.tasks {
+				keys = append(keys, task.strategyKey.Keys...)
+			}
+			return strings.Join(keys, ",")
+		}
+		smokeGroup, err := scheduler.ScheduleSmoke(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := groupKeys(smokeGroup); keys != "TestSmokeB,TestD" {
+			t.Fatalf("unexpected smoke keys: %s", keys)
+		}
+		taskGroup, err := scheduler.Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := groupKeys(taskGroup); keys != "TestA,TestC" {
+			t.Fatalf("unexpected keys: %s", keys)
+		}
+	})
+	t.Run("After", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Static: []string{"TestC", "TestA", "TestB", "TestD"}}
+		testjob.Spec.MainStep.Strategy.Key.After = map[string][]string{
+			"TestC": {"TestB"},
+			"TestB": {"TestA", "TestUnknown"},
+		}
+		if err := NewValidator().ValidateStrategyKeySpec(testjob.Spec.MainStep.Strategy.Key); err != nil {
+			t.Fatal(err)
+		}
+		order := filepath.Join(t.TempDir(), "order")
+		testjob.Spec.MainStep.Template.Spec.Containers[0].Args = []string{fmt.Sprintf("echo $TEST >> %s", order)}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stages := []string{}
+		for group := taskGroup; group != nil; group = group.next {
+			keys := []string{}
+			for _, task := range group.tasks {
+				keys = append(keys, task.strategyKey.Keys...)
+			}
+			stages = append(stages, strings.Join(keys, ","))
+		}
+		if strings.Join(stages, " | ") != "TestA,TestD | TestB | TestC" {
+			t.Fatalf("unexpected stages: %v", stages)
+		}
+		if taskGroup.TaskNum() != 3 {
+			t.Fatalf("unexpected number of tasks: %d", taskGroup.TaskNum())
+		}
+		result, err := taskGroup.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.TotalNum() != 4 || result.Status() != ResultStatusSuccess {
+			t.Fatalf("unexpected result: total %d status %s", result.TotalNum(), result.Status())
+		}
+		content, err := os.ReadFile(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != 4 || lines[2] != "TestB" || lines[3] != "TestC" {
+			t.Fatalf("unexpected order: %q", lines)
+		}
+
+		testjob.Spec.MainStep.Strategy.Key.After["TestA"] = []string{"TestC"}
+		err = NewValidator().ValidateStrategyKeySpec(testjob.Spec.MainStep.Strategy.Key)
+		if err == nil || !strings.Contains(err.Error(), "TestA -> TestC -> TestB -> TestA") {
+			t.Fatalf("expected error for the cycle but got %v", err)
+		}
+		if _, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder); err == nil || !strings.Contains(err.Error(), "cycle") {
+			t.Fatalf("expected error for the cycle but got %v", err)
+		}
+	})
+	t.Run("ListCommand", func(t *testing.T) {
+		source := &StrategyDynamicKeySource{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{{
+						Container: corev1.Container{
+							Name:    "list",
+							Image:   "alpine",
+							Command: []string{"sh", "-c"},
+							Args:    []string{"echo TestA"},
+						},
+					}},
+				},
+			},
+		}
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Dynamic: source}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+		scheduler.SetListCommand([]string{"printf", "TestB\nTestC\n"})
+		keys, err := scheduler.strategyKeys(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(keys, ",") != "TestB,TestC" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		container := source.Template.Spec.Containers[0]
+		if strings.Join(container.Command, " ") != "sh -c" || strings.Join(container.Args, " ") != "echo TestA" {
+			t.Fatalf("the spec must not be changed: %v %v", container.Command, container.Args)
+		}
+
+		second := *source.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source.Dynamics = []StrategyDynamicKeySource{second}
+		scheduler = NewTaskScheduler(testjob.Spec.MainStep)
+		scheduler.SetListCommand([]string{"echo", "TestB"})
+		if _, err := scheduler.strategyKeys(ctx, builder); err == nil || !strings.Contains(err.Error(), "single dynamic key source") {
+			t.Fatalf("expected error for multiple dynamic key sources but got %v", err)
+		}
+	})
+	t.Run("ShardSummary", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Static: []string{"A", "B", "C"}}
+		testjob.Spec.MainStep.Strategy.Scheduler.MaxContainersPerPod = 2
+		testjob.Spec.MainStep.Template.Spec.Containers[0].Args = []string{`test "$TEST" != "B"`}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		ctx := WithLogger(context.Background(), NewLogger(&b, LogLevelInfo))
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		builder.SetShardSummary(true)
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := taskGroup.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		out := b.String()
+		for _, expected := range []string{
+			"shard summary: shard=0 tests=2 passed=1 failed=1 duration=",
+			"shard summary: shard=1 tests=1 passed=1 failed=0 duration=",
+		} {
+			if !strings.Contains(out, expected) {
+				t.Fatalf("failed to find %q in the log: %s", expected, out)
+			}
+		}
+	})
+	t.Run("TestListProcessor", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Static: []string{"A", "B", "C", "B"}}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+		scheduler.SetTestListProcessor(func(keys []string) ([]string, error) {
+			processed := []string{}
+			seen := map[string]struct{}{}
+			for idx := len(keys) - 1; idx >= 0; idx-- {
+				if _, exists := seen[keys[idx]]; exists || keys[idx] == "A" {
+					continue
+				}
+				seen[keys[idx]] = struct{}{}
+				processed = append(processed, keys[idx])
+			}
+			return processed, nil
+		})
+		keys, err := scheduler.strategyKeys(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(keys, ",") != "B,C" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		scheduler = NewTaskScheduler(testjob.Spec.MainStep)
+		processedNum := 0
+		scheduler.SetTestListProcessor(func([]string) ([]string, error) {
+			processedNum++
+			return nil, nil
+		})
+		for i := 0; i < 2; i++ {
+			keys, err := scheduler.strategyKeys(ctx, builder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 0 {
+				t.Fatalf("unexpected keys: %v", keys)
+			}
+		}
+		if processedNum != 1 {
+			t.Fatalf("the nil result of the processor must be reused but the processor ran %d times", processedNum)
+		}
+		scheduler = NewTaskScheduler(testjob.Spec.MainStep)
+		scheduler.SetTestListProcessor(func([]string) ([]string, error) {
+			return nil, fmt.Errorf("unknown test")
+		})
+		if _, err := scheduler.Schedule(ctx, builder); err == nil || !strings.Contains(err.Error(), "unknown test") {
+			t.Fatalf("expected error of the processor but got %v", err)
+		}
+	})
+	t.Run("UnionKeys", func(t *testing.T) {
+		source := StrategyKeySource{
+			Static: []string{"TestB", "TestC"},
+			Dynamic: &StrategyDynamicKeySource{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{{
+							Container: corev1.Container{
+								Name:    "list",
+								Image:   "alpine",
+								Command: []string{"sh", "-c"},
+								Args:    []string{"echo TestA; echo TestB"},
+							},
+						}},
+					},
+				},
+			},
+		}
+		if err := NewValidator().ValidateStrategyKeySource(source); err == nil {
+			t.Fatal("expected error for both static and dynamic keys without union")
+		}
+		source.Union = true
+		if err := NewValidator().ValidateStrategyKeySource(source); err != nil {
+			t.Fatal(err)
+		}
+		testjob := *baseTestJob.DeepCopy()
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		keys, err := NewTaskScheduler(testjob.Spec.MainStep).getScheduleKeys(ctx, builder, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(keys, ",") != "TestB,TestC,TestA" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+
+		testjob.Spec.MainStep.Strategy.Key.Source = source
+		testjob.Spec.MainStep.Strategy.Key.Duplicates = DuplicateKeyPolicySuffix
+		keys, err = NewTaskScheduler(testjob.Spec.MainStep).strategyKeys(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(keys, ",") != "TestB,TestC,TestA,TestB#1" {
+			t.Fatalf("the key found by both sources must be renamed by suffix: %v", keys)
+		}
+		testjob.Spec.MainStep.Strategy.Key.Duplicates = DuplicateKeyPolicyError
+		if _, err := NewTaskScheduler(testjob.Spec.MainStep).strategyKeys(ctx, builder); err == nil || !strings.Contains(err.Error(), "TestB") {
+			t.Fatalf("expected error of the duplicated key but got %v", err)
+		}
+	})
+	t.Run("MultipleDynamicSources", func(t *testing.T) {
+		listSource := func(command, delim, filter string) StrategyDynamicKeySource {
+			return StrategyDynamicKeySource{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{{
+							Container: corev1.Container{
+								Name:    "list",
+								Image:   "alpine",
+								Command: []string{"sh", "-c"},
+								Args:    []string{command},
+							},
+						}},
+					},
+				},
+				Delim:  delim,
+				Filter: filter,
+			}
+		}
+		first := listSource("echo TestA; echo TestB", "", "")
+		source := StrategyKeySource{
+			Static:  []string{"TestB"},
+			Dynamic: &first,
+			Dynamics: []StrategyDynamicKeySource{
+				listSource("echo -n TestC,TestA,BenchmarkD", ",", "^Test"),
+				listSource("sleep 0.1; echo TestE", "", ""),
+			},
+		}
+		if err := NewValidator().ValidateStrategyKeySource(source); err != nil {
+			t.Fatal(err)
+		}
+		testjob := *baseTestJob.DeepCopy()
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		keys, err := NewTaskScheduler(testjob.Spec.MainStep).getScheduleKeys(ctx, builder, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(keys, ",") != "TestB,TestA,TestC,TestE" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+
+		// the source waiting to retry is canceled by the failure of the other source.
+		defaultInterval := dynamicKeysRetryInterval
+		dynamicKeysRetryInterval = time.Minute
+		defer func() { dynamicKeysRetryInterval = defaultInterval }()
+		failed := listSource("exit 1", "", "")
+		retried := listSource("sleep 0.1; exit 1", "", "")
+		retried.Retries = 3
+		source = StrategyKeySource{Dynamic: &failed, Dynamics: []StrategyDynamicKeySource{retried}}
+		done := make(chan error, 1)
+		go func() {
+			_, err := NewTaskScheduler(testjob.Spec.MainStep).getScheduleKeys(ctx, builder, source)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Fatal("expected error of the failed source")
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("the other sources must be canceled if one of them fails")
+		}
+	})
+	t.Run("InheritVolumes", func(t *testing.T) {
+		step := MainStep{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{
+						{
+							Container: corev1.Container{
+								Name: "test",
+								VolumeMounts: []corev1.VolumeMount{
+									{Name: "repo", MountPath: "/work"},
+									{Name: "token", MountPath: "/token"},
+								},
+							},
+						},
+					},
+					Volumes: []TestJobVolume{
+						{Name: "repo", TestJobVolumeSource: TestJobVolumeSource{Repo: &RepositoryVolumeSource{Name: "repo"}}},
+						{Name: "token", TestJobVolumeSource: TestJobVolumeSource{Token: &TokenVolumeSource{Name: "token"}}},
+					},
+				},
+			},
+		}
+		source := &StrategyDynamicKeySource{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{{Container: corev1.Container{Name: "list"}}},
+				},
+			},
+			InheritVolumes: []string{"repo"},
+		}
+		if err := NewValidator().ValidateInheritVolumes(source.InheritVolumes, step.Template); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewValidator().ValidateInheritVolumes([]string{"unknown"}, step.Template); err == nil {
+			t.Fatal("expected error for undefined volume")
+		}
+		tmpl, err := NewTaskScheduler(step).listingTemplate(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tmpl.Spec.Volumes) != 1 || tmpl.Spec.Volumes[0].Name != "repo" {
+			t.Fatalf("failed to inherit volumes: %v", tmpl.Spec.Volumes)
+		}
+		mounts := tmpl.Spec.Containers[0].VolumeMounts
+		if len(mounts) != 1 || mounts[0].MountPath != "/work" {
+			t.Fatalf("failed to inherit volume mounts: %v", mounts)
+		}
+		if len(source.Template.Spec.Volumes) != 0 {
+			t.Fatal("source template must not be modified")
+		}
+	})
+	t.Run("PriorityClassName", func(t *testing.T) {
+		step := MainStep{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					PodSpec:                    corev1.PodSpec{PriorityClassName: "batch"},
+					Containers:                 []TestJobContainer{{Container: corev1.Container{Name: "test", Image: "alpine"}}},
+					FinalizerContainer:         TestJobContainer{Container: corev1.Container{Name: "finalizer", Image: "alpine"}},
+					FinalizerPriorityClassName: "critical",
+				},
+			},
+		}
+		source := &StrategyDynamicKeySource{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{{Container: corev1.Container{Name: "list", Image: "alpine", Command: []string{"go", "test", "-list", "."}}}},
+				},
+			},
+		}
+		scheduler := NewTaskScheduler(step)
+		tmpl, err := scheduler.listingTemplate(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tmpl.Spec.PriorityClassName != "batch" {
+			t.Fatalf("expected priorityClassName of mainStep but got %q", tmpl.Spec.PriorityClassName)
+		}
+		source.PriorityClassName = "listing"
+		tmpl, err = scheduler.listingTemplate(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tmpl.Spec.PriorityClassName != "listing" {
+			t.Fatalf("expected priorityClassName of source but got %q", tmpl.Spec.PriorityClassName)
+		}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep = step
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+		task, err := builder.Build(ctx, &step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := task.job.(*dryRunJob).job.Spec.Template.Spec.PriorityClassName; name != "critical" {
+			t.Fatalf("expected priorityClassName for finalizer but got %q", name)
+		}
+		source.PendingTimeout = "1m"
+		if err := NewValidator().ValidateStrategyDynamicKeySource(source); err != nil {
+			t.Fatal(err)
+		}
+		source.PendingTimeout = "-1m"
+		if err := NewValidator().ValidateStrategyDynamicKeySource(source); err == nil {
+			t.Fatal("expected error for negative pendingTimeout")
+		}
+	})
+	t.Run("DuplicateKeys", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Static: []string{"A", "B", "A", "A#1", "A"}}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		scheduledKeys := func(policy DuplicateKeyPolicy) ([]string, error) {
+			step := *testjob.Spec.MainStep.DeepCopy()
+			step.Strategy.Key.Duplicates = policy
+			builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+			taskGroup, err := NewTaskScheduler(step).Schedule(ctx, builder)
+			if err != nil {
+				return nil, err
+			}
+			keys := []string{}
+			for _, task := range taskGroup.tasks {
+				keys = append(keys, task.strategyKey.Keys...)
+			}
+			return keys, nil
+		}
+		keys, err := scheduledKeys(DuplicateKeyPolicyAllow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []string{"A", "B", "A", "A#1", "A"}; !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		if _, err := scheduledKeys(DuplicateKeyPolicyError); err == nil || !strings.Contains(err.Error(), "duplicated keys: A") {
+			t.Fatalf("expected error for duplicated keys but got %v", err)
+		}
+		keys, err = scheduledKeys(DuplicateKeyPolicySuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []string{"A", "B", "A#2", "A#1", "A#3"}; !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		step := *testjob.Spec.MainStep.DeepCopy()
+		step.Strategy.Key.Duplicates = DuplicateKeyPolicySuffix
+		step.Strategy.Key.Images = map[string]string{"A": "golang:1.22"}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+		taskGroup, err := NewTaskScheduler(step).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		containers := []string{}
+		for _, task := range taskGroup.tasks {
+			for _, container := range task.job.(*dryRunJob).job.Spec.Template.Spec.Containers {
+				if !task.hasKeyEnv(container) {
+					continue
+				}
+				var value string
+				for _, env := range container.Env {
+					if env.Name == step.Strategy.Key.Env {
+						value = env.Value
+					}
+				}
+				containers = append(containers, fmt.Sprintf("%s:%s:%s", task.getKeyName(container), value, container.Image))
+			}
+		}
+		if expected := []string{
+			"A:A:golang:1.22", "B:B:alpine", "A#2:A:golang:1.22", "A#1:A#1:alpine", "A#3:A:golang:1.22",
+		}; !reflect.DeepEqual(containers, expected) {
+			t.Fatalf("the renamed keys must run the original key: %v", containers)
+		}
+		if err := NewValidator().ValidateStrategyKeySpec(StrategyKeySpec{
+			Env:        "TEST",
+			Source:     StrategyKeySource{Static: []string{"A"}},
+			Duplicates: "unknown",
+		}); err == nil {
+			t.Fatal("expected error for unknown duplicates policy")
+		}
+	})
+	t.Run("ShuffleKeys", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		sources := staticSources(20)
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{Static: sources}
+		testjob.Spec.MainStep.Strategy.Scheduler.ShuffleKeys = true
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		scheduledKeys := func(step MainStep) ([]string, int64) {
+			builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+			scheduler := NewTaskScheduler(step)
+			taskGroup, err := scheduler.Schedule(ctx, builder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := []string{}
+			for _, task := range taskGroup.tasks {
+				keys = append(keys, task.strategyKey.Keys...)
+			}
+			seed, shuffled := scheduler.ShuffleSeed()
+			if !shuffled {
+				t.Fatal("failed to get shuffle seed")
+			}
+			return keys, seed
+		}
+		seed := int64(42)
+		step := *testjob.Spec.MainStep.DeepCopy()
+		step.Strategy.Scheduler.ShuffleSeed = &seed
+		keys, usedSeed := scheduledKeys(step)
+		if usedSeed != seed {
+			t.Fatalf("unexpected seed: %d", usedSeed)
+		}
+		if reflect.DeepEqual(keys, sources) {
+			t.Fatal("failed to shuffle keys")
+		}
+		if again, _ := scheduledKeys(step); !reflect.DeepEqual(keys, again) {
+			t.Fatalf("the same seed must produce the same order: %v and %v", keys, again)
+		}
+		sorted := append([]string{}, keys...)
+		sort.Strings(sorted)
+		if !reflect.DeepEqual(sorted, sources) {
+			t.Fatalf("shuffled keys must have the same keys: %v", keys)
+		}
+
+		// reproduce the order of the run without seed by the recorded seed.
+		randomKeys, randomSeed := scheduledKeys(testjob.Spec.MainStep)
+		step.Strategy.Scheduler.ShuffleSeed = &randomSeed
+		if reproduced, _ := scheduledKeys(step); !reflect.DeepEqual(randomKeys, reproduced) {
+			t.Fatalf("failed to reproduce the order by the recorded seed: %v and %v", randomKeys, reproduced)
+		}
+
+		if err := NewValidator().ValidateScheduler(Scheduler{MaxPodNum: 1, MaxConcurrentNumPerPod: 1, ShuffleSeed: &seed}); err == nil {
+			t.Fatal("expected error for shuffleSeed without shuffleKeys")
+		}
+		if err := NewValidator().ValidateScheduler(Scheduler{MaxPodNum: 1, MaxConcurrentNumPerPod: 1, SubTaskStartJitter: "-1s"}); err == nil {
+			t.Fatal("expected error for negative subTaskStartJitter")
+		}
+	})
+	t.Run("ManifestDir", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Static: staticSources(3),
+		}
+		testjob.Spec.MainStep.Strategy.Scheduler.MaxContainersPerPod = 2
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := filepath.Join(t.TempDir(), "manifests")
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+		builder.SetRunID("run")
+		builder.SetManifestDir(dir)
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]struct{}{}
+		for _, entry := range entries {
+			names[entry.Name()] = struct{}{}
+		}
+		if len(names) != taskGroup.TaskNum() {
+			t.Fatalf("expected a manifest for each task but got %v", names)
+		}
+		for idx, task := range taskGroup.tasks {
+			var expected string
+			for name := range names {
+				if strings.HasPrefix(name, "run-") && strings.HasSuffix(name, fmt.Sprintf("-mainStep-shard-%d.yaml", idx)) {
+					expected = name
+				}
+			}
+			if expected == "" {
+				t.Fatalf("failed to find manifest of shard %d: %v", idx, names)
+			}
+			b, err := os.ReadFile(filepath.Join(dir, expected))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var job batchv1.Job
+			if err := yaml.Unmarshal(b, &job); err != nil {
+				t.Fatal(err)
+			}
+			if job.Kind != "Job" || job.APIVersion != "batch/v1" {
+				t.Fatalf("unexpected type of manifest: %s %s", job.APIVersion, job.Kind)
+			}
+			if !equality.Semantic.DeepEqual(job.Spec, task.job.(*dryRunJob).job.Spec) {
+				t.Fatalf("manifest is different from the submitted job: %s", b)
+			}
+		}
+	})
+	t.Run("AntiAffinity", func(t *testing.T) {
+		for _, required := range []bool{false, true} {
+			testjob := *baseTestJob.DeepCopy()
+			testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+				Static: staticSources(3),
+			}
+			testjob.Spec.MainStep.Strategy.Scheduler.AntiAffinity = &ShardAntiAffinity{Required: required}
+			clientset, err := kubernetes.NewForConfig(getConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resourceMgr := NewResourceManager(clientset, testjob)
+			builder := NewTaskBuilder(getConfig(), resourceMgr, "default", RunModeDryRun)
+			builder.SetRunID("run")
+			taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, task := range taskGroup.tasks {
+				job := task.job.(*dryRunJob).job
+				if job.Labels[runIDLabel] != "run" {
+					t.Fatalf("failed to set run id label to job: %v", job.Labels)
+				}
+				tmpl := job.Spec.Template
+				if tmpl.Labels[runIDLabel] != "run" {
+					t.Fatalf("failed to set run id label: %v", tmpl.Labels)
+				}
+				antiAffinity := tmpl.Spec.Affinity.PodAntiAffinity
+				var terms []corev1.PodAffinityTerm
+				if required {
+					terms = antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+				} else {
+					for _, term := range antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+						terms = append(terms, term.PodAffinityTerm)
+					}
+				}
+				if len(terms) != 1 {
+					t.Fatalf("failed to set anti affinity: %+v", antiAffinity)
+				}
+				if terms[0].TopologyKey != defaultAntiAffinityTopologyKey || terms[0].LabelSelector.MatchLabels[runIDLabel] != "run" {
+					t.Fatalf("unexpected anti affinity term: %+v", terms[0])
+				}
+			}
+		}
+	})
+	t.Run("TestAnnotations", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Static: []string{"TestA", "TestB", "TestC"},
+		}
+		testjob.Spec.MainStep.Strategy.Scheduler.MaxContainersPerPod = 2
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resourceMgr := NewResourceManager(clientset, testjob)
+		builder := NewTaskBuilder(getConfig(), resourceMgr, "default", RunModeDryRun)
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []map[string]string{
+			{shardAnnotation: "0", testsAnnotation: "TestA,TestB", testCountAnnotation: "2"},
+			{shardAnnotation: "1", testsAnnotation: "TestC", testCountAnnotation: "1"},
+		}
+		if len(taskGroup.tasks) != len(expected) {
+			t.Fatalf("unexpected number of tasks: %d", len(taskGroup.tasks))
+		}
+		for idx, task := range taskGroup.tasks {
+			annotations := task.job.(*dryRunJob).job.Spec.Template.Annotations
+			for key, value := range expected[idx] {
+				if annotations[key] != value {
+					t.Fatalf("expected %s annotation of shard %d is %q but got %q", key, idx, value, annotations[key])
+				}
+			}
+		}
+		for _, test := range []struct {
+			names    []string
+			limit    int
+			expected string
+		}{
+			{names: []string{"TestA", "TestB", "TestC"}, limit: 100, expected: "TestA,TestB,TestC"},
+			{names: []string{"TestA", "TestB", "TestC"}, limit: 24, expected: "TestA,... ( 2 more )"},
+			{names: []string{"TestLongName"}, limit: 8, expected: "... ( 1 more )"},
+			{names: nil, limit: 8, expected: ""},
+		} {
+			if got := testsSummary(test.names, test.limit); got != test.expected {
+				t.Errorf("expected summary of %v is %q but got %q", test.names, test.expected, got)
+			}
+		}
+	})
+	t.Run("Namespaces", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Static: staticSources(3),
+		}
+		testjob.Spec.MainStep.Strategy.Scheduler.MaxContainersPerPod = 1
+		testjob.Spec.MainStep.Strategy.Scheduler.Namespaces = []string{"ns-a", "ns-b"}
+		testjob.Spec.MainStep.Strategy.Scheduler.AntiAffinity = &ShardAntiAffinity{}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+		builder.SetRunID("run")
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		namespaces := []string{}
+		for _, task := range taskGroup.tasks {
+			job := task.job.(*dryRunJob).job
+			namespaces = append(namespaces, job.Namespace)
+			terms := job.Spec.Template.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			if len(terms) != 1 || strings.Join(terms[0].PodAffinityTerm.Namespaces, ",") != "ns-a,ns-b" {
+				t.Fatalf("anti affinity must be applied across namespaces: %+v", terms)
+			}
+		}
+		if expected := "ns-a,ns-b,ns-a"; strings.Join(namespaces, ",") != expected {
+			t.Fatalf("expected shards are created in %s but got %v", expected, namespaces)
+		}
+
+		testjob.Spec.MainStep.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+		fakeClientset := fake.NewSimpleClientset(
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-a", Name: "registry"}},
+		)
+		err = checkShardNamespaces(ctx, fakeClientset, testjob)
+		if err == nil || !strings.Contains(err.Error(), "ns-b/registry") || strings.Contains(err.Error(), "ns-a/registry") {
+			t.Fatalf("expected error for the missing secret but got %v", err)
+		}
+		if _, err := fakeClientset.CoreV1().Secrets("ns-b").Create(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-b", Name: "registry"}}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkShardNamespaces(ctx, fakeClientset, testjob); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("delete Jobs of shards in other namespaces", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelInfo))
+		jobs := []ReportObject{
+			{Kind: "Job", Namespace: "default", Name: "test-0"},
+			{Kind: "Job", Namespace: "ns-a", Name: "test-1"},
+			{Kind: "Pod", Namespace: "ns-a", Name: "test-1-pod"},
+			{Kind: "Job", Namespace: "ns-b", Name: "test-2"},
+		}
+		fakeClientset := fake.NewSimpleClientset()
+		recorder := NewObjectRecorder()
+		for _, obj := range jobs {
+			recorder.Record(obj)
+			if obj.Kind != "Job" {
+				continue
+			}
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: obj.Namespace, Name: obj.Name}}
+			if _, err := fakeClientset.BatchV1().Jobs(obj.Namespace).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the Job kept by keepResources remains until the retention elapses.
+		recorder.keep(jobs[3:], time.Now())
+		runner := NewRunner(getConfig(), RunModeKubernetes)
+		runner.deleteShardJobs(ctx, fakeClientset, "default", recorder)
+		for _, obj := range []ReportObject{jobs[0], jobs[3]} {
+			if _, err := fakeClientset.BatchV1().Jobs(obj.Namespace).Get(ctx, obj.Name, metav1.GetOptions{}); err != nil {
+				t.Fatalf("Job %s/%s must remain: %v", obj.Namespace, obj.Name, err)
+			}
+		}
+		if _, err := fakeClientset.BatchV1().Jobs("ns-a").Get(ctx, "test-1", metav1.GetOptions{}); err == nil {
+			t.Fatal("Job of the shard in the other namespace must be deleted")
+		}
+	})
+	t.Run("Rebalance", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Static: staticSources(9),
+		}
+		testjob.Spec.MainStep.Strategy.Scheduler.MaxContainersPerPod = 4
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedule := func(t *testing.T, rebalance bool) ([]int, string) {
+			testjob.Spec.MainStep.Strategy.Scheduler.Rebalance = rebalance
+			var b bytes.Buffer
+			ctx := WithLogger(context.Background(), NewLogger(&b, LogLevelInfo))
+			builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+			scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+			taskGroup, err := scheduler.Schedule(ctx, builder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyNums := []int{}
+			for _, task := range taskGroup.tasks {
+				keyNums = append(keyNums, len(task.strategyKey.Keys))
+			}
+			if !reflect.DeepEqual(keyNums, scheduler.plannedKeyNums(9)) {
+				t.Fatalf("planned key nums %v must be the same as the scheduled ones %v", scheduler.plannedKeyNums(9), keyNums)
+			}
+			return keyNums, b.String()
+		}
+		keyNums, out := schedule(t, false)
+		if !reflect.DeepEqual(keyNums, []int{4, 4, 1}) {
+			t.Fatalf("unexpected key nums: %v", keyNums)
+		}
+		if !strings.Contains(out, "the last of 3 shards has only 1/4 containers") {
+			t.Fatalf("expected warning of the uneven last shard: %s", out)
+		}
+		keyNums, out = schedule(t, true)
+		if !reflect.DeepEqual(keyNums, []int{3, 3, 3}) {
+			t.Fatalf("unexpected rebalanced key nums: %v", keyNums)
+		}
+		if strings.Contains(out, "the last of") {
+			t.Fatalf("unexpected warning: %s", out)
+		}
+		if err := NewValidator().ValidateScheduler(Scheduler{MaxPodNum: 2, MaxConcurrentNumPerPod: 1, Rebalance: true}); err == nil {
+			t.Fatal("expected error for rebalance without maxContainersPerPod")
+		}
+	})
+	t.Run("OwnerReference", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Static: staticSources(3),
+		}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner := metav1.OwnerReference{
+			APIVersion: "example.com/v1",
+			Kind:       "Parent",
+			Name:       "parent",
+			UID:        "6c6cdb4b-0b3d-4ac7-9c3c-1d0ad4f1ecb8",
+		}
+		if err := NewValidator().ValidateOwnerReference(owner); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewValidator().ValidateOwnerReference(metav1.OwnerReference{APIVersion: "v1", Kind: "Parent", Name: "parent"}); err == nil {
+			t.Fatal("expected error for owner reference without uid")
+		}
+		resourceMgr := NewResourceManager(clientset, testjob)
+		builder := NewTaskBuilder(getConfig(), resourceMgr, "default", RunModeDryRun)
+		builder.SetOwnerReference(&owner)
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range taskGroup.tasks {
+			refs := task.job.(*dryRunJob).job.OwnerReferences
+			if len(refs) != 1 || refs[0].UID != owner.UID {
+				t.Fatalf("failed to set owner reference: %v", refs)
+			}
+		}
+		if len(testjob.Spec.MainStep.Template.OwnerReferences) != 0 {
+			t.Fatal("owner reference must not be added to the template")
+		}
+
+		testjob.Spec.MainStep.Strategy.Scheduler.MaxContainersPerPod = 1
+		testjob.Spec.MainStep.Strategy.Scheduler.Namespaces = []string{"default", "ns-b"}
+		taskGroup, err = NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refNums := []string{}
+		for _, task := range taskGroup.tasks {
+			job := task.job.(*dryRunJob).job
+			refNums = append(refNums, fmt.Sprintf("%s:%d", job.Namespace, len(job.OwnerReferences)))
+		}
+		if expected := ":1,ns-b:0,:1"; strings.Join(refNums, ",") != expected {
+			t.Fatalf("owner reference must be set only in the same namespace: %v", refNums)
+		}
+	})
+	t.Run("EnvFrom", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Static: staticSources(3),
+		}
+		testjob.Spec.EnvFrom = []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "common"}}},
+		}
+		testjob.Spec.MainStep.Template.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "test"}}},
+		}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resourceMgr := NewResourceManager(clientset, testjob)
+		builder := NewTaskBuilder(getConfig(), resourceMgr, "default", RunModeDryRun)
+		builder.SetEnvFrom(testjob.Spec.EnvFrom)
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range taskGroup.tasks {
+			for _, container := range task.job.(*dryRunJob).job.Spec.Template.Spec.Containers {
+				envFrom := container.EnvFrom
+				if len(envFrom) != 2 || envFrom[0].ConfigMapRef.Name != "common" || envFrom[1].SecretRef.Name != "test" {
+					t.Fatalf("failed to set envFrom to %s: %+v", container.Name, envFrom)
+				}
+			}
+		}
+		if len(testjob.Spec.MainStep.Template.Spec.Containers[0].EnvFrom) != 1 {
+			t.Fatal("envFrom must not be added to the template")
+		}
+	})
+	t.Run("ImagePrefix", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Static: staticSources(3),
+		}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resourceMgr := NewResourceManager(clientset, testjob)
+		builder := NewTaskBuilder(getConfig(), resourceMgr, "default", RunModeDryRun)
+		builder.SetImagePrefix("mirror.example.com")
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		image := testjob.Spec.MainStep.Template.Spec.Containers[0].Image
+		for _, task := range taskGroup.tasks {
+			for _, container := range task.job.(*dryRunJob).job.Spec.Template.Spec.Containers {
+				if container.Image != "mirror.example.com/"+image {
+					t.Fatalf("failed to rewrite image of %s: %s", container.Name, container.Image)
+				}
+			}
+		}
+	})
+	t.Run("Scratch", func(t *testing.T) {
+		testjob := *baseTestJob.DeepCopy()
+		testjob.Spec.MainStep.Strategy.Key.Source = StrategyKeySource{
+			Static: staticSources(3),
+		}
+		sizeLimit := resource.MustParse("10Gi")
+		testjob.Spec.Scratch = &ScratchSpec{
+			SizeLimit: &sizeLimit,
+			Medium:    corev1.StorageMediumMemory,
+			MountPath: "/scratch",
+		}
+		testjob.Spec.MainStep.Template.Spec.InitContainers = []TestJobContainer{
+			{
+				Container: corev1.Container{
+					Name:  "init",
+					Image: "alpine",
+					Env:   []corev1.EnvVar{{Name: "TMPDIR", Value: "/tmp"}},
+				},
+			},
+		}
+		testjob.Spec.MainStep.Template.Spec.FinalizerContainer = TestJobContainer{
+			Container: corev1.Container{
+				Name:  "finalizer",
+				Image: "alpine",
+			},
+		}
+		clientset, err := kubernetes.NewForConfig(getConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resourceMgr := NewResourceManager(clientset, testjob)
+		builder := NewTaskBuilder(getConfig(), resourceMgr, "default", RunModeDryRun)
+		builder.SetScratch(testjob.Spec.Scratch)
+		taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpDir := func(container corev1.Container) string {
+			for _, env := range container.Env {
+				if env.Name == "TMPDIR" {
+					return env.Value
+				}
+			}
+			return ""
+		}
+		hasScratchMount := func(container corev1.Container) bool {
+			for _, vm := range container.VolumeMounts {
+				if vm.Name == scratchVolumeName && vm.MountPath == "/scratch" {
+					return true
+				}
+			}
+			return false
+		}
+		for _, task := range taskGroup.tasks {
+			podSpec := task.job.(*dryRunJob).job.Spec.Template.Spec
+			var scratch *corev1.Volume
+			for idx := range podSpec.Volumes {
+				if podSpec.Volumes[idx].Name == scratchVolumeName {
+					scratch = &podSpec.Volumes[idx]
+				}
+			}
+			if scratch == nil || scratch.EmptyDir == nil {
+				t.Fatalf("scratch volume must be added: %+v", podSpec.Volumes)
+			}
+			if scratch.EmptyDir.Medium != corev1.StorageMediumMemory || scratch.EmptyDir.SizeLimit.Cmp(sizeLimit) != 0 {
+				t.Fatalf("unexpected scratch volume: %+v", scratch.EmptyDir)
+			}
+			if len(podSpec.Containers) != 3 {
+				t.Fatalf("unexpected number of containers: %d", len(podSpec.Containers))
+			}
+			for _, container := range podSpec.Containers {
+				if !hasScratchMount(container) {
+					t.Fatalf("scratch volume must be mounted to %s: %+v", container.Name, container.VolumeMounts)
+				}
+				if dir := tmpDir(container); dir != "/scratch" {
+					t.Fatalf("TMPDIR of %s must be the scratch directory but got %q", container.Name, dir)
+				}
+			}
+			for _, container := range podSpec.InitContainers {
+				if !hasScratchMount(container) {
+					t.Fatalf("scratch volume must be mounted to %s: %+v", container.Name, container.VolumeMounts)
+				}
+				if dir := tmpDir(container); dir != "/tmp" {
+					t.Fatalf("specified TMPDIR of %s must not be overwritten but got %q", container.Name, dir)
+				}
+			}
+			finalizer := task.job.(*dryRunJob).finalizer
+			if finalizer == nil {
+				t.Fatal("finalizer must be built")
+			}
+			if !hasScratchMount(*finalizer) {
+				t.Fatalf("scratch volume must be mounted to the finalizer: %+v", finalizer.VolumeMounts)
+			}
+			if dir := tmpDir(*finalizer); dir != "/scratch" {
+				t.Fatalf("TMPDIR of the finalizer must be the scratch directory but got %q", dir)
+			}
+		}
+		if len(testjob.Spec.MainStep.Template.Spec.Containers[0].VolumeMounts) != 0 {
+			t.Fatal("scratch volume must not be added to the template")
+		}
+	})
 }
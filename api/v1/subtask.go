@@ -4,8 +4,12 @@
 package v1
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"os/exec"
 	"strings"
 	"sync"
 	"time"
@@ -15,13 +19,21 @@ import (
 )
 
 type SubTask struct {
-	Name         string
-	TaskName     string
-	KeyEnvName   string
-	OnFinish     func(*SubTask)
-	exec         JobExecutor
-	isMain       bool
-	copyArtifact func(context.Context, *SubTask) error
+	Name             string
+	TaskName         string
+	KeyEnvName       string
+	Metadata         map[string]string
+	OnFinish         func(*SubTask)
+	exec             JobExecutor
+	isMain           bool
+	copyArtifact     func(context.Context, *SubTask) error
+	onFailureCommand []string
+	stopGracePeriod  time.Duration
+	startJitter      time.Duration
+	commandWrapper   []string
+	emptyOutput      EmptyOutputPolicy
+	debug            *debugHolder
+	logLineLimit     LogLineLimit
 }
 
 func (t *SubTask) outputError(logGroup Logger, baseErr error) {
@@ -46,10 +58,53 @@ func (t *SubTask) outputError(logGroup Logger, baseErr error) {
 	}
 }
 
+// onFailureCommandTimeout timeout for running the command to collect diagnostics of the failed test.
+var onFailureCommandTimeout = 1 * time.Minute
+
 const (
 	terminationLog = "kubetest task is completed"
+
+	oomKilledReason = "OOMKilled"
+	// oomKilledExitCode the exit code of the process killed by SIGKILL ( 128 + 9 ) as the OOM killer does.
+	oomKilledExitCode = 137
 )
 
+// errEmptyOutput the error of the test exited with 0 but produced no output.
+var errEmptyOutput = errors.New("kubetest: the test exited with 0 but produced no output. the test might not have run ( mainStep.emptyOutput is fail )")
+
+// requiresOutput returns whether the test must produce output to pass.
+func (t *SubTask) requiresOutput() bool {
+	return t.isMain && t.emptyOutput == EmptyOutputPolicyFail
+}
+
+// failureKind detects the kind of failure from the status of the container and the error of the command.
+// The container keeps running after the test process is OOM-killed because the test runs by exec,
+// so the exit code 137 ( SIGKILL ) of the command is also regarded as a suspected OOMKilled.
+func (t *SubTask) failureKind(ctx context.Context, logGroup Logger, cmdErr error) FailureKind {
+	reason, err := t.exec.TerminatedReason(ctx)
+	if err != nil {
+		logGroup.Warn("failed to get terminated reason: %s", err.Error())
+	}
+	container := t.exec.Container()
+	if reason == oomKilledReason {
+		logGroup.Error("container %s was OOMKilled. %s", container.Name, memoryLimitAdvice(container))
+		return FailureKindOOMKilled
+	}
+	if exitCodeFromError(cmdErr) == oomKilledExitCode {
+		logGroup.Error("test in container %s exited with %d. it might be OOMKilled. %s", container.Name, oomKilledExitCode, memoryLimitAdvice(container))
+		return FailureKindOOMKilledSuspected
+	}
+	return FailureKindNone
+}
+
+// memoryLimitAdvice returns the advice on the memory limit of the container killed by OOM.
+func memoryLimitAdvice(container corev1.Container) string {
+	if limit, exists := container.Resources.Limits[corev1.ResourceMemory]; exists {
+		return fmt.Sprintf("consider increasing the memory limit ( current: %s )", limit.String())
+	}
+	return "consider specifying a higher memory limit"
+}
+
 func (t *SubTask) Run(ctx context.Context) *SubTaskResult {
 	logger := LoggerFromContext(ctx)
 	logGroup := logger.Group()
@@ -63,40 +118,158 @@ func (t *SubTask) Run(ctx context.Context) *SubTaskResult {
 			t.OnFinish(t)
 		}
 	}()
+	t.waitStartJitter(ctx, logGroup)
 	start := time.Now()
 	out, err := t.exec.Output(ctx)
+	// the elapsed time is measured before the grace period, so it is the time of the test only.
+	elapsedTime := time.Since(start)
+	if err == nil && t.requiresOutput() && len(bytes.TrimSpace(out)) == 0 {
+		err = errEmptyOutput
+	}
+	t.waitStopGracePeriod(ctx, logGroup)
+	container := t.unwrappedContainer()
 	result := &SubTaskResult{
-		ElapsedTime: time.Since(start),
+		ElapsedTime: elapsedTime,
 		Out:         out,
 		Err:         err,
 		Name:        t.Name,
-		Container:   t.exec.Container(),
+		Container:   container,
 		Pod:         t.exec.Pod(),
 		IsMain:      t.isMain,
 		KeyEnvName:  t.KeyEnvName,
+		Env: envSnapshot(container, func(msg string) string {
+			return maskText(logger, msg)
+		}),
+		WorkingDir: container.WorkingDir,
+		Metadata:   t.Metadata,
+		Phase:      ContainerPhaseMain,
 	}
-	logGroup.Debug("container: %s", t.exec.Container().Name)
+	logGroup.Debug("container: %s", container.Name)
 	logGroup.Log(result.Command())
-	logGroup.Log(string(out))
+	logOutput(logGroup, string(out), t.logLineLimit.maxLines(err != nil))
 	if err == nil {
 		result.Status = TaskResultSuccess
 	} else {
 		t.outputError(logGroup, err)
 		result.Status = TaskResultFailure
+		if ctx.Err() != nil {
+			result.FailureKind = FailureKindInterrupted
+		} else {
+			if t.isMain {
+				recordTestFailure(ctx, time.Now())
+			}
+			if errors.Is(err, errEmptyOutput) {
+				result.FailureKind = FailureKindEmptyOutput
+			} else {
+				result.FailureKind = t.failureKind(ctx, logGroup, err)
+			}
+			t.runOnFailureCommand(ctx, logGroup, result)
+			if t.isMain && t.debug != nil {
+				t.debug.hold(ctx, logger, t)
+			}
+		}
 	}
 	if t.TaskName != "" {
 		logGroup.Info("%s: elapsed time: %f sec.", t.TaskName, result.ElapsedTime.Seconds())
 	} else {
 		logGroup.Info("elapsed time: %f sec.", result.ElapsedTime.Seconds())
 	}
+	if result.IsInternalError() {
+		recordInfraFailure(ctx, "exit code of the test was lost")
+	}
+	// copy artifacts regardless of the result because they are most valuable for the failed test ( e.g. heap dumps ).
 	if err := t.copyArtifact(ctx, t); err != nil {
-		logGroup.Error("failed to copy artifact: %s", err.Error())
-		result.Status = TaskResultFailure
-		result.ArtifactErr = err
+		if result.Status == TaskResultFailure && errors.Is(err, errArtifactNotFound) {
+			// the test might have crashed before creating the artifacts.
+			logGroup.Warn("failed to copy artifact of the failed test: %s", err.Error())
+		} else {
+			logGroup.Error("failed to copy artifact: %s", err.Error())
+			result.Status = TaskResultFailure
+			result.ArtifactErr = err
+			recordInfraFailure(ctx, "failed to copy artifact")
+		}
 	}
 	return result
 }
 
+// unwrappedContainer returns the container running the test with the original command of the test,
+// so the command wrapper doesn't appear in the report.
+func (t *SubTask) unwrappedContainer() corev1.Container {
+	container := t.exec.Container()
+	if !t.isMain || !hasCommandPrefix(container.Command, t.commandWrapper) {
+		return container
+	}
+	container.Command = container.Command[len(t.commandWrapper):]
+	return container
+}
+
+func hasCommandPrefix(command, prefix []string) bool {
+	if len(prefix) == 0 || len(command) < len(prefix) {
+		return false
+	}
+	for idx, arg := range prefix {
+		if command[idx] != arg {
+			return false
+		}
+	}
+	return true
+}
+
+// waitStartJitter waits for the random time up to startJitter before starting the test,
+// so the tests starting at the same time don't access the shared resources at once.
+func (t *SubTask) waitStartJitter(ctx context.Context, logGroup Logger) {
+	if !t.isMain || t.startJitter <= 0 {
+		return
+	}
+	delay := time.Duration(rand.Int63n(int64(t.startJitter)))
+	logGroup.Debug("wait %s before starting the test", delay)
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
+
+// waitStopGracePeriod waits for the background processes of the test to flush their files.
+// The artifacts are copied after this and the container is stopped after copying them.
+func (t *SubTask) waitStopGracePeriod(ctx context.Context, logGroup Logger) {
+	if !t.isMain || t.stopGracePeriod <= 0 {
+		return
+	}
+	logGroup.Debug("wait %s before stopping the container", t.stopGracePeriod)
+	select {
+	case <-ctx.Done():
+	case <-time.After(t.stopGracePeriod):
+	}
+}
+
+// runOnFailureCommand runs the command to collect diagnostics in the container of the failed test,
+// and appends the output to the output of the test.
+// The container is stopped after the test, so this must be called before sending the termination log.
+func (t *SubTask) runOnFailureCommand(ctx context.Context, logGroup Logger, result *SubTaskResult) {
+	if !t.isMain || len(t.onFailureCommand) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, onFailureCommandTimeout)
+	defer cancel()
+	// PrepareCommand runs the joined command by the shell, so each argument is quoted to be passed as is.
+	quoted := make([]string, 0, len(t.onFailureCommand))
+	for _, arg := range t.onFailureCommand {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	cmd := strings.Join(quoted, " ")
+	out, err := t.exec.PrepareCommand(ctx, quoted)
+	if err != nil {
+		logGroup.Warn("failed to run on failure command %q: %s", cmd, err.Error())
+	}
+	if len(out) == 0 {
+		return
+	}
+	logGroup.Log(fmt.Sprintf("--- on failure command: %s", cmd))
+	logGroup.Log(string(out))
+	diag := fmt.Sprintf("\n--- on failure command: %s\n%s", cmd, out)
+	result.Out = append(result.Out, []byte(diag)...)
+}
+
 type SubTaskGroup struct {
 	tasks []*SubTask
 }
@@ -155,6 +328,23 @@ func (s TaskResultStatus) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf(`"%s"`, s.String())), nil
 }
 
+// FailureKind kind of the failure detected from the container status.
+type FailureKind string
+
+const (
+	// FailureKindNone the failure has no specific kind ( e.g. the test just failed ).
+	FailureKindNone FailureKind = ""
+	// FailureKindOOMKilled the container was killed because it exceeded the memory limit.
+	FailureKindOOMKilled FailureKind = "oomKilled"
+	// FailureKindOOMKilledSuspected the test exited with 137 ( SIGKILL ) while the container kept running.
+	// The test process was likely killed by the OOM killer.
+	FailureKindOOMKilledSuspected FailureKind = "oomKilledSuspected"
+	// FailureKindInterrupted the test was stopped because the run was canceled.
+	FailureKindInterrupted FailureKind = "interrupted"
+	// FailureKindEmptyOutput the test exited with 0 but produced no output while mainStep.emptyOutput is fail.
+	FailureKindEmptyOutput FailureKind = "emptyOutput"
+)
+
 type SubTaskResult struct {
 	Status      TaskResultStatus
 	ElapsedTime time.Duration
@@ -166,6 +356,24 @@ type SubTaskResult struct {
 	Pod         *corev1.Pod
 	KeyEnvName  string
 	IsMain      bool
+	FailureKind FailureKind
+	// Env environment variables of the container at the time the test ran. The values are masked.
+	Env []corev1.EnvVar
+	// WorkingDir working directory of the container.
+	WorkingDir string
+	// Metadata metadata of the test got with the strategy key.
+	Metadata map[string]string
+	// Phase phase of the pod in which the result was decided ( e.g. setup if the repository couldn't be extracted ).
+	Phase ContainerPhase
+}
+
+// phaseMessage returns the message of the failure originated in the phase other than main ( e.g. setup ).
+// The failure of the test itself is described by the output, so the message is empty.
+func (r *SubTaskResult) phaseMessage() string {
+	if r.Status != TaskResultFailure || r.Phase == "" || r.Phase == ContainerPhaseMain {
+		return ""
+	}
+	return string(r.Out)
 }
 
 func (r *SubTaskResult) Error() error {
@@ -182,6 +390,54 @@ func (r *SubTaskResult) Error() error {
 	return nil
 }
 
+// ExitCode returns the exit code of the command.
+// If the command succeeded, returns 0. If the exit code couldn't be determined, returns -1.
+func (r *SubTaskResult) ExitCode() int {
+	if r.Err == nil {
+		return 0
+	}
+	return exitCodeFromError(r.Err)
+}
+
+// IsInternalError returns whether the result failed by the error not caused by the test itself.
+// e.g. the exit code couldn't be determined because the container was lost, the setup of the container failed,
+// or the artifact couldn't be copied.
+// The test interrupted by the cancellation of the run is not an internal error.
+func (r *SubTaskResult) IsInternalError() bool {
+	if r.ArtifactErr != nil || r.Phase == ContainerPhaseSetup {
+		return true
+	}
+	if r.Err == nil || r.FailureKind != FailureKindNone {
+		return false
+	}
+	return r.ExitCode() < 0
+}
+
+func exitCodeFromError(err error) int {
+	var execExitErr *exec.ExitError
+	if errors.As(err, &execExitErr) {
+		return execExitErr.ExitCode()
+	}
+	var exitErr interface{ ExitStatus() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	var failedJob *kubejob.FailedJob
+	if errors.As(err, &failedJob) && failedJob.Reason != nil {
+		if cmdErr, ok := failedJob.Reason.(*kubejob.CommandError); ok {
+			for _, err := range []error{cmdErr.ReaderErr, cmdErr.WriterErr} {
+				if err == nil {
+					continue
+				}
+				if code := exitCodeFromError(err); code >= 0 {
+					return code
+				}
+			}
+		}
+	}
+	return -1
+}
+
 func (r *SubTaskResult) Command() string {
 	cmd := strings.Join(append(r.Container.Command, r.Container.Args...), " ")
 	envName := r.KeyEnvName
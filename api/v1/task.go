@@ -7,13 +7,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/goccy/kubejob"
 	"github.com/lestrrat-go/backoff"
-	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 type Task struct {
@@ -23,7 +27,30 @@ type Task struct {
 	copyArtifact      func(context.Context, *SubTask) error
 	strategyKey       *StrategyKey
 	mainContainerName string
-	createJob         func(context.Context) (Job, error)
+	// postContainerNames names of the containers run after all subtasks of the pod finish.
+	postContainerNames map[string]struct{}
+	onFailureCommand   []string
+	stopGracePeriod    time.Duration
+	startJitter        time.Duration
+	commandWrapper     []string
+	emptyOutput        EmptyOutputPolicy
+	// finalizerVerdictMode how the verdict of the finalizer is applied. If empty, the verdict isn't read.
+	finalizerVerdictMode FinalizerVerdictMode
+	// onFinalizer called when the finalizer starts. If nil, nothing is called.
+	onFinalizer func()
+	// shardSummary whether to write the summary line of the shard when the task finishes.
+	shardSummary bool
+	createJob    func(context.Context) (Job, error)
+	// debug holds the pod of the failed test for debugging. If nil, the pod isn't held.
+	debug *debugHolder
+	// logLineLimit maximum number of lines of the output of each subtask written to the log.
+	logLineLimit LogLineLimit
+	// teardownCause the failure of the run which prevented the tests from running.
+	// If not nil, the task runs only the finalizer container in degraded mode.
+	teardownCause error
+	// failOnPendingTimeout whether the pending timeout of the job is the total time the pod of the task can be pending.
+	// If true, the job isn't recreated when its pod stays pending, so the timeout isn't multiplied by the retries.
+	failOnPendingTimeout bool
 }
 
 func (t *Task) SubTaskNum() int {
@@ -36,14 +63,101 @@ func (t *Task) SubTaskNum() int {
 	return subTaskNum
 }
 
+// CPURequest returns the total CPU requested by the containers of the task's pod.
+// As well as kubernetes, the effective request is the larger of the sum of containers and the maximum of init containers.
+func (t *Task) CPURequest() resource.Quantity {
+	podSpec := t.job.Spec().Template.Spec
+	var total resource.Quantity
+	for _, container := range podSpec.Containers {
+		total.Add(*container.Resources.Requests.Cpu())
+	}
+	for _, container := range podSpec.InitContainers {
+		if request := container.Resources.Requests.Cpu(); request.Cmp(total) > 0 {
+			total = request.DeepCopy()
+		}
+	}
+	return total
+}
+
+// outputName returns the name of the file of the combined output of the task.
+// The shards of mainStep have the same name, so the index of the shard is added.
+func (t *Task) outputName(name string) string {
+	if t.strategyKey == nil {
+		return name
+	}
+	return fmt.Sprintf("%s-shard%d", name, t.strategyKey.ConcurrentIdx)
+}
+
 func (t *Task) Run(ctx context.Context) (*TaskResult, error) {
-	return t.runWithRetry(ctx)
+	start := time.Now()
+	name := t.Name
+	if name == "" {
+		name = MainStepType
+	}
+	// the requests are counted for each shard, so the shard sending many requests can be found.
+	ctx = withAPIRequestTask(ctx, t.displayName())
+	result, err := t.runWithRetry(ctx)
+	if result != nil {
+		writeTaskOutput(ctx, t.outputName(name), result)
+		if t.shardSummary {
+			t.logShardSummary(ctx, result, time.Since(start))
+		}
+	}
+	return result, err
+}
+
+// logShardSummary writes the summary of the finished shard as a line of key=value pairs,
+// so the shards running in parallel can be found easily from the log.
+func (t *Task) logShardSummary(ctx context.Context, result *TaskResult, elapsedTime time.Duration) {
+	var (
+		passed  int
+		failed  int
+		podName string
+	)
+	mainResults := result.MainTaskResults()
+	for _, mainResult := range mainResults {
+		if mainResult.Status == TaskResultSuccess {
+			passed++
+		} else {
+			failed++
+		}
+		if podName == "" && mainResult.Pod != nil {
+			podName = mainResult.Pod.Name
+		}
+	}
+	LoggerFromContext(ctx).Info(
+		"shard summary: shard=%d tests=%d passed=%d failed=%d duration=%.3fs pod=%s",
+		t.strategyKey.ConcurrentIdx, len(mainResults), passed, failed, elapsedTime.Seconds(), podName,
+	)
+}
+
+// displayName returns the name of the task with the index of the shard to distinguish the shards of the same step.
+func (t *Task) displayName() string {
+	name := t.Name
+	if name == "" {
+		name = MainStepType
+	}
+	if t.strategyKey == nil {
+		return name
+	}
+	return fmt.Sprintf("%s shard-%d", name, t.strategyKey.ConcurrentIdx)
+}
+
+// shardIndex returns the index of the shard of the task. If the task isn't a shard of mainStep, returns -1.
+func (t *Task) shardIndex() int {
+	if t.strategyKey == nil {
+		return -1
+	}
+	return int(t.strategyKey.ConcurrentIdx)
 }
 
 func (t *Task) retryableError(err error) bool {
 	if err == nil {
 		return false
 	}
+	if t.failOnPendingTimeout && isPendingTimeoutError(err) {
+		return false
+	}
 	switch e := err.(type) {
 	case *kubejob.PreInitError:
 		return true
@@ -59,6 +173,65 @@ func (t *Task) retryableError(err error) bool {
 	return false
 }
 
+var (
+	// quotaRetryInterval interval before the first retry of the job rejected by the quota of the namespace.
+	quotaRetryInterval = 5 * time.Second
+	// quotaRetryMaxInterval maximum interval between the retries. The interval is doubled for each retry up to this.
+	quotaRetryMaxInterval = 1 * time.Minute
+	// quotaWaitTimeout maximum time to wait for the quota of the namespace to be freed.
+	// The wait is also stopped when the context of the run is done.
+	quotaWaitTimeout = 1 * time.Hour
+)
+
+// isQuotaExceededError returns whether err is caused by the job or its pod rejected by ResourceQuota of the namespace.
+// Other errors of creating the job ( e.g. invalid spec ) are not retried because they never succeed.
+func isQuotaExceededError(err error) bool {
+	switch e := err.(type) {
+	case *kubejob.JobCreationError:
+		return apierrors.IsForbidden(e.Err) && strings.Contains(e.Err.Error(), "exceeded quota")
+	case *PodQuotaExceededError:
+		return true
+	case *kubejob.JobMultiError:
+		for _, err := range e.Errs {
+			if isQuotaExceededError(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPendingTimeoutError returns whether err or the error wrapped by it is caused by the pod which couldn't leave the pending phase.
+func isPendingTimeoutError(err error) bool {
+	var timeoutErr *kubejob.PendingPhaseTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return true
+	}
+	var multiErr *kubejob.JobMultiError
+	return errors.As(err, &multiErr) && multiErr.Has(kubejob.PendingPhaseTimeoutErrorType)
+}
+
+// infraFailureReason returns the reason of the retryable error for the circuit breaker.
+func infraFailureReason(err error) string {
+	switch e := err.(type) {
+	case *kubejob.PreInitError:
+		return "failed to initialize pod"
+	case *kubejob.PendingPhaseTimeoutError:
+		return "pod stayed pending"
+	case *kubejob.JobUnexpectedError:
+		return "job failed unexpectedly"
+	case *kubejob.JobMultiError:
+		switch {
+		case e.Has(kubejob.PreInitErrorType):
+			return "failed to initialize pod"
+		case e.Has(kubejob.PendingPhaseTimeoutErrorType):
+			return "pod stayed pending"
+		}
+		return "job failed unexpectedly"
+	}
+	return err.Error()
+}
+
 func (t *Task) runWithRetry(ctx context.Context) (*TaskResult, error) {
 	const taskRetryCount = 2
 
@@ -75,9 +248,10 @@ func (t *Task) runWithRetry(ctx context.Context) (*TaskResult, error) {
 		retryCount int
 	)
 	for backoff.Continue(b) {
-		result, err = t.run(ctx)
+		result, err = t.runWaitingForQuota(ctx)
 		if err != nil {
 			if t.retryableError(err) {
+				recordInfraFailure(ctx, infraFailureReason(err))
 				LoggerFromContext(ctx).Warn(
 					"failed to run task because %s. retry %d/%d",
 					err, retryCount, taskRetryCount,
@@ -99,56 +273,231 @@ func (t *Task) runWithRetry(ctx context.Context) (*TaskResult, error) {
 	return result, err
 }
 
+// runWaitingForQuota runs the task. If the job is rejected because the quota of the namespace is exhausted,
+// waits for the running jobs to free the capacity and runs again, so a busy namespace delays the run instead of failing it.
+func (t *Task) runWaitingForQuota(ctx context.Context) (*TaskResult, error) {
+	interval := quotaRetryInterval
+	deadline := time.Now().Add(quotaWaitTimeout)
+	for {
+		result, err := t.run(ctx)
+		if !isQuotaExceededError(err) {
+			return result, err
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return nil, fmt.Errorf("kubetest: quota of the namespace was not freed within %s: %w", quotaWaitTimeout, err)
+		}
+		LoggerFromContext(ctx).Warn("quota of the namespace is exceeded. retry after %s: %s", interval, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("kubetest: stopped waiting for quota of the namespace: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+		interval *= 2
+		if interval > quotaRetryMaxInterval {
+			interval = quotaRetryMaxInterval
+		}
+		// Recreate the job because the internal state of the job has already changed.
+		// The rejected job has already been deleted, so it doesn't remain for each retry.
+		job, err := t.createJob(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.job = job
+	}
+}
+
 func (t *Task) run(ctx context.Context) (*TaskResult, error) {
 	logger := LoggerFromContext(ctx)
 	var result TaskResult
-	if err := t.job.RunWithExecutionHandler(ctx, func(ctx context.Context, executors []JobExecutor) error {
+	err := t.job.RunWithExecutionHandler(ctx, func(ctx context.Context, executors []JobExecutor) error {
+		if t.teardownCause != nil {
+			// the containers are stopped without running the tests, and then the finalizer runs.
+			return nil
+		}
 		for _, sidecar := range t.sideCarExecutors(executors) {
 			sidecar.ExecAsync(ctx)
 		}
 		subTasks := t.getSubTasks(t.mainExecutors(executors))
+		testStart := time.Now()
 		if t.strategyKey == nil {
 			result.add(NewSubTaskGroup(subTasks).Run(ctx))
-			return nil
+		} else {
+			subTaskGroups := t.strategyKey.SubTaskScheduler.Schedule(subTasks)
+			for _, subTaskGroup := range subTaskGroups {
+				result.add(subTaskGroup.Run(ctx))
+			}
 		}
-		subTaskGroups := t.strategyKey.SubTaskScheduler.Schedule(subTasks)
-		for _, subTaskGroup := range subTaskGroups {
-			result.add(subTaskGroup.Run(ctx))
+		result.testTime = time.Since(testStart)
+		t.runPostSubTasks(ctx, executors, &result)
+		if t.debug != nil {
+			// the pod is deleted after the handler returns, so it's kept until the hold for debugging ends.
+			t.debug.wait(executors)
 		}
 		return nil
 	}, func(ctx context.Context, finalizer JobExecutor) error {
+		if t.onFinalizer != nil {
+			t.onFinalizer()
+		}
+		setupErr := finalizerSetupErrorFromContext(ctx)
+		if setupErr == nil {
+			setupErr = t.teardownCause
+		}
+		var setupMessage string
+		if setupErr != nil {
+			setupMessage = maskText(logger, setupErr.Error())
+			logger.Warn("run finalizer in degraded mode because %s", setupMessage)
+		}
 		out, err := finalizer.Output(ctx)
-		if err != nil {
-			logger.Error("failed to run finalizer: output %s: %s", string(out), err.Error())
-			return fmt.Errorf("failed to run finalizer: %s: %w", string(out), err)
+		err = t.finalizerResultError(ctx, finalizer, out, err)
+		result.finalizer = &FinalizerResult{
+			Container:    finalizer.Container().Name,
+			Out:          []byte(maskText(logger, string(out))),
+			Err:          err,
+			SetupErr:     setupErr,
+			setupMessage: setupMessage,
+		}
+		return err
+	})
+	// kubejob only logs the error returned by the handler of the finalizer, so the error recorded to the result is applied here.
+	if err == nil && result.finalizer != nil && result.finalizer.Err != nil {
+		err = &ContainerPhaseError{Phase: ContainerPhaseFinalizer, Container: result.finalizer.Container, Err: result.finalizer.Err}
+	}
+	if err != nil {
+		var phaseErr *ContainerPhaseError
+		if errors.As(err, &phaseErr) {
+			switch phaseErr.Phase {
+			case ContainerPhaseSetup:
+				setupResult := t.setupFailureResult(logger, phaseErr)
+				setupResult.finalizer = result.finalizer
+				return setupResult, nil
+			case ContainerPhaseFinalizer:
+				// the results of the tests are kept, and the failure of the finalizer is added to them.
+				result.add(t.finalizerFailureResult(logger, phaseErr))
+				return &result, nil
+			}
 		}
-		logger.Debug("run finalizer: output %s", string(out))
-		return nil
-	}); err != nil {
 		var failedJob *kubejob.FailedJob
 		if !errors.As(err, &failedJob) {
 			return nil, err
 		}
+		if initErr := failedInitContainerError(failedJob); initErr != nil && len(result.groups) == 0 {
+			return t.setupFailureResult(logger, initErr), nil
+		}
 	}
 	return &result, nil
 }
 
+// finalizerResultError returns the error of the finalizer decided by the verdict or the exit code of the finalizer.
+func (t *Task) finalizerResultError(ctx context.Context, finalizer JobExecutor, out []byte, err error) error {
+	logger := LoggerFromContext(ctx)
+	if t.finalizerVerdictMode != "" {
+		verdict, verdictErr := readFinalizerVerdict(ctx, finalizer)
+		if verdictErr != nil {
+			return verdictErr
+		}
+		if verdict != nil {
+			logger.Debug("run finalizer: output %s", string(out))
+			return applyFinalizerVerdict(ctx, t.finalizerVerdictMode, verdict, err)
+		}
+	}
+	if err != nil {
+		logger.Error("failed to run finalizer: output %s: %s", string(out), err.Error())
+		return fmt.Errorf("failed to run finalizer: %s: %w", string(out), err)
+	}
+	logger.Debug("run finalizer: output %s", string(out))
+	return nil
+}
+
+// setupFailureResult returns the result of the main containers which couldn't run the tests because the setup failed.
+// The tests are reported as failures of the setup phase, so they aren't confused with the failures of the tests.
+// The message of err is used as the output of the tests.
+func (t *Task) setupFailureResult(logger Logger, err error) *TaskResult {
+	msg := maskText(logger, err.Error())
+	logger.Error("%s", msg)
+	var keyEnvName string
+	if t.strategyKey != nil {
+		keyEnvName = t.strategyKey.Env
+	}
+	group := &SubTaskResultGroup{}
+	for _, container := range t.job.Spec().Template.Spec.Containers {
+		if !t.isMainContainer(container) {
+			continue
+		}
+		group.add(&SubTaskResult{
+			Status:     TaskResultFailure,
+			Out:        []byte(msg),
+			Err:        err,
+			Name:       t.getKeyName(container),
+			Container:  container,
+			IsMain:     true,
+			KeyEnvName: keyEnvName,
+			WorkingDir: container.WorkingDir,
+			Phase:      ContainerPhaseSetup,
+		})
+	}
+	var result TaskResult
+	result.add(group)
+	return &result
+}
+
+// finalizerFailureResult returns the result failed by the finalizer. It's reported as the failure of the finalizer phase
+// named by the task and the finalizer container ( e.g. mainStep shard-0/finalizer ), so it fails the run without losing the results of the tests.
+func (t *Task) finalizerFailureResult(logger Logger, err *ContainerPhaseError) *SubTaskResultGroup {
+	msg := maskText(logger, err.Error())
+	group := &SubTaskResultGroup{}
+	group.add(&SubTaskResult{
+		Status:    TaskResultFailure,
+		Out:       []byte(msg),
+		Err:       err,
+		Name:      fmt.Sprintf("%s/%s", t.displayName(), err.Container),
+		Container: corev1.Container{Name: err.Container},
+		IsMain:    true,
+		Phase:     ContainerPhaseFinalizer,
+	})
+	return group
+}
+
+// runPostSubTasks runs the post containers one by one after all subtasks of the pod finish.
+func (t *Task) runPostSubTasks(ctx context.Context, executors []JobExecutor, result *TaskResult) {
+	for _, subTask := range t.getSubTasks(t.postExecutors(executors)) {
+		result.add(NewSubTaskGroup([]*SubTask{subTask}).Run(ctx))
+	}
+}
+
 func (t *Task) getSubTasks(execs []JobExecutor) []*SubTask {
 	tasks := make([]*SubTask, 0, len(execs))
 	for _, exec := range execs {
 		container := exec.Container()
+		isMain := t.isMainExecutor(exec)
+		var onFinish func(*SubTask)
+		if isMain {
+			onFinish = t.OnFinishSubTask
+		}
 		var envName string
 		if t.strategyKey != nil {
 			envName = t.strategyKey.Env
 		}
+		name := t.getKeyName(container)
+		var metadata map[string]string
+		if t.strategyKey != nil {
+			metadata = t.strategyKey.Metadata[name]
+		}
 		tasks = append(tasks, &SubTask{
-			Name:         t.getKeyName(container),
-			TaskName:     t.Name,
-			KeyEnvName:   envName,
-			OnFinish:     t.OnFinishSubTask,
-			exec:         exec,
-			copyArtifact: t.copyArtifact,
-			isMain:       t.isMainExecutor(exec),
+			Name:             name,
+			Metadata:         metadata,
+			TaskName:         t.Name,
+			KeyEnvName:       envName,
+			OnFinish:         onFinish,
+			exec:             exec,
+			copyArtifact:     t.copyArtifact,
+			isMain:           isMain,
+			onFailureCommand: t.onFailureCommand,
+			stopGracePeriod:  t.stopGracePeriod,
+			startJitter:      t.startJitter,
+			commandWrapper:   t.commandWrapper,
+			emptyOutput:      t.emptyOutput,
+			debug:            t.debug,
+			logLineLimit:     t.logLineLimit,
 		})
 	}
 	return tasks
@@ -167,13 +516,28 @@ func (t *Task) mainExecutors(executors []JobExecutor) []JobExecutor {
 func (t *Task) sideCarExecutors(executors []JobExecutor) []JobExecutor {
 	sideCarExecs := make([]JobExecutor, 0, len(executors))
 	for _, exec := range executors {
-		if !t.isMainExecutor(exec) {
+		if !t.isMainExecutor(exec) && !t.isPostExecutor(exec) {
 			sideCarExecs = append(sideCarExecs, exec)
 		}
 	}
 	return sideCarExecs
 }
 
+func (t *Task) postExecutors(executors []JobExecutor) []JobExecutor {
+	postExecs := make([]JobExecutor, 0, len(t.postContainerNames))
+	for _, exec := range executors {
+		if t.isPostExecutor(exec) {
+			postExecs = append(postExecs, exec)
+		}
+	}
+	return postExecs
+}
+
+func (t *Task) isPostExecutor(exec JobExecutor) bool {
+	_, exists := t.postContainerNames[exec.Container().Name]
+	return exists
+}
+
 func (t *Task) isMainExecutor(exec JobExecutor) bool {
 	return t.isMainContainer(exec.Container())
 }
@@ -186,6 +550,12 @@ func (t *Task) getKeyName(container corev1.Container) string {
 	if t.strategyKey == nil {
 		return container.Name
 	}
+	// the env has the original key of the key renamed by strategy.key.duplicates, so the container is identified by its name first.
+	for idx, key := range t.strategyKey.Keys {
+		if container.Name == strategyKeyContainerName(t.mainContainerName, t.strategyKey.ConcurrentIdx, idx) {
+			return key
+		}
+	}
 	envName := t.strategyKey.Env
 	for _, env := range container.Env {
 		if env.Name == envName {
@@ -209,7 +579,10 @@ func (t *Task) hasKeyEnv(container corev1.Container) bool {
 }
 
 type TaskGroup struct {
-	tasks []*Task
+	tasks     []*Task
+	cpuBudget *resource.Quantity
+	// next the group run after all tasks of this group finish.
+	next *TaskGroup
 }
 
 func NewTaskGroup(tasks []*Task) *TaskGroup {
@@ -218,47 +591,234 @@ func NewTaskGroup(tasks []*Task) *TaskGroup {
 	}
 }
 
+func (g *TaskGroup) TaskNum() int {
+	if g.next != nil {
+		return len(g.tasks) + g.next.TaskNum()
+	}
+	return len(g.tasks)
+}
+
+// SetNext set the group run after all tasks of this group finish.
+// The results of the next group are merged into the results of this group.
+func (g *TaskGroup) SetNext(next *TaskGroup) {
+	g.next = next
+}
+
+// SetCPUBudget set the total CPU requested by the tasks running at the same time.
+// The tasks are started in order until the sum of their CPU requests reaches the budget, and the rest wait for running tasks to finish.
+func (g *TaskGroup) SetCPUBudget(budget resource.Quantity) {
+	g.cpuBudget = &budget
+}
+
+// Run runs all tasks and returns their results.
+// If the next group is set, it runs after all tasks of this group finish.
+// If ctx is canceled while running, returns the results of the finished tasks with the error.
 func (g *TaskGroup) Run(ctx context.Context) (*TaskResultGroup, error) {
+	result, err := g.run(ctx)
+	if err != nil || g.next == nil {
+		return result, err
+	}
+	LoggerFromContext(ctx).Info("start the next stage of %d tasks", len(g.next.tasks))
+	nextResult, err := g.next.Run(ctx)
+	if nextResult == nil {
+		return nil, err
+	}
+	nextResult.merge(result)
+	return nextResult, err
+}
+
+// run runs all tasks to the end even if some of them failed, and returns TaskGroupError having the errors of all failed tasks.
+// The results of the succeeded tasks are returned with the error.
+func (g *TaskGroup) run(ctx context.Context) (*TaskResultGroup, error) {
 	var (
-		eg errgroup.Group
+		wg sync.WaitGroup
 		rg TaskResultGroup
 	)
+	// failures errors of the tasks indexed by the order of the tasks, so the failures are reported in that order.
+	failures := make([]*TaskFailure, len(g.tasks))
 	totalSubTaskNum := 0
 	for _, task := range g.tasks {
 		totalSubTaskNum += task.SubTaskNum()
 	}
 	rg.totalSubTaskNum = totalSubTaskNum
-	for _, task := range g.tasks {
-		task := task
-		eg.Go(func() error {
+	var sem *semaphore.Weighted
+	if g.cpuBudget != nil {
+		sem = semaphore.NewWeighted(g.cpuBudget.MilliValue())
+	}
+	var acquireErr error
+	for idx, task := range g.tasks {
+		idx, task := idx, task
+		weight := g.cpuWeight(ctx, task)
+		if sem != nil {
+			if err := sem.Acquire(ctx, weight); err != nil {
+				acquireErr = fmt.Errorf("kubetest: failed to wait for cpu budget: %w", err)
+				break
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sem != nil {
+				defer sem.Release(weight)
+			}
 			result, err := task.Run(ctx)
 			if err != nil {
-				return err
+				failures[idx] = &TaskFailure{Task: task.displayName(), Err: err}
+				return
 			}
+			result.task = task.displayName()
+			result.shard = task.shardIndex()
 			rg.add(result)
-			return nil
-		})
+			writePartialReport(ctx, result)
+		}()
 	}
-	if err := eg.Wait(); err != nil {
-		return nil, err
+	wg.Wait()
+	for _, failure := range failures {
+		if failure != nil {
+			rg.failures = append(rg.failures, failure)
+		}
+	}
+	if len(rg.failures) != 0 {
+		err := &TaskGroupError{TaskNum: len(g.tasks), Failures: rg.failures}
+		if ctx.Err() == nil {
+			err.logSummary(LoggerFromContext(ctx))
+		}
+		return &rg, err
+	}
+	if acquireErr != nil {
+		if ctx.Err() != nil {
+			// keep the results of the finished tasks to report them as partial result.
+			return &rg, acquireErr
+		}
+		return nil, acquireErr
 	}
 	return &rg, nil
 }
 
+// TaskFailure error of the task which failed to run ( e.g. the image of the pod couldn't be pulled ).
+type TaskFailure struct {
+	// Task name of the task. The index of the shard is appended to the task of the distributed strategy ( e.g. test shard-1 ).
+	Task string
+	Err  error
+}
+
+// TaskGroupError is returned by TaskGroup.Run when some tasks failed to run.
+// The other tasks aren't stopped by the failure, so the error has the errors of all failed tasks.
+type TaskGroupError struct {
+	// TaskNum number of the tasks of the group.
+	TaskNum int
+	// Failures failed tasks in order of the tasks of the group.
+	Failures []*TaskFailure
+}
+
+func (e *TaskGroupError) Error() string {
+	// the same error of many shards ( e.g. the image pull error ) is shown once with the names of the shards.
+	var (
+		msgs      []string
+		msgToTask = map[string][]string{}
+	)
+	for _, failure := range e.Failures {
+		msg := failure.Err.Error()
+		if _, exists := msgToTask[msg]; !exists {
+			msgs = append(msgs, msg)
+		}
+		msgToTask[msg] = append(msgToTask[msg], failure.Task)
+	}
+	groups := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		groups = append(groups, fmt.Sprintf("%s ( %s )", msg, strings.Join(msgToTask[msg], ", ")))
+	}
+	return fmt.Sprintf("kubetest: %d of %d tasks failed: %s", len(e.Failures), e.TaskNum, strings.Join(groups, "; "))
+}
+
+// Unwrap returns the errors of all failed tasks, so errors.Is and errors.As match any of them.
+func (e *TaskGroupError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		errs = append(errs, failure.Err)
+	}
+	return errs
+}
+
+// logSummary writes each failed task with the first line of its error.
+func (e *TaskGroupError) logSummary(logger Logger) {
+	logger.Error("%d of %d tasks failed", len(e.Failures), e.TaskNum)
+	for _, failure := range e.Failures {
+		reason, _, _ := strings.Cut(failure.Err.Error(), "\n")
+		logger.Error("- %s: %s", failure.Task, reason)
+	}
+}
+
+// cpuWeight returns the CPU request of the task in millicores to acquire from the CPU budget.
+// The task requesting more CPU than the budget acquires the whole budget, so it runs alone instead of waiting forever.
+func (g *TaskGroup) cpuWeight(ctx context.Context, task *Task) int64 {
+	if g.cpuBudget == nil {
+		return 0
+	}
+	cpuRequest := task.CPURequest()
+	request := cpuRequest.MilliValue()
+	budget := g.cpuBudget.MilliValue()
+	if request > budget {
+		LoggerFromContext(ctx).Warn(
+			"task %s requests %dm CPU over the budget %dm. run it alone",
+			task.Name, request, budget,
+		)
+		return budget
+	}
+	return request
+}
+
 type TaskResult struct {
-	groups []*SubTaskResultGroup
+	// task name of the task having the result ( see Task.displayName ).
+	task string
+	// shard index of the shard having the result ( see Task.shardIndex ).
+	shard int
+	// testTime wall time from starting the tests of the task until all of them finish.
+	testTime time.Duration
+	groups   []*SubTaskResultGroup
+	// finalizer result of the finalizer container. This is nil if the finalizer didn't run.
+	finalizer *FinalizerResult
+}
+
+// FinalizerResult result of the finalizer container of the task.
+type FinalizerResult struct {
+	Container string
+	// Out masked output of the finalizer.
+	Out []byte
+	Err error
+	// SetupErr error of the setup of the pod if the finalizer ran in degraded mode.
+	// In that case, the finalizer ran without the tests and the volumes which couldn't be prepared.
+	SetupErr error
+	// setupMessage masked message of SetupErr.
+	setupMessage string
+}
+
+// Finalizer returns the result of the finalizer container. If the finalizer didn't run, returns nil.
+func (r *TaskResult) Finalizer() *FinalizerResult {
+	return r.finalizer
 }
 
+// MainTaskResults returns the results of the tests. Only these results decide the status of the run.
 func (r *TaskResult) MainTaskResults() []*SubTaskResult {
-	mainResults := []*SubTaskResult{}
+	return r.filterResults(true)
+}
+
+// NonMainTaskResults returns the results of the containers other than the tests ( e.g. post containers ).
+// They are only for debugging and don't affect the status of the run.
+func (r *TaskResult) NonMainTaskResults() []*SubTaskResult {
+	return r.filterResults(false)
+}
+
+func (r *TaskResult) filterResults(isMain bool) []*SubTaskResult {
+	results := []*SubTaskResult{}
 	for _, group := range r.groups {
 		for _, result := range group.results {
-			if result.IsMain {
-				mainResults = append(mainResults, result)
+			if result.IsMain == isMain {
+				results = append(results, result)
 			}
 		}
 	}
-	return mainResults
+	return results
 }
 
 func (r *TaskResult) add(group *SubTaskResultGroup) {
@@ -268,7 +828,37 @@ func (r *TaskResult) add(group *SubTaskResultGroup) {
 type TaskResultGroup struct {
 	totalSubTaskNum int
 	results         []*TaskResult
-	mu              sync.Mutex
+	// failures tasks which failed to run. They have no results.
+	failures []*TaskFailure
+	mu       sync.Mutex
+}
+
+// Failures returns the tasks which failed to run without the results ( e.g. the pod couldn't start ).
+func (g *TaskResultGroup) Failures() []*TaskFailure {
+	return g.failures
+}
+
+// ReportTasks returns the status of each task. The tasks which ran are followed by the tasks which failed to run with the masked reason.
+// If no tasks failed to run, returns nil because the details of the report tell the status of all tasks.
+func (g *TaskResultGroup) ReportTasks(mask func(string) string) []ReportTask {
+	if len(g.failures) == 0 {
+		return nil
+	}
+	tasks := make([]ReportTask, 0, len(g.results)+len(g.failures))
+	for _, result := range g.results {
+		status := ResultStatus(ResultStatusSuccess)
+		for _, subTaskResult := range result.MainTaskResults() {
+			if subTaskResult.Status != TaskResultSuccess {
+				status = ResultStatusFailure
+				break
+			}
+		}
+		tasks = append(tasks, ReportTask{Name: result.task, Status: status})
+	}
+	for _, failure := range g.failures {
+		tasks = append(tasks, ReportTask{Name: failure.Task, Status: ResultStatusError, Error: mask(failure.Err.Error())})
+	}
+	return tasks
 }
 
 func (g *TaskResultGroup) TotalNum() int {
@@ -278,11 +868,9 @@ func (g *TaskResultGroup) TotalNum() int {
 func (g *TaskResultGroup) SuccessNum() int {
 	successNum := 0
 	for _, result := range g.results {
-		for _, group := range result.groups {
-			for _, subTaskResult := range group.results {
-				if subTaskResult.Status == TaskResultSuccess {
-					successNum++
-				}
+		for _, subTaskResult := range result.MainTaskResults() {
+			if subTaskResult.Status == TaskResultSuccess {
+				successNum++
 			}
 		}
 	}
@@ -292,44 +880,227 @@ func (g *TaskResultGroup) SuccessNum() int {
 func (g *TaskResultGroup) FailureNum() int {
 	failureNum := 0
 	for _, result := range g.results {
-		for _, group := range result.groups {
-			for _, subTaskResult := range group.results {
-				if subTaskResult.Status == TaskResultFailure {
-					failureNum++
-				}
+		for _, subTaskResult := range result.MainTaskResults() {
+			if subTaskResult.Status == TaskResultFailure {
+				failureNum++
 			}
 		}
 	}
 	return failureNum
 }
 
+// InternalErrorShardNum returns the number of the tasks ( shards ) having any result failed by internal errors.
+// The tasks which failed to run without the results ( e.g. the pod couldn't start ) are also counted,
+// except for the ones canceled by the interruption of the run.
+func (g *TaskResultGroup) InternalErrorShardNum() int {
+	shardNum := 0
+	for _, result := range g.results {
+		if result.hasInternalError() {
+			shardNum++
+		}
+	}
+	for _, failure := range g.failures {
+		if !errors.Is(failure.Err, context.Canceled) {
+			shardNum++
+		}
+	}
+	return shardNum
+}
+
+// shardNum returns the number of the tasks including the ones which failed to run.
+func (g *TaskResultGroup) shardNum() int {
+	return len(g.results) + len(g.failures)
+}
+
+func (r *TaskResult) hasInternalError() bool {
+	for _, subTaskResult := range r.MainTaskResults() {
+		if subTaskResult.IsInternalError() {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the status decided by the results of the tests. The results of the other containers are ignored.
 func (g *TaskResultGroup) Status() ResultStatus {
 	for _, result := range g.results {
-		for _, group := range result.groups {
-			for _, subTaskResult := range group.results {
-				if err := subTaskResult.Error(); err != nil {
-					return ResultStatusFailure
-				}
+		for _, subTaskResult := range result.MainTaskResults() {
+			if err := subTaskResult.Error(); err != nil {
+				return ResultStatusFailure
 			}
 		}
 	}
 	return ResultStatusSuccess
 }
 
+// ToReportDetails returns the details of the tests.
 func (g *TaskResultGroup) ToReportDetails() []*ReportDetail {
 	details := make([]*ReportDetail, 0, g.TotalNum())
+	for _, result := range g.results {
+		details = append(details, result.toReportDetails()...)
+	}
+	return details
+}
+
+// NonMainResults returns the results of the containers other than the tests ( e.g. post containers ).
+func (g *TaskResultGroup) NonMainResults() []*SubTaskResult {
+	results := []*SubTaskResult{}
+	for _, result := range g.results {
+		results = append(results, result.NonMainTaskResults()...)
+	}
+	return results
+}
+
+// ToAuxiliaryReportDetails returns the details of the containers other than the tests for debugging.
+func (g *TaskResultGroup) ToAuxiliaryReportDetails() []*ReportDetail {
+	var details []*ReportDetail
+	for _, subTaskResult := range g.NonMainResults() {
+		details = append(details, newReportDetail(subTaskResult))
+	}
+	return details
+}
+
+func (r *TaskResult) toReportDetails() []*ReportDetail {
+	details := []*ReportDetail{}
+	for _, subTaskResult := range r.MainTaskResults() {
+		details = append(details, newReportDetail(subTaskResult))
+	}
+	return details
+}
+
+func newReportDetail(result *SubTaskResult) *ReportDetail {
+	return &ReportDetail{
+		Status:         result.Status.ToResultStatus(),
+		Name:           result.Name,
+		ElapsedTimeSec: int64(result.ElapsedTime.Seconds()),
+		FailureKind:    result.FailureKind,
+		Metadata:       result.Metadata,
+		Phase:          result.Phase,
+		Message:        result.phaseMessage(),
+	}
+}
+
+// ReportFinalizers returns the results of the finalizers of the tasks.
+// The output is recorded only if the finalizer failed or ran in degraded mode, to keep the report small.
+func (g *TaskResultGroup) ReportFinalizers() []ReportFinalizer {
+	var finalizers []ReportFinalizer
+	for _, result := range g.results {
+		finalizer := result.finalizer
+		if finalizer == nil {
+			continue
+		}
+		reportFinalizer := ReportFinalizer{
+			Container: finalizer.Container,
+			Status:    ResultStatusSuccess,
+			Degraded:  finalizer.SetupErr != nil,
+		}
+		if finalizer.Err != nil {
+			reportFinalizer.Status = ResultStatusFailure
+		}
+		if finalizer.SetupErr != nil {
+			reportFinalizer.Reason = finalizer.setupMessage
+		}
+		if reportFinalizer.Degraded || finalizer.Err != nil {
+			reportFinalizer.Output = string(finalizer.Out)
+		}
+		finalizers = append(finalizers, reportFinalizer)
+	}
+	return finalizers
+}
+
+// ShardBalance returns the wall time running the tests of each shard and the imbalance between them.
+// The wall time is measured from starting the tests of the shard until all of them finish, so the tests running
+// concurrently in the pod aren't counted twice, and the setup of the pod ( e.g. the init containers and extracting
+// the repositories ), the post containers and the finalizer aren't included.
+// The estimate of each shard is the total wall time distributed in proportion to the number of tests of the shard,
+// because the scheduler distributes the tests assuming that they take the same time.
+// The tests failed before running ( e.g. by the setup failure ) aren't counted.
+// Shards are sorted in descending order of duration. If the number of tasks is less than two, returns nil.
+func (g *TaskResultGroup) ShardBalance() *ReportShardBalance {
+	if len(g.results) < 2 {
+		return nil
+	}
+	var (
+		totalTestNum int
+		totalTime    time.Duration
+	)
+	shards := make([]ReportShard, 0, len(g.results))
+	for _, result := range g.results {
+		var testNum int
+		for _, subTaskResult := range result.MainTaskResults() {
+			if subTaskResult.Phase != "" && subTaskResult.Phase != ContainerPhaseMain {
+				continue
+			}
+			testNum++
+		}
+		totalTestNum += testNum
+		totalTime += result.testTime
+		shards = append(shards, ReportShard{
+			Index:          result.shard,
+			TestNum:        testNum,
+			ElapsedTimeSec: result.testTime.Seconds(),
+		})
+	}
+	if totalTestNum > 0 {
+		for idx := range shards {
+			shards[idx].EstimatedTimeSec = totalTime.Seconds() * float64(shards[idx].TestNum) / float64(totalTestNum)
+		}
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		if shards[i].ElapsedTimeSec != shards[j].ElapsedTimeSec {
+			return shards[i].ElapsedTimeSec > shards[j].ElapsedTimeSec
+		}
+		return shards[i].Index < shards[j].Index
+	})
+	balance := &ReportShardBalance{Shards: shards}
+	if shortest := shards[len(shards)-1].ElapsedTimeSec; shortest > 0 {
+		balance.ImbalanceRatio = shards[0].ElapsedTimeSec / shortest
+	}
+	return balance
+}
+
+// FailedMainResults returns the results of failed tests.
+func (g *TaskResultGroup) FailedMainResults() []*SubTaskResult {
+	failedResults := []*SubTaskResult{}
+	for _, result := range g.results {
+		for _, subTaskResult := range result.MainTaskResults() {
+			if subTaskResult.Status == TaskResultFailure {
+				failedResults = append(failedResults, subTaskResult)
+			}
+		}
+	}
+	return failedResults
+}
+
+// replaceResults replaces the results of the same name tests by the results of retry.
+func (g *TaskResultGroup) replaceResults(retry *TaskResultGroup) {
+	nameToRetryResult := map[string]*SubTaskResult{}
+	for _, result := range retry.results {
+		for _, subTaskResult := range result.MainTaskResults() {
+			nameToRetryResult[subTaskResult.Name] = subTaskResult
+		}
+	}
 	for _, result := range g.results {
 		for _, group := range result.groups {
-			for _, subTaskResult := range group.results {
-				details = append(details, &ReportDetail{
-					Status:         subTaskResult.Status.ToResultStatus(),
-					Name:           subTaskResult.Name,
-					ElapsedTimeSec: int64(subTaskResult.ElapsedTime.Seconds()),
-				})
+			for idx, subTaskResult := range group.results {
+				if !subTaskResult.IsMain {
+					continue
+				}
+				if retryResult, exists := nameToRetryResult[subTaskResult.Name]; exists {
+					group.results[idx] = retryResult
+				}
 			}
 		}
 	}
-	return details
+}
+
+// merge adds the results of other to the head of g.
+func (g *TaskResultGroup) merge(other *TaskResultGroup) {
+	g.mu.Lock()
+	g.results = append(append([]*TaskResult{}, other.results...), g.results...)
+	g.failures = append(append([]*TaskFailure{}, other.failures...), g.failures...)
+	g.totalSubTaskNum += other.totalSubTaskNum
+	g.mu.Unlock()
 }
 
 func (g *TaskResultGroup) add(result *TaskResult) {
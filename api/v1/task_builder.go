@@ -6,20 +6,52 @@ package v1
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 const (
 	kubetestLabel  = "kubetest.io/testjob"
+	runIDLabel     = "kubetest.io/run"
 	keysAnnotation = "kubetest.io/strategyKeys"
+
+	// ulimitNoFileAnnotation maximum number of the open file descriptors of the processes of the pod.
+	// This is applied by kubetest in local mode, and is the hint for the container runtime in kubernetes mode.
+	ulimitNoFileAnnotation = "kubetest.io/ulimit-nofile"
+
+	// shardAnnotation index of the shard of the distributed pod.
+	shardAnnotation = "kubetest.io/shard"
+	// testsAnnotation comma separated names of the tests assigned to the distributed pod.
+	// This is truncated to maxTestsAnnotationLength for readability. keysAnnotation has all names.
+	testsAnnotation = "kubetest.io/tests"
+	// testCountAnnotation number of the tests assigned to the distributed pod.
+	testCountAnnotation      = "kubetest.io/test-count"
+	maxTestsAnnotationLength = 1024
+
+	defaultAntiAffinityTopologyKey = "kubernetes.io/hostname"
+
+	scratchVolumeName = "kubetest-scratch"
+	scratchEnvName    = "TMPDIR"
+
+	// compressedFileSuffix suffix of the log and report compressed by gzip.
+	compressedFileSuffix = ".gz"
+	// logPathEnvName environment variable having the path to the compressed log.
+	logPathEnvName = "KUBETEST_LOG_PATH"
+	// reportPathEnvName environment variable having the path to the compressed report.
+	reportPathEnvName = "KUBETEST_REPORT_PATH"
 )
 
 var (
@@ -30,26 +62,180 @@ var (
 )
 
 type TaskBuilder struct {
-	cfg       *rest.Config
-	mgr       *ResourceManager
-	namespace string
-	runMode   RunMode
+	cfg            *rest.Config
+	clientset      kubernetes.Interface
+	mgr            *ResourceManager
+	namespace      string
+	runMode        RunMode
+	ownerReference *metav1.OwnerReference
+	runID          string
+	envFrom        []corev1.EnvFromSource
+	copyRetry      CopyRetryPolicy
+	initLogLimit   int
+	imagePrefix    string
+	scratch        *ScratchSpec
+	containerCache *taskContainerCache
+	manifestWriter *manifestWriter
+	shardSummary   bool
+	debug          *debugHolder
+	keepResources  KeepResourcesPolicy
+	logLineLimit   LogLineLimit
+	nameGenerator  NameGenerator
+	idempotencyKey string
+	preInitReady   time.Duration
+	// zstdSupport whether tar supports zstd by the image of the container.
+	zstdSupport   map[string]bool
+	zstdSupportMu sync.Mutex
+	// onMainStepFinalizer called when the finalizer of the task of mainStep starts.
+	onMainStepFinalizer func()
 }
 
+// NameGenerator returns the name of the Job from base, the generateName of the template ( e.g. "testjob-" ).
+// It is called whenever the Job is built, including the retries, so it must return a unique name for each call.
+type NameGenerator func(base string) string
+
 func NewTaskBuilder(cfg *rest.Config, mgr *ResourceManager, namespace string, runMode RunMode) *TaskBuilder {
 	return &TaskBuilder{
-		cfg:       cfg,
-		mgr:       mgr,
-		namespace: namespace,
-		runMode:   runMode,
+		cfg:            cfg,
+		mgr:            mgr,
+		namespace:      namespace,
+		runMode:        runMode,
+		copyRetry:      defaultCopyRetryPolicy,
+		initLogLimit:   defaultInitLogLimit,
+		preInitReady:   defaultPreInitReadyTimeout,
+		containerCache: newTaskContainerCache(),
+		zstdSupport:    map[string]bool{},
 	}
 }
 
+// SetOwnerReference set the owner reference attached to every Job created by the built tasks.
+func (b *TaskBuilder) SetOwnerReference(ref *metav1.OwnerReference) {
+	b.ownerReference = ref
+}
+
+// SetNameGenerator set the function to name the Jobs whose template has generateName instead of the random suffix of kubernetes.
+// The generated name must be a DNS-1123 subdomain within 63 characters because it is also the label value of the pods.
+func (b *TaskBuilder) SetNameGenerator(generator NameGenerator) {
+	b.nameGenerator = generator
+}
+
+// SetRunID set the identifier of the run. It is attached to the Jobs and pods as label to distinguish them from the objects of the other runs.
+func (b *TaskBuilder) SetRunID(id string) {
+	b.runID = id
+}
+
+// SetClientset set the clientset shared by the Jobs built by the tasks.
+// It must be created from the config without timeout because it is also used to run the commands in the containers.
+// If it isn't set, each Job creates the clientset from the config passed to NewTaskBuilder.
+func (b *TaskBuilder) SetClientset(clientset kubernetes.Interface) {
+	b.clientset = clientset
+}
+
+// SetIdempotencyKey set the idempotency key of the run. It is attached to the Jobs as label to find the duplicate runs.
+func (b *TaskBuilder) SetIdempotencyKey(key string) {
+	b.idempotencyKey = key
+}
+
+// SetPreInitReadyTimeout set the time to wait until the preinit container copying the files to the pod is ready.
+func (b *TaskBuilder) SetPreInitReadyTimeout(timeout time.Duration) {
+	b.preInitReady = timeout
+}
+
+// SetEnvFrom set the sources of environment variables applied to all containers of the built tasks.
+func (b *TaskBuilder) SetEnvFrom(envFrom []corev1.EnvFromSource) {
+	b.envFrom = envFrom
+}
+
+// SetCopyRetryPolicy set the policy to retry copying files between local and the containers of the built tasks.
+func (b *TaskBuilder) SetCopyRetryPolicy(policy CopyRetryPolicy) {
+	b.copyRetry = policy
+}
+
+// SetInitLogLimit set the maximum bytes of the output of the failed init containers written to the log.
+// Only the tail of the output is kept.
+func (b *TaskBuilder) SetInitLogLimit(limit int) {
+	b.initLogLimit = limit
+}
+
+// SetLogLineLimit set the maximum number of lines of the output of each test written to the log by the result of the test.
+func (b *TaskBuilder) SetLogLineLimit(limit LogLineLimit) {
+	b.logLineLimit = limit
+}
+
+// SetShardSummary enables the summary line written when each shard of the strategy finishes.
+func (b *TaskBuilder) SetShardSummary(enabled bool) {
+	b.shardSummary = enabled
+}
+
+// SetImagePrefix set the prefix prepended to the images of all containers of the built tasks.
+func (b *TaskBuilder) SetImagePrefix(prefix string) {
+	b.imagePrefix = prefix
+}
+
+// SetScratch set the scratch volume mounted to all containers of the built tasks.
+func (b *TaskBuilder) SetScratch(scratch *ScratchSpec) {
+	b.scratch = scratch
+}
+
+// setDebugHolder set the holder of the pods of the failed tests of the built tasks for debugging.
+func (b *TaskBuilder) setDebugHolder(debug *debugHolder) {
+	b.debug = debug
+}
+
+// setOnMainStepFinalizer set the function called when the finalizer of the built task of mainStep starts.
+func (b *TaskBuilder) setOnMainStepFinalizer(fn func()) {
+	b.onMainStepFinalizer = fn
+}
+
+// SetKeepResources set when to keep the Jobs built by the builder after the run.
+// ttlSecondsAfterFinished of the step is removed from the Job unless policy is never.
+func (b *TaskBuilder) SetKeepResources(policy KeepResourcesPolicy) {
+	b.keepResources = policy
+}
+
+// SetManifestDir set the directory to write the manifests of the Jobs built by the tasks.
+// In kubernetes run mode, the Jobs are written when they are submitted, so the manifests have the labels, the init containers,
+// the finalizer and the command wrapper added by kubejob. In the other run modes, the Jobs built by the tasks are written as is.
+func (b *TaskBuilder) SetManifestDir(dir string) {
+	if dir == "" {
+		b.manifestWriter = nil
+		return
+	}
+	b.manifestWriter = newManifestWriter(dir)
+}
+
 func (b *TaskBuilder) Build(ctx context.Context, step Step) (*Task, error) {
 	return b.BuildWithKey(ctx, step, nil)
 }
 
 func (b *TaskBuilder) BuildWithKey(ctx context.Context, step Step, strategyKey *StrategyKey) (*Task, error) {
+	return b.build(ctx, step, strategyKey, defaultPendingTimeout)
+}
+
+// BuildWithPendingTimeout builds the task whose pod fails to run if it is pending longer than timeout.
+// The timeout is applied once by the wait of the job, so the task isn't retried when it's hit.
+func (b *TaskBuilder) BuildWithPendingTimeout(ctx context.Context, step Step, timeout time.Duration) (*Task, error) {
+	task, err := b.build(ctx, step, nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+	task.failOnPendingTimeout = true
+	return task, nil
+}
+
+// BuildTeardown builds the task running only the finalizer container of step.
+// This is used when the run failed before the tests of step ran, so the finalizer cleans up the resources anyway.
+// The finalizer runs in degraded mode with cause as the setup error.
+func (b *TaskBuilder) BuildTeardown(ctx context.Context, step Step, cause error) (*Task, error) {
+	task, err := b.build(ctx, step, nil, defaultPendingTimeout)
+	if err != nil {
+		return nil, err
+	}
+	task.teardownCause = cause
+	return task, nil
+}
+
+func (b *TaskBuilder) build(ctx context.Context, step Step, strategyKey *StrategyKey, pendingTimeout time.Duration) (*Task, error) {
 	tmpl := step.GetTemplate()
 	mainContainer, err := getMainContainerFromTmpl(tmpl)
 	if err != nil {
@@ -59,7 +245,7 @@ func (b *TaskBuilder) BuildWithKey(ctx context.Context, step Step, strategyKey *
 		return nil, fmt.Errorf("kubetest: main container name must be specified")
 	}
 	createJob := func(ctx context.Context) (Job, error) {
-		return b.buildJob(ctx, mainContainer, step, tmpl, strategyKey)
+		return b.buildJob(ctx, mainContainer, step, tmpl, strategyKey, pendingTimeout)
 	}
 	job, err := createJob(ctx)
 	if err != nil {
@@ -71,6 +257,19 @@ func (b *TaskBuilder) BuildWithKey(ctx context.Context, step Step, strategyKey *
 		artifactMap[artifact.Container.Name] = append(artifactMap[artifact.Container.Name], artifact)
 	}
 	b.mgr.artifactMgr.AddArtifacts(spec.Artifacts)
+	if mainStep, ok := step.(*MainStep); ok && mainStep.Coverage != nil {
+		// the coverage profile is copied like the artifact of the main container, and merged when it is used.
+		if err := b.mgr.artifactMgr.AddCoverage(*mainStep.Coverage); err != nil {
+			return nil, err
+		}
+		artifactMap[mainContainer.Name] = append(artifactMap[mainContainer.Name], ArtifactSpec{
+			Name: mainStep.Coverage.Name,
+			Container: ArtifactContainer{
+				Name: mainContainer.Name,
+				Path: mainStep.Coverage.Path,
+			},
+		})
+	}
 	copyArtifact := func(ctx context.Context, subtask *SubTask) error {
 		if b.runMode == RunModeDryRun {
 			return nil
@@ -85,11 +284,15 @@ func (b *TaskBuilder) BuildWithKey(ctx context.Context, step Step, strategyKey *
 		if !exists {
 			return nil
 		}
+		var notFoundErrs []error
 		for _, artifact := range artifacts {
 			localPath, err := b.mgr.ArtifactPathByNameAndContainerName(artifact.Name, subtask.exec.Container().Name)
 			if err != nil {
 				return err
 			}
+			if err := b.mgr.PrepareArtifactCopy(artifact.Name, subtask.exec.Container().Name); err != nil {
+				return err
+			}
 			if mainContainer.Agent != nil {
 				// artifact.Container.Path and localPath has same Base name.
 				// If enabled kubetest-agent, try to copy artifacts via normal copy method.
@@ -101,34 +304,93 @@ func (b *TaskBuilder) BuildWithKey(ctx context.Context, step Step, strategyKey *
 				artifact.Container.Path,
 				localPath,
 			); err != nil {
+				if isNotExistCopyError(err) {
+					// copy the remaining artifacts because they are useful to investigate the failed test.
+					notFoundErrs = append(notFoundErrs, fmt.Errorf(
+						"kubetest: %w: %s ( %s ): %w", errArtifactNotFound, artifact.Name, artifact.Container.Path, err,
+					))
+					continue
+				}
+				return err
+			}
+			if err := b.mgr.DeduplicateArtifact(ctx, artifact.Name, subtask.exec.Container().Name); err != nil {
 				return err
 			}
 		}
-		return nil
+		return errors.Join(notFoundErrs...)
 	}
 	var onFinishSubTask func(*SubTask)
 	if strategyKey != nil {
 		onFinishSubTask = strategyKey.OnFinishSubTask
 	}
+	var (
+		onFailureCommand []string
+		commandWrapper   []string
+		emptyOutput      EmptyOutputPolicy
+		stopGracePeriod  time.Duration
+		startJitter      time.Duration
+		onFinalizer      func()
+	)
+	if mainStep, ok := step.(*MainStep); ok {
+		onFinalizer = b.onMainStepFinalizer
+		onFailureCommand = mainStep.OnFailureCommand
+		commandWrapper = mainStep.CommandWrapper
+		emptyOutput = mainStep.EmptyOutput
+		if mainStep.StopGracePeriod != "" {
+			stopGracePeriod, err = time.ParseDuration(mainStep.StopGracePeriod)
+			if err != nil {
+				return nil, fmt.Errorf("kubetest: failed to parse stopGracePeriod: %w", err)
+			}
+		}
+		if mainStep.Strategy != nil && mainStep.Strategy.Scheduler.SubTaskStartJitter != "" {
+			startJitter, err = time.ParseDuration(mainStep.Strategy.Scheduler.SubTaskStartJitter)
+			if err != nil {
+				return nil, fmt.Errorf("kubetest: failed to parse subTaskStartJitter: %w", err)
+			}
+		}
+	}
+	postContainerNames := map[string]struct{}{}
+	for _, container := range spec.Containers {
+		if container.Role == ContainerRolePost {
+			postContainerNames[container.Name] = struct{}{}
+		}
+	}
 	return &Task{
-		Name:              step.GetName(),
-		OnFinishSubTask:   onFinishSubTask,
-		job:               job,
-		copyArtifact:      copyArtifact,
-		strategyKey:       strategyKey,
-		mainContainerName: mainContainer.Name,
-		createJob:         createJob,
+		Name:                 step.GetName(),
+		OnFinishSubTask:      onFinishSubTask,
+		job:                  job,
+		copyArtifact:         copyArtifact,
+		strategyKey:          strategyKey,
+		mainContainerName:    mainContainer.Name,
+		postContainerNames:   postContainerNames,
+		onFailureCommand:     onFailureCommand,
+		stopGracePeriod:      stopGracePeriod,
+		startJitter:          startJitter,
+		commandWrapper:       commandWrapper,
+		emptyOutput:          emptyOutput,
+		finalizerVerdictMode: spec.FinalizerVerdictMode,
+		onFinalizer:          onFinalizer,
+		shardSummary:         b.shardSummary && strategyKey != nil,
+		createJob:            createJob,
+		debug:                b.debug,
+		logLineLimit:         b.logLineLimit,
 	}, nil
 }
 
-func (b *TaskBuilder) buildJob(ctx context.Context, mainContainer TestJobContainer, step Step, tmpl TestJobTemplateSpec, strategyKey *StrategyKey) (Job, error) {
+func (b *TaskBuilder) buildJob(ctx context.Context, mainContainer TestJobContainer, step Step, tmpl TestJobTemplateSpec, strategyKey *StrategyKey, pendingTimeout time.Duration) (Job, error) {
 	spec := *tmpl.Spec.DeepCopy()
+	if mainStep, ok := step.(*MainStep); ok {
+		b.addCommandWrapper(&spec, &mainContainer, mainStep.CommandWrapper)
+	}
 	b.addContainersByStrategyKey(&spec, mainContainer, strategyKey)
-	buildCtx := &TaskBuildContext{
-		initContainers:      newTaskContainerGroup(spec.InitContainers, spec.Volumes),
-		containers:          newTaskContainerGroup(spec.Containers, spec.Volumes),
-		finalizerContainers: newTaskContainerGroup([]TestJobContainer{spec.FinalizerContainer}, spec.Volumes),
-		spec:                spec,
+	b.addEnvFrom(&spec)
+	b.addImagePrefix(&spec)
+	b.addScratch(&spec)
+	b.addFinalizerVerdictEnv(&spec)
+	b.addCompressedFileEnv(&spec)
+	buildCtx, err := b.newBuildContext(ctx, spec)
+	if err != nil {
+		return nil, err
 	}
 	podSpec := buildCtx.podSpec()
 	podMeta := tmpl.ObjectMeta
@@ -137,6 +399,19 @@ func (b *TaskBuilder) buildJob(ctx context.Context, mainContainer TestJobContain
 		labels[k] = v
 	}
 	labels[kubetestLabel] = fmt.Sprint(true)
+	if b.runID != "" {
+		labels[runIDLabel] = b.runID
+	}
+	namespace := b.namespace
+	if strategyKey != nil {
+		if mainStep, ok := step.(*MainStep); ok && mainStep.Strategy != nil {
+			scheduler := mainStep.Strategy.Scheduler
+			namespace = shardNamespace(b.namespace, scheduler.Namespaces, strategyKey.ConcurrentIdx)
+			if scheduler.AntiAffinity != nil {
+				podSpec.Affinity = b.shardAntiAffinity(podSpec.Affinity, scheduler.AntiAffinity, scheduler.Namespaces)
+			}
+		}
+	}
 	annotations := map[string]string{}
 	for k, v := range podMeta.Annotations {
 		annotations[k] = v
@@ -147,23 +422,91 @@ func (b *TaskBuilder) buildJob(ctx context.Context, mainContainer TestJobContain
 			return nil, fmt.Errorf("kubetest: failed to encode strategy keys: %w", err)
 		}
 		annotations[keysAnnotation] = string(keys)
+		annotations[shardAnnotation] = strconv.FormatUint(uint64(strategyKey.ConcurrentIdx), 10)
+		annotations[testsAnnotation] = testsSummary(strategyKey.Keys, maxTestsAnnotationLength)
+		annotations[testCountAnnotation] = strconv.Itoa(len(strategyKey.Keys))
+	}
+	if spec.Ulimits != nil && spec.Ulimits.NoFile != nil {
+		annotations[ulimitNoFileAnnotation] = strconv.FormatInt(*spec.Ulimits.NoFile, 10)
 	}
 	podMeta.Labels = labels
 	podMeta.Annotations = annotations
-	jobBuilder := NewJobBuilder(b.cfg, b.namespace, b.runMode)
+	jobMeta := *tmpl.ObjectMeta.DeepCopy()
+	if namespace != b.namespace {
+		// make the namespace of the shard visible in the manifests.
+		jobMeta.Namespace = namespace
+	}
+	if b.nameGenerator != nil && jobMeta.Name == "" && jobMeta.GenerateName != "" {
+		name, err := generateJobName(b.nameGenerator, jobMeta.GenerateName)
+		if err != nil {
+			return nil, err
+		}
+		jobMeta.Name = name
+		jobMeta.GenerateName = ""
+	}
+	// the Job of the shard in the other namespace is deleted by the runner after the run.
+	if ownerReference := ownerReferenceIn(namespace, b.namespace, b.ownerReference); ownerReference != nil {
+		jobMeta.OwnerReferences = append(jobMeta.OwnerReferences, *ownerReference)
+	}
+	if b.runID != "" {
+		if jobMeta.Labels == nil {
+			jobMeta.Labels = map[string]string{}
+		}
+		jobMeta.Labels[runIDLabel] = b.runID
+	}
+	if b.idempotencyKey != "" {
+		if jobMeta.Labels == nil {
+			jobMeta.Labels = map[string]string{}
+		}
+		jobMeta.Labels[idempotencyKeyLabel] = b.idempotencyKey
+	}
+	jobBuilder := NewJobBuilder(b.cfg, namespace, b.runMode)
+	jobBuilder.SetClientset(b.clientset)
+	jobBuilder.SetCopyRetryPolicy(b.copyRetry)
+	jobBuilder.SetInitLogLimit(b.initLogLimit)
+	jobBuilder.SetPreInitReadyTimeout(b.preInitReady)
+	jobBuilder.SetPendingTimeout(pendingTimeout)
+	if b.mgr != nil {
+		jobBuilder.SetWorkDir(b.mgr.WorkDir())
+	}
 	if spec.FinalizerContainer.Name != "" {
 		jobBuilder.SetFinalizer(&spec.FinalizerContainer.Container)
+		if spec.FinalizerPriorityClassName != "" {
+			// priority is resolved from priorityClassName by kubernetes, so the priority of the template must not remain.
+			podSpec.PriorityClassName = spec.FinalizerPriorityClassName
+			podSpec.Priority = nil
+		}
 	}
-	job, err := jobBuilder.BuildWithJob(&batchv1.Job{
-		ObjectMeta: tmpl.ObjectMeta,
+	jobSpec := &batchv1.Job{
+		ObjectMeta: jobMeta,
 		Spec: batchv1.JobSpec{
-			TTLSecondsAfterFinished: step.GetTTLSecondsAfterFinished(),
+			TTLSecondsAfterFinished: keepJobTTL(b.keepResources, &jobMeta, step.GetTTLSecondsAfterFinished()),
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: podMeta,
 				Spec:       podSpec,
 			},
 		},
-	}, buildCtx.containerNameToInstalledAgentPathMap(), mainContainer.Agent)
+	}
+	if b.manifestWriter != nil {
+		if b.runMode == RunModeKubernetes {
+			// the manifest is written from the Job completed by kubejob, so it's the object submitted to kubernetes.
+			jobBuilder.SetSubmitHandler(func(ctx context.Context, job *batchv1.Job) {
+				path, err := b.manifestWriter.write(job, step, strategyKey, b.runID)
+				if err != nil {
+					LoggerFromContext(ctx).Warn("failed to write job manifest: %s", err)
+					return
+				}
+				LoggerFromContext(ctx).Debug("wrote job manifest to %s", path)
+			})
+		} else {
+			path, err := b.manifestWriter.write(jobSpec, step, strategyKey, b.runID)
+			if err != nil {
+				return nil, err
+			}
+			LoggerFromContext(ctx).Debug("wrote job manifest to %s", path)
+		}
+	}
+	job, err := jobBuilder.BuildWithJob(jobSpec, buildCtx.containerNameToInstalledAgentPathMap(), mainContainer.Agent)
 	if err != nil {
 		return nil, err
 	}
@@ -180,25 +523,48 @@ func (b *TaskBuilder) buildJob(ctx context.Context, mainContainer TestJobContain
 		containerName := exec.Container().Name
 		taskContainer := buildCtx.taskContainer(containerName, isInitContainer)
 		if err := b.mountRepository(ctx, taskContainer, exec); err != nil {
-			return err
+			return setupError(containerName, "mounting repositories", err)
 		}
 		if err := b.mountToken(ctx, taskContainer, exec); err != nil {
-			return err
+			return setupError(containerName, "mounting tokens", err)
 		}
 		if err := b.mountArtifact(ctx, taskContainer, exec); err != nil {
-			return err
+			return setupError(containerName, "mounting artifacts", err)
 		}
 		if err := b.mountLog(ctx, taskContainer, exec); err != nil {
-			return err
+			return setupError(containerName, "mounting log", err)
 		}
 		if err := b.mountReport(ctx, taskContainer, exec); err != nil {
-			return err
+			return setupError(containerName, "mounting report", err)
 		}
 		return nil
 	})
 	return job, nil
 }
 
+// newBuildContext creates TaskBuildContext by reusing TaskContainer built from the same volume mounts and volumes.
+func (b *TaskBuilder) newBuildContext(ctx context.Context, spec TestJobPodSpec) (*TaskBuildContext, error) {
+	volumesKey, err := b.containerCache.volumesKey(spec.Volumes)
+	if err != nil {
+		return nil, err
+	}
+	initContainers, initHits := b.containerCache.containerGroup(spec.InitContainers, spec.Volumes, volumesKey)
+	containers, hits := b.containerCache.containerGroup(spec.Containers, spec.Volumes, volumesKey)
+	finalizerContainers, finalizerHits := b.containerCache.containerGroup([]TestJobContainer{spec.FinalizerContainer}, spec.Volumes, volumesKey)
+	if total := initHits + hits + finalizerHits; total != 0 {
+		LoggerFromContext(ctx).Debug(
+			"reuse build context of %d/%d containers",
+			total, len(spec.InitContainers)+len(spec.Containers)+1,
+		)
+	}
+	return &TaskBuildContext{
+		initContainers:      initContainers,
+		containers:          containers,
+		finalizerContainers: finalizerContainers,
+		spec:                spec,
+	}, nil
+}
+
 func (b *TaskBuilder) mountRepository(ctx context.Context, taskContainer *TaskContainer, exec JobExecutor) error {
 	containerName := exec.Container().Name
 	LoggerFromContext(ctx).Debug("mount repositories: %s", containerName)
@@ -207,6 +573,29 @@ func (b *TaskBuilder) mountRepository(ctx context.Context, taskContainer *TaskCo
 		if !exists {
 			return fmt.Errorf("kubetest: failed to find org mount path by %s", repoName)
 		}
+		archivePath, err := b.mgr.RepositoryPathByName(repoName)
+		if err != nil {
+			return err
+		}
+		compression := b.mgr.RepositoryArchiveCompression(repoName)
+		if compression == ArchiveCompressionZstd {
+			if !b.supportsZstd(ctx, exec) {
+				LoggerFromContext(ctx).Warn("tar of %s container doesn't support zstd. use gzip to mount %s repository", containerName, repoName)
+				gzipArchivePath, err := b.mgr.RepositoryGzipArchivePathByName(ctx, repoName)
+				if err != nil {
+					return err
+				}
+				if err := exec.CopyTo(ctx, gzipArchivePath, filepath.Join(archiveMountPath, filepath.Base(gzipArchivePath))); err != nil {
+					return setupError(
+						containerName,
+						fmt.Sprintf("copying repository '%s'", repoName),
+						fmt.Errorf("kubetest: failed to copy gzip archive of %s repository: %w", repoName, err),
+					)
+				}
+				archivePath = gzipArchivePath
+				compression = ArchiveCompressionGzip
+			}
+		}
 		cmd := []string{
 			// remove the mount point path if it already exists.
 			"rm", "-rf", orgMountPath,
@@ -215,20 +604,96 @@ func (b *TaskBuilder) mountRepository(ctx context.Context, taskContainer *TaskCo
 			"mkdir", "-p", orgMountPath,
 			"&&",
 			// extract the repository files under the mount point directory.
-			"tar", "-zxvf", filepath.Join(archiveMountPath, "repo.tar.gz"), "-C", orgMountPath,
 		}
+		cmd = append(cmd, extractArchiveCommand(compression)...)
+		cmd = append(cmd, filepath.Join(archiveMountPath, filepath.Base(archivePath)), "-C", orgMountPath)
 		LoggerFromContext(ctx).Debug(
 			"mount repository %s on %s by '%s'",
 			containerName, repoName, strings.Join(cmd, " "),
 		)
 		out, err := exec.PrepareCommand(ctx, cmd)
 		if err != nil {
-			return fmt.Errorf("kubetest: failed to mount repository. %s: %w", string(out), err)
+			return setupError(
+				containerName,
+				fmt.Sprintf("extracting repository '%s'", repoName),
+				fmt.Errorf("kubetest: failed to mount repository. %s: %w", string(out), err),
+			)
 		}
 	}
 	return nil
 }
 
+// extractArchiveCommand returns tar command to extract the archive compressed by compression.
+func extractArchiveCommand(compression ArchiveCompression) []string {
+	switch compression {
+	case ArchiveCompressionZstd:
+		return []string{"tar", "--zstd", "-xvf"}
+	case ArchiveCompressionNone:
+		return []string{"tar", "-xvf"}
+	}
+	return []string{"tar", "-zxvf"}
+}
+
+const (
+	zstdSupportedMarker   = "kubetest:zstd:supported"
+	zstdUnsupportedMarker = "kubetest:zstd:unsupported"
+)
+
+// zstdProbeCommand returns the command extracting the archive compressed by zstd in the container.
+// The archive is passed by printf, so the probe doesn't depend on the other commands ( e.g. zstd or base64 ).
+// The command prints the marker of the result instead of exiting with the status,
+// so the failure of running the command itself can be distinguished from the lack of support.
+func zstdProbeCommand() ([]string, error) {
+	archive, err := zstdProbeArchive()
+	if err != nil {
+		return nil, err
+	}
+	var format strings.Builder
+	for _, c := range archive {
+		fmt.Fprintf(&format, "\\%03o", c)
+	}
+	return []string{
+		"printf", shellQuote(format.String()), "|", "tar", "--zstd", "-tf", "-",
+		"&&", "echo", zstdSupportedMarker,
+		"||", "echo", zstdUnsupportedMarker,
+	}, nil
+}
+
+// supportsZstd probes whether tar of the container can extract the archive compressed by zstd.
+// GNU tar requires zstd command in addition to --zstd option, so the archive is actually extracted in the container.
+// The result is cached by the image, so the containers of the same image in the other pods aren't probed again.
+// If the probe itself fails ( e.g. the exec API is temporarily unavailable ), gzip is used for this container and the result isn't cached.
+func (b *TaskBuilder) supportsZstd(ctx context.Context, exec JobExecutor) bool {
+	image := exec.Container().Image
+	b.zstdSupportMu.Lock()
+	supported, exists := b.zstdSupport[image]
+	b.zstdSupportMu.Unlock()
+	if exists {
+		return supported
+	}
+	cmd, err := zstdProbeCommand()
+	if err != nil {
+		LoggerFromContext(ctx).Warn("failed to build zstd probe: %s", err)
+		return false
+	}
+	out, err := exec.PrepareCommand(ctx, cmd)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	switch marker := strings.TrimSpace(lines[len(lines)-1]); {
+	case err == nil && marker == zstdSupportedMarker:
+		supported = true
+	case err == nil && marker == zstdUnsupportedMarker:
+		LoggerFromContext(ctx).Debug("zstd is not supported by %s container: %s", exec.Container().Name, string(out))
+		supported = false
+	default:
+		LoggerFromContext(ctx).Warn("failed to probe zstd support of %s container: %v: %s", exec.Container().Name, err, string(out))
+		return false
+	}
+	b.zstdSupportMu.Lock()
+	b.zstdSupport[image] = supported
+	b.zstdSupportMu.Unlock()
+	return supported
+}
+
 func (b *TaskBuilder) mountToken(ctx context.Context, taskContainer *TaskContainer, exec JobExecutor) error {
 	containerName := exec.Container().Name
 	LoggerFromContext(ctx).Debug("mount tokens: %s", containerName)
@@ -256,6 +721,99 @@ func (b *TaskBuilder) mountToken(ctx context.Context, taskContainer *TaskContain
 	return nil
 }
 
+// testsSummary joins the names of the tests by comma up to limit bytes.
+// If some names don't fit in limit, the number of the omitted names is appended instead.
+func testsSummary(names []string, limit int) string {
+	var summary strings.Builder
+	for idx, name := range names {
+		sep := ""
+		if idx != 0 {
+			sep = ","
+		}
+		omitted := fmt.Sprintf("%s... ( %d more )", sep, len(names)-idx)
+		rest := 0
+		if idx != len(names)-1 {
+			// keep room for the omitted count of the following names.
+			rest = len(fmt.Sprintf(",... ( %d more )", len(names)-idx-1))
+		}
+		if summary.Len()+len(sep)+len(name)+rest > limit {
+			summary.WriteString(omitted)
+			break
+		}
+		summary.WriteString(sep)
+		summary.WriteString(name)
+	}
+	return summary.String()
+}
+
+// shardNamespace returns the namespace of the shard specified by idx. The shards are assigned to namespaces in round-robin.
+// If namespaces are not specified, returns defaultNamespace.
+func shardNamespace(defaultNamespace string, namespaces []string, idx uint32) string {
+	if len(namespaces) == 0 {
+		return defaultNamespace
+	}
+	return namespaces[idx%uint32(len(namespaces))]
+}
+
+// generateJobName generates the name of the Job from base by generator and validates it.
+func generateJobName(generator NameGenerator, base string) (string, error) {
+	name := generator(base)
+	errs := validation.IsDNS1123Subdomain(name)
+	if len(name) > validation.LabelValueMaxLength {
+		errs = append(errs, validation.MaxLenError(validation.LabelValueMaxLength))
+	}
+	if len(errs) != 0 {
+		return "", fmt.Errorf("kubetest: invalid job name %q generated from %q: %s", name, base, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// shardAntiAffinity adds the pod anti-affinity against the pods of the same run to affinity.
+// If the shards are spread over namespaces, the anti-affinity is applied across them.
+func (b *TaskBuilder) shardAntiAffinity(affinity *corev1.Affinity, spec *ShardAntiAffinity, namespaces []string) *corev1.Affinity {
+	if b.runID == "" {
+		return affinity
+	}
+	topologyKey := spec.TopologyKey
+	if topologyKey == "" {
+		topologyKey = defaultAntiAffinityTopologyKey
+	}
+	term := corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				kubetestLabel: fmt.Sprint(true),
+				runIDLabel:    b.runID,
+			},
+		},
+		TopologyKey: topologyKey,
+	}
+	if len(namespaces) != 0 {
+		// the term matches only the pods in the same namespace by default, so the shards in the other namespaces must be listed.
+		term.Namespaces = append([]string{}, namespaces...)
+	}
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	} else {
+		affinity = affinity.DeepCopy()
+	}
+	if affinity.PodAntiAffinity == nil {
+		affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+	}
+	antiAffinity := affinity.PodAntiAffinity
+	if spec.Required {
+		antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(
+			antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+			term,
+		)
+	} else {
+		antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+			antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+			corev1.WeightedPodAffinityTerm{Weight: 100, PodAffinityTerm: term},
+		)
+	}
+	return affinity
+}
+
 func (b *TaskBuilder) mountArtifact(ctx context.Context, taskContainer *TaskContainer, exec JobExecutor) error {
 	containerName := exec.Container().Name
 	LoggerFromContext(ctx).Debug("mount artifacts: %s", containerName)
@@ -279,8 +837,15 @@ func (b *TaskBuilder) mountArtifact(ctx context.Context, taskContainer *TaskCont
 			// remove the mount point path if it already exists.
 			"rm", "-rf", orgMountPath,
 			"&&",
-			// copy artifacts to the mount point path.
-			"cp", "-rf", filepath.Join(mountPath, fileName), orgMountPath,
+			// copy artifacts to the mount point path with the permissions ( e.g. the executable bit of the test binary ).
+			"cp", "-rfp", filepath.Join(mountPath, fileName), orgMountPath,
+		}
+		if mode, exists := taskContainer.artifactNameToMode[artifactName]; exists {
+			cmd = append(cmd,
+				"&&",
+				// change the permissions of the files. directories keep the permissions to be traversed.
+				"find", orgMountPath, "-type", "f", "-exec", "chmod", fmt.Sprintf("%o", mode), "{}", "+",
+			)
 		}
 		LoggerFromContext(ctx).Debug(
 			"mount artifact %s on %s by '%s'",
@@ -297,13 +862,14 @@ func (b *TaskBuilder) mountArtifact(ctx context.Context, taskContainer *TaskCont
 func (b *TaskBuilder) mountLog(ctx context.Context, taskContainer *TaskContainer, exec JobExecutor) error {
 	containerName := exec.Container().Name
 	LoggerFromContext(ctx).Debug("mount log: %s", containerName)
-	for _, mountPath := range taskContainer.logOrgMountPaths {
+	for _, path := range taskContainer.logCopyPaths() {
+		src, mountPath := path[0], path[1]
 		cmd := []string{
 			// create mount point base directory if it doesn't exist.
 			"mkdir", "-p", filepath.Dir(mountPath),
 			"&&",
 			// copy log file to the mount point path.
-			"cp", logMountFilePath, mountPath,
+			"cp", src, mountPath,
 		}
 		LoggerFromContext(ctx).Debug(
 			"mount log on %s by '%s'",
@@ -320,13 +886,14 @@ func (b *TaskBuilder) mountLog(ctx context.Context, taskContainer *TaskContainer
 func (b *TaskBuilder) mountReport(ctx context.Context, taskContainer *TaskContainer, exec JobExecutor) error {
 	containerName := exec.Container().Name
 	LoggerFromContext(ctx).Debug("mount report: %s", containerName)
-	for _, mountPath := range taskContainer.reportOrgMountPaths {
+	for _, path := range taskContainer.reportCopyPaths() {
+		src, mountPath := path[0], path[1]
 		cmd := []string{
 			// create mount point base directory if it doesn't exist.
 			"mkdir", "-p", filepath.Dir(mountPath),
 			"&&",
 			// copy report file to the mount point path.
-			"cp", filepath.Join(reportMountPath, "report.json"), mountPath,
+			"cp", src, mountPath,
 		}
 		LoggerFromContext(ctx).Debug(
 			"mount report on %s by '%s'",
@@ -347,11 +914,21 @@ func (b *TaskBuilder) addContainersByStrategyKey(podSpec *TestJobPodSpec, mainCo
 	containers := []TestJobContainer{}
 	for idx, key := range strategyKey.Keys {
 		container := *mainContainer.DeepCopy()
-		container.Name += fmt.Sprintf("%d-%d", strategyKey.ConcurrentIdx, idx)
+		container.Name = strategyKeyContainerName(mainContainer.Name, strategyKey.ConcurrentIdx, idx)
+		// the key renamed by strategy.key.duplicates isn't the name of the test, so the container runs the original key.
+		originalKey := strategyKey.originalKey(key)
+		if image, exists := strategyKey.Images[originalKey]; exists {
+			container.Image = image
+		}
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  strategyKey.Env,
-			Value: key,
+			Value: originalKey,
 		})
+		if strategyKey.SubTaskScheduler != nil {
+			if env, exists := strategyKey.SubTaskScheduler.AllocatedEnv(len(strategyKey.Keys), idx); exists {
+				container.Env = append(container.Env, env)
+			}
+		}
 		containers = append(containers, container)
 	}
 	sideCarContainers := []TestJobContainer{}
@@ -364,8 +941,151 @@ func (b *TaskBuilder) addContainersByStrategyKey(podSpec *TestJobPodSpec, mainCo
 	podSpec.Containers = append(sideCarContainers, containers...)
 }
 
+// addCommandWrapper prefixes the command of the main container with wrapper.
+// The containers created by the strategy key are copied from the main container, so they are also wrapped.
+func (b *TaskBuilder) addCommandWrapper(podSpec *TestJobPodSpec, mainContainer *TestJobContainer, wrapper []string) {
+	if len(wrapper) == 0 {
+		return
+	}
+	mainContainer.Command = append(append([]string{}, wrapper...), mainContainer.Command...)
+	for idx := range podSpec.Containers {
+		if podSpec.Containers[idx].Name == mainContainer.Name {
+			podSpec.Containers[idx].Command = mainContainer.Command
+		}
+	}
+}
+
+// addEnvFrom prepends the common sources of environment variables to all containers.
+// The sources specified later take precedence, so the sources of each container override the common ones.
+func (b *TaskBuilder) addEnvFrom(podSpec *TestJobPodSpec) {
+	if len(b.envFrom) == 0 {
+		return
+	}
+	for idx := range podSpec.InitContainers {
+		container := &podSpec.InitContainers[idx]
+		container.EnvFrom = append(append([]corev1.EnvFromSource{}, b.envFrom...), container.EnvFrom...)
+	}
+	for idx := range podSpec.Containers {
+		container := &podSpec.Containers[idx]
+		container.EnvFrom = append(append([]corev1.EnvFromSource{}, b.envFrom...), container.EnvFrom...)
+	}
+}
+
+func (b *TaskBuilder) addImagePrefix(podSpec *TestJobPodSpec) {
+	if b.imagePrefix == "" {
+		return
+	}
+	for idx := range podSpec.InitContainers {
+		podSpec.InitContainers[idx].Image = prefixedImage(b.imagePrefix, podSpec.InitContainers[idx].Image)
+	}
+	for idx := range podSpec.Containers {
+		podSpec.Containers[idx].Image = prefixedImage(b.imagePrefix, podSpec.Containers[idx].Image)
+	}
+	podSpec.FinalizerContainer.Image = prefixedImage(b.imagePrefix, podSpec.FinalizerContainer.Image)
+}
+
+// addScratch adds the scratch volume to the pod and mounts it to the init, main and finalizer containers.
+// This is called after the containers are copied by the strategy key, so the copied containers also mount it.
+func (b *TaskBuilder) addScratch(podSpec *TestJobPodSpec) {
+	if b.scratch == nil {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, TestJobVolume{
+		Name: scratchVolumeName,
+		TestJobVolumeSource: TestJobVolumeSource{
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{
+					Medium:    b.scratch.Medium,
+					SizeLimit: b.scratch.SizeLimit,
+				},
+			},
+		},
+	})
+	for idx := range podSpec.InitContainers {
+		b.mountScratch(&podSpec.InitContainers[idx])
+	}
+	for idx := range podSpec.Containers {
+		b.mountScratch(&podSpec.Containers[idx])
+	}
+	if podSpec.FinalizerContainer.Name != "" {
+		b.mountScratch(&podSpec.FinalizerContainer)
+	}
+}
+
+// mountScratch mounts the scratch volume to the container and points TMPDIR to it unless TMPDIR is already specified.
+func (b *TaskBuilder) mountScratch(container *TestJobContainer) {
+	if b.scratch == nil {
+		return
+	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      scratchVolumeName,
+		MountPath: b.scratch.MountPath,
+	})
+	for _, env := range container.Env {
+		if env.Name == scratchEnvName {
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name:  scratchEnvName,
+		Value: b.scratch.MountPath,
+	})
+}
+
+// addFinalizerVerdictEnv tells the finalizer container the path to write the verdict.
+func (b *TaskBuilder) addFinalizerVerdictEnv(podSpec *TestJobPodSpec) {
+	if podSpec.FinalizerVerdictMode == "" || podSpec.FinalizerContainer.Name == "" {
+		return
+	}
+	podSpec.FinalizerContainer.Env = append(podSpec.FinalizerContainer.Env, corev1.EnvVar{
+		Name:  finalizerVerdictEnv,
+		Value: finalizerVerdictPath,
+	})
+}
+
+// addCompressedFileEnv tells the containers mounting the compressed log or report the path to the file,
+// because the mount path has .gz suffix. If the container mounts it multiple times, the first mount path is used.
+func (b *TaskBuilder) addCompressedFileEnv(podSpec *TestJobPodSpec) {
+	compressedEnvNames := map[string]string{}
+	for _, volume := range podSpec.Volumes {
+		switch {
+		case volume.Log != nil && volume.Log.Compress:
+			compressedEnvNames[volume.Name] = logPathEnvName
+		case volume.Report != nil && volume.Report.Compress:
+			compressedEnvNames[volume.Name] = reportPathEnvName
+		}
+	}
+	if len(compressedEnvNames) == 0 {
+		return
+	}
+	addEnv := func(container *TestJobContainer) {
+		addedEnvNames := map[string]struct{}{}
+		for _, vm := range container.VolumeMounts {
+			envName, exists := compressedEnvNames[vm.Name]
+			if !exists {
+				continue
+			}
+			if _, added := addedEnvNames[envName]; added {
+				continue
+			}
+			addedEnvNames[envName] = struct{}{}
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  envName,
+				Value: vm.MountPath + compressedFileSuffix,
+			})
+		}
+	}
+	for idx := range podSpec.InitContainers {
+		addEnv(&podSpec.InitContainers[idx])
+	}
+	for idx := range podSpec.Containers {
+		addEnv(&podSpec.Containers[idx])
+	}
+	addEnv(&podSpec.FinalizerContainer)
+}
+
 func (b *TaskBuilder) preInitContainer(buildCtx *TaskBuildContext) TestJobContainer {
-	return TestJobContainer{
+	container := TestJobContainer{
 		Container: corev1.Container{
 			Name:            "preinit",
 			Image:           buildCtx.preInitImage(),
@@ -375,6 +1095,9 @@ func (b *TaskBuilder) preInitContainer(buildCtx *TaskBuildContext) TestJobContai
 			ImagePullPolicy: buildCtx.preInitImagePullPolicy(),
 		},
 	}
+	// the preinit container is also an init container of the pod, so the copies to it spill into the scratch volume.
+	b.mountScratch(&container)
+	return container
 }
 
 func (b *TaskBuilder) preInitCallback(ctx context.Context, buildCtx *TaskBuildContext) (PreInitCallback, error) {
@@ -488,6 +1211,13 @@ func (b *TaskBuilder) getCopyPathForLog(ctx context.Context, buildCtx *TaskBuild
 		}
 		cb(logPath, logMountFilePath)
 	}
+	if buildCtx.isUsedCompressedLogVolume() {
+		logPath, err := b.mgr.CompressedLogPath()
+		if err != nil {
+			return err
+		}
+		cb(logPath, logMountFilePath+compressedFileSuffix)
+	}
 	return nil
 }
 
@@ -502,6 +1232,13 @@ func (b *TaskBuilder) getCopyPathForReport(ctx context.Context, buildCtx *TaskBu
 		}
 		cb(reportPath, filepath.Join(reportMountPath, filepath.Base(reportPath)))
 	}
+	if buildCtx.isUsedCompressedReportVolume() {
+		reportPath, err := b.mgr.CompressedReportPath(ReportFormatTypeJSON)
+		if err != nil {
+			return err
+		}
+		cb(reportPath, filepath.Join(reportMountPath, filepath.Base(reportPath)))
+	}
 	return nil
 }
 
@@ -538,38 +1275,36 @@ func (c *TaskBuildContext) containerNameToInstalledAgentPathMap() map[string]str
 }
 
 func (c *TaskBuildContext) isUsedLogVolume() bool {
-	for _, container := range c.initContainers.containerMap {
-		if len(container.logOrgMountPaths) != 0 {
-			return true
-		}
-	}
-	for _, container := range c.containers.containerMap {
-		if len(container.logOrgMountPaths) != 0 {
-			return true
-		}
-	}
-	for _, container := range c.finalizerContainers.containerMap {
-		if len(container.logOrgMountPaths) != 0 {
-			return true
-		}
-	}
-	return false
+	return c.anyContainer(func(container *TaskContainer) bool {
+		return len(container.logOrgMountPaths) != 0
+	})
+}
+
+func (c *TaskBuildContext) isUsedCompressedLogVolume() bool {
+	return c.anyContainer(func(container *TaskContainer) bool {
+		return len(container.gzipLogOrgMountPaths) != 0
+	})
 }
 
 func (c *TaskBuildContext) isUsedReportVolume() bool {
-	for _, container := range c.initContainers.containerMap {
-		if len(container.reportOrgMountPaths) != 0 {
-			return true
-		}
-	}
-	for _, container := range c.containers.containerMap {
-		if len(container.reportOrgMountPaths) != 0 {
-			return true
-		}
-	}
-	for _, container := range c.finalizerContainers.containerMap {
-		if len(container.reportOrgMountPaths) != 0 {
-			return true
+	return c.anyContainer(func(container *TaskContainer) bool {
+		return len(container.reportOrgMountPaths) != 0
+	})
+}
+
+func (c *TaskBuildContext) isUsedCompressedReportVolume() bool {
+	return c.anyContainer(func(container *TaskContainer) bool {
+		return len(container.gzipReportOrgMountPaths) != 0
+	})
+}
+
+// anyContainer returns whether any init, main or finalizer container satisfies f.
+func (c *TaskBuildContext) anyContainer(f func(*TaskContainer) bool) bool {
+	for _, group := range []*TaskContainerGroup{c.initContainers, c.containers, c.finalizerContainers} {
+		for _, container := range group.containerMap {
+			if f(container) {
+				return true
+			}
 		}
 	}
 	return false
@@ -885,16 +1620,6 @@ func (g *TaskContainerGroup) preInitImagePullPolicy() corev1.PullPolicy {
 	return ""
 }
 
-func newTaskContainerGroup(containers []TestJobContainer, volumes []TestJobVolume) *TaskContainerGroup {
-	g := &TaskContainerGroup{
-		containerMap: map[string]*TaskContainer{},
-	}
-	for _, c := range containers {
-		g.containerMap[c.Name] = newTaskContainer(c, volumes)
-	}
-	return g
-}
-
 type TaskContainer struct {
 	idx                        int
 	container                  TestJobContainer
@@ -904,10 +1629,40 @@ type TaskContainer struct {
 	tokenNameToOrgMountPath    map[string]string
 	artifactNameToMountPath    map[string]string
 	artifactNameToOrgMountPath map[string]string
+	artifactNameToMode         map[string]int32
 	logOrgMountPaths           []string
 	reportOrgMountPaths        []string
-	podSpecVolumeMap           map[string]corev1.Volume
-	preInitVolumeMountMap      map[string]corev1.VolumeMount
+	// gzipLogOrgMountPaths mount paths of the log compressed by gzip. The file is copied with .gz suffix.
+	gzipLogOrgMountPaths []string
+	// gzipReportOrgMountPaths mount paths of the report compressed by gzip. The file is copied with .gz suffix.
+	gzipReportOrgMountPaths []string
+	podSpecVolumeMap        map[string]corev1.Volume
+	preInitVolumeMountMap   map[string]corev1.VolumeMount
+}
+
+// logCopyPaths returns the pairs of the log in the log volume and the path to copy it.
+func (c *TaskContainer) logCopyPaths() [][2]string {
+	paths := make([][2]string, 0, len(c.logOrgMountPaths)+len(c.gzipLogOrgMountPaths))
+	for _, mountPath := range c.logOrgMountPaths {
+		paths = append(paths, [2]string{logMountFilePath, mountPath})
+	}
+	for _, mountPath := range c.gzipLogOrgMountPaths {
+		paths = append(paths, [2]string{logMountFilePath + compressedFileSuffix, mountPath + compressedFileSuffix})
+	}
+	return paths
+}
+
+// reportCopyPaths returns the pairs of the report in the report volume and the path to copy it.
+func (c *TaskContainer) reportCopyPaths() [][2]string {
+	src := filepath.Join(reportMountPath, reportJSONFile)
+	paths := make([][2]string, 0, len(c.reportOrgMountPaths)+len(c.gzipReportOrgMountPaths))
+	for _, mountPath := range c.reportOrgMountPaths {
+		paths = append(paths, [2]string{src, mountPath})
+	}
+	for _, mountPath := range c.gzipReportOrgMountPaths {
+		paths = append(paths, [2]string{src + compressedFileSuffix, mountPath + compressedFileSuffix})
+	}
+	return paths
 }
 
 func (c *TaskContainer) hasTestVolumeMount() bool {
@@ -923,9 +1678,12 @@ func newTaskContainer(c TestJobContainer, volumes []TestJobVolume) *TaskContaine
 
 	artifactNameToMountPath := map[string]string{}
 	artifactNameToOrgMountPath := map[string]string{}
+	artifactNameToMode := map[string]int32{}
 
 	logOrgMountPaths := []string{}
 	reportOrgMountPaths := []string{}
+	gzipLogOrgMountPaths := []string{}
+	gzipReportOrgMountPaths := []string{}
 
 	podSpecVolumeMap := map[string]corev1.Volume{}
 	preInitVolumeMountMap := map[string]corev1.VolumeMount{}
@@ -961,6 +1719,9 @@ func newTaskContainer(c TestJobContainer, volumes []TestJobVolume) *TaskContaine
 			archiveMountPath := filepath.Join("/", "tmp", "artifact-archive", artifactVolumeName)
 			artifactNameToMountPath[artifactName] = archiveMountPath
 			artifactNameToOrgMountPath[artifactName] = vm.MountPath
+			if volume.Artifact.Mode != nil {
+				artifactNameToMode[artifactName] = *volume.Artifact.Mode
+			}
 			c.VolumeMounts[idx].MountPath = archiveMountPath
 			podSpecVolumeMap[artifactVolumeName] = corev1.Volume{
 				Name: artifactVolumeName,
@@ -991,7 +1752,11 @@ func newTaskContainer(c TestJobContainer, volumes []TestJobVolume) *TaskContaine
 			}
 		case volume.Log != nil:
 			logVolumeName := volume.Name
-			logOrgMountPaths = append(logOrgMountPaths, vm.MountPath)
+			if volume.Log.Compress {
+				gzipLogOrgMountPaths = append(gzipLogOrgMountPaths, vm.MountPath)
+			} else {
+				logOrgMountPaths = append(logOrgMountPaths, vm.MountPath)
+			}
 			c.VolumeMounts[idx].MountPath = logMountPath
 			podSpecVolumeMap[logVolumeName] = corev1.Volume{
 				Name: logVolumeName,
@@ -1005,7 +1770,11 @@ func newTaskContainer(c TestJobContainer, volumes []TestJobVolume) *TaskContaine
 			}
 		case volume.Report != nil:
 			reportVolumeName := volume.Name
-			reportOrgMountPaths = append(reportOrgMountPaths, vm.MountPath)
+			if volume.Report.Compress {
+				gzipReportOrgMountPaths = append(gzipReportOrgMountPaths, vm.MountPath)
+			} else {
+				reportOrgMountPaths = append(reportOrgMountPaths, vm.MountPath)
+			}
 			c.VolumeMounts[idx].MountPath = reportMountPath
 			podSpecVolumeMap[reportVolumeName] = corev1.Volume{
 				Name: reportVolumeName,
@@ -1032,8 +1801,11 @@ func newTaskContainer(c TestJobContainer, volumes []TestJobVolume) *TaskContaine
 		tokenNameToOrgMountPath:    tokenNameToOrgMountPath,
 		artifactNameToMountPath:    artifactNameToMountPath,
 		artifactNameToOrgMountPath: artifactNameToOrgMountPath,
+		artifactNameToMode:         artifactNameToMode,
 		logOrgMountPaths:           logOrgMountPaths,
 		reportOrgMountPaths:        reportOrgMountPaths,
+		gzipLogOrgMountPaths:       gzipLogOrgMountPaths,
+		gzipReportOrgMountPaths:    gzipReportOrgMountPaths,
 		podSpecVolumeMap:           podSpecVolumeMap,
 		preInitVolumeMountMap:      preInitVolumeMountMap,
 	}
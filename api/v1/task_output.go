@@ -0,0 +1,99 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const taskOutputSuffix = "-output.txt"
+
+// taskOutputWriter writes the combined output of each task to the directory of the output artifact as <task>-output.txt.
+// The shards of mainStep are written as mainStep-shard<index>-output.txt.
+// The tasks having the same name ( e.g. the shards of the retry ) are written as <task>-<seq>-output.txt in the order they finished.
+type taskOutputWriter struct {
+	name      string
+	dir       string
+	taskNum   map[string]int
+	artifacts []ReportArtifact
+	mu        sync.Mutex
+}
+
+func newTaskOutputWriter(name, dir string) *taskOutputWriter {
+	return &taskOutputWriter{name: name, dir: dir, taskNum: map[string]int{}}
+}
+
+// write writes the command, the output and the status of every subtask of the finished task.
+func (w *taskOutputWriter) write(taskName string, content []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	file := taskName + taskOutputSuffix
+	if num := w.taskNum[taskName]; num > 0 {
+		file = fmt.Sprintf("%s-%d%s", taskName, num, taskOutputSuffix)
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, file), content, 0644); err != nil {
+		return fmt.Errorf("kubetest: failed to write output of task %s: %w", taskName, err)
+	}
+	w.taskNum[taskName]++
+	w.artifacts = append(w.artifacts, ReportArtifact{Name: w.name, File: file})
+	return nil
+}
+
+// Artifacts returns the files written so far. If w is nil, returns nil.
+func (w *taskOutputWriter) Artifacts() []ReportArtifact {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]ReportArtifact{}, w.artifacts...)
+}
+
+// formatTaskOutput formats the results of the subtasks in execution order as well as the log of each subtask.
+func formatTaskOutput(result *TaskResult, mask func(string) string) []byte {
+	var b bytes.Buffer
+	for _, group := range result.groups {
+		for _, subResult := range group.results {
+			fmt.Fprintf(&b, "=== %s\n", mask(subResult.Command()))
+			out := mask(string(subResult.Out))
+			b.WriteString(out)
+			if len(out) != 0 && out[len(out)-1] != '\n' {
+				b.WriteByte('\n')
+			}
+			if err := subResult.Error(); err != nil {
+				fmt.Fprintf(&b, "--- %s: %s ( %f sec ): %s\n", subResult.Status, subResult.Name, subResult.ElapsedTime.Seconds(), mask(err.Error()))
+			} else {
+				fmt.Fprintf(&b, "--- %s: %s ( %f sec )\n", subResult.Status, subResult.Name, subResult.ElapsedTime.Seconds())
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+type taskOutputWriterKey struct{}
+
+func withTaskOutputWriter(ctx context.Context, writer *taskOutputWriter) context.Context {
+	return context.WithValue(ctx, taskOutputWriterKey{}, writer)
+}
+
+// writeTaskOutput writes the combined output of the finished task if the task output writer is registered to the context.
+// The failure is only logged because the output artifact must not stop the run.
+func writeTaskOutput(ctx context.Context, taskName string, result *TaskResult) {
+	writer, _ := ctx.Value(taskOutputWriterKey{}).(*taskOutputWriter)
+	if writer == nil {
+		return
+	}
+	logger := LoggerFromContext(ctx)
+	content := formatTaskOutput(result, func(msg string) string {
+		return maskText(logger, msg)
+	})
+	if err := writer.write(taskName, content); err != nil {
+		logger.Warn("failed to write task output: %s", err)
+	}
+}
@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTaskOutput(t *testing.T) {
+	logger := NewLogger(io.Discard, LogLevelInfo)
+	logger.AddMask("secret-token")
+	ctx := WithLogger(context.Background(), logger)
+	newTaskResult := func(results ...*SubTaskResult) *TaskResult {
+		var group SubTaskResultGroup
+		for _, result := range results {
+			group.add(result)
+		}
+		return &TaskResult{groups: []*SubTaskResultGroup{&group}}
+	}
+	dir := t.TempDir()
+	writer := newTaskOutputWriter("output", dir)
+	ctx = withTaskOutputWriter(ctx, writer)
+	writeTaskOutput(ctx, "test", newTaskResult(
+		&SubTaskResult{
+			Name:      "TestA",
+			Status:    TaskResultSuccess,
+			Container: corev1.Container{Command: []string{"echo", "secret-token"}},
+			Out:       []byte("token is secret-token"),
+		},
+		&SubTaskResult{
+			Name:      "TestB",
+			Status:    TaskResultFailure,
+			Container: corev1.Container{Command: []string{"false"}},
+			Err:       errors.New("exit code 1"),
+		},
+	))
+	writeTaskOutput(ctx, "test", newTaskResult(&SubTaskResult{
+		Name:      "TestC",
+		Status:    TaskResultSuccess,
+		Container: corev1.Container{Command: []string{"true"}},
+	}))
+
+	content, err := os.ReadFile(filepath.Join(dir, "test-output.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `=== echo ************
+token is ************
+--- success: TestA ( 0.000000 sec )
+=== false
+--- failure: TestB ( 0.000000 sec ): exit code 1
+`
+	if string(content) != expected {
+		t.Fatalf("unexpected output:\n%s", string(content))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test-1-output.txt")); err != nil {
+		t.Fatalf("the output of the task having the same name must be written to the other file: %v", err)
+	}
+	artifacts := writer.Artifacts()
+	if len(artifacts) != 2 {
+		t.Fatalf("failed to get artifacts: %v", artifacts)
+	}
+	if artifacts[0].Name != "output" || artifacts[0].File != "test-output.txt" || artifacts[1].File != "test-1-output.txt" {
+		t.Fatalf("unexpected artifacts: %v", artifacts)
+	}
+	t.Run("shard", func(t *testing.T) {
+		task := &Task{strategyKey: &StrategyKey{ConcurrentIdx: 2}}
+		if name := task.outputName(MainStepType); name != "mainStep-shard2" {
+			t.Fatalf("the output of the shard must be named by the index of the shard: %s", name)
+		}
+		if name := (&Task{}).outputName("setup"); name != "setup" {
+			t.Fatalf("unexpected output name: %s", name)
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		if err := NewValidator().ValidateTestJobSpec(TestJobSpec{
+			MainStep: MainStep{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"true"}}}},
+					},
+				},
+			},
+			OutputArtifact:  "output",
+			ExportArtifacts: []ExportArtifact{{Name: "output", Path: "/tmp/output"}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
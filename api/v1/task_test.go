@@ -0,0 +1,1001 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/kubejob"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTaskResultGroup(t *testing.T) {
+	newTaskResult := func(shard int, testTime time.Duration, testNum int) *TaskResult {
+		var group SubTaskResultGroup
+		for i := 0; i < testNum; i++ {
+			// the elapsed times of the tests running concurrently are not summed.
+			group.add(&SubTaskResult{ElapsedTime: testTime, IsMain: true})
+		}
+		// sidecar results are not counted.
+		group.add(&SubTaskResult{ElapsedTime: time.Hour})
+		return &TaskResult{shard: shard, testTime: testTime, groups: []*SubTaskResultGroup{&group}}
+	}
+	t.Run("shard balance", func(t *testing.T) {
+		var g TaskResultGroup
+		// the results are added in order of finishing.
+		g.add(newTaskResult(2, 2*time.Second, 2))
+		g.add(newTaskResult(0, 4*time.Second, 1))
+		g.add(newTaskResult(1, 8*time.Second, 1))
+		// the tests failed before running are not counted.
+		g.results[1].groups[0].add(&SubTaskResult{ElapsedTime: time.Hour, IsMain: true, Phase: ContainerPhaseSetup})
+		balance := g.ShardBalance()
+		if balance == nil {
+			t.Fatal("failed to get shard balance")
+		}
+		expected := []ReportShard{
+			{Index: 1, TestNum: 1, ElapsedTimeSec: 8, EstimatedTimeSec: 3.5},
+			{Index: 0, TestNum: 1, ElapsedTimeSec: 4, EstimatedTimeSec: 3.5},
+			{Index: 2, TestNum: 2, ElapsedTimeSec: 2, EstimatedTimeSec: 7},
+		}
+		if len(balance.Shards) != len(expected) {
+			t.Fatalf("unexpected shard num: %d", len(balance.Shards))
+		}
+		for idx, shard := range balance.Shards {
+			if shard != expected[idx] {
+				t.Fatalf("unexpected shard: expected %+v but got %+v", expected[idx], shard)
+			}
+		}
+		if balance.ImbalanceRatio != 4 {
+			t.Fatalf("unexpected imbalance ratio: %f", balance.ImbalanceRatio)
+		}
+	})
+	t.Run("single shard", func(t *testing.T) {
+		var g TaskResultGroup
+		g.add(newTaskResult(0, time.Second, 1))
+		if g.ShardBalance() != nil {
+			t.Fatal("shard balance must be nil for single shard")
+		}
+	})
+}
+
+// cpuBudgetTestJob records the total CPU requested by the jobs running at the same time.
+type cpuBudgetTestJob struct {
+	spec    batchv1.JobSpec
+	cpu     int64
+	running *int64
+	maxCPU  *int64
+	mu      *sync.Mutex
+}
+
+func (j *cpuBudgetTestJob) Spec() batchv1.JobSpec                                { return j.spec }
+func (j *cpuBudgetTestJob) PreInit(TestJobContainer, PreInitCallback)            {}
+func (j *cpuBudgetTestJob) Mount(func(context.Context, JobExecutor, bool) error) {}
+func (j *cpuBudgetTestJob) RunWithExecutionHandler(ctx context.Context, handler func(context.Context, []JobExecutor) error, _ func(context.Context, JobExecutor) error) error {
+	j.mu.Lock()
+	*j.running += j.cpu
+	if *j.running > *j.maxCPU {
+		*j.maxCPU = *j.running
+	}
+	j.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	j.mu.Lock()
+	*j.running -= j.cpu
+	j.mu.Unlock()
+	return handler(ctx, nil)
+}
+
+func TestTaskGroupCPUBudget(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	var (
+		running int64
+		maxCPU  int64
+		mu      sync.Mutex
+	)
+	newTask := func(cpu string) *Task {
+		quantity := resource.MustParse(cpu)
+		return &Task{
+			job: &cpuBudgetTestJob{
+				spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: quantity}}},
+							},
+						},
+					},
+				},
+				cpu:     quantity.MilliValue(),
+				running: &running,
+				maxCPU:  &maxCPU,
+				mu:      &mu,
+			},
+		}
+	}
+	tasks := []*Task{newTask("2"), newTask("2"), newTask("1500m"), newTask("8"), newTask("1")}
+	if cpu := tasks[2].CPURequest(); cpu.MilliValue() != 1500 {
+		t.Fatalf("unexpected cpu request: %s", cpu.String())
+	}
+	group := NewTaskGroup(tasks)
+	group.SetCPUBudget(resource.MustParse("4"))
+	if _, err := group.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the task requesting 8 cpu runs alone.
+	if maxCPU != 8000 {
+		t.Fatalf("unexpected max cpu: %d", maxCPU)
+	}
+	maxCPU = 0
+	group = NewTaskGroup(tasks[:3])
+	group.SetCPUBudget(resource.MustParse("4"))
+	if _, err := group.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if maxCPU > 4000 {
+		t.Fatalf("cpu budget is exceeded: %d", maxCPU)
+	}
+}
+
+func TestSubTaskFailureKind(t *testing.T) {
+	container := corev1.Container{
+		Name: "test",
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+		},
+	}
+	newSubTask := func(reason string) *SubTask {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-pod"},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name: "test",
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{Reason: reason, ExitCode: 137},
+					},
+				}},
+			},
+		}
+		clientset := fake.NewSimpleClientset(pod)
+		return &SubTask{
+			exec: &kubernetesJobExecutor{
+				// the executor keeps the pod at the time it started.
+				exec:      &kubejob.JobExecutor{Container: container, Pod: &corev1.Pod{ObjectMeta: pod.ObjectMeta}},
+				podClient: clientset.CoreV1().Pods("default"),
+			},
+		}
+	}
+	t.Run("OOMKilled", func(t *testing.T) {
+		var b bytes.Buffer
+		logger := NewLogger(&b, LogLevelInfo)
+		group := logger.Group()
+		if kind := newSubTask("OOMKilled").failureKind(context.Background(), group, testExitError(137)); kind != FailureKindOOMKilled {
+			t.Fatalf("unexpected failure kind: %q", kind)
+		}
+		logger.LogGroup(group)
+		if !strings.Contains(b.String(), "current: 128Mi") {
+			t.Fatalf("expected memory limit is suggested but got %q", b.String())
+		}
+	})
+	t.Run("Error", func(t *testing.T) {
+		group := NewLogger(io.Discard, LogLevelInfo).Group()
+		if kind := newSubTask("Error").failureKind(context.Background(), group, testExitError(1)); kind != FailureKindNone {
+			t.Fatalf("unexpected failure kind: %q", kind)
+		}
+	})
+	t.Run("exit code 137", func(t *testing.T) {
+		var b bytes.Buffer
+		logger := NewLogger(&b, LogLevelInfo)
+		group := logger.Group()
+		// the container itself isn't OOMKilled because only the test process is killed.
+		if kind := newSubTask("").failureKind(context.Background(), group, testExitError(137)); kind != FailureKindOOMKilledSuspected {
+			t.Fatalf("unexpected failure kind: %q", kind)
+		}
+		logger.LogGroup(group)
+		if !strings.Contains(b.String(), "current: 128Mi") {
+			t.Fatalf("expected memory limit is suggested but got %q", b.String())
+		}
+	})
+}
+
+// cancelTestJob cancels the context of the run after running the job.
+type cancelTestJob struct {
+	*cpuBudgetTestJob
+	cancel context.CancelFunc
+}
+
+func (j *cancelTestJob) RunWithExecutionHandler(ctx context.Context, handler func(context.Context, []JobExecutor) error, finalizer func(context.Context, JobExecutor) error) error {
+	defer j.cancel()
+	return j.cpuBudgetTestJob.RunWithExecutionHandler(ctx, handler, finalizer)
+}
+
+func TestTaskGroupInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug)))
+	defer cancel()
+	var (
+		running int64
+		maxCPU  int64
+		mu      sync.Mutex
+	)
+	newTask := func() *Task {
+		cpu := resource.MustParse("2")
+		return &Task{
+			job: &cancelTestJob{
+				cpuBudgetTestJob: &cpuBudgetTestJob{
+					spec: batchv1.JobSpec{
+						Template: corev1.PodTemplateSpec{
+							Spec: corev1.PodSpec{
+								Containers: []corev1.Container{
+									{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: cpu}}},
+								},
+							},
+						},
+					},
+					cpu:     cpu.MilliValue(),
+					running: &running,
+					maxCPU:  &maxCPU,
+					mu:      &mu,
+				},
+				cancel: cancel,
+			},
+		}
+	}
+	group := NewTaskGroup([]*Task{newTask(), newTask()})
+	group.SetCPUBudget(resource.MustParse("2"))
+	result, err := group.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error but got %v", err)
+	}
+	if result == nil || len(result.results) != 1 {
+		t.Fatalf("expected the result of the finished task but got %+v", result)
+	}
+	if err := interruptedError(ctx, errors.New("failed to run")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error wrapping context.Canceled but got %v", err)
+	}
+	if err := interruptedError(context.Background(), errors.New("failed to run")); errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected canceled error: %v", err)
+	}
+}
+
+// failedTestJob fails to run by err without running the handler.
+type failedTestJob struct {
+	fakeJob
+	err error
+}
+
+func (j *failedTestJob) RunWithExecutionHandler(context.Context, func(context.Context, []JobExecutor) error, func(context.Context, JobExecutor) error) error {
+	return j.err
+}
+
+func TestTaskGroupErrors(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := WithLogger(context.Background(), NewLogger(&buf, LogLevelInfo))
+	pullErr := errors.New("ErrImagePull: image not found")
+	newTask := func(idx uint32, err error) *Task {
+		if err == nil {
+			// the succeeded task runs no subtasks.
+			return &Task{Name: "test", job: &fakeJob{}}
+		}
+		return &Task{Name: "test", strategyKey: &StrategyKey{ConcurrentIdx: idx}, job: &failedTestJob{err: err}}
+	}
+	group := NewTaskGroup([]*Task{
+		newTask(0, pullErr),
+		newTask(1, nil),
+		newTask(2, pullErr),
+		newTask(3, errors.New("node lost\nsee the events of the node")),
+		newTask(4, pullErr),
+	})
+	result, err := group.Run(ctx)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	expected := "kubetest: 4 of 5 tasks failed: ErrImagePull: image not found ( test shard-0, test shard-2, test shard-4 ); node lost\nsee the events of the node ( test shard-3 )"
+	if err.Error() != expected {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !errors.Is(err, pullErr) {
+		t.Fatalf("the error must wrap the errors of the tasks: %v", err)
+	}
+	if result == nil || len(result.results) != 1 || len(result.Failures()) != 4 {
+		t.Fatalf("expected the result of the succeeded task and the failures: %+v", result)
+	}
+	if result.Failures()[1].Task != "test shard-2" {
+		t.Fatalf("the failures must be in order of the tasks: %+v", result.Failures()[1])
+	}
+	for _, line := range []string{"4 of 5 tasks failed", "- test shard-0: ErrImagePull: image not found", "- test shard-3: node lost"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("the summary must have %q: %s", line, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "see the events") {
+		t.Fatalf("the summary must have only the first line of the error: %s", buf.String())
+	}
+	tasks := result.ReportTasks(func(msg string) string { return strings.ReplaceAll(msg, "image", "*****") })
+	if len(tasks) != 5 {
+		t.Fatalf("expected the status of all tasks: %+v", tasks)
+	}
+	if tasks[0] != (ReportTask{Name: "test", Status: ResultStatusSuccess}) {
+		t.Fatalf("unexpected status of the succeeded task: %+v", tasks[0])
+	}
+	if tasks[1] != (ReportTask{Name: "test shard-0", Status: ResultStatusError, Error: "ErrImagePull: ***** not found"}) {
+		t.Fatalf("unexpected status of the failed task: %+v", tasks[1])
+	}
+	if tasks := (&TaskResultGroup{}).ReportTasks(nil); tasks != nil {
+		t.Fatalf("the status of the tasks must be reported only if some tasks failed to run: %+v", tasks)
+	}
+}
+
+// quotaTestJob fails to create the job by err until the number of the runs reaches failures.
+type quotaTestJob struct {
+	*cpuBudgetTestJob
+	runs     *int
+	failures int
+	err      error
+}
+
+func (j *quotaTestJob) RunWithExecutionHandler(ctx context.Context, handler func(context.Context, []JobExecutor) error, finalizer func(context.Context, JobExecutor) error) error {
+	*j.runs++
+	if *j.runs <= j.failures {
+		return j.err
+	}
+	return j.cpuBudgetTestJob.RunWithExecutionHandler(ctx, handler, finalizer)
+}
+
+func TestTaskQuotaExceeded(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	defaultInterval, defaultTimeout := quotaRetryInterval, quotaWaitTimeout
+	quotaRetryInterval = 10 * time.Millisecond
+	defer func() {
+		quotaRetryInterval, quotaWaitTimeout = defaultInterval, defaultTimeout
+	}()
+	quotaErr := &kubejob.JobCreationError{
+		Err: apierrors.NewForbidden(
+			schema.GroupResource{Group: "batch", Resource: "jobs"}, "test",
+			errors.New("exceeded quota: compute-resources, requested: requests.cpu=2, used: requests.cpu=8, limited: requests.cpu=8"),
+		),
+	}
+	newTask := func(runs *int, failures int, err error) *Task {
+		var (
+			running int64
+			maxCPU  int64
+			mu      sync.Mutex
+		)
+		newJob := func() *quotaTestJob {
+			return &quotaTestJob{
+				cpuBudgetTestJob: &cpuBudgetTestJob{running: &running, maxCPU: &maxCPU, mu: &mu},
+				runs:             runs,
+				failures:         failures,
+				err:              err,
+			}
+		}
+		return &Task{
+			job: newJob(),
+			createJob: func(context.Context) (Job, error) {
+				return newJob(), nil
+			},
+		}
+	}
+	t.Run("wait for quota", func(t *testing.T) {
+		var runs int
+		if _, err := newTask(&runs, 3, quotaErr).runWithRetry(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if runs != 4 {
+			t.Fatalf("unexpected number of runs: %d", runs)
+		}
+	})
+	t.Run("quota is not freed", func(t *testing.T) {
+		quotaWaitTimeout = 50 * time.Millisecond
+		defer func() { quotaWaitTimeout = defaultTimeout }()
+		var runs int
+		_, err := newTask(&runs, 100, quotaErr).runWithRetry(ctx)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !isQuotaExceededError(errors.Unwrap(err)) {
+			t.Fatalf("expected quota error but got %v", err)
+		}
+	})
+	t.Run("invalid spec", func(t *testing.T) {
+		invalidErr := &kubejob.JobCreationError{
+			Err: apierrors.NewForbidden(
+				schema.GroupResource{Group: "batch", Resource: "jobs"}, "test",
+				errors.New("violates PodSecurity"),
+			),
+		}
+		var runs int
+		if _, err := newTask(&runs, 1, invalidErr).runWithRetry(ctx); err == nil {
+			t.Fatal("expected error")
+		}
+		if runs != 1 {
+			t.Fatalf("the job of the invalid spec must not be retried: %d", runs)
+		}
+	})
+}
+
+func TestTaskPendingTimeout(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	var (
+		runs    int
+		running int64
+		maxCPU  int64
+		mu      sync.Mutex
+	)
+	newJob := func() *quotaTestJob {
+		return &quotaTestJob{
+			cpuBudgetTestJob: &cpuBudgetTestJob{running: &running, maxCPU: &maxCPU, mu: &mu},
+			runs:             &runs,
+			failures:         100,
+			err:              &kubejob.PendingPhaseTimeoutError{},
+		}
+	}
+	task := &Task{
+		job: newJob(),
+		createJob: func(context.Context) (Job, error) {
+			return newJob(), nil
+		},
+		failOnPendingTimeout: true,
+	}
+	_, err := task.runWithRetry(ctx)
+	if !isPendingTimeoutError(err) {
+		t.Fatalf("expected pending timeout error but got %v", err)
+	}
+	if runs != 1 {
+		t.Fatalf("the pending timeout must be applied once but the job ran %d times", runs)
+	}
+	if !isPendingTimeoutError(fmt.Errorf("kubetest: failed to run dynamic key task: %w", err)) {
+		t.Fatal("failed to find the wrapped pending timeout error")
+	}
+}
+
+func TestSubTaskArtifactOfFailedTest(t *testing.T) {
+	rootDir := t.TempDir()
+	newSubTask := func(command string) *SubTask {
+		exec := &localJobExecutor{
+			rootDir:   rootDir,
+			container: corev1.Container{Name: "test", Command: []string{"sh", "-c", command}},
+		}
+		return &SubTask{
+			Name: "test",
+			exec: exec,
+			copyArtifact: func(ctx context.Context, subtask *SubTask) error {
+				err := subtask.exec.CopyFrom(ctx, "/tmp/heap.dump", t.TempDir())
+				if err == nil {
+					return nil
+				}
+				if !isNotExistCopyError(err) {
+					t.Fatalf("expected not exist error but got %v", err)
+				}
+				return fmt.Errorf("kubetest: %w: heap: %w", errArtifactNotFound, err)
+			},
+		}
+	}
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	t.Run("failed test", func(t *testing.T) {
+		result := newSubTask("exit 1").Run(ctx)
+		if result.Status != TaskResultFailure {
+			t.Fatalf("unexpected status: %v", result.Status)
+		}
+		if result.ArtifactErr != nil {
+			t.Fatalf("missing artifact of the failed test must not be an error: %v", result.ArtifactErr)
+		}
+	})
+	t.Run("succeeded test", func(t *testing.T) {
+		result := newSubTask("true").Run(ctx)
+		if result.Status != TaskResultFailure || !errors.Is(result.ArtifactErr, errArtifactNotFound) {
+			t.Fatalf("missing artifact of the succeeded test must be an error: %v", result.ArtifactErr)
+		}
+	})
+}
+
+func TestSubTaskOnFailureCommand(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	onFailureCommand := []string{"echo", "diagnostics"}
+	newSubTask := func(command string) *SubTask {
+		return &SubTask{
+			Name: "test",
+			exec: &localJobExecutor{
+				rootDir:   t.TempDir(),
+				container: corev1.Container{Name: "test", Command: []string{"sh", "-c", command}},
+			},
+			isMain:           true,
+			copyArtifact:     func(context.Context, *SubTask) error { return nil },
+			onFailureCommand: onFailureCommand,
+		}
+	}
+	result := newSubTask("echo failed; exit 1").Run(ctx)
+	if result.Status != TaskResultFailure {
+		t.Fatalf("unexpected status: %v", result.Status)
+	}
+	if !strings.Contains(string(result.Out), "failed\n") || !strings.Contains(string(result.Out), "--- on failure command: echo diagnostics\ndiagnostics") {
+		t.Fatalf("failed to append output of on failure command: %q", result.Out)
+	}
+	result = newSubTask("echo succeeded").Run(ctx)
+	if strings.Contains(string(result.Out), "diagnostics") {
+		t.Fatalf("on failure command must not be run for the succeeded test: %q", result.Out)
+	}
+	// the argument having the white spaces is passed to the command as is.
+	onFailureCommand = []string{"sh", "-c", "echo diag; echo nostics"}
+	result = newSubTask("exit 1").Run(ctx)
+	if !strings.Contains(string(result.Out), "--- on failure command: sh -c 'echo diag; echo nostics'\ndiag\nnostics") {
+		t.Fatalf("failed to run on failure command having the quoted argument: %q", result.Out)
+	}
+}
+
+func TestSubTaskEmptyOutput(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	newSubTask := func(command string, policy EmptyOutputPolicy) *SubTask {
+		return &SubTask{
+			Name: "test",
+			exec: &localJobExecutor{
+				rootDir:   t.TempDir(),
+				container: corev1.Container{Name: "test", Command: []string{"sh", "-c", command}},
+			},
+			isMain:       true,
+			copyArtifact: func(context.Context, *SubTask) error { return nil },
+			emptyOutput:  policy,
+		}
+	}
+	if result := newSubTask("true", "").Run(ctx); result.Status != TaskResultSuccess {
+		t.Fatalf("empty output must be allowed by default: %v", result.Err)
+	}
+	result := newSubTask("echo", EmptyOutputPolicyFail).Run(ctx)
+	if result.Status != TaskResultFailure || result.FailureKind != FailureKindEmptyOutput || !errors.Is(result.Err, errEmptyOutput) {
+		t.Fatalf("unexpected result of the test without output: %v, %v", result.Status, result.Err)
+	}
+	if result.IsInternalError() {
+		t.Fatal("empty output must not be an internal error")
+	}
+	if result := newSubTask("echo ok", EmptyOutputPolicyFail).Run(ctx); result.Status != TaskResultSuccess {
+		t.Fatalf("unexpected result of the test with output: %v", result.Err)
+	}
+	if result := newSubTask("exit 1", EmptyOutputPolicyFail).Run(ctx); result.FailureKind == FailureKindEmptyOutput {
+		t.Fatal("the failed test must keep the exit code semantics")
+	}
+}
+
+func TestSubTaskStopGracePeriod(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	artifactPath := filepath.Join(t.TempDir(), "artifact")
+	var artifact []byte
+	subtask := &SubTask{
+		Name: "test",
+		exec: &localJobExecutor{
+			rootDir: t.TempDir(),
+			container: corev1.Container{
+				Name:    "test",
+				Command: []string{"sh", "-c", fmt.Sprintf("(sleep 0.1; echo flushed > %s) > /dev/null 2>&1 &", artifactPath)},
+			},
+		},
+		isMain: true,
+		copyArtifact: func(context.Context, *SubTask) error {
+			b, err := os.ReadFile(artifactPath)
+			if err != nil {
+				return err
+			}
+			artifact = b
+			return nil
+		},
+		stopGracePeriod: time.Second,
+	}
+	result := subtask.Run(ctx)
+	if result.Status != TaskResultSuccess {
+		t.Fatalf("unexpected status: %v: %v", result.Status, result.ArtifactErr)
+	}
+	if string(artifact) != "flushed\n" {
+		t.Fatalf("artifacts must be copied after the grace period: %q", artifact)
+	}
+	if result.ElapsedTime >= subtask.stopGracePeriod {
+		t.Fatalf("the elapsed time must not include the grace period: %s", result.ElapsedTime)
+	}
+}
+
+func TestTaskPostContainer(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	testjob := TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec: TestJobSpec{
+			MainStep: MainStep{
+				Strategy: &Strategy{
+					Key: StrategyKeySpec{
+						Env:    "TEST",
+						Source: StrategyKeySource{Static: []string{"a", "b", "c"}},
+					},
+					Scheduler: Scheduler{
+						MaxContainersPerPod:    3,
+						MaxConcurrentNumPerPod: 1,
+					},
+				},
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{
+								Container: corev1.Container{
+									Name:    "collector",
+									Image:   "alpine",
+									Command: []string{"sh", "-c"},
+									Args:    []string{"echo collected"},
+								},
+								Role: ContainerRolePost,
+							},
+							{
+								Container: corev1.Container{
+									Name:    "test",
+									Image:   "alpine",
+									Command: []string{"sh", "-c"},
+									Args:    []string{"echo $TEST"},
+								},
+								Role: ContainerRoleMain,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := NewValidator().ValidateTestJobTemplateSpec(testjob.Spec.MainStep.Template, MainStepType); err != nil {
+		t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+	var finishedNames []string
+	scheduler := NewTaskScheduler(testjob.Spec.MainStep)
+	taskGroup, err := scheduler.Schedule(ctx, builder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range taskGroup.tasks {
+		task.OnFinishSubTask = func(subTask *SubTask) {
+			finishedNames = append(finishedNames, subTask.Name)
+		}
+	}
+	result, err := taskGroup.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, taskResult := range result.results {
+		for _, group := range taskResult.groups {
+			for _, subTaskResult := range group.results {
+				names = append(names, subTaskResult.Name)
+			}
+		}
+	}
+	if expected := []string{"a", "b", "c", "collector"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("post container must run once after the tests: expected %v but got %v", expected, names)
+	}
+	names = []string{}
+	for _, detail := range result.ToReportDetails() {
+		names = append(names, detail.Name)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("post container must not be reported as a test: %v", names)
+	}
+	auxiliary := result.ToAuxiliaryReportDetails()
+	if len(auxiliary) != 1 || auxiliary[0].Name != "collector" || auxiliary[0].Status != ResultStatusSuccess {
+		t.Fatalf("post container must be reported as an auxiliary result: %v", auxiliary)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(finishedNames, expected) {
+		t.Fatalf("post container must not be counted as a finished test: %v", finishedNames)
+	}
+	if result.TotalNum() != 3 || result.SuccessNum() != 3 {
+		t.Fatalf("unexpected total num: %d, success num: %d", result.TotalNum(), result.SuccessNum())
+	}
+}
+
+func TestSubTaskStartJitter(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	subtask := &SubTask{
+		Name: "test",
+		exec: &localJobExecutor{
+			rootDir:   t.TempDir(),
+			container: corev1.Container{Name: "test", Command: []string{"echo", "started"}},
+		},
+		isMain:       true,
+		copyArtifact: func(context.Context, *SubTask) error { return nil },
+		startJitter:  50 * time.Millisecond,
+	}
+	start := time.Now()
+	result := subtask.Run(ctx)
+	if result.Status != TaskResultSuccess {
+		t.Fatalf("unexpected status: %v: %s", result.Status, result.Out)
+	}
+	if elapsed := time.Since(start); elapsed-result.ElapsedTime > time.Second {
+		t.Fatalf("waited longer than the jitter: %s", elapsed)
+	}
+}
+
+func TestTaskCommandWrapper(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	testjob := TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec: TestJobSpec{
+			MainStep: MainStep{
+				Strategy: &Strategy{
+					Key: StrategyKeySpec{
+						Env:    "TEST",
+						Source: StrategyKeySource{Static: []string{"a", "b"}},
+					},
+					Scheduler: Scheduler{
+						MaxContainersPerPod:    2,
+						MaxConcurrentNumPerPod: 2,
+					},
+				},
+				CommandWrapper: []string{"env", "WRAPPED=1"},
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{
+								Container: corev1.Container{
+									Name:    "test",
+									Image:   "alpine",
+									Command: []string{"sh", "-c"},
+									Args:    []string{"echo $TEST:$WRAPPED"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := NewValidator().ValidateMainStep(testjob.Spec.MainStep); err != nil {
+		t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+	taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := taskGroup.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TotalNum() != 2 {
+		t.Fatalf("unexpected number of tests: %d", result.TotalNum())
+	}
+	for _, taskResult := range result.results {
+		for _, subTaskResult := range taskResult.MainTaskResults() {
+			if expected := fmt.Sprintf("%s:1\n", subTaskResult.Name); string(subTaskResult.Out) != expected {
+				t.Fatalf("the test must run with the wrapper: expected %q but got %q", expected, subTaskResult.Out)
+			}
+			if expected := fmt.Sprintf("[TEST:%s] sh -c echo $TEST:$WRAPPED", subTaskResult.Name); subTaskResult.Command() != expected {
+				t.Fatalf("the original command must be reported: expected %q but got %q", expected, subTaskResult.Command())
+			}
+		}
+	}
+	if err := NewValidator().ValidateMainStep(MainStep{CommandWrapper: []string{"timeout", ""}}); err == nil || !strings.Contains(err.Error(), "commandWrapper") {
+		t.Fatalf("expected error for empty argument of the command wrapper but got %v", err)
+	}
+	noCommand := *testjob.Spec.MainStep.DeepCopy()
+	noCommand.Template.Spec.Containers[0].Command = nil
+	if err := NewValidator().ValidateMainStep(noCommand); err == nil || !strings.Contains(err.Error(), "requires the command of the main container test") {
+		t.Fatalf("expected error for the main container without command but got %v", err)
+	}
+}
+
+func TestTaskScratch(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	testjob := TestJob{
+		ObjectMeta: testjobObjectMeta(),
+		Spec: TestJobSpec{
+			Scratch: &ScratchSpec{MountPath: "/tmp/scratch"},
+			MainStep: MainStep{
+				Strategy: &Strategy{
+					Key: StrategyKeySpec{
+						Env:    "TEST",
+						Source: StrategyKeySource{Static: []string{"a", "b"}},
+					},
+					Scheduler: Scheduler{
+						MaxContainersPerPod:    2,
+						MaxConcurrentNumPerPod: 2,
+					},
+				},
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{
+								Container: corev1.Container{
+									Name:    "test",
+									Image:   "alpine",
+									Command: []string{"sh", "-c"},
+									Args:    []string{`touch "$TMPDIR/$TEST" && ls "$TMPDIR"`},
+								},
+							},
+						},
+						// the local finalizer can't see the mount path, so TMPDIR must be rewritten to its scratch directory.
+						FinalizerContainer: TestJobContainer{
+							Container: corev1.Container{
+								Name:    "finalizer",
+								Image:   "alpine",
+								Command: []string{"sh", "-c"},
+								Args:    []string{`test "$TMPDIR" != /tmp/scratch && test -d "$TMPDIR"`},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := NewValidator().ValidateTestJobSpec(testjob.Spec); err != nil {
+		t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+	builder.SetScratch(testjob.Spec.Scratch)
+	taskGroup, err := NewTaskScheduler(testjob.Spec.MainStep).Schedule(ctx, builder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := taskGroup.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TotalNum() != 2 {
+		t.Fatalf("unexpected number of tests: %d", result.TotalNum())
+	}
+	for _, taskResult := range result.results {
+		for _, subTaskResult := range taskResult.MainTaskResults() {
+			// each container has its own scratch directory, so only the file created by the test is listed.
+			if expected := subTaskResult.Name + "\n"; string(subTaskResult.Out) != expected {
+				t.Fatalf("failed to use scratch directory: expected %q but got %q", expected, subTaskResult.Out)
+			}
+		}
+	}
+	for _, scratch := range []ScratchSpec{
+		{},
+		{MountPath: "tmp"},
+		{MountPath: "/tmp/scratch", Medium: corev1.StorageMediumHugePages},
+	} {
+		if err := NewValidator().ValidateScratch(&scratch); err == nil || !strings.Contains(err.Error(), "scratch") {
+			t.Fatalf("expected error for invalid scratch %+v but got %v", scratch, err)
+		}
+	}
+}
+
+func TestTaskCompressedLogAndReport(t *testing.T) {
+	logger := NewLogger(io.Discard, LogLevelDebug)
+	ctx := WithLogger(context.Background(), logger)
+	step := PostStep{
+		Name: "post",
+		Template: TestJobTemplateSpec{
+			Spec: TestJobPodSpec{
+				Containers: []TestJobContainer{
+					{
+						Container: corev1.Container{
+							Name:       "post",
+							Image:      "alpine",
+							Command:    []string{"sh", "-c"},
+							Args:       []string{`test ! -e kubetest.log && gzip -dc "$KUBETEST_LOG_PATH" && cat report.json`},
+							WorkingDir: "/work",
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "log", MountPath: "/work/kubetest.log"},
+								{Name: "report", MountPath: "/work/report.json"},
+							},
+						},
+					},
+				},
+				Volumes: []TestJobVolume{
+					{Name: "log", TestJobVolumeSource: TestJobVolumeSource{Log: &LogVolumeSource{Compress: true}}},
+					{Name: "report", TestJobVolumeSource: TestJobVolumeSource{Report: &ReportVolumeSource{Format: ReportFormatTypeJSON}}},
+				},
+			},
+		},
+	}
+	if err := NewValidator().ValidatePostStep(step); err != nil {
+		t.Fatal(err)
+	}
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testjob := TestJob{ObjectMeta: testjobObjectMeta(), Spec: TestJobSpec{PostSteps: []PostStep{step}}}
+	mgr := NewResourceManager(clientset, testjob)
+	mgr.SetWorkDir(t.TempDir())
+	logger.Info("compressed log")
+	if err := mgr.WriteLog(logger); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.WriteReport(&Result{}); err != nil {
+		t.Fatal(err)
+	}
+	task, err := NewTaskBuilder(getConfig(), mgr, "default", RunModeLocal).Build(ctx, &step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := task.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := result.MainTaskResults()
+	if len(results) != 1 {
+		t.Fatalf("unexpected number of results: %d", len(results))
+	}
+	if results[0].Error() != nil {
+		t.Fatalf("failed to read compressed log: %s: %v", results[0].Out, results[0].Error())
+	}
+	out := string(results[0].Out)
+	if !strings.Contains(out, "compressed log") || !strings.HasSuffix(out, "{}") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestTaskNameGenerator(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	step := &MainStep{
+		Template: TestJobTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "unit-"},
+			Spec: TestJobPodSpec{
+				Containers: []TestJobContainer{
+					{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"true"}}},
+				},
+			},
+		},
+	}
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testjob := TestJob{ObjectMeta: testjobObjectMeta()}
+	newBuilder := func(generator NameGenerator) *TaskBuilder {
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeDryRun)
+		builder.SetNameGenerator(generator)
+		return builder
+	}
+	t.Run("generated", func(t *testing.T) {
+		var seq int
+		task, err := newBuilder(func(base string) string {
+			seq++
+			return fmt.Sprintf("%sbuild-1234-%d", base, seq)
+		}).Build(ctx, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := task.job.(*dryRunJob).job
+		if job.Name != "unit-build-1234-1" || job.GenerateName != "" {
+			t.Fatalf("unexpected job name: name %q generateName %q", job.Name, job.GenerateName)
+		}
+	})
+	t.Run("default", func(t *testing.T) {
+		task, err := newBuilder(nil).Build(ctx, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := task.job.(*dryRunJob).job
+		if job.Name != "" || job.GenerateName != "unit-" {
+			t.Fatalf("unexpected job name: name %q generateName %q", job.Name, job.GenerateName)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, name := range []string{"Unit_Build", strings.Repeat("a", 64)} {
+			_, err := newBuilder(func(string) string { return name }).Build(ctx, step)
+			if err == nil || !strings.Contains(err.Error(), "invalid job name") {
+				t.Fatalf("expected invalid job name error for %q but got %v", name, err)
+			}
+		}
+	})
+}
@@ -3,7 +3,14 @@
 
 package v1
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	sigsjson "sigs.k8s.io/json"
+)
 
 func (j *TestJob) Validate() error {
 	return NewValidator().ValidateTestJob(*j)
@@ -16,3 +23,97 @@ func (j *TestJob) SetStaticStrategyKeys(keys []string) error {
 	j.Spec.MainStep.Strategy.Key.Source.Static = keys
 	return nil
 }
+
+// ApplyOverlay returns the copy of the testjob having the spec resolved by the overlay specified by name.
+// The patch of the overlay is merged over the spec by strategic merge patch, and the overlays are removed from the resolved spec.
+// metadata.namespace of the patch overrides the namespace of the testjob.
+func (j *TestJob) ApplyOverlay(name string) (*TestJob, error) {
+	var overlay *TestJobOverlay
+	for idx := range j.Spec.Overlays {
+		if j.Spec.Overlays[idx].Name == name {
+			overlay = &j.Spec.Overlays[idx]
+			break
+		}
+	}
+	if overlay == nil {
+		return nil, fmt.Errorf("kubetest: overlay %q is not found", name)
+	}
+	patch, namespace, err := splitOverlayPatch(overlay.Patch.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: invalid overlay %q: %w", name, err)
+	}
+	base := j.Spec.DeepCopy()
+	base.Overlays = nil
+	original, err := json.Marshal(base)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to encode spec: %w", err)
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, patch, TestJobSpec{})
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to apply overlay %q: %w", name, err)
+	}
+	var spec TestJobSpec
+	strictErrs, err := sigsjson.UnmarshalStrict(merged, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to decode spec resolved by overlay %q: %w", name, err)
+	}
+	if len(strictErrs) != 0 {
+		return nil, fmt.Errorf("kubetest: invalid overlay %q: %w", name, errors.Join(strictErrs...))
+	}
+	if len(spec.Overlays) != 0 {
+		return nil, fmt.Errorf("kubetest: overlay %q cannot have overlays", name)
+	}
+	resolved := j.DeepCopy()
+	resolved.Spec = spec
+	if namespace != "" {
+		resolved.Namespace = namespace
+	}
+	return resolved, nil
+}
+
+// splitOverlayPatch splits metadata from the patch of the overlay and returns the patch of the spec and the namespace.
+// Only namespace is allowed in the metadata.
+func splitOverlayPatch(raw []byte) ([]byte, string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, "", err
+	}
+	metadata, exists := fields["metadata"]
+	if !exists {
+		return raw, "", nil
+	}
+	var meta struct {
+		Namespace string `json:"namespace"`
+	}
+	strictErrs, err := sigsjson.UnmarshalStrict(metadata, &meta)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	if len(strictErrs) != 0 {
+		return nil, "", fmt.Errorf("only metadata.namespace can be patched: %w", errors.Join(strictErrs...))
+	}
+	delete(fields, "metadata")
+	patch, err := json.Marshal(fields)
+	if err != nil {
+		return nil, "", err
+	}
+	return patch, meta.Namespace, nil
+}
+
+// templates returns the templates of all steps including the template to get dynamic keys.
+func (j *TestJob) templates() []TestJobTemplateSpec {
+	templates := []TestJobTemplateSpec{}
+	for _, step := range j.Spec.PreSteps {
+		templates = append(templates, step.Template)
+	}
+	templates = append(templates, j.Spec.MainStep.Template)
+	if strategy := j.Spec.MainStep.Strategy; strategy != nil {
+		for _, source := range dynamicKeySources(strategy.Key.Source) {
+			templates = append(templates, source.Template)
+		}
+	}
+	for _, step := range j.Spec.PostSteps {
+		templates = append(templates, step.Template)
+	}
+	return templates
+}
@@ -0,0 +1,155 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyOverlay(t *testing.T) {
+	loadTestJob := func(t *testing.T, overlays string) TestJob {
+		testjobs, err := LoadTestJobs(strings.NewReader(`
+apiVersion: kubetest.io/v1
+kind: TestJob
+metadata:
+  name: overlay
+spec:
+  mainStep:
+    template:
+      main: test
+      spec:
+        containers:
+          - name: test
+            image: golang:1.21
+            command: ["go", "test", "./..."]
+          - name: db
+            image: mysql:8.0
+            command: ["mysqld"]
+` + overlays))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testjobs[0]
+	}
+	t.Run("merge", func(t *testing.T) {
+		testjob := loadTestJob(t, `
+  overlays:
+    - name: prod
+      patch:
+        maxObjects: 10
+        mainStep:
+          template:
+            spec:
+              containers:
+                - name: test
+                  image: golang:1.22
+`)
+		if err := testjob.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		resolved, err := testjob.ApplyOverlay("prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved.Spec.MaxObjects != 10 || len(resolved.Spec.Overlays) != 0 {
+			t.Fatalf("unexpected resolved spec: %+v", resolved.Spec)
+		}
+		containers := resolved.Spec.MainStep.Template.Spec.Containers
+		if len(containers) != 2 {
+			t.Fatalf("containers must be merged by name: %+v", containers)
+		}
+		if containers[0].Image != "golang:1.22" || strings.Join(containers[0].Command, " ") != "go test ./..." {
+			t.Fatalf("unexpected test container: %+v", containers[0])
+		}
+		if containers[1].Image != "mysql:8.0" {
+			t.Fatalf("unexpected db container: %+v", containers[1])
+		}
+		if testjob.Spec.MainStep.Template.Spec.Containers[0].Image != "golang:1.21" {
+			t.Fatal("the original testjob must not be changed")
+		}
+	})
+	t.Run("namespace", func(t *testing.T) {
+		testjob := loadTestJob(t, `
+  overlays:
+    - name: prod
+      patch:
+        metadata:
+          namespace: prod
+        maxObjects: 10
+`)
+		if err := testjob.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		resolved, err := testjob.ApplyOverlay("prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved.Namespace != "prod" || resolved.Spec.MaxObjects != 10 {
+			t.Fatalf("unexpected resolved testjob: namespace %q, maxObjects %d", resolved.Namespace, resolved.Spec.MaxObjects)
+		}
+		if testjob.Namespace != "" {
+			t.Fatal("the original testjob must not be changed")
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		testjob := loadTestJob(t, "")
+		if _, err := testjob.ApplyOverlay("prod"); err == nil || !strings.Contains(err.Error(), `overlay "prod" is not found`) {
+			t.Fatalf("expected not found error but got %v", err)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, test := range []struct {
+			name     string
+			overlays string
+			expected string
+		}{
+			{
+				name: "unknown field",
+				overlays: `
+  overlays:
+    - name: dev
+      patch:
+        maxObject: 10
+`,
+				expected: `unknown field "maxObject"`,
+			},
+			{
+				name: "metadata",
+				overlays: `
+  overlays:
+    - name: dev
+      patch:
+        metadata:
+          name: dev
+`,
+				expected: "only metadata.namespace can be patched",
+			},
+			{
+				name: "duplicated name",
+				overlays: `
+  overlays:
+    - name: dev
+      patch: {maxObjects: 10}
+    - name: dev
+      patch: {maxObjects: 20}
+`,
+				expected: "overlay name 'dev' is duplicated",
+			},
+			{
+				name: "invalid resolved spec",
+				overlays: `
+  overlays:
+    - name: dev
+      patch: {maxObjects: -1}
+`,
+				expected: "invalid spec resolved by overlay 'dev'",
+			},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				testjob := loadTestJob(t, test.overlays)
+				if err := testjob.Validate(); err == nil || !strings.Contains(err.Error(), test.expected) {
+					t.Fatalf("expected error %q but got %v", test.expected, err)
+				}
+			})
+		}
+	})
+}
@@ -9,7 +9,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/google/go-github/v54/github"
@@ -25,6 +27,10 @@ type Token struct {
 type TokenManager struct {
 	tokenMap map[string]TokenSource
 	cli      *TokenClient
+	workDir  string
+	mu       sync.Mutex
+	// resolved values of the tokens resolved by TokenByName by the name.
+	resolved map[string]string
 }
 
 func NewTokenManager(tokens []TokenSpec, cli *TokenClient) *TokenManager {
@@ -35,11 +41,18 @@ func NewTokenManager(tokens []TokenSpec, cli *TokenClient) *TokenManager {
 	return &TokenManager{
 		tokenMap: tokenMap,
 		cli:      cli,
+		resolved: map[string]string{},
 	}
 }
 
+// SetWorkDir set the directory to write the token files.
+// If empty string is specified, the default directory for temporary files is used.
+func (m *TokenManager) SetWorkDir(dir string) {
+	m.workDir = dir
+}
+
 func (m *TokenManager) TokenByName(ctx context.Context, name string) (*Token, error) {
-	dir, err := os.MkdirTemp("", "token")
+	dir, err := os.MkdirTemp(m.workDir, "token")
 	if err != nil {
 		return nil, fmt.Errorf("kubetest: failed to create temporary directory for token: %w", err)
 	}
@@ -57,12 +70,31 @@ func (m *TokenManager) TokenByName(ctx context.Context, name string) (*Token, er
 		return nil, fmt.Errorf("kubetest: failed to write token to %s: %w", file, err)
 	}
 	LoggerFromContext(ctx).AddMask(value)
+	m.mu.Lock()
+	m.resolved[name] = value
+	m.mu.Unlock()
 	return &Token{
 		File:  file,
 		Value: value,
 	}, nil
 }
 
+// credentials returns the tokens resolved so far to check that they are masked in the log.
+func (m *TokenManager) credentials() []credential {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.resolved))
+	for name := range m.resolved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	credentials := make([]credential, 0, len(names))
+	for _, name := range names {
+		credentials = append(credentials, credential{name: fmt.Sprintf("token %s", name), value: m.resolved[name]})
+	}
+	return credentials
+}
+
 type TokenClient struct {
 	clientset *kubernetes.Clientset
 	namespace string
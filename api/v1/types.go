@@ -2,7 +2,10 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // TestJobSpec defines the desired state of TestJob
@@ -18,18 +21,137 @@ type TestJobSpec struct {
 	// In addition, when performing distributed execution, the work that must be performed at the distributed execution destination is reduced,
 	// so the resources of kubernetes cluster can be used efficiently.
 	// +optional
-	PreSteps []PreStep `json:"preSteps,omitempty"`
+	PreSteps []PreStep `json:"preSteps,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
 	// MainStep defines the behavior when running the main task. This step can be distributed.
 	MainStep MainStep `json:"mainStep"`
 	// PostSteps defines post-processing to export artifacts.
 	// +optional
-	PostSteps []PostStep `json:"postSteps,omitempty"`
+	PostSteps []PostStep `json:"postSteps,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
 	// ExportArtifacts export what was saved as an artifact to any path.
 	// +optional
 	ExportArtifacts []ExportArtifact `json:"exportArtifacts,omitempty"`
 	// Log extend parameter to output log.
 	// +optional
 	Log LogSpec `json:"log,omitempty"`
+	// MaxObjects maximum number of kubernetes objects ( Job and Pod ) created by a run.
+	// If the planned number of objects exceeds this value, kubetest refuses to start the run.
+	// The number planned from the static keys and all steps is checked by Validate before anything runs.
+	// The tasks of the dynamic keys and the retries are checked when they are scheduled. 0 means unlimited.
+	// +optional
+	MaxObjects int `json:"maxObjects,omitempty"`
+	// AllowDangerousPaths allows mount paths and artifact paths under the system directories ( e.g. /etc, /usr ).
+	// kubetest removes and recreates the mount point of repository, so these paths are rejected by default.
+	// +optional
+	AllowDangerousPaths bool `json:"allowDangerousPaths,omitempty"`
+	// EnvFrom list of sources to populate environment variables of all test containers ( e.g. ConfigMap, Secret ).
+	// These are applied before EnvFrom of each container, so the container can override them.
+	// The values of referenced secrets are masked in the log.
+	// +optional
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+	// ImagePrefix prefix prepended to the images of all containers ( e.g. the host of pull-through mirror ).
+	// The image already having the prefix isn't rewritten.
+	// +optional
+	ImagePrefix string `json:"imagePrefix,omitempty"`
+	// VerifyImages checks that the manifests of all images exist in the registries before creating any Job.
+	// The credentials of imagePullSecrets are used to access the registries.
+	// Disable this for the registry that doesn't allow checking manifests ( e.g. air-gapped registry ).
+	// +optional
+	VerifyImages bool `json:"verifyImages,omitempty"`
+	// Scratch adds a scratch volume to every pod and mounts it to all containers including the init containers and the finalizer.
+	// TMPDIR of the containers is set to the mount path unless it is already specified.
+	// +optional
+	Scratch *ScratchSpec `json:"scratch,omitempty"`
+	// OutputArtifact name of the artifact to save the combined output of each task as <task>-output.txt.
+	// The shards of mainStep are saved as mainStep-shard<index>-output.txt.
+	// The file has the command, the masked output and the status of every subtask in execution order,
+	// so the output of a pod can be searched without mounting the log volume. The artifact can be exported by exportArtifacts.
+	// +optional
+	OutputArtifact string `json:"outputArtifact,omitempty"`
+	// KeepResources when to keep the Jobs and pods created by the run for postmortem instead of relying on ttlSecondsAfterFinished ( default: never ).
+	// +optional
+	KeepResources KeepResourcesPolicy `json:"keepResources,omitempty"`
+	// Debug holds the pod of the failed test with the ephemeral container for live debugging.
+	// This is only for the interactive run and ignored if CI environment variable is set.
+	// +optional
+	Debug *DebugSpec `json:"debug,omitempty"`
+	// Hermetic forbids the network access from the pods of the run except the allowlist and the DNS
+	// by the NetworkPolicy created before the tasks start and deleted after the run.
+	// +optional
+	Hermetic *HermeticSpec `json:"hermetic,omitempty"`
+	// Fixtures manifests of the objects ( e.g. the database seeded for the tests ) applied before the first task and deleted after the run.
+	// kubetest waits until the Deployments are available and the Jobs are complete before starting the tasks.
+	// The objects are kept with the Jobs of the run if keepResources requires it.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Fixtures []runtime.RawExtension `json:"fixtures,omitempty"`
+	// Overlays named patches for the environments ( e.g. dev, staging, prod ) merged over this spec by ApplyOverlay.
+	// +optional
+	Overlays []TestJobOverlay `json:"overlays,omitempty"`
+}
+
+// TestJobOverlay patch of TestJobSpec selected by name at run time.
+type TestJobOverlay struct {
+	// Name name of the overlay.
+	Name string `json:"name"`
+	// Patch partial TestJobSpec merged over the spec by strategic merge patch.
+	// The containers, the initContainers, the preSteps and the postSteps are merged by name, the other lists are replaced.
+	// metadata.namespace in the patch overrides the namespace of the TestJob.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Patch runtime.RawExtension `json:"patch"`
+}
+
+// KeepResourcesPolicy when to keep the kubernetes objects created by the run.
+type KeepResourcesPolicy string
+
+const (
+	// KeepResourcesNever the objects are deleted by ttlSecondsAfterFinished of the steps as usual.
+	KeepResourcesNever KeepResourcesPolicy = "never"
+	// KeepResourcesOnFailure the objects are kept if the run fails.
+	// ttlSecondsAfterFinished of the Jobs is removed while running and restored if the run succeeds.
+	KeepResourcesOnFailure KeepResourcesPolicy = "onFailure"
+	// KeepResourcesAlways the objects are always kept. ttlSecondsAfterFinished of the Jobs is ignored.
+	KeepResourcesAlways KeepResourcesPolicy = "always"
+)
+
+// DebugSpec describes the ephemeral container added to the pod of the failed test.
+type DebugSpec struct {
+	// Enabled adds the ephemeral container to the pod when the test fails
+	// and delays deleting the Job until HoldDuration elapses or kubetest receives SIGUSR1.
+	// The failure is logged before holding the pod. Each pod is held once in the background, so the other tests of the pod keep running,
+	// and the held pods are released at the end of the run. This works only in kubernetes run mode.
+	Enabled bool `json:"enabled,omitempty"`
+	// Image image of the ephemeral container.
+	Image string `json:"image"`
+	// Command command of the ephemeral container ( default: sh ).
+	// +optional
+	Command []string `json:"command,omitempty"`
+	// HoldDuration maximum time to hold the pod by Go's time.Duration format ( default: 10m ).
+	// +optional
+	HoldDuration string `json:"holdDuration,omitempty"`
+}
+
+// ScratchSpec describes the scratch volume mounted to all containers.
+type ScratchSpec struct {
+	// SizeLimit total amount of local storage of the scratch volume.
+	// +optional
+	SizeLimit *resource.Quantity `json:"sizeLimit,omitempty"`
+	// Medium storage medium of the scratch volume. This is the same as the medium of emptyDir ( e.g. Memory ).
+	// +optional
+	Medium corev1.StorageMedium `json:"medium,omitempty"`
+	// MountPath path to mount the scratch volume in the containers.
+	MountPath string `json:"mountPath"`
+}
+
+// HermeticSpec describes the egress allowed from the pods of the run.
+type HermeticSpec struct {
+	// Enabled creates the NetworkPolicy denying the egress of the pods selected by the run id label.
+	Enabled bool `json:"enabled"`
+	// AllowedCIDRs IP blocks the pods can connect to ( e.g. 10.0.0.0/8 ).
+	// +optional
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+	// AllowedNamespaces namespaces having the pods the pods of the run can connect to ( e.g. the namespace of the test database ).
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
 }
 
 // RepositorySpec describes the specification of repository.
@@ -57,6 +179,35 @@ type Repository struct {
 	// If the target repository has already been cloned and the directory is not empty,
 	// it will be reused ( doesn't clone ).
 	ClonedPath string `json:"clonedPath,omitempty"`
+	// PrepareCommands commands to run in the cloned directory before archiving the repository.
+	// This is used to complete the repository for tests ( e.g. git submodule update --init, git lfs pull ).
+	// If clonedPath is specified, the commands run in the copy of it in the working directory, so clonedPath isn't changed.
+	// +optional
+	PrepareCommands []RepositoryCommand `json:"prepareCommands,omitempty"`
+	// ArchiveCompression compression of the archive to transfer the repository to the containers ( default: gzip ).
+	// zstd is faster than gzip at similar ratio. If tar of the container doesn't support zstd, gzip is used for the container.
+	// none is the fastest if the files of the repository are already compressed.
+	// +optional
+	ArchiveCompression ArchiveCompression `json:"archiveCompression,omitempty"`
+	// DiffBase base revision ( e.g. origin/main or commit hash ) to compute the paths changed by the checked-out revision.
+	// The changed paths are computed by git diff --name-only <diffBase>...HEAD and used by runIfChanged of the steps.
+	// +optional
+	DiffBase string `json:"diffBase,omitempty"`
+}
+
+// ArchiveCompression compression of the repository archive.
+type ArchiveCompression string
+
+const (
+	ArchiveCompressionGzip ArchiveCompression = "gzip"
+	ArchiveCompressionZstd ArchiveCompression = "zstd"
+	ArchiveCompressionNone ArchiveCompression = "none"
+)
+
+// RepositoryCommand describes the command to prepare the repository.
+type RepositoryCommand struct {
+	// Command to run. The first element is the executable.
+	Command []string `json:"command"`
 }
 
 // MergeSpec describes the specification of merge behavior.
@@ -97,6 +248,12 @@ type PreStep struct {
 	Name                    string              `json:"name"`
 	TTLSecondsAfterFinished *int32              `json:"ttlSecondsAfterFinished,omitempty"`
 	Template                TestJobTemplateSpec `json:"template"`
+	// RunIfChanged paths relative to the repositories which have diffBase. The step is skipped if none of them changed.
+	// Each path is a directory or a pattern of path.Match ( e.g. services/api, docs/*.md ).
+	// The artifacts of the skipped step are not created. If the other steps which run use them,
+	// the paths to the existing artifacts must be specified ( e.g. --artifact ), or the run fails before running any step.
+	// +optional
+	RunIfChanged []string `json:"runIfChanged,omitempty"`
 }
 
 func (s *PreStep) GetName() string {
@@ -122,6 +279,54 @@ type MainStep struct {
 	Strategy                *Strategy           `json:"strategy,omitempty"`
 	TTLSecondsAfterFinished *int32              `json:"ttlSecondsAfterFinished,omitempty"`
 	Template                TestJobTemplateSpec `json:"template"`
+	// OnFailureCommand command to collect diagnostics ( e.g. dump logs, list processes ) when the test fails.
+	// It is executed in the same container before the container is stopped, and the output is appended to the output of the test.
+	// Each argument is passed to the command as is. Use [sh, -c, ...] to run it by the shell.
+	// +optional
+	OnFailureCommand []string `json:"onFailureCommand,omitempty"`
+	// StopGracePeriod time to wait after the test finishes before copying artifacts and stopping the container
+	// by Go's time.Duration format ( e.g. 5s ). This gives the background processes of the test a chance to flush their files.
+	// +optional
+	StopGracePeriod string `json:"stopGracePeriod,omitempty"`
+	// CommandWrapper command prefixed to the command of each test ( e.g. [timeout, 300, coverage, run] ).
+	// This instruments the tests without modifying the list of tests or the images.
+	// The main container must specify the command because the entrypoint of the image isn't known.
+	// The report and the logs show the original command of the test.
+	// +optional
+	CommandWrapper []string `json:"commandWrapper,omitempty"`
+	// EmptyOutput how the test exited with 0 but produced no output is handled ( default: allow ).
+	// This catches the silent no-op tests ( e.g. the filter of the test framework matched nothing ).
+	// +optional
+	EmptyOutput EmptyOutputPolicy `json:"emptyOutput,omitempty"`
+	// Coverage collects the coverage profile written by the main container of each test ( e.g. go test -coverprofile )
+	// and merges them into a single profile after running all tests.
+	// +optional
+	Coverage *CoverageSpec `json:"coverage,omitempty"`
+	// RunIfChanged paths relative to the repositories which have diffBase. The step is skipped if none of them changed.
+	// Each path is a directory or a pattern of path.Match ( e.g. services/api, docs/*.md ).
+	// The tests of the skipped mainStep are not run and the report has skipped status.
+	// +optional
+	RunIfChanged []string `json:"runIfChanged,omitempty"`
+}
+
+// EmptyOutputPolicy how the test succeeded without output is handled.
+type EmptyOutputPolicy string
+
+const (
+	// EmptyOutputPolicyAllow the result is decided by the exit code only.
+	EmptyOutputPolicyAllow EmptyOutputPolicy = "allow"
+	// EmptyOutputPolicyFail the test exited with 0 but produced no output ( or only white spaces ) fails.
+	EmptyOutputPolicyFail EmptyOutputPolicy = "fail"
+)
+
+// CoverageSpec
+type CoverageSpec struct {
+	// Name of the artifact having the merged coverage profile.
+	// The artifact can be exported by exportArtifacts and mounted by the artifact volume of postSteps.
+	Name string `json:"name"`
+	// Path to the Go coverage profile written by the main container of each test.
+	// The same block reported by multiple tests is merged ( count and atomic mode sum the counts, set mode keeps the covered block ).
+	Path string `json:"path"`
 }
 
 func (s *MainStep) GetName() string {
@@ -145,6 +350,11 @@ type PostStep struct {
 	Name                    string              `json:"name"`
 	TTLSecondsAfterFinished *int32              `json:"ttlSecondsAfterFinished,omitempty"`
 	Template                TestJobTemplateSpec `json:"template"`
+	// RunIfChanged paths relative to the repositories which have diffBase. The step is skipped if none of them changed.
+	// Each path is a directory or a pattern of path.Match ( e.g. services/api, docs/*.md ).
+	// The artifacts of the skipped step are not created.
+	// +optional
+	RunIfChanged []string `json:"runIfChanged,omitempty"`
 }
 
 func (s *PostStep) GetName() string {
@@ -177,13 +387,45 @@ type TestJobTemplateSpec struct {
 // TestJobPodSpec
 type TestJobPodSpec struct {
 	corev1.PodSpec     `json:",inline"`
-	InitContainers     []TestJobContainer `json:"initContainers,omitempty"`
-	Containers         []TestJobContainer `json:"containers"`
+	InitContainers     []TestJobContainer `json:"initContainers,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+	Containers         []TestJobContainer `json:"containers" patchStrategy:"merge" patchMergeKey:"name"`
 	FinalizerContainer TestJobContainer   `json:"finalizerContainer"`
-	Volumes            []TestJobVolume    `json:"volumes,omitempty"`
-	Artifacts          []ArtifactSpec     `json:"artifacts,omitempty"`
+	// FinalizerPriorityClassName priorityClassName of the pod having the finalizer container.
+	// This prevents the pod from being preempted before the finalizer runs ( default: priorityClassName ).
+	// +optional
+	FinalizerPriorityClassName string `json:"finalizerPriorityClassName,omitempty"`
+	// FinalizerVerdictMode how the verdict written by the finalizer container is applied to the result of the task.
+	// The finalizer writes the verdict as JSON ( e.g. {"status":"warning","message":"coverage is 79%"} ) to the path of KUBETEST_VERDICT_PATH environment variable.
+	// If the finalizer doesn't write the verdict, the exit code of the finalizer is used.
+	// +optional
+	FinalizerVerdictMode FinalizerVerdictMode `json:"finalizerVerdictMode,omitempty"`
+	Volumes              []TestJobVolume      `json:"volumes,omitempty"`
+	Artifacts            []ArtifactSpec       `json:"artifacts,omitempty"`
+	// Ulimits resource limits of the processes of the containers ( default: unset ).
+	// In local mode, the limits are applied to the commands run by kubetest.
+	// In kubernetes mode, the limits are decided by the node and the container runtime, so they are only added to the pod
+	// as kubetest.io/ulimit-nofile annotation for the runtime or the admission webhook supporting it.
+	// +optional
+	Ulimits *UlimitSpec `json:"ulimits,omitempty"`
 }
 
+// UlimitSpec describes the resource limits of the processes.
+type UlimitSpec struct {
+	// NoFile maximum number of the open file descriptors ( ulimit -n ).
+	// +optional
+	NoFile *int64 `json:"nofile,omitempty"`
+}
+
+// FinalizerVerdictMode how the verdict of the finalizer container is applied.
+type FinalizerVerdictMode string
+
+const (
+	// FinalizerVerdictModeEnforce the failure verdict fails the task. The other verdicts pass the task even if the finalizer exits with error.
+	FinalizerVerdictModeEnforce FinalizerVerdictMode = "enforce"
+	// FinalizerVerdictModeAdvisory the verdict is only logged and never fails the task.
+	FinalizerVerdictModeAdvisory FinalizerVerdictMode = "advisory"
+)
+
 // TestAgentSpec describes the specification of kubetest-agent.
 type TestAgentSpec struct {
 	// Installed path to the kubetest-agent e.g.) /bin/kubetest-agent
@@ -204,8 +446,24 @@ type TestAgentSpec struct {
 type TestJobContainer struct {
 	corev1.Container `json:",inline"`
 	Agent            *TestAgentSpec `json:"agent,omitempty"`
+	// Role role of the container in the pod ( default: main if the container is template.main, otherwise sidecar ).
+	// +optional
+	Role ContainerRole `json:"role,omitempty"`
 }
 
+// ContainerRole role of the container in the pod.
+type ContainerRole string
+
+const (
+	// ContainerRoleMain the container runs the test. This can be used instead of template.main.
+	ContainerRoleMain ContainerRole = "main"
+	// ContainerRoleSidecar the container runs in the background while the tests are running.
+	ContainerRoleSidecar ContainerRole = "sidecar"
+	// ContainerRolePost the container runs once in the same pod after all tests of the pod finish, before the finalizer.
+	// The result is reported as well as the tests.
+	ContainerRolePost ContainerRole = "post"
+)
+
 // ArtifactSpec describes the specification of artifact for each process.
 type ArtifactSpec struct {
 	// Name specify the name to be used when referencing the token in the TestJob resource.
@@ -213,8 +471,32 @@ type ArtifactSpec struct {
 	Name string `json:"name"`
 	// Container
 	Container ArtifactContainer `json:"container"`
+	// Conflict behavior when the same artifact name is declared by multiple containers.
+	// All declarations of the same name must specify the same policy.
+	// Default policy is error.
+	// +optional
+	Conflict ArtifactConflictPolicy `json:"conflict,omitempty"`
+	// Deduplicate stores the identical artifacts copied from multiple containers ( e.g. the containers of strategy keys ) only once.
+	// The artifact of each container is replaced with the symbolic link to the shared copy, so the layout of the artifact doesn't change.
+	// All declarations of the same name must specify the same value. This cannot be used with merge policy.
+	// +optional
+	Deduplicate bool `json:"deduplicate,omitempty"`
 }
 
+// ArtifactConflictPolicy behavior when the same artifact name is declared by multiple containers.
+type ArtifactConflictPolicy string
+
+const (
+	// ArtifactConflictError rejects the same artifact name declared by multiple containers at validation.
+	ArtifactConflictError ArtifactConflictPolicy = "error"
+	// ArtifactConflictMerge merges the artifacts copied from all containers into a single directory.
+	// If the same file is copied from multiple containers, it is reported as error.
+	ArtifactConflictMerge ArtifactConflictPolicy = "merge"
+	// ArtifactConflictPerContainer stores the artifacts under the directories named by each container.
+	// The mounted or exported artifact always has the container name directories.
+	ArtifactConflictPerContainer ArtifactConflictPolicy = "perContainer"
+)
+
 // ArtifactContainer
 type ArtifactContainer struct {
 	// Name for the container
@@ -254,6 +536,10 @@ type RepositoryVolumeSource struct {
 type ArtifactVolumeSource struct {
 	// This must match the Name of a ArtifactSpec.
 	Name string `json:"name"`
+	// Mode permission bits set to the files of the artifact after mounting ( e.g. 0755 for the compiled test binaries ).
+	// Directories are not changed. If not specified, the permissions of the copied files are preserved.
+	// +optional
+	Mode *int32 `json:"mode,omitempty"`
 }
 
 // TokenVolumeSource
@@ -263,7 +549,12 @@ type TokenVolumeSource struct {
 }
 
 // LogVolumeSource
-type LogVolumeSource struct{}
+type LogVolumeSource struct {
+	// Compress copies the log compressed by gzip. The file is mounted with .gz suffix ( e.g. kubetest.log.gz ),
+	// and the path is set to KUBETEST_LOG_PATH environment variable of the container.
+	// +optional
+	Compress bool `json:"compress,omitempty"`
+}
 
 // ReportFormatType format type of report
 type ReportFormatType string
@@ -279,29 +570,218 @@ const (
 	ResultStatusSuccess ResultStatus = "success"
 	ResultStatusFailure              = "failure"
 	ResultStatusError                = "error"
+	// ResultStatusSkipped status of the run whose mainStep was skipped by runIfChanged.
+	ResultStatusSkipped = "skipped"
 )
 
 type Report struct {
-	Status         ResultStatus      `json:"status"`
-	StartedAt      metav1.Time       `json:"startedAt"`
-	ElapsedTimeSec int64             `json:"elapsedTimeSec"`
-	TotalNum       int               `json:"totalNum"`
-	SuccessNum     int               `json:"successNum"`
-	FailureNum     int               `json:"failureNum"`
-	UnknownNum     int               `json:"unknownNum,omitempty"`
-	Details        []*ReportDetail   `json:"details"`
-	ExtParam       map[string]string `json:"ext,omitempty"`
+	// RunID identifier of the run. The same value is attached to the created Jobs and Pods as kubetest.io/run label.
+	RunID  string       `json:"runID,omitempty"`
+	Status ResultStatus `json:"status"`
+	// Interrupted whether the run was canceled before all tests finished.
+	// The report of the interrupted run contains only the results of the finished tests.
+	Interrupted    bool        `json:"interrupted,omitempty"`
+	StartedAt      metav1.Time `json:"startedAt"`
+	ElapsedTimeSec int64       `json:"elapsedTimeSec"`
+	TotalNum       int         `json:"totalNum"`
+	SuccessNum     int         `json:"successNum"`
+	FailureNum     int         `json:"failureNum"`
+	UnknownNum     int         `json:"unknownNum,omitempty"`
+	// InternalErrorNum number of the shards ( pods ) failed by internal errors.
+	InternalErrorNum int               `json:"internalErrorNum,omitempty"`
+	Details          []*ReportDetail   `json:"details"`
+	ExtParam         map[string]string `json:"ext,omitempty"`
+	Objects          []ReportObject    `json:"objects,omitempty"`
+	// ShardBalance how balanced the shards ( pods ) of mainStep were.
+	ShardBalance *ReportShardBalance `json:"shardBalance,omitempty"`
+	// ShuffleSeed seed used to shuffle the keys of mainStep. This is set only if strategy.scheduler.shuffleKeys is enabled.
+	ShuffleSeed *int64 `json:"shuffleSeed,omitempty"`
+	// FirstFailureAt time the first failure of the tests was observed. This is not set if all tests succeeded.
+	// The time to the first failure is the difference from StartedAt.
+	FirstFailureAt *metav1.Time `json:"firstFailureAt,omitempty"`
+	// CircuitBreaker infrastructure failures which stopped the run. This is set only if the circuit breaker tripped.
+	CircuitBreaker *ReportCircuitBreaker `json:"circuitBreaker,omitempty"`
+	// Artifacts files saved as artifacts by kubetest ( e.g. the combined output of each task ).
+	Artifacts []ReportArtifact `json:"artifacts,omitempty"`
+	// PreSteps results of the presteps run before mainStep.
+	PreSteps []ReportPreStep `json:"preSteps,omitempty"`
+	// SkippedSteps names of the steps skipped because none of the paths of runIfChanged changed.
+	// The name of mainStep is "mainStep".
+	SkippedSteps []string `json:"skippedSteps,omitempty"`
+	// APIRequests number of the requests to the API server sent by the run.
+	APIRequests *ReportAPIRequests `json:"apiRequests,omitempty"`
+	// Repositories commit checked out for each repository the tests ran against.
+	Repositories []ReportRepository `json:"repositories,omitempty"`
+	// AuxiliaryDetails results of the containers of mainStep other than the tests ( e.g. post containers ).
+	// They are only for debugging and don't affect the status and the numbers of the report.
+	AuxiliaryDetails []*ReportDetail `json:"auxiliaryDetails,omitempty"`
+	// Finalizers results of the finalizer containers of mainStep.
+	Finalizers []ReportFinalizer `json:"finalizers,omitempty"`
+	// ClockSkewMillis offset applied to startedAt and firstFailureAt for the skew of the clock of the API server from kubetest.
+	// This is set only if the adjustment is enabled and the skew is detected.
+	ClockSkewMillis int64 `json:"clockSkewMillis,omitempty"`
+	// AttachedRunID identifier of the run having the same idempotency key which this run attached to instead of running the tests.
+	// The results of the tests are reported by that run.
+	AttachedRunID string `json:"attachedRunID,omitempty"`
+	// Baseline comparison with the baseline report set by Runner.SetCompareBaseline.
+	// If this is set, the run fails only if some tests newly failed compared to the baseline.
+	Baseline *ReportBaseline `json:"baseline,omitempty"`
+	// Tasks status of each task of mainStep. This is set only if some tasks failed to run ( e.g. the pod couldn't start ),
+	// because the tests of those tasks have no results and the details can't tell why they are missing.
+	Tasks []ReportTask `json:"tasks,omitempty"`
+}
+
+// ReportTask status of the task of mainStep.
+type ReportTask struct {
+	// Name name of the task. The index of the shard is appended to the task of the distributed strategy ( e.g. test shard-1 ).
+	Name   string       `json:"name"`
+	Status ResultStatus `json:"status"`
+	// Error masked error of the task which failed to run. This is empty if the task ran the tests.
+	Error string `json:"error,omitempty"`
+}
+
+// ReportBaseline comparison of the tests with the baseline report. Each list is sorted by the name of the test.
+type ReportBaseline struct {
+	// Path path to the baseline report.
+	Path string `json:"path"`
+	// NewFailures tests failed in the run but not in the baseline. This includes the added tests that failed.
+	NewFailures []string `json:"newFailures"`
+	// KnownFailures tests failed in both the run and the baseline. They are tolerated.
+	KnownFailures []string `json:"knownFailures"`
+	// Improvements tests failed in the baseline but succeeded in the run.
+	Improvements []string `json:"improvements"`
+}
+
+// ReportFinalizer result of the finalizer container of the task.
+type ReportFinalizer struct {
+	Container string       `json:"container"`
+	Status    ResultStatus `json:"status"`
+	// Degraded whether the finalizer ran although the setup of the pod failed ( e.g. extracting the repository ).
+	// The finalizer ran without the tests and the volumes which couldn't be prepared.
+	Degraded bool `json:"degraded,omitempty"`
+	// Reason masked error of the setup which degraded the finalizer.
+	Reason string `json:"reason,omitempty"`
+	// Output masked output of the finalizer. This is recorded only if the finalizer failed or was degraded.
+	Output string `json:"output,omitempty"`
+}
+
+// ReportRepository commit checked out for the repository.
+type ReportRepository struct {
+	Name string `json:"name"`
+	// Ref branch checked out ( e.g. the branch of the repository ). This is empty if rev is specified or HEAD of the reused directory is detached.
+	Ref string `json:"ref,omitempty"`
+	// SHA hash of the commit checked out. If merge is specified, this is the merge commit.
+	SHA string `json:"sha"`
+}
+
+// ReportAPIRequests number of the requests to the API server sent by the run.
+type ReportAPIRequests struct {
+	Total int `json:"total"`
+	// Requests number of the requests by verb and resource ( e.g. create pods/exec ) sorted by the number in descending order.
+	Requests []ReportAPIRequestCount `json:"requests,omitempty"`
+	// Tasks number of the requests sent by each task. The name of mainStep is "mainStep",
+	// and the shards of mainStep are named with their index ( e.g. "mainStep shard-0" ).
+	// The requests sent out of the tasks ( e.g. setup and cleanup of the run ) are counted only in Total.
+	Tasks []ReportTaskAPIRequests `json:"tasks,omitempty"`
+}
+
+// ReportAPIRequestCount number of the requests by verb and resource.
+type ReportAPIRequestCount struct {
+	Verb     string `json:"verb"`
+	Resource string `json:"resource"`
+	Count    int    `json:"count"`
+}
+
+// ReportTaskAPIRequests number of the requests sent by the task.
+type ReportTaskAPIRequests struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ReportPreStep result of the prestep.
+type ReportPreStep struct {
+	Name   string       `json:"name"`
+	Status ResultStatus `json:"status"`
+	// Output masked command, output and status of each container of the failed prestep.
+	// This is empty if the prestep succeeded.
+	Output string `json:"output,omitempty"`
+}
+
+// ReportArtifact file saved as an artifact by kubetest.
+type ReportArtifact struct {
+	// Name name of the artifact having the file.
+	Name string `json:"name"`
+	// File name of the file in the artifact.
+	File string `json:"file"`
+}
+
+// ReportCircuitBreaker infrastructure failures which tripped the circuit breaker set by Runner.SetCircuitBreaker.
+type ReportCircuitBreaker struct {
+	// FailureNum number of the infrastructure failures within the window.
+	FailureNum int `json:"failureNum"`
+	// WindowSec window to count the infrastructure failures.
+	WindowSec float64 `json:"windowSec"`
+	// Reasons distinct reasons of the failures with the number of times ( e.g. pod stayed pending ( x2 ) ).
+	Reasons []string `json:"reasons"`
 }
 
 type ReportDetail struct {
 	Status         ResultStatus `json:"status"`
 	Name           string       `json:"name"`
 	ElapsedTimeSec int64        `json:"elapsedTimeSec"`
+	// FailureKind kind of the failure ( e.g. oomKilled, oomKilledSuspected ). This is empty if the kind couldn't be detected.
+	FailureKind FailureKind `json:"failureKind,omitempty"`
+	// Metadata metadata of the test got with the strategy key ( e.g. owner, component ).
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Phase phase of the pod in which the result was decided. setup means the test couldn't run
+	// because the init containers or preparing the container ( e.g. extracting the repository ) failed.
+	// finalizer means the finalizer of the task failed. It's named by the task and the finalizer container ( e.g. mainStep shard-0/finalizer ).
+	Phase ContainerPhase `json:"phase,omitempty"`
+	// Message message of the failure originated in the phase other than main
+	// ( e.g. setup failed while extracting repository 'repo' in container 'test' ).
+	Message string `json:"message,omitempty"`
+}
+
+// ReportShardBalance wall time of each shard and the imbalance between them.
+type ReportShardBalance struct {
+	Shards []ReportShard `json:"shards"`
+	// ImbalanceRatio ratio of the longest shard duration to the shortest shard duration.
+	// If the shortest shard duration is zero, this is zero.
+	ImbalanceRatio float64 `json:"imbalanceRatio"`
+}
+
+// ReportShard wall time running the tests of a shard.
+// The duration is measured from starting the tests of the shard until all of them finish, which excludes the setup of the pod,
+// the post containers and the finalizer. The tests failed before running aren't counted.
+type ReportShard struct {
+	// Index index of the shard ( e.g. 1 of mainStep shard-1 ).
+	Index          int     `json:"index"`
+	TestNum        int     `json:"testNum"`
+	ElapsedTimeSec float64 `json:"elapsedTimeSec"`
+	// EstimatedTimeSec duration expected by the number of tests of the shard.
+	// It's the total duration of the shards distributed in proportion to the number of tests,
+	// so the difference from ElapsedTimeSec shows the shard whose tests are slower than the others.
+	EstimatedTimeSec float64 `json:"estimatedTimeSec"`
+}
+
+// ReportObject kubernetes object created by kubetest.
+type ReportObject struct {
+	APIVersion string    `json:"apiVersion,omitempty"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid,omitempty"`
+	// Kept whether the object is kept for postmortem by keepResources.
+	Kept bool `json:"kept,omitempty"`
 }
 
 // ReportVolumeSource
 type ReportVolumeSource struct {
 	Format ReportFormatType `json:"format"`
+	// Compress copies the report compressed by gzip. The file is mounted with .gz suffix ( e.g. report.json.gz ),
+	// and the path is set to KUBETEST_REPORT_PATH environment variable of the container.
+	// +optional
+	Compress bool `json:"compress,omitempty"`
 }
 
 // ExportArtifact
@@ -327,6 +807,8 @@ func (l LogLevel) String() string {
 	switch l {
 	case LogLevelNone:
 		return "none"
+	case LogLevelError:
+		return "error"
 	case LogLevelWarn:
 		return "warn"
 	case LogLevelInfo:
@@ -353,6 +835,26 @@ type Strategy struct {
 	Scheduler Scheduler `json:"scheduler"`
 	// Restart testing for failed tests
 	Retest bool `json:"retest,omitempty"`
+	// Smoke the tests run before all other tests.
+	// If any of them fail, the other tests are not run.
+	// +optional
+	Smoke *StrategySmokeSpec `json:"smoke,omitempty"`
+	// InternalErrorThreshold percentage of the shards ( pods ) failed by internal errors to mark the run as error instead of failure.
+	// Internal errors are the failures not caused by the tests ( e.g. the container was lost, the artifact couldn't be copied or the pod never produced the results ).
+	// If more than this percentage of the shards hit internal errors, the status of the report becomes error.
+	// If not specified, the run with failed tests is always marked as failure.
+	// +optional
+	InternalErrorThreshold *int `json:"internalErrorThreshold,omitempty"`
+}
+
+// StrategySmokeSpec
+type StrategySmokeSpec struct {
+	// Keys the keys of the smoke tests.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+	// Pattern regular expression to select the smoke tests from the keys.
+	// +optional
+	Pattern string `json:"pattern,omitempty"`
 }
 
 // StrategyKeySpec
@@ -361,14 +863,53 @@ type StrategyKeySpec struct {
 	Env string `json:"env"`
 	// Source
 	Source StrategyKeySource `json:"source"`
+	// Images image of the main container for each key ( e.g. {"1.21": "golang:1.21", "1.22": "golang:1.22"} ).
+	// The keys not specified use the image of the main container.
+	// +optional
+	Images map[string]string `json:"images,omitempty"`
+	// Resources resources used by the test of each key ( e.g. {"integration": {"cpu": "4", "memory": "8Gi"}} ).
+	// This is used with scheduler.maxResourcesPerPod to pack the tests running concurrently in the same pod.
+	// The keys not specified are regarded as using no resources.
+	// +optional
+	Resources map[string]corev1.ResourceList `json:"resources,omitempty"`
+	// Duplicates how to handle the duplicated keys ( e.g. the names of the parameterized tests ) ( default: allow ).
+	// The duplicated keys collide in the report and the retry of the failed tests.
+	// +optional
+	Duplicates DuplicateKeyPolicy `json:"duplicates,omitempty"`
+	// After keys that must finish before each key starts ( e.g. {"TestB": ["TestA"]} runs TestB after TestA finishes ).
+	// The keys are scheduled in stages so that every key runs after the keys it depends on, even across pods.
+	// The keys without constraints run in the first stage. The constraints for the keys not scheduled together are ignored.
+	// +optional
+	After map[string][]string `json:"after,omitempty"`
 }
 
+// DuplicateKeyPolicy how to handle the duplicated strategy keys.
+type DuplicateKeyPolicy string
+
+const (
+	// DuplicateKeyPolicyAllow runs the duplicated keys as they are and logs them as warning.
+	DuplicateKeyPolicyAllow DuplicateKeyPolicy = "allow"
+	// DuplicateKeyPolicyError fails the run if the keys have duplicates.
+	DuplicateKeyPolicyError DuplicateKeyPolicy = "error"
+	// DuplicateKeyPolicySuffix renames the second and later occurrences of the key by the index suffix ( e.g. TestA#1 ).
+	// The renamed key identifies the test in the report, and the original key is set to the environment variable of the test.
+	DuplicateKeyPolicySuffix DuplicateKeyPolicy = "suffix"
+)
+
 // StrategyKeySource
 type StrategyKeySource struct {
 	// Static
 	Static []string `json:"static,omitempty"`
 	// Dynamic
 	Dynamic *StrategyDynamicKeySource `json:"dynamic,omitempty"`
+	// Union uses both static and dynamic keys. The duplicated keys are removed unless strategy.key.duplicates is error or suffix.
+	// This is used to always run the static keys in addition to the keys found dynamically.
+	// +optional
+	Union bool `json:"union,omitempty"`
+	// Dynamics additional dynamic sources. The keys of all sources are concatenated in order of static, dynamic and dynamics,
+	// and the duplicated keys are removed. The keys of the dynamic sources are got concurrently.
+	// +optional
+	Dynamics []StrategyDynamicKeySource `json:"dynamics,omitempty"`
 }
 
 type StrategyDynamicKeySource struct {
@@ -380,8 +921,39 @@ type StrategyDynamicKeySource struct {
 	Delim string `json:"delimiter,omitempty"`
 	// Filter filter got strategy keys ( use regular expression )
 	Filter string `json:"filter,omitempty"`
+	// InheritVolumes names of the volumes copied from the template of mainStep.
+	// The mounts of the main container for these volumes are also copied to the main container of this template.
+	// +optional
+	InheritVolumes []string `json:"inheritVolumes,omitempty"`
+	// Retries number of retries when the job to get keys failed.
+	// The interval between retries is doubled for each retry up to 30 seconds,
+	// and the retries stop when the total wait would exceed 5 minutes.
+	// +optional
+	Retries int `json:"retries,omitempty"`
+	// Format format of each key ( default: plain ).
+	// If json is specified, each key is a JSON object having the name and the metadata of the test
+	// ( e.g. {"name":"TestA","metadata":{"owner":"team-a","component":"api"}} ).
+	// The metadata is attached to the result of the test, so the report can be grouped by it.
+	// +optional
+	Format KeyFormat `json:"format,omitempty"`
+	// PriorityClassName priorityClassName of the pod to get keys ( default: priorityClassName of mainStep ).
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// PendingTimeout time the pod to get keys can be pending by Go's time.Duration format ( default: 10m ).
+	// The pod isn't recreated by the retries when this time is exceeded.
+	// see details: https://pkg.go.dev/time#ParseDuration.
+	// +optional
+	PendingTimeout string `json:"pendingTimeout,omitempty"`
 }
 
+// KeyFormat format of the strategy key got dynamically.
+type KeyFormat string
+
+const (
+	KeyFormatPlain KeyFormat = "plain"
+	KeyFormatJSON  KeyFormat = "json"
+)
+
 // Scheduler
 type Scheduler struct {
 	// MaxPodNum maximum number of pod.
@@ -392,6 +964,71 @@ type Scheduler struct {
 	MaxContainersPerPod int `json:"maxContainersPerPod"`
 	// MaxConcurrentNumPerPod maximum number of concurrent per pod.
 	MaxConcurrentNumPerPod int `json:"maxConcurrentNumPerPod"`
+	// Allocation assigns a unique value to each test running concurrently in the same pod.
+	// +optional
+	Allocation *SubTaskAllocation `json:"allocation,omitempty"`
+	// AntiAffinity spreads the pods of the same run across nodes by pod anti-affinity.
+	// +optional
+	AntiAffinity *ShardAntiAffinity `json:"antiAffinity,omitempty"`
+	// MaxCPU total CPU requested by the pods running at the same time ( e.g. 32 ).
+	// The pods are started until the sum of their CPU requests reaches this value, and the rest are queued.
+	// The main container must specify the CPU request.
+	// +optional
+	MaxCPU *resource.Quantity `json:"maxCPU,omitempty"`
+	// MaxResourcesPerPod total resources used by the tests running concurrently in the same pod ( e.g. {"cpu": "8", "memory": "16Gi"} ).
+	// The tests are packed in order so that the sum of strategy.key.resources doesn't exceed this value.
+	// The test using more resources than this value runs alone. If not specified, the tests are grouped by maxConcurrentNumPerPod only.
+	// +optional
+	MaxResourcesPerPod corev1.ResourceList `json:"maxResourcesPerPod,omitempty"`
+	// ShuffleKeys runs the keys in random order instead of the order of the source.
+	// This spreads the access to the shared resources ( e.g. external API ) done at the start of the similar tests.
+	// +optional
+	ShuffleKeys bool `json:"shuffleKeys,omitempty"`
+	// ShuffleSeed seed to shuffle the keys. If not specified, a random seed is used.
+	// The seed is logged and recorded in the report, so the order can be reproduced by specifying it.
+	// +optional
+	ShuffleSeed *int64 `json:"shuffleSeed,omitempty"`
+	// SubTaskStartJitter maximum random delay before starting each test by Go's time.Duration format ( e.g. 3s ).
+	// +optional
+	SubTaskStartJitter string `json:"subTaskStartJitter,omitempty"`
+	// Namespaces namespaces to create the shard pods in round-robin by the shard index to spread them over the quota of each namespace.
+	// The secrets referenced by the pods must exist in all namespaces. The other steps run in the namespace of the TestJob.
+	// The Jobs in the other namespaces can't have the owner reference, so they are deleted at the end of the run.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Rebalance distributes the keys evenly over the pods scheduled by maxContainersPerPod,
+	// so the last pod doesn't get only the remainder of the keys. The number of pods doesn't change.
+	// If not set, the last pod much smaller than the others is warned.
+	// +optional
+	Rebalance bool `json:"rebalance,omitempty"`
+}
+
+// ShardAntiAffinity describes the pod anti-affinity between the pods of the same run.
+// The preferred anti-affinity is used by default.
+type ShardAntiAffinity struct {
+	// Required uses the required anti-affinity instead of the preferred one.
+	// If the number of pods exceeds the number of nodes, the rest of the pods remain pending until the others finish.
+	// +optional
+	Required bool `json:"required,omitempty"`
+	// TopologyKey the key of node label to spread pods ( default: kubernetes.io/hostname ).
+	// +optional
+	TopologyKey string `json:"topologyKey,omitempty"`
+}
+
+// SubTaskAllocation describes the pool of values assigned to the tests running concurrently in the same pod.
+// For example, this is useful for the tests that start a server, to avoid the conflict of the listening port.
+type SubTaskAllocation struct {
+	// Env name of env value for the allocated value.
+	Env string `json:"env"`
+	// Values list of values to assign. The number of values must be greater than or equal to maxConcurrentNumPerPod.
+	// If maxConcurrentNumPerPod isn't specified, the tests run concurrently up to the number of values.
+	// Values and StartPort cannot both be set.
+	// +optional
+	Values []string `json:"values,omitempty"`
+	// StartPort assigns ports in order from this value. For example, if this value is 8000, ports are assigned from 8000 to 8001, 8002.
+	// Values and StartPort cannot both be set.
+	// +optional
+	StartPort int `json:"startPort,omitempty"`
 }
 
 // TestJobStatus defines the observed state of TestJob
@@ -4,10 +4,12 @@
 package v1
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 func existsDir(path string) bool {
@@ -27,6 +29,11 @@ func getMainContainerFromTmpl(tmpl TestJobTemplateSpec) (TestJobContainer, error
 		}
 		return TestJobContainer{}, fmt.Errorf("kubetest: couldn't find main container name %s", tmpl.Main)
 	}
+	for _, container := range tmpl.Spec.Containers {
+		if container.Role == ContainerRoleMain {
+			return container, nil
+		}
+	}
 	if len(tmpl.Spec.Containers) == 1 {
 		return tmpl.Spec.Containers[0], nil
 	}
@@ -81,6 +88,34 @@ func copyFile(src, dst string, mode os.FileMode) error {
 	return nil
 }
 
+// gzipFile compresses src by gzip and writes it to src with .gz suffix. Returns the path to the compressed file.
+// The compressed file is created again on every call because src may be rewritten.
+func gzipFile(src string) (string, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to open %s to compress: %w", src, err)
+	}
+	defer srcFile.Close()
+	dst := src + compressedFileSuffix
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to create %s: %w", dst, err)
+	}
+	defer dstFile.Close()
+	gzw := gzip.NewWriter(dstFile)
+	if _, err := io.Copy(gzw, srcFile); err != nil {
+		return "", fmt.Errorf("kubetest: failed to compress %s: %w", src, err)
+	}
+	if err := gzw.Close(); err != nil {
+		return "", fmt.Errorf("kubetest: failed to compress %s: %w", src, err)
+	}
+	// the compressed data may not be written until the file is closed.
+	if err := dstFile.Close(); err != nil {
+		return "", fmt.Errorf("kubetest: failed to write %s: %w", dst, err)
+	}
+	return dst, nil
+}
+
 func copyDir(src, dst string, mode os.FileMode) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
@@ -112,3 +147,47 @@ func copyDir(src, dst string, mode os.FileMode) error {
 	}
 	return nil
 }
+
+// tailBuffer keeps the last limit bytes of the written data.
+// This is used to show the recent output without keeping the whole output in memory.
+// It's safe to write stdout and stderr of the command to the same buffer concurrently.
+type tailBuffer struct {
+	mu        sync.Mutex
+	limit     int
+	buf       []byte
+	truncated int64
+}
+
+func newTailBuffer(limit int) *tailBuffer {
+	return &tailBuffer{limit: limit}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	if b.limit <= 0 {
+		b.truncated += int64(n)
+		return n, nil
+	}
+	if len(p) > b.limit {
+		b.truncated += int64(len(p) - b.limit)
+		p = p[len(p)-b.limit:]
+	}
+	if over := len(b.buf) + len(p) - b.limit; over > 0 {
+		b.truncated += int64(over)
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+// String returns the kept data. If the data was truncated, the number of the dropped bytes is prepended.
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.truncated == 0 {
+		return string(b.buf)
+	}
+	return fmt.Sprintf("... ( %d bytes truncated )\n%s", b.truncated, string(b.buf))
+}
@@ -5,20 +5,41 @@ package v1
 
 import (
 	"fmt"
+	"net"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
+const maxPortNumber = 65535
+
+// dangerousPaths system directories rejected as the path in the container unless allowDangerousPaths is specified.
+// The paths under these directories are also rejected except for the root directory.
+var dangerousPaths = []string{"/", "/etc", "/usr"}
+
 type Validator struct {
-	tokenNameMap    map[string]struct{}
-	repoNameMap     map[string]struct{}
-	artifactNameMap map[string]struct{}
+	tokenNameMap        map[string]struct{}
+	repoNameMap         map[string]struct{}
+	artifactNameMap     map[string]ArtifactSpec
+	allowDangerousPaths bool
+	// hasDiffBase whether any repository has diffBase to compute the changed paths for runIfChanged.
+	hasDiffBase bool
 }
 
 func NewValidator() *Validator {
 	return &Validator{
 		tokenNameMap:    map[string]struct{}{},
 		repoNameMap:     map[string]struct{}{},
-		artifactNameMap: map[string]struct{}{},
+		artifactNameMap: map[string]ArtifactSpec{},
 	}
 }
 
@@ -26,13 +47,80 @@ func (v *Validator) ValidateTestJob(job TestJob) error {
 	if err := v.ValidateTestJobSpec(job.Spec); err != nil {
 		return err
 	}
+	return v.ValidateOverlays(job)
+}
+
+// ValidateOverlays validates the spec resolved by each overlay, so the overlay broken for other environments fails early.
+func (v *Validator) ValidateOverlays(job TestJob) error {
+	overlayNameMap := map[string]struct{}{}
+	for _, overlay := range job.Spec.Overlays {
+		if overlay.Name == "" {
+			return fmt.Errorf("kubetest: overlay name must be specified")
+		}
+		if _, exists := overlayNameMap[overlay.Name]; exists {
+			return fmt.Errorf("kubetest: specified overlay name '%s' is duplicated", overlay.Name)
+		}
+		overlayNameMap[overlay.Name] = struct{}{}
+		if len(overlay.Patch.Raw) == 0 {
+			return fmt.Errorf("kubetest: patch of overlay '%s' must be specified", overlay.Name)
+		}
+		resolved, err := job.ApplyOverlay(overlay.Name)
+		if err != nil {
+			return err
+		}
+		if err := NewValidator().ValidateTestJobSpec(resolved.Spec); err != nil {
+			return fmt.Errorf("kubetest: invalid spec resolved by overlay '%s': %w", overlay.Name, err)
+		}
+	}
 	return nil
 }
 
+// ValidateTestJobData decodes YAML or JSON data of TestJob strictly and validates it.
+// Unknown fields are reported with their path and the closest known field name.
+func (v *Validator) ValidateTestJobData(data []byte) error {
+	jsonData, err := k8syaml.ToJSON(data)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to convert testjob to JSON: %w", err)
+	}
+	testjob, err := decodeTestJobStrict(jsonData)
+	if err != nil {
+		return fmt.Errorf("kubetest: invalid testjob: %w", err)
+	}
+	return v.ValidateTestJob(testjob)
+}
+
 func (v *Validator) ValidateTestJobSpec(spec TestJobSpec) error {
+	// the names defined by the spec validated before are unknown to this spec.
+	v.tokenNameMap = map[string]struct{}{}
+	v.repoNameMap = map[string]struct{}{}
+	v.artifactNameMap = map[string]ArtifactSpec{}
+	v.allowDangerousPaths = spec.AllowDangerousPaths
 	if err := v.ValidateLog(spec.Log); err != nil {
 		return err
 	}
+	if err := v.ValidateMaxObjects(spec); err != nil {
+		return err
+	}
+	if err := v.ValidateImagePrefix(spec.ImagePrefix); err != nil {
+		return err
+	}
+	if err := v.ValidateScratch(spec.Scratch); err != nil {
+		return err
+	}
+	if err := v.ValidateDebug(spec.Debug); err != nil {
+		return err
+	}
+	if err := v.ValidateHermetic(spec.Hermetic); err != nil {
+		return err
+	}
+	if err := v.ValidateFixtures(spec.Fixtures); err != nil {
+		return err
+	}
+	switch spec.KeepResources {
+	case "", KeepResourcesNever, KeepResourcesOnFailure, KeepResourcesAlways:
+	default:
+		return fmt.Errorf("kubetest: unknown keepResources %q", spec.KeepResources)
+	}
 	for _, token := range spec.Tokens {
 		if err := v.ValidateToken(token); err != nil {
 			return err
@@ -50,6 +138,9 @@ func (v *Validator) ValidateTestJobSpec(spec TestJobSpec) error {
 			return fmt.Errorf("kubetest: specified repository name '%s' is duplicated", repo.Name)
 		}
 		v.repoNameMap[repo.Name] = struct{}{}
+		if repo.Value.DiffBase != "" {
+			v.hasDiffBase = true
+		}
 	}
 	for _, prestep := range spec.PreSteps {
 		if err := v.ValidatePreStep(prestep); err != nil {
@@ -64,6 +155,9 @@ func (v *Validator) ValidateTestJobSpec(spec TestJobSpec) error {
 			return err
 		}
 	}
+	if err := v.ValidateOutputArtifact(spec.OutputArtifact); err != nil {
+		return err
+	}
 	for _, artifact := range spec.ExportArtifacts {
 		if err := v.ValidateExportArtifact(artifact); err != nil {
 			return err
@@ -72,6 +166,106 @@ func (v *Validator) ValidateTestJobSpec(spec TestJobSpec) error {
 	return nil
 }
 
+// ValidateMaxObjects validates that the number of kubernetes objects planned from the static part of spec doesn't exceed maxObjects.
+// The tasks of the dynamic keys and the retries are checked by the runner when they are scheduled.
+func (v *Validator) ValidateMaxObjects(spec TestJobSpec) error {
+	if spec.MaxObjects < 0 {
+		return fmt.Errorf("kubetest: maxObjects must be zero ( unlimited ) or a positive number")
+	}
+	if spec.MaxObjects == 0 {
+		return nil
+	}
+	mainTaskNum := NewTaskScheduler(spec.MainStep).minTaskNum()
+	if objectNum := plannedObjectNum(spec, len(spec.PreSteps), mainTaskNum); objectNum > spec.MaxObjects {
+		return fmt.Errorf(
+			"kubetest: the run requires %d kubernetes objects ( Job and Pod ) at least but maxObjects is %d",
+			objectNum, spec.MaxObjects,
+		)
+	}
+	return nil
+}
+
+func (v *Validator) ValidateImagePrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if strings.Contains(prefix, "://") {
+		return fmt.Errorf("kubetest: imagePrefix must not contain scheme: %s", prefix)
+	}
+	if strings.TrimSuffix(prefix, "/") == "" || strings.ContainsAny(prefix, "@ ") {
+		return fmt.Errorf("kubetest: invalid imagePrefix: %q", prefix)
+	}
+	return nil
+}
+
+func (v *Validator) ValidateScratch(spec *ScratchSpec) error {
+	if spec == nil {
+		return nil
+	}
+	if spec.MountPath == "" {
+		return fmt.Errorf("kubetest: scratch.mountPath must be specified")
+	}
+	if err := v.ValidateContainerPath(spec.MountPath); err != nil {
+		return fmt.Errorf("kubetest: invalid scratch.mountPath: %w", err)
+	}
+	switch spec.Medium {
+	case corev1.StorageMediumDefault, corev1.StorageMediumMemory:
+	default:
+		return fmt.Errorf("kubetest: unsupported scratch.medium %q. medium must be empty or %s", spec.Medium, corev1.StorageMediumMemory)
+	}
+	if spec.SizeLimit != nil && spec.SizeLimit.Sign() <= 0 {
+		return fmt.Errorf("kubetest: scratch.sizeLimit must be greater than zero")
+	}
+	return nil
+}
+
+func (v *Validator) ValidateDebug(spec *DebugSpec) error {
+	if spec == nil || !spec.Enabled {
+		return nil
+	}
+	if spec.Image == "" {
+		return fmt.Errorf("kubetest: debug.image must be specified")
+	}
+	if spec.HoldDuration != "" {
+		duration, err := time.ParseDuration(spec.HoldDuration)
+		if err != nil {
+			return fmt.Errorf("kubetest: invalid debug.holdDuration: %w", err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("kubetest: debug.holdDuration must be greater than zero")
+		}
+	}
+	return nil
+}
+
+// ValidateHermetic validates the allowlist of the egress from the pods of the run.
+func (v *Validator) ValidateHermetic(spec *HermeticSpec) error {
+	if spec == nil || !spec.Enabled {
+		return nil
+	}
+	for _, cidr := range spec.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("kubetest: invalid hermetic.allowedCIDRs %q: %w", cidr, err)
+		}
+	}
+	for _, namespace := range spec.AllowedNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) != 0 {
+			return fmt.Errorf("kubetest: invalid namespace %q of hermetic.allowedNamespaces: %s", namespace, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// ValidateFixtures validates that every fixture is the object having apiVersion, kind and name.
+func (v *Validator) ValidateFixtures(fixtures []runtime.RawExtension) error {
+	for idx, fixture := range fixtures {
+		if _, err := decodeFixture(fixture); err != nil {
+			return fmt.Errorf("kubetest: invalid fixtures[%d]: %w", idx, err)
+		}
+	}
+	return nil
+}
+
 func (v *Validator) ValidateLog(spec LogSpec) error {
 	if spec.Level != LogLevelNone {
 		switch spec.Level {
@@ -87,6 +281,11 @@ func (v *Validator) ValidateToken(token TokenSpec) error {
 	if token.Name == "" {
 		return fmt.Errorf("kubetest: token name must be specified")
 	}
+	// the token is referenced by the name from the repositories and the volumes, and it is written to the file,
+	// so the name which can be confused with the path is rejected.
+	if token.Name == "." || token.Name == ".." || strings.ContainsAny(token.Name, `/\`) {
+		return fmt.Errorf("kubetest: invalid token name %q. token name must not be a path", token.Name)
+	}
 	var foundSource int
 	if token.Value.GitHubApp != nil {
 		foundSource++
@@ -144,6 +343,35 @@ func (v *Validator) ValidateFilePathTokenSource(source *string) error {
 	return nil
 }
 
+// ValidateIdempotencyKey validates the idempotency key set by Runner.SetIdempotencyKey and the policy.
+func (v *Validator) ValidateIdempotencyKey(key string, policy IdempotencyPolicy) error {
+	if errs := validation.IsValidLabelValue(key); len(errs) != 0 {
+		return fmt.Errorf("kubetest: invalid idempotency key %q: %s", key, strings.Join(errs, ", "))
+	}
+	switch policy {
+	case "", IdempotencyPolicyRefuse, IdempotencyPolicyAttach:
+	default:
+		return fmt.Errorf("kubetest: unknown idempotency policy %q", policy)
+	}
+	return nil
+}
+
+func (v *Validator) ValidateOwnerReference(ref metav1.OwnerReference) error {
+	if ref.APIVersion == "" {
+		return fmt.Errorf("kubetest: ownerReference.apiVersion must be specified")
+	}
+	if ref.Kind == "" {
+		return fmt.Errorf("kubetest: ownerReference.kind must be specified")
+	}
+	if ref.Name == "" {
+		return fmt.Errorf("kubetest: ownerReference.name must be specified")
+	}
+	if ref.UID == "" {
+		return fmt.Errorf("kubetest: ownerReference.uid must be specified")
+	}
+	return nil
+}
+
 func (v *Validator) ValidateRepositorySpec(spec RepositorySpec) error {
 	if spec.Name == "" {
 		return fmt.Errorf("kubetest: repository name must be specified")
@@ -155,6 +383,16 @@ func (v *Validator) ValidateRepositorySpec(spec RepositorySpec) error {
 }
 
 func (v *Validator) ValidateRepository(repo Repository) error {
+	for idx, cmd := range repo.PrepareCommands {
+		if len(cmd.Command) == 0 || cmd.Command[0] == "" {
+			return fmt.Errorf("kubetest: repository prepareCommands[%d].command must be specified", idx)
+		}
+	}
+	switch repo.ArchiveCompression {
+	case "", ArchiveCompressionGzip, ArchiveCompressionZstd, ArchiveCompressionNone:
+	default:
+		return fmt.Errorf("kubetest: unsupported repository archiveCompression %s. supported values are gzip, zstd and none", repo.ArchiveCompression)
+	}
 	if repo.ClonedPath != "" {
 		return nil
 	}
@@ -176,6 +414,9 @@ func (v *Validator) ValidatePreStep(prestep PreStep) error {
 	if prestep.Name == "" {
 		return fmt.Errorf("kubetest: prestep name must be specified")
 	}
+	if err := v.ValidateRunIfChanged(prestep.Name, prestep.RunIfChanged); err != nil {
+		return err
+	}
 	if err := v.ValidateTestJobTemplateSpec(prestep.Template, PreStepType); err != nil {
 		return err
 	}
@@ -183,12 +424,123 @@ func (v *Validator) ValidatePreStep(prestep PreStep) error {
 }
 
 func (v *Validator) ValidateMainStep(step MainStep) error {
+	if step.StopGracePeriod != "" {
+		period, err := time.ParseDuration(step.StopGracePeriod)
+		if err != nil {
+			return fmt.Errorf("kubetest: invalid mainStep.stopGracePeriod %s: %w", step.StopGracePeriod, err)
+		}
+		if period < 0 {
+			return fmt.Errorf("kubetest: mainStep.stopGracePeriod must be greater than or equal to zero")
+		}
+	}
+	for _, arg := range step.CommandWrapper {
+		if arg == "" {
+			return fmt.Errorf("kubetest: mainStep.commandWrapper must not contain empty argument")
+		}
+	}
+	if len(step.CommandWrapper) != 0 {
+		// the wrapper is prefixed to the command, so the entrypoint of the image would be replaced by the wrapper without it.
+		if mainContainer, err := getMainContainerFromTmpl(step.Template); err == nil && len(mainContainer.Command) == 0 {
+			return fmt.Errorf("kubetest: mainStep.commandWrapper requires the command of the main container %s", mainContainer.Name)
+		}
+	}
+	switch step.EmptyOutput {
+	case "", EmptyOutputPolicyAllow, EmptyOutputPolicyFail:
+	default:
+		return fmt.Errorf("kubetest: unknown mainStep.emptyOutput %q", step.EmptyOutput)
+	}
+	if err := v.ValidateRunIfChanged(MainStepType, step.RunIfChanged); err != nil {
+		return err
+	}
 	if err := v.ValidateStrategy(step.Strategy); err != nil {
 		return err
 	}
 	if err := v.ValidateTestJobTemplateSpec(step.Template, MainStepType); err != nil {
 		return err
 	}
+	if err := v.ValidateCoverage(step.Coverage); err != nil {
+		return err
+	}
+	if step.Strategy != nil {
+		for _, source := range dynamicKeySources(step.Strategy.Key.Source) {
+			if err := v.ValidateInheritVolumes(source.InheritVolumes, step.Template); err != nil {
+				return err
+			}
+		}
+	}
+	if step.Strategy != nil && step.Strategy.Scheduler.MaxCPU != nil {
+		if err := v.ValidateMaxCPU(*step.Strategy.Scheduler.MaxCPU, step.Template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateCoverage validates the coverage profile collected from the main container.
+// The name of the merged profile must not be used by the artifacts of the containers.
+func (v *Validator) ValidateCoverage(coverage *CoverageSpec) error {
+	if coverage == nil {
+		return nil
+	}
+	if coverage.Name == "" {
+		return fmt.Errorf("kubetest: mainStep.coverage.name must be specified")
+	}
+	if coverage.Path == "" {
+		return fmt.Errorf("kubetest: mainStep.coverage.path must be specified")
+	}
+	if err := v.ValidateContainerPath(coverage.Path); err != nil {
+		return fmt.Errorf("kubetest: invalid mainStep.coverage.path: %w", err)
+	}
+	if _, exists := v.artifactNameMap[coverage.Name]; exists {
+		return fmt.Errorf("kubetest: mainStep.coverage.name %s is already used by the artifact of the container", coverage.Name)
+	}
+	v.artifactNameMap[coverage.Name] = ArtifactSpec{Name: coverage.Name}
+	return nil
+}
+
+// ValidateRunIfChanged validates runIfChanged of the step. The changed paths are computed only if a repository has diffBase.
+func (v *Validator) ValidateRunIfChanged(stepName string, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if !v.hasDiffBase {
+		return fmt.Errorf("kubetest: runIfChanged of %s requires diffBase of the repository", stepName)
+	}
+	for _, p := range paths {
+		if p == "" {
+			return fmt.Errorf("kubetest: runIfChanged of %s must not contain empty path", stepName)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("kubetest: invalid runIfChanged %q of %s: %w", p, stepName, err)
+		}
+	}
+	return nil
+}
+
+func (v *Validator) ValidateMaxCPU(maxCPU resource.Quantity, mainTemplate TestJobTemplateSpec) error {
+	if maxCPU.Sign() <= 0 {
+		return fmt.Errorf("kubetest: strategy.scheduler.maxCPU must be a number greater than zero")
+	}
+	mainContainer, err := getMainContainerFromTmpl(mainTemplate)
+	if err != nil {
+		return err
+	}
+	if mainContainer.Resources.Requests.Cpu().IsZero() {
+		return fmt.Errorf("kubetest: main container must specify resources.requests.cpu to use strategy.scheduler.maxCPU")
+	}
+	return nil
+}
+
+func (v *Validator) ValidateInheritVolumes(names []string, mainTemplate TestJobTemplateSpec) error {
+	volumeNameMap := map[string]struct{}{}
+	for _, volume := range mainTemplate.Spec.Volumes {
+		volumeNameMap[volume.Name] = struct{}{}
+	}
+	for _, name := range names {
+		if _, exists := volumeNameMap[name]; !exists {
+			return fmt.Errorf("kubetest: strategy.key.source.dynamic.inheritVolumes %s is undefined in mainStep.template", name)
+		}
+	}
 	return nil
 }
 
@@ -196,6 +548,9 @@ func (v *Validator) ValidatePostStep(poststep PostStep) error {
 	if poststep.Name == "" {
 		return fmt.Errorf("kubetest: poststep name must be specified")
 	}
+	if err := v.ValidateRunIfChanged(poststep.Name, poststep.RunIfChanged); err != nil {
+		return err
+	}
 	if err := v.ValidateTestJobTemplateSpec(poststep.Template, PostStepType); err != nil {
 		return err
 	}
@@ -203,8 +558,11 @@ func (v *Validator) ValidatePostStep(poststep PostStep) error {
 }
 
 func (v *Validator) ValidateTestJobTemplateSpec(spec TestJobTemplateSpec, stepType StepType) error {
-	if len(spec.Spec.Containers) > 1 && spec.Main == "" {
-		return fmt.Errorf("kubetest: if specified multiple containers, must be specified template.main param for the main container")
+	if err := v.ValidateContainerRoles(spec); err != nil {
+		return err
+	}
+	if len(spec.Spec.Containers) > 1 && spec.Main == "" && !hasMainRoleContainer(spec) {
+		return fmt.Errorf("kubetest: if specified multiple containers, must be specified template.main param or role: main for the main container")
 	}
 	if err := v.ValidateTestJobPodSpec(spec.Spec, stepType); err != nil {
 		return err
@@ -212,6 +570,42 @@ func (v *Validator) ValidateTestJobTemplateSpec(spec TestJobTemplateSpec, stepTy
 	return nil
 }
 
+// ValidateContainerRoles validates that exactly one container of the template is the main container.
+func (v *Validator) ValidateContainerRoles(spec TestJobTemplateSpec) error {
+	mainNum := 0
+	for _, container := range spec.Spec.Containers {
+		switch container.Role {
+		case "", ContainerRoleSidecar, ContainerRolePost:
+			if spec.Main != "" && container.Name == spec.Main && container.Role != "" {
+				return fmt.Errorf("kubetest: main container %s cannot have role %s", container.Name, container.Role)
+			}
+		case ContainerRoleMain:
+			if spec.Main != "" && container.Name != spec.Main {
+				return fmt.Errorf("kubetest: container %s has role main but template.main is %s", container.Name, spec.Main)
+			}
+			mainNum++
+		default:
+			return fmt.Errorf("kubetest: unknown role %s of container %s", container.Role, container.Name)
+		}
+	}
+	if mainNum > 1 {
+		return fmt.Errorf("kubetest: only one container can have role main")
+	}
+	if spec.Main == "" && mainNum == 0 && len(spec.Spec.Containers) == 1 && spec.Spec.Containers[0].Role != "" {
+		return fmt.Errorf("kubetest: main container %s cannot have role %s", spec.Spec.Containers[0].Name, spec.Spec.Containers[0].Role)
+	}
+	return nil
+}
+
+func hasMainRoleContainer(spec TestJobTemplateSpec) bool {
+	for _, container := range spec.Spec.Containers {
+		if container.Role == ContainerRoleMain {
+			return true
+		}
+	}
+	return false
+}
+
 func (v *Validator) ValidateTestJobPodSpec(spec TestJobPodSpec, stepType StepType) error {
 	if len(spec.Containers) == 0 {
 		return fmt.Errorf("kubetest: template.spec.containers are must be specified")
@@ -231,6 +625,18 @@ func (v *Validator) ValidateTestJobPodSpec(spec TestJobPodSpec, stepType StepTyp
 			return err
 		}
 	}
+	if spec.Ulimits != nil && spec.Ulimits.NoFile != nil && *spec.Ulimits.NoFile <= 0 {
+		return fmt.Errorf("kubetest: ulimits.nofile must be greater than zero")
+	}
+	switch spec.FinalizerVerdictMode {
+	case "":
+	case FinalizerVerdictModeEnforce, FinalizerVerdictModeAdvisory:
+		if spec.FinalizerContainer.Name == "" {
+			return fmt.Errorf("kubetest: finalizerVerdictMode is specified but finalizerContainer isn't specified")
+		}
+	default:
+		return fmt.Errorf("kubetest: unknown finalizerVerdictMode %q", spec.FinalizerVerdictMode)
+	}
 	for _, volume := range spec.Volumes {
 		if err := v.ValidateTestJobVolume(volume, stepType); err != nil {
 			return err
@@ -250,10 +656,13 @@ func (v *Validator) ValidateTestJobPodSpec(spec TestJobPodSpec, stepType StepTyp
 		if !foundContainerName {
 			return fmt.Errorf("kubetest: template.spec.artifact.container.name %s is undefined", artifact.Container.Name)
 		}
-		if _, exists := v.artifactNameMap[artifact.Name]; exists {
-			return fmt.Errorf("kubetest: specified artifact name '%s' is duplicated", artifact.Name)
+		if declared, exists := v.artifactNameMap[artifact.Name]; exists {
+			if err := v.validateArtifactConflict(declared, artifact); err != nil {
+				return err
+			}
+			continue
 		}
-		v.artifactNameMap[artifact.Name] = struct{}{}
+		v.artifactNameMap[artifact.Name] = artifact
 	}
 	return nil
 }
@@ -265,12 +674,41 @@ func (v *Validator) ValidateTestJobContainer(container TestJobContainer) error {
 	if container.Image == "" {
 		return fmt.Errorf("kubetest: container's image must be specified")
 	}
+	for _, vm := range container.VolumeMounts {
+		if err := v.ValidateContainerPath(vm.MountPath); err != nil {
+			return fmt.Errorf("kubetest: invalid mountPath of %s in container %s: %w", vm.Name, container.Name, err)
+		}
+	}
 	if container.Agent != nil {
 		return v.ValidateTestAgentSpec(container.Agent)
 	}
 	return nil
 }
 
+// ValidateContainerPath validates the path in the container specified by user.
+// The path must be absolute and must not contain "..".
+// The system directories are rejected unless allowDangerousPaths is specified.
+func (v *Validator) ValidateContainerPath(p string) error {
+	if !path.IsAbs(p) {
+		return fmt.Errorf("kubetest: path %q must be absolute", p)
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." {
+			return fmt.Errorf("kubetest: path %q must not contain ..", p)
+		}
+	}
+	if v.allowDangerousPaths {
+		return nil
+	}
+	cleaned := path.Clean(p)
+	for _, dangerousPath := range dangerousPaths {
+		if cleaned == dangerousPath || (dangerousPath != "/" && strings.HasPrefix(cleaned, dangerousPath+"/")) {
+			return fmt.Errorf("kubetest: path %q is under the system directory %s. specify allowDangerousPaths to use it", p, dangerousPath)
+		}
+	}
+	return nil
+}
+
 func (v *Validator) ValidateTestAgentSpec(spec *TestAgentSpec) error {
 	if spec.InstalledPath == "" {
 		return fmt.Errorf("kubetest: agent.installedPath must be specified")
@@ -290,6 +728,34 @@ func (v *Validator) ValidateArtifactSpec(spec ArtifactSpec) error {
 	if err := v.ValidateArtifactContainer(spec.Container); err != nil {
 		return err
 	}
+	switch spec.Conflict {
+	case "", ArtifactConflictError, ArtifactConflictMerge, ArtifactConflictPerContainer:
+	default:
+		return fmt.Errorf("kubetest: template.spec.artifact.conflict %q is invalid", spec.Conflict)
+	}
+	if spec.Deduplicate && spec.Conflict == ArtifactConflictMerge {
+		return fmt.Errorf("kubetest: template.spec.artifact.deduplicate cannot be used with merge policy")
+	}
+	return nil
+}
+
+func (v *Validator) validateArtifactConflict(declared, artifact ArtifactSpec) error {
+	switch {
+	case declared.Conflict != artifact.Conflict:
+		return fmt.Errorf(
+			"kubetest: artifact '%s' has different conflict policies %q and %q",
+			artifact.Name, declared.Conflict, artifact.Conflict,
+		)
+	case declared.Deduplicate != artifact.Deduplicate:
+		return fmt.Errorf("kubetest: artifact '%s' has different deduplicate values", artifact.Name)
+	case artifact.Conflict == "" || artifact.Conflict == ArtifactConflictError:
+		return fmt.Errorf("kubetest: specified artifact name '%s' is duplicated", artifact.Name)
+	case filepath.Base(declared.Container.Path) != filepath.Base(artifact.Container.Path):
+		return fmt.Errorf(
+			"kubetest: artifact '%s' declared by multiple containers must have the same file name but got %s and %s",
+			artifact.Name, declared.Container.Path, artifact.Container.Path,
+		)
+	}
 	return nil
 }
 
@@ -300,6 +766,9 @@ func (v *Validator) ValidateArtifactContainer(container ArtifactContainer) error
 	if container.Path == "" {
 		return fmt.Errorf("kubetest: template.spec.artifact.container.path must be specified")
 	}
+	if err := v.ValidateContainerPath(container.Path); err != nil {
+		return fmt.Errorf("kubetest: invalid template.spec.artifact.container.path: %w", err)
+	}
 	return nil
 }
 
@@ -346,6 +815,9 @@ func (v *Validator) ValidateArtifactVolumeSource(source *ArtifactVolumeSource) e
 	if _, exists := v.artifactNameMap[source.Name]; !exists {
 		return fmt.Errorf("kubetest: artifact volume source name %s is undefined", source.Name)
 	}
+	if source.Mode != nil && (*source.Mode < 0 || *source.Mode > 0777) {
+		return fmt.Errorf("kubetest: mode of artifact volume source %s must be between 0 and 0777 but got %#o", source.Name, *source.Mode)
+	}
 	return nil
 }
 
@@ -388,6 +860,27 @@ func (v *Validator) ValidateStrategy(strategy *Strategy) error {
 	if err := v.ValidateScheduler(strategy.Scheduler); err != nil {
 		return err
 	}
+	if err := v.ValidateStrategySmokeSpec(strategy.Smoke); err != nil {
+		return err
+	}
+	if threshold := strategy.InternalErrorThreshold; threshold != nil && (*threshold < 0 || *threshold > 100) {
+		return fmt.Errorf("kubetest: strategy.internalErrorThreshold must be between 0 and 100 but got %d", *threshold)
+	}
+	return nil
+}
+
+func (v *Validator) ValidateStrategySmokeSpec(spec *StrategySmokeSpec) error {
+	if spec == nil {
+		return nil
+	}
+	if len(spec.Keys) == 0 && spec.Pattern == "" {
+		return fmt.Errorf("kubetest: strategy.smoke.keys or strategy.smoke.pattern must be specified")
+	}
+	if spec.Pattern != "" {
+		if _, err := regexp.Compile(spec.Pattern); err != nil {
+			return fmt.Errorf("kubetest: invalid strategy.smoke.pattern %s: %w", spec.Pattern, err)
+		}
+	}
 	return nil
 }
 
@@ -398,18 +891,56 @@ func (v *Validator) ValidateStrategyKeySpec(spec StrategyKeySpec) error {
 	if err := v.ValidateStrategyKeySource(spec.Source); err != nil {
 		return err
 	}
+	for key, image := range spec.Images {
+		if image == "" {
+			return fmt.Errorf("kubetest: image of strategy.key.images for %s must be specified", key)
+		}
+	}
+	for key, resources := range spec.Resources {
+		for name, quantity := range resources {
+			if quantity.Sign() < 0 {
+				return fmt.Errorf("kubetest: %s of strategy.key.resources for %s must not be negative", name, key)
+			}
+		}
+	}
+	switch spec.Duplicates {
+	case "", DuplicateKeyPolicyAllow, DuplicateKeyPolicyError, DuplicateKeyPolicySuffix:
+	default:
+		return fmt.Errorf("kubetest: unknown strategy.key.duplicates %s", spec.Duplicates)
+	}
+	for key, deps := range spec.After {
+		if key == "" {
+			return fmt.Errorf("kubetest: key of strategy.key.after must be specified")
+		}
+		for _, dep := range deps {
+			if dep == "" {
+				return fmt.Errorf("kubetest: strategy.key.after for %s must not contain empty key", key)
+			}
+		}
+	}
+	if cycle := keyOrderCycle(spec.After); len(cycle) != 0 {
+		return fmt.Errorf("kubetest: strategy.key.after has a cycle: %s", strings.Join(cycle, " -> "))
+	}
 	return nil
 }
 
 func (v *Validator) ValidateStrategyKeySource(source StrategyKeySource) error {
-	if len(source.Static) == 0 && source.Dynamic == nil {
+	if len(source.Static) == 0 && source.Dynamic == nil && len(source.Dynamics) == 0 {
 		return fmt.Errorf("kubetest: strategy.key.source.static or strategy.key.source.dynamic must be specified")
 	}
-	if len(source.Static) > 0 && source.Dynamic != nil {
-		return fmt.Errorf("kubetest: only one of strategy.key.source.static or strategy.key.source.dynamic needs to be specified")
+	// dynamics combines all sources, so union is not required.
+	if len(source.Dynamics) == 0 {
+		if source.Union && (len(source.Static) == 0 || source.Dynamic == nil) {
+			return fmt.Errorf("kubetest: strategy.key.source.union requires both strategy.key.source.static and strategy.key.source.dynamic")
+		}
+		if !source.Union && len(source.Static) > 0 && source.Dynamic != nil {
+			return fmt.Errorf("kubetest: only one of strategy.key.source.static or strategy.key.source.dynamic needs to be specified")
+		}
 	}
-	if source.Dynamic != nil {
-		return v.ValidateStrategyDynamicKeySource(source.Dynamic)
+	for _, dynamicSource := range dynamicKeySources(source) {
+		if err := v.ValidateStrategyDynamicKeySource(dynamicSource); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -418,6 +949,28 @@ func (v *Validator) ValidateStrategyDynamicKeySource(source *StrategyDynamicKeyS
 	if err := v.ValidateTestJobTemplateSpec(source.Template, MainStepType); err != nil {
 		return err
 	}
+	for _, container := range source.Template.Spec.Containers {
+		if container.Role == ContainerRolePost {
+			return fmt.Errorf("kubetest: container %s of strategy.key.source.dynamic.template cannot have role post", container.Name)
+		}
+	}
+	if source.Retries < 0 {
+		return fmt.Errorf("kubetest: strategy.key.source.dynamic.retries must be a number greater than or equal to zero")
+	}
+	switch source.Format {
+	case "", KeyFormatPlain, KeyFormatJSON:
+	default:
+		return fmt.Errorf("kubetest: unknown strategy.key.source.dynamic.format %s", source.Format)
+	}
+	if source.PendingTimeout != "" {
+		timeout, err := time.ParseDuration(source.PendingTimeout)
+		if err != nil {
+			return fmt.Errorf("kubetest: invalid strategy.key.source.dynamic.pendingTimeout %s: %w", source.PendingTimeout, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("kubetest: strategy.key.source.dynamic.pendingTimeout must be greater than zero")
+		}
+	}
 	return nil
 }
 
@@ -434,12 +987,83 @@ func (v *Validator) ValidateScheduler(scheduler Scheduler) error {
 	if scheduler.MaxContainersPerPod < 0 {
 		return fmt.Errorf("kubetest: strategy.scheduler.maxContainersPerPod must be a number greater than zero")
 	}
+	if scheduler.Rebalance && scheduler.MaxContainersPerPod == 0 {
+		return fmt.Errorf("kubetest: strategy.scheduler.rebalance requires maxContainersPerPod")
+	}
 	if scheduler.MaxConcurrentNumPerPod == 0 {
 		return fmt.Errorf("kubetest: strategy.scheduler.maxConcurrentNumPerPod must be specified")
 	}
 	if scheduler.MaxConcurrentNumPerPod < 0 {
 		return fmt.Errorf("kubetest: strategy.scheduler.ConcurrentNumPerPod must be a number greater than zero")
 	}
+	if err := v.ValidateSubTaskAllocation(scheduler.Allocation, scheduler.MaxConcurrentNumPerPod); err != nil {
+		return err
+	}
+	for name, quantity := range scheduler.MaxResourcesPerPod {
+		if quantity.Sign() <= 0 {
+			return fmt.Errorf("kubetest: %s of strategy.scheduler.maxResourcesPerPod must be greater than zero", name)
+		}
+	}
+	if scheduler.ShuffleSeed != nil && !scheduler.ShuffleKeys {
+		return fmt.Errorf("kubetest: strategy.scheduler.shuffleSeed requires strategy.scheduler.shuffleKeys")
+	}
+	if scheduler.SubTaskStartJitter != "" {
+		jitter, err := time.ParseDuration(scheduler.SubTaskStartJitter)
+		if err != nil {
+			return fmt.Errorf("kubetest: invalid strategy.scheduler.subTaskStartJitter %s: %w", scheduler.SubTaskStartJitter, err)
+		}
+		if jitter < 0 {
+			return fmt.Errorf("kubetest: strategy.scheduler.subTaskStartJitter must not be negative")
+		}
+	}
+	namespaceMap := map[string]struct{}{}
+	for _, namespace := range scheduler.Namespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) != 0 {
+			return fmt.Errorf("kubetest: invalid namespace %q of strategy.scheduler.namespaces: %s", namespace, strings.Join(errs, ", "))
+		}
+		if _, exists := namespaceMap[namespace]; exists {
+			return fmt.Errorf("kubetest: namespace %q of strategy.scheduler.namespaces is duplicated", namespace)
+		}
+		namespaceMap[namespace] = struct{}{}
+	}
+	return nil
+}
+
+func (v *Validator) ValidateSubTaskAllocation(allocation *SubTaskAllocation, maxConcurrentNumPerPod int) error {
+	if allocation == nil {
+		return nil
+	}
+	if allocation.Env == "" {
+		return fmt.Errorf("kubetest: strategy.scheduler.allocation.env must be specified")
+	}
+	if len(allocation.Values) == 0 && allocation.StartPort == 0 {
+		return fmt.Errorf("kubetest: strategy.scheduler.allocation's values or startPort must be specified")
+	}
+	if len(allocation.Values) != 0 && allocation.StartPort != 0 {
+		return fmt.Errorf("kubetest: strategy.scheduler.allocation's values and startPort cannot both be set")
+	}
+	if len(allocation.Values) != 0 && len(allocation.Values) < maxConcurrentNumPerPod {
+		return fmt.Errorf(
+			"kubetest: the number of strategy.scheduler.allocation.values must be greater than or equal to maxConcurrentNumPerPod(%d)",
+			maxConcurrentNumPerPod,
+		)
+	}
+	if allocation.StartPort < 0 || allocation.StartPort+maxConcurrentNumPerPod-1 > maxPortNumber {
+		return fmt.Errorf("kubetest: strategy.scheduler.allocation.startPort is out of range of port number")
+	}
+	return nil
+}
+
+// ValidateOutputArtifact validates the name of the artifact having the combined output of each task.
+// The name must not be used by the artifacts of the containers.
+func (v *Validator) ValidateOutputArtifact(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, exists := v.artifactNameMap[name]; exists {
+		return fmt.Errorf("kubetest: outputArtifact %s is already used by the artifact of the container", name)
+	}
+	v.artifactNameMap[name] = ArtifactSpec{Name: name}
 	return nil
 }
 
@@ -0,0 +1,194 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidateContainerPath(t *testing.T) {
+	for _, test := range []struct {
+		path  string
+		valid bool
+	}{
+		{path: "/work", valid: true},
+		{path: "/go/src/kubetest", valid: true},
+		{path: "/usrlocal", valid: true},
+		{path: "work", valid: false},
+		{path: "", valid: false},
+		{path: "/tmp/log/../../etc", valid: false},
+		{path: "/", valid: false},
+		{path: "/etc", valid: false},
+		{path: "/usr/local/bin/", valid: false},
+	} {
+		err := NewValidator().ValidateContainerPath(test.path)
+		if test.valid && err != nil {
+			t.Fatalf("expected %q is valid but got %v", test.path, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("expected %q is invalid", test.path)
+		}
+	}
+	t.Run("allowDangerousPaths", func(t *testing.T) {
+		spec := TestJobSpec{
+			AllowDangerousPaths: true,
+			MainStep: MainStep{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{{
+							Container: corev1.Container{
+								Name:         "test",
+								Image:        "alpine",
+								Command:      []string{"echo"},
+								VolumeMounts: []corev1.VolumeMount{{Name: "cache", MountPath: "/usr/local/cache"}},
+							},
+						}},
+					},
+				},
+			},
+		}
+		if err := NewValidator().ValidateTestJobSpec(spec); err != nil {
+			t.Fatal(err)
+		}
+		spec.AllowDangerousPaths = false
+		if err := NewValidator().ValidateTestJobSpec(spec); err == nil {
+			t.Fatal("expected error for dangerous path")
+		}
+		spec.AllowDangerousPaths = true
+		spec.MainStep.Template.Spec.Containers[0].VolumeMounts[0].MountPath = "/work/../etc"
+		if err := NewValidator().ValidateTestJobSpec(spec); err == nil {
+			t.Fatal("expected error for path traversal even if allowDangerousPaths is specified")
+		}
+	})
+}
+
+func TestValidateTokens(t *testing.T) {
+	token := func(name string) TokenSpec {
+		path := "/var/run/token"
+		return TokenSpec{Name: name, Value: TokenSource{FilePath: &path}}
+	}
+	newSpec := func(tokens []TokenSpec, volumeToken string) TestJobSpec {
+		return TestJobSpec{
+			Tokens: tokens,
+			MainStep: MainStep{
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{{
+							Container: corev1.Container{
+								Name:         "test",
+								Image:        "alpine",
+								Command:      []string{"echo"},
+								VolumeMounts: []corev1.VolumeMount{{Name: "token", MountPath: "/token"}},
+							},
+						}},
+						Volumes: []TestJobVolume{{
+							Name:                "token",
+							TestJobVolumeSource: TestJobVolumeSource{Token: &TokenVolumeSource{Name: volumeToken}},
+						}},
+					},
+				},
+			},
+		}
+	}
+	validator := NewValidator()
+	if err := validator.ValidateTestJobSpec(newSpec([]TokenSpec{token("github")}, "github")); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		spec     TestJobSpec
+		expected string
+	}{
+		{name: "duplicated", spec: newSpec([]TokenSpec{token("github"), token("github")}, "github"), expected: "token name 'github' is duplicated"},
+		{name: "unknown", spec: newSpec(nil, "github"), expected: "token volume source name github is undefined"},
+		{name: "path", spec: newSpec([]TokenSpec{token("../github")}, "../github"), expected: "token name must not be a path"},
+		{name: "dot", spec: newSpec([]TokenSpec{token("..")}, ".."), expected: "token name must not be a path"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// the tokens of the spec validated before must not be known by the other spec.
+			if err := validator.ValidateTestJobSpec(test.spec); err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected error %q but got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidateContainerRoles(t *testing.T) {
+	container := func(name string, role ContainerRole) TestJobContainer {
+		return TestJobContainer{Container: corev1.Container{Name: name, Image: "alpine", Command: []string{"true"}}, Role: role}
+	}
+	for _, test := range []struct {
+		name       string
+		main       string
+		containers []TestJobContainer
+		valid      bool
+	}{
+		{name: "role main", containers: []TestJobContainer{container("test", ContainerRoleMain), container("collector", ContainerRolePost)}, valid: true},
+		{name: "template.main", main: "test", containers: []TestJobContainer{container("test", ""), container("db", ContainerRoleSidecar)}, valid: true},
+		{name: "same main", main: "test", containers: []TestJobContainer{container("test", ContainerRoleMain), container("db", "")}, valid: true},
+		{name: "no main", containers: []TestJobContainer{container("test", ""), container("collector", ContainerRolePost)}, valid: false},
+		{name: "multiple main", containers: []TestJobContainer{container("test", ContainerRoleMain), container("test2", ContainerRoleMain)}, valid: false},
+		{name: "different main", main: "test", containers: []TestJobContainer{container("test", ""), container("test2", ContainerRoleMain)}, valid: false},
+		{name: "main is post", main: "test", containers: []TestJobContainer{container("test", ContainerRolePost)}, valid: false},
+		{name: "single post", containers: []TestJobContainer{container("test", ContainerRolePost)}, valid: false},
+		{name: "unknown role", containers: []TestJobContainer{container("test", "init")}, valid: false},
+	} {
+		spec := TestJobTemplateSpec{Main: test.main, Spec: TestJobPodSpec{Containers: test.containers}}
+		err := NewValidator().ValidateTestJobTemplateSpec(spec, MainStepType)
+		if test.valid && err != nil {
+			t.Fatalf("%s: expected valid but got %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%s: expected invalid", test.name)
+		}
+	}
+	source := &StrategyDynamicKeySource{
+		Template: TestJobTemplateSpec{
+			Spec: TestJobPodSpec{Containers: []TestJobContainer{container("list", ContainerRoleMain), container("collector", ContainerRolePost)}},
+		},
+	}
+	if err := NewValidator().ValidateStrategyDynamicKeySource(source); err == nil {
+		t.Fatal("expected post container in the listing template is invalid")
+	}
+}
+
+func TestValidateMaxObjects(t *testing.T) {
+	newSpec := func(maxObjects int, smoke *StrategySmokeSpec) TestJobSpec {
+		return TestJobSpec{
+			MaxObjects: maxObjects,
+			PreSteps:   []PreStep{{Name: "build"}},
+			MainStep: MainStep{
+				Strategy: &Strategy{
+					Key: StrategyKeySpec{
+						Env:    "TEST",
+						Source: StrategyKeySource{Static: []string{"A", "B", "C", "D"}},
+					},
+					Scheduler: Scheduler{MaxContainersPerPod: 2},
+					Smoke:     smoke,
+				},
+			},
+		}
+	}
+	for _, test := range []struct {
+		name  string
+		spec  TestJobSpec
+		valid bool
+	}{
+		{name: "unlimited", spec: newSpec(0, nil), valid: true},
+		{name: "negative", spec: newSpec(-1, nil), valid: false},
+		// 1 prestep and 2 tasks require 6 objects.
+		{name: "within limit", spec: newSpec(6, nil), valid: true},
+		{name: "exceeded", spec: newSpec(5, nil), valid: false},
+		// the smoke test and the other 3 tests are scheduled to 1 and 2 tasks.
+		{name: "smoke", spec: newSpec(6, &StrategySmokeSpec{Keys: []string{"A"}}), valid: false},
+	} {
+		err := NewValidator().ValidateMaxObjects(test.spec)
+		if test.valid && err != nil {
+			t.Fatalf("%s: expected valid but got %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%s: expected invalid", test.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// finalizerVerdictEnv environment variable of the finalizer container having the path to write the verdict.
+	finalizerVerdictEnv = "KUBETEST_VERDICT_PATH"
+)
+
+// finalizerVerdictPath path in the finalizer container to write the verdict.
+var finalizerVerdictPath = filepath.Join("/", "tmp", "kubetest-verdict.json")
+
+// VerdictStatus status of the verdict written by the finalizer container.
+type VerdictStatus string
+
+const (
+	VerdictStatusSuccess VerdictStatus = "success"
+	VerdictStatusFailure VerdictStatus = "failure"
+	VerdictStatusWarning VerdictStatus = "warning"
+)
+
+// finalizerVerdict verdict written by the finalizer container as JSON.
+type finalizerVerdict struct {
+	Status  VerdictStatus `json:"status"`
+	Message string        `json:"message"`
+}
+
+// readFinalizerVerdict copies the verdict from the finalizer container and decodes it.
+// If the finalizer didn't write the verdict, returns nil.
+func readFinalizerVerdict(ctx context.Context, finalizer JobExecutor) (*finalizerVerdict, error) {
+	dir, err := os.MkdirTemp("", "kubetest-verdict")
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to create directory to copy the verdict: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, filepath.Base(finalizerVerdictPath))
+	if err := finalizer.CopyFrom(ctx, finalizerVerdictPath, dst); err != nil {
+		if isNotExistCopyError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("kubetest: failed to copy the verdict of the finalizer: %w", err)
+	}
+	content, err := os.ReadFile(dst)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to read the verdict of the finalizer: %w", err)
+	}
+	var verdict finalizerVerdict
+	if err := json.Unmarshal(content, &verdict); err != nil {
+		return nil, fmt.Errorf("kubetest: failed to decode the verdict of the finalizer %q: %w", string(content), err)
+	}
+	switch verdict.Status {
+	case VerdictStatusSuccess, VerdictStatusFailure, VerdictStatusWarning:
+	default:
+		return nil, fmt.Errorf("kubetest: unknown status of the verdict of the finalizer: %q", verdict.Status)
+	}
+	return &verdict, nil
+}
+
+// applyFinalizerVerdict decides whether the finalizer failed by the verdict instead of the exit code.
+// In advisory mode, the verdict is only logged. If the verdict doesn't exist, runErr of the finalizer is returned.
+func applyFinalizerVerdict(ctx context.Context, mode FinalizerVerdictMode, verdict *finalizerVerdict, runErr error) error {
+	if verdict == nil {
+		return runErr
+	}
+	logger := LoggerFromContext(ctx)
+	if runErr != nil {
+		logger.Debug("the verdict of the finalizer is used instead of the error: %s", runErr)
+	}
+	switch verdict.Status {
+	case VerdictStatusSuccess:
+		logger.Info("finalizer verdict: success: %s", verdict.Message)
+	case VerdictStatusWarning:
+		logger.Warn("finalizer verdict: warning: %s", verdict.Message)
+	case VerdictStatusFailure:
+		if mode == FinalizerVerdictModeAdvisory {
+			logger.Warn("finalizer verdict: failure ( advisory ): %s", verdict.Message)
+			return nil
+		}
+		return fmt.Errorf("kubetest: finalizer verdict is failure: %s", verdict.Message)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func TestFinalizerVerdict(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(io.Discard, LogLevelDebug))
+	clientset, err := kubernetes.NewForConfig(getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runFinalizer := func(t *testing.T, mode FinalizerVerdictMode, command string) error {
+		t.Helper()
+		step := MainStep{
+			Template: TestJobTemplateSpec{
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{
+						{
+							Container: corev1.Container{
+								Name:    "test",
+								Image:   "alpine",
+								Command: []string{"echo", "-n", "test"},
+							},
+						},
+					},
+					FinalizerContainer: TestJobContainer{
+						Container: corev1.Container{
+							Name:    "finalizer",
+							Image:   "alpine",
+							Command: []string{"sh", "-c", command},
+						},
+					},
+					FinalizerVerdictMode: mode,
+				},
+			},
+		}
+		if err := NewValidator().ValidateMainStep(step); err != nil {
+			t.Fatal(err)
+		}
+		testjob := TestJob{ObjectMeta: testjobObjectMeta(), Spec: TestJobSpec{MainStep: step}}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeLocal)
+		task, err := builder.Build(ctx, &step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := task.Run(ctx)
+		if err != nil {
+			t.Fatalf("the failure of the finalizer must be recorded to the result: %v", err)
+		}
+		return finalizerFailure(t, result)
+	}
+	writeVerdict := func(status string) string {
+		return `echo '{"status":"` + status + `","message":"coverage is 79%"}' > "$KUBETEST_VERDICT_PATH"; exit 1`
+	}
+	t.Run("enforce", func(t *testing.T) {
+		if err := runFinalizer(t, FinalizerVerdictModeEnforce, writeVerdict("warning")); err != nil {
+			t.Fatalf("warning verdict must pass the task even if the finalizer failed: %v", err)
+		}
+		if err := runFinalizer(t, FinalizerVerdictModeEnforce, writeVerdict("failure")); err == nil || !strings.Contains(err.Error(), "coverage is 79%") {
+			t.Fatalf("expected error by the failure verdict but got %v", err)
+		}
+	})
+	t.Run("advisory", func(t *testing.T) {
+		if err := runFinalizer(t, FinalizerVerdictModeAdvisory, writeVerdict("failure")); err != nil {
+			t.Fatalf("advisory verdict must not fail the task: %v", err)
+		}
+	})
+	t.Run("without verdict", func(t *testing.T) {
+		if err := runFinalizer(t, FinalizerVerdictModeAdvisory, "exit 1"); err == nil {
+			t.Fatal("expected error by the exit code of the finalizer")
+		}
+		if err := runFinalizer(t, FinalizerVerdictModeEnforce, "exit 0"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("invalid verdict", func(t *testing.T) {
+		if err := runFinalizer(t, FinalizerVerdictModeEnforce, writeVerdict("unknown")); err == nil || !strings.Contains(err.Error(), "unknown status") {
+			t.Fatalf("expected error for invalid verdict but got %v", err)
+		}
+	})
+	t.Run("kubernetes", func(t *testing.T) {
+		step := MainStep{
+			Template: TestJobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"},
+				Spec: TestJobPodSpec{
+					Containers: []TestJobContainer{
+						{Container: corev1.Container{Name: "test", Image: "alpine", Command: []string{"echo", "-n", "test"}}},
+					},
+					FinalizerContainer: TestJobContainer{
+						Container: corev1.Container{Name: "finalizer", Image: "alpine", Command: []string{"true"}},
+					},
+					FinalizerVerdictMode: FinalizerVerdictModeEnforce,
+				},
+			},
+		}
+		testjob := TestJob{ObjectMeta: testjobObjectMeta(), Spec: TestJobSpec{MainStep: step}}
+		builder := NewTaskBuilder(getConfig(), NewResourceManager(clientset, testjob), "default", RunModeKubernetes)
+		task, err := builder.Build(ctx, &step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec := task.job.Spec()
+		task.job = &swallowFinalizerErrorJob{
+			spec:      spec,
+			execs:     []JobExecutor{&fakeJobExecutor{container: spec.Template.Spec.Containers[0]}},
+			finalizer: &verdictJobExecutor{container: step.Template.Spec.FinalizerContainer.Container, verdict: `{"status":"failure","message":"coverage is 79%"}`},
+		}
+		result, err := task.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := finalizerFailure(t, result); err == nil || !strings.Contains(err.Error(), "coverage is 79%") {
+			t.Fatalf("expected error by the failure verdict but got %v", err)
+		}
+		var names []string
+		for _, detail := range result.toReportDetails() {
+			names = append(names, fmt.Sprintf("%s:%s:%s", detail.Name, detail.Status, detail.Phase))
+		}
+		if strings.Join(names, ",") != "test:success:main,mainStep/finalizer:failure:finalizer" {
+			t.Fatalf("unexpected details: %v", names)
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		spec := TestJobPodSpec{
+			Containers:           []TestJobContainer{{Container: corev1.Container{Name: "test", Image: "alpine"}}},
+			FinalizerVerdictMode: FinalizerVerdictModeEnforce,
+		}
+		if err := NewValidator().ValidateTestJobPodSpec(spec, MainStepType); err == nil {
+			t.Fatal("expected error for verdict mode without finalizer")
+		}
+		spec.FinalizerContainer = TestJobContainer{Container: corev1.Container{Name: "finalizer", Image: "alpine"}}
+		spec.FinalizerVerdictMode = "strict"
+		if err := NewValidator().ValidateTestJobPodSpec(spec, MainStepType); err == nil {
+			t.Fatal("expected error for unknown verdict mode")
+		}
+	})
+}
+
+// finalizerFailure returns the error of the finalizer recorded to result. The results of the tests must be kept with it.
+func finalizerFailure(t *testing.T, result *TaskResult) error {
+	t.Helper()
+	var (
+		err     error
+		testNum int
+	)
+	for _, subTaskResult := range result.MainTaskResults() {
+		if subTaskResult.Phase == ContainerPhaseFinalizer {
+			err = subTaskResult.Err
+			continue
+		}
+		testNum++
+	}
+	if testNum == 0 {
+		t.Fatal("the results of the tests must be kept")
+	}
+	return err
+}
+
+// swallowFinalizerErrorJob runs the finalizer after the handler and ignores its error as kubejob does.
+type swallowFinalizerErrorJob struct {
+	spec      batchv1.JobSpec
+	execs     []JobExecutor
+	finalizer JobExecutor
+}
+
+func (j *swallowFinalizerErrorJob) Spec() batchv1.JobSpec                                { return j.spec }
+func (j *swallowFinalizerErrorJob) PreInit(TestJobContainer, PreInitCallback)            {}
+func (j *swallowFinalizerErrorJob) Mount(func(context.Context, JobExecutor, bool) error) {}
+func (j *swallowFinalizerErrorJob) RunWithExecutionHandler(ctx context.Context, handler func(context.Context, []JobExecutor) error, finalizer func(context.Context, JobExecutor) error) error {
+	if err := handler(ctx, j.execs); err != nil {
+		return err
+	}
+	// kubejob runs the finalizer with the new context, and kubernetesJob sets the logger of the run to it.
+	_ = finalizer(WithLogger(context.Background(), LoggerFromContext(ctx)), j.finalizer)
+	return nil
+}
+
+// verdictJobExecutor finalizer executor which wrote verdict.
+type verdictJobExecutor struct {
+	fakeJobExecutor
+	container corev1.Container
+	verdict   string
+}
+
+func (e *verdictJobExecutor) Container() corev1.Container { return e.container }
+func (e *verdictJobExecutor) CopyFrom(_ context.Context, _ string, dst string) error {
+	return os.WriteFile(dst, []byte(e.verdict), 0644)
+}
@@ -0,0 +1,83 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// workDirEnv environment variable to specify the directory to create the local files of the run.
+// It is used when the directory isn't specified by Runner.SetWorkDir.
+const workDirEnv = "KUBETEST_WORKDIR"
+
+var (
+	// minWorkDirFreeSpace the free space required for the files of the run whose sizes can't be known before starting it
+	// ( e.g. the log, the reports and the artifacts ). The sizes of the repositories cloned by the run are checked after cloning them.
+	minWorkDirFreeSpace uint64 = 64 * 1024 * 1024
+
+	errDiskFreeUnsupported = errors.New("kubetest: getting free space of the disk is unsupported on this platform")
+)
+
+// resolveWorkDir returns the directory to create the local files of the run.
+// The priority is dir, KUBETEST_WORKDIR environment variable and the default directory for temporary files.
+func resolveWorkDir(dir string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv(workDirEnv)
+	}
+	if dir == "" {
+		return os.TempDir(), nil
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to get absolute path of working directory %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return "", fmt.Errorf("kubetest: failed to create working directory %s: %w", absDir, err)
+	}
+	return absDir, nil
+}
+
+// expectedWorkDirSpace returns the space of the working directory expected to be used by testjob.
+// The repositories already cloned to clonedPath are archived in the working directory, so their sizes are added to minWorkDirFreeSpace.
+// The repositories having the prepare commands are also copied to the working directory, so their sizes are added twice.
+func expectedWorkDirSpace(testjob TestJob) (uint64, error) {
+	required := minWorkDirFreeSpace
+	for _, repo := range testjob.Spec.Repos {
+		dir := repo.Value.ClonedPath
+		if dir == "" || !existsDir(dir) {
+			continue
+		}
+		size, err := localSize(dir)
+		if err != nil {
+			return 0, fmt.Errorf("kubetest: failed to get size of %s repository: %w", repo.Name, err)
+		}
+		required += uint64(size)
+		if len(repo.Value.PrepareCommands) != 0 {
+			required += uint64(size)
+		}
+	}
+	return required, nil
+}
+
+// checkFreeSpace returns the error if dir doesn't have the required free space.
+// If the free space can't be got on the platform, the check is skipped.
+func checkFreeSpace(dir string, required uint64) error {
+	free, err := diskFree(dir)
+	if err != nil {
+		if errors.Is(err, errDiskFreeUnsupported) {
+			return nil
+		}
+		return fmt.Errorf("kubetest: failed to get free space of %s: %w", dir, err)
+	}
+	if free < required {
+		return fmt.Errorf(
+			"kubetest: not enough space in working directory %s: required %s but available %s. specify the other directory by %s",
+			dir, formatBytes(int64(required)), formatBytes(int64(free)), workDirEnv,
+		)
+	}
+	return nil
+}
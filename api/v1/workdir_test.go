@@ -0,0 +1,105 @@
+package v1
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+func TestResolveWorkDir(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv(workDirEnv, "")
+		dir, err := resolveWorkDir("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir != os.TempDir() {
+			t.Fatalf("expected %s but got %s", os.TempDir(), dir)
+		}
+	})
+	t.Run("env", func(t *testing.T) {
+		expected := filepath.Join(t.TempDir(), "env")
+		t.Setenv(workDirEnv, expected)
+		dir, err := resolveWorkDir("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir != expected {
+			t.Fatalf("expected %s but got %s", expected, dir)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			t.Fatalf("failed to create working directory: %v", err)
+		}
+	})
+	t.Run("option is prior to env", func(t *testing.T) {
+		t.Setenv(workDirEnv, filepath.Join(t.TempDir(), "env"))
+		expected := filepath.Join(t.TempDir(), "option")
+		dir, err := resolveWorkDir(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir != expected {
+			t.Fatalf("expected %s but got %s", expected, dir)
+		}
+	})
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkFreeSpace(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diskFree(dir); err == errDiskFreeUnsupported {
+		t.Skip("getting free space is unsupported")
+	}
+	err := checkFreeSpace(dir, math.MaxInt64)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), dir) || !strings.Contains(err.Error(), workDirEnv) {
+		t.Fatalf("unexpected error message: %v", err)
+	}
+}
+
+func TestExpectedWorkDirSpace(t *testing.T) {
+	clonedPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(clonedPath, "main.go"), make([]byte, 1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	testjob := TestJob{
+		Spec: TestJobSpec{
+			Repos: []RepositorySpec{
+				{Name: "cloned", Value: Repository{URL: "https://github.com/goccy/kubetest.git", ClonedPath: clonedPath}},
+				{Name: "not cloned", Value: Repository{URL: "https://github.com/goccy/kubetest.git", ClonedPath: filepath.Join(clonedPath, "not-exists")}},
+				{Name: "clone", Value: Repository{URL: "https://github.com/goccy/kubetest.git"}},
+				{Name: "prepared", Value: Repository{ClonedPath: clonedPath, PrepareCommands: []RepositoryCommand{{Command: []string{"make"}}}}},
+			},
+		},
+	}
+	required, err := expectedWorkDirSpace(testjob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := minWorkDirFreeSpace + 1024 + 2*1024; required != expected {
+		t.Fatalf("expected %d bytes but got %d", expected, required)
+	}
+}
+
+func TestJobBuilderWorkDir(t *testing.T) {
+	workDir := t.TempDir()
+	builder := NewJobBuilder(nil, "default", RunModeLocal)
+	builder.SetWorkDir(workDir)
+	job, err := builder.BuildWithJob(&batchv1.Job{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootDir := job.(*localJob).rootDir
+	defer os.RemoveAll(rootDir)
+	if filepath.Dir(rootDir) != workDir {
+		t.Fatalf("expected root directory is created in %s but got %s", workDir, rootDir)
+	}
+}
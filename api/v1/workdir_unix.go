@@ -0,0 +1,15 @@
+//go:build !ignore_autogenerated && !windows
+// +build !ignore_autogenerated,!windows
+
+package v1
+
+import "syscall"
+
+// diskFree returns the free space available to the unprivileged user in the filesystem containing dir.
+func diskFree(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bsize) * stat.Bavail, nil
+}
@@ -0,0 +1,8 @@
+//go:build !ignore_autogenerated && windows
+// +build !ignore_autogenerated,windows
+
+package v1
+
+func diskFree(dir string) (uint64, error) {
+	return 0, errDiskFreeUnsupported
+}
@@ -43,6 +43,11 @@ func (in *ArtifactSpec) DeepCopy() *ArtifactSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ArtifactVolumeSource) DeepCopyInto(out *ArtifactVolumeSource) {
 	*out = *in
+	if in.Mode != nil {
+		in, out := &in.Mode, &out.Mode
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactVolumeSource.
@@ -55,6 +60,41 @@ func (in *ArtifactVolumeSource) DeepCopy() *ArtifactVolumeSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CoverageSpec) DeepCopyInto(out *CoverageSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CoverageSpec.
+func (in *CoverageSpec) DeepCopy() *CoverageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CoverageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DebugSpec) DeepCopyInto(out *DebugSpec) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DebugSpec.
+func (in *DebugSpec) DeepCopy() *DebugSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DebugSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExportArtifact) DeepCopyInto(out *ExportArtifact) {
 	*out = *in
@@ -111,6 +151,31 @@ func (in *GitHubTokenSource) DeepCopy() *GitHubTokenSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HermeticSpec) DeepCopyInto(out *HermeticSpec) {
+	*out = *in
+	if in.AllowedCIDRs != nil {
+		in, out := &in.AllowedCIDRs, &out.AllowedCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HermeticSpec.
+func (in *HermeticSpec) DeepCopy() *HermeticSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HermeticSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LogSpec) DeepCopyInto(out *LogSpec) {
 	*out = *in
@@ -157,6 +222,26 @@ func (in *MainStep) DeepCopyInto(out *MainStep) {
 		(*in).DeepCopyInto(*out)
 	}
 	in.Template.DeepCopyInto(&out.Template)
+	if in.OnFailureCommand != nil {
+		in, out := &in.OnFailureCommand, &out.OnFailureCommand
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CommandWrapper != nil {
+		in, out := &in.CommandWrapper, &out.CommandWrapper
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Coverage != nil {
+		in, out := &in.Coverage, &out.Coverage
+		*out = new(CoverageSpec)
+		**out = **in
+	}
+	if in.RunIfChanged != nil {
+		in, out := &in.RunIfChanged, &out.RunIfChanged
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MainStep.
@@ -188,6 +273,11 @@ func (in *MergeSpec) DeepCopy() *MergeSpec {
 func (in *PostStep) DeepCopyInto(out *PostStep) {
 	*out = *in
 	in.Template.DeepCopyInto(&out.Template)
+	if in.RunIfChanged != nil {
+		in, out := &in.RunIfChanged, &out.RunIfChanged
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PostStep.
@@ -204,6 +294,11 @@ func (in *PostStep) DeepCopy() *PostStep {
 func (in *PreStep) DeepCopyInto(out *PreStep) {
 	*out = *in
 	in.Template.DeepCopyInto(&out.Template)
+	if in.RunIfChanged != nil {
+		in, out := &in.RunIfChanged, &out.RunIfChanged
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreStep.
@@ -227,7 +322,7 @@ func (in *Report) DeepCopyInto(out *Report) {
 			if (*in)[i] != nil {
 				in, out := &(*in)[i], &(*out)[i]
 				*out = new(ReportDetail)
-				**out = **in
+				(*in).DeepCopyInto(*out)
 			}
 		}
 	}
@@ -238,6 +333,81 @@ func (in *Report) DeepCopyInto(out *Report) {
 			(*out)[key] = val
 		}
 	}
+	if in.Objects != nil {
+		in, out := &in.Objects, &out.Objects
+		*out = make([]ReportObject, len(*in))
+		copy(*out, *in)
+	}
+	if in.ShardBalance != nil {
+		in, out := &in.ShardBalance, &out.ShardBalance
+		*out = new(ReportShardBalance)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ShuffleSeed != nil {
+		in, out := &in.ShuffleSeed, &out.ShuffleSeed
+		*out = new(int64)
+		**out = **in
+	}
+	if in.FirstFailureAt != nil {
+		in, out := &in.FirstFailureAt, &out.FirstFailureAt
+		*out = (*in).DeepCopy()
+	}
+	if in.CircuitBreaker != nil {
+		in, out := &in.CircuitBreaker, &out.CircuitBreaker
+		*out = new(ReportCircuitBreaker)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Artifacts != nil {
+		in, out := &in.Artifacts, &out.Artifacts
+		*out = make([]ReportArtifact, len(*in))
+		copy(*out, *in)
+	}
+	if in.PreSteps != nil {
+		in, out := &in.PreSteps, &out.PreSteps
+		*out = make([]ReportPreStep, len(*in))
+		copy(*out, *in)
+	}
+	if in.SkippedSteps != nil {
+		in, out := &in.SkippedSteps, &out.SkippedSteps
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.APIRequests != nil {
+		in, out := &in.APIRequests, &out.APIRequests
+		*out = new(ReportAPIRequests)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Repositories != nil {
+		in, out := &in.Repositories, &out.Repositories
+		*out = make([]ReportRepository, len(*in))
+		copy(*out, *in)
+	}
+	if in.AuxiliaryDetails != nil {
+		in, out := &in.AuxiliaryDetails, &out.AuxiliaryDetails
+		*out = make([]*ReportDetail, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(ReportDetail)
+				(*in).DeepCopyInto(*out)
+			}
+		}
+	}
+	if in.Finalizers != nil {
+		in, out := &in.Finalizers, &out.Finalizers
+		*out = make([]ReportFinalizer, len(*in))
+		copy(*out, *in)
+	}
+	if in.Baseline != nil {
+		in, out := &in.Baseline, &out.Baseline
+		*out = new(ReportBaseline)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Tasks != nil {
+		in, out := &in.Tasks, &out.Tasks
+		*out = make([]ReportTask, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Report.
@@ -250,9 +420,121 @@ func (in *Report) DeepCopy() *Report {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportAPIRequestCount) DeepCopyInto(out *ReportAPIRequestCount) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportAPIRequestCount.
+func (in *ReportAPIRequestCount) DeepCopy() *ReportAPIRequestCount {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportAPIRequestCount)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportAPIRequests) DeepCopyInto(out *ReportAPIRequests) {
+	*out = *in
+	if in.Requests != nil {
+		in, out := &in.Requests, &out.Requests
+		*out = make([]ReportAPIRequestCount, len(*in))
+		copy(*out, *in)
+	}
+	if in.Tasks != nil {
+		in, out := &in.Tasks, &out.Tasks
+		*out = make([]ReportTaskAPIRequests, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportAPIRequests.
+func (in *ReportAPIRequests) DeepCopy() *ReportAPIRequests {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportAPIRequests)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportArtifact) DeepCopyInto(out *ReportArtifact) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportArtifact.
+func (in *ReportArtifact) DeepCopy() *ReportArtifact {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportArtifact)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportBaseline) DeepCopyInto(out *ReportBaseline) {
+	*out = *in
+	if in.NewFailures != nil {
+		in, out := &in.NewFailures, &out.NewFailures
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.KnownFailures != nil {
+		in, out := &in.KnownFailures, &out.KnownFailures
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Improvements != nil {
+		in, out := &in.Improvements, &out.Improvements
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportBaseline.
+func (in *ReportBaseline) DeepCopy() *ReportBaseline {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportBaseline)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportCircuitBreaker) DeepCopyInto(out *ReportCircuitBreaker) {
+	*out = *in
+	if in.Reasons != nil {
+		in, out := &in.Reasons, &out.Reasons
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportCircuitBreaker.
+func (in *ReportCircuitBreaker) DeepCopy() *ReportCircuitBreaker {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportCircuitBreaker)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ReportDetail) DeepCopyInto(out *ReportDetail) {
 	*out = *in
+	if in.Metadata != nil {
+		in, out := &in.Metadata, &out.Metadata
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportDetail.
@@ -265,6 +547,116 @@ func (in *ReportDetail) DeepCopy() *ReportDetail {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportFinalizer) DeepCopyInto(out *ReportFinalizer) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportFinalizer.
+func (in *ReportFinalizer) DeepCopy() *ReportFinalizer {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportFinalizer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportObject) DeepCopyInto(out *ReportObject) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportObject.
+func (in *ReportObject) DeepCopy() *ReportObject {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportObject)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportPreStep) DeepCopyInto(out *ReportPreStep) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportPreStep.
+func (in *ReportPreStep) DeepCopy() *ReportPreStep {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportPreStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportRepository) DeepCopyInto(out *ReportRepository) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportRepository.
+func (in *ReportRepository) DeepCopy() *ReportRepository {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportRepository)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportShard) DeepCopyInto(out *ReportShard) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportShard.
+func (in *ReportShard) DeepCopy() *ReportShard {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportShard)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportShardBalance) DeepCopyInto(out *ReportShardBalance) {
+	*out = *in
+	if in.Shards != nil {
+		in, out := &in.Shards, &out.Shards
+		*out = make([]ReportShard, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportShardBalance.
+func (in *ReportShardBalance) DeepCopy() *ReportShardBalance {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportShardBalance)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportTask) DeepCopyInto(out *ReportTask) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportTask.
+func (in *ReportTask) DeepCopy() *ReportTask {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportTask)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ReportVolumeSource) DeepCopyInto(out *ReportVolumeSource) {
 	*out = *in
@@ -280,6 +672,21 @@ func (in *ReportVolumeSource) DeepCopy() *ReportVolumeSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportTaskAPIRequests) DeepCopyInto(out *ReportTaskAPIRequests) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportTaskAPIRequests.
+func (in *ReportTaskAPIRequests) DeepCopy() *ReportTaskAPIRequests {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportTaskAPIRequests)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Repository) DeepCopyInto(out *Repository) {
 	*out = *in
@@ -288,6 +695,13 @@ func (in *Repository) DeepCopyInto(out *Repository) {
 		*out = new(MergeSpec)
 		**out = **in
 	}
+	if in.PrepareCommands != nil {
+		in, out := &in.PrepareCommands, &out.PrepareCommands
+		*out = make([]RepositoryCommand, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Repository.
@@ -300,6 +714,26 @@ func (in *Repository) DeepCopy() *Repository {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepositoryCommand) DeepCopyInto(out *RepositoryCommand) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositoryCommand.
+func (in *RepositoryCommand) DeepCopy() *RepositoryCommand {
+	if in == nil {
+		return nil
+	}
+	out := new(RepositoryCommand)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RepositorySpec) DeepCopyInto(out *RepositorySpec) {
 	*out = *in
@@ -334,6 +768,38 @@ func (in *RepositoryVolumeSource) DeepCopy() *RepositoryVolumeSource {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Scheduler) DeepCopyInto(out *Scheduler) {
 	*out = *in
+	if in.Allocation != nil {
+		in, out := &in.Allocation, &out.Allocation
+		*out = new(SubTaskAllocation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AntiAffinity != nil {
+		in, out := &in.AntiAffinity, &out.AntiAffinity
+		*out = new(ShardAntiAffinity)
+		**out = **in
+	}
+	if in.MaxCPU != nil {
+		in, out := &in.MaxCPU, &out.MaxCPU
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.MaxResourcesPerPod != nil {
+		in, out := &in.MaxResourcesPerPod, &out.MaxResourcesPerPod
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.ShuffleSeed != nil {
+		in, out := &in.ShuffleSeed, &out.ShuffleSeed
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Scheduler.
@@ -346,11 +812,41 @@ func (in *Scheduler) DeepCopy() *Scheduler {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScratchSpec) DeepCopyInto(out *ScratchSpec) {
+	*out = *in
+	if in.SizeLimit != nil {
+		in, out := &in.SizeLimit, &out.SizeLimit
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScratchSpec.
+func (in *ScratchSpec) DeepCopy() *ScratchSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScratchSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Strategy) DeepCopyInto(out *Strategy) {
 	*out = *in
 	in.Key.DeepCopyInto(&out.Key)
-	out.Scheduler = in.Scheduler
+	in.Scheduler.DeepCopyInto(&out.Scheduler)
+	if in.Smoke != nil {
+		in, out := &in.Smoke, &out.Smoke
+		*out = new(StrategySmokeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InternalErrorThreshold != nil {
+		in, out := &in.InternalErrorThreshold, &out.InternalErrorThreshold
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Strategy.
@@ -363,10 +859,40 @@ func (in *Strategy) DeepCopy() *Strategy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategySmokeSpec) DeepCopyInto(out *StrategySmokeSpec) {
+	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategySmokeSpec.
+func (in *StrategySmokeSpec) DeepCopy() *StrategySmokeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategySmokeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StrategyDynamicKeySource) DeepCopyInto(out *StrategyDynamicKeySource) {
 	*out = *in
+	if in.TTLSecondsAfterFinished != nil {
+		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
+		*out = new(int32)
+		**out = **in
+	}
 	in.Template.DeepCopyInto(&out.Template)
+	if in.InheritVolumes != nil {
+		in, out := &in.InheritVolumes, &out.InheritVolumes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyDynamicKeySource.
@@ -392,6 +918,13 @@ func (in *StrategyKeySource) DeepCopyInto(out *StrategyKeySource) {
 		*out = new(StrategyDynamicKeySource)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Dynamics != nil {
+		in, out := &in.Dynamics, &out.Dynamics
+		*out = make([]StrategyDynamicKeySource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyKeySource.
@@ -408,6 +941,35 @@ func (in *StrategyKeySource) DeepCopy() *StrategyKeySource {
 func (in *StrategyKeySpec) DeepCopyInto(out *StrategyKeySpec) {
 	*out = *in
 	in.Source.DeepCopyInto(&out.Source)
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make(map[string]corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.After != nil {
+		in, out := &in.After, &out.After
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyKeySpec.
@@ -420,6 +982,26 @@ func (in *StrategyKeySpec) DeepCopy() *StrategyKeySpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubTaskAllocation) DeepCopyInto(out *SubTaskAllocation) {
+	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubTaskAllocation.
+func (in *SubTaskAllocation) DeepCopy() *SubTaskAllocation {
+	if in == nil {
+		return nil
+	}
+	out := new(SubTaskAllocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TestAgentSpec) DeepCopyInto(out *TestAgentSpec) {
 	*out = *in
@@ -525,6 +1107,22 @@ func (in *TestJobList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TestJobOverlay) DeepCopyInto(out *TestJobOverlay) {
+	*out = *in
+	in.Patch.DeepCopyInto(&out.Patch)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestJobOverlay.
+func (in *TestJobOverlay) DeepCopy() *TestJobOverlay {
+	if in == nil {
+		return nil
+	}
+	out := new(TestJobOverlay)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TestJobPodSpec) DeepCopyInto(out *TestJobPodSpec) {
 	*out = *in
@@ -555,6 +1153,11 @@ func (in *TestJobPodSpec) DeepCopyInto(out *TestJobPodSpec) {
 		*out = make([]ArtifactSpec, len(*in))
 		copy(*out, *in)
 	}
+	if in.Ulimits != nil {
+		in, out := &in.Ulimits, &out.Ulimits
+		*out = new(UlimitSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestJobPodSpec.
@@ -605,6 +1208,42 @@ func (in *TestJobSpec) DeepCopyInto(out *TestJobSpec) {
 		copy(*out, *in)
 	}
 	in.Log.DeepCopyInto(&out.Log)
+	if in.EnvFrom != nil {
+		in, out := &in.EnvFrom, &out.EnvFrom
+		*out = make([]corev1.EnvFromSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Scratch != nil {
+		in, out := &in.Scratch, &out.Scratch
+		*out = new(ScratchSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Debug != nil {
+		in, out := &in.Debug, &out.Debug
+		*out = new(DebugSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Hermetic != nil {
+		in, out := &in.Hermetic, &out.Hermetic
+		*out = new(HermeticSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fixtures != nil {
+		in, out := &in.Fixtures, &out.Fixtures
+		*out = make([]runtime.RawExtension, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Overlays != nil {
+		in, out := &in.Overlays, &out.Overlays
+		*out = make([]TestJobOverlay, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestJobSpec.
@@ -677,7 +1316,7 @@ func (in *TestJobVolumeSource) DeepCopyInto(out *TestJobVolumeSource) {
 	if in.Artifact != nil {
 		in, out := &in.Artifact, &out.Artifact
 		*out = new(ArtifactVolumeSource)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Token != nil {
 		in, out := &in.Token, &out.Token
@@ -766,3 +1405,23 @@ func (in *TokenVolumeSource) DeepCopy() *TokenVolumeSource {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UlimitSpec) DeepCopyInto(out *UlimitSpec) {
+	*out = *in
+	if in.NoFile != nil {
+		in, out := &in.NoFile, &out.NoFile
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UlimitSpec.
+func (in *UlimitSpec) DeepCopy() *UlimitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UlimitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
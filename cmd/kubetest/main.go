@@ -4,31 +4,63 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"text/template"
+	"time"
 
 	kubetestv1 "github.com/goccy/kubetest/api/v1"
 	"github.com/jessevdk/go-flags"
-	"k8s.io/apimachinery/pkg/util/yaml"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 type option struct {
-	Namespace string            `description:"specify namespace" short:"n" long:"namespace" default:"default"`
+	Namespace string            `description:"specify namespace of the testjob without namespace. ( default: namespace of the context, or default in cluster )" short:"n" long:"namespace"`
 	InCluster bool              `description:"specify whether in cluster" long:"in-cluster"`
-	Config    string            `description:"specify local kubeconfig path. ( default: $HOME/.kube/config )" short:"c" long:"config"`
+	Config    string            `description:"specify local kubeconfig path. ( default: $KUBECONFIG or $HOME/.kube/config )" short:"c" long:"config"`
+	Context   string            `description:"specify context of kubeconfig. the testjob without namespace runs in the namespace of the context ( default: current context )" long:"context"`
 	List      string            `description:"specify path to get the list for test" long:"list"`
+	ListCmd   string            `description:"specify command to get dynamic keys used instead of the command in the testjob. the command is run by sh -c" long:"list-command"`
 	LogLevel  string            `description:"specify log level (debug/info/warn/error)" long:"log-level"`
+	LogJSONL  string            `description:"specify path to write log in JSON Lines format in addition to console" long:"log-jsonl"`
+	LogFlush  time.Duration     `description:"specify interval to write the console log in batches ( e.g. 1s ) so that log collectors don't drop lines of massively parallel runs. ( default: write immediately )" long:"log-flush-interval"`
+	LogLines  string            `description:"specify maximum number of the last lines of the output of each test written to the log. the limit of the failed test can be specified separately ( e.g. 200,2000 ). the report and the artifacts have the whole output ( default: all lines )" long:"max-log-lines-per-key"`
 	DryRun    bool              `description:"specify dry run mode" long:"dry-run"`
 	Template  map[string]string `description:"specify template parameter for testjob file" long:"template"`
+	Overlay   string            `description:"specify name of the overlay in the testjob to merge over the spec" long:"overlay"`
 	Output    string            `description:"specify output path of report" short:"o" long:"output"`
+	Indent    int               `description:"specify number of spaces to indent the reports written to the files ( the output path and report.json ). ( default: single line )" long:"output-indent"`
+	Failures  string            `description:"specify path to write the output of all failed tests grouped by testjob and test name" long:"failures-log"`
+	Plan      string            `description:"specify path to save the plan of testjob instead of running it. if the plan of the last run exists, print the diff against it" long:"plan"`
+	PlanDiff  string            `description:"specify path to write the diff against the plan of the last run as JSON. all steps are added if the last plan doesn't exist" long:"plan-diff-output"`
+	SkipPre   bool              `description:"skip running presteps to reuse the artifacts exported by the previous run" long:"skip-presteps"`
+	Artifacts map[string]string `description:"specify path to the existing artifact used instead of running presteps or the presteps skipped by runIfChanged ( name:path )" long:"artifact"`
+	WorkDir   string            `description:"specify directory to create local files of the run. ( default: $KUBETEST_WORKDIR or temporary directory )" long:"workdir"`
+	SkipImage bool              `description:"skip verifying images even if verifyImages is enabled" long:"skip-image-verification"`
+	Baseline  string            `description:"specify path to the report of the baseline run ( e.g. the last green run ) to print the diff against it" long:"baseline"`
+	Diff      string            `description:"specify path to write the diff against the baseline report in Markdown format. ( default: stderr )" long:"diff-output"`
+	Compare   bool              `description:"fail the run only if some tests newly failed compared to the baseline report. the tests failed in the baseline are tolerated" long:"compare-baseline"`
+	Manifests string            `description:"specify directory to write the manifests of all Jobs submitted by the run" long:"manifest-dir"`
+	Partial   string            `description:"specify directory to write the report of each task as soon as it finishes. the reports remain even if the run crashes" long:"partial-report-dir"`
+	Masking   bool              `description:"fail the run if a credential ( a token or a secret referenced by env ) isn't masked in the log, or the masks of the logger can't be checked" long:"strict-masking"`
+	Summary   bool              `description:"write the summary line of each shard when it finishes" long:"shard-summary"`
+	Timeout   time.Duration     `description:"specify timeout of each request to kubernetes API ( e.g. 60s ). ( default: no timeout )" long:"client-timeout"`
+	QPS       float32           `description:"specify queries per second of kubernetes client. large runs should raise it ( e.g. 50 ). ( default: 5 )" long:"client-qps"`
+	Burst     int               `description:"specify burst of kubernetes client. large runs should raise it ( e.g. 100 ). ( default: 10 )" long:"client-burst"`
+	PreInit   time.Duration     `description:"specify time to wait until the container copying the repositories and the artifacts to the pod is ready. 0 copies without waiting" long:"preinit-ready-timeout" default:"1m"`
+	ClockSkew bool              `description:"offset the timestamps of the report by the skew of the clock of the API server so that they can be compared with the time of the cluster" long:"adjust-clock-skew"`
+	IdemKey   string            `description:"specify key to avoid the duplicate runs of the same testjob ( e.g. the build id of CI ). the run is refused or attached if the run having the same key is still running in the namespaces of the testjob" long:"idempotency-key"`
+	IdemMode  string            `description:"specify what to do when the run having the same idempotency key is running (refuse/attach). attach waits until the run finishes and reports the status published by it to the ConfigMap kubetest-run-<run id>" long:"idempotency-policy" default:"refuse"`
 }
 
 const (
@@ -39,23 +71,29 @@ const (
 	ExitWithSignal             = 4
 )
 
-func loadConfig(opt option) (*rest.Config, error) {
+// loadConfig returns the config and the namespace used for the testjob without namespace.
+// The namespace specified by the option takes precedence over the namespace of the context.
+func loadConfig(opt option) (*rest.Config, string, error) {
 	if opt.InCluster {
 		cfg, err := rest.InClusterConfig()
 		if err != nil {
-			return nil, fmt.Errorf("kubetest: failed to load config in cluster: %w", err)
+			return nil, "", fmt.Errorf("kubetest: failed to load config in cluster: %w", err)
 		}
-		return cfg, nil
-	}
-	p := opt.Config
-	if p == "" {
-		p = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+		namespace := opt.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		return cfg, namespace, nil
 	}
-	cfg, err := clientcmd.BuildConfigFromFlags("", p)
+	// the kubeconfig is loaded by the default rules ( KUBECONFIG environment variable or $HOME/.kube/config ) unless the path is specified.
+	cfg, namespace, err := kubetestv1.ConfigFromKubeconfig(opt.Config, opt.Context)
 	if err != nil {
-		return nil, fmt.Errorf("kubetest: failed to load config from %s: %w", p, err)
+		return nil, "", err
 	}
-	return cfg, nil
+	if opt.Namespace != "" {
+		namespace = opt.Namespace
+	}
+	return cfg, namespace, nil
 }
 
 func assignStaticKeys(job *kubetestv1.TestJob, opt option) error {
@@ -80,19 +118,158 @@ func assignStaticKeys(job *kubetestv1.TestJob, opt option) error {
 	return job.SetStaticStrategyKeys(staticKeys)
 }
 
-func _main(args []string, opt option) (*kubetestv1.Report, error) {
+// savePlan prints the diff against the plan of the last run and replaces it by the plan of job.
+// The plan of the last run is kept if the diff fails.
+func savePlan(job kubetestv1.TestJob, opt option) error {
+	plan := kubetestv1.NewRunPlan(job)
+	lastPlan := &kubetestv1.RunPlan{}
+	f, err := os.Open(opt.Plan)
+	switch {
+	case err == nil:
+		lastPlan, err = kubetestv1.ReadRunPlan(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("kubetest: failed to open plan file %s: %w", opt.Plan, err)
+	}
+	diff := kubetestv1.DiffPlan(lastPlan, plan)
+	if len(lastPlan.Steps) != 0 && diff.HasChanges() {
+		fmt.Fprintf(os.Stdout, "kubetest: the plan is changed from the last run\n%s\n", diff)
+	}
+	if opt.PlanDiff != "" {
+		if err := writeFileAtomically(opt.PlanDiff, diff.Write); err != nil {
+			return fmt.Errorf("kubetest: failed to write plan diff to %s: %w", opt.PlanDiff, err)
+		}
+	}
+	if err := writeFileAtomically(opt.Plan, plan.Write); err != nil {
+		return fmt.Errorf("kubetest: failed to write plan file %s: %w", opt.Plan, err)
+	}
+	return nil
+}
+
+// writeFileAtomically writes the file by renaming the temporary file written by write, so that the file isn't truncated by the failure.
+func writeFileAtomically(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func writeReportDiff(report *kubetestv1.Report, opt option) error {
+	if opt.Baseline == "" {
+		return nil
+	}
+	f, err := os.Open(opt.Baseline)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to open baseline report %s: %w", opt.Baseline, err)
+	}
+	defer f.Close()
+	baseline, err := kubetestv1.ReadReport(f)
+	if err != nil {
+		return err
+	}
+	diff := kubetestv1.DiffReports(baseline, report).Markdown()
+	if opt.Diff == "" {
+		fmt.Fprint(os.Stderr, diff)
+		return nil
+	}
+	if err := os.WriteFile(opt.Diff, []byte(diff), 0644); err != nil {
+		return fmt.Errorf("kubetest: failed to write diff to %s: %w", opt.Diff, err)
+	}
+	return nil
+}
+
+func logLevel(level string) (kubetestv1.LogLevel, bool) {
+	switch level {
+	case "debug":
+		return kubetestv1.LogLevelDebug, true
+	case "", "info":
+		return kubetestv1.LogLevelInfo, true
+	case "warn":
+		return kubetestv1.LogLevelWarn, true
+	case "error":
+		return kubetestv1.LogLevelError, true
+	}
+	return kubetestv1.LogLevelNone, false
+}
+
+// maxLogLines returns the maximum number of lines of the output of the passed test and the failed test.
+// If only one number is specified, it is used for both.
+func maxLogLines(opt option) (int, int, error) {
+	if opt.LogLines == "" {
+		return 0, 0, nil
+	}
+	values := strings.Split(opt.LogLines, ",")
+	if len(values) > 2 {
+		return 0, 0, fmt.Errorf("kubetest: invalid --max-log-lines-per-key %q. specify <lines> or <success lines>,<failure lines>", opt.LogLines)
+	}
+	lines := make([]int, 0, len(values))
+	for _, value := range values {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("kubetest: invalid --max-log-lines-per-key %q. the number of lines must be zero or positive", opt.LogLines)
+		}
+		lines = append(lines, n)
+	}
+	if len(lines) == 1 {
+		return lines[0], lines[0], nil
+	}
+	return lines[0], lines[1], nil
+}
+
+func readTestJobFile(path string) ([]byte, error) {
+	switch {
+	case path == "-":
+		file, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to read testjob from stdin: %w", err)
+		}
+		return file, nil
+	case strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://"):
+		resp, err := http.Get(path)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to get testjob from %s: %w", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("kubetest: failed to get testjob from %s: %s", path, resp.Status)
+		}
+		file, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to read testjob from %s: %w", path, err)
+		}
+		return file, nil
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to open %s: %w", path, err)
+	}
+	return file, nil
+}
+
+func _main(args []string, opt option) ([]*kubetestv1.Report, error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("unspecified testjob file path")
 	}
 	path := args[0]
-	cfg, err := loadConfig(opt)
+	cfg, namespace, err := loadConfig(opt)
 	if err != nil {
 		return nil, err
 	}
-	var job kubetestv1.TestJob
-	file, err := os.ReadFile(path)
+	file, err := readTestJobFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("kubetest: failed to open %s: %w", path, err)
+		return nil, err
 	}
 	f, err := template.New("").Parse(string(file))
 	if err != nil {
@@ -102,10 +279,37 @@ func _main(args []string, opt option) (*kubetestv1.Report, error) {
 	if err := f.Execute(&b, opt.Template); err != nil {
 		return nil, fmt.Errorf("kubetest: failed to execute template %s: %w", string(file), err)
 	}
-	if err := yaml.NewYAMLOrJSONDecoder(&b, 1024).Decode(&job); err != nil {
-		return nil, fmt.Errorf("kubetest: failed to decode YAML: %w", err)
+	jobs, err := kubetestv1.LoadTestJobs(&b)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range jobs {
+		if opt.Overlay != "" {
+			resolved, err := jobs[idx].ApplyOverlay(opt.Overlay)
+			if err != nil {
+				return nil, fmt.Errorf("kubetest: failed to resolve testjob %s: %w", jobs[idx].Name, err)
+			}
+			jobs[idx] = *resolved
+		}
+		if jobs[idx].Namespace == "" {
+			jobs[idx].Namespace = namespace
+		}
+		if err := assignStaticKeys(&jobs[idx], opt); err != nil {
+			return nil, err
+		}
+	}
+	if len(jobs) > 1 && opt.Plan != "" {
+		return nil, fmt.Errorf("kubetest: --plan option cannot be used with multiple testjobs")
+	}
+	if len(jobs) > 1 && opt.Baseline != "" {
+		return nil, fmt.Errorf("kubetest: --baseline option cannot be used with multiple testjobs")
 	}
-	if err := assignStaticKeys(&job, opt); err != nil {
+	if opt.Plan != "" {
+		// only the plan is saved without running the testjob.
+		return nil, savePlan(jobs[0], opt)
+	}
+	successLines, failureLines, err := maxLogLines(opt)
+	if err != nil {
 		return nil, err
 	}
 	runMode := kubetestv1.RunModeKubernetes
@@ -113,16 +317,50 @@ func _main(args []string, opt option) (*kubetestv1.Report, error) {
 		runMode = kubetestv1.RunModeDryRun
 	}
 	runner := kubetestv1.NewRunner(cfg, runMode)
-	switch opt.LogLevel {
-	case "debug":
-		runner.SetLogger(kubetestv1.NewLogger(os.Stdout, kubetestv1.LogLevelDebug))
-	case "", "info":
-		runner.SetLogger(kubetestv1.NewLogger(os.Stdout, kubetestv1.LogLevelInfo))
-	case "warn":
-		runner.SetLogger(kubetestv1.NewLogger(os.Stdout, kubetestv1.LogLevelWarn))
-	case "error":
-		runner.SetLogger(kubetestv1.NewLogger(os.Stdout, kubetestv1.LogLevelError))
-	default:
+	if opt.Compare {
+		if opt.Baseline == "" {
+			return nil, fmt.Errorf("kubetest: --compare-baseline requires --baseline")
+		}
+		runner.SetCompareBaseline(opt.Baseline)
+	}
+	runner.SetDrainOnSignal(true)
+	runner.SetSkipPreSteps(opt.SkipPre)
+	runner.SetFailuresLogPath(opt.Failures)
+	runner.SetWorkDir(opt.WorkDir)
+	runner.SetSkipImageVerification(opt.SkipImage)
+	runner.SetManifestDir(opt.Manifests)
+	runner.SetPartialReportDir(opt.Partial)
+	if opt.ListCmd != "" {
+		// run by the shell so that the quoted arguments and the pipes are interpreted as written.
+		runner.SetListCommand([]string{"sh", "-c", opt.ListCmd})
+	}
+	runner.SetStrictMasking(opt.Masking)
+	runner.SetShardSummary(opt.Summary)
+	runner.SetResultIndent(reportIndent(opt))
+	runner.SetClientTimeout(opt.Timeout)
+	runner.SetClientRateLimit(opt.QPS, opt.Burst)
+	runner.SetMaxLogLinesPerKey(successLines, failureLines)
+	runner.SetClockSkewAdjustment(opt.ClockSkew)
+	runner.SetPreInitReadyTimeout(opt.PreInit)
+	if opt.IdemKey != "" {
+		runner.SetIdempotencyKey(opt.IdemKey, kubetestv1.IdempotencyPolicy(opt.IdemMode))
+	}
+	for name, path := range opt.Artifacts {
+		runner.SetExistingArtifactPath(name, path)
+	}
+	if level, exists := logLevel(opt.LogLevel); exists {
+		sinks := []kubetestv1.LogSink{
+			{Out: os.Stdout, Level: level, Format: kubetestv1.LogFormatText, FlushInterval: opt.LogFlush},
+		}
+		if opt.LogJSONL != "" {
+			f, err := os.Create(opt.LogJSONL)
+			if err != nil {
+				return nil, fmt.Errorf("kubetest: failed to create log file %s: %w", opt.LogJSONL, err)
+			}
+			defer f.Close()
+			sinks = append(sinks, kubetestv1.LogSink{Out: f, Level: level, Format: kubetestv1.LogFormatJSONL})
+		}
+		runner.SetLogger(kubetestv1.NewLoggerWithSinks(sinks...))
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	interrupt := make(chan os.Signal, 1)
@@ -138,15 +376,16 @@ func _main(args []string, opt option) (*kubetestv1.Report, error) {
 		}
 	}()
 
-	report, err := runner.Run(ctx, job)
+	reports, err := runner.RunTestJobs(ctx, jobs)
 	if err != nil {
 		if canceledBySignal {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(ExitWithSignal)
 		}
-		return nil, err
+		// the reports of the finished testjobs are returned to diagnose the error ( e.g. the output of the failed prestep ).
+		return reports, err
 	}
-	return report, nil
+	return reports, nil
 }
 
 func parseOpt() ([]string, option, error) {
@@ -156,6 +395,41 @@ func parseOpt() ([]string, option, error) {
 	return args, opt, err
 }
 
+// reportIndent returns the indent of the reports written to the files. If the indent isn't specified, returns empty string.
+func reportIndent(opt option) string {
+	if opt.Indent <= 0 {
+		return ""
+	}
+	return strings.Repeat(" ", opt.Indent)
+}
+
+// writeReports prints the reports and writes them to the output path if specified.
+func writeReports(reports []*kubetestv1.Report, opt option) error {
+	// keep the output format of a single testjob.
+	var output interface{} = reports
+	if len(reports) == 1 {
+		output = reports[0]
+	}
+	b, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(b))
+	if opt.Output != "" {
+		b, err := json.Marshal(output)
+		if indent := reportIndent(opt); indent != "" {
+			b, err = json.MarshalIndent(output, "", indent)
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(opt.Output, b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func fatalError(err error) {
 	fmt.Fprintln(os.Stderr, err)
 	fmt.Fprintln(os.Stderr, "kubetest: fatal error")
@@ -175,25 +449,27 @@ func main() {
 		}
 		os.Exit(ExitWithOtherError)
 	}
-	report, err := _main(args, opt)
+	reports, err := _main(args, opt)
+	if err == nil && opt.Plan != "" {
+		return
+	}
 	if err != nil {
+		if len(reports) != 0 {
+			if err := writeReports(reports, opt); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
 		fatalError(err)
 	}
-	b, err := json.MarshalIndent(report, "", "  ")
-	if err != nil {
+	if err := writeReports(reports, opt); err != nil {
 		fatalError(err)
 	}
-	fmt.Fprintln(os.Stdout, string(b))
-	if opt.Output != "" {
-		b, err := json.Marshal(report)
-		if err != nil {
-			fatalError(err)
-		}
-		if err := os.WriteFile(opt.Output, b, 0644); err != nil {
+	if len(reports) == 1 {
+		if err := writeReportDiff(reports[0], opt); err != nil {
 			fatalError(err)
 		}
 	}
-	if report.Status != kubetestv1.ResultStatusSuccess {
+	if kubetestv1.CombinedStatus(reports) != kubetestv1.ResultStatusSuccess {
 		os.Exit(ExitWithFailureTestJob)
 	}
 }
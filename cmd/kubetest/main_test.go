@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	kubetestv1 "github.com/goccy/kubetest/api/v1"
 	"github.com/jessevdk/go-flags"
+	corev1 "k8s.io/api/core/v1"
 )
 
 func TestHelpOpt(t *testing.T) {
@@ -84,3 +87,115 @@ func TestListOpt(t *testing.T) {
 		}
 	})
 }
+
+func TestSavePlan(t *testing.T) {
+	dir := t.TempDir()
+	opt := option{
+		Plan:     filepath.Join(dir, "plan.json"),
+		PlanDiff: filepath.Join(dir, "diff.json"),
+	}
+	newTestJob := func(image string) kubetestv1.TestJob {
+		return kubetestv1.TestJob{
+			Spec: kubetestv1.TestJobSpec{
+				MainStep: kubetestv1.MainStep{
+					Template: kubetestv1.TestJobTemplateSpec{
+						Spec: kubetestv1.TestJobPodSpec{
+							Containers: []kubetestv1.TestJobContainer{
+								{Container: corev1.Container{Name: "test", Image: image}},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	readDiff := func(t *testing.T) kubetestv1.PlanDiff {
+		t.Helper()
+		b, err := os.ReadFile(opt.PlanDiff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var diff kubetestv1.PlanDiff
+		if err := json.Unmarshal(b, &diff); err != nil {
+			t.Fatal(err)
+		}
+		return diff
+	}
+	t.Run("first plan", func(t *testing.T) {
+		if err := savePlan(newTestJob("golang:1.18"), opt); err != nil {
+			t.Fatal(err)
+		}
+		diff := readDiff(t)
+		if len(diff.Changes) != 1 || diff.Changes[0].Type != kubetestv1.PlanChangeAdded || diff.Changes[0].Path != "mainStep" {
+			t.Fatalf("unexpected diff: %s", diff.String())
+		}
+	})
+	t.Run("changed plan", func(t *testing.T) {
+		if err := savePlan(newTestJob("golang:1.19"), opt); err != nil {
+			t.Fatal(err)
+		}
+		diff := readDiff(t)
+		if len(diff.Changes) != 1 || diff.Changes[0].Field != "image" || diff.Changes[0].Old != "golang:1.18" || diff.Changes[0].New != "golang:1.19" {
+			t.Fatalf("unexpected diff: %s", diff.String())
+		}
+	})
+	t.Run("broken last plan is kept", func(t *testing.T) {
+		if err := os.WriteFile(opt.Plan, []byte("{"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := savePlan(newTestJob("golang:1.20"), opt); err == nil {
+			t.Fatal("expected error")
+		}
+		b, err := os.ReadFile(opt.Plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "{" {
+			t.Fatalf("the last plan is overwritten: %q", b)
+		}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(`
+apiVersion: v1
+kind: Config
+current-context: ci
+clusters:
+- name: ci
+  cluster:
+    server: https://ci.example.com
+contexts:
+- name: ci
+  context:
+    cluster: ci
+    user: user
+    namespace: ci
+users:
+- name: user
+  user:
+    token: token
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", path)
+	t.Run("KUBECONFIG", func(t *testing.T) {
+		cfg, namespace, err := loadConfig(option{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Host != "https://ci.example.com" || namespace != "ci" {
+			t.Fatalf("the kubeconfig must be loaded from KUBECONFIG: host %s namespace %s", cfg.Host, namespace)
+		}
+	})
+	t.Run("explicit namespace", func(t *testing.T) {
+		_, namespace, err := loadConfig(option{Namespace: "default"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if namespace != "default" {
+			t.Fatalf("the namespace of the option must take precedence over the context: %s", namespace)
+		}
+	})
+}